@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+// correlationExampleKey is the published key of the spec's test vectors. It
+// lets another service check its implementation without the real key.
+const correlationExampleKey = "ihv1-example-key-not-for-production-use"
+
+var correlationExampleIdentities = []correlationEntry{
+	{Kind: audit.CorrelationTenant, Value: "acme"},
+	{Kind: audit.CorrelationSession, Value: "session-123"},
+	{Kind: audit.CorrelationUser, Value: "user@example.com"},
+}
+
+// correlationEntry is one identifier to hash and, optionally, the correlation
+// ID another service computed for it.
+type correlationEntry struct {
+	Kind          string `json:"kind"`
+	Value         string `json:"value,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+type correlationResult struct {
+	Line          int    `json:"line"`
+	Kind          string `json:"kind"`
+	CorrelationID string `json:"correlation_id"`
+	Match         *bool  `json:"match,omitempty"`
+}
+
+type correlationVectors struct {
+	Version string             `json:"version"`
+	Key     string             `json:"key"`
+	Vectors []correlationEntry `json:"vectors"`
+}
+
+// runAuditCorrelationID implements "gateway-api audit correlation-id". It
+// reads {"kind","value","correlation_id"} JSON lines on stdin and writes the
+// correlation ID of each value under GATEWAY_AUDIT_CORRELATION_KEY, without
+// echoing the value. When an entry carries the correlation_id another service
+// computed, the result reports whether it matches; the command exits 1 when
+// any does not. -vectors prints the spec's test vectors instead. Usage,
+// input and configuration errors exit 2.
+func runAuditCorrelationID(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("audit correlation-id", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	vectors := flags.Bool("vectors", false, "print the test vectors computed with the published example key")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintln(stderr, "usage: gateway-api audit correlation-id [-vectors] < entries.jsonl")
+		return 2
+	}
+	encoder := json.NewEncoder(stdout)
+	if *vectors {
+		out := correlationVectors{Version: audit.CorrelationVersion, Key: correlationExampleKey}
+		for _, entry := range correlationExampleIdentities {
+			entry.CorrelationID = audit.CorrelationID([]byte(correlationExampleKey), entry.Kind, entry.Value)
+			out.Vectors = append(out.Vectors, entry)
+		}
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(out)
+		return 0
+	}
+
+	key, err := audit.CorrelationKey()
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid audit configuration: %v\n", err)
+		return 2
+	}
+	if key == nil {
+		fmt.Fprintln(stderr, "GATEWAY_AUDIT_CORRELATION_KEY is not set")
+		return 2
+	}
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	mismatched := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry correlationEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Value == "" {
+			fmt.Fprintf(stderr, "line %d: expected {\"kind\",\"value\",\"correlation_id\"}\n", line)
+			return 2
+		}
+		if !slices.Contains(audit.CorrelationKinds, entry.Kind) {
+			fmt.Fprintf(stderr, "line %d: kind must be one of %v\n", line, audit.CorrelationKinds)
+			return 2
+		}
+		result := correlationResult{Line: line, Kind: entry.Kind, CorrelationID: audit.CorrelationID(key, entry.Kind, entry.Value)}
+		if entry.CorrelationID != "" {
+			match := entry.CorrelationID == result.CorrelationID
+			result.Match = &match
+			if !match {
+				mismatched++
+			}
+		}
+		_ = encoder.Encode(result)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(stderr, "read failed: %v\n", err)
+		return 2
+	}
+	if mismatched > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/gateway"
+)
+
+// checkReport is printed by "gateway-api --check".
+type checkReport struct {
+	OK        bool                         `json:"ok"`
+	Errors    []string                     `json:"errors,omitempty"`
+	Providers gateway.ProviderHealthReport `json:"providers"`
+}
+
+// runCheck implements "gateway-api --check". It runs the startup
+// configuration checks and the OAuth provider checks without starting any
+// server, prints a JSON report and exits 1 when anything fails.
+func runCheck(stdout io.Writer) int {
+	var errs []string
+	if _, err := validateServiceURL("ORCHESTRATOR_URL", "http://127.0.0.1:4000"); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid ORCHESTRATOR_URL: %v", err))
+	}
+	if _, err := validateServiceURL("INDEXER_URL", "http://127.0.0.1:7071"); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid INDEXER_URL: %v", err))
+	}
+	if _, err := gateway.ParseTrustedProxyCIDRs(trustedProxyCIDRsFromEnv()); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid trusted proxy configuration: %v", err))
+	}
+	if err := gateway.ValidateHealthCheckConfig(); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid health check configuration: %v", err))
+	}
+	if err := validateStateCookieConfig(allowInsecureStateCookieFromEnv()); err != nil {
+		errs = append(errs, fmt.Sprintf("oauth state cookie configuration invalid: %v", err))
+	}
+
+	report := checkReport{Errors: errs, Providers: gateway.CheckProviders()}
+	report.OK = len(errs) == 0 && report.Providers.OK()
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
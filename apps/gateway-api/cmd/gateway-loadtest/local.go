@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/gatewaytest"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/fakeidp"
+)
+
+const (
+	localProvider = "oidc"
+	localClientID = "gateway-loadtest"
+	// localRateLimit stands in for "unlimited": the rate limits treat zero
+	// as unset and fall back to their defaults.
+	localRateLimit = 1_000_000_000
+)
+
+// localGateway is an in-process gateway together with the fake identity
+// provider it signs in against.
+type localGateway struct {
+	gateway *gatewaytest.Gateway
+	idp     *fakeidp.Server
+}
+
+// startLocalGateway starts a gateway whose OIDC provider is a fake identity
+// provider and whose fake orchestrator streams cfg.events events for
+// cfg.planID and redeems callback codes at the provider.
+//
+// Per-IP limits and authorize de-duplication are relaxed because the load
+// generator sends everything from one address; left on they would measure
+// the protections instead of the paths behind them.
+func startLocalGateway(cfg config, stderr io.Writer) (*localGateway, error) {
+	if cfg.verbose {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(stderr, nil)))
+	} else {
+		slog.SetDefault(slog.New(slog.DiscardHandler))
+	}
+
+	idp, err := fakeidp.Start()
+	if err != nil {
+		return nil, err
+	}
+	redirect, err := url.Parse(cfg.redirectURI)
+	if err != nil {
+		idp.Close()
+		return nil, err
+	}
+	gw, err := gatewaytest.StartGateway(gatewaytest.Options{
+		AllowInsecureStateCookie: true,
+		Env: map[string]string{
+			"OIDC_ISSUER_URL":                     idp.Issuer(),
+			"OIDC_CLIENT_ID":                      localClientID,
+			"OAUTH_ALLOWED_REDIRECT_ORIGINS":      redirect.Scheme + "://" + redirect.Host,
+			"GATEWAY_VALIDATE_ID_TOKEN":           "true",
+			"GATEWAY_HTTP_IP_RATE_LIMIT_MAX":      strconv.Itoa(localRateLimit),
+			"GATEWAY_AUTH_IP_RATE_LIMIT_MAX":      strconv.Itoa(localRateLimit),
+			"GATEWAY_AUTH_ID_RATE_LIMIT_MAX":      strconv.Itoa(localRateLimit),
+			"GATEWAY_AUTH_AUTHORIZE_DEDUP_WINDOW": "0",
+			"GATEWAY_SSE_MAX_CONNECTIONS_PER_IP":  "0",
+			"GATEWAY_SSE_CONNECT_LIMIT":           strconv.Itoa(localRateLimit),
+		},
+	})
+	if err != nil {
+		idp.Close()
+		return nil, err
+	}
+	local := &localGateway{gateway: gw, idp: idp}
+	// The callback URL names the gateway, whose address is only known once
+	// it listens; the OIDC provider reads it per request.
+	if err := os.Setenv("OIDC_REDIRECT_BASE", gw.URL()); err != nil {
+		local.Close()
+		return nil, err
+	}
+
+	events := make([]gatewaytest.Event, cfg.events)
+	for i := range events {
+		events[i] = gatewaytest.Event{
+			ID:   strconv.Itoa(i + 1),
+			Name: "plan.step",
+			Data: fmt.Sprintf(`{"step":%d}`, i+1),
+		}
+	}
+	gw.Orchestrator.SetPlanEvents(cfg.planID, events...)
+	gw.Orchestrator.SetCallbackHandler(localProvider, gatewaytest.CodeExchangeHandler(nil, idp.TokenURL(),
+		&http.Cookie{Name: "oss_session", Value: "loadtest", Path: "/"}))
+	return local, nil
+}
+
+// URL returns the base URL of the in-process gateway.
+func (l *localGateway) URL() string {
+	return l.gateway.URL()
+}
+
+// Close shuts down the gateway, its fake upstreams and the identity provider.
+func (l *localGateway) Close() {
+	l.gateway.Close()
+	l.idp.Close()
+}
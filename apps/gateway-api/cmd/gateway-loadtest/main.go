@@ -0,0 +1,168 @@
+// Command gateway-loadtest measures gateway capacity on its hot paths: it
+// holds concurrent SSE streams open on /events and repeatedly drives the
+// authorize, provider and callback legs of a sign-in, then prints a JSON
+// report of latency percentiles and error rates.
+//
+// Without -target it starts an in-process gateway wired to the fake
+// orchestrator, indexer and identity provider used by the end-to-end tests,
+// so a run needs no other services. With -target it loads an existing
+// gateway; sign-ins then only succeed when its provider completes
+// authorization without user interaction, as the fake identity provider does.
+//
+// Thresholds set with -max-error-rate, -max-sse-p95 and -max-auth-p95 make the
+// command exit 1 when breached, so it can gate a CI job. Usage errors exit 2.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// config holds the parsed command line.
+type config struct {
+	target      string
+	duration    time.Duration
+	timeout     time.Duration
+	streams     int
+	streamHold  time.Duration
+	events      int
+	planID      string
+	logins      int
+	provider    string
+	redirectURI string
+	headers     http.Header
+	verbose     bool
+
+	maxErrorRate float64
+	maxSSEP95    time.Duration
+	maxAuthP95   time.Duration
+}
+
+// errFlagsReported marks a parse error the flag package already printed.
+var errFlagsReported = errors.New("invalid flags")
+
+// headerFlag collects repeated -header "Name: value" flags.
+type headerFlag http.Header
+
+func (h headerFlag) String() string { return "" }
+
+func (h headerFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("header %q must look like \"Name: value\"", value)
+	}
+	http.Header(h).Add(name, strings.TrimSpace(val))
+	return nil
+}
+
+func parseFlags(args []string, stderr io.Writer) (config, error) {
+	cfg := config{headers: make(http.Header)}
+	flags := flag.NewFlagSet("gateway-loadtest", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&cfg.target, "target", "", "gateway base URL; empty starts an in-process gateway with fake upstreams")
+	flags.DurationVar(&cfg.duration, "duration", 30*time.Second, "how long to generate load")
+	flags.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "per sign-in limit, and how long a stream may take to deliver its first event")
+	flags.IntVar(&cfg.streams, "streams", 50, "concurrent SSE streams (0 disables the scenario)")
+	flags.DurationVar(&cfg.streamHold, "stream-hold", 0, "close each stream this long after its first event; 0 reads until the gateway ends it")
+	flags.IntVar(&cfg.events, "events", 10, "events the in-process orchestrator sends on each stream")
+	flags.StringVar(&cfg.planID, "plan-id", "plan-10ad7e57", "plan whose events are streamed")
+	flags.IntVar(&cfg.logins, "logins", 5, "concurrent sign-in loops (0 disables the scenario)")
+	flags.StringVar(&cfg.provider, "provider", "oidc", "OAuth provider to sign in with")
+	flags.StringVar(&cfg.redirectURI, "redirect-uri", "https://app.example.com/complete", "client redirect_uri a sign-in finishes at")
+	flags.Var(headerFlag(cfg.headers), "header", "header sent on SSE requests, as \"Name: value\" (repeatable)")
+	flags.BoolVar(&cfg.verbose, "verbose", false, "keep the in-process gateway's logs on stderr")
+	flags.Float64Var(&cfg.maxErrorRate, "max-error-rate", 0.01, "fail when a scenario's error rate exceeds this fraction")
+	flags.DurationVar(&cfg.maxSSEP95, "max-sse-p95", 0, "fail when the 95th percentile time to first event exceeds this (0 disables)")
+	flags.DurationVar(&cfg.maxAuthP95, "max-auth-p95", 0, "fail when the 95th percentile sign-in time exceeds this (0 disables)")
+	if err := flags.Parse(args); err != nil {
+		return config{}, errFlagsReported
+	}
+	if flags.NArg() > 0 {
+		return config{}, fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	switch {
+	case cfg.duration <= 0:
+		return config{}, errors.New("-duration must be positive")
+	case cfg.timeout <= 0:
+		return config{}, errors.New("-timeout must be positive")
+	case cfg.streams < 0 || cfg.logins < 0:
+		return config{}, errors.New("-streams and -logins must not be negative")
+	case cfg.streams == 0 && cfg.logins == 0:
+		return config{}, errors.New("at least one of -streams and -logins must be positive")
+	case cfg.streamHold < 0 || cfg.events < 1:
+		return config{}, errors.New("-stream-hold must not be negative and -events must be positive")
+	case cfg.maxErrorRate < 0 || cfg.maxErrorRate > 1:
+		return config{}, errors.New("-max-error-rate must be between 0 and 1")
+	case cfg.maxSSEP95 < 0 || cfg.maxAuthP95 < 0:
+		return config{}, errors.New("latency thresholds must not be negative")
+	case strings.TrimSpace(cfg.planID) == "" || strings.TrimSpace(cfg.provider) == "":
+		return config{}, errors.New("-plan-id and -provider must not be empty")
+	}
+	if cfg.target == "" && cfg.provider != localProvider {
+		return config{}, fmt.Errorf("the in-process gateway only configures the %s provider; use -target for %s", localProvider, cfg.provider)
+	}
+	if cfg.target != "" {
+		target, err := url.Parse(cfg.target)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return config{}, fmt.Errorf("-target must be an absolute http(s) URL, got %q", cfg.target)
+		}
+		cfg.target = strings.TrimRight(cfg.target, "/")
+	}
+	redirect, err := url.Parse(cfg.redirectURI)
+	if err != nil || redirect.Scheme == "" || redirect.Host == "" {
+		return config{}, fmt.Errorf("-redirect-uri must be an absolute URL, got %q", cfg.redirectURI)
+	}
+	return cfg, nil
+}
+
+// run implements the command and returns its exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	cfg, err := parseFlags(args, stderr)
+	if err != nil {
+		if !errors.Is(err, errFlagsReported) {
+			fmt.Fprintln(stderr, err)
+		}
+		return 2
+	}
+
+	if cfg.target == "" {
+		local, err := startLocalGateway(cfg, stderr)
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to start in-process gateway: %v\n", err)
+			return 1
+		}
+		defer local.Close()
+		cfg.target = local.URL()
+	}
+
+	report := generateLoad(ctx, cfg)
+	report.evaluate(cfg)
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+	if !report.Pass {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runLoadTest(t *testing.T, args ...string) (int, report, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	var rep report
+	if stdout.Len() > 0 {
+		if err := json.Unmarshal(stdout.Bytes(), &rep); err != nil {
+			t.Fatalf("invalid report %q: %v", stdout.String(), err)
+		}
+	}
+	return code, rep, stderr.String()
+}
+
+func TestRunAgainstInProcessGateway(t *testing.T) {
+	code, rep, stderr := runLoadTest(t, "-duration", "500ms", "-streams", "3", "-logins", "2", "-events", "2", "-max-sse-p95", "5s", "-max-auth-p95", "5s")
+	if code != 0 {
+		t.Fatalf("expected exit 0, got %d (stderr %q, report %+v)", code, stderr, rep)
+	}
+	for _, name := range []string{"sse", "auth"} {
+		stats := rep.Scenarios[name]
+		if stats == nil || stats.Requests == 0 || stats.Errors != 0 {
+			t.Fatalf("expected error-free %s samples, got %+v", name, stats)
+		}
+		if stats.LatencyMS.P95 <= 0 {
+			t.Fatalf("expected %s latencies, got %+v", name, stats.LatencyMS)
+		}
+	}
+	if got, want := rep.Scenarios["sse"].Events, 2*rep.Scenarios["sse"].Requests; got < want {
+		t.Fatalf("expected at least %d events, got %d", want, got)
+	}
+	if len(rep.Thresholds) != 6 || !rep.Pass {
+		t.Fatalf("expected six passing thresholds, got %+v", rep.Thresholds)
+	}
+}
+
+func TestRunFailsWhenThresholdIsBreached(t *testing.T) {
+	code, rep, _ := runLoadTest(t, "-duration", "300ms", "-streams", "0", "-logins", "1", "-max-auth-p95", "1ns")
+	if code != 1 || rep.Pass {
+		t.Fatalf("expected a breached latency gate to exit 1, got %d with pass=%v", code, rep.Pass)
+	}
+	var breached []string
+	for _, result := range rep.Thresholds {
+		if !result.Pass {
+			breached = append(breached, result.Name)
+		}
+	}
+	if len(breached) != 1 || breached[0] != "auth.p95_ms" {
+		t.Fatalf("expected only auth.p95_ms to fail, got %v", breached)
+	}
+}
+
+func TestRunRejectsInvalidFlags(t *testing.T) {
+	cases := map[string][]string{
+		"nothing to run":    {"-streams", "0", "-logins", "0"},
+		"bad error rate":    {"-max-error-rate", "2"},
+		"relative target":   {"-target", "gateway.internal"},
+		"local provider":    {"-provider", "google"},
+		"malformed header":  {"-header", "no-colon"},
+		"stray argument":    {"extra"},
+		"negative duration": {"-duration", "-1s"},
+	}
+	for name, args := range cases {
+		t.Run(name, func(t *testing.T) {
+			code, _, stderr := runLoadTest(t, args...)
+			if code != 2 {
+				t.Fatalf("expected exit 2, got %d", code)
+			}
+			if strings.TrimSpace(stderr) == "" {
+				t.Fatal("expected the problem to be reported on stderr")
+			}
+		})
+	}
+}
+
+func TestPercentileUsesNearestRank(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	for p, want := range map[float64]time.Duration{50: 50 * time.Millisecond, 95: 95 * time.Millisecond, 100: 100 * time.Millisecond, 0: time.Millisecond} {
+		if got := percentile(sorted, p); got != want {
+			t.Fatalf("p%v: expected %v, got %v", p, want, got)
+		}
+	}
+	if got := percentile(sorted[:1], 99); got != time.Millisecond {
+		t.Fatalf("expected a single sample to be every percentile, got %v", got)
+	}
+}
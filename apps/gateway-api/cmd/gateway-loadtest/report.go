@@ -0,0 +1,155 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// report is the JSON document printed at the end of a run.
+type report struct {
+	Target     string                     `json:"target"`
+	Duration   string                     `json:"duration"`
+	Scenarios  map[string]*scenarioReport `json:"scenarios"`
+	Thresholds []thresholdResult          `json:"thresholds"`
+	Pass       bool                       `json:"pass"`
+}
+
+// scenarioReport summarises one scenario. Latencies cover successful samples
+// only: time to first event for "sse" and the full sign-in for "auth".
+type scenarioReport struct {
+	Workers        int            `json:"workers"`
+	Requests       int            `json:"requests"`
+	Errors         int            `json:"errors"`
+	ErrorRate      float64        `json:"error_rate"`
+	ErrorsByReason map[string]int `json:"errors_by_reason,omitempty"`
+	Events         int            `json:"events,omitempty"`
+	LatencyMS      latencySummary `json:"latency_ms"`
+}
+
+type latencySummary struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// thresholdResult is one CI gate and whether the run met it.
+type thresholdResult struct {
+	Name  string  `json:"name"`
+	Limit float64 `json:"limit"`
+	Value float64 `json:"value"`
+	Pass  bool    `json:"pass"`
+}
+
+// recorder collects the samples of one scenario.
+type recorder struct {
+	workers int
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	failures  map[string]int
+	events    int
+}
+
+func newRecorder(workers int) *recorder {
+	return &recorder{workers: workers, failures: make(map[string]int)}
+}
+
+func (r *recorder) add(s sample) {
+	if s.discard {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events += s.events
+	if s.failure != "" {
+		r.failures[s.failure]++
+		return
+	}
+	r.latencies = append(r.latencies, s.latency)
+}
+
+func (r *recorder) summary() *scenarioReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	errs := 0
+	for _, n := range r.failures {
+		errs += n
+	}
+	out := &scenarioReport{
+		Workers:   r.workers,
+		Requests:  len(r.latencies) + errs,
+		Errors:    errs,
+		Events:    r.events,
+		LatencyMS: summarizeLatencies(r.latencies),
+	}
+	if errs > 0 {
+		out.ErrorsByReason = make(map[string]int, len(r.failures))
+		for reason, n := range r.failures {
+			out.ErrorsByReason[reason] = n
+		}
+	}
+	if out.Requests > 0 {
+		out.ErrorRate = float64(errs) / float64(out.Requests)
+	}
+	return out
+}
+
+func summarizeLatencies(latencies []time.Duration) latencySummary {
+	if len(latencies) == 0 {
+		return latencySummary{}
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	return latencySummary{
+		P50: milliseconds(percentile(sorted, 50)),
+		P90: milliseconds(percentile(sorted, 90)),
+		P95: milliseconds(percentile(sorted, 95)),
+		P99: milliseconds(percentile(sorted, 99)),
+		Max: milliseconds(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of sorted, which must not
+// be empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+// evaluate checks the report against the thresholds in cfg. Every enabled
+// scenario must complete at least one request and stay within
+// -max-error-rate; latency gates apply when set.
+func (r *report) evaluate(cfg config) {
+	r.Pass = true
+	check := func(name string, limit, value float64, pass bool) {
+		r.Thresholds = append(r.Thresholds, thresholdResult{Name: name, Limit: limit, Value: value, Pass: pass})
+		r.Pass = r.Pass && pass
+	}
+	scenarios := []struct {
+		name   string
+		p95Max time.Duration
+	}{
+		{"sse", cfg.maxSSEP95},
+		{"auth", cfg.maxAuthP95},
+	}
+	for _, sc := range scenarios {
+		stats, ok := r.Scenarios[sc.name]
+		if !ok {
+			continue
+		}
+		check(sc.name+".requests", 1, float64(stats.Requests), stats.Requests >= 1)
+		check(sc.name+".error_rate", cfg.maxErrorRate, stats.ErrorRate, stats.Requests > 0 && stats.ErrorRate <= cfg.maxErrorRate)
+		if sc.p95Max > 0 {
+			limit := milliseconds(sc.p95Max)
+			check(sc.name+".p95_ms", limit, stats.LatencyMS.P95, stats.Requests > stats.Errors && stats.LatencyMS.P95 <= limit)
+		}
+	}
+}
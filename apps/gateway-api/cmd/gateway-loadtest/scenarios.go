@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLoginHops bounds the redirects followed in one sign-in: authorize to
+// provider, provider to callback and callback to the client.
+const maxLoginHops = 5
+
+// sample is the outcome of one stream or sign-in. A sample cut short by the
+// end of the run is discarded rather than counted as an error.
+type sample struct {
+	latency time.Duration
+	failure string
+	events  int
+	discard bool
+}
+
+// generateLoad runs the enabled scenarios side by side for cfg.duration.
+func generateLoad(ctx context.Context, cfg config) *report {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = cfg.streams + cfg.logins
+	defer transport.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+
+	rep := &report{Target: cfg.target, Duration: cfg.duration.String(), Scenarios: map[string]*scenarioReport{}}
+	var (
+		scenarios sync.WaitGroup
+		mu        sync.Mutex
+	)
+	run := func(name string, workers int, once func(context.Context) sample) {
+		scenarios.Add(1)
+		go func() {
+			defer scenarios.Done()
+			rec := newRecorder(workers)
+			var workersDone sync.WaitGroup
+			for range workers {
+				workersDone.Add(1)
+				go func() {
+					defer workersDone.Done()
+					for ctx.Err() == nil {
+						rec.add(once(ctx))
+					}
+				}()
+			}
+			workersDone.Wait()
+			mu.Lock()
+			rep.Scenarios[name] = rec.summary()
+			mu.Unlock()
+		}()
+	}
+	client := &http.Client{Transport: transport}
+	if cfg.streams > 0 {
+		run("sse", cfg.streams, func(ctx context.Context) sample { return streamOnce(ctx, client, cfg) })
+	}
+	if cfg.logins > 0 {
+		run("auth", cfg.logins, func(ctx context.Context) sample { return loginOnce(ctx, transport, cfg) })
+	}
+	scenarios.Wait()
+	return rep
+}
+
+// streamOnce opens one event stream and reports the time to its first event.
+// The stream then stays open until the gateway ends it, cfg.streamHold
+// elapses or the run ends.
+func streamOnce(ctx context.Context, client *http.Client, cfg config) sample {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	firstEventDeadline := time.AfterFunc(cfg.timeout, cancel)
+	defer firstEventDeadline.Stop()
+
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, cfg.target+"/events?plan_id="+url.QueryEscape(cfg.planID), nil)
+	if err != nil {
+		return sample{failure: "request_invalid"}
+	}
+	for name, values := range cfg.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	started := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil && streamCtx.Err() != nil {
+			return sample{failure: "first_event_timeout"}
+		}
+		return abandoned(ctx, "connect_failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return sample{failure: fmt.Sprintf("status_%d", resp.StatusCode)}
+	}
+
+	var s sample
+	pending := false
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "" && pending:
+			pending = false
+			s.events++
+			if s.events == 1 {
+				s.latency = time.Since(started)
+				firstEventDeadline.Stop()
+				if cfg.streamHold > 0 {
+					defer time.AfterFunc(cfg.streamHold, cancel).Stop()
+				}
+			}
+		case strings.HasPrefix(line, "data:") || strings.HasPrefix(line, "event:"):
+			pending = true
+		}
+		if err != nil {
+			break
+		}
+	}
+	if s.events == 0 {
+		if ctx.Err() != nil {
+			return sample{discard: true}
+		}
+		if streamCtx.Err() != nil {
+			return sample{failure: "first_event_timeout"}
+		}
+		return sample{failure: "no_events"}
+	}
+	return s
+}
+
+// loginOnce drives one sign-in from GET /auth/{provider}/authorize through the
+// provider and callback until the gateway redirects to cfg.redirectURI, and
+// reports how long the whole exchange took. Each sign-in uses its own cookie
+// jar, as separate browsers would.
+func loginOnce(ctx context.Context, transport http.RoundTripper, cfg config) sample {
+	loginCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return sample{failure: "cookie_jar"}
+	}
+	client := &http.Client{
+		Transport: transport,
+		Jar:       jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	finish, _ := url.Parse(cfg.redirectURI)
+
+	next, _ := url.Parse(cfg.target + "/auth/" + url.PathEscape(cfg.provider) + "/authorize?redirect_uri=" + url.QueryEscape(cfg.redirectURI))
+	started := time.Now()
+	for hop := range maxLoginHops {
+		req, err := http.NewRequestWithContext(loginCtx, http.MethodGet, next.String(), nil)
+		if err != nil {
+			return sample{failure: "request_invalid"}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if errors.Is(loginCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+				return sample{failure: "timeout"}
+			}
+			return abandoned(ctx, "connect_failed")
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound && resp.StatusCode != http.StatusSeeOther {
+			return sample{failure: fmt.Sprintf("hop%d_status_%d", hop, resp.StatusCode)}
+		}
+		location, err := resp.Location()
+		if err != nil {
+			return sample{failure: fmt.Sprintf("hop%d_no_location", hop)}
+		}
+		if location.Scheme == finish.Scheme && location.Host == finish.Host && location.Path == finish.Path {
+			if status := location.Query().Get("status"); status != "success" {
+				return sample{failure: "login_" + status}
+			}
+			return sample{latency: time.Since(started)}
+		}
+		next = location
+	}
+	return sample{failure: "too_many_redirects"}
+}
+
+// abandoned reports a transport failure, unless the run ended while the
+// request was in flight.
+func abandoned(ctx context.Context, failure string) sample {
+	if ctx.Err() != nil {
+		return sample{discard: true}
+	}
+	return sample{failure: failure}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is one "gateway-api" subcommand. name may be several words, such as
+// "secrets verify"; the remaining arguments are passed to run. Commands print
+// machine-readable output on stdout and diagnostics on stderr, and exit 0 on
+// success, 1 when the check they perform fails and 2 on usage or
+// configuration errors.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int
+}
+
+func commands() []command {
+	return []command{
+		{name: "serve", summary: "run the gateway (default)", run: func(context.Context, []string, io.Reader, io.Writer, io.Writer) int {
+			return runServe()
+		}},
+		{name: "check", summary: "check the configuration and OAuth providers without serving", run: func(_ context.Context, _ []string, _ io.Reader, stdout, _ io.Writer) int {
+			return runCheck(stdout)
+		}},
+		{name: "routes", summary: "print the effective route table with route policies", run: func(_ context.Context, args []string, _ io.Reader, stdout, stderr io.Writer) int {
+			return runRoutes(args, stdout, stderr)
+		}},
+		{name: "secrets verify", summary: "check that every configured secret can be loaded", run: func(_ context.Context, _ []string, _ io.Reader, stdout, _ io.Writer) int {
+			return runSecretsVerify(stdout)
+		}},
+		{name: "drain", usage: "[-no-wait] [-cancel]", summary: "take the running gateway out of rotation before shutdown", run: func(ctx context.Context, args []string, _ io.Reader, stdout, stderr io.Writer) int {
+			return runDrain(ctx, args, stdout, stderr)
+		}},
+		{name: "audit verify", usage: "<file>", summary: "validate an audit log against the audit event schema", run: func(_ context.Context, args []string, _ io.Reader, stdout, stderr io.Writer) int {
+			return runAuditVerify(args, stdout, stderr)
+		}},
+		{name: "audit correlation-id", usage: "[-vectors] < entries.jsonl", summary: "compute or check cross-service correlation IDs", run: func(_ context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+			return runAuditCorrelationID(args, stdin, stdout, stderr)
+		}},
+		{name: "reconcile-usage", usage: "[-from time] [-to time] [-check-store]", summary: "check the usage export for gaps", run: func(ctx context.Context, args []string, _ io.Reader, stdout, stderr io.Writer) int {
+			return runReconcileUsage(ctx, args, stdout, stderr)
+		}},
+		{name: "migrate", usage: "[-dry-run] [-timeout duration]", summary: "apply pending GATEWAY_STORAGE_URL schema migrations", run: func(ctx context.Context, args []string, _ io.Reader, stdout, stderr io.Writer) int {
+			return runMigrate(ctx, args, stdout, stderr)
+		}},
+		{name: "rehash-audit", usage: "< entries.jsonl", summary: "rehash stored identity hashes during a hash rotation", run: func(_ context.Context, _ []string, stdin io.Reader, stdout, stderr io.Writer) int {
+			return runRehashAudit(stdin, stdout, stderr)
+		}},
+	}
+}
+
+// runCommand dispatches args to the matching command. Without arguments it
+// serves; "--check" is kept as an alias of "check".
+func runCommand(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	switch args[0] {
+	case "--check":
+		args[0] = "check"
+	case "help", "-h", "-help", "--help":
+		printCommands(stdout)
+		return 0
+	}
+	for _, cmd := range commands() {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd.run(ctx, args[len(words):], stdin, stdout, stderr)
+		}
+	}
+	fmt.Fprintf(stderr, "unknown command %q\n\n", strings.Join(args, " "))
+	printCommands(stderr)
+	return 2
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "usage: gateway-api <command> [arguments]")
+	fmt.Fprintln(w)
+	tw := newTableWriter(w)
+	for _, cmd := range commands() {
+		fmt.Fprintf(tw, "  %s\t%s\n", strings.TrimSpace(cmd.name+" "+cmd.usage), cmd.summary)
+	}
+	_ = tw.Flush()
+}
+
+func newTableWriter(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/gateway"
+)
+
+// runDrain implements "gateway-api drain", meant for a Kubernetes exec
+// preStop hook. It asks the local admin API to drain the gateway and waits
+// for the drain delay, prints the drain state as JSON and exits 1 when the
+// admin API rejects the request, 2 on usage or configuration errors.
+func runDrain(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("drain", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	noWait := flags.Bool("no-wait", false, "return as soon as the drain has started")
+	cancel := flags.Bool("cancel", false, "stop draining and report ready again")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	addr := strings.TrimSpace(gateway.GetEnv("GATEWAY_ADMIN_ADDR", ""))
+	if addr == "" {
+		fmt.Fprintln(stderr, "GATEWAY_ADMIN_ADDR is not set")
+		return 2
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid GATEWAY_ADMIN_ADDR: %v\n", err)
+		return 2
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	token, err := gateway.LoadAdminToken()
+	if err != nil || token == "" {
+		fmt.Fprintln(stderr, "GATEWAY_ADMIN_TOKEN is required")
+		return 2
+	}
+
+	method, target := http.MethodPost, "http://"+net.JoinHostPort(host, port)+"/admin/drain"
+	if *cancel {
+		method = http.MethodDelete
+	} else if *noWait {
+		target += "?wait=false"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		fmt.Fprintf(stderr, "drain failed: %v\n", err)
+		return 2
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	client := &http.Client{Timeout: 2*gateway.ResolveDuration([]string{"GATEWAY_DRAIN_DELAY"}, 5*time.Second) + 5*time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(stderr, "drain failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(stderr, "drain failed: admin API returned %d\n", resp.StatusCode)
+		return 1
+	}
+
+	var state map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		fmt.Fprintf(stderr, "drain failed: invalid response: %v\n", err)
+		return 1
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(state)
+	return 0
+}
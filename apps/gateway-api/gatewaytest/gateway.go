@@ -0,0 +1,199 @@
+// Package gatewaytest provides fake upstream services and a fully wired gateway
+// for end-to-end tests. Helpers mutate process environment variables through
+// testing.TB.Setenv, so tests using them must not call t.Parallel.
+package gatewaytest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/gateway"
+)
+
+// Options customises the gateway started by NewGateway.
+type Options struct {
+	// Orchestrator is the upstream orchestrator. When nil a new
+	// FakeOrchestrator is started.
+	Orchestrator *FakeOrchestrator
+	// Indexer is the upstream indexer. When nil a new FakeIndexer is started.
+	Indexer *FakeIndexer
+	// Env holds additional environment variables applied before the routes
+	// are registered.
+	Env map[string]string
+	// TrustedProxyCIDRs mirrors GATEWAY_TRUSTED_PROXY_CIDRS.
+	TrustedProxyCIDRs []string
+	// AllowInsecureStateCookie permits OAuth state cookies over plain HTTP,
+	// which httptest servers use by default.
+	AllowInsecureStateCookie bool
+	// MaxBodyBytes overrides the request body limit. Zero applies the
+	// gateway default.
+	MaxBodyBytes int64
+}
+
+// Gateway is a gateway instance served over a real HTTP listener and wired
+// with the same routes and middleware as the production binary.
+type Gateway struct {
+	Server       *httptest.Server
+	Orchestrator *FakeOrchestrator
+	Indexer      *FakeIndexer
+
+	closers []func()
+}
+
+// NewGateway starts a gateway backed by fake upstreams. The server is closed
+// automatically when the test completes.
+func NewGateway(tb testing.TB, opts Options) *Gateway {
+	tb.Helper()
+
+	orchestrator := opts.Orchestrator
+	if orchestrator == nil {
+		orchestrator = NewFakeOrchestrator(tb)
+	}
+	indexer := opts.Indexer
+	if indexer == nil {
+		indexer = NewFakeIndexer(tb)
+	}
+
+	// Registered before the environment changes so it runs after they are
+	// undone; cleanups run last-in first-out.
+	tb.Cleanup(gateway.ResetAllowedRedirectOrigins)
+	tb.Setenv("ORCHESTRATOR_URL", orchestrator.URL())
+	tb.Setenv("INDEXER_URL", indexer.URL())
+	for key, value := range opts.Env {
+		tb.Setenv(key, value)
+	}
+	resetGatewayState()
+	tb.Cleanup(gateway.ResetOrchestratorClient)
+	tb.Cleanup(gateway.ResetCookieHandler)
+
+	handler, err := newHandler(opts)
+	if err != nil {
+		tb.Fatalf("%v", err)
+	}
+	server := httptest.NewServer(handler)
+	tb.Cleanup(server.Close)
+
+	return &Gateway{
+		Server:       server,
+		Orchestrator: orchestrator,
+		Indexer:      indexer,
+	}
+}
+
+// StartGateway starts a gateway backed by fake upstreams outside a test, such
+// as in a load generator. Options.Env is applied with os.Setenv and is not
+// restored, so StartGateway suits a process that runs a single gateway. The
+// caller must Close the gateway.
+func StartGateway(opts Options) (*Gateway, error) {
+	g := &Gateway{Orchestrator: opts.Orchestrator, Indexer: opts.Indexer}
+	if g.Orchestrator == nil {
+		g.Orchestrator = StartFakeOrchestrator()
+		g.closers = append(g.closers, g.Orchestrator.Close)
+	}
+	if g.Indexer == nil {
+		g.Indexer = StartFakeIndexer()
+		g.closers = append(g.closers, g.Indexer.Close)
+	}
+
+	env := map[string]string{
+		"ORCHESTRATOR_URL": g.Orchestrator.URL(),
+		"INDEXER_URL":      g.Indexer.URL(),
+	}
+	for key, value := range opts.Env {
+		env[key] = value
+	}
+	for key, value := range env {
+		if err := os.Setenv(key, value); err != nil {
+			g.Close()
+			return nil, fmt.Errorf("set %s: %w", key, err)
+		}
+	}
+	resetGatewayState()
+
+	handler, err := newHandler(opts)
+	if err != nil {
+		g.Close()
+		return nil, err
+	}
+	g.Server = httptest.NewServer(handler)
+	return g, nil
+}
+
+// Close shuts down a gateway started with StartGateway together with the fake
+// upstreams it started.
+func (g *Gateway) Close() {
+	if g.Server != nil {
+		g.Server.Close()
+	}
+	for i := len(g.closers) - 1; i >= 0; i-- {
+		g.closers[i]()
+	}
+	g.closers = nil
+}
+
+// resetGatewayState drops configuration the gateway package caches from the
+// environment so the values just applied take effect.
+func resetGatewayState() {
+	gateway.ResetOrchestratorClient()
+	gateway.ResetCookieHandler()
+	gateway.ResetAllowedRedirectOrigins()
+	gateway.ResetJWKSCache()
+}
+
+// newHandler registers the gateway routes and wraps them in the middleware
+// stack of the production binary.
+func newHandler(opts Options) (http.Handler, error) {
+	trustedNetworks, err := gateway.ParseTrustedProxyCIDRs(opts.TrustedProxyCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy configuration: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	gateway.RegisterAuthRoutes(mux, gateway.AuthRouteConfig{
+		TrustedProxyCIDRs:        opts.TrustedProxyCIDRs,
+		AllowInsecureStateCookie: opts.AllowInsecureStateCookie,
+	})
+	gateway.RegisterHealthRoutes(mux, time.Now())
+	gateway.RegisterEventRoutes(mux, gateway.EventRouteConfig{TrustedProxyCIDRs: opts.TrustedProxyCIDRs})
+	gateway.RegisterAttachmentRoutes(mux, gateway.AttachmentRouteConfig{TrustedProxyCIDRs: opts.TrustedProxyCIDRs})
+	gateway.RegisterArtifactRoutes(mux, gateway.ArtifactRouteConfig{TrustedProxyCIDRs: opts.TrustedProxyCIDRs})
+	gateway.RegisterCollaborationRoutes(mux, gateway.CollaborationRouteConfig{TrustedProxyCIDRs: opts.TrustedProxyCIDRs})
+
+	maxBodyBytes := opts.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = gateway.DefaultMaxRequestBodyBytes()
+	}
+
+	// Mirror the middleware ordering used by main.buildHTTPHandler.
+	routeBodyLimits, err := gateway.RouteBodyLimits("")
+	if err != nil {
+		return nil, fmt.Errorf("route body limits: %w", err)
+	}
+	router := gateway.NewRouter(mux)
+	router.SetBodyLimits(maxBodyBytes, routeBodyLimits)
+	handler := gateway.RequestCanonicalizationMiddleware(gateway.PublicBaseURLMiddleware(router, trustedNetworks), gateway.DefaultMaxURLBytes())
+	handler = gateway.NewGlobalRateLimiter(trustedNetworks).Middleware(handler)
+	handler = gateway.SecurityHeadersMiddleware(handler)
+	handler = audit.Middleware(handler)
+	return handler, nil
+}
+
+// URL returns the base URL of the gateway.
+func (g *Gateway) URL() string {
+	return g.Server.URL
+}
+
+// Client returns an HTTP client that does not follow redirects so OAuth flows
+// can be asserted hop by hop.
+func (g *Gateway) Client() *http.Client {
+	client := g.Server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return client
+}
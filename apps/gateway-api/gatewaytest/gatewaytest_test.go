@@ -0,0 +1,328 @@
+package gatewaytest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/fakeidp"
+)
+
+const testPlanID = "plan-deadbeef"
+
+func TestGatewayReadinessReflectsFakeUpstreams(t *testing.T) {
+	gw := NewGateway(t, Options{})
+
+	resp, err := gw.Client().Get(gw.URL() + "/readyz")
+	if err != nil {
+		t.Fatalf("readyz request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 from readyz, got %d", resp.StatusCode)
+	}
+
+	gw.Indexer.SetHealthy(false)
+	resp, err = gw.Client().Get(gw.URL() + "/readyz")
+	if err != nil {
+		t.Fatalf("readyz request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when indexer unhealthy, got %d", resp.StatusCode)
+	}
+	if got := len(gw.Orchestrator.RequestsWithPrefix("/readyz")); got != 2 {
+		t.Fatalf("expected 2 orchestrator readiness probes, got %d", got)
+	}
+}
+
+func TestGatewayProxiesPlanEventsWithRequestCorrelation(t *testing.T) {
+	gw := NewGateway(t, Options{})
+	gw.Orchestrator.SetPlanEvents(testPlanID,
+		Event{ID: "1", Name: "plan.step", Data: `{"step":1}`},
+		Event{ID: "2", Name: "plan.completed", Data: `{}`},
+	)
+
+	req, err := http.NewRequest(http.MethodGet, gw.URL()+"/events?plan_id="+testPlanID, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	req.Header.Set("X-Request-Id", "req-e2e-1")
+	resp, err := gw.Client().Do(req)
+	if err != nil {
+		t.Fatalf("events request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read stream: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Request-Id"); got != "req-e2e-1" {
+		t.Fatalf("expected request id to be echoed, got %q", got)
+	}
+	if !strings.Contains(string(body), "event: plan.completed") {
+		t.Fatalf("expected scripted events in stream, got %q", body)
+	}
+
+	upstream := gw.Orchestrator.RequestsWithPrefix("/plan/")
+	if len(upstream) != 1 {
+		t.Fatalf("expected 1 upstream plan request, got %d", len(upstream))
+	}
+	if got := upstream[0].Header.Get("X-Request-Id"); got != "req-e2e-1" {
+		t.Fatalf("expected request id to propagate upstream, got %q", got)
+	}
+}
+
+func TestGatewayCollaborationRejectsMissingSession(t *testing.T) {
+	gw := NewGateway(t, Options{})
+
+	query := url.Values{"filePath": {"src/main.go"}, "projectId": {"proj-1"}}
+	req, err := http.NewRequest(http.MethodGet, gw.URL()+"/collaboration/ws?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := gw.Client().Do(req)
+	if err != nil {
+		t.Fatalf("collaboration request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a scripted session, got %d", resp.StatusCode)
+	}
+	if got := len(gw.Orchestrator.RequestsWithPrefix("/auth/session")); got != 1 {
+		t.Fatalf("expected one session validation call, got %d", got)
+	}
+}
+
+func TestGatewayCollaborationTunnelsWebSocketUpgrade(t *testing.T) {
+	gw := NewGateway(t, Options{})
+	gw.Orchestrator.SetSession(&Session{ID: "sess-1", TenantID: "acme"})
+
+	conn, err := net.DialTimeout("tcp", gw.Server.Listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	query := url.Values{"filePath": {"src/main.go"}, "projectId": {"proj-1"}}
+	handshake := "GET /collaboration/ws?" + query.Encode() + " HTTP/1.1\r\n" +
+		"Host: " + gw.Server.Listener.Addr().String() + "\r\n" +
+		"Authorization: Bearer token\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		t.Fatalf("failed to write handshake: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", got)
+	}
+
+	// A masked binary frame holding a y-websocket sync step 1 message, which
+	// the gateway requires as the opening message.
+	frame := []byte{0x82, 0x84, 0x01, 0x02, 0x03, 0x04, 0x00 ^ 0x01, 0x00 ^ 0x02, 0x01 ^ 0x03, 0x00 ^ 0x04}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("failed to write payload: %v", err)
+	}
+	echo := make([]byte, len(frame))
+	if _, err := io.ReadFull(reader, echo); err != nil {
+		t.Fatalf("failed to read echo: %v", err)
+	}
+	if string(echo) != string(frame) {
+		t.Fatalf("expected echoed payload, got % x", echo)
+	}
+
+	sessions := gw.Orchestrator.RequestsWithPrefix("/collaboration/ws")
+	if len(sessions) != 1 {
+		t.Fatalf("expected one upstream upgrade, got %d", len(sessions))
+	}
+	if got := sessions[0].Header.Get("X-Tenant-Id"); got != "acme" {
+		t.Fatalf("expected tenant from session to be forwarded, got %q", got)
+	}
+}
+
+func TestGatewayCollaborationClosesWebSocketAtMaxStreamAge(t *testing.T) {
+	gw := NewGateway(t, Options{Env: map[string]string{
+		"GATEWAY_COLLAB_MAX_STREAM_AGE":        "100ms",
+		"GATEWAY_COLLAB_MAX_STREAM_AGE_JITTER": "10ms",
+	}})
+	gw.Orchestrator.SetSession(&Session{ID: "sess-1", TenantID: "acme"})
+
+	conn, err := net.DialTimeout("tcp", gw.Server.Listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	query := url.Values{"filePath": {"src/main.go"}, "projectId": {"proj-1"}}
+	handshake := "GET /collaboration/ws?" + query.Encode() + " HTTP/1.1\r\n" +
+		"Host: " + gw.Server.Listener.Addr().String() + "\r\n" +
+		"Authorization: Bearer token\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		t.Fatalf("failed to write handshake: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read until close: %v", err)
+	}
+	want := "\x88\x10\x03\xe9max_stream_age"
+	if string(rest) != want {
+		t.Fatalf("expected going-away close frame, got %q", rest)
+	}
+}
+
+const testAppRedirect = "https://app.example.com/complete"
+
+// newOIDCGateway starts a gateway whose generic OIDC provider is backed by a
+// fake identity provider and whose fake orchestrator redeems forwarded codes
+// at the provider's token endpoint.
+func newOIDCGateway(t *testing.T) (*Gateway, *fakeidp.Server) {
+	t.Helper()
+	idp := fakeidp.New(t)
+	gw := NewGateway(t, Options{
+		AllowInsecureStateCookie: true,
+		Env: map[string]string{
+			"OIDC_ISSUER_URL":                idp.Issuer(),
+			"OIDC_CLIENT_ID":                 "oidc-client",
+			"OAUTH_ALLOWED_REDIRECT_ORIGINS": "https://app.example.com",
+			"GATEWAY_VALIDATE_ID_TOKEN":      "true",
+		},
+	})
+	t.Setenv("OIDC_REDIRECT_BASE", gw.URL())
+	gw.Orchestrator.SetCallbackHandler("oidc", CodeExchangeHandler(nil, idp.TokenURL(),
+		&http.Cookie{Name: "oss_session", Value: "session-1", Path: "/"}))
+	return gw, idp
+}
+
+// runOIDCLogin follows a login from the gateway authorize endpoint through
+// the identity provider and back to the callback, returning the final
+// redirect to the client application.
+func runOIDCLogin(t *testing.T, gw *Gateway) (*url.URL, []*http.Cookie) {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("failed to create cookie jar: %v", err)
+	}
+	client := gw.Client()
+	client.Jar = jar
+
+	next := gw.URL() + "/auth/oidc/authorize?redirect_uri=" + url.QueryEscape(testAppRedirect)
+	var resp *http.Response
+	for hop := 0; hop < 3; hop++ {
+		resp, err = client.Get(next)
+		if err != nil {
+			t.Fatalf("hop %d to %s failed: %v", hop, next, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("hop %d to %s: expected 302, got %d", hop, next, resp.StatusCode)
+		}
+		next = resp.Header.Get("Location")
+	}
+	location, err := url.Parse(next)
+	if err != nil {
+		t.Fatalf("invalid final redirect: %v", err)
+	}
+	if got := location.Scheme + "://" + location.Host + location.Path; got != testAppRedirect {
+		t.Fatalf("expected login to finish at %s, got %s", testAppRedirect, location)
+	}
+	return location, resp.Cookies()
+}
+
+func TestGatewayOIDCLoginAgainstFakeIdP(t *testing.T) {
+	gw, idp := newOIDCGateway(t)
+
+	location, cookies := runOIDCLogin(t, gw)
+	if got := location.Query().Get("status"); got != "success" {
+		t.Fatalf("expected success redirect, got %s", location)
+	}
+	found := false
+	for _, cookie := range cookies {
+		found = found || cookie.Name == "oss_session"
+	}
+	if !found {
+		t.Fatal("expected the orchestrator session cookie to be forwarded")
+	}
+
+	auths := idp.Authorizations()
+	if len(auths) != 1 {
+		t.Fatalf("expected one authorize request at the provider, got %d", len(auths))
+	}
+	if auths[0].ClientID != "oidc-client" || auths[0].Nonce == "" || auths[0].CodeChallengeMethod != "S256" {
+		t.Fatalf("unexpected authorize request %+v", auths[0])
+	}
+	if auths[0].RedirectURI != gw.URL()+"/auth/oidc/callback" {
+		t.Fatalf("expected provider to return to the gateway callback, got %q", auths[0].RedirectURI)
+	}
+	if got := idp.TokenRequests(); got != 1 {
+		t.Fatalf("expected one code exchange, got %d", got)
+	}
+}
+
+func TestGatewayOIDCLoginReportsProviderErrors(t *testing.T) {
+	t.Run("authorize denied", func(t *testing.T) {
+		gw, idp := newOIDCGateway(t)
+		idp.SetAuthorizeError(&fakeidp.Error{Code: "access_denied"})
+
+		location, _ := runOIDCLogin(t, gw)
+		if got := location.Query().Get("status"); got != "error" {
+			t.Fatalf("expected error redirect, got %s", location)
+		}
+		if got := idp.TokenRequests(); got != 0 {
+			t.Fatalf("expected no code exchange after a denied authorization, got %d", got)
+		}
+	})
+
+	t.Run("token error", func(t *testing.T) {
+		gw, idp := newOIDCGateway(t)
+		idp.SetTokenError(&fakeidp.Error{Code: "invalid_grant"})
+
+		location, cookies := runOIDCLogin(t, gw)
+		if got := location.Query().Get("status"); got != "error" {
+			t.Fatalf("expected error redirect, got %s", location)
+		}
+		for _, cookie := range cookies {
+			if cookie.Name == "oss_session" {
+				t.Fatal("expected no session cookie after a failed exchange")
+			}
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package gatewaytest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// FakeIndexer is a minimal stand-in for the indexer service exposing the
+// health endpoint probed by the gateway readiness check.
+type FakeIndexer struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	healthy  bool
+	requests []RecordedRequest
+}
+
+// NewFakeIndexer starts a healthy fake indexer. The server is closed
+// automatically when the test completes.
+func NewFakeIndexer(tb testing.TB) *FakeIndexer {
+	tb.Helper()
+	f := StartFakeIndexer()
+	tb.Cleanup(f.Close)
+	return f
+}
+
+// StartFakeIndexer starts a healthy fake indexer outside a test. The caller
+// must Close it.
+func StartFakeIndexer() *FakeIndexer {
+	f := &FakeIndexer{healthy: true}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// URL returns the base URL of the fake indexer.
+func (f *FakeIndexer) URL() string {
+	return f.server.URL
+}
+
+// Close shuts down the fake indexer ahead of test cleanup.
+func (f *FakeIndexer) Close() {
+	f.server.Close()
+}
+
+// SetHealthy toggles the status reported by GET /healthz.
+func (f *FakeIndexer) SetHealthy(healthy bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.healthy = healthy
+}
+
+// Requests returns a snapshot of every request received so far.
+func (f *FakeIndexer) Requests() []RecordedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]RecordedRequest(nil), f.requests...)
+}
+
+func (f *FakeIndexer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, RecordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Header: r.Header.Clone(),
+	})
+	healthy := f.healthy
+	f.mu.Unlock()
+
+	if r.URL.Path != "/healthz" {
+		http.NotFound(w, r)
+		return
+	}
+	if !healthy {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "unavailable"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
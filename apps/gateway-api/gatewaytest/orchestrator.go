@@ -0,0 +1,386 @@
+package gatewaytest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const websocketAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// RecordedRequest captures an inbound request observed by a fake upstream so
+// tests can assert on forwarded headers, payloads, and request correlation.
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   []byte
+}
+
+// Response scripts the reply returned by a fake upstream endpoint.
+type Response struct {
+	Status  int
+	Body    string
+	Header  http.Header
+	Cookies []*http.Cookie
+}
+
+// Event describes a single Server-Sent Event emitted by the fake plan stream.
+type Event struct {
+	ID   string
+	Name string
+	Data string
+}
+
+// Session mirrors the orchestrator session payload returned from /auth/session.
+type Session struct {
+	ID       string
+	TenantID string
+	// BindingIDHash is echoed as bindingIdHash and as the
+	// X-Session-Binding-Hash header on plan event streams.
+	BindingIDHash string
+}
+
+// FakeOrchestrator is a scriptable stand-in for the orchestrator service. It
+// implements the subset of endpoints the gateway proxies: OAuth callbacks,
+// session validation, plan event streams, collaboration upgrades, and
+// readiness.
+type FakeOrchestrator struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	requests   []RecordedRequest
+	callbacks  map[string]Response
+	exchangers map[string]func(RecordedRequest) Response
+	session    *Session
+	planEvents map[string][]Event
+	ready      bool
+}
+
+// NewFakeOrchestrator starts a fake orchestrator. The server is closed
+// automatically when the test completes.
+func NewFakeOrchestrator(tb testing.TB) *FakeOrchestrator {
+	tb.Helper()
+	f := StartFakeOrchestrator()
+	tb.Cleanup(f.Close)
+	return f
+}
+
+// StartFakeOrchestrator starts a fake orchestrator outside a test, such as in
+// a load generator. The caller must Close it.
+func StartFakeOrchestrator() *FakeOrchestrator {
+	f := &FakeOrchestrator{
+		callbacks:  make(map[string]Response),
+		exchangers: make(map[string]func(RecordedRequest) Response),
+		planEvents: make(map[string][]Event),
+		ready:      true,
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// URL returns the base URL of the fake orchestrator.
+func (f *FakeOrchestrator) URL() string {
+	return f.server.URL
+}
+
+// Close shuts down the fake orchestrator ahead of test cleanup.
+func (f *FakeOrchestrator) Close() {
+	f.server.Close()
+}
+
+// SetCallbackResponse scripts the reply for POST /auth/{provider}/callback.
+// Providers without a scripted response receive 200 with an empty JSON body.
+func (f *FakeOrchestrator) SetCallbackResponse(provider string, resp Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.callbacks[provider] = resp
+}
+
+// SetCallbackHandler computes the reply for POST /auth/{provider}/callback
+// from the request the gateway sent, which lets a test redeem the forwarded
+// code and code_verifier against a fake identity provider as the real
+// orchestrator would. A handler takes precedence over SetCallbackResponse.
+func (f *FakeOrchestrator) SetCallbackHandler(provider string, handler func(RecordedRequest) Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.exchangers[provider] = handler
+}
+
+// CodeExchangeHandler returns a callback handler for SetCallbackHandler that
+// redeems the forwarded code at tokenURL with the authorization_code grant.
+// A successful exchange is answered with the token response and the given
+// session cookies; a rejected one with 401 and the provider's error body.
+func CodeExchangeHandler(client *http.Client, tokenURL string, cookies ...*http.Cookie) func(RecordedRequest) Response {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(req RecordedRequest) Response {
+		var payload map[string]any
+		if err := json.Unmarshal(req.Body, &payload); err != nil {
+			return Response{Status: http.StatusBadRequest, Body: `{"code":"invalid_request"}`}
+		}
+		form := url.Values{"grant_type": {"authorization_code"}}
+		for _, key := range []string{"code", "code_verifier", "redirect_uri", "client_id"} {
+			if value, ok := payload[key].(string); ok {
+				form.Set(key, value)
+			}
+		}
+		resp, err := client.PostForm(tokenURL, form)
+		if err != nil {
+			return Response{Status: http.StatusBadGateway, Body: `{"code":"upstream_error"}`}
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return Response{Status: http.StatusUnauthorized, Body: string(body)}
+		}
+		return Response{Status: http.StatusOK, Body: string(body), Cookies: cookies}
+	}
+}
+
+// SetSession configures the session returned by GET /auth/session. Passing a
+// nil session causes the endpoint to respond with 401.
+func (f *FakeOrchestrator) SetSession(session *Session) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if session == nil {
+		f.session = nil
+		return
+	}
+	clone := *session
+	f.session = &clone
+}
+
+// SetPlanEvents scripts the events streamed from GET /plan/{planID}/events.
+// The stream is closed once all events have been written.
+func (f *FakeOrchestrator) SetPlanEvents(planID string, events ...Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.planEvents[planID] = append([]Event(nil), events...)
+}
+
+// SetReady toggles the status reported by GET /readyz.
+func (f *FakeOrchestrator) SetReady(ready bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ready = ready
+}
+
+// Requests returns a snapshot of every request received so far.
+func (f *FakeOrchestrator) Requests() []RecordedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]RecordedRequest(nil), f.requests...)
+}
+
+// RequestsWithPrefix returns the recorded requests whose path begins with the
+// provided prefix.
+func (f *FakeOrchestrator) RequestsWithPrefix(prefix string) []RecordedRequest {
+	var matched []RecordedRequest
+	for _, req := range f.Requests() {
+		if strings.HasPrefix(req.Path, prefix) {
+			matched = append(matched, req)
+		}
+	}
+	return matched
+}
+
+func (f *FakeOrchestrator) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	recorded := f.record(r)
+
+	path := r.URL.Path
+	switch {
+	case path == "/readyz":
+		f.serveReady(w)
+	case path == "/auth/session":
+		f.serveSession(w)
+	case strings.HasPrefix(path, "/auth/") && strings.HasSuffix(path, "/callback"):
+		provider := strings.TrimSuffix(strings.TrimPrefix(path, "/auth/"), "/callback")
+		f.serveCallback(w, r, recorded, provider)
+	case strings.HasPrefix(path, "/plan/") && strings.HasSuffix(path, "/events"):
+		planID := strings.TrimSuffix(strings.TrimPrefix(path, "/plan/"), "/events")
+		f.servePlanEvents(w, planID)
+	case path == "/collaboration/ws":
+		serveWebSocketEcho(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *FakeOrchestrator) record(r *http.Request) RecordedRequest {
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+	recorded := RecordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Header: r.Header.Clone(),
+		Body:   body,
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, recorded)
+	return recorded
+}
+
+func (f *FakeOrchestrator) serveReady(w http.ResponseWriter) {
+	f.mu.Lock()
+	ready := f.ready
+	f.mu.Unlock()
+	if !ready {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "unavailable"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "details": map[string]any{}})
+}
+
+func (f *FakeOrchestrator) serveSession(w http.ResponseWriter) {
+	f.mu.Lock()
+	session := f.session
+	f.mu.Unlock()
+	if session == nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"code": "unauthorized"})
+		return
+	}
+	payload := map[string]any{"id": session.ID}
+	if session.TenantID != "" {
+		payload["tenantId"] = session.TenantID
+	}
+	if session.BindingIDHash != "" {
+		payload["bindingIdHash"] = session.BindingIDHash
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"session": payload})
+}
+
+func (f *FakeOrchestrator) serveCallback(w http.ResponseWriter, r *http.Request, recorded RecordedRequest, provider string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	f.mu.Lock()
+	resp, ok := f.callbacks[provider]
+	handler := f.exchangers[provider]
+	f.mu.Unlock()
+	if handler != nil {
+		resp, ok = handler(recorded), true
+	}
+	if !ok {
+		resp = Response{Status: http.StatusOK, Body: "{}"}
+	}
+	writeResponse(w, resp)
+}
+
+func (f *FakeOrchestrator) servePlanEvents(w http.ResponseWriter, planID string) {
+	f.mu.Lock()
+	events, ok := f.planEvents[planID]
+	session := f.session
+	f.mu.Unlock()
+	if !ok {
+		http.Error(w, "plan not found", http.StatusNotFound)
+		return
+	}
+	if session != nil && session.BindingIDHash != "" {
+		w.Header().Set("X-Session-Binding-Hash", session.BindingIDHash)
+	}
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	for _, event := range events {
+		_, _ = io.WriteString(w, formatEvent(event))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func formatEvent(event Event) string {
+	var b strings.Builder
+	if event.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", event.ID)
+	}
+	if event.Name != "" {
+		fmt.Fprintf(&b, "event: %s\n", event.Name)
+	}
+	for _, line := range strings.Split(event.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// serveWebSocketEcho completes a WebSocket handshake and echoes raw frames back
+// to the caller. Frames are not decoded; the fake only needs to prove that the
+// gateway tunnels bytes in both directions.
+func serveWebSocketEcho(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking unsupported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketAcceptGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	handshake := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n"
+	if protocol := r.Header.Get("Sec-WebSocket-Protocol"); protocol != "" {
+		handshake += "Sec-WebSocket-Protocol: " + strings.TrimSpace(strings.Split(protocol, ",")[0]) + "\r\n"
+	}
+	handshake += "\r\n"
+	if _, err := rw.WriteString(handshake); err != nil {
+		return
+	}
+	if err := rw.Flush(); err != nil {
+		return
+	}
+	_, _ = io.Copy(conn, bufio.NewReader(rw))
+}
+
+func writeResponse(w http.ResponseWriter, resp Response) {
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	for _, cookie := range resp.Cookies {
+		http.SetCookie(w, cookie)
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, resp.Body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
@@ -2,15 +2,15 @@ package audit
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/deployment"
 )
 
 type contextKey string
@@ -19,33 +19,51 @@ const (
 	actorContextKey     contextKey = "audit.actor"
 	requestIDContextKey contextKey = "audit.request_id"
 	defaultSalt                    = "gateway"
+
+	// MessagePrefix starts the log message of every audit record.
+	MessagePrefix = "gateway.audit."
 )
 
 // Event captures the structured details emitted to the audit log.
+// SchemaVersion pins the record format for a single event; left empty, the
+// logger's configured version applies.
 type Event struct {
-	Name       string
-	Outcome    string
-	Target     string
-	Capability string
-	ActorID    string
-	Details    map[string]any
+	SchemaVersion string
+	Name          string
+	Outcome       string
+	Target        string
+	Capability    string
+	ActorID       string
+	// Correlation maps identifier kinds, such as CorrelationTenant, to
+	// correlation IDs. Version 1 records leave it out.
+	Correlation map[string]string
+	Details     map[string]any
 }
 
 // Logger provides structured helpers for writing audit events.
 type Logger struct {
-	logger *slog.Logger
-	salt   string
+	// logger receives the records; nil writes to slog.Default() at the time
+	// of each event.
+	logger         *slog.Logger
+	hashing        hashConfig
+	correlationKey []byte
+	version        string
+	clock          func() time.Time
 }
 
 // Default constructs a Logger backed by the process-wide slog default logger.
 // A custom hashing salt may be provided via the GATEWAY_AUDIT_SALT environment
-// variable to ensure hash stability across restarts without leaking raw values.
+// variable to ensure hash stability across restarts without leaking raw values;
+// GATEWAY_AUDIT_HASH_ALGORITHM=hmac-sha256 keys the hashes with
+// GATEWAY_AUDIT_HMAC_KEY instead, and GATEWAY_AUDIT_CORRELATION_KEY enables
+// correlation IDs. GATEWAY_AUDIT_SCHEMA_VERSION selects the record format.
+// Invalid settings fall back to the defaults and are reported by
+// ValidateConfig.
 func Default() *Logger {
-	salt := strings.TrimSpace(os.Getenv("GATEWAY_AUDIT_SALT"))
-	if salt == "" {
-		salt = defaultSalt
-	}
-	return &Logger{logger: slog.Default(), salt: salt}
+	hashing, _ := configuredHashing()
+	correlationKey, _ := CorrelationKey()
+	version, _ := configuredSchemaVersion()
+	return &Logger{hashing: hashing, correlationKey: correlationKey, version: version}
 }
 
 // WithActor records the hashed actor identifier on the request context so the
@@ -104,25 +122,33 @@ func EnsureRequestID(r *http.Request, w http.ResponseWriter) (*http.Request, str
 
 // Info records a successful audit event.
 func (l *Logger) Info(ctx context.Context, event Event) {
-	l.log(ctx, slog.LevelInfo, "gateway.audit.info", event)
+	l.log(ctx, slog.LevelInfo, MessagePrefix+"info", event)
 }
 
 // Security records a security-relevant audit event.
 func (l *Logger) Security(ctx context.Context, event Event) {
-	l.log(ctx, slog.LevelWarn, "gateway.audit.security", event)
+	l.log(ctx, slog.LevelWarn, MessagePrefix+"security", event)
 }
 
 // Error records an audit event that resulted in a failure.
 func (l *Logger) Error(ctx context.Context, event Event) {
-	l.log(ctx, slog.LevelError, "gateway.audit.error", event)
+	l.log(ctx, slog.LevelError, MessagePrefix+"error", event)
 }
 
 func (l *Logger) log(ctx context.Context, level slog.Level, msg string, event Event) {
-	attrs := []slog.Attr{
+	version := event.SchemaVersion
+	if version == "" {
+		version = l.version
+	}
+	var attrs []slog.Attr
+	if version != LegacySchemaVersion {
+		attrs = append(attrs, slog.String("schema_version", SchemaVersion))
+	}
+	attrs = append(attrs,
 		slog.String("event", event.Name),
 		slog.String("outcome", event.Outcome),
 		slog.String("target", event.Target),
-	}
+	)
 	if event.Capability != "" {
 		attrs = append(attrs, slog.String("capability", event.Capability))
 	}
@@ -132,27 +158,31 @@ func (l *Logger) log(ctx context.Context, level slog.Level, msg string, event Ev
 	if reqID := RequestID(ctx); reqID != "" {
 		attrs = append(attrs, slog.String("request_id", reqID))
 	}
+	if replica := deployment.Current(); version != LegacySchemaVersion && !replica.IsZero() {
+		attrs = append(attrs, slog.Any("deployment", replica))
+	}
+	if version != LegacySchemaVersion && len(event.Correlation) > 0 {
+		attrs = append(attrs, slog.Any("correlation", event.Correlation))
+	}
 	if len(event.Details) > 0 {
 		attrs = append(attrs, slog.Any("details", event.Details))
 	}
+	if validationEnabled() {
+		checkRecord(attrs)
+	}
 
-	l.logger.LogAttrs(ctx, level, msg, attrs...)
+	logger := l.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
 }
 
 // HashIdentity hashes the provided identity components using SHA-256 with the
-// logger's configured salt. Empty components are ignored to maintain stability.
+// logger's configured salt, or HMAC-SHA-256 with its key. Empty components are
+// ignored to maintain stability.
 func (l *Logger) HashIdentity(parts ...string) string {
-	h := sha256.New()
-	h.Write([]byte(l.salt))
-	for _, part := range parts {
-		trimmed := strings.TrimSpace(part)
-		if trimmed == "" {
-			continue
-		}
-		h.Write([]byte("|"))
-		h.Write([]byte(trimmed))
-	}
-	return hex.EncodeToString(h.Sum(nil))
+	return l.hashing.current.sum("", parts)
 }
 
 func actorFromContext(ctx context.Context, fallback string) string {
@@ -180,7 +210,12 @@ func SanitizeDetails(details map[string]any) map[string]any {
 	if len(details) == 0 {
 		return nil
 	}
-	sanitized := make(map[string]any, len(details))
+	return SanitizeDetailsInto(make(map[string]any, len(details)), details)
+}
+
+// SanitizeDetailsInto is SanitizeDetails writing into sanitized, so callers
+// can reuse a map between events. It returns sanitized.
+func SanitizeDetailsInto(sanitized, details map[string]any) map[string]any {
 	for key, value := range details {
 		switch v := value.(type) {
 		case nil:
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +14,8 @@ import (
 	"testing"
 
 	"log/slog"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/deployment"
 )
 
 type recordingHandler struct {
@@ -49,8 +52,8 @@ func TestDefaultUsesEnvironmentSalt(t *testing.T) {
 	t.Setenv("GATEWAY_AUDIT_SALT", "custom")
 
 	logger := Default()
-	if logger.salt != "custom" {
-		t.Fatalf("expected salt to come from environment, got %q", logger.salt)
+	if salt := string(logger.hashing.current.secret); salt != "custom" {
+		t.Fatalf("expected salt to come from environment, got %q", salt)
 	}
 }
 
@@ -58,8 +61,8 @@ func TestDefaultFallsBackToConstantSalt(t *testing.T) {
 	t.Setenv("GATEWAY_AUDIT_SALT", "")
 
 	logger := Default()
-	if logger.salt != defaultSalt {
-		t.Fatalf("expected fallback salt %q, got %q", defaultSalt, logger.salt)
+	if salt := string(logger.hashing.current.secret); salt != defaultSalt {
+		t.Fatalf("expected fallback salt %q, got %q", defaultSalt, salt)
 	}
 }
 
@@ -180,7 +183,7 @@ func TestEnsureRequestID(t *testing.T) {
 
 func TestLoggerLogIncludesContextAttributes(t *testing.T) {
 	handler := &recordingHandler{}
-	logger := &Logger{logger: slog.New(handler), salt: "salt"}
+	logger := &Logger{logger: slog.New(handler), hashing: saltedHashing("salt")}
 
 	ctx := WithActor(context.Background(), "actor-1")
 	ctx = context.WithValue(ctx, requestIDContextKey, "req-xyz")
@@ -231,7 +234,7 @@ func TestLoggerLogIncludesContextAttributes(t *testing.T) {
 }
 
 func TestHashIdentityIgnoresEmptyParts(t *testing.T) {
-	logger := &Logger{salt: "pepper"}
+	logger := &Logger{hashing: saltedHashing("pepper")}
 	got := logger.HashIdentity(" user ", "", "service")
 
 	expected := func() string {
@@ -305,3 +308,37 @@ func TestSanitizeDetails(t *testing.T) {
 		t.Fatalf("expected nil input to return nil, got %v", result)
 	}
 }
+
+func TestLoggerLogIncludesDeployment(t *testing.T) {
+	replica := deployment.Metadata{Region: "eu-west-1", Zone: "eu-west-1a", InstanceID: "gateway-7d9f"}
+	deployment.Set(replica)
+	t.Cleanup(func() { deployment.Set(deployment.Metadata{}) })
+
+	for _, version := range []string{SchemaVersion, LegacySchemaVersion} {
+		handler := &recordingHandler{}
+		logger := &Logger{logger: slog.New(handler), hashing: saltedHashing("salt"), version: version}
+		logger.Info(context.Background(), Event{Name: "upstream.call", Outcome: "success", Target: "orchestrator"})
+
+		fields := map[string]any{}
+		handler.records[0].Attrs(func(attr slog.Attr) bool {
+			fields[attr.Key] = attr.Value.Any()
+			return true
+		})
+		got, ok := fields["deployment"]
+		if version == LegacySchemaVersion {
+			if ok {
+				t.Fatal("expected version 1 records to omit deployment")
+			}
+			continue
+		}
+		if got != replica {
+			t.Fatalf("expected deployment %+v, got %v", replica, got)
+		}
+		encoded, _ := json.Marshal(fields)
+		var record map[string]any
+		_ = json.Unmarshal(encoded, &record)
+		if err := Validate(record); err != nil {
+			t.Fatalf("expected record to match the schema: %v", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Identity hashes are salted per deployment and per service, so they cannot
+// be joined with the orchestrator's. Correlation IDs follow a spec every
+// service implements the same way:
+//
+//	ihv1:<hex HMAC-SHA256(key, "ihv1" 0x00 kind 0x00 value)>
+//
+// key is GATEWAY_AUDIT_CORRELATION_KEY, a secret of at least 32 bytes that
+// each service loads from the same secrets provider entry; kind names the
+// identifier type (CorrelationTenant and so on) and value is the identifier
+// without surrounding whitespace. The version prefix lets a later spec change
+// the construction without its IDs being mistaken for these.
+const (
+	CorrelationVersion = "ihv1"
+
+	minCorrelationKeyBytes = 32
+)
+
+// Identifier kinds of correlation IDs.
+const (
+	CorrelationTenant  = "tenant"
+	CorrelationSession = "session"
+	CorrelationUser    = "user"
+)
+
+// CorrelationKinds lists the identifier kinds of the spec.
+var CorrelationKinds = []string{CorrelationTenant, CorrelationSession, CorrelationUser}
+
+// CorrelationID computes the correlation ID of value under key. It returns ""
+// for an empty value.
+func CorrelationID(key []byte, kind, value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(CorrelationVersion))
+	mac.Write([]byte{0})
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return CorrelationVersion + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CorrelationKey returns GATEWAY_AUDIT_CORRELATION_KEY, or its _FILE variant,
+// and nil when neither is set.
+func CorrelationKey() ([]byte, error) {
+	key, err := secretFromEnv("GATEWAY_AUDIT_CORRELATION_KEY")
+	if err != nil || key == "" {
+		return nil, err
+	}
+	if len(key) < minCorrelationKeyBytes {
+		return nil, fmt.Errorf("GATEWAY_AUDIT_CORRELATION_KEY must be at least %d bytes", minCorrelationKeyBytes)
+	}
+	return []byte(key), nil
+}
+
+// CorrelationID returns the correlation ID of value, or "" when no
+// correlation key is configured.
+func (l *Logger) CorrelationID(kind, value string) string {
+	if len(l.correlationKey) == 0 {
+		return ""
+	}
+	return CorrelationID(l.correlationKey, kind, value)
+}
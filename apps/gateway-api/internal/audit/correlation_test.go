@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCorrelationIDFollowsTheSpec(t *testing.T) {
+	key := []byte("ihv1-example-key-not-for-production-use")
+	// Published test vector: HMAC-SHA256(key, "ihv1\x00tenant\x00acme").
+	want := "ihv1:dcfefe65fcb4c59a9b370e5be31bf3a5701b58539dc83ba3be4cffab7bde5dc5"
+	if got := CorrelationID(key, CorrelationTenant, " acme "); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	if CorrelationID(key, CorrelationSession, "acme") == want {
+		t.Fatal("expected the kind to be part of the hash")
+	}
+	if got := CorrelationID(key, CorrelationTenant, " "); got != "" {
+		t.Fatalf("expected no ID for an empty value, got %s", got)
+	}
+}
+
+func TestCorrelationKey(t *testing.T) {
+	t.Setenv("GATEWAY_AUDIT_CORRELATION_KEY", "")
+	if key, err := CorrelationKey(); key != nil || err != nil {
+		t.Fatalf("expected correlation to be off by default, got %q %v", key, err)
+	}
+	if (&Logger{}).CorrelationID(CorrelationTenant, "acme") != "" {
+		t.Fatal("expected no correlation IDs without a key")
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "correlation-key")
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("c", minCorrelationKeyBytes)+"\n"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	t.Setenv("GATEWAY_AUDIT_CORRELATION_KEY_FILE", keyFile)
+	if key, err := CorrelationKey(); err != nil || string(key) != strings.Repeat("c", minCorrelationKeyBytes) {
+		t.Fatalf("expected the key from the file, got %q %v", key, err)
+	}
+
+	t.Setenv("GATEWAY_AUDIT_CORRELATION_KEY_FILE", "")
+	t.Setenv("GATEWAY_AUDIT_CORRELATION_KEY", "short")
+	if err := ValidateConfig(); err == nil {
+		t.Fatal("expected a short correlation key to be rejected")
+	}
+	if logger := Default(); logger.correlationKey != nil {
+		t.Fatal("expected Default to leave correlation off for an invalid key")
+	}
+}
+
+func TestLoggerLogIncludesCorrelation(t *testing.T) {
+	key := []byte(strings.Repeat("c", minCorrelationKeyBytes))
+	for _, version := range []string{SchemaVersion, LegacySchemaVersion} {
+		handler := &recordingHandler{}
+		logger := &Logger{logger: slog.New(handler), hashing: saltedHashing("salt"), correlationKey: key, version: version}
+		tenant := logger.CorrelationID(CorrelationTenant, "acme")
+		logger.Info(context.Background(), Event{Name: "upstream.call", Outcome: "success", Target: "orchestrator", Correlation: map[string]string{CorrelationTenant: tenant}})
+
+		fields := map[string]any{}
+		handler.records[0].Attrs(func(attr slog.Attr) bool {
+			fields[attr.Key] = attr.Value.Any()
+			return true
+		})
+		got, ok := fields["correlation"].(map[string]string)
+		if version == LegacySchemaVersion {
+			if ok {
+				t.Fatal("expected version 1 records to omit correlation")
+			}
+			continue
+		}
+		if got[CorrelationTenant] != tenant {
+			t.Fatalf("expected the tenant correlation ID, got %v", fields["correlation"])
+		}
+		encoded, _ := json.Marshal(fields)
+		var record map[string]any
+		_ = json.Unmarshal(encoded, &record)
+		if err := Validate(record); err != nil {
+			t.Fatalf("expected record to match the schema: %v", err)
+		}
+		record["correlation"] = map[string]any{CorrelationTenant: strings.TrimPrefix(tenant, CorrelationVersion+":")}
+		if err := Validate(record); err == nil {
+			t.Fatal("expected an unversioned correlation ID to be rejected")
+		}
+	}
+}
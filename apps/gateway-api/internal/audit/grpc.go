@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDMetadataKey carries the request identifier in gRPC metadata, the
+// counterpart of the X-Request-Id header.
+const requestIDMetadataKey = "x-request-id"
+
+// EnsureGRPCRequestID returns a context carrying the call's request
+// identifier, taken from the context, the incoming x-request-id metadata or a
+// new UUIDv4, in that order. It is the gRPC counterpart of EnsureRequestID.
+func EnsureGRPCRequestID(ctx context.Context) (context.Context, string) {
+	requestID := RequestID(ctx)
+	if requestID == "" {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, value := range md.Get(requestIDMetadataKey) {
+				if trimmed := strings.TrimSpace(value); trimmed != "" {
+					requestID = trimmed
+					break
+				}
+			}
+		}
+	}
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	if RequestID(ctx) == requestID {
+		return ctx, requestID
+	}
+	return context.WithValue(ctx, requestIDContextKey, requestID), requestID
+}
+
+// UnaryServerInterceptor seeds request IDs, echoes them in the response
+// header, records the hashed peer address as the actor unless one is already
+// set, and emits one audit event per call.
+func (l *Logger) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, requestID := l.prepareGRPCContext(ctx)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadataKey, requestID))
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		l.logGRPCCall(ctx, info.FullMethod, "unary", start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor. The event is emitted when the stream ends.
+func (l *Logger) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, requestID := l.prepareGRPCContext(ss.Context())
+		_ = ss.SetHeader(metadata.Pairs(requestIDMetadataKey, requestID))
+		start := time.Now()
+		err := handler(srv, &auditServerStream{ServerStream: ss, ctx: ctx})
+		kind := "server_stream"
+		switch {
+		case info.IsClientStream && info.IsServerStream:
+			kind = "bidi_stream"
+		case info.IsClientStream:
+			kind = "client_stream"
+		}
+		l.logGRPCCall(ctx, info.FullMethod, kind, start, err)
+		return err
+	}
+}
+
+func (l *Logger) prepareGRPCContext(ctx context.Context) (context.Context, string) {
+	ctx, requestID := EnsureGRPCRequestID(ctx)
+	if actorFromContext(ctx, "") == "" {
+		if addr := peerHost(ctx); addr != "" {
+			ctx = WithActor(ctx, l.HashIdentity(addr))
+		}
+	}
+	return ctx, requestID
+}
+
+// peerHost returns the remote host of the call without its port, so the
+// hashed identity matches the one HTTP handlers derive from the client IP.
+func peerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// logGRPCCall emits the call's audit event. Authentication and authorization
+// failures are security events, as denials are for HTTP handlers.
+func (l *Logger) logGRPCCall(ctx context.Context, method, kind string, start time.Time, err error) {
+	code := status.Code(err)
+	event := Event{
+		Name:   "grpc.call",
+		Target: method,
+		Details: SanitizeDetails(map[string]any{
+			"grpc_code":   code.String(),
+			"grpc_type":   kind,
+			"duration_ms": time.Since(start).Milliseconds(),
+		}),
+	}
+	switch code {
+	case codes.OK:
+		event.Outcome = "success"
+		l.Info(ctx, event)
+	case codes.Unauthenticated, codes.PermissionDenied:
+		event.Outcome = "denied"
+		l.Security(ctx, event)
+	default:
+		event.Outcome = "failure"
+		l.Error(ctx, event)
+	}
+}
+
+// auditServerStream exposes the interceptor's context to stream handlers.
+type auditServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *auditServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func recordAttrs(record slog.Record) map[string]any {
+	attrs := map[string]any{}
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value.Any()
+		return true
+	})
+	return attrs
+}
+
+func grpcTestContext(requestID string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 51000}})
+	if requestID != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-request-id", requestID))
+	}
+	return ctx
+}
+
+func TestUnaryServerInterceptorSeedsContextAndEmitsEvent(t *testing.T) {
+	handler := &recordingHandler{}
+	logger := &Logger{logger: slog.New(handler), hashing: saltedHashing("salt")}
+	interceptor := logger.UnaryServerInterceptor()
+
+	var seenID, seenActor string
+	_, err := interceptor(grpcTestContext("req-grpc"), "request", &grpc.UnaryServerInfo{FullMethod: "/agent.Agent/Invoke"},
+		func(ctx context.Context, req any) (any, error) {
+			seenID = RequestID(ctx)
+			seenActor = actorFromContext(ctx, "")
+			return "ok", nil
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if seenID != "req-grpc" {
+		t.Fatalf("expected request id from metadata, got %q", seenID)
+	}
+	if seenActor != logger.HashIdentity("203.0.113.9") {
+		t.Fatalf("expected hashed peer host as actor, got %q", seenActor)
+	}
+
+	if len(handler.records) != 1 {
+		t.Fatalf("expected one audit record, got %d", len(handler.records))
+	}
+	record := handler.records[0]
+	attrs := recordAttrs(record)
+	if record.Message != "gateway.audit.info" || attrs["event"] != "grpc.call" || attrs["outcome"] != "success" || attrs["target"] != "/agent.Agent/Invoke" {
+		t.Fatalf("unexpected record %s %v", record.Message, attrs)
+	}
+	if attrs["request_id"] != "req-grpc" || attrs["actor_id"] != seenActor {
+		t.Fatalf("expected request and actor ids on the event, got %v", attrs)
+	}
+	details := attrs["details"].(map[string]any)
+	if details["grpc_code"] != "OK" || details["grpc_type"] != "unary" {
+		t.Fatalf("unexpected details %v", details)
+	}
+}
+
+func TestUnaryServerInterceptorClassifiesFailures(t *testing.T) {
+	handler := &recordingHandler{}
+	logger := &Logger{logger: slog.New(handler), hashing: saltedHashing("salt")}
+	interceptor := logger.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/agent.Agent/Invoke"}
+
+	for _, code := range []codes.Code{codes.PermissionDenied, codes.Internal} {
+		_, err := interceptor(grpcTestContext(""), nil, info, func(context.Context, any) (any, error) {
+			return nil, status.Error(code, "nope")
+		})
+		if status.Code(err) != code {
+			t.Fatalf("expected handler error to pass through, got %v", err)
+		}
+	}
+
+	if len(handler.records) != 2 {
+		t.Fatalf("expected two audit records, got %d", len(handler.records))
+	}
+	denied, failed := recordAttrs(handler.records[0]), recordAttrs(handler.records[1])
+	if handler.records[0].Message != "gateway.audit.security" || denied["outcome"] != "denied" {
+		t.Fatalf("expected permission denied to be a security event, got %s %v", handler.records[0].Message, denied)
+	}
+	if handler.records[1].Message != "gateway.audit.error" || failed["outcome"] != "failure" {
+		t.Fatalf("expected internal errors to be failures, got %s %v", handler.records[1].Message, failed)
+	}
+	if id, _ := denied["request_id"].(string); id == "" {
+		t.Fatal("expected a generated request id")
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestStreamServerInterceptorWrapsContext(t *testing.T) {
+	handler := &recordingHandler{}
+	logger := &Logger{logger: slog.New(handler), hashing: saltedHashing("salt")}
+	stream := &fakeServerStream{ctx: grpcTestContext("req-stream")}
+
+	info := &grpc.StreamServerInfo{FullMethod: "/agent.Agent/Stream", IsServerStream: true, IsClientStream: true}
+	err := logger.StreamServerInterceptor()(nil, stream, info, func(srv any, ss grpc.ServerStream) error {
+		if RequestID(ss.Context()) != "req-stream" {
+			t.Fatalf("expected request id on the stream context, got %q", RequestID(ss.Context()))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := stream.header.Get("x-request-id"); len(got) != 1 || got[0] != "req-stream" {
+		t.Fatalf("expected request id response header, got %v", got)
+	}
+	details := recordAttrs(handler.records[0])["details"].(map[string]any)
+	if details["grpc_type"] != "bidi_stream" {
+		t.Fatalf("unexpected details %v", details)
+	}
+}
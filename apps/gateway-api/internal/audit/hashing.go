@@ -0,0 +1,230 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"time"
+)
+
+// Identity hashing algorithms selected by GATEWAY_AUDIT_HASH_ALGORITHM.
+// Both produce 64 lowercase hex characters, so switching between them does
+// not change the shape of audit records.
+const (
+	HashAlgorithmSHA256     = "sha256"
+	HashAlgorithmHMACSHA256 = "hmac-sha256"
+)
+
+const minHMACKeyBytes = 32
+
+// hashScheme is one algorithm and the salt or key it is used with.
+type hashScheme struct {
+	algorithm string
+	secret    []byte
+}
+
+// sum hashes parts under the scheme. A non-empty tenant salt replaces the
+// configured salt for sha256 and is mixed into the message for HMAC, whose
+// key stays the same for every tenant.
+func (s hashScheme) sum(tenantSalt string, parts []string) string {
+	var h hash.Hash
+	if s.algorithm == HashAlgorithmHMACSHA256 {
+		h = hmac.New(sha256.New, s.secret)
+		h.Write([]byte(tenantSalt))
+	} else {
+		h = sha256.New()
+		if tenantSalt != "" {
+			h.Write([]byte(tenantSalt))
+		} else {
+			h.Write(s.secret)
+		}
+	}
+	for _, part := range parts {
+		trimmed := strings.TrimSpace(part)
+		if trimmed == "" {
+			continue
+		}
+		h.Write([]byte("|"))
+		h.Write([]byte(trimmed))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashConfig is the identity hashing configuration read from the
+// environment.
+type hashConfig struct {
+	current       hashScheme
+	previous      *hashScheme
+	previousUntil time.Time
+	tenantSalts   map[string]string
+	// previousTenantSalts are the tenant salts the previous scheme is
+	// checked with; they default to tenantSalts.
+	previousTenantSalts map[string]string
+}
+
+// saltedHashing hashes with sha256 and a single shared salt, the behaviour
+// without any hashing configuration.
+func saltedHashing(salt string) hashConfig {
+	return hashConfig{current: hashScheme{algorithm: HashAlgorithmSHA256, secret: []byte(salt)}}
+}
+
+// configuredHashing reads the identity hashing settings. On error it still
+// returns a usable salted sha256 configuration so Default never fails; the
+// error is surfaced at startup through ValidateConfig.
+func configuredHashing() (hashConfig, error) {
+	salt := strings.TrimSpace(os.Getenv("GATEWAY_AUDIT_SALT"))
+	if salt == "" {
+		salt = defaultSalt
+	}
+	fallback := saltedHashing(salt)
+
+	algorithm, err := hashAlgorithmFromEnv("GATEWAY_AUDIT_HASH_ALGORITHM", HashAlgorithmSHA256)
+	if err != nil {
+		return fallback, err
+	}
+	cfg := hashConfig{current: fallback.current}
+	if algorithm == HashAlgorithmHMACSHA256 {
+		key, err := secretFromEnv("GATEWAY_AUDIT_HMAC_KEY")
+		if err != nil {
+			return fallback, err
+		}
+		if len(key) < minHMACKeyBytes {
+			return fallback, fmt.Errorf("GATEWAY_AUDIT_HMAC_KEY must be at least %d bytes when GATEWAY_AUDIT_HASH_ALGORITHM=%s", minHMACKeyBytes, HashAlgorithmHMACSHA256)
+		}
+		cfg.current = hashScheme{algorithm: HashAlgorithmHMACSHA256, secret: []byte(key)}
+	}
+
+	if cfg.tenantSalts, err = tenantSaltsFromEnv("GATEWAY_AUDIT_TENANT_SALTS"); err != nil {
+		return fallback, err
+	}
+
+	// A rotation window covers a new salt or key, new tenant salts, or both.
+	previousSecret, err := secretFromEnv("GATEWAY_AUDIT_PREVIOUS_HASH_SECRET")
+	if err != nil {
+		return fallback, err
+	}
+	previousTenantSalts, err := tenantSaltsFromEnv("GATEWAY_AUDIT_PREVIOUS_TENANT_SALTS")
+	if err != nil {
+		return fallback, err
+	}
+	if previousSecret != "" || previousTenantSalts != nil {
+		until, err := time.Parse(time.RFC3339, strings.TrimSpace(os.Getenv("GATEWAY_AUDIT_PREVIOUS_HASH_UNTIL")))
+		if err != nil {
+			return fallback, fmt.Errorf("GATEWAY_AUDIT_PREVIOUS_HASH_UNTIL must be an RFC 3339 time when GATEWAY_AUDIT_PREVIOUS_HASH_SECRET or GATEWAY_AUDIT_PREVIOUS_TENANT_SALTS is set")
+		}
+		previous := cfg.current
+		if previousSecret != "" {
+			previousAlgorithm, err := hashAlgorithmFromEnv("GATEWAY_AUDIT_PREVIOUS_HASH_ALGORITHM", cfg.current.algorithm)
+			if err != nil {
+				return fallback, err
+			}
+			previous = hashScheme{algorithm: previousAlgorithm, secret: []byte(previousSecret)}
+		}
+		cfg.previous = &previous
+		cfg.previousUntil = until
+		cfg.previousTenantSalts = cfg.tenantSalts
+		if previousTenantSalts != nil {
+			cfg.previousTenantSalts = previousTenantSalts
+		}
+	}
+	return cfg, nil
+}
+
+// tenantSaltsFromEnv reads a JSON object of tenant IDs to salts from key or
+// key_FILE, returning nil when neither is set.
+func tenantSaltsFromEnv(key string) (map[string]string, error) {
+	raw, err := secretFromEnv(key)
+	if err != nil || raw == "" {
+		return nil, err
+	}
+	var salts map[string]string
+	if err := json.Unmarshal([]byte(raw), &salts); err != nil {
+		return nil, fmt.Errorf("%s must be a JSON object of tenant IDs to salts: %w", key, err)
+	}
+	for tenant, tenantSalt := range salts {
+		if strings.TrimSpace(tenant) == "" || strings.TrimSpace(tenantSalt) == "" {
+			return nil, fmt.Errorf("%s entries must have a tenant ID and a salt", key)
+		}
+	}
+	if salts == nil {
+		salts = map[string]string{}
+	}
+	return salts, nil
+}
+
+func hashAlgorithmFromEnv(key, fallback string) (string, error) {
+	algorithm := strings.ToLower(strings.TrimSpace(os.Getenv(key)))
+	switch algorithm {
+	case "":
+		return fallback, nil
+	case HashAlgorithmSHA256, HashAlgorithmHMACSHA256:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("%s must be %s or %s, got %q", key, HashAlgorithmSHA256, HashAlgorithmHMACSHA256, algorithm)
+	}
+}
+
+// secretFromEnv reads key, preferring the file named by key_FILE, such as a
+// key a KMS agent or CSI secrets driver writes to a mounted volume.
+func secretFromEnv(key string) (string, error) {
+	if path := strings.TrimSpace(os.Getenv(key + "_FILE")); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s_FILE: %w", key, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return strings.TrimSpace(os.Getenv(key)), nil
+}
+
+// HashTenantIdentity hashes identity components that belong to tenant. When
+// GATEWAY_AUDIT_TENANT_SALTS configures a salt for the tenant it is used in
+// place of the shared one; otherwise the result equals HashIdentity(parts...).
+func (l *Logger) HashTenantIdentity(tenant string, parts ...string) string {
+	return l.hashing.current.sum(l.tenantSalt(tenant), parts)
+}
+
+// MatchIdentity reports whether stored was produced for the identity components
+// by the current hashing configuration or, until GATEWAY_AUDIT_PREVIOUS_HASH_UNTIL,
+// by the previous one. Pass an empty tenant for identities hashed with
+// HashIdentity.
+func (l *Logger) MatchIdentity(stored, tenant string, parts ...string) bool {
+	_, ok := l.Rehash(stored, tenant, parts...)
+	return ok
+}
+
+// Rehash returns the current hash of the identity components when stored is a
+// hash of them under the current or, within the rotation window, the
+// previous configuration, including the previous tenant salts. Stored correlation tables keyed by identity hashes
+// are migrated to a new salt or key with it before the window closes.
+func (l *Logger) Rehash(stored, tenant string, parts ...string) (string, bool) {
+	tenantSalt := l.tenantSalt(tenant)
+	current := l.hashing.current.sum(tenantSalt, parts)
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(current)) == 1 {
+		return current, true
+	}
+	if previous := l.hashing.previous; previous != nil && l.now().Before(l.hashing.previousUntil) {
+		previousSalt := l.hashing.previousTenantSalts[strings.TrimSpace(tenant)]
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(previous.sum(previousSalt, parts))) == 1 {
+			return current, true
+		}
+	}
+	return "", false
+}
+
+func (l *Logger) tenantSalt(tenant string) string {
+	return l.hashing.tenantSalts[strings.TrimSpace(tenant)]
+}
+
+func (l *Logger) now() time.Time {
+	if l.clock != nil {
+		return l.clock()
+	}
+	return time.Now()
+}
@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var hexHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func TestConfiguredHashing(t *testing.T) {
+	key := strings.Repeat("k", minHMACKeyBytes)
+	keyFile := filepath.Join(t.TempDir(), "audit-key")
+	if err := os.WriteFile(keyFile, []byte(key+"\n"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	t.Setenv("GATEWAY_AUDIT_HASH_ALGORITHM", "HMAC-SHA256")
+	t.Setenv("GATEWAY_AUDIT_HMAC_KEY_FILE", keyFile)
+	t.Setenv("GATEWAY_AUDIT_PREVIOUS_HASH_SECRET", "old-salt")
+	t.Setenv("GATEWAY_AUDIT_PREVIOUS_HASH_ALGORITHM", "sha256")
+	t.Setenv("GATEWAY_AUDIT_PREVIOUS_HASH_UNTIL", "2030-01-01T00:00:00Z")
+	t.Setenv("GATEWAY_AUDIT_TENANT_SALTS", `{"acme":"acme-salt"}`)
+
+	cfg, err := configuredHashing()
+	if err != nil {
+		t.Fatalf("configuredHashing: %v", err)
+	}
+	if cfg.current.algorithm != HashAlgorithmHMACSHA256 || string(cfg.current.secret) != key {
+		t.Fatalf("expected the HMAC key from the file, got %+v", cfg.current)
+	}
+	if cfg.previous == nil || cfg.previous.algorithm != HashAlgorithmSHA256 || cfg.previousUntil.Year() != 2030 {
+		t.Fatalf("unexpected previous configuration %+v until %v", cfg.previous, cfg.previousUntil)
+	}
+	if cfg.tenantSalts["acme"] != "acme-salt" {
+		t.Fatalf("unexpected tenant salts %v", cfg.tenantSalts)
+	}
+
+	for name, env := range map[string]map[string]string{
+		"unknown algorithm": {"GATEWAY_AUDIT_HASH_ALGORITHM": "md5"},
+		"short key":         {"GATEWAY_AUDIT_HMAC_KEY_FILE": "", "GATEWAY_AUDIT_HMAC_KEY": "short"},
+		"missing key file":  {"GATEWAY_AUDIT_HMAC_KEY_FILE": filepath.Join(t.TempDir(), "missing")},
+		"open window":       {"GATEWAY_AUDIT_PREVIOUS_HASH_UNTIL": ""},
+		"tenant salts":      {"GATEWAY_AUDIT_TENANT_SALTS": `["acme"]`},
+		"empty tenant salt": {"GATEWAY_AUDIT_TENANT_SALTS": `{"acme":" "}`},
+		"previous salts":    {"GATEWAY_AUDIT_PREVIOUS_TENANT_SALTS": `{"acme":""}`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range env {
+				t.Setenv(key, value)
+			}
+			if err := ValidateConfig(); err == nil {
+				t.Fatalf("expected %v to be rejected", env)
+			}
+			if logger := Default(); logger.hashing.current.algorithm != HashAlgorithmSHA256 {
+				t.Fatalf("expected Default to fall back to salted sha256, got %+v", logger.hashing.current)
+			}
+		})
+	}
+}
+
+func TestHashTenantIdentity(t *testing.T) {
+	logger := &Logger{hashing: saltedHashing("pepper")}
+	logger.hashing.tenantSalts = map[string]string{"acme": "acme-salt"}
+
+	if got := logger.HashTenantIdentity("globex", "session", "s-1"); got != logger.HashIdentity("session", "s-1") {
+		t.Fatalf("expected tenants without a salt to use the shared salt, got %s", got)
+	}
+	acme := logger.HashTenantIdentity("acme", "session", "s-1")
+	if acme == logger.HashIdentity("session", "s-1") || acme != (&Logger{hashing: saltedHashing("acme-salt")}).HashIdentity("session", "s-1") {
+		t.Fatalf("expected the tenant salt to replace the shared salt, got %s", acme)
+	}
+
+	keyed := &Logger{hashing: hashConfig{current: hashScheme{algorithm: HashAlgorithmHMACSHA256, secret: []byte("pepper")}}}
+	hmacHash := keyed.HashIdentity("session", "s-1")
+	if !hexHashPattern.MatchString(hmacHash) || hmacHash == logger.HashIdentity("session", "s-1") {
+		t.Fatalf("expected a distinct 64 character hex HMAC, got %s", hmacHash)
+	}
+	keyed.hashing.tenantSalts = logger.hashing.tenantSalts
+	if keyed.HashTenantIdentity("acme", "session", "s-1") == hmacHash {
+		t.Fatal("expected the tenant salt to change HMAC hashes")
+	}
+}
+
+func TestRehashAcceptsPreviousConfigurationDuringWindow(t *testing.T) {
+	previous := &Logger{hashing: saltedHashing("old-salt")}
+	stored := previous.HashIdentity("tenant", "acme")
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	logger := &Logger{
+		hashing: hashConfig{
+			current:       hashScheme{algorithm: HashAlgorithmHMACSHA256, secret: []byte(strings.Repeat("k", minHMACKeyBytes))},
+			previous:      &previous.hashing.current,
+			previousUntil: now.Add(time.Hour),
+		},
+		clock: func() time.Time { return now },
+	}
+	current := logger.HashIdentity("tenant", "acme")
+
+	if rehashed, ok := logger.Rehash(stored, "", "tenant", "acme"); !ok || rehashed != current {
+		t.Fatalf("expected the previous hash to be re-hashed, got %q %v", rehashed, ok)
+	}
+	if !logger.MatchIdentity(current, "", "tenant", "acme") {
+		t.Fatal("expected the current hash to match")
+	}
+	if logger.MatchIdentity(stored, "", "tenant", "globex") {
+		t.Fatal("expected a hash of other components not to match")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok := logger.Rehash(stored, "", "tenant", "acme"); ok {
+		t.Fatal("expected the previous hash to be refused after the window")
+	}
+}
+
+func TestRehashAcceptsPreviousTenantSalts(t *testing.T) {
+	t.Setenv("GATEWAY_AUDIT_SALT", "pepper")
+	t.Setenv("GATEWAY_AUDIT_TENANT_SALTS", `{"acme":"new-acme-salt"}`)
+	t.Setenv("GATEWAY_AUDIT_PREVIOUS_TENANT_SALTS", `{"acme":"old-acme-salt"}`)
+	t.Setenv("GATEWAY_AUDIT_PREVIOUS_HASH_UNTIL", "2030-01-01T00:00:00Z")
+	cfg, err := configuredHashing()
+	if err != nil {
+		t.Fatalf("configuredHashing: %v", err)
+	}
+	now := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+	logger := &Logger{hashing: cfg, clock: func() time.Time { return now }}
+	stored := (&Logger{hashing: saltedHashing("old-acme-salt")}).HashIdentity("username", "alice")
+
+	rehashed, ok := logger.Rehash(stored, "acme", "username", "alice")
+	if !ok || rehashed != logger.HashTenantIdentity("acme", "username", "alice") {
+		t.Fatalf("expected a hash under the previous tenant salt to be re-hashed, got %q %v", rehashed, ok)
+	}
+	if logger.MatchIdentity(stored, "globex", "username", "alice") {
+		t.Fatal("expected the previous salt to apply only to its tenant")
+	}
+	now = time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	if logger.MatchIdentity(stored, "acme", "username", "alice") {
+		t.Fatal("expected the previous tenant salt to be refused after the window")
+	}
+}
@@ -0,0 +1,423 @@
+package audit
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Schema versions of the emitted audit record. Version 1 is the format used
+// before records carried schema_version; it is still emitted when
+// GATEWAY_AUDIT_SCHEMA_VERSION=1 so existing SIEM parsers keep working while
+// they migrate.
+const (
+	SchemaVersion       = "2"
+	LegacySchemaVersion = "1"
+)
+
+//go:embed schema/*.json
+var schemaFiles embed.FS
+
+var (
+	schemaMu    sync.Mutex
+	schemaCache = make(map[string]*compiledSchema)
+)
+
+// Schema returns the embedded JSON Schema describing records of the given
+// version.
+func Schema(version string) ([]byte, error) {
+	data, err := schemaFiles.ReadFile("schema/v" + version + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("unknown audit schema version %q", version)
+	}
+	return data, nil
+}
+
+// ValidateConfig checks GATEWAY_AUDIT_SCHEMA_VERSION, the identity hashing
+// settings and the correlation key at startup.
+func ValidateConfig() error {
+	if _, err := configuredSchemaVersion(); err != nil {
+		return err
+	}
+	if _, err := configuredHashing(); err != nil {
+		return err
+	}
+	_, err := CorrelationKey()
+	return err
+}
+
+func configuredSchemaVersion() (string, error) {
+	version := strings.TrimPrefix(strings.TrimSpace(os.Getenv("GATEWAY_AUDIT_SCHEMA_VERSION")), "v")
+	switch version {
+	case "":
+		return SchemaVersion, nil
+	case SchemaVersion, LegacySchemaVersion:
+		return version, nil
+	default:
+		return SchemaVersion, fmt.Errorf("GATEWAY_AUDIT_SCHEMA_VERSION must be %s or %s, got %q", LegacySchemaVersion, SchemaVersion, version)
+	}
+}
+
+// Validate checks a decoded audit record against the schema named by its
+// schema_version field. Records without the field are version 1.
+func Validate(record map[string]any) error {
+	version := LegacySchemaVersion
+	if raw, ok := record["schema_version"]; ok {
+		text, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("schema_version must be a string")
+		}
+		version = text
+	}
+	schema, err := loadSchema(version)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	schema.validate(record, "$", &problems)
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("audit event %v does not match schema v%s: %s", record["event"], version, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func loadSchema(version string) (*compiledSchema, error) {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	if schema, ok := schemaCache[version]; ok {
+		return schema, nil
+	}
+	data, err := Schema(version)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("audit schema v%s: %w", version, err)
+	}
+	schema, err := compileSchema(raw, raw, "#")
+	if err != nil {
+		return nil, fmt.Errorf("audit schema v%s: %w", version, err)
+	}
+	schemaCache[version] = schema
+	return schema, nil
+}
+
+// compiledSchema is the subset of JSON Schema the audit schemas use.
+// Compilation rejects any other keyword, so the embedded documents cannot
+// rely on rules that would silently go unchecked here.
+type compiledSchema struct {
+	types                []string
+	constant             any
+	hasConst             bool
+	enum                 []any
+	pattern              *regexp.Regexp
+	required             []string
+	properties           map[string]*compiledSchema
+	additionalProperties *compiledSchema
+	noAdditional         bool
+	propertyNames        *compiledSchema
+	items                *compiledSchema
+	allOf                []*compiledSchema
+	ifSchema             *compiledSchema
+	thenSchema           *compiledSchema
+}
+
+var annotationKeywords = []string{"$schema", "$id", "$comment", "$defs", "title", "description", "examples"}
+
+func compileSchema(root, raw map[string]any, path string) (*compiledSchema, error) {
+	if ref, ok := raw["$ref"].(string); ok {
+		if len(raw) != 1 {
+			return nil, fmt.Errorf("%s: $ref cannot be combined with other keywords", path)
+		}
+		target, err := resolveRef(root, ref)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return compileSchema(root, target, ref)
+	}
+
+	schema := &compiledSchema{}
+	sub := func(keyword string, value any) (*compiledSchema, error) {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/%s must be a schema object", path, keyword)
+		}
+		return compileSchema(root, object, path+"/"+keyword)
+	}
+	var err error
+	for keyword, value := range raw {
+		switch keyword {
+		case "type":
+			switch v := value.(type) {
+			case string:
+				schema.types = []string{v}
+			case []any:
+				for _, item := range v {
+					name, _ := item.(string)
+					schema.types = append(schema.types, name)
+				}
+			}
+		case "const":
+			schema.constant, schema.hasConst = value, true
+		case "enum":
+			values, ok := value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%s/enum must be an array", path)
+			}
+			schema.enum = values
+		case "pattern":
+			text, _ := value.(string)
+			if schema.pattern, err = regexp.Compile(text); err != nil {
+				return nil, fmt.Errorf("%s/pattern: %w", path, err)
+			}
+		case "required":
+			values, _ := value.([]any)
+			for _, item := range values {
+				name, _ := item.(string)
+				schema.required = append(schema.required, name)
+			}
+		case "properties":
+			object, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s/properties must be an object", path)
+			}
+			schema.properties = make(map[string]*compiledSchema, len(object))
+			for name, property := range object {
+				if schema.properties[name], err = sub("properties/"+name, property); err != nil {
+					return nil, err
+				}
+			}
+		case "additionalProperties":
+			if allowed, ok := value.(bool); ok {
+				schema.noAdditional = !allowed
+				continue
+			}
+			if schema.additionalProperties, err = sub(keyword, value); err != nil {
+				return nil, err
+			}
+		case "propertyNames":
+			if schema.propertyNames, err = sub(keyword, value); err != nil {
+				return nil, err
+			}
+		case "items":
+			if schema.items, err = sub(keyword, value); err != nil {
+				return nil, err
+			}
+		case "allOf":
+			values, ok := value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%s/allOf must be an array", path)
+			}
+			for i, item := range values {
+				compiled, err := sub(fmt.Sprintf("allOf/%d", i), item)
+				if err != nil {
+					return nil, err
+				}
+				schema.allOf = append(schema.allOf, compiled)
+			}
+		case "if":
+			if schema.ifSchema, err = sub(keyword, value); err != nil {
+				return nil, err
+			}
+		case "then":
+			if schema.thenSchema, err = sub(keyword, value); err != nil {
+				return nil, err
+			}
+		default:
+			if !slices.Contains(annotationKeywords, keyword) {
+				return nil, fmt.Errorf("%s: unsupported keyword %q", path, keyword)
+			}
+		}
+	}
+	return schema, nil
+}
+
+func resolveRef(root map[string]any, ref string) (map[string]any, error) {
+	name, ok := strings.CutPrefix(ref, "#/$defs/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	defs, _ := root["$defs"].(map[string]any)
+	target, ok := defs[name].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unresolved $ref %q", ref)
+	}
+	return target, nil
+}
+
+func (s *compiledSchema) validate(value any, path string, problems *[]string) {
+	fail := func(format string, args ...any) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(name string) bool { return matchesType(name, value) }) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), jsonType(value))
+		return
+	}
+	if s.hasConst && !jsonEqual(s.constant, value) {
+		fail("expected %v, got %v", s.constant, value)
+	}
+	if s.enum != nil && !slices.ContainsFunc(s.enum, func(allowed any) bool { return jsonEqual(allowed, value) }) {
+		fail("%v is not one of %v", value, s.enum)
+	}
+	if text, ok := value.(string); ok && s.pattern != nil && !s.pattern.MatchString(text) {
+		fail("%q does not match %s", text, s.pattern)
+	}
+	if object, ok := value.(map[string]any); ok {
+		for _, name := range s.required {
+			if _, ok := object[name]; !ok {
+				fail("missing required field %q", name)
+			}
+		}
+		for name, field := range object {
+			if s.propertyNames != nil {
+				s.propertyNames.validate(name, path+"."+name+" (name)", problems)
+			}
+			if property, ok := s.properties[name]; ok {
+				property.validate(field, path+"."+name, problems)
+				continue
+			}
+			if s.noAdditional {
+				fail("unexpected field %q", name)
+			} else if s.additionalProperties != nil {
+				s.additionalProperties.validate(field, path+"."+name, problems)
+			}
+		}
+	}
+	if list, ok := value.([]any); ok && s.items != nil {
+		for i, item := range list {
+			s.items.validate(item, fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	}
+	for _, sub := range s.allOf {
+		sub.validate(value, path, problems)
+	}
+	if s.ifSchema != nil && s.thenSchema != nil {
+		var conditional []string
+		s.ifSchema.validate(value, path, &conditional)
+		if len(conditional) == 0 {
+			s.thenSchema.validate(value, path, problems)
+		}
+	}
+}
+
+func matchesType(name string, value any) bool {
+	switch name {
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonType(value) == name
+	}
+}
+
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func jsonEqual(a, b any) bool {
+	left, errLeft := json.Marshal(a)
+	right, errRight := json.Marshal(b)
+	return errLeft == nil && errRight == nil && string(left) == string(right)
+}
+
+var validation struct {
+	mu         sync.Mutex
+	enabled    bool
+	violations []error
+}
+
+// EnableValidation makes every Logger check the records it writes against
+// the embedded schema. Violations do not stop the write; they are collected
+// for Violations. Test packages that emit audit events enable it from
+// TestMain so a changed field or detail key fails the build instead of a
+// downstream parser.
+func EnableValidation() {
+	validation.mu.Lock()
+	defer validation.mu.Unlock()
+	validation.enabled = true
+}
+
+// SuspendValidation turns validation off until the returned function is
+// called, so benchmarks measure the logger rather than the schema check.
+func SuspendValidation() (resume func()) {
+	validation.mu.Lock()
+	defer validation.mu.Unlock()
+	enabled := validation.enabled
+	validation.enabled = false
+	return func() {
+		validation.mu.Lock()
+		defer validation.mu.Unlock()
+		validation.enabled = enabled
+	}
+}
+
+// Violations returns and clears the schema violations recorded since
+// validation was enabled.
+func Violations() []error {
+	validation.mu.Lock()
+	defer validation.mu.Unlock()
+	violations := validation.violations
+	validation.violations = nil
+	return violations
+}
+
+func validationEnabled() bool {
+	validation.mu.Lock()
+	defer validation.mu.Unlock()
+	return validation.enabled
+}
+
+func recordViolation(err error) {
+	validation.mu.Lock()
+	defer validation.mu.Unlock()
+	validation.violations = append(validation.violations, err)
+}
+
+// checkRecord validates attrs as they would be serialised by a JSON handler.
+func checkRecord(attrs []slog.Attr) {
+	fields := make(map[string]any, len(attrs))
+	for _, attr := range attrs {
+		fields[attr.Key] = attr.Value.Any()
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		recordViolation(fmt.Errorf("audit event %v is not serialisable: %w", fields["event"], err))
+		return
+	}
+	var record map[string]any
+	if err := json.Unmarshal(encoded, &record); err != nil {
+		recordViolation(err)
+		return
+	}
+	if err := Validate(record); err != nil {
+		recordViolation(err)
+	}
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func recordedFields(t *testing.T, handler *recordingHandler) map[string]any {
+	t.Helper()
+	if len(handler.records) != 1 {
+		t.Fatalf("expected one record, got %d", len(handler.records))
+	}
+	fields := map[string]any{}
+	handler.records[0].Attrs(func(attr slog.Attr) bool {
+		fields[attr.Key] = attr.Value.Any()
+		return true
+	})
+	handler.records = nil
+	return fields
+}
+
+func TestEmbeddedSchemasCompile(t *testing.T) {
+	for _, version := range []string{LegacySchemaVersion, SchemaVersion} {
+		data, err := Schema(version)
+		if err != nil {
+			t.Fatalf("schema v%s: %v", version, err)
+		}
+		if !json.Valid(data) {
+			t.Fatalf("schema v%s is not valid JSON", version)
+		}
+		if _, err := loadSchema(version); err != nil {
+			t.Fatalf("compile schema v%s: %v", version, err)
+		}
+	}
+	if _, err := Schema("9"); err == nil {
+		t.Fatal("expected unknown schema version to be rejected")
+	}
+}
+
+func TestCompileSchemaRejectsUnsupportedKeywords(t *testing.T) {
+	raw := map[string]any{"type": "object", "properties": map[string]any{"event": map[string]any{"minLength": 1.0}}}
+	if _, err := compileSchema(raw, raw, "#"); err == nil || !strings.Contains(err.Error(), "minLength") {
+		t.Fatalf("expected unsupported keyword error, got %v", err)
+	}
+}
+
+func TestLoggerEmitsSchemaVersion(t *testing.T) {
+	handler := &recordingHandler{}
+	logger := &Logger{logger: slog.New(handler), hashing: saltedHashing("salt"), version: SchemaVersion}
+	event := Event{Name: "scim.provisioning", Outcome: "success", Target: "scim.users"}
+
+	logger.Info(context.Background(), event)
+	if fields := recordedFields(t, handler); fields["schema_version"] != SchemaVersion {
+		t.Fatalf("expected schema_version %s, got %v", SchemaVersion, fields["schema_version"])
+	}
+
+	legacy := &Logger{logger: slog.New(handler), hashing: saltedHashing("salt"), version: LegacySchemaVersion}
+	legacy.Info(context.Background(), event)
+	if fields := recordedFields(t, handler); fields["schema_version"] != nil {
+		t.Fatalf("expected legacy record without schema_version, got %v", fields["schema_version"])
+	}
+
+	event.SchemaVersion = SchemaVersion
+	legacy.Info(context.Background(), event)
+	if fields := recordedFields(t, handler); fields["schema_version"] != SchemaVersion {
+		t.Fatalf("expected event to pin schema_version, got %v", fields["schema_version"])
+	}
+}
+
+func TestDefaultReadsSchemaVersion(t *testing.T) {
+	t.Setenv("GATEWAY_AUDIT_SCHEMA_VERSION", "v1")
+	if logger := Default(); logger.version != LegacySchemaVersion {
+		t.Fatalf("expected legacy version, got %q", logger.version)
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	t.Setenv("GATEWAY_AUDIT_SCHEMA_VERSION", "3")
+	if err := ValidateConfig(); err == nil {
+		t.Fatal("expected unsupported version to be rejected")
+	}
+	if logger := Default(); logger.version != SchemaVersion {
+		t.Fatalf("expected fallback to the current version, got %q", logger.version)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() map[string]any {
+		return map[string]any{
+			"schema_version": SchemaVersion,
+			"event":          "grpc.call",
+			"outcome":        "success",
+			"target":         "/svc.Plans/Get",
+			"details":        map[string]any{"grpc_code": "OK", "grpc_type": "unary", "duration_ms": 3.0},
+		}
+	}
+	if err := Validate(valid()); err != nil {
+		t.Fatalf("expected valid record, got %v", err)
+	}
+
+	for name, mutate := range map[string]func(map[string]any){
+		"unknown detail key": func(r map[string]any) { r["details"].(map[string]any)["grpcCode"] = "OK" },
+		"unknown event":      func(r map[string]any) { r["event"] = "grpc.stream" },
+		"unknown outcome":    func(r map[string]any) { r["outcome"] = "ok" },
+		"missing target":     func(r map[string]any) { delete(r, "target") },
+		"unexpected field":   func(r map[string]any) { r["tenant"] = "acme" },
+		"wrong type":         func(r map[string]any) { r["actor_id"] = 42.0 },
+		"unknown version":    func(r map[string]any) { r["schema_version"] = "7" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			record := valid()
+			mutate(record)
+			if err := Validate(record); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+
+	// Records without schema_version are checked against the legacy format,
+	// which leaves event names and details open.
+	legacy := valid()
+	delete(legacy, "schema_version")
+	legacy["event"] = "plan.approved"
+	legacy["details"] = map[string]any{"anyKey": true}
+	if err := Validate(legacy); err != nil {
+		t.Fatalf("expected legacy record to validate, got %v", err)
+	}
+}
+
+func TestValidationModeRecordsViolations(t *testing.T) {
+	EnableValidation()
+	t.Cleanup(func() {
+		validation.mu.Lock()
+		validation.enabled = false
+		validation.mu.Unlock()
+		Violations()
+	})
+	logger := &Logger{logger: slog.New(&recordingHandler{}), hashing: saltedHashing("salt"), version: SchemaVersion}
+
+	logger.Info(context.Background(), Event{Name: "scim.provisioning", Outcome: "success", Target: "scim.users", Details: map[string]any{"status_code": 201}})
+	if violations := Violations(); len(violations) != 0 {
+		t.Fatalf("expected no violations, got %v", violations)
+	}
+
+	logger.Security(context.Background(), Event{Name: "scim.provisioning", Outcome: "denied", Target: "scim.users", Details: map[string]any{"userId": "u-1"}})
+	violations := Violations()
+	if len(violations) != 1 || !strings.Contains(violations[0].Error(), "userId") {
+		t.Fatalf("expected a violation naming the detail key, got %v", violations)
+	}
+}
@@ -0,0 +1,301 @@
+// Package deployment describes where a gateway replica runs: its region,
+// zone, cluster and instance. The metadata is resolved once at startup and
+// attached to audit records, metrics and the OpenTelemetry resource so
+// replicas in different regions can be told apart.
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Cloud metadata services GATEWAY_DEPLOYMENT_METADATA may name.
+const (
+	ProviderNone  = "none"
+	ProviderAWS   = "aws"
+	ProviderGCP   = "gcp"
+	ProviderAzure = "azure"
+
+	// ResolveTimeout bounds the metadata service queries made at startup.
+	ResolveTimeout = 3 * time.Second
+
+	maxMetadataBody = 4096
+)
+
+var (
+	valuePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`)
+
+	// metadataEndpoints are the metadata service base URLs, replaced in
+	// tests.
+	metadataEndpoints = map[string]string{
+		ProviderAWS:   "http://169.254.169.254",
+		ProviderGCP:   "http://metadata.google.internal",
+		ProviderAzure: "http://169.254.169.254",
+	}
+	// metadataClient talks to the link-local metadata service directly; an
+	// egress proxy could not reach it.
+	metadataClient = &http.Client{Transport: &http.Transport{Proxy: nil}}
+)
+
+// Metadata identifies a gateway replica. Empty fields are unknown.
+type Metadata struct {
+	Region     string `json:"region,omitempty"`
+	Zone       string `json:"zone,omitempty"`
+	Cluster    string `json:"cluster,omitempty"`
+	InstanceID string `json:"instance_id,omitempty"`
+}
+
+var (
+	currentMu sync.RWMutex
+	current   Metadata
+)
+
+// Current returns the metadata recorded by Set, or zero Metadata before
+// startup resolved it.
+func Current() Metadata {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
+
+// Set records the metadata of this process.
+func Set(m Metadata) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = m
+}
+
+// IsZero reports whether nothing is known about the deployment.
+func (m Metadata) IsZero() bool {
+	return m == Metadata{}
+}
+
+// ValidateConfig checks GATEWAY_DEPLOYMENT_METADATA and the GATEWAY_REGION,
+// GATEWAY_ZONE, GATEWAY_CLUSTER and GATEWAY_INSTANCE_ID values at startup.
+func ValidateConfig() error {
+	if _, err := configuredProvider(); err != nil {
+		return err
+	}
+	_, err := fromEnv()
+	return err
+}
+
+func configuredProvider() (string, error) {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("GATEWAY_DEPLOYMENT_METADATA")))
+	switch provider {
+	case "":
+		return ProviderNone, nil
+	case ProviderNone, ProviderAWS, ProviderGCP, ProviderAzure:
+		return provider, nil
+	default:
+		return "", fmt.Errorf("unsupported GATEWAY_DEPLOYMENT_METADATA %q (expected none, aws, gcp or azure)", provider)
+	}
+}
+
+func fromEnv() (Metadata, error) {
+	var m Metadata
+	for _, field := range []struct {
+		env   string
+		value *string
+	}{
+		{"GATEWAY_REGION", &m.Region},
+		{"GATEWAY_ZONE", &m.Zone},
+		{"GATEWAY_CLUSTER", &m.Cluster},
+		{"GATEWAY_INSTANCE_ID", &m.InstanceID},
+	} {
+		value := strings.TrimSpace(os.Getenv(field.env))
+		if value != "" && !valuePattern.MatchString(value) {
+			return Metadata{}, fmt.Errorf("%s must be at most 128 letters, digits, '.', '_', ':' or '-'", field.env)
+		}
+		*field.value = value
+	}
+	return m, nil
+}
+
+// Resolve reads GATEWAY_REGION, GATEWAY_ZONE, GATEWAY_CLUSTER and
+// GATEWAY_INSTANCE_ID and fills the fields they leave empty from the cloud
+// metadata service named by GATEWAY_DEPLOYMENT_METADATA. The instance ID
+// falls back to the host name, which is the pod name on Kubernetes. When the
+// metadata service fails, Resolve returns what it knows with the error.
+func Resolve(ctx context.Context) (Metadata, error) {
+	provider, err := configuredProvider()
+	if err != nil {
+		return Metadata{}, err
+	}
+	m, err := fromEnv()
+	if err != nil {
+		return Metadata{}, err
+	}
+	var lookupErr error
+	if provider != ProviderNone {
+		var fetched Metadata
+		fetched, lookupErr = fetchMetadata(ctx, provider)
+		m = m.fill(fetched)
+	}
+	if m.InstanceID == "" {
+		if host, err := os.Hostname(); err == nil && valuePattern.MatchString(host) {
+			m.InstanceID = host
+		}
+	}
+	return m, lookupErr
+}
+
+// fill returns m with its empty fields taken from other.
+func (m Metadata) fill(other Metadata) Metadata {
+	for _, field := range []struct{ dst, src *string }{
+		{&m.Region, &other.Region},
+		{&m.Zone, &other.Zone},
+		{&m.Cluster, &other.Cluster},
+		{&m.InstanceID, &other.InstanceID},
+	} {
+		if *field.dst == "" && valuePattern.MatchString(*field.src) {
+			*field.dst = *field.src
+		}
+	}
+	return m
+}
+
+func fetchMetadata(ctx context.Context, provider string) (Metadata, error) {
+	base := metadataEndpoints[provider]
+	switch provider {
+	case ProviderAWS:
+		// IMDSv2: every read presents a session token.
+		token, err := metadataGet(ctx, http.MethodPut, base+"/latest/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+		if err != nil {
+			return Metadata{}, fmt.Errorf("aws metadata token: %w", err)
+		}
+		headers := map[string]string{"X-aws-ec2-metadata-token": token}
+		var m Metadata
+		for _, field := range []struct {
+			path  string
+			value *string
+		}{
+			{"/latest/meta-data/placement/region", &m.Region},
+			{"/latest/meta-data/placement/availability-zone", &m.Zone},
+			{"/latest/meta-data/instance-id", &m.InstanceID},
+		} {
+			value, err := metadataGet(ctx, http.MethodGet, base+field.path, headers)
+			if err != nil {
+				return m, fmt.Errorf("aws metadata %s: %w", field.path, err)
+			}
+			*field.value = value
+		}
+		return m, nil
+	case ProviderGCP:
+		headers := map[string]string{"Metadata-Flavor": "Google"}
+		zone, err := metadataGet(ctx, http.MethodGet, base+"/computeMetadata/v1/instance/zone", headers)
+		if err != nil {
+			return Metadata{}, fmt.Errorf("gcp metadata zone: %w", err)
+		}
+		// projects/<number>/zones/us-central1-a
+		zone = zone[strings.LastIndex(zone, "/")+1:]
+		m := Metadata{Zone: zone}
+		if i := strings.LastIndex(zone, "-"); i > 0 {
+			m.Region = zone[:i]
+		}
+		if m.InstanceID, err = metadataGet(ctx, http.MethodGet, base+"/computeMetadata/v1/instance/id", headers); err != nil {
+			return m, fmt.Errorf("gcp metadata instance id: %w", err)
+		}
+		// Only GKE nodes carry the cluster name.
+		m.Cluster, _ = metadataGet(ctx, http.MethodGet, base+"/computeMetadata/v1/instance/attributes/cluster-name", headers)
+		return m, nil
+	case ProviderAzure:
+		raw, err := metadataGet(ctx, http.MethodGet, base+"/metadata/instance/compute?api-version=2021-02-01&format=json", map[string]string{"Metadata": "true"})
+		if err != nil {
+			return Metadata{}, fmt.Errorf("azure metadata: %w", err)
+		}
+		var compute struct {
+			Location string `json:"location"`
+			Zone     string `json:"zone"`
+			VMID     string `json:"vmId"`
+		}
+		if err := json.Unmarshal([]byte(raw), &compute); err != nil {
+			return Metadata{}, fmt.Errorf("azure metadata: %w", err)
+		}
+		m := Metadata{Region: compute.Location, InstanceID: compute.VMID}
+		if compute.Zone != "" {
+			// Azure numbers zones within the region.
+			m.Zone = compute.Location + "-" + compute.Zone
+		}
+		return m, nil
+	}
+	return Metadata{}, nil
+}
+
+func metadataGet(ctx context.Context, method, url string, headers map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMetadataBody))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// MetricAttributes labels metrics with the region, zone and cluster. The
+// instance ID is left out: it changes with every rollout, so it would grow
+// the label set without bound.
+func (m Metadata) MetricAttributes() []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if m.Region != "" {
+		attrs = append(attrs, attribute.String("region", m.Region))
+	}
+	if m.Zone != "" {
+		attrs = append(attrs, attribute.String("zone", m.Zone))
+	}
+	if m.Cluster != "" {
+		attrs = append(attrs, attribute.String("cluster", m.Cluster))
+	}
+	return attrs
+}
+
+// MergeResourceAttributes adds the metadata, under the OpenTelemetry semantic
+// convention keys, to an OTEL_RESOURCE_ATTRIBUTES value. Keys already in
+// existing are kept, so operators can override them.
+func (m Metadata) MergeResourceAttributes(existing string) string {
+	entries := []string{}
+	present := make(map[string]bool)
+	for _, entry := range strings.Split(existing, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(entry, "=")
+		present[strings.TrimSpace(key)] = true
+		entries = append(entries, entry)
+	}
+	for _, attr := range []struct{ key, value string }{
+		{"cloud.region", m.Region},
+		{"cloud.availability_zone", m.Zone},
+		{"k8s.cluster.name", m.Cluster},
+		{"service.instance.id", m.InstanceID},
+	} {
+		if attr.value != "" && !present[attr.key] {
+			entries = append(entries, attr.key+"="+attr.value)
+		}
+	}
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,155 @@
+package deployment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func useMetadataServer(t *testing.T, provider string, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	previous := metadataEndpoints[provider]
+	metadataEndpoints[provider] = server.URL
+	t.Cleanup(func() { metadataEndpoints[provider] = previous })
+	t.Setenv("GATEWAY_DEPLOYMENT_METADATA", provider)
+}
+
+func clearDeploymentEnv(t *testing.T) {
+	for _, name := range []string{"GATEWAY_DEPLOYMENT_METADATA", "GATEWAY_REGION", "GATEWAY_ZONE", "GATEWAY_CLUSTER", "GATEWAY_INSTANCE_ID"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestResolvePrefersEnvironment(t *testing.T) {
+	clearDeploymentEnv(t)
+	t.Setenv("GATEWAY_REGION", "eu-west-1")
+	t.Setenv("GATEWAY_CLUSTER", "prod-eu")
+	t.Setenv("GATEWAY_INSTANCE_ID", "gateway-7d9f")
+	useMetadataServer(t, ProviderAWS, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			if r.Method != http.MethodPut {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			_, _ = w.Write([]byte("token"))
+		case "/latest/meta-data/placement/region":
+			_, _ = w.Write([]byte("us-east-1"))
+		case "/latest/meta-data/placement/availability-zone":
+			if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte("us-east-1b"))
+		case "/latest/meta-data/instance-id":
+			_, _ = w.Write([]byte("i-0123456789"))
+		}
+	})
+
+	m, err := Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Metadata{Region: "eu-west-1", Zone: "us-east-1b", Cluster: "prod-eu", InstanceID: "gateway-7d9f"}
+	if m != want {
+		t.Fatalf("expected %+v, got %+v", want, m)
+	}
+}
+
+func TestResolveReadsCloudMetadata(t *testing.T) {
+	cases := map[string]struct {
+		handler http.HandlerFunc
+		want    Metadata
+	}{
+		ProviderGCP: {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Metadata-Flavor") != "Google" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				switch r.URL.Path {
+				case "/computeMetadata/v1/instance/zone":
+					_, _ = w.Write([]byte("projects/123/zones/us-central1-a"))
+				case "/computeMetadata/v1/instance/id":
+					_, _ = w.Write([]byte("4567"))
+				case "/computeMetadata/v1/instance/attributes/cluster-name":
+					_, _ = w.Write([]byte("prod-us"))
+				}
+			},
+			want: Metadata{Region: "us-central1", Zone: "us-central1-a", Cluster: "prod-us", InstanceID: "4567"},
+		},
+		ProviderAzure: {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Metadata") != "true" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				_, _ = w.Write([]byte(`{"location":"westeurope","zone":"2","vmId":"0f1e2d3c"}`))
+			},
+			want: Metadata{Region: "westeurope", Zone: "westeurope-2", InstanceID: "0f1e2d3c"},
+		},
+	}
+	for provider, tc := range cases {
+		t.Run(provider, func(t *testing.T) {
+			clearDeploymentEnv(t)
+			useMetadataServer(t, provider, tc.handler)
+			m, err := Resolve(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if m != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, m)
+			}
+		})
+	}
+}
+
+func TestResolveKeepsEnvironmentWhenMetadataFails(t *testing.T) {
+	clearDeploymentEnv(t)
+	t.Setenv("GATEWAY_REGION", "eu-west-1")
+	useMetadataServer(t, ProviderAWS, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	m, err := Resolve(context.Background())
+	if err == nil {
+		t.Fatal("expected metadata error")
+	}
+	if m.Region != "eu-west-1" || m.InstanceID == "" {
+		t.Fatalf("expected configured region and host name, got %+v", m)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	clearDeploymentEnv(t)
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Setenv("GATEWAY_DEPLOYMENT_METADATA", "openstack")
+	if err := ValidateConfig(); err == nil {
+		t.Fatal("expected unknown provider to be rejected")
+	}
+	t.Setenv("GATEWAY_DEPLOYMENT_METADATA", "")
+	t.Setenv("GATEWAY_REGION", "eu west")
+	if err := ValidateConfig(); err == nil {
+		t.Fatal("expected invalid region to be rejected")
+	}
+}
+
+func TestMetricAttributesOmitInstanceID(t *testing.T) {
+	attrs := Metadata{Region: "eu-west-1", Cluster: "prod-eu", InstanceID: "gateway-7d9f"}.MetricAttributes()
+	if len(attrs) != 2 || attrs[0].Key != "region" || attrs[1].Key != "cluster" {
+		t.Fatalf("expected region and cluster labels only, got %v", attrs)
+	}
+}
+
+func TestMergeResourceAttributes(t *testing.T) {
+	m := Metadata{Region: "eu-west-1", Zone: "eu-west-1a", InstanceID: "gateway-7d9f"}
+	got := m.MergeResourceAttributes("service.name=gateway-api, cloud.region=override")
+	want := "service.name=gateway-api,cloud.region=override,cloud.availability_zone=eu-west-1a,service.instance.id=gateway-7d9f"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,423 @@
+// Package fakeidp provides a hermetic OpenID Connect identity provider for
+// tests. It serves discovery, an authorize endpoint that redirects straight
+// back with a code, a PKCE-checked token endpoint and the JWKS for the RS256
+// key it signs ID tokens with, so the authorize, provider and callback legs of
+// a login can run without network access.
+package fakeidp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// KeyID is the kid of the key the provider signs ID tokens with.
+const KeyID = "fakeidp-rsa-1"
+
+const codeTTL = time.Minute
+
+// User is the identity asserted in ID tokens.
+type User struct {
+	Subject string
+	Email   string
+	Name    string
+}
+
+// Authorization records a request to the authorize endpoint.
+type Authorization struct {
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Query holds every parameter, including those the fields above do not
+	// name such as prompt, max_age or login_hint.
+	Query url.Values
+	// Code is the authorization code issued, empty when the request was
+	// answered with an error.
+	Code string
+}
+
+// Error scripts an OAuth error response. On the authorize endpoint it is
+// returned to the redirect URI as error and error_description; on the token
+// endpoint it is the JSON body, sent with Status (400 when zero).
+type Error struct {
+	Status      int
+	Code        string
+	Description string
+}
+
+// Server is a fake identity provider served over a real HTTP listener.
+type Server struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu             sync.Mutex
+	user           User
+	authorizations []Authorization
+	codes          map[string]*grant
+	authorizeError *Error
+	tokenError     *Error
+	tokenRequests  int
+}
+
+type grant struct {
+	authorization Authorization
+	issuedAt      time.Time
+	redeemed      bool
+}
+
+// New starts a fake identity provider. The server is closed automatically
+// when the test completes.
+func New(tb testing.TB) *Server {
+	tb.Helper()
+	s, err := Start()
+	if err != nil {
+		tb.Fatalf("failed to start fake identity provider: %v", err)
+	}
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Start starts a fake identity provider outside a test, such as in a load
+// generator. The caller must Close it.
+func Start() (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("generate signing key: %w", err)
+	}
+	s := &Server{
+		key:   key,
+		user:  User{Subject: "user-1", Email: "user-1@example.com", Name: "Test User"},
+		codes: make(map[string]*grant),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.serveDiscovery)
+	mux.HandleFunc("GET /authorize", s.serveAuthorize)
+	mux.HandleFunc("POST /token", s.serveToken)
+	mux.HandleFunc("GET /jwks", s.serveJWKS)
+	s.server = httptest.NewServer(mux)
+	return s, nil
+}
+
+// Issuer returns the issuer URL, which is also the base URL of the server.
+func (s *Server) Issuer() string {
+	return s.server.URL
+}
+
+// AuthorizeURL returns the authorization endpoint.
+func (s *Server) AuthorizeURL() string {
+	return s.server.URL + "/authorize"
+}
+
+// TokenURL returns the token endpoint.
+func (s *Server) TokenURL() string {
+	return s.server.URL + "/token"
+}
+
+// JWKSURL returns the JWKS endpoint.
+func (s *Server) JWKSURL() string {
+	return s.server.URL + "/jwks"
+}
+
+// Close shuts down the provider ahead of test cleanup.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetUser configures the identity asserted in subsequently issued tokens.
+func (s *Server) SetUser(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+// SetAuthorizeError makes the authorize endpoint redirect with err instead of
+// a code, as a provider does when the user denies consent. Passing nil
+// restores normal behaviour.
+func (s *Server) SetAuthorizeError(err *Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorizeError = cloneError(err)
+}
+
+// SetTokenError makes the token endpoint answer every request with err.
+// Passing nil restores normal behaviour.
+func (s *Server) SetTokenError(err *Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenError = cloneError(err)
+}
+
+// Authorizations returns a snapshot of every authorize request received.
+func (s *Server) Authorizations() []Authorization {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Authorization(nil), s.authorizations...)
+}
+
+// TokenRequests reports how many requests reached the token endpoint.
+func (s *Server) TokenRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenRequests
+}
+
+// IDToken signs an ID token for the current user, issued to clientID with
+// nonce. Entries in overrides replace the default claims; a nil value removes
+// the claim, which lets tests build tokens the relying party must reject.
+func (s *Server) IDToken(clientID, nonce string, overrides map[string]any) string {
+	s.mu.Lock()
+	user := s.user
+	s.mu.Unlock()
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":       s.Issuer(),
+		"sub":       user.Subject,
+		"aud":       clientID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(time.Hour).Unix(),
+		"auth_time": now.Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if user.Email != "" {
+		claims["email"] = user.Email
+	}
+	if user.Name != "" {
+		claims["name"] = user.Name
+	}
+	for key, value := range overrides {
+		if value == nil {
+			delete(claims, key)
+			continue
+		}
+		claims[key] = value
+	}
+	return s.sign(claims)
+}
+
+func (s *Server) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": KeyID, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		// Signing with a freshly generated RSA key only fails if the
+		// system random source does.
+		panic("fakeidp: " + err.Error())
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (s *Server) serveDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.Issuer(),
+		"authorization_endpoint":                s.AuthorizeURL(),
+		"token_endpoint":                        s.TokenURL(),
+		"jwks_uri":                              s.JWKSURL(),
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"none", "client_secret_post"},
+	})
+}
+
+func (s *Server) serveJWKS(w http.ResponseWriter, _ *http.Request) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": KeyID,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   b64(s.key.N.Bytes()),
+		"e":   b64(big.NewInt(int64(s.key.E)).Bytes()),
+	}}})
+}
+
+// serveAuthorize skips the login page and consent screen: a well-formed
+// request is answered at once with a redirect carrying a code, or with the
+// scripted authorize error. Requests without a usable redirect URI get a 400,
+// as a real provider must not redirect to an unvalidated target.
+func (s *Server) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	auth := Authorization{
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		Nonce:               query.Get("nonce"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+		Query:               query,
+	}
+	target, err := url.Parse(auth.RedirectURI)
+	if err != nil || auth.RedirectURI == "" || !target.IsAbs() {
+		s.recordAuthorization(auth)
+		writeJSON(w, http.StatusBadRequest, oauthError("invalid_request", "redirect_uri must be an absolute URL"))
+		return
+	}
+
+	s.mu.Lock()
+	scripted := cloneError(s.authorizeError)
+	s.mu.Unlock()
+
+	params := target.Query()
+	switch {
+	case scripted != nil:
+		params.Set("error", scripted.Code)
+		if scripted.Description != "" {
+			params.Set("error_description", scripted.Description)
+		}
+	case query.Get("response_type") != "code":
+		params.Set("error", "unsupported_response_type")
+	case auth.ClientID == "":
+		params.Set("error", "invalid_request")
+		params.Set("error_description", "client_id is required")
+	case auth.CodeChallenge != "" && auth.CodeChallengeMethod != "S256":
+		params.Set("error", "invalid_request")
+		params.Set("error_description", "code_challenge_method must be S256")
+	default:
+		auth.Code = randomToken()
+		params.Set("code", auth.Code)
+	}
+	if auth.State != "" {
+		params.Set("state", auth.State)
+	}
+	params.Set("iss", s.Issuer())
+	s.recordAuthorization(auth)
+
+	target.RawQuery = params.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (s *Server) recordAuthorization(auth Authorization) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorizations = append(s.authorizations, auth)
+	if auth.Code != "" {
+		s.codes[auth.Code] = &grant{authorization: auth, issuedAt: time.Now()}
+	}
+}
+
+// serveToken redeems authorization codes. Codes are single use, expire after
+// a minute and must be presented with the client ID and redirect URI they
+// were issued for and, when the authorize request carried a challenge, the
+// matching PKCE verifier.
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.tokenRequests++
+	scripted := cloneError(s.tokenError)
+	s.mu.Unlock()
+
+	if scripted != nil {
+		status := scripted.Status
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+		writeJSON(w, status, oauthError(scripted.Code, scripted.Description))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, oauthError("invalid_request", "malformed form body"))
+		return
+	}
+	if grantType := r.PostForm.Get("grant_type"); grantType != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, oauthError("unsupported_grant_type", "only authorization_code is supported"))
+		return
+	}
+
+	auth, ok := s.redeem(r.PostForm.Get("code"))
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, oauthError("invalid_grant", "authorization code is invalid, expired or already used"))
+		return
+	}
+	if r.PostForm.Get("client_id") != auth.ClientID {
+		writeJSON(w, http.StatusBadRequest, oauthError("invalid_grant", "client_id does not match the authorization request"))
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != auth.RedirectURI {
+		writeJSON(w, http.StatusBadRequest, oauthError("invalid_grant", "redirect_uri does not match the authorization request"))
+		return
+	}
+	if auth.CodeChallenge != "" && !verifierMatches(r.PostForm.Get("code_verifier"), auth.CodeChallenge) {
+		writeJSON(w, http.StatusBadRequest, oauthError("invalid_grant", "code_verifier does not match the code_challenge"))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomToken(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"scope":        auth.Scope,
+		"id_token":     s.IDToken(auth.ClientID, auth.Nonce, nil),
+	})
+}
+
+func (s *Server) redeem(code string) (Authorization, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.codes[code]
+	if !ok || g.redeemed || time.Since(g.issuedAt) > codeTTL {
+		return Authorization{}, false
+	}
+	g.redeemed = true
+	return g.authorization, true
+}
+
+func verifierMatches(verifier, challenge string) bool {
+	if verifier == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func oauthError(code, description string) map[string]string {
+	payload := map[string]string{"error": code}
+	if description != "" {
+		payload["error_description"] = description
+	}
+	return payload
+}
+
+func cloneError(err *Error) *Error {
+	if err == nil {
+		return nil
+	}
+	clone := *err
+	return &clone
+}
+
+func randomToken() string {
+	buf := make([]byte, 24)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
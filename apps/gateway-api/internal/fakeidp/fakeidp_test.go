@@ -0,0 +1,249 @@
+package fakeidp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	testClientID    = "client-1"
+	testRedirectURI = "https://app.example.com/callback"
+	testVerifier    = "verifier-0123456789-0123456789-0123456789"
+)
+
+func noRedirectClient() *http.Client {
+	return &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+}
+
+func authorize(t *testing.T, s *Server, extra url.Values) url.Values {
+	t.Helper()
+	sum := sha256.Sum256([]byte(testVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"openid email"},
+		"state":                 {"state-1"},
+		"nonce":                 {"nonce-1"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	for key, values := range extra {
+		query[key] = values
+	}
+	resp, err := noRedirectClient().Get(s.AuthorizeURL() + "?" + query.Encode())
+	if err != nil {
+		t.Fatalf("authorize request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected 302 from authorize, got %d", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	if got := location.Scheme + "://" + location.Host + location.Path; got != testRedirectURI {
+		t.Fatalf("expected redirect to %s, got %s", testRedirectURI, location)
+	}
+	return location.Query()
+}
+
+func exchange(t *testing.T, s *Server, form url.Values) (int, map[string]any) {
+	t.Helper()
+	resp, err := http.PostForm(s.TokenURL(), form)
+	if err != nil {
+		t.Fatalf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var payload map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	return resp.StatusCode, payload
+}
+
+func tokenForm(code string) url.Values {
+	return url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {testClientID},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+	}
+}
+
+func decodeClaims(t *testing.T, token string) map[string]any {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected compact JWS, got %q", token)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("invalid claims encoding: %v", err)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		t.Fatalf("invalid claims: %v", err)
+	}
+	return claims
+}
+
+func TestDiscoveryDescribesEndpoints(t *testing.T) {
+	s := New(t)
+
+	resp, err := http.Get(s.Issuer() + "/.well-known/openid-configuration")
+	if err != nil {
+		t.Fatalf("discovery request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var doc map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatalf("failed to decode discovery: %v", err)
+	}
+	for key, want := range map[string]string{
+		"issuer":                 s.Issuer(),
+		"authorization_endpoint": s.AuthorizeURL(),
+		"token_endpoint":         s.TokenURL(),
+		"jwks_uri":               s.JWKSURL(),
+	} {
+		if doc[key] != want {
+			t.Fatalf("expected %s %q, got %v", key, want, doc[key])
+		}
+	}
+
+	resp, err = http.Get(s.JWKSURL())
+	if err != nil {
+		t.Fatalf("jwks request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		t.Fatalf("failed to decode jwks: %v", err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0]["kid"] != KeyID || set.Keys[0]["kty"] != "RSA" {
+		t.Fatalf("unexpected jwks %v", set.Keys)
+	}
+}
+
+func TestAuthorizeCodeExchangeIssuesIDToken(t *testing.T) {
+	s := New(t)
+	s.SetUser(User{Subject: "alice", Email: "alice@example.com"})
+
+	params := authorize(t, s, url.Values{"prompt": {"login"}})
+	if params.Get("state") != "state-1" || params.Get("iss") != s.Issuer() {
+		t.Fatalf("expected state and iss in redirect, got %v", params)
+	}
+	code := params.Get("code")
+	if code == "" {
+		t.Fatalf("expected code in redirect, got %v", params)
+	}
+	auths := s.Authorizations()
+	if len(auths) != 1 || auths[0].Nonce != "nonce-1" || auths[0].Code != code || auths[0].Query.Get("prompt") != "login" {
+		t.Fatalf("unexpected recorded authorizations %+v", auths)
+	}
+
+	status, payload := exchange(t, s, tokenForm(code))
+	if status != http.StatusOK {
+		t.Fatalf("expected 200 from token endpoint, got %d: %v", status, payload)
+	}
+	idToken, _ := payload["id_token"].(string)
+	claims := decodeClaims(t, idToken)
+	if claims["iss"] != s.Issuer() || claims["aud"] != testClientID || claims["nonce"] != "nonce-1" || claims["sub"] != "alice" {
+		t.Fatalf("unexpected id token claims %v", claims)
+	}
+
+	status, payload = exchange(t, s, tokenForm(code))
+	if status != http.StatusBadRequest || payload["error"] != "invalid_grant" {
+		t.Fatalf("expected a redeemed code to be rejected, got %d: %v", status, payload)
+	}
+}
+
+func TestTokenEndpointRejectsMismatchedGrants(t *testing.T) {
+	cases := []struct {
+		name   string
+		mutate func(url.Values)
+		want   string
+	}{
+		{name: "wrong verifier", mutate: func(f url.Values) { f.Set("code_verifier", "other") }, want: "invalid_grant"},
+		{name: "missing verifier", mutate: func(f url.Values) { f.Del("code_verifier") }, want: "invalid_grant"},
+		{name: "wrong redirect", mutate: func(f url.Values) { f.Set("redirect_uri", "https://evil.example.com/") }, want: "invalid_grant"},
+		{name: "wrong client", mutate: func(f url.Values) { f.Set("client_id", "other") }, want: "invalid_grant"},
+		{name: "unknown code", mutate: func(f url.Values) { f.Set("code", "forged") }, want: "invalid_grant"},
+		{name: "wrong grant type", mutate: func(f url.Values) { f.Set("grant_type", "password") }, want: "unsupported_grant_type"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := New(t)
+			form := tokenForm(authorize(t, s, nil).Get("code"))
+			tc.mutate(form)
+			status, payload := exchange(t, s, form)
+			if status != http.StatusBadRequest || payload["error"] != tc.want {
+				t.Fatalf("expected 400 %s, got %d: %v", tc.want, status, payload)
+			}
+		})
+	}
+}
+
+func TestScriptedErrors(t *testing.T) {
+	s := New(t)
+
+	s.SetAuthorizeError(&Error{Code: "access_denied", Description: "user cancelled"})
+	params := authorize(t, s, nil)
+	if params.Get("error") != "access_denied" || params.Get("error_description") != "user cancelled" || params.Get("code") != "" {
+		t.Fatalf("expected scripted authorize error, got %v", params)
+	}
+	if params.Get("state") != "state-1" {
+		t.Fatalf("expected state to be echoed with the error, got %v", params)
+	}
+	s.SetAuthorizeError(nil)
+	code := authorize(t, s, nil).Get("code")
+
+	s.SetTokenError(&Error{Status: http.StatusServiceUnavailable, Code: "temporarily_unavailable"})
+	status, payload := exchange(t, s, tokenForm(code))
+	if status != http.StatusServiceUnavailable || payload["error"] != "temporarily_unavailable" {
+		t.Fatalf("expected scripted token error, got %d: %v", status, payload)
+	}
+	s.SetTokenError(nil)
+	if status, payload := exchange(t, s, tokenForm(code)); status != http.StatusOK {
+		t.Fatalf("expected the code to survive a scripted failure, got %d: %v", status, payload)
+	}
+	if got := s.TokenRequests(); got != 2 {
+		t.Fatalf("expected 2 token requests, got %d", got)
+	}
+}
+
+func TestAuthorizeRejectsMissingRedirect(t *testing.T) {
+	s := New(t)
+
+	resp, err := noRedirectClient().Get(s.AuthorizeURL() + "?response_type=code&client_id=" + testClientID)
+	if err != nil {
+		t.Fatalf("authorize request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 without redirect_uri, got %d", resp.StatusCode)
+	}
+}
+
+func TestIDTokenOverrides(t *testing.T) {
+	s := New(t)
+
+	claims := decodeClaims(t, s.IDToken(testClientID, "n", map[string]any{"aud": "other", "nonce": nil}))
+	if claims["aud"] != "other" {
+		t.Fatalf("expected overridden audience, got %v", claims["aud"])
+	}
+	if _, ok := claims["nonce"]; ok {
+		t.Fatalf("expected nonce to be removed, got %v", claims)
+	}
+}
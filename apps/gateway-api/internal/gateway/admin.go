@@ -0,0 +1,262 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminRouteConfig captures configuration for the operator-only admin API.
+type AdminRouteConfig struct {
+	// Token is the bearer token required on every admin request.
+	Token string
+	// StartedAt, when set, also registers an unauthenticated GET /healthz
+	// liveness probe on the admin listener.
+	StartedAt time.Time
+}
+
+type jobsResponse struct {
+	Jobs []JobStatus `json:"jobs"`
+}
+
+// collaborationReadOnlyRequest replaces the configured read-only switch until
+// it is deleted again.
+type collaborationReadOnlyRequest struct {
+	Global  bool     `json:"global"`
+	Tenants []string `json:"tenants"`
+}
+
+type rateLimitersResponse struct {
+	Limiters []rateLimiterStats `json:"limiters"`
+}
+
+type upstreamsResponse struct {
+	Upstreams []upstreamClientStats `json:"upstreams"`
+	Resolver  *dnsCacheStats        `json:"resolver,omitempty"`
+}
+
+// RegisterAdminRoutes wires the admin API into mux. The admin API is meant to
+// be served on a separate, non-public listener and always requires a token.
+func RegisterAdminRoutes(mux *http.ServeMux, cfg AdminRouteConfig) {
+	token := strings.TrimSpace(cfg.Token)
+	if token == "" {
+		panic("admin api requires a token")
+	}
+
+	handle(mux, "GET /admin/upstreams", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeUpstreamsResponse(w)
+	})), policyAdminToken)
+
+	handle(mux, "POST /admin/upstreams/refresh", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rebuilt, err := orchestratorUpstream.Refresh()
+		if err != nil {
+			slog.WarnContext(r.Context(), "admin upstream refresh failed", slog.Any("error", err))
+			writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", fmt.Sprintf("failed to build %s client", orchestratorUpstream.name), nil)
+			return
+		}
+		slog.InfoContext(r.Context(), "admin upstream refresh", slog.Bool("rebuilt", rebuilt))
+		writeUpstreamsResponse(w)
+	})), policyAdminToken)
+
+	handle(mux, "POST /admin/collaboration/sessions/flush", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cache, _ := loadCollaborationSessionCache(); cache != nil {
+			if err := cache.flush(r.Context()); err != nil {
+				slog.WarnContext(r.Context(), "admin collaboration session flush failed", slog.Any("error", err))
+				writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "failed to flush collaboration sessions", nil)
+				return
+			}
+			slog.InfoContext(r.Context(), "admin collaboration session flush")
+			publish(gatewayEvents, topicCollaborationSessionsFlushed, struct{}{})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(collaborationSessionCacheStatus())
+	})), policyAdminToken)
+
+	handle(mux, "GET /admin/collaboration/read-only", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readOnly, ok := adminCollaborationReadOnly(w, r)
+		if !ok {
+			return
+		}
+		writeCollaborationReadOnlyResponse(w, readOnly.current(r.Context()))
+	})), policyAdminToken)
+
+	handle(mux, "PUT /admin/collaboration/read-only", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readOnly, ok := adminCollaborationReadOnly(w, r)
+		if !ok {
+			return
+		}
+		var req collaborationReadOnlyRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "invalid read-only request", nil)
+			return
+		}
+		state, err := readOnly.override(r.Context(), req.Global, req.Tenants)
+		if err != nil {
+			if errors.Is(err, errInvalidReadOnlyTenant) {
+				writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+				return
+			}
+			slog.WarnContext(r.Context(), "admin collaboration read-only update failed", slog.Any("error", err))
+			writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "failed to update read-only mode", nil)
+			return
+		}
+		recordCollaborationReadOnlyChange(r.Context(), r, state)
+		publish(gatewayEvents, topicCollaborationReadOnly, state)
+		writeCollaborationReadOnlyResponse(w, state)
+	})), policyAdminToken)
+
+	handle(mux, "DELETE /admin/collaboration/read-only", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readOnly, ok := adminCollaborationReadOnly(w, r)
+		if !ok {
+			return
+		}
+		state, err := readOnly.clear(r.Context())
+		if err != nil {
+			slog.WarnContext(r.Context(), "admin collaboration read-only reset failed", slog.Any("error", err))
+			writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "failed to reset read-only mode", nil)
+			return
+		}
+		recordCollaborationReadOnlyChange(r.Context(), r, state)
+		publish(gatewayEvents, topicCollaborationReadOnly, state)
+		writeCollaborationReadOnlyResponse(w, state)
+	})), policyAdminToken)
+
+	handle(mux, "GET /admin/rate-limiters", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(rateLimitersResponse{Limiters: rateLimiterStatsSnapshot()})
+	})), policyAdminToken)
+
+	handle(mux, "GET /admin/event-bus", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(gatewayEvents.stats())
+	})), policyAdminToken)
+
+	handle(mux, "GET /admin/loglevel", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeLogLevelResponse(w, loadLogLevels().state())
+	})), policyAdminToken)
+
+	handle(mux, "PUT /admin/loglevel", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req logLevelRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "invalid log level request", nil)
+			return
+		}
+		settings, err := req.settings()
+		if err != nil {
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+			return
+		}
+		state := loadLogLevels().setOverride(settings)
+		recordLogLevelChange(r.Context(), r, state)
+		writeLogLevelResponse(w, state)
+	})), policyAdminToken)
+
+	handle(mux, "DELETE /admin/loglevel", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := loadLogLevels().clearOverride()
+		recordLogLevelChange(r.Context(), r, state)
+		writeLogLevelResponse(w, state)
+	})), policyAdminToken)
+
+	handle(mux, "GET /admin/slow", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder, _ := loadSlowRequests()
+		if recorder == nil {
+			writeErrorResponse(w, r, http.StatusNotFound, "not_found", "slow request recording is disabled", nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(recorder.snapshot())
+	})), policyAdminToken)
+
+	handle(mux, "GET /admin/jobs", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJobsResponse(w)
+	})), policyAdminToken)
+
+	handle(mux, "GET /admin/usage", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeUsageResponse(w, r)
+	})), policyAdminToken)
+
+	registerAdminShareRoutes(mux, token)
+	registerAdminDrainRoutes(mux, token)
+	registerAdminDebugRoutes(mux, token)
+	registerAdminAuthTraceRoutes(mux, token)
+	if !cfg.StartedAt.IsZero() {
+		registerLivenessRoute(mux, cfg.StartedAt)
+	}
+}
+
+// LoadAdminToken resolves GATEWAY_ADMIN_TOKEN, honouring the _FILE variant.
+func LoadAdminToken() (string, error) {
+	return ResolveEnvValue("GATEWAY_ADMIN_TOKEN")
+}
+
+func adminAuthMiddleware(token string, next http.Handler) http.Handler {
+	expected := []byte(token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(provided)), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gateway-admin"`)
+			writeErrorResponse(w, r, http.StatusUnauthorized, "unauthorized", "admin token required", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func adminCollaborationReadOnly(w http.ResponseWriter, r *http.Request) (*collaborationReadOnly, bool) {
+	readOnly, err := loadCollaborationReadOnly()
+	if err != nil {
+		slog.WarnContext(r.Context(), "admin collaboration read-only unavailable", slog.Any("error", err))
+		writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "read-only mode unavailable", nil)
+		return nil, false
+	}
+	return readOnly, true
+}
+
+func writeCollaborationReadOnlyResponse(w http.ResponseWriter, state collaborationReadOnlyState) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(state)
+}
+
+func writeLogLevelResponse(w http.ResponseWriter, state logLevelState) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(state)
+}
+
+func writeUpstreamsResponse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	resp := upstreamsResponse{
+		Upstreams: []upstreamClientStats{orchestratorUpstream.stats()},
+	}
+	resp.Upstreams[0].APIVersion = orchestratorAPI.currentVersion(resp.Upstreams[0].BaseURL)
+	if cache, _ := loadDNSCache(); cache != nil {
+		stats := cache.stats()
+		resp.Resolver = &stats
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func writeJobsResponse(w http.ResponseWriter) {
+	jobs := []JobStatus{}
+	if scheduler := maintenanceScheduler.Load(); scheduler != nil {
+		jobs = scheduler.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(jobsResponse{Jobs: jobs})
+}
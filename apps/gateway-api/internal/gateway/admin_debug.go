@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const (
+	auditEventAdminDebug  = "admin.debug.access"
+	auditTargetAdminDebug = "admin.debug"
+
+	adminDebugRuntime = "runtime"
+	adminDebugTrace   = "trace"
+)
+
+// adminPprofHandlers maps the pprof endpoints that are not named runtime
+// profiles to their handlers.
+var adminPprofHandlers = map[string]http.HandlerFunc{
+	"cmdline": pprof.Cmdline,
+	"profile": pprof.Profile,
+	"symbol":  pprof.Symbol,
+}
+
+// runtimeStats is returned by GET /admin/debug/runtime.
+type runtimeStats struct {
+	GoVersion  string             `json:"go_version"`
+	Goroutines int                `json:"goroutines"`
+	GOMAXPROCS int                `json:"gomaxprocs"`
+	CgoCalls   int64              `json:"cgo_calls"`
+	Memory     runtimeMemoryStats `json:"memory"`
+	GC         runtimeGCStats     `json:"gc"`
+	Streams    streamStats        `json:"streams"`
+}
+
+type runtimeMemoryStats struct {
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64 `json:"heap_inuse_bytes"`
+	HeapObjects    uint64 `json:"heap_objects"`
+	StackBytes     uint64 `json:"stack_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	NextGCBytes    uint64 `json:"next_gc_bytes"`
+}
+
+// runtimeGCStats summarises garbage collection. Pause quantiles cover the
+// pauses the runtime still remembers (the last 256).
+type runtimeGCStats struct {
+	Count          int64             `json:"count"`
+	LastAt         *time.Time        `json:"last_at,omitempty"`
+	PauseTotal     string            `json:"pause_total"`
+	LastPause      string            `json:"last_pause,omitempty"`
+	PauseQuantiles map[string]string `json:"pause_quantiles,omitempty"`
+}
+
+// registerAdminDebugRoutes exposes net/http/pprof, runtime statistics and,
+// when GATEWAY_ADMIN_TRACE_ENABLED is set, execution trace capture under
+// /admin/debug. Every request is audited before it is served since profiles
+// reveal memory contents and command lines.
+func registerAdminDebugRoutes(mux *http.ServeMux, token string) {
+	debugRoute := func(pattern, name string, handler http.Handler) {
+		handle(mux, pattern, adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recordAdminDebugAccess(r.Context(), r, cmp.Or(adminPprofEndpoint(r.PathValue("profile")), name))
+			handler.ServeHTTP(w, r)
+		})), policyAdminToken)
+	}
+
+	debugRoute("GET /admin/debug/pprof/", "index", http.HandlerFunc(pprof.Index))
+	debugRoute("GET /admin/debug/pprof/{profile}", "pprof", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("profile")
+		if handler, ok := adminPprofHandlers[name]; ok {
+			handler(w, r)
+			return
+		}
+		if name == adminDebugTrace {
+			writeErrorResponse(w, r, http.StatusNotFound, "not_found", "execution traces are served at /admin/debug/trace", nil)
+			return
+		}
+		pprof.Handler(name).ServeHTTP(w, r)
+	}))
+	debugRoute("POST /admin/debug/pprof/symbol", "symbol", http.HandlerFunc(pprof.Symbol))
+	debugRoute("GET /admin/debug/runtime", adminDebugRuntime, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(readRuntimeStats())
+	}))
+	if getBoolEnv("GATEWAY_ADMIN_TRACE_ENABLED") {
+		debugRoute("GET /admin/debug/trace", adminDebugTrace, http.HandlerFunc(pprof.Trace))
+	}
+}
+
+// adminPprofEndpoint names a requested profile for the audit record; names
+// the runtime does not know are recorded as "unknown".
+func adminPprofEndpoint(name string) string {
+	if name == "" {
+		return ""
+	}
+	if _, ok := adminPprofHandlers[name]; ok || runtimepprof.Lookup(name) != nil {
+		return name
+	}
+	return "unknown"
+}
+
+func readRuntimeStats() runtimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	gc := debug.GCStats{PauseQuantiles: make([]time.Duration, 5)}
+	debug.ReadGCStats(&gc)
+
+	stats := runtimeStats{
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		CgoCalls:   runtime.NumCgoCall(),
+		Memory: runtimeMemoryStats{
+			HeapAllocBytes: mem.HeapAlloc,
+			HeapInuseBytes: mem.HeapInuse,
+			HeapObjects:    mem.HeapObjects,
+			StackBytes:     mem.StackInuse,
+			SysBytes:       mem.Sys,
+			NextGCBytes:    mem.NextGC,
+		},
+		GC: runtimeGCStats{
+			Count:      gc.NumGC,
+			PauseTotal: gc.PauseTotal.String(),
+		},
+		Streams: streamConns.stats(),
+	}
+	if gc.NumGC > 0 {
+		last := gc.LastGC
+		stats.GC.LastAt = &last
+		stats.GC.LastPause = gc.Pause[0].String()
+		stats.GC.PauseQuantiles = map[string]string{
+			"min": gc.PauseQuantiles[0].String(),
+			"p25": gc.PauseQuantiles[1].String(),
+			"p50": gc.PauseQuantiles[2].String(),
+			"p75": gc.PauseQuantiles[3].String(),
+			"max": gc.PauseQuantiles[4].String(),
+		}
+	}
+	return stats
+}
+
+// recordAdminDebugAccess audits a request for diagnostics. seconds is the
+// requested profile or trace duration, when given.
+func recordAdminDebugAccess(ctx context.Context, r *http.Request, endpoint string) {
+	actor := hashedActorFromRequest(r, nil, "admin")
+	details := map[string]any{
+		"endpoint": endpoint,
+		"method":   r.Method,
+	}
+	if seconds, err := strconv.Atoi(r.URL.Query().Get("seconds")); err == nil {
+		details["seconds"] = seconds
+	}
+	gatewayAuditLogger.Security(audit.WithActor(ctx, actor), audit.Event{
+		Name:    auditEventAdminDebug,
+		Outcome: auditOutcomeSuccess,
+		Target:  auditTargetAdminDebug,
+		ActorID: actor,
+		Details: auditDetails(details),
+	})
+}
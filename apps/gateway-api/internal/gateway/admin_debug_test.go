@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveAdminDebug(t *testing.T, method, target, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminDebugRequiresTokenAndAudits(t *testing.T) {
+	logs := captureAuditLogs(t)
+
+	if rec := serveAdminDebug(t, http.MethodGet, "/admin/debug/pprof/goroutine", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+	if strings.Contains(logs.String(), auditEventAdminDebug) {
+		t.Fatalf("expected no audit record for a rejected request, got %s", logs.String())
+	}
+
+	rec := serveAdminDebug(t, http.MethodGet, "/admin/debug/pprof/goroutine?debug=1", "s3cret")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine profile") {
+		t.Fatalf("expected a goroutine profile, got %d: %.200s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(logs.String(), auditEventAdminDebug) || !strings.Contains(logs.String(), `"endpoint":"goroutine"`) {
+		t.Fatalf("expected an audit record, got %s", logs.String())
+	}
+
+	if rec := serveAdminDebug(t, http.MethodGet, "/admin/debug/pprof/", "s3cret"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "heap") {
+		t.Fatalf("expected the profile index, got %d", rec.Code)
+	}
+	if rec := serveAdminDebug(t, http.MethodGet, "/admin/debug/pprof/nonsense", "s3cret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown profile, got %d", rec.Code)
+	}
+	if !strings.Contains(logs.String(), `"endpoint":"unknown"`) {
+		t.Fatalf("expected unknown profiles to be audited without their name, got %s", logs.String())
+	}
+}
+
+func TestAdminDebugRuntime(t *testing.T) {
+	captureAuditLogs(t)
+	rec := serveAdminDebug(t, http.MethodGet, "/admin/debug/runtime", "s3cret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var stats runtimeStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if stats.Goroutines == 0 || stats.GOMAXPROCS == 0 || stats.Memory.HeapAllocBytes == 0 || stats.GoVersion == "" {
+		t.Fatalf("unexpected runtime stats %+v", stats)
+	}
+}
+
+func TestAdminDebugTraceIsOptional(t *testing.T) {
+	captureAuditLogs(t)
+	if rec := serveAdminDebug(t, http.MethodGet, "/admin/debug/trace?seconds=1", "s3cret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected trace capture to be disabled by default, got %d", rec.Code)
+	}
+
+	t.Setenv("GATEWAY_ADMIN_TRACE_ENABLED", "true")
+	rec := serveAdminDebug(t, http.MethodGet, "/admin/debug/trace?seconds=0.05", "s3cret")
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Fatalf("expected an execution trace, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminUpstreamsRequiresToken(t *testing.T) {
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+
+	for _, header := range []string{"", "Bearer wrong", "s3cret"} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/upstreams", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for %q, got %d", header, rec.Code)
+		}
+	}
+}
+
+func TestAdminUpstreamsReportsClientStats(t *testing.T) {
+	t.Setenv("ORCHESTRATOR_URL", "http://orchestrator:4000")
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+	if _, err := getOrchestratorClient(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/upstreams/refresh", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var payload upstreamsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(payload.Upstreams) != 1 {
+		t.Fatalf("expected one upstream, got %+v", payload.Upstreams)
+	}
+	stats := payload.Upstreams[0]
+	if stats.Name != "orchestrator" || stats.Status != "ok" || stats.BaseURL != "http://orchestrator:4000" || stats.Generation != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.Transport == nil || stats.LastCheckedAt == nil {
+		t.Fatalf("expected transport stats and refresh timestamp, got %+v", stats)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/admin/upstreams", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
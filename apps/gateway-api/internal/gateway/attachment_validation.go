@@ -0,0 +1,132 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// attachmentSniffBytes is how much of a file is inspected before it is
+// forwarded; it matches the window browsers sniff, which is what matters for
+// content later displayed inline.
+const attachmentSniffBytes = 512
+
+// attachmentExtensionTypes lists the declared content types each known
+// extension may carry. Files with other extensions, or none, are only
+// accepted as text/plain.
+var attachmentExtensionTypes = map[string][]string{
+	".txt":      {"text/plain"},
+	".log":      {"text/plain"},
+	".md":       {"text/markdown", "text/plain"},
+	".markdown": {"text/markdown", "text/plain"},
+	".csv":      {"text/csv", "text/plain"},
+	".json":     {"application/json", "text/plain"},
+	".yaml":     {"application/yaml", "application/x-yaml", "text/plain"},
+	".yml":      {"application/yaml", "application/x-yaml", "text/plain"},
+	".pdf":      {"application/pdf"},
+	".png":      {"image/png"},
+	".jpg":      {"image/jpeg"},
+	".jpeg":     {"image/jpeg"},
+}
+
+// blockedAttachmentExtensions are executable or active content that is
+// refused whatever type it is declared as.
+var blockedAttachmentExtensions = map[string]struct{}{
+	".bat": {}, ".cmd": {}, ".com": {}, ".cpl": {}, ".dll": {}, ".dylib": {},
+	".exe": {}, ".hta": {}, ".htm": {}, ".html": {}, ".jar": {}, ".js": {},
+	".mjs": {}, ".msi": {}, ".ps1": {}, ".scr": {}, ".sh": {}, ".so": {},
+	".svg": {}, ".vbs": {}, ".xhtml": {},
+}
+
+// executableSignatures are magic numbers of native executables and archives
+// of code that must never pass as documents.
+var executableSignatures = [][]byte{
+	[]byte("MZ"),               // PE (Windows)
+	[]byte("\x7fELF"),          // ELF
+	[]byte("\xfe\xed\xfa\xce"), // Mach-O 32-bit
+	[]byte("\xfe\xed\xfa\xcf"), // Mach-O 64-bit
+	[]byte("\xce\xfa\xed\xfe"), // Mach-O 32-bit, little endian
+	[]byte("\xcf\xfa\xed\xfe"), // Mach-O 64-bit, little endian
+	[]byte("\xca\xfe\xba\xbe"), // Mach-O universal or Java class
+	[]byte("#!"),               // script with interpreter line
+	[]byte("\x00asm"),          // WebAssembly
+}
+
+// activeContentMarkers are tags that make a browser treat text as markup.
+var activeContentMarkers = [][]byte{
+	[]byte("<!doctype html"),
+	[]byte("<html"),
+	[]byte("<script"),
+	[]byte("<iframe"),
+	[]byte("<svg"),
+	[]byte("<object"),
+	[]byte("<embed"),
+}
+
+// checkAttachmentContent enforces the extension and content-type policy on a
+// file's name, declared type and first bytes. It returns nil when the file
+// may be forwarded. Types an operator adds to GATEWAY_ATTACHMENT_CONTENT_TYPES
+// have no extension or magic-byte policy; they are only checked for
+// executables and, when they sniff as text, markup.
+func checkAttachmentContent(filename, declared string, head []byte) *attachmentError {
+	ext := strings.ToLower(path.Ext(filename))
+	sniffed := http.DetectContentType(head)
+	details := map[string]any{
+		"extension":     ext,
+		"content_type":  declared,
+		"detected_type": sniffed,
+	}
+	reject := func(status int, code, message, reason string) *attachmentError {
+		return &attachmentError{status, code, message, reason, details}
+	}
+
+	if _, blocked := blockedAttachmentExtensions[ext]; blocked {
+		return reject(http.StatusUnsupportedMediaType, "unsupported_media_type", "attachment file type is not allowed", "extension_blocked")
+	}
+	builtin := slices.Contains(defaultAttachmentContentTypes, declared)
+	allowed, known := attachmentExtensionTypes[ext]
+	if !known {
+		allowed = []string{"text/plain"}
+	}
+	if builtin && !slices.Contains(allowed, declared) {
+		return reject(http.StatusUnsupportedMediaType, "unsupported_media_type", "attachment content type does not match its file extension", "extension_mismatch")
+	}
+
+	for _, signature := range executableSignatures {
+		// "MZ" is also an ordinary way for text to start; a real PE header
+		// is followed by binary data.
+		if bytes.HasPrefix(head, signature) && (string(signature) != "MZ" || !strings.HasPrefix(sniffed, "text/plain")) {
+			return reject(http.StatusUnprocessableEntity, "attachment_rejected", "attachment content does not match its declared type", "executable_content")
+		}
+	}
+
+	switch {
+	case !builtin:
+		if strings.HasPrefix(sniffed, "text/html") || (strings.HasPrefix(sniffed, "text/plain") && containsActiveContent(head)) {
+			return reject(http.StatusUnprocessableEntity, "attachment_rejected", "attachment content does not match its declared type", "content_mismatch")
+		}
+	case declared == "application/pdf" || strings.HasPrefix(declared, "image/"):
+		if !strings.HasPrefix(sniffed, declared) {
+			return reject(http.StatusUnprocessableEntity, "attachment_rejected", "attachment content does not match its declared type", "content_mismatch")
+		}
+	default:
+		// Every other allowed type is text. Browsers sniff text for markup,
+		// so HTML declared as text could run script when displayed.
+		if !strings.HasPrefix(sniffed, "text/plain") || containsActiveContent(head) {
+			return reject(http.StatusUnprocessableEntity, "attachment_rejected", "attachment content does not match its declared type", "content_mismatch")
+		}
+	}
+	return nil
+}
+
+func containsActiveContent(head []byte) bool {
+	lower := bytes.ToLower(head)
+	for _, marker := range activeContentMarkers {
+		if bytes.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
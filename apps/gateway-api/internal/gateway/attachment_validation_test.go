@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+func TestCheckAttachmentContent(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name     string
+		filename string
+		declared string
+		head     []byte
+		reason   string
+	}{
+		{name: "plain log", filename: "build.log", declared: "text/plain", head: []byte("step 1 ok\n")},
+		{name: "markdown", filename: "spec.md", declared: "text/markdown", head: []byte("# Spec\n\nUse `<b>` sparingly.")},
+		{name: "json as text", filename: "plan.json", declared: "text/plain", head: []byte(`{"a":1}`)},
+		{name: "extensionless text", filename: "Dockerfile", declared: "text/plain", head: []byte("FROM scratch\n")},
+		{name: "text starting with MZ", filename: "notes.txt", declared: "text/plain", head: []byte("MZ region outage notes")},
+		{name: "png", filename: "shot.png", declared: "image/png", head: png},
+		{name: "pdf", filename: "doc.pdf", declared: "application/pdf", head: []byte("%PDF-1.7\n")},
+
+		{name: "blocked extension", filename: "page.html", declared: "text/plain", head: []byte("hello"), reason: "extension_blocked"},
+		{name: "extension type mismatch", filename: "shot.png", declared: "text/plain", head: png, reason: "extension_mismatch"},
+		{name: "unknown extension as json", filename: "data.bin", declared: "application/json", head: []byte("{}"), reason: "extension_mismatch"},
+		{name: "windows executable", filename: "tool.txt", declared: "text/plain", head: []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff"), reason: "executable_content"},
+		{name: "elf", filename: "tool.log", declared: "text/plain", head: []byte("\x7fELF\x02\x01\x01"), reason: "executable_content"},
+		{name: "shebang script", filename: "run.txt", declared: "text/plain", head: []byte("#!/bin/sh\nrm -rf /\n"), reason: "executable_content"},
+		{name: "html as text", filename: "readme.txt", declared: "text/plain", head: []byte("<!DOCTYPE html><html><body>hi</body></html>"), reason: "content_mismatch"},
+		{name: "script later in markdown", filename: "notes.md", declared: "text/markdown", head: []byte("# Notes\n\n<ScRiPt>alert(1)</script>"), reason: "content_mismatch"},
+		{name: "binary as text", filename: "data.csv", declared: "text/csv", head: []byte("\x00\x01\x02\x03"), reason: "content_mismatch"},
+		{name: "text as png", filename: "shot.png", declared: "image/png", head: []byte("not an image"), reason: "content_mismatch"},
+		{name: "html as pdf", filename: "doc.pdf", declared: "application/pdf", head: []byte("<html><script>x</script>"), reason: "content_mismatch"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rejection := checkAttachmentContent(tc.filename, tc.declared, tc.head)
+			if tc.reason == "" {
+				if rejection != nil {
+					t.Fatalf("expected file to be accepted, got %s", rejection.reason)
+				}
+				return
+			}
+			if rejection == nil || rejection.reason != tc.reason {
+				t.Fatalf("expected rejection %q, got %+v", tc.reason, rejection)
+			}
+			if rejection.details["detected_type"] == "" {
+				t.Fatal("expected detected type in audit details")
+			}
+		})
+	}
+}
+
+func TestCheckAttachmentContentOperatorTypes(t *testing.T) {
+	if rejection := checkAttachmentContent("bundle.zip", "application/zip", []byte("PK\x03\x04")); rejection != nil {
+		t.Fatalf("expected operator type to skip extension policy, got %s", rejection.reason)
+	}
+	if rejection := checkAttachmentContent("bundle.zip", "application/zip", []byte("<html><script>x</script>")); rejection == nil {
+		t.Fatal("expected markup to be rejected for operator types")
+	}
+}
+
+func TestAttachmentUploadAuditsSpoofedContent(t *testing.T) {
+	var buf bytes.Buffer
+	original := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{})))
+	gatewayAuditLogger = audit.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(original)
+		gatewayAuditLogger = audit.Default()
+	})
+
+	uploads := make(chan []uploadedAttachment, 1)
+	handler := newTestAttachmentHandler(newAttachmentOrchestrator(t, uploads))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, attachmentRequest(t, validPlanID, uploadedAttachment{Filename: "report.txt", ContentType: "text/plain", Content: "<html><script>steal()</script></html>"}))
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body httpErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != "attachment_rejected" {
+		t.Fatalf("expected attachment_rejected error, got %q", rec.Body.String())
+	}
+	logged := buf.String()
+	for _, want := range []string{`"reason":"content_mismatch"`, `"detected_type":"text/html; charset=utf-8"`, auditEventPlanAttachment} {
+		if !strings.Contains(logged, want) {
+			t.Fatalf("expected audit log to contain %s, got %s", want, logged)
+		}
+	}
+	select {
+	case received := <-uploads:
+		t.Fatalf("expected upload to be aborted, got %+v", received)
+	default:
+	}
+}
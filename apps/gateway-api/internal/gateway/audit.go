@@ -1,6 +1,7 @@
 package gateway
 
 import (
+	"context"
 	"net"
 	"net/http"
 
@@ -14,6 +15,12 @@ func hashedActorFromRequest(r *http.Request, trustedProxies []*net.IPNet, extra
 	return gatewayAuditLogger.HashIdentity(identityParts...)
 }
 
+// hashScopedIdentity hashes identity components with the salt of the tenant
+// in ctx's request scope, or the shared salt before the tenant is known.
+func hashScopedIdentity(ctx context.Context, parts ...string) string {
+	return gatewayAuditLogger.HashTenantIdentity(requestScopeFrom(ctx).TenantID, parts...)
+}
+
 func auditDetails(base map[string]any) map[string]any {
 	return audit.SanitizeDetails(base)
 }
@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuthorizeDedupWindow = 2 * time.Second
+	maxAuthorizeDedupWindow     = 30 * time.Second
+	maxAuthorizeDedupEntries    = 10000
+)
+
+// authorizeDeduplicator answers repeated sign-in requests from the same
+// client, such as a double-clicked login button, with the authorization
+// started by the first one, so the browser holds one state cookie and the
+// provider sees one authorization request. Entries live for the window only
+// and are kept in memory, so duplicates reaching another replica start their
+// own authorization.
+//
+// Browsers are told apart by client IP, User-Agent and device cookie. Two
+// browsers sharing an IP, such as behind a corporate NAT, with the same
+// User-Agent and no device cookie, that start the same sign-in within the
+// window are still answered with one authorization; the second browser then
+// holds the first one's state cookie and the first browser's callback fails
+// with an unknown state. Keep the window short.
+type authorizeDeduplicator struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]authorizeDedupEntry
+	now     func() time.Time
+}
+
+// authorizeDedupEntry is an authorization already sent to a client.
+type authorizeDedupEntry struct {
+	state     stateData
+	authURL   *url.URL
+	expiresAt time.Time
+}
+
+func newAuthorizeDeduplicator(window time.Duration) *authorizeDeduplicator {
+	return &authorizeDeduplicator{
+		window:  min(window, maxAuthorizeDedupWindow),
+		entries: make(map[string]authorizeDedupEntry),
+		now:     clockNow,
+	}
+}
+
+func loadAuthorizeDeduplicator() *authorizeDeduplicator {
+	return newAuthorizeDeduplicator(GetDurationEnv("GATEWAY_AUTH_AUTHORIZE_DEDUP_WINDOW", defaultAuthorizeDedupWindow))
+}
+
+// authorizeDedupBrowser identifies the browser behind a sign-in request by
+// its User-Agent and device cookie, either of which may be empty.
+func authorizeDedupBrowser(r *http.Request) string {
+	browser := r.Header.Get("User-Agent")
+	if cookie, err := r.Cookie(deviceCookieName); err == nil {
+		browser += "\x00" + cookie.Value
+	}
+	return browser
+}
+
+// authorizeDedupKey identifies a sign-in request by client IP, browser and
+// redirect host. The remaining parameters are part of the key too, so a
+// request for another provider, tenant or client is never answered with this
+// one's authorization.
+func authorizeDedupKey(ip, browser, provider string, redirectURL *url.URL, tenantID, clientApp, bindingID, landingPath string, authContext authContextParams, passthrough authorizePassthrough) string {
+	maxAge := ""
+	if authContext.MaxAge != nil {
+		maxAge = strconv.Itoa(*authContext.MaxAge)
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		ip, browser, redirectURL.Host, provider, redirectURL.String(), tenantID, clientApp, bindingID, landingPath,
+		authContext.ACRValues, maxAge, authContext.Prompt, passthrough.key(),
+	}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup returns the authorization started for key within the window.
+func (d *authorizeDeduplicator) lookup(key string) (authorizeDedupEntry, bool) {
+	if d == nil || d.window <= 0 {
+		return authorizeDedupEntry{}, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[key]
+	if !ok || !d.now().Before(entry.expiresAt) {
+		return authorizeDedupEntry{}, false
+	}
+	return entry, true
+}
+
+// remember records the authorization started for key and returns the one to
+// send: entry, or the authorization a concurrent duplicate recorded first.
+func (d *authorizeDeduplicator) remember(key string, entry authorizeDedupEntry) (authorizeDedupEntry, bool) {
+	if d == nil || d.window <= 0 {
+		return entry, false
+	}
+	now := d.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if existing, ok := d.entries[key]; ok && now.Before(existing.expiresAt) {
+		return existing, true
+	}
+	if len(d.entries) >= maxAuthorizeDedupEntries {
+		for k, existing := range d.entries {
+			if !now.Before(existing.expiresAt) {
+				delete(d.entries, k)
+			}
+		}
+		if len(d.entries) >= maxAuthorizeDedupEntries {
+			return entry, false
+		}
+	}
+	entry.expiresAt = now.Add(d.window)
+	d.entries[key] = entry
+	return entry, false
+}
@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func authorizeFrom(t *testing.T, duplicates *authorizeDeduplicator, ip, query string, headers ...string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?"+query, nil)
+	req.TLS = &tls.ConnectionState{}
+	req.RemoteAddr = ip + ":1234"
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, duplicates)
+	res := rec.Result()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect status, got %d", res.StatusCode)
+	}
+	return res
+}
+
+func authorizeState(t *testing.T, res *http.Response) string {
+	t.Helper()
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	return location.Query().Get("state")
+}
+
+func TestAuthorizeDeduplicatesRepeatedSignIns(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+	logs := captureAuditLogs(t)
+	now := time.Unix(1_700_000_000, 0)
+	duplicates := newAuthorizeDeduplicator(2 * time.Second)
+	duplicates.now = func() time.Time { return now }
+
+	const query = "redirect_uri=https://app.example.com/complete"
+	first := authorizeFrom(t, duplicates, "198.51.100.7", query)
+	second := authorizeFrom(t, duplicates, "198.51.100.7", query)
+	if first.Header.Get("Location") != second.Header.Get("Location") {
+		t.Fatalf("expected the same authorization, got %s and %s", first.Header.Get("Location"), second.Header.Get("Location"))
+	}
+	state := authorizeState(t, first)
+	var resent bool
+	for _, cookie := range second.Cookies() {
+		if cookie.Name == stateCookieName(state) {
+			resent = true
+		}
+	}
+	if !resent {
+		t.Fatal("expected the duplicate to re-issue the state cookie")
+	}
+	if !strings.Contains(logs.String(), `"deduplicated":true`) {
+		t.Fatalf("expected the duplicate to be audited, got %s", logs.String())
+	}
+
+	for name, res := range map[string]*http.Response{
+		"other client":   authorizeFrom(t, duplicates, "198.51.100.8", query),
+		"other browser":  authorizeFrom(t, duplicates, "198.51.100.7", query, "User-Agent", "Firefox"),
+		"other device":   authorizeFrom(t, duplicates, "198.51.100.7", query, "Cookie", deviceCookieName+"=other"),
+		"other redirect": authorizeFrom(t, duplicates, "198.51.100.7", query+"/other"),
+		"other tenant":   authorizeFrom(t, duplicates, "198.51.100.7", query+"&tenant_id=acme"),
+	} {
+		if authorizeState(t, res) == state {
+			t.Fatalf("%s: expected a new authorization", name)
+		}
+	}
+
+	now = now.Add(2 * time.Second)
+	if authorizeState(t, authorizeFrom(t, duplicates, "198.51.100.7", query)) == state {
+		t.Fatal("expected a new authorization once the window passed")
+	}
+}
+
+func TestAuthorizeDeduplicatorDisabled(t *testing.T) {
+	duplicates := newAuthorizeDeduplicator(0)
+	entry := authorizeDedupEntry{state: stateData{State: "first"}}
+	if _, duplicate := duplicates.remember("key", entry); duplicate {
+		t.Fatal("expected nothing to be remembered")
+	}
+	if _, ok := duplicates.lookup("key"); ok {
+		t.Fatal("expected no duplicates with a zero window")
+	}
+	if capped := newAuthorizeDeduplicator(time.Hour); capped.window != maxAuthorizeDedupWindow {
+		t.Fatalf("expected the window to be capped, got %s", capped.window)
+	}
+}
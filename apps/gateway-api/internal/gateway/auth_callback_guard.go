@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCallbackFailureThreshold = 5
+	defaultCallbackFailureWindow    = 10 * time.Minute
+	defaultCallbackStrictDuration   = 15 * time.Minute
+	defaultCallbackStrictStateAge   = 2 * time.Minute
+	maxCallbackGuardEntries         = 10000
+)
+
+// callbackGuard slows down authorization code spraying against the callback.
+// Each invalid_grant the orchestrator reports counts against the client IP and
+// the callback identity; once either crosses the threshold, callbacks for it
+// are only accepted with a state issued moments ago, so every further guess
+// costs a full authorize round-trip.
+type callbackGuard struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	duration  time.Duration
+	stateAge  time.Duration
+	entries   map[string]callbackGuardEntry
+	now       func() time.Time
+}
+
+type callbackGuardEntry struct {
+	failures    int
+	windowEnds  time.Time
+	strictUntil time.Time
+}
+
+func newCallbackGuard(threshold int, window, duration, stateAge time.Duration) *callbackGuard {
+	return &callbackGuard{
+		threshold: threshold,
+		window:    window,
+		duration:  duration,
+		stateAge:  stateAge,
+		entries:   make(map[string]callbackGuardEntry),
+		now:       clockNow,
+	}
+}
+
+func loadCallbackGuard() *callbackGuard {
+	return newCallbackGuard(
+		GetIntEnv("GATEWAY_CALLBACK_FAILURE_THRESHOLD", defaultCallbackFailureThreshold),
+		GetDurationEnv("GATEWAY_CALLBACK_FAILURE_WINDOW", defaultCallbackFailureWindow),
+		GetDurationEnv("GATEWAY_CALLBACK_STRICT_DURATION", defaultCallbackStrictDuration),
+		GetDurationEnv("GATEWAY_CALLBACK_STRICT_STATE_AGE", defaultCallbackStrictStateAge),
+	)
+}
+
+// callbackGuardKeys returns the tracking keys for a callback: the client IP
+// and, when known, the identity the callback rate limit uses.
+func callbackGuardKeys(ip, identity string) []string {
+	keys := []string{"ip:" + ip}
+	if identity != "" {
+		keys = append(keys, "identity:"+identity)
+	}
+	return keys
+}
+
+// callbackGuardScopes names the kinds of key, "ip" or "identity", without
+// the values, for audit events.
+func callbackGuardScopes(keys []string) []string {
+	scopes := make([]string, 0, len(keys))
+	for _, key := range keys {
+		scope, _, _ := strings.Cut(key, ":")
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+// stateTooOld reports whether a callback for keys must be rejected because
+// one of them is in strict mode and the state was issued too long ago.
+func (g *callbackGuard) stateTooOld(keys []string, data stateData) bool {
+	if g == nil || g.threshold <= 0 {
+		return false
+	}
+	now := g.now()
+	issuedAt := data.ExpiresAt.Add(-stateTTL)
+	if now.Sub(issuedAt) <= g.stateAge {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range keys {
+		if now.Before(g.entries[key].strictUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordFailure counts an invalid_grant against keys and returns the keys
+// that entered strict mode as a result.
+func (g *callbackGuard) recordFailure(keys []string) []string {
+	if g == nil || g.threshold <= 0 {
+		return nil
+	}
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.entries) >= maxCallbackGuardEntries {
+		for k, entry := range g.entries {
+			if now.After(entry.windowEnds) && now.After(entry.strictUntil) {
+				delete(g.entries, k)
+			}
+		}
+	}
+	var tripped []string
+	for _, key := range keys {
+		entry := g.entries[key]
+		if now.After(entry.windowEnds) {
+			entry.failures = 0
+			entry.windowEnds = now.Add(g.window)
+		}
+		entry.failures++
+		if entry.failures >= g.threshold {
+			if !now.Before(entry.strictUntil) {
+				tripped = append(tripped, key)
+			}
+			entry.strictUntil = now.Add(g.duration)
+			entry.failures = 0
+		}
+		g.entries[key] = entry
+	}
+	return tripped
+}
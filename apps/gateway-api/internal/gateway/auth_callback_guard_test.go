@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallbackGuardEntersStrictModeAfterThreshold(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	guard := newCallbackGuard(3, time.Minute, 10*time.Minute, 30*time.Second)
+	guard.now = func() time.Time { return now }
+	keys := callbackGuardKeys("203.0.113.7", "app.example.com")
+	stale := stateData{ExpiresAt: now.Add(stateTTL - time.Minute)}
+	fresh := stateData{ExpiresAt: now.Add(stateTTL - 10*time.Second)}
+
+	for i := 0; i < 2; i++ {
+		if tripped := guard.recordFailure(keys); len(tripped) != 0 {
+			t.Fatalf("failure %d: unexpected strict mode for %v", i+1, tripped)
+		}
+	}
+	if guard.stateTooOld(keys, stale) {
+		t.Fatal("expected callbacks to be accepted below the threshold")
+	}
+	tripped := guard.recordFailure(keys)
+	if scopes := callbackGuardScopes(tripped); len(scopes) != 2 || scopes[0] != "ip" || scopes[1] != "identity" {
+		t.Fatalf("expected both keys to enter strict mode, got %v", scopes)
+	}
+	if !guard.stateTooOld(keys, stale) {
+		t.Fatal("expected a state issued a minute ago to be rejected in strict mode")
+	}
+	if guard.stateTooOld(keys, fresh) {
+		t.Fatal("expected a freshly issued state to be accepted in strict mode")
+	}
+	if guard.stateTooOld(callbackGuardKeys("198.51.100.1", ""), stale) {
+		t.Fatal("expected other clients to be unaffected")
+	}
+
+	now = now.Add(11 * time.Minute)
+	if guard.stateTooOld(keys, stateData{ExpiresAt: now.Add(stateTTL - time.Minute)}) {
+		t.Fatal("expected strict mode to expire")
+	}
+}
+
+func TestCallbackGuardFailuresOutsideWindowDoNotAccumulate(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	guard := newCallbackGuard(2, time.Minute, 10*time.Minute, 30*time.Second)
+	guard.now = func() time.Time { return now }
+	keys := callbackGuardKeys("203.0.113.7", "")
+
+	guard.recordFailure(keys)
+	now = now.Add(2 * time.Minute)
+	if tripped := guard.recordFailure(keys); len(tripped) != 0 {
+		t.Fatalf("expected the window to reset, got %v", tripped)
+	}
+
+	var nilGuard *callbackGuard
+	if nilGuard.recordFailure(keys) != nil || nilGuard.stateTooOld(keys, stateData{}) {
+		t.Fatal("expected a nil guard to be disabled")
+	}
+}
+
+func TestCallbackHandlerRequiresFreshStateAfterInvalidGrants(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+
+	var calls int
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Body:       io.NopCloser(strings.NewReader(`{"error":"invalid authorization code","code":"invalid_grant"}`)),
+				Header:     make(http.Header),
+			}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+
+	guard := newCallbackGuard(2, time.Minute, time.Minute, 30*time.Second)
+	callback := func(state string, issuedAgo time.Duration) *httptest.ResponseRecorder {
+		t.Helper()
+		data := stateData{
+			Provider:     "openrouter",
+			RedirectURI:  "https://app.example.com/complete",
+			CodeVerifier: "verifier",
+			ExpiresAt:    time.Now().Add(stateTTL - issuedAgo),
+			State:        state,
+		}
+		encoded, err := getCookieHandler().Encode(stateCookieName(state), data)
+		if err != nil {
+			t.Fatalf("failed to encode state data: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?code=guess&state="+state, nil)
+		req.TLS = &tls.ConnectionState{}
+		req.AddCookie(&http.Cookie{Name: stateCookieName(state), Value: encoded, Path: "/auth/"})
+		rec := httptest.NewRecorder()
+		callbackHandler(rec, withProviderPathValue(req), nil, false, guard)
+		return rec
+	}
+
+	callback("guard-state-1", 5*time.Minute)
+	callback("guard-state-2", 5*time.Minute)
+	if calls != 2 {
+		t.Fatalf("expected both guesses to reach the orchestrator, got %d", calls)
+	}
+
+	rec := callback("guard-state-3", 5*time.Minute)
+	if calls != 2 {
+		t.Fatal("expected a stale state to be rejected before the code exchange")
+	}
+	if rec.Code != http.StatusFound || !strings.Contains(rec.Header().Get("Location"), "status=error") {
+		t.Fatalf("expected an error redirect, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	callback("guard-state-4", 5*time.Second)
+	if calls != 3 {
+		t.Fatalf("expected a fresh state to reach the orchestrator, got %d calls", calls)
+	}
+}
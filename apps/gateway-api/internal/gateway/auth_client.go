@@ -2,6 +2,7 @@ package gateway
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
@@ -63,6 +64,8 @@ func parseOidcClientRegistrations(raw string) (map[string]map[string]oidcClientR
 		ClientID               string   `json:"client_id"`
 		RedirectOrigins        []string `json:"redirect_origins"`
 		SessionBindingRequired bool     `json:"session_binding_required"`
+		PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris"`
+		DefaultLandingPath     string   `json:"default_landing_path"`
 	}
 
 	var payload []registrationPayload
@@ -105,6 +108,20 @@ func parseOidcClientRegistrations(raw string) (map[string]map[string]oidcClientR
 			ClientID:               clientID,
 			RedirectOrigins:        origins,
 			SessionBindingRequired: entry.SessionBindingRequired,
+			DefaultLandingPath:     strings.TrimSpace(entry.DefaultLandingPath),
+		}
+		for _, rawURI := range entry.PostLogoutRedirectURIs {
+			target, err := parsePostLogoutRedirectURI(strings.TrimSpace(rawURI))
+			if err == nil && !reg.allowsRedirect(target) {
+				err = errors.New("must match a redirect origin of the registration")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("registration %d: post_logout_redirect_uri %q: %w", idx, rawURI, err)
+			}
+			reg.PostLogoutRedirectURIs = append(reg.PostLogoutRedirectURIs, target.String())
+		}
+		if err := validateLandingPath(reg.DefaultLandingPath); err != nil {
+			return nil, fmt.Errorf("registration %d: default_landing_path: %w", idx, err)
 		}
 		if _, exists := result[tenantKey][appID]; exists {
 			return nil, fmt.Errorf("registration %d: duplicate entry for tenant %q and app %q", idx, tenantID, appID)
@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	deviceCookieName = "gateway_device"
+	// maxDeviceCookieTTL is the longest lifetime browsers honour for a
+	// cookie.
+	maxDeviceCookieTTL = 400 * 24 * time.Hour
+	deviceIDBytes      = 24
+)
+
+var (
+	deviceCookieCodec     *securecookie.SecureCookie
+	deviceCookieCodecOnce sync.Once
+)
+
+// getDeviceCookieCodec returns the codec for device cookies. It shares the
+// state cookie keys but not their 30-day timestamp limit: device cookies
+// outlive it, and their age is checked against the tenant's lifetime instead.
+func getDeviceCookieCodec() *securecookie.SecureCookie {
+	deviceCookieCodecOnce.Do(func() {
+		deviceCookieCodec = newCookieCodec()
+		deviceCookieCodec.SetSerializer(securecookie.JSONEncoder{})
+		deviceCookieCodec.MaxAge(0)
+	})
+	return deviceCookieCodec
+}
+
+// deviceCookie is the encrypted value of the device cookie.
+type deviceCookie struct {
+	ID       string `json:"id"`
+	IssuedAt int64  `json:"iat"`
+}
+
+// deviceTrust is what the callback knows about the browser completing a
+// sign-in. Enterprise admins use it to require step-up only on new devices:
+// the gateway reports the device to the orchestrator, which decides.
+type deviceTrust struct {
+	// ID identifies the browser; it is freshly generated when the browser
+	// sent no valid device cookie.
+	ID string
+	// Known reports that the browser presented a device cookie issued within
+	// TTL by an earlier sign-in.
+	Known bool
+	// TTL is the device cookie lifetime; zero disables device cookies.
+	TTL time.Duration
+	// VerifyNew asks the orchestrator to require additional verification
+	// when the device is not known.
+	VerifyNew bool
+}
+
+// deviceSettings resolves the device cookie lifetime and new-device policy
+// for tenantID: GATEWAY_DEVICE_COOKIE_TTL and GATEWAY_DEVICE_VERIFY_NEW,
+// overridden by the tenant's auth settings.
+func deviceSettings(ctx context.Context, tenantID string) (time.Duration, bool) {
+	ttl := GetDurationEnv("GATEWAY_DEVICE_COOKIE_TTL", 0)
+	verify := getBoolEnv("GATEWAY_DEVICE_VERIFY_NEW")
+	settings := TenantConfig(withRequestScope(ctx, requestScope{TenantID: tenantID})).Auth
+	if settings.DeviceCookieTTL != "" {
+		// Validated when the tenant configuration was loaded.
+		ttl, _ = time.ParseDuration(settings.DeviceCookieTTL)
+	}
+	if settings.VerifyNewDevices != nil {
+		verify = *settings.VerifyNewDevices
+	}
+	return min(max(ttl, 0), maxDeviceCookieTTL), verify
+}
+
+// loadDeviceTrust reads the device cookie of r for a sign-in to tenantID. It
+// returns a zero deviceTrust when device cookies are disabled for the tenant.
+func loadDeviceTrust(r *http.Request, tenantID string) (deviceTrust, error) {
+	ttl, verify := deviceSettings(r.Context(), tenantID)
+	if ttl <= 0 {
+		return deviceTrust{}, nil
+	}
+	trust := deviceTrust{TTL: ttl, VerifyNew: verify}
+	if cookie, err := r.Cookie(deviceCookieName); err == nil {
+		var value deviceCookie
+		if err := getDeviceCookieCodec().Decode(deviceCookieName, cookie.Value, &value); err == nil && value.ID != "" {
+			trust.ID = value.ID
+			trust.Known = clockNow().Sub(time.Unix(value.IssuedAt, 0)) < ttl
+		}
+	}
+	if trust.ID == "" {
+		id, err := randomString(deviceIDBytes)
+		if err != nil {
+			return deviceTrust{}, err
+		}
+		trust.ID = id
+	}
+	return trust, nil
+}
+
+func (d deviceTrust) enabled() bool {
+	return d.TTL > 0
+}
+
+// hash is the device_hash sent to the orchestrator and recorded in audit
+// events; the device ID itself stays in the cookie.
+func (d deviceTrust) hash() string {
+	return gatewayAuditLogger.HashIdentity("device", d.ID)
+}
+
+// setDeviceCookie issues or renews the device cookie after a successful
+// sign-in, restarting its lifetime. It carries the state cookie attributes:
+// scoped to the auth routes, HttpOnly, and only issued over plain HTTP when
+// insecure cookies are allowed.
+func setDeviceCookie(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecure bool, trust deviceTrust) error {
+	if !trust.enabled() || (!IsRequestSecure(r, trustedProxies) && !allowInsecure) {
+		return nil
+	}
+	now := clockNow()
+	encoded, err := getDeviceCookieCodec().Encode(deviceCookieName, deviceCookie{ID: trust.ID, IssuedAt: now.Unix()})
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, newStateCookie(r, trustedProxies, allowInsecure, deviceCookieName, encoded, now.Add(trust.TTL)))
+	return nil
+}
@@ -0,0 +1,127 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// deviceCallback completes a fresh sign-in for tenantID, presenting device when it
+// is not nil, and returns the response and the code exchange payload.
+func deviceCallback(t *testing.T, tenantID string, device *http.Cookie) (*http.Response, map[string]any) {
+	t.Helper()
+	var exchanged map[string]any
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			_ = json.NewDecoder(req.Body).Decode(&exchanged)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("{}")),
+				Header:     make(http.Header),
+			}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+
+	state, err := randomString(16)
+	if err != nil {
+		t.Fatalf("generate state: %v", err)
+	}
+	data := stateData{
+		Provider:     "openrouter",
+		RedirectURI:  "https://app.example.com/complete",
+		CodeVerifier: "verifier",
+		ExpiresAt:    time.Now().Add(time.Minute),
+		State:        state,
+		TenantID:     tenantID,
+	}
+	encoded, err := getCookieHandler().Encode(stateCookieName(data.State), data)
+	if err != nil {
+		t.Fatalf("encode state: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?code=abc&state="+state, nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: stateCookieName(data.State), Value: encoded})
+	if device != nil {
+		req.AddCookie(device)
+	}
+	rec := httptest.NewRecorder()
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d", rec.Code)
+	}
+	return rec.Result(), exchanged
+}
+
+func deviceCookieFrom(res *http.Response) *http.Cookie {
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == deviceCookieName {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestCallbackRemembersDevice(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	t.Setenv("GATEWAY_DEVICE_COOKIE_TTL", "720h")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+	useTenantConfig(t, `{"tenants":{"acme":{"auth":{"verify_new_devices":true}},"globex":{"auth":{"device_cookie_ttl":"0s"}}}}`)
+	logs := captureAuditLogs(t)
+
+	res, exchanged := deviceCallback(t, "acme", nil)
+	device := deviceCookieFrom(res)
+	if device == nil || !device.HttpOnly || !device.Secure || device.Path != "/auth/" || device.MaxAge < int((720*time.Hour-time.Minute)/time.Second) {
+		t.Fatalf("expected a hardened device cookie, got %+v", device)
+	}
+	deviceHash, _ := exchanged["device_hash"].(string)
+	if !validBindingHash(deviceHash) || exchanged["device_known"] != false || exchanged["new_device_verification"] != true {
+		t.Fatalf("expected a new device needing verification, got %v", exchanged)
+	}
+	if !strings.Contains(logs.String(), `"device_known":false`) {
+		t.Fatalf("expected the callback audit to record the device, got %s", logs.String())
+	}
+
+	res, exchanged = deviceCallback(t, "acme", device)
+	if exchanged["device_hash"] != deviceHash || exchanged["device_known"] != true || exchanged["new_device_verification"] != nil {
+		t.Fatalf("expected the device to be recognised, got %v", exchanged)
+	}
+	if renewed := deviceCookieFrom(res); renewed == nil || renewed.Value == device.Value {
+		t.Fatal("expected the device cookie to be renewed")
+	}
+
+	res, exchanged = deviceCallback(t, "globex", device)
+	if _, ok := exchanged["device_hash"]; ok || deviceCookieFrom(res) != nil {
+		t.Fatalf("expected device cookies to be disabled for globex, got %v", exchanged)
+	}
+}
+
+func TestLoadDeviceTrustExpiresOldCookies(t *testing.T) {
+	t.Setenv("GATEWAY_DEVICE_COOKIE_TTL", "24h")
+	setupTestCookies(t)
+	encoded, err := getDeviceCookieCodec().Encode(deviceCookieName, deviceCookie{ID: "device-1", IssuedAt: time.Now().Add(-25 * time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("encode device cookie: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback", nil)
+	req.AddCookie(&http.Cookie{Name: deviceCookieName, Value: encoded})
+	trust, err := loadDeviceTrust(req, "")
+	if err != nil {
+		t.Fatalf("load device trust: %v", err)
+	}
+	if trust.ID != "device-1" || trust.Known {
+		t.Fatalf("expected an expired cookie to keep its ID but not be trusted, got %+v", trust)
+	}
+
+	req.Header.Set("Cookie", deviceCookieName+"=forged")
+	if trust, _ := loadDeviceTrust(req, ""); trust.ID == "device-1" || trust.Known {
+		t.Fatalf("expected a forged cookie to be ignored, got %+v", trust)
+	}
+}
@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// providerDisplayNames label the sign-in buttons for the built-in providers.
+// Providers missing here are shown by name.
+var providerDisplayNames = map[string]string{
+	"auth0":      "Auth0",
+	"azuread":    "Microsoft",
+	"google":     "Google",
+	"oidc":       "Single sign-on",
+	"okta":       "Okta",
+	"openrouter": "OpenRouter",
+}
+
+// loginMethod is one way to sign in offered by GET /auth/providers.
+type loginMethod struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	// Type is "oauth" for the {provider} routes, "ldap" for the password
+	// form and "negotiate" for Kerberos single sign-on.
+	Type      string `json:"type"`
+	LoginPath string `json:"login_path"`
+}
+
+// loginClient is a client registration the tenant may sign in with. The
+// registered client ID and redirect origins stay server-side.
+type loginClient struct {
+	App                    string `json:"app"`
+	SessionBindingRequired bool   `json:"session_binding_required"`
+}
+
+// loginDiscovery is the GET /auth/providers response.
+type loginDiscovery struct {
+	TenantID  string        `json:"tenant_id,omitempty"`
+	Providers []loginMethod `json:"providers"`
+	// RegistrationRequired reports that client_app must name one of Clients;
+	// without registrations any client_app signs in with the provider's
+	// default client.
+	RegistrationRequired bool          `json:"registration_required"`
+	Clients              []loginClient `json:"clients,omitempty"`
+}
+
+// providersHandler lists the sign-in options for tenant_id so the GUI can
+// render its login buttons before starting an authorization. Only providers
+// with configuration present are listed; the response carries no client IDs
+// or secrets.
+func providersHandler(w http.ResponseWriter, r *http.Request, ldapEnabled, negotiateEnabled bool) {
+	tenantID, err := normalizeTenantID(strings.TrimSpace(r.URL.Query().Get("tenant_id")))
+	if err != nil {
+		writeValidationError(w, r, []validationError{{
+			Field:   "tenant_id",
+			Message: tenantValidationErrorMessage,
+		}})
+		return
+	}
+	registrations, err := loadOidcClientRegistrations()
+	if err != nil {
+		authLog.ErrorContext(r.Context(), "gateway.auth.discovery_failed", slog.String("error", err.Error()))
+		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to load client configuration", nil)
+		return
+	}
+
+	discovery := loginDiscovery{
+		TenantID:             tenantID,
+		Providers:            []loginMethod{},
+		RegistrationRequired: len(registrations) > 0,
+		Clients:              tenantLoginClients(registrations, tenantID),
+	}
+	for _, name := range authProviderNames() {
+		p, _ := lookupAuthProvider(name)
+		if !p.Configured() {
+			continue
+		}
+		displayName := providerDisplayNames[name]
+		if displayName == "" {
+			displayName = name
+		}
+		discovery.Providers = append(discovery.Providers, loginMethod{
+			Name:        name,
+			DisplayName: displayName,
+			Type:        "oauth",
+			LoginPath:   "/auth/" + name + "/authorize",
+		})
+	}
+	if ldapEnabled {
+		discovery.Providers = append(discovery.Providers, loginMethod{Name: "ldap", DisplayName: "Directory", Type: "ldap", LoginPath: "/auth/ldap/login"})
+	}
+	if negotiateEnabled {
+		discovery.Providers = append(discovery.Providers, loginMethod{Name: "negotiate", DisplayName: "Windows sign-in", Type: "negotiate", LoginPath: "/auth/negotiate"})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(discovery); err != nil {
+		authLog.WarnContext(r.Context(), "gateway.auth.discovery_write_failed", slog.String("error", err.Error()))
+	}
+}
+
+// tenantLoginClients lists the registrations tenantID signs in with: its
+// own, and those registered without a tenant for apps it does not override,
+// matching getOidcClientRegistration.
+func tenantLoginClients(registrations map[string]map[string]oidcClientRegistration, tenantID string) []loginClient {
+	apps := make(map[string]oidcClientRegistration)
+	for app, reg := range registrations[""] {
+		apps[app] = reg
+	}
+	if key := normalizeTenantKey(tenantID); key != "" {
+		for app, reg := range registrations[key] {
+			apps[app] = reg
+		}
+	}
+	clients := make([]loginClient, 0, len(apps))
+	for app, reg := range apps {
+		clients = append(clients, loginClient{App: app, SessionBindingRequired: reg.SessionBindingRequired})
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].App < clients[j].App })
+	return clients
+}
@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func discoverProviders(t *testing.T, query string) (*httptest.ResponseRecorder, loginDiscovery) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	providersHandler(rec, httptest.NewRequest(http.MethodGet, "/auth/providers"+query, nil), true, false)
+	var discovery loginDiscovery
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &discovery); err != nil {
+			t.Fatalf("decode discovery: %v", err)
+		}
+	}
+	return rec, discovery
+}
+
+func TestProvidersHandlerListsConfiguredProviders(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "openrouter-secret-client")
+	t.Setenv("GOOGLE_OAUTH_CLIENT_ID", "google-secret-client")
+	setOidcRegistrations(t, `[
+		{"app":"gui","client_id":"default-gui-client"},
+		{"app":"cli","client_id":"default-cli-client"},
+		{"tenant_id":"acme","app":"gui","client_id":"acme-gui-client","session_binding_required":true}
+	]`)
+
+	rec, discovery := discoverProviders(t, "?tenant_id=acme")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "-client") {
+		t.Fatalf("expected no client IDs in the response, got %s", rec.Body.String())
+	}
+	var names []string
+	for _, p := range discovery.Providers {
+		names = append(names, p.Name+"="+p.LoginPath)
+	}
+	if got := strings.Join(names, ","); got != "google=/auth/google/authorize,openrouter=/auth/openrouter/authorize,ldap=/auth/ldap/login" {
+		t.Fatalf("unexpected providers %s", got)
+	}
+	if discovery.Providers[0].DisplayName != "Google" {
+		t.Fatalf("expected a display name, got %q", discovery.Providers[0].DisplayName)
+	}
+	if !discovery.RegistrationRequired || len(discovery.Clients) != 2 {
+		t.Fatalf("expected the tenant's two clients, got %+v", discovery)
+	}
+	if discovery.Clients[0] != (loginClient{App: "cli"}) || discovery.Clients[1] != (loginClient{App: "gui", SessionBindingRequired: true}) {
+		t.Fatalf("expected acme's gui registration to require session binding, got %+v", discovery.Clients)
+	}
+
+	_, other := discoverProviders(t, "?tenant_id=globex")
+	if other.Clients[1].SessionBindingRequired {
+		t.Fatal("expected other tenants to use the default registration")
+	}
+
+	if rec, _ := discoverProviders(t, "?tenant_id=bad%20tenant"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid tenant to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestProvidersHandlerWithoutRegistrations(t *testing.T) {
+	setOidcRegistrations(t, "")
+	_, discovery := discoverProviders(t, "")
+	if discovery.RegistrationRequired || discovery.Clients != nil {
+		t.Fatalf("expected no registrations, got %+v", discovery)
+	}
+}
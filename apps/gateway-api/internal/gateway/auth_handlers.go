@@ -4,14 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
@@ -23,45 +24,100 @@ func RegisterAuthRoutes(mux *http.ServeMux, cfg AuthRouteConfig) {
 		// panic: startup-only
 		panic(fmt.Sprintf("invalid trusted proxy configuration: %v", err))
 	}
+	if _, err := loadConsumedStateStore(); err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("invalid oauth state store configuration: %v", err))
+	}
+	negotiateCfg, err := loadNegotiateConfig()
+	if err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("invalid spnego configuration: %v", err))
+	}
+	ldapCfg, err := loadLDAPConfig()
+	if err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("invalid ldap configuration: %v", err))
+	}
 
 	// newRateLimiter is defined in global_rate_limit.go
-	limiter := newRateLimiter()
+	limiter := newRateLimiter("auth")
 	policy := newAuthRateLimitPolicy()
+	guard := loadCallbackGuard()
+	duplicates := loadAuthorizeDeduplicator()
+
+	// The OAuth flows are traced for the admin API when debug capture is on.
+	authorize := captureAuthTrace(authTraceKindAuthorize, withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		authorizeHandler(w, r, trustedProxies, cfg.AllowInsecureStateCookie, duplicates)
+	}, limiter, policy.loginBuckets, trustedProxies, extractAuthorizeIdentity))
+
+	callback := captureAuthTrace(authTraceKindCallback, withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		callbackHandler(w, r, trustedProxies, cfg.AllowInsecureStateCookie, guard)
+	}, limiter, policy.tokenBuckets, trustedProxies, extractCallbackIdentity))
+
+	link := captureAuthTrace(authFlowLink, withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		linkHandler(w, r, trustedProxies, cfg.AllowInsecureStateCookie)
+	}, limiter, policy.loginBuckets, trustedProxies, extractAuthorizeIdentity))
 
-	authorize := withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
-		authorizeHandler(w, r, trustedProxies, cfg.AllowInsecureStateCookie)
+	stepUp := captureAuthTrace(authFlowStepUp, withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		stepUpHandler(w, r, trustedProxies, cfg.AllowInsecureStateCookie)
+	}, limiter, policy.loginBuckets, trustedProxies, extractAuthorizeIdentity))
+
+	negotiate := withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		negotiateHandler(w, r, trustedProxies, negotiateCfg)
 	}, limiter, policy.loginBuckets, trustedProxies, extractAuthorizeIdentity)
 
-	callback := withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
-		callbackHandler(w, r, trustedProxies, cfg.AllowInsecureStateCookie)
-	}, limiter, policy.tokenBuckets, trustedProxies, extractCallbackIdentity)
+	// The username is in the request body, so only the IP bucket applies here;
+	// per-account attempts are bounded by the LDAP lockout instead.
+	ldapLogin := withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		ldapLoginHandler(w, r, trustedProxies, ldapCfg)
+	}, limiter, policy.loginBuckets, trustedProxies, nil)
 
-	mux.HandleFunc("/auth/", func(w http.ResponseWriter, r *http.Request) {
-		switch {
-		case strings.HasSuffix(r.URL.Path, "/authorize"):
-			if r.Method != http.MethodGet {
-				methodNotAllowed(w, r, http.MethodGet)
-				return
-			}
-			authorize(w, r)
-		case strings.HasSuffix(r.URL.Path, "/callback"):
-			if r.Method != http.MethodGet {
-				methodNotAllowed(w, r, http.MethodGet)
-				return
-			}
-			callback(w, r)
-		default:
-			http.NotFound(w, r)
-		}
-	})
+	jwks := withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		jwksHandler(w, r, trustedProxies)
+	}, limiter, policy.jwksBuckets, trustedProxies, nil)
+
+	// Logout needs no identity beyond the session it ends, so only the IP
+	// bucket applies.
+	logout := withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		logoutHandler(w, r, trustedProxies)
+	}, limiter, policy.loginBuckets, trustedProxies, nil)
+
+	// Discovery is public metadata like the key sets, so it shares their
+	// per-IP budget rather than the sign-in one.
+	providers := withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		providersHandler(w, r, ldapCfg != nil, negotiateCfg != nil)
+	}, limiter, policy.jwksBuckets, trustedProxies, nil)
+
+	handleFunc(mux, "GET /auth/providers", providers, policyJWKSRateLimit)
+	handleFunc(mux, "GET /auth/stepup", stepUp, policySession, policyAuthRateLimit)
+	handleFunc(mux, "GET /auth/logout", logout, policyAuthRateLimit)
+	if negotiateCfg != nil {
+		handleFunc(mux, "GET /auth/negotiate", negotiate, policyAuthRateLimit)
+	}
+	if ldapCfg != nil {
+		handleFunc(mux, "POST /auth/ldap/login", ldapLogin, policyAuthRateLimit)
+	}
+	handleFunc(mux, "GET /auth/{provider}/authorize", authorize, policyAuthRateLimit)
+	handleFunc(mux, "GET /auth/{provider}/link", link, policySession, policyAuthRateLimit)
+	handleFunc(mux, "GET /auth/{provider}/callback", callback, policyAuthRateLimit)
+	handleFunc(mux, "GET /auth/{provider}/jwks", jwks, policyJWKSRateLimit)
 }
 
-func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecureStateCookie bool) {
-	provider := strings.TrimPrefix(r.URL.Path, "/auth/")
-	provider = strings.TrimSuffix(provider, "/authorize")
+func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecureStateCookie bool, duplicates *authorizeDeduplicator) {
+	provider := r.PathValue("provider")
+	beginAuthorization(w, r, trustedProxies, allowInsecureStateCookie, provider, authFlow{}, duplicates)
+}
+
+// beginAuthorization starts an OAuth authorization code flow. Link and step-up
+// flows carry the caller's existing session in flow.Session. Sign-ins
+// repeating one answered within the deduplication window receive the same
+// authorization; duplicates is nil for flows that are never deduplicated.
+func beginAuthorization(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecureStateCookie bool, provider string, flow authFlow, duplicates *authorizeDeduplicator) {
+	emit := flow.auditEmitter()
+	link := flow.Session
 	cfg, err := getProviderConfig(provider)
 	if err != nil {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, map[string]any{
 			"provider": provider,
 			"error":    err.Error(),
 		})
@@ -76,18 +132,56 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 		TenantID:    rawTenant,
 		ClientApp:   strings.TrimSpace(r.URL.Query().Get("client_app")),
 		BindingID:   r.URL.Query().Get("session_binding"),
+		LandingPath: strings.TrimSpace(r.URL.Query().Get("landing_path")),
 	}
 	if errs := validateRequestParams(params); len(errs) > 0 {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
 			"provider": provider,
 			"reason":   errs[0].Message,
 		}, tenantHash))
 		writeValidationError(w, r, errs)
 		return
 	}
+	authContext, contextErrs := parseAuthContextParams(r.URL.Query())
+	if len(contextErrs) > 0 {
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, map[string]any{
+			"provider":          provider,
+			"reason":            contextErrs[0].Message,
+			"redirect_uri_hash": redirectHash(params.RedirectURI),
+		})
+		writeValidationError(w, r, contextErrs)
+		return
+	}
+	passthrough, passthroughErrs := parseAuthorizePassthrough(r.URL.Query())
+	if len(passthroughErrs) > 0 {
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, map[string]any{
+			"provider":          provider,
+			"reason":            passthroughErrs[0].Message,
+			"redirect_uri_hash": redirectHash(params.RedirectURI),
+		})
+		writeValidationError(w, r, passthroughErrs)
+		return
+	}
+	if flow.Kind == authFlowStepUp {
+		forceReauth := 0
+		authContext.MaxAge = &forceReauth
+		authContext.Prompt = "login"
+	}
+	if !authContext.empty() && !requestsOpenIDScope(cfg.Scopes) {
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, map[string]any{
+			"provider":          provider,
+			"reason":            "auth_context_unsupported",
+			"redirect_uri_hash": redirectHash(params.RedirectURI),
+		})
+		writeValidationError(w, r, []validationError{{
+			Field:   "provider",
+			Message: "provider does not support OpenID Connect authentication requests",
+		}})
+		return
+	}
 	tenantID, tenantErr := normalizeTenantID(params.TenantID)
 	if tenantErr != nil {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
 			"provider":          provider,
 			"reason":            tenantValidationErrorMessage,
 			"redirect_uri_hash": redirectHash(params.RedirectURI),
@@ -98,12 +192,30 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 		}})
 		return
 	}
+	if link != nil && link.TenantID != nil && *link.TenantID != "" {
+		sessionTenant, _ := normalizeTenantID(*link.TenantID)
+		if tenantID == "" {
+			tenantID = sessionTenant
+		} else if normalizeTenantKey(tenantID) != normalizeTenantKey(sessionTenant) {
+			emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+				"provider":          provider,
+				"reason":            "link_tenant_mismatch",
+				"redirect_uri_hash": redirectHash(params.RedirectURI),
+			}, hashTenantID(tenantID)))
+			writeValidationError(w, r, []validationError{{
+				Field:   "tenant_id",
+				Message: "tenant_id does not match the current session",
+			}})
+			return
+		}
+	}
 	params.TenantID = tenantID
 	tenantHash = hashTenantID(tenantID)
+	traceAuthTenant(r.Context(), tenantID)
 
 	clientApp, appErr := normalizeClientApp(params.ClientApp)
 	if appErr != nil {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
 			"provider":          provider,
 			"reason":            appErr.Error(),
 			"redirect_uri_hash": redirectHash(params.RedirectURI),
@@ -117,7 +229,7 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 
 	bindingID, bindingErr := normalizeSessionBinding(params.BindingID)
 	if bindingErr != nil {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
 			"provider":          provider,
 			"reason":            bindingErr.Error(),
 			"redirect_uri_hash": redirectHash(params.RedirectURI),
@@ -128,11 +240,12 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 		}})
 		return
 	}
+	r = r.WithContext(withRequestScope(r.Context(), requestScope{TenantID: tenantID, ClientApp: clientApp, BindingIDHash: hashSessionBinding(bindingID)}))
 
 	redirectURI := params.RedirectURI
 	redirectURL, parseErr := url.Parse(redirectURI)
 	if parseErr != nil {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
 			"provider":           provider,
 			"reason":             "invalid redirect_uri",
 			"redirect_uri_hash":  redirectHash(redirectURI),
@@ -146,7 +259,7 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 		return
 	}
 	if redirectErr := validateClientRedirectURL(redirectURL); redirectErr != nil {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
 			"provider":           provider,
 			"reason":             redirectErr.Error(),
 			"redirect_uri_hash":  redirectHash(redirectURI),
@@ -159,9 +272,15 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 		return
 	}
 
+	traceAuthStep(r.Context(), "request_validated", "", map[string]any{
+		"redirect_uri_host": redirectHost(redirectURI),
+		"client_app":        clientApp,
+		"session_binding":   bindingID != "",
+		"flow":              flow.Kind,
+	})
 	registration, registrationFound, registrationsConfigured, regErr := getOidcClientRegistration(tenantID, clientApp)
 	if regErr != nil {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
 			"provider":          provider,
 			"reason":            "client_registration_error",
 			"redirect_uri_hash": redirectHash(redirectURI),
@@ -172,7 +291,7 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 	selectedClientID := cfg.ClientID
 	if registrationFound {
 		if !registration.allowsRedirect(redirectURL) {
-			auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+			emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
 				"provider":          provider,
 				"reason":            "redirect_not_registered",
 				"redirect_uri_hash": redirectHash(redirectURI),
@@ -185,7 +304,7 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 			return
 		}
 		if registration.SessionBindingRequired && bindingID == "" {
-			auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+			emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
 				"provider":          provider,
 				"reason":            "session_binding_required",
 				"redirect_uri_hash": redirectHash(redirectURI),
@@ -199,7 +318,7 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 		}
 		selectedClientID = registration.ClientID
 	} else if registrationsConfigured {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
 			"provider":          provider,
 			"reason":            "client_not_registered",
 			"redirect_uri_hash": redirectHash(redirectURI),
@@ -212,10 +331,65 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 		return
 	}
 	cfg.ClientID = selectedClientID
+	traceAuthStep(r.Context(), "registration_selected", "", map[string]any{
+		"client_app":               clientApp,
+		"client_id":                selectedClientID,
+		"registration_found":       registrationFound,
+		"registrations_configured": registrationsConfigured,
+	})
+
+	landingPath := params.LandingPath
+	if landingPath == "" {
+		landingPath = registration.DefaultLandingPath
+	}
+	if landingErr := validateLandingPath(landingPath); landingErr != nil {
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+			"provider":          provider,
+			"reason":            "invalid_landing_path",
+			"redirect_uri_hash": redirectHash(redirectURI),
+			"validation_error":  landingErr.Error(),
+		}, tenantHash))
+		writeValidationError(w, r, []validationError{{
+			Field:   "landing_path",
+			Message: landingErr.Error(),
+		}})
+		return
+	}
+
+	dedupKey := authorizeDedupKey(ClientIP(r, trustedProxies), authorizeDedupBrowser(r), provider, redirectURL, tenantID, clientApp, bindingID, landingPath, authContext, passthrough)
+	if entry, ok := duplicates.lookup(dedupKey); ok {
+		resendAuthorization(w, r, trustedProxies, allowInsecureStateCookie, emit, entry, tenantHash)
+		return
+	}
+
+	scopePolicies, policyErr := loadOauthScopePolicies()
+	if policyErr != nil {
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
+			"provider":          provider,
+			"reason":            "scope_policy_error",
+			"redirect_uri_hash": redirectHash(redirectURI),
+		}, tenantHash))
+		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to load scope policy", nil)
+		return
+	}
+	if denied := disallowedScopes(scopePolicies, tenantID, clientApp, provider, cfg.Scopes); len(denied) > 0 {
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(map[string]any{
+			"provider":          provider,
+			"reason":            "scope_not_permitted",
+			"redirect_uri_hash": redirectHash(redirectURI),
+			"client_app":        clientApp,
+			"denied_scopes":     denied,
+		}, tenantHash))
+		writeValidationError(w, r, []validationError{{
+			Field:   "scope",
+			Message: "scopes not permitted: " + strings.Join(denied, ", "),
+		}})
+		return
+	}
 
 	state, codeVerifier, codeChallenge, err := generateStateAndPKCEFunc()
 	if err != nil {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
 			"provider":          provider,
 			"reason":            "state_generation_failed",
 			"redirect_uri_hash": redirectHash(redirectURI),
@@ -223,33 +397,44 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to generate state", nil)
 		return
 	}
+	traceAuthFlow(r.Context(), state)
+	nonce := ""
+	if requestsOpenIDScope(cfg.Scopes) {
+		nonce, err = generateNonceFunc()
+		if err != nil {
+			emit(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
+				"provider":          provider,
+				"reason":            "nonce_generation_failed",
+				"redirect_uri_hash": redirectHash(redirectURI),
+			}, tenantHash))
+			writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to generate state", nil)
+			return
+		}
+	}
 
 	data := stateData{
 		Provider:     provider,
 		RedirectURI:  redirectURI,
 		CodeVerifier: codeVerifier,
-		ExpiresAt:    time.Now().Add(stateTTL),
+		ExpiresAt:    clockNow().Add(stateTTL),
 		State:        state,
 		TenantID:     tenantID,
 		ClientApp:    clientApp,
 		BindingID:    bindingID,
 		ClientID:     selectedClientID,
+		Nonce:        nonce,
+		ACRValues:    authContext.ACRValues,
+		MaxAge:       authContext.MaxAge,
+		LandingPath:  landingPath,
 	}
-
-	if stateErr := setStateCookie(w, r, trustedProxies, allowInsecureStateCookie, data); stateErr != nil {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
-			"provider":          provider,
-			"reason":            "state_persistence_failed",
-			"redirect_uri_hash": redirectHash(redirectURI),
-			"error":             stateErr.Error(),
-		}, tenantHash))
-		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to persist state", nil)
-		return
+	if link != nil {
+		data.Flow = flow.Kind
+		data.SessionID = link.ID
 	}
 
-	authURL, err := buildAuthorizeURL(cfg, state, codeChallenge)
+	authURL, err := buildAuthorizeURL(cfg, state, codeChallenge, nonce, passthrough)
 	if err != nil {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
 			"provider":          provider,
 			"reason":            "authorize_url_build_failed",
 			"redirect_uri_hash": redirectHash(redirectURI),
@@ -257,9 +442,10 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to build authorize url", nil)
 		return
 	}
+	authContext.apply(authURL)
 
 	if err := validateAuthorizeRedirect(authURL, cfg.AuthorizeURL); err != nil {
-		auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
 			"provider":          provider,
 			"reason":            "authorize_url_validation_failed",
 			"redirect_uri_hash": redirectHash(redirectURI),
@@ -268,17 +454,59 @@ func authorizeHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*
 		return
 	}
 
-	auditAuthorizeEvent(r.Context(), r, trustedProxies, auditOutcomeSuccess, withTenantHash(map[string]any{
+	entry, duplicate := duplicates.remember(dedupKey, authorizeDedupEntry{state: data, authURL: authURL})
+	if duplicate {
+		resendAuthorization(w, r, trustedProxies, allowInsecureStateCookie, emit, entry, tenantHash)
+		return
+	}
+	if stateErr := setStateCookie(w, r, trustedProxies, allowInsecureStateCookie, data); stateErr != nil {
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
+			"provider":          provider,
+			"reason":            "state_persistence_failed",
+			"redirect_uri_hash": redirectHash(redirectURI),
+			"error":             stateErr.Error(),
+		}, tenantHash))
+		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to persist state", nil)
+		return
+	}
+
+	details := map[string]any{
 		"provider":          provider,
 		"redirect_uri_host": redirectHost(redirectURI),
-	}, tenantHash))
+	}
+	if len(passthrough) > 0 {
+		details["passthrough_params"] = passthrough.names()
+	}
+	emit(r.Context(), r, trustedProxies, auditOutcomeSuccess, withBindingHash(withTenantHash(details, tenantHash), hashSessionBinding(bindingID)))
 
 	sendRedirect(w, r, authURL)
 }
 
-func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecureStateCookie bool) {
-	provider := strings.TrimPrefix(r.URL.Path, "/auth/")
-	provider = strings.TrimSuffix(provider, "/callback")
+// resendAuthorization answers a duplicate sign-in with the authorization
+// already started for it, re-issuing its state cookie.
+func resendAuthorization(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecureStateCookie bool, emit authAuditEmitter, entry authorizeDedupEntry, tenantHash string) {
+	data := entry.state
+	traceAuthFlow(r.Context(), data.State)
+	if err := setStateCookie(w, r, trustedProxies, allowInsecureStateCookie, data); err != nil {
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, withTenantHash(map[string]any{
+			"provider":          data.Provider,
+			"reason":            "state_persistence_failed",
+			"redirect_uri_hash": redirectHash(data.RedirectURI),
+			"error":             err.Error(),
+		}, tenantHash))
+		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to persist state", nil)
+		return
+	}
+	emit(r.Context(), r, trustedProxies, auditOutcomeSuccess, withBindingHash(withTenantHash(map[string]any{
+		"provider":          data.Provider,
+		"redirect_uri_host": redirectHost(data.RedirectURI),
+		"deduplicated":      true,
+	}, tenantHash), hashSessionBinding(data.BindingID)))
+	sendRedirect(w, r, entry.authURL)
+}
+
+func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecureStateCookie bool, guard *callbackGuard) {
+	provider := r.PathValue("provider")
 	baseDetails := map[string]any{"provider": provider}
 
 	cfg, err := getProviderConfig(provider)
@@ -307,6 +535,7 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 		return
 	}
 
+	traceAuthFlow(r.Context(), params.State)
 	data, err := readStateCookie(r, params.State)
 	if err != nil || data.Provider != provider {
 		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
@@ -318,9 +547,57 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 	}
 
 	deleteStateCookie(w, r, trustedProxies, allowInsecureStateCookie, params.State)
+	stateStore, storeErr := loadConsumedStateStore()
+	if storeErr != nil {
+		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
+			"reason": "state_store_unavailable",
+			"error":  storeErr.Error(),
+		}))
+		writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "authentication temporarily unavailable", nil)
+		return
+	}
+	if !consumedStatesDurable(stateStore) && data.ExpiresAt.Add(-stateTTL).Before(processStarted) && currentColdStartPolicy().failClosed(clockNow()) {
+		// The previous process may already have consumed this state.
+		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+			"reason": "state_issued_before_restart",
+		}))
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "invalid or expired state", nil)
+		return
+	}
+	firstUse, consumeErr := stateStore.Consume(r.Context(), params.State, data.ExpiresAt)
+	if consumeErr != nil {
+		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
+			"reason": "state_store_unavailable",
+			"error":  consumeErr.Error(),
+		}))
+		writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "authentication temporarily unavailable", nil)
+		return
+	}
+	if !firstUse {
+		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+			"reason": "state_replayed",
+			"state":  params.State,
+		}))
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "invalid or expired state", nil)
+		return
+	}
+	guardKeys := callbackGuardKeys(ClientIP(r, trustedProxies), callbackIdentity(data.RedirectURI))
+	if guard.stateTooOld(guardKeys, data) {
+		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+			"reason":            "callback_state_not_fresh",
+			"redirect_uri_hash": redirectHash(data.RedirectURI),
+		}))
+		redirectWithStatus(w, r, data, "error", "authentication expired, please sign in again")
+		return
+	}
+	emit := auditCallbackEvent
+	if data.Flow != "" {
+		emit = authFlow{Kind: data.Flow}.auditEmitter()
+		baseDetails = mergeDetails(baseDetails, map[string]any{"flow": data.Flow})
+	}
 	tenantID, tenantErr := normalizeTenantID(data.TenantID)
 	if tenantErr != nil {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
 			"reason": "invalid_state_tenant",
 			"state":  params.State,
 		}))
@@ -328,6 +605,7 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 		return
 	}
 	data.TenantID = tenantID
+	traceAuthTenant(r.Context(), tenantID)
 	tenantHash := hashTenantID(data.TenantID)
 	if tenantHash != "" {
 		baseDetails = mergeDetails(baseDetails, map[string]any{"tenant_id_hash": tenantHash})
@@ -335,7 +613,7 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 
 	clientApp, appErr := normalizeClientApp(data.ClientApp)
 	if appErr != nil {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
 			"reason": "invalid_state_client_app",
 			"state":  params.State,
 		}))
@@ -349,7 +627,7 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 
 	bindingID, bindingErr := normalizeSessionBinding(data.BindingID)
 	if bindingErr != nil {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
 			"reason":           "invalid_state_binding",
 			"state":            params.State,
 			"validation_error": bindingErr.Error(),
@@ -358,9 +636,12 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 		return
 	}
 	data.BindingID = bindingID
+	bindingHash := hashSessionBinding(bindingID)
+	baseDetails = withBindingHash(baseDetails, bindingHash)
+	r = r.WithContext(withRequestScope(r.Context(), requestScope{TenantID: data.TenantID, ClientApp: clientApp, BindingIDHash: bindingHash}))
 	stateClientID := strings.TrimSpace(data.ClientID)
 	if len(stateClientID) > maxClientIDLength {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
 			"reason": "invalid_state_client_id",
 			"state":  params.State,
 		}))
@@ -368,10 +649,17 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 		return
 	}
 	data.ClientID = stateClientID
+	traceAuthStep(r.Context(), "state_verified", "", map[string]any{
+		"flow":              data.Flow,
+		"client_app":        clientApp,
+		"state_age":         clockNow().Sub(data.ExpiresAt.Add(-stateTTL)).Round(time.Second).String(),
+		"session_binding":   bindingID != "",
+		"redirect_uri_host": redirectHost(data.RedirectURI),
+	})
 
 	registration, registrationFound, registrationsConfigured, regErr := getOidcClientRegistration(data.TenantID, clientApp)
 	if regErr != nil {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
 			"reason": "client_registration_error",
 		}))
 		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to load client configuration", nil)
@@ -381,14 +669,14 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 	if registrationFound {
 		expectedClientID = registration.ClientID
 	} else if registrationsConfigured {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
 			"reason": "client_not_registered",
 		}))
 		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "invalid or expired state", nil)
 		return
 	}
 	if stateClientID != "" && stateClientID != expectedClientID {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
 			"reason":                  "state_client_id_mismatch",
 			"state":                   params.State,
 			"state_client_id_present": true,
@@ -397,7 +685,13 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 		return
 	}
 	effectiveClientID := expectedClientID
-	payload := map[string]string{
+	traceAuthStep(r.Context(), "registration_selected", "", map[string]any{
+		"client_app":               clientApp,
+		"client_id":                effectiveClientID,
+		"registration_found":       registrationFound,
+		"registrations_configured": registrationsConfigured,
+	})
+	payload := map[string]any{
 		"code":          params.Code,
 		"code_verifier": data.CodeVerifier,
 		"redirect_uri":  cfg.RedirectURI,
@@ -406,45 +700,100 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 	if data.TenantID != "" {
 		payload["tenant_id"] = data.TenantID
 	}
+	if data.Nonce != "" {
+		payload["nonce"] = data.Nonce
+	}
+	if bindingHash != "" {
+		// The orchestrator stores the hash on the session and returns it as
+		// bindingIdHash so later audits can name the originating login.
+		payload["session_binding_hash"] = bindingHash
+	}
+	device, deviceErr := loadDeviceTrust(r, data.TenantID)
+	if deviceErr != nil {
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
+			"reason": "device_id_failed",
+			"error":  deviceErr.Error(),
+		}))
+		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to identify device", nil)
+		return
+	}
+	if device.enabled() {
+		// The orchestrator decides whether a device it has not seen needs
+		// step-up; new_device_verification asks it to.
+		baseDetails = mergeDetails(baseDetails, map[string]any{"device_hash": device.hash()})
+		payload["device_hash"] = device.hash()
+		payload["device_known"] = device.Known
+		if device.VerifyNew && !device.Known {
+			payload["new_device_verification"] = true
+		}
+	}
+	if p, ok := lookupAuthProvider(provider); ok {
+		p.ExchangePayload(cfg, payload)
+	}
+	var linkAuthHeader, linkCookieHeader string
+	if data.SessionID != "" {
+		var ok bool
+		linkAuthHeader, linkCookieHeader, ok = verifyFlowSession(w, r, trustedProxies, emit, baseDetails, data)
+		if !ok {
+			return
+		}
+		payload["session_id"] = data.SessionID
+		switch data.Flow {
+		case authFlowLink:
+			payload["link"] = true
+		case authFlowStepUp:
+			payload["step_up"] = true
+		}
+	}
 
 	buf, err := json.Marshal(payload)
 	if err != nil {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
 			"reason":            "payload_encoding_failed",
 			"redirect_uri_hash": redirectHash(data.RedirectURI),
 		}))
 		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to encode payload", nil)
 		return
 	}
-	orchestratorURL := strings.TrimRight(GetEnv("ORCHESTRATOR_URL", "http://127.0.0.1:4000"), "/")
-	endpoint := fmt.Sprintf("%s/auth/%s/callback", orchestratorURL, url.PathEscape(provider))
-	ctx, cancel := context.WithTimeout(r.Context(), orchestratorTimeout)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(buf))
-	if err != nil {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
-			"reason":            "upstream_request_failed",
+	client, orchestratorURL, clientErr := currentOrchestrator()
+	if clientErr != nil {
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
+			"reason":            "upstream_client_not_configured",
 			"redirect_uri_hash": redirectHash(data.RedirectURI),
 		}))
-		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to create upstream request", nil)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "orchestrator client not configured", nil)
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client, clientErr := getOrchestratorClient()
-	if clientErr != nil {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
-			"reason":            "upstream_client_not_configured",
+	endpoint := orchestratorAPI.URL(r.Context(), orchestratorURL, orchestratorAuthCallback, "provider", provider)
+	newRequest := func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(buf))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if linkAuthHeader != "" {
+			req.Header.Set("Authorization", linkAuthHeader)
+		}
+		if linkCookieHeader != "" {
+			req.Header.Set("Cookie", linkCookieHeader)
+		}
+		return req, nil
+	}
+	if _, err := newRequest(r.Context()); err != nil {
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
+			"reason":            "upstream_request_failed",
 			"redirect_uri_hash": redirectHash(data.RedirectURI),
 		}))
-		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "orchestrator client not configured", nil)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to create upstream request", nil)
 		return
 	}
 
-	resp, err := client.Do(req)
+	upstreamStart := clockNow()
+	policy := providerCallPolicyFor(provider)
+	resp, err := policy.do(r.Context(), client, policy.callbackTimeout, newRequest, retryableExchange)
 	if err != nil {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
 			"reason":            "upstream_unreachable",
 			"redirect_uri_hash": redirectHash(data.RedirectURI),
 		}))
@@ -452,7 +801,24 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 		return
 	}
 	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
+	body, err := readUpstreamBody(resp.Body, maxUpstreamResponseBytes())
+	if err != nil {
+		reason := "upstream_read_failed"
+		if errors.Is(err, errUpstreamResponseTooLarge) {
+			reason = "upstream_response_too_large"
+		}
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
+			"reason":            reason,
+			"status_code":       resp.StatusCode,
+			"redirect_uri_hash": redirectHash(data.RedirectURI),
+		}))
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "invalid response from orchestrator", nil)
+		return
+	}
+	traceAuthStep(r.Context(), "upstream_response", "", map[string]any{
+		"status_code": resp.StatusCode,
+		"duration":    clockNow().Sub(upstreamStart).String(),
+	})
 	if resp.StatusCode >= 400 {
 		safeError, detailedError, errorCode := sanitizeOrchestratorError(body)
 		details := mergeDetails(baseDetails, map[string]any{
@@ -464,20 +830,51 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 		if errorCode != "" {
 			details["error_code"] = errorCode
 		}
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, details)
-		redirectWithStatus(w, r, data.RedirectURI, data.State, "error", safeError, data.BindingID)
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, details)
+		if errorCode == "invalid_grant" {
+			if tripped := guard.recordFailure(guardKeys); len(tripped) > 0 {
+				emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+					"reason":          "callback_failure_threshold",
+					"scopes":          callbackGuardScopes(tripped),
+					"strict_duration": guard.duration.String(),
+				}))
+			}
+		}
+		redirectWithStatus(w, r, data, "error", safeError)
 		return
 	}
 
+	var claims idTokenClaims
+	if validatesIDTokens(provider) {
+		now := clockNow()
+		var err error
+		claims, err = verifyIDToken(r.Context(), provider, extractIDToken(body), effectiveClientID, data.Nonce, now)
+		if err == nil && data.MaxAge != nil {
+			err = checkIDTokenAuthTime(claims, *data.MaxAge, now)
+		}
+		if err != nil {
+			// Upstream session cookies are deliberately dropped so a session
+			// backed by an unverified ID token is never handed to the client.
+			emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+				"reason":            "id_token_invalid",
+				"error":             err.Error(),
+				"redirect_uri_hash": redirectHash(data.RedirectURI),
+			}))
+			redirectWithStatus(w, r, data, "error", "authentication failed")
+			return
+		}
+		traceAuthStep(r.Context(), "id_token_validated", "", map[string]any{"acr": claims.ACR})
+	}
+
 	normalizedCookies, hardenedDetails, droppedDetails := normalizeUpstreamCookies(resp.Cookies())
 	if len(droppedDetails) > 0 {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeSuccess, mergeDetails(baseDetails, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeSuccess, mergeDetails(baseDetails, map[string]any{
 			"action":  "upstream_cookie_rejected",
 			"cookies": droppedDetails,
 		}))
 	}
 	if len(hardenedDetails) > 0 {
-		auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeSuccess, mergeDetails(baseDetails, map[string]any{
+		emit(r.Context(), r, trustedProxies, auditOutcomeSuccess, mergeDetails(baseDetails, map[string]any{
 			"action":  "upstream_cookie_hardened",
 			"cookies": hardenedDetails,
 		}))
@@ -485,16 +882,41 @@ func callbackHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*n
 	for _, cookie := range normalizedCookies {
 		http.SetCookie(w, cookie)
 	}
+	if err := setDeviceCookie(w, r, trustedProxies, allowInsecureStateCookie, device); err != nil {
+		authLog.WarnContext(r.Context(), "gateway.auth.device_cookie_failed", slog.String("error", err.Error()))
+	}
 
-	auditCallbackEvent(r.Context(), r, trustedProxies, auditOutcomeSuccess, mergeDetails(baseDetails, map[string]any{
-		"redirect_uri_host": redirectHost(data.RedirectURI),
-	}))
+	var extra url.Values
+	successDetails := map[string]any{"redirect_uri_host": redirectHost(data.RedirectURI)}
+	if device.enabled() {
+		successDetails["device_known"] = device.Known
+	}
+	if data.Flow == authFlowStepUp {
+		token, tokenErr := issueStepUpToken(provider, data, claims, clockNow())
+		if tokenErr != nil {
+			emit(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
+				"reason":            "stepup_token_failed",
+				"error":             tokenErr.Error(),
+				"redirect_uri_hash": redirectHash(data.RedirectURI),
+			}))
+			redirectWithStatus(w, r, data, "error", "step-up authentication unavailable")
+			return
+		}
+		extra = url.Values{"step_up_token": {token}}
+		successDetails["step_up_completed"] = true
+		if claims.ACR != "" {
+			successDetails["acr"] = claims.ACR
+		}
+	}
 
-	redirectWithStatus(w, r, data.RedirectURI, data.State, "success", "", data.BindingID)
+	emit(r.Context(), r, trustedProxies, auditOutcomeSuccess, mergeDetails(baseDetails, successDetails))
+
+	redirectWithParams(w, r, data, "success", "", extra)
 }
 
 func redirectError(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecureStateCookie bool, errParam string) {
 	state := r.URL.Query().Get("state")
+	traceAuthFlow(r.Context(), state)
 	if state == "" {
 		auditRedirectEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, map[string]any{
 			"reason": errParam,
@@ -522,25 +944,39 @@ func redirectError(w http.ResponseWriter, r *http.Request, trustedProxies []*net
 		details["tenant_id_hash"] = tenantHash
 	}
 	auditRedirectEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, details)
-	redirectWithStatus(w, r, data.RedirectURI, data.State, "error", errParam, data.BindingID)
+	redirectWithStatus(w, r, data, "error", errParam)
+}
+
+// redirectWithStatus returns the user to the client's redirect_uri with the
+// outcome of the flow, echoing the state, session binding and landing path
+// the client sent so it can resume where the user started.
+func redirectWithStatus(w http.ResponseWriter, r *http.Request, data stateData, status, message string) {
+	redirectWithParams(w, r, data, status, message, nil)
 }
 
-func redirectWithStatus(w http.ResponseWriter, r *http.Request, redirectURI, state, status, message, binding string) {
-	target, err := url.Parse(redirectURI)
+// redirectWithParams is redirectWithStatus with additional query parameters.
+func redirectWithParams(w http.ResponseWriter, r *http.Request, data stateData, status, message string, extra url.Values) {
+	target, err := url.Parse(data.RedirectURI)
 	if err != nil {
 		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "invalid redirect_uri", nil)
 		return
 	}
 	q := target.Query()
-	if state != "" {
-		q.Set("state", state)
+	for key, values := range extra {
+		q[key] = values
+	}
+	if data.State != "" {
+		q.Set("state", data.State)
 	}
 	q.Set("status", status)
 	if status == "error" && message != "" {
 		q.Set("error", message)
 	}
-	if binding != "" {
-		q.Set("session_binding", binding)
+	if data.BindingID != "" {
+		q.Set("session_binding", data.BindingID)
+	}
+	if data.LandingPath != "" {
+		q.Set("landing_path", data.LandingPath)
 	}
 	target.RawQuery = q.Encode()
 	sendRedirect(w, r, target)
@@ -594,16 +1030,30 @@ func writeErrorResponse(w http.ResponseWriter, r *http.Request, status int, code
 		payload.RequestID = requestID
 	}
 
+	buf := errorResponseBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		errorResponseBuffers.Put(buf)
+	}()
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(payload); err != nil {
+	if err := json.NewEncoder(buf).Encode(payload); err != nil {
 		slog.ErrorContext(r.Context(), "gateway.write_error_response_failed", slog.String("error", err.Error()))
+		w.WriteHeader(status)
+		return
 	}
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
 }
 
+// errorResponseBuffers holds the buffers error responses are encoded into
+// before they are written with a Content-Length.
+var errorResponseBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
 type authRateLimitPolicy struct {
 	loginBuckets []rateLimitBucket
 	tokenBuckets []rateLimitBucket
+	jwksBuckets  []rateLimitBucket
 }
 
 func newAuthRateLimitPolicy() authRateLimitPolicy {
@@ -611,6 +1061,8 @@ func newAuthRateLimitPolicy() authRateLimitPolicy {
 	ipLimit := ResolveLimit([]string{"GATEWAY_AUTH_IP_RATE_LIMIT_MAX", "GATEWAY_AUTH_RATE_LIMIT_MAX"}, defaultAuthIPLimit)
 	identityWindow := ResolveDuration([]string{"GATEWAY_AUTH_ID_RATE_LIMIT_WINDOW"}, defaultAuthIdentityWindow)
 	identityLimit := ResolveLimit([]string{"GATEWAY_AUTH_ID_RATE_LIMIT_MAX"}, defaultAuthIdentityLimit)
+	jwksWindow := ResolveDuration([]string{"GATEWAY_AUTH_JWKS_RATE_LIMIT_WINDOW"}, defaultAuthJWKSWindow)
+	jwksLimit := ResolveLimit([]string{"GATEWAY_AUTH_JWKS_RATE_LIMIT_MAX"}, defaultAuthJWKSLimit)
 
 	return authRateLimitPolicy{
 		loginBuckets: []rateLimitBucket{
@@ -621,6 +1073,9 @@ func newAuthRateLimitPolicy() authRateLimitPolicy {
 			{Endpoint: "auth_token", IdentityType: "ip", Window: ipWindow, Limit: ipLimit},
 			{Endpoint: "auth_token", IdentityType: "client", Window: identityWindow, Limit: identityLimit},
 		},
+		jwksBuckets: []rateLimitBucket{
+			{Endpoint: "auth_jwks", IdentityType: "ip", Window: jwksWindow, Limit: jwksLimit},
+		},
 	}
 }
 
@@ -644,6 +1099,7 @@ func withAuthRateLimit(
 
 		var identity string
 		identityLoaded := false
+		var headers rateLimitHeaderTracker
 
 		for _, bucket := range buckets {
 			var key string
@@ -665,7 +1121,7 @@ func withAuthRateLimit(
 
 			allowed, retryAfter, err := limiter.Allow(r.Context(), bucket, key)
 			if err != nil {
-				slog.WarnContext(r.Context(), "gateway.ratelimit.allow_error",
+				authLog.WarnContext(r.Context(), "gateway.ratelimit.allow_error",
 					slog.String("endpoint", bucket.Endpoint),
 					slog.String("identity_type", bucket.IdentityType),
 					slog.String("error", err.Error()),
@@ -673,11 +1129,14 @@ func withAuthRateLimit(
 				continue
 			}
 			if !allowed {
+				headers.deny(w, bucket, retryAfter)
 				respondTooManyRequests(w, r, retryAfter)
 				return
 			}
+			headers.observe(r.Context(), limiter, bucket, key)
 		}
 
+		headers.write(w)
 		handler(w, r)
 	}
 }
@@ -710,19 +1169,26 @@ func extractCallbackIdentity(r *http.Request) (string, bool) {
 	if err != nil {
 		return "", false
 	}
-	if data.RedirectURI == "" {
-		return "", false
+	identity := callbackIdentity(data.RedirectURI)
+	return identity, identity != ""
+}
+
+// callbackIdentity is the redirect host a callback returns to, used to key
+// the callback rate limit and the callback guard.
+func callbackIdentity(redirectURI string) string {
+	if redirectURI == "" {
+		return ""
 	}
-	parsed, err := url.Parse(data.RedirectURI)
+	parsed, err := url.Parse(redirectURI)
 	if err != nil {
-		return data.RedirectURI, true
+		return redirectURI
 	}
 	host := strings.TrimSpace(parsed.Hostname())
 	if host == "" {
-		return data.RedirectURI, true
+		return redirectURI
 	}
 	if port := strings.TrimSpace(parsed.Port()); port != "" {
 		host = fmt.Sprintf("%s:%s", host, port)
 	}
-	return strings.ToLower(host), true
+	return strings.ToLower(host)
 }
@@ -0,0 +1,367 @@
+package gateway
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512" // registers SHA-384/512 for RS384, PS512 and friends
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	maxIDTokenLength = 16 * 1024
+)
+
+var errIDTokenMissing = errors.New("id_token missing from orchestrator response")
+
+type idTokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type idTokenClaims struct {
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	AZP       string          `json:"azp"`
+	ExpiresAt *int64          `json:"exp"`
+	IssuedAt  *int64          `json:"iat"`
+	Nonce     string          `json:"nonce"`
+	AuthTime  *int64          `json:"auth_time"`
+	ACR       string          `json:"acr"`
+	// TenantID is the Entra ID directory that issued the token.
+	TenantID string `json:"tid"`
+}
+
+func idTokenValidationEnabled() bool {
+	return getBoolEnv("GATEWAY_VALIDATE_ID_TOKEN")
+}
+
+// validatesIDTokens reports whether callbacks from provider have their ID
+// token checked: GATEWAY_VALIDATE_ID_TOKEN is on and the provider issues ID
+// tokens. Providers without IDTokenIssuers, such as openrouter, publish no
+// key set to check them against.
+func validatesIDTokens(provider string) bool {
+	if !idTokenValidationEnabled() {
+		return false
+	}
+	p, ok := lookupAuthProvider(provider)
+	return ok && len(p.IDTokenIssuers()) > 0
+}
+
+// extractIDToken pulls the ID token from the orchestrator callback response.
+// Both snake_case and camelCase field names are accepted.
+func extractIDToken(body []byte) string {
+	var payload struct {
+		IDToken      string `json:"id_token"`
+		IDTokenCamel string `json:"idToken"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+	if payload.IDToken != "" {
+		return payload.IDToken
+	}
+	return payload.IDTokenCamel
+}
+
+// idTokenIssuerValidator is implemented by providers whose issuer depends on
+// the token itself, such as multi-tenant Entra ID authorities.
+type idTokenIssuerValidator interface {
+	ValidIDTokenIssuer(claims idTokenClaims) bool
+}
+
+// expectedIDTokenIssuers lists the iss values accepted for provider and
+// claims.
+func expectedIDTokenIssuers(provider string, claims idTokenClaims) []string {
+	p, ok := lookupAuthProvider(provider)
+	if !ok {
+		return nil
+	}
+	if validator, ok := p.(idTokenIssuerValidator); ok && validator.ValidIDTokenIssuer(claims) {
+		return []string{strings.TrimRight(claims.Issuer, "/")}
+	}
+	return p.IDTokenIssuers()
+}
+
+// validateIDToken verifies the signature of rawToken against the provider's
+// cached JWKS and checks issuer, audience, expiry and nonce.
+func validateIDToken(ctx context.Context, provider, rawToken, clientID, nonce string, now time.Time) error {
+	_, err := verifyIDToken(ctx, provider, rawToken, clientID, nonce, now)
+	return err
+}
+
+// verifyIDToken performs the checks of validateIDToken and returns the
+// verified claims.
+func verifyIDToken(ctx context.Context, provider, rawToken, clientID, nonce string, now time.Time) (idTokenClaims, error) {
+	if rawToken == "" {
+		return idTokenClaims{}, errIDTokenMissing
+	}
+	if len(rawToken) > maxIDTokenLength {
+		return idTokenClaims{}, fmt.Errorf("id_token exceeds %d bytes", maxIDTokenLength)
+	}
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return idTokenClaims{}, errors.New("id_token is not a compact JWS")
+	}
+
+	var header idTokenHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return idTokenClaims{}, fmt.Errorf("invalid id_token header: %w", err)
+	}
+	hash, err := idTokenHash(header.Alg)
+	if err != nil {
+		return idTokenClaims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("invalid id_token signature encoding: %w", err)
+	}
+
+	jwksURL, err := resolveJWKSURL(provider)
+	if err != nil {
+		return idTokenClaims{}, err
+	}
+	doc, _, err := loadJWKS(ctx, provider, jwksURL, header.Kid)
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("failed to load jwks: %w", err)
+	}
+	key, err := findJWK(doc.body, header.Kid, header.Alg)
+	if err != nil {
+		return idTokenClaims{}, err
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifyJWSSignature(key, header.Alg, hash, hasher.Sum(nil), signature); err != nil {
+		return idTokenClaims{}, err
+	}
+
+	var claims idTokenClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return idTokenClaims{}, fmt.Errorf("invalid id_token claims: %w", err)
+	}
+	if err := validateIDTokenClaims(claims, expectedIDTokenIssuers(provider, claims), clientID, nonce, now); err != nil {
+		return idTokenClaims{}, err
+	}
+	return claims, nil
+}
+
+func validateIDTokenClaims(claims idTokenClaims, issuers []string, clientID, nonce string, now time.Time) error {
+	issuerMatched := false
+	for _, issuer := range issuers {
+		if issuer != "" && strings.TrimRight(claims.Issuer, "/") == issuer {
+			issuerMatched = true
+			break
+		}
+	}
+	if !issuerMatched {
+		return errors.New("id_token issuer mismatch")
+	}
+
+	audiences, err := parseAudience(claims.Audience)
+	if err != nil {
+		return err
+	}
+	audienceMatched := false
+	for _, aud := range audiences {
+		if aud == clientID {
+			audienceMatched = true
+			break
+		}
+	}
+	if !audienceMatched {
+		return errors.New("id_token audience mismatch")
+	}
+	if len(audiences) > 1 && claims.AZP != clientID {
+		return errors.New("id_token authorized party mismatch")
+	}
+
+	// exp and iat are set by the issuer's clock.
+	skew := clockSkewTolerance()
+	if claims.ExpiresAt == nil || now.After(time.Unix(*claims.ExpiresAt, 0).Add(skew)) {
+		return errors.New("id_token expired")
+	}
+	if claims.IssuedAt != nil && time.Unix(*claims.IssuedAt, 0).After(now.Add(skew)) {
+		return errors.New("id_token issued in the future")
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return errors.New("id_token nonce mismatch")
+	}
+	return nil
+}
+
+func parseAudience(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("id_token audience missing")
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil, errors.New("id_token audience invalid")
+	}
+	return many, nil
+}
+
+func decodeJWTSegment(segment string, dst any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, dst)
+}
+
+func idTokenHash(alg string) (crypto.Hash, error) {
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		return crypto.SHA256, nil
+	case "RS384", "PS384", "ES384":
+		return crypto.SHA384, nil
+	case "RS512", "PS512":
+		return crypto.SHA512, nil
+	default:
+		// Symmetric and "none" algorithms are never acceptable for ID tokens
+		// validated against a public key set.
+		return 0, fmt.Errorf("unsupported id_token algorithm %q", alg)
+	}
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// findJWK returns the public key for kid from a JWKS document. When the token
+// omits kid the key set must contain exactly one candidate for the algorithm.
+func findJWK(body []byte, kid, alg string) (crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %w", err)
+	}
+	var candidates []jsonWebKey
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if key.Alg != "" && key.Alg != alg {
+			continue
+		}
+		if kid != "" && key.Kid != kid {
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+	if len(candidates) != 1 {
+		return nil, errors.New("no matching signing key for id_token")
+	}
+	return candidates[0].publicKey()
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("rsa key too small")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ec point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("invalid jwk integer")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+func verifyJWSSignature(key crypto.PublicKey, alg string, hash crypto.Hash, digest, signature []byte) error {
+	invalid := errors.New("id_token signature invalid")
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		case "PS":
+			err = rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		default:
+			return invalid
+		}
+		if err != nil {
+			return invalid
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" {
+			return invalid
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return invalid
+		}
+		return nil
+	default:
+		return invalid
+	}
+}
@@ -0,0 +1,333 @@
+package gateway
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testIDTokenClientID = "google-client"
+	testIDTokenNonce    = "nonce-123"
+)
+
+type idTokenSigner struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newIDTokenSigner(t *testing.T) *idTokenSigner {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ec key: %v", err)
+	}
+	return &idTokenSigner{rsaKey: rsaKey, ecKey: ecKey}
+}
+
+func (s *idTokenSigner) jwks() string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	set := map[string]any{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa-1", "use": "sig", "alg": "RS256",
+			"n": b64(s.rsaKey.N.Bytes()),
+			"e": b64(big.NewInt(int64(s.rsaKey.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": "ec-1", "crv": "P-256",
+			"x": b64(s.ecKey.X.FillBytes(make([]byte, 32))),
+			"y": b64(s.ecKey.Y.FillBytes(make([]byte, 32))),
+		},
+	}}
+	raw, _ := json.Marshal(set)
+	return string(raw)
+}
+
+func (s *idTokenSigner) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		sig, err := rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		signature = sig
+	case "ES256":
+		r, sVal, err := ecdsa.Sign(rand.Reader, s.ecKey, digest[:])
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), sVal.FillBytes(make([]byte, 32))...)
+	default:
+		signature = []byte{}
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validIDTokenClaims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":   "https://accounts.google.com",
+		"aud":   testIDTokenClientID,
+		"sub":   "user-1",
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": testIDTokenNonce,
+	}
+}
+
+func setupIDTokenIssuer(t *testing.T) *idTokenSigner {
+	t.Helper()
+	signer := newIDTokenSigner(t)
+	body := &atomic.Value{}
+	body.Store(signer.jwks())
+	setupJWKSUpstream(t, body)
+	return signer
+}
+
+func TestValidateIDTokenAcceptsSignedTokens(t *testing.T) {
+	signer := setupIDTokenIssuer(t)
+
+	for _, tc := range []struct{ alg, kid string }{{"RS256", "rsa-1"}, {"ES256", "ec-1"}} {
+		token := signer.sign(t, tc.alg, tc.kid, validIDTokenClaims())
+		if err := validateIDToken(context.Background(), "google", token, testIDTokenClientID, testIDTokenNonce, time.Now()); err != nil {
+			t.Fatalf("%s: expected token to validate, got %v", tc.alg, err)
+		}
+	}
+}
+
+func TestValidateIDTokenRejectsInvalidTokens(t *testing.T) {
+	signer := setupIDTokenIssuer(t)
+
+	with := func(key string, value any) map[string]any {
+		claims := validIDTokenClaims()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	valid := signer.sign(t, "RS256", "rsa-1", validIDTokenClaims())
+	tampered := valid[:strings.LastIndex(valid, ".")] + "." + base64.RawURLEncoding.EncodeToString([]byte("forged"))
+
+	cases := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{name: "missing", token: "", want: "missing"},
+		{name: "alg none", token: signer.sign(t, "none", "rsa-1", validIDTokenClaims()), want: "unsupported id_token algorithm"},
+		{name: "alg hs256", token: signer.sign(t, "HS256", "rsa-1", validIDTokenClaims()), want: "unsupported id_token algorithm"},
+		{name: "tampered signature", token: tampered, want: "signature invalid"},
+		{name: "unknown kid", token: signer.sign(t, "RS256", "other", validIDTokenClaims()), want: "no matching signing key"},
+		{name: "wrong nonce", token: signer.sign(t, "RS256", "rsa-1", with("nonce", "other")), want: "nonce mismatch"},
+		{name: "missing nonce", token: signer.sign(t, "RS256", "rsa-1", with("nonce", nil)), want: "nonce mismatch"},
+		{name: "wrong audience", token: signer.sign(t, "RS256", "rsa-1", with("aud", "someone-else")), want: "audience mismatch"},
+		{name: "multi audience without azp", token: signer.sign(t, "RS256", "rsa-1", with("aud", []string{testIDTokenClientID, "other"})), want: "authorized party mismatch"},
+		{name: "wrong issuer", token: signer.sign(t, "RS256", "rsa-1", with("iss", "https://evil.example.com")), want: "issuer mismatch"},
+		{name: "expired", token: signer.sign(t, "RS256", "rsa-1", with("exp", time.Now().Add(-time.Hour).Unix())), want: "expired"},
+		{name: "issued in future", token: signer.sign(t, "RS256", "rsa-1", with("iat", time.Now().Add(time.Hour).Unix())), want: "future"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateIDToken(context.Background(), "google", tc.token, testIDTokenClientID, testIDTokenNonce, time.Now())
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestValidateIDTokenAppliesSkewTolerance(t *testing.T) {
+	signer := setupIDTokenIssuer(t)
+	claims := validIDTokenClaims()
+	claims["exp"] = time.Now().Add(-90 * time.Second).Unix()
+	token := signer.sign(t, "RS256", "rsa-1", claims)
+
+	if err := validateIDToken(context.Background(), "google", token, testIDTokenClientID, testIDTokenNonce, time.Now()); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("expected the default tolerance to reject the token, got %v", err)
+	}
+	t.Setenv("GATEWAY_CLOCK_SKEW_TOLERANCE", "2m")
+	if err := validateIDToken(context.Background(), "google", token, testIDTokenClientID, testIDTokenNonce, time.Now()); err != nil {
+		t.Fatalf("expected a 2m tolerance to accept the token, got %v", err)
+	}
+}
+
+func TestAuthorizeHandlerIncludesNonceInRedirectAndState(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?redirect_uri=https://app.example.com/complete", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d", rec.Code)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid location: %v", err)
+	}
+	nonce := location.Query().Get("nonce")
+	if nonce == "" {
+		t.Fatalf("expected nonce in authorize url, got %s", location)
+	}
+
+	state := location.Query().Get("state")
+	var stateCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == stateCookieName(state) {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil {
+		t.Fatal("expected state cookie")
+	}
+	var data stateData
+	if err := getCookieHandler().Decode(stateCookie.Name, stateCookie.Value, &data); err != nil {
+		t.Fatalf("failed to decode state: %v", err)
+	}
+	if data.Nonce != nonce {
+		t.Fatalf("expected state nonce %q to match authorize nonce %q", data.Nonce, nonce)
+	}
+}
+
+func runIDTokenCallback(t *testing.T, idToken string) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	t.Setenv("GOOGLE_OAUTH_CLIENT_ID", testIDTokenClientID)
+	t.Setenv("GATEWAY_VALIDATE_ID_TOKEN", "true")
+	setupTestCookies(t)
+
+	var capturedBody string
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			body, _ := io.ReadAll(req.Body)
+			capturedBody = string(body)
+			payload, _ := json.Marshal(map[string]any{"ok": true, "id_token": idToken})
+			header := make(http.Header)
+			header.Add("Set-Cookie", "oss_session=session-1; Path=/; HttpOnly; Secure")
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(string(payload))),
+				Header:     header,
+			}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+
+	data := stateData{
+		Provider:     "google",
+		RedirectURI:  "https://app.example.com/complete",
+		CodeVerifier: "verifier",
+		ExpiresAt:    time.Now().Add(time.Minute),
+		State:        "state-token",
+		Nonce:        testIDTokenNonce,
+	}
+	encoded, err := getCookieHandler().Encode(stateCookieName(data.State), data)
+	if err != nil {
+		t.Fatalf("failed to encode state data: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/google/callback?code=abc&state=state-token", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: stateCookieName(data.State), Value: encoded, Path: "/auth/"})
+	rec := httptest.NewRecorder()
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
+	return rec, capturedBody
+}
+
+func TestCallbackHandlerValidatesIDToken(t *testing.T) {
+	signer := setupIDTokenIssuer(t)
+
+	rec, upstreamBody := runIDTokenCallback(t, signer.sign(t, "RS256", "rsa-1", validIDTokenClaims()))
+	if !strings.Contains(upstreamBody, `"nonce":"`+testIDTokenNonce+`"`) {
+		t.Fatalf("expected nonce to be forwarded upstream, got %s", upstreamBody)
+	}
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if got := location.Query().Get("status"); got != "success" {
+		t.Fatalf("expected success redirect, got %s", location)
+	}
+	if findCookie(rec.Result().Cookies(), "oss_session") == nil {
+		t.Fatal("expected session cookie to be forwarded after validation")
+	}
+}
+
+func TestCallbackHandlerSkipsIDTokenForProvidersWithoutJWKS(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	t.Setenv("GATEWAY_VALIDATE_ID_TOKEN", "true")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"ok":true}`)), Header: make(http.Header)}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+
+	data := stateData{
+		Provider:     "openrouter",
+		RedirectURI:  "https://app.example.com/complete",
+		CodeVerifier: "verifier",
+		ExpiresAt:    time.Now().Add(time.Minute),
+		State:        "state-token",
+	}
+	encoded, err := getCookieHandler().Encode(stateCookieName(data.State), data)
+	if err != nil {
+		t.Fatalf("failed to encode state data: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?code=abc&state=state-token", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: stateCookieName(data.State), Value: encoded, Path: "/auth/"})
+	rec := httptest.NewRecorder()
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
+
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if got := location.Query().Get("status"); got != "success" {
+		t.Fatalf("expected openrouter sign-ins to succeed without an ID token, got %s", location)
+	}
+}
+
+func TestCallbackHandlerRejectsInvalidIDToken(t *testing.T) {
+	signer := setupIDTokenIssuer(t)
+	claims := validIDTokenClaims()
+	claims["nonce"] = "replayed"
+
+	rec, _ := runIDTokenCallback(t, signer.sign(t, "RS256", "rsa-1", claims))
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if got := location.Query().Get("status"); got != "error" {
+		t.Fatalf("expected error redirect, got %s", location)
+	}
+	if findCookie(rec.Result().Cookies(), "oss_session") != nil {
+		t.Fatal("expected session cookie to be withheld when id_token is invalid")
+	}
+}
@@ -0,0 +1,306 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSCacheTTL = 15 * time.Minute
+	minJWKSCacheTTL     = time.Minute
+	// minJWKSRefreshInterval bounds how often an unknown kid may force a
+	// refresh so clients cannot use the endpoint to hammer the issuer.
+	minJWKSRefreshInterval = 30 * time.Second
+	maxJWKSBodyBytes       = 1 << 20
+	maxJWKSKeyIDLength     = 256
+	jwksContentType        = "application/jwk-set+json"
+)
+
+var errJWKSUnsupported = errors.New("provider does not publish a jwks")
+
+type jwksDocument struct {
+	body      []byte
+	etag      string
+	kids      map[string]struct{}
+	fetchedAt time.Time
+	expires   time.Time
+}
+
+func (d *jwksDocument) hasKey(kid string) bool {
+	_, ok := d.kids[kid]
+	return ok
+}
+
+// jwksCache holds the key sets by provider. mu guards the maps only; a
+// refresh holds its provider's lock in refreshing while it fetches, so a slow
+// issuer delays only its own provider and concurrent refreshes collapse into
+// one fetch.
+var jwksCache struct {
+	mu         sync.Mutex
+	entries    map[string]*jwksDocument
+	refreshing map[string]*sync.Mutex
+}
+
+// ResetJWKSCache drops the cached provider key sets. Entries are keyed by
+// provider name, so tests that point a provider at a new issuer call it.
+func ResetJWKSCache() {
+	resetJWKSCache()
+}
+
+func resetJWKSCache() {
+	jwksCache.mu.Lock()
+	jwksCache.entries = nil
+	jwksCache.refreshing = nil
+	jwksCache.mu.Unlock()
+}
+
+// cachedJWKS returns a copy of the entry of provider, if there is one, and
+// whether it can be served for kid without a refresh.
+func cachedJWKS(provider, kid string, now time.Time) (doc jwksDocument, found, usable bool) {
+	jwksCache.mu.Lock()
+	defer jwksCache.mu.Unlock()
+	entry := jwksCache.entries[provider]
+	if entry == nil {
+		return jwksDocument{}, false, false
+	}
+	fresh := now.Before(entry.expires)
+	unknownKid := kid != "" && !entry.hasKey(kid)
+	return *entry, true, fresh && (!unknownKid || now.Sub(entry.fetchedAt) < minJWKSRefreshInterval)
+}
+
+func jwksRefreshLock(provider string) *sync.Mutex {
+	jwksCache.mu.Lock()
+	defer jwksCache.mu.Unlock()
+	if jwksCache.refreshing == nil {
+		jwksCache.refreshing = make(map[string]*sync.Mutex)
+	}
+	lock := jwksCache.refreshing[provider]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		jwksCache.refreshing[provider] = lock
+	}
+	return lock
+}
+
+func resolveJWKSURL(provider string) (string, error) {
+	p, ok := lookupAuthProvider(provider)
+	if !ok {
+		return "", fmt.Errorf("unknown provider: %s", provider)
+	}
+	return p.JWKSURL()
+}
+
+// loadJWKS returns the cached key set for provider, refreshing it once it has
+// expired or when kid is not present and the last refresh is old enough. A
+// stale document is served when the issuer cannot be reached.
+func loadJWKS(ctx context.Context, provider, jwksURL, kid string) (jwksDocument, bool, error) {
+	if entry, _, usable := cachedJWKS(provider, kid, time.Now()); usable {
+		return entry, false, nil
+	}
+
+	refresh := jwksRefreshLock(provider)
+	refresh.Lock()
+	defer refresh.Unlock()
+	// A refresh that finished while this one waited already answers it.
+	now := time.Now()
+	entry, found, usable := cachedJWKS(provider, kid, now)
+	if usable {
+		return entry, false, nil
+	}
+
+	fetched, err := fetchJWKS(ctx, jwksURL, now)
+	if err != nil {
+		if found {
+			authLog.WarnContext(ctx, "gateway.auth.jwks_refresh_failed",
+				slog.String("provider", provider),
+				slog.String("error", err.Error()),
+			)
+			return entry, false, nil
+		}
+		return jwksDocument{}, false, err
+	}
+	jwksCache.mu.Lock()
+	if jwksCache.entries == nil {
+		jwksCache.entries = make(map[string]*jwksDocument)
+	}
+	jwksCache.entries[provider] = fetched
+	jwksCache.mu.Unlock()
+	return *fetched, true, nil
+}
+
+func fetchJWKS(ctx context.Context, jwksURL string, now time.Time) (*jwksDocument, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := identityHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks endpoint returned %d", resp.StatusCode)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > maxJWKSBodyBytes {
+		return nil, fmt.Errorf("jwks exceeds %d bytes", maxJWKSBodyBytes)
+	}
+
+	var payload struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %w", err)
+	}
+	if len(payload.Keys) == 0 {
+		return nil, errors.New("jwks contains no keys")
+	}
+	kids := make(map[string]struct{}, len(payload.Keys))
+	for _, key := range payload.Keys {
+		var fields struct {
+			Kid string  `json:"kid"`
+			D   *string `json:"d"`
+		}
+		if err := json.Unmarshal(key, &fields); err != nil {
+			return nil, fmt.Errorf("invalid jwk: %w", err)
+		}
+		// Never relay private key material, even if the issuer leaks it.
+		if fields.D != nil {
+			return nil, errors.New("jwks contains private key material")
+		}
+		if fields.Kid != "" {
+			kids[fields.Kid] = struct{}{}
+		}
+	}
+
+	// Re-encode so only the key set itself is served to clients.
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+
+	return &jwksDocument{
+		body:      body,
+		etag:      `"` + hex.EncodeToString(sum[:16]) + `"`,
+		kids:      kids,
+		fetchedAt: now,
+		expires:   now.Add(jwksCacheTTL(resp.Header.Get("Cache-Control"))),
+	}, nil
+}
+
+// jwksCacheTTL honours a shorter upstream max-age but never caches longer than
+// GATEWAY_JWKS_CACHE_TTL so key rotation is picked up promptly.
+func jwksCacheTTL(cacheControl string) time.Duration {
+	ttl := ResolveDuration([]string{"GATEWAY_JWKS_CACHE_TTL"}, defaultJWKSCacheTTL)
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || seconds < 0 {
+			continue
+		}
+		if upstream := time.Duration(seconds) * time.Second; upstream < ttl {
+			ttl = upstream
+		}
+	}
+	if ttl < minJWKSCacheTTL {
+		ttl = minJWKSCacheTTL
+	}
+	return ttl
+}
+
+func jwksHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet) {
+	provider := r.PathValue("provider")
+
+	kid := strings.TrimSpace(r.URL.Query().Get("kid"))
+	if len(kid) > maxJWKSKeyIDLength || hasUnsafeHeaderRunes(kid) {
+		emitAuthEvent(r.Context(), r, trustedProxies, auditEventJWKS, auditOutcomeDenied, map[string]any{
+			"provider": provider,
+			"reason":   "invalid_kid",
+		})
+		writeValidationError(w, r, []validationError{{Field: "kid", Message: "kid is invalid"}})
+		return
+	}
+
+	jwksURL, err := resolveJWKSURL(provider)
+	if err != nil {
+		emitAuthEvent(r.Context(), r, trustedProxies, auditEventJWKS, auditOutcomeFailure, map[string]any{
+			"provider": provider,
+			"error":    err.Error(),
+		})
+		writeErrorResponse(w, r, http.StatusNotFound, "not_found", err.Error(), nil)
+		return
+	}
+
+	doc, refreshed, err := loadJWKS(r.Context(), provider, jwksURL, kid)
+	if err != nil {
+		emitAuthEvent(r.Context(), r, trustedProxies, auditEventJWKS, auditOutcomeFailure, map[string]any{
+			"provider": provider,
+			"reason":   "upstream_error",
+			"error":    err.Error(),
+		})
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to fetch jwks", nil)
+		return
+	}
+
+	details := map[string]any{
+		"provider":  provider,
+		"refreshed": refreshed,
+	}
+	if kid != "" {
+		details["kid_known"] = doc.hasKey(kid)
+	}
+	emitAuthEvent(r.Context(), r, trustedProxies, auditEventJWKS, auditOutcomeSuccess, details)
+
+	headers := w.Header()
+	headers.Add("Vary", "Origin")
+	if origin := strings.TrimSpace(r.Header.Get("Origin")); origin != "" {
+		if parsed, err := url.Parse(origin); err == nil && originAllowed(parsed) {
+			headers.Set("Access-Control-Allow-Origin", origin)
+		}
+	}
+	maxAge := int(time.Until(doc.expires) / time.Second)
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	headers.Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+	headers.Set("ETag", doc.etag)
+
+	if match := r.Header.Get("If-None-Match"); match != "" && match == doc.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	headers.Set("Content-Type", jwksContentType)
+	headers.Set("Content-Length", strconv.Itoa(len(doc.body)))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(doc.body); err != nil {
+		authLog.WarnContext(r.Context(), "gateway.auth.jwks_write_failed", slog.String("error", err.Error()))
+	}
+}
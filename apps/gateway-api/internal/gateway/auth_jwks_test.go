@@ -0,0 +1,247 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testJWKS = `{"keys":[{"kty":"RSA","kid":"key-1","n":"abc","e":"AQAB"}],"extra":"dropped"}`
+
+func setupJWKSUpstream(t *testing.T, body *atomic.Value) (*httptest.Server, *int32) {
+	t.Helper()
+	resetJWKSCache()
+	t.Cleanup(resetJWKSCache)
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "public, max-age=21600")
+		_, _ = io.WriteString(w, body.Load().(string))
+	}))
+	t.Cleanup(server.Close)
+
+	originalClient := identityHTTPClient
+	identityHTTPClient = server.Client()
+	t.Cleanup(func() { identityHTTPClient = originalClient })
+
+	t.Setenv("GOOGLE_JWKS_URL", server.URL)
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	t.Cleanup(func() { allowedRedirectOrigins = loadAllowedRedirectOrigins() })
+	return server, &calls
+}
+
+func serveJWKS(t *testing.T, mux *http.ServeMux, path string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestJWKSHandlerServesCachedKeySet(t *testing.T) {
+	body := &atomic.Value{}
+	body.Store(testJWKS)
+	_, calls := setupJWKSUpstream(t, body)
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, AuthRouteConfig{})
+
+	rec := serveJWKS(t, mux, "/auth/google/jwks", map[string]string{"Origin": "https://app.example.com"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Body.String(); got != `{"keys":[{"kty":"RSA","kid":"key-1","n":"abc","e":"AQAB"}]}` {
+		t.Fatalf("unexpected body %q", got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != jwksContentType {
+		t.Fatalf("unexpected content type %q", got)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=899" && got != "public, max-age=900" {
+		t.Fatalf("expected cache ttl capped at 15m, got %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Fatalf("expected CORS header for allowed origin, got %q", got)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag header")
+	}
+
+	rec = serveJWKS(t, mux, "/auth/google/jwks", map[string]string{"Origin": "https://evil.example.com", "If-None-Match": etag})
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for matching ETag, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected no CORS header for unknown origin, got %q", got)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Fatalf("expected a single upstream fetch, got %d", got)
+	}
+}
+
+func TestJWKSHandlerRefreshesOnUnknownKid(t *testing.T) {
+	body := &atomic.Value{}
+	body.Store(testJWKS)
+	_, calls := setupJWKSUpstream(t, body)
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, AuthRouteConfig{})
+
+	if rec := serveJWKS(t, mux, "/auth/google/jwks", nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body.Store(`{"keys":[{"kty":"RSA","kid":"key-2","n":"def","e":"AQAB"}]}`)
+
+	// Within the refresh interval an unknown kid must not reach the issuer.
+	serveJWKS(t, mux, "/auth/google/jwks?kid=key-2", nil)
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Fatalf("expected refresh to be throttled, got %d fetches", got)
+	}
+
+	jwksCache.mu.Lock()
+	jwksCache.entries["google"].fetchedAt = time.Now().Add(-minJWKSRefreshInterval)
+	jwksCache.mu.Unlock()
+
+	rec := serveJWKS(t, mux, "/auth/google/jwks?kid=key-2", nil)
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Fatalf("expected unknown kid to trigger a refresh, got %d fetches", got)
+	}
+	if got := rec.Body.String(); got != `{"keys":[{"kty":"RSA","kid":"key-2","n":"def","e":"AQAB"}]}` {
+		t.Fatalf("expected rotated key set, got %q", got)
+	}
+}
+
+func TestJWKSHandlerServesStaleKeySetWhenIssuerFails(t *testing.T) {
+	body := &atomic.Value{}
+	body.Store(testJWKS)
+	setupJWKSUpstream(t, body)
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, AuthRouteConfig{})
+
+	if rec := serveJWKS(t, mux, "/auth/google/jwks", nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body.Store(`not json`)
+	jwksCache.mu.Lock()
+	jwksCache.entries["google"].expires = time.Now().Add(-time.Second)
+	jwksCache.mu.Unlock()
+
+	rec := serveJWKS(t, mux, "/auth/google/jwks", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected stale key set to be served, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=0" {
+		t.Fatalf("expected stale response to be uncacheable, got %q", got)
+	}
+}
+
+func TestJWKSHandlerRejectsPrivateKeyMaterial(t *testing.T) {
+	body := &atomic.Value{}
+	body.Store(`{"keys":[{"kty":"RSA","kid":"key-1","n":"abc","e":"AQAB","d":"secret"}]}`)
+	setupJWKSUpstream(t, body)
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, AuthRouteConfig{})
+
+	rec := serveJWKS(t, mux, "/auth/google/jwks", nil)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 for private key material, got %d", rec.Code)
+	}
+}
+
+func TestJWKSHandlerRejectsUnsupportedProviderAndMethod(t *testing.T) {
+	body := &atomic.Value{}
+	body.Store(testJWKS)
+	setupJWKSUpstream(t, body)
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, AuthRouteConfig{})
+
+	if rec := serveJWKS(t, mux, "/auth/openrouter/jwks", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for provider without jwks, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/google/jwks", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
+
+func TestJWKSCacheTTL(t *testing.T) {
+	cases := []struct {
+		name         string
+		cacheControl string
+		want         time.Duration
+	}{
+		{name: "no directive", want: defaultJWKSCacheTTL},
+		{name: "longer upstream", cacheControl: "public, max-age=21600", want: defaultJWKSCacheTTL},
+		{name: "shorter upstream", cacheControl: "max-age=300, must-revalidate", want: 5 * time.Minute},
+		{name: "below floor", cacheControl: "max-age=0", want: minJWKSCacheTTL},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := jwksCacheTTL(tc.cacheControl); got != tc.want {
+				t.Fatalf("jwksCacheTTL(%q) = %s, want %s", tc.cacheControl, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadJWKSRefreshesProvidersIndependently(t *testing.T) {
+	resetJWKSCache()
+	t.Cleanup(resetJWKSCache)
+	var slowCalls int32
+	slowStarted := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			atomic.AddInt32(&slowCalls, 1)
+			slowStarted <- struct{}{}
+			<-release
+		}
+		_, _ = io.WriteString(w, testJWKS)
+	}))
+	t.Cleanup(server.Close)
+	originalClient := identityHTTPClient
+	identityHTTPClient = server.Client()
+	t.Cleanup(func() { identityHTTPClient = originalClient })
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := loadJWKS(context.Background(), "slow", server.URL+"/slow", ""); err != nil {
+				t.Errorf("slow provider: %v", err)
+			}
+		}()
+	}
+	<-slowStarted
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := loadJWKS(context.Background(), "fast", server.URL+"/fast", "")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("fast provider: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a slow issuer not to block other providers")
+	}
+	close(release)
+	wg.Wait()
+	if got := atomic.LoadInt32(&slowCalls); got != 1 {
+		t.Fatalf("expected concurrent refreshes to share one fetch, got %d", got)
+	}
+}
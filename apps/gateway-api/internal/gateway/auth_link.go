@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const (
+	authFlowLink   = "link"
+	authFlowStepUp = "stepup"
+)
+
+type authAuditEmitter func(ctx context.Context, r *http.Request, trusted []*net.IPNet, outcome string, details map[string]any)
+
+// authFlow describes an authorization started on behalf of an existing
+// session. The zero value is a regular sign-in.
+type authFlow struct {
+	Kind    string
+	Session *orchestratorSession
+}
+
+func (f authFlow) auditEmitter() authAuditEmitter {
+	switch f.Kind {
+	case authFlowLink:
+		return auditLinkEvent
+	case authFlowStepUp:
+		return auditStepUpEvent
+	default:
+		return auditAuthorizeEvent
+	}
+}
+
+// linkHandler starts a flow that attaches another provider to the caller's
+// existing session. The session must be valid before the user is sent to the
+// provider, and is checked again when the callback completes.
+func linkHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecureStateCookie bool) {
+	provider := r.PathValue("provider")
+	session, ok := requireActiveSession(w, r, trustedProxies, auditLinkEvent, map[string]any{"provider": provider}, "an active session is required to link accounts")
+	if !ok {
+		return
+	}
+	beginAuthorization(w, r, trustedProxies, allowInsecureStateCookie, provider, authFlow{Kind: authFlowLink, Session: &session}, nil)
+}
+
+// requireActiveSession resolves the caller's session with the orchestrator.
+// On failure it writes the response, using message for 401s, and returns false.
+func requireActiveSession(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, emit authAuditEmitter, details map[string]any, message string) (orchestratorSession, bool) {
+	authHeader, cookieHeader, err := linkSessionCredentials(r)
+	if err != nil {
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{
+			"reason": "invalid_session_credentials",
+			"error":  err.Error(),
+		}))
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "session credentials invalid", nil)
+		return orchestratorSession{}, false
+	}
+	if authHeader == "" && cookieHeader == "" {
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{
+			"reason": "session_required",
+		}))
+		writeErrorResponse(w, r, http.StatusUnauthorized, "unauthorized", message, nil)
+		return orchestratorSession{}, false
+	}
+
+	session, status, err := lookupOrchestratorSession(r.Context(), authHeader, cookieHeader, audit.RequestID(r.Context()))
+	if status == http.StatusUnauthorized {
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{
+			"reason": "session_invalid",
+		}))
+		writeErrorResponse(w, r, http.StatusUnauthorized, "unauthorized", message, nil)
+		return orchestratorSession{}, false
+	}
+	if err != nil || status != http.StatusOK {
+		failure := map[string]any{"reason": "session_lookup_failed", "status_code": status}
+		if err != nil {
+			failure["error"] = err.Error()
+		}
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(details, failure))
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to validate session", nil)
+		return orchestratorSession{}, false
+	}
+	return session, true
+}
+
+// linkSessionCredentials returns the Authorization and Cookie headers that
+// identify the caller's session, after the same validation applied to headers
+// forwarded on other proxied routes.
+func linkSessionCredentials(r *http.Request) (string, string, error) {
+	authHeader := strings.TrimSpace(r.Header.Get("Authorization"))
+	if authHeader != "" {
+		if err := validateAuthorizationHeader(authHeader); err != nil {
+			return "", "", err
+		}
+	}
+	cookieHeader := strings.TrimSpace(strings.Join(r.Header.Values("Cookie"), "; "))
+	if cookieHeader != "" {
+		if err := validateForwardedCookie(cookieHeader); err != nil {
+			return "", "", err
+		}
+	}
+	return authHeader, cookieHeader, nil
+}
+
+// verifyFlowSession confirms the callback still carries the session that
+// started a link or step-up flow, so a leaked callback URL cannot be completed
+// against someone else's session. On failure it writes the response and
+// returns false.
+func verifyFlowSession(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, emit authAuditEmitter, baseDetails map[string]any, data stateData) (string, string, bool) {
+	authHeader, cookieHeader, err := linkSessionCredentials(r)
+	if err != nil || (authHeader == "" && cookieHeader == "") {
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+			"reason":            "flow_session_missing",
+			"redirect_uri_hash": redirectHash(data.RedirectURI),
+		}))
+		redirectWithStatus(w, r, data, "error", "session required")
+		return "", "", false
+	}
+
+	session, status, err := lookupOrchestratorSession(r.Context(), authHeader, cookieHeader, audit.RequestID(r.Context()))
+	if err != nil && status != http.StatusUnauthorized {
+		emit(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(baseDetails, map[string]any{
+			"reason":            "flow_session_lookup_failed",
+			"error":             err.Error(),
+			"redirect_uri_hash": redirectHash(data.RedirectURI),
+		}))
+		redirectWithStatus(w, r, data, "error", "failed to validate session")
+		return "", "", false
+	}
+	if status != http.StatusOK || session.ID != data.SessionID {
+		emit(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(baseDetails, map[string]any{
+			"reason":            "flow_session_mismatch",
+			"redirect_uri_hash": redirectHash(data.RedirectURI),
+		}))
+		redirectWithStatus(w, r, data, "error", "session mismatch")
+		return "", "", false
+	}
+	return authHeader, cookieHeader, true
+}
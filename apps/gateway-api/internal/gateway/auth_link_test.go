@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type linkOrchestrator struct {
+	sessionID      string
+	callbackBody   string
+	callbackCookie string
+	callbacks      int
+	// idToken, when set, is returned from the code exchange.
+	idToken string
+}
+
+func setupLinkOrchestrator(t *testing.T, sessionID string) *linkOrchestrator {
+	t.Helper()
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+
+	fake := &linkOrchestrator{sessionID: sessionID}
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			switch req.URL.Path {
+			case "/auth/session":
+				if !strings.Contains(req.Header.Get("Cookie"), "oss_session=") {
+					return &http.Response{StatusCode: http.StatusUnauthorized, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+				}
+				body := `{"session":{"id":"` + fake.sessionID + `","tenantId":"acme"}}`
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+			default:
+				fake.callbacks++
+				raw, _ := io.ReadAll(req.Body)
+				fake.callbackBody = string(raw)
+				fake.callbackCookie = req.Header.Get("Cookie")
+				body := `{"ok":true}`
+				if fake.idToken != "" {
+					body = `{"ok":true,"id_token":"` + fake.idToken + `"}`
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+			}
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+	return fake
+}
+
+func TestLinkHandlerRequiresSession(t *testing.T) {
+	setupLinkOrchestrator(t, "session-1")
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/link?redirect_uri=https://app.example.com/complete", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	linkHandler(rec, withProviderPathValue(req), nil, false)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a session, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/auth/openrouter/link?redirect_uri=https://app.example.com/complete", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: "other", Value: "1"})
+	rec = httptest.NewRecorder()
+	linkHandler(rec, withProviderPathValue(req), nil, false)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an invalid session, got %d", rec.Code)
+	}
+}
+
+func TestLinkHandlerRecordsSessionInState(t *testing.T) {
+	setupLinkOrchestrator(t, "session-1")
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/link?redirect_uri=https://app.example.com/complete", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: "oss_session", Value: "abc"})
+	rec := httptest.NewRecorder()
+	linkHandler(rec, withProviderPathValue(req), nil, false)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect to provider, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	state := location.Query().Get("state")
+	cookie := findCookie(rec.Result().Cookies(), stateCookieName(state))
+	if cookie == nil {
+		t.Fatal("expected state cookie")
+	}
+	var data stateData
+	if err := getCookieHandler().Decode(cookie.Name, cookie.Value, &data); err != nil {
+		t.Fatalf("failed to decode state: %v", err)
+	}
+	if data.Flow != authFlowLink || data.SessionID != "session-1" || data.TenantID != "acme" {
+		t.Fatalf("expected link session and tenant in state, got %+v", data)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/auth/openrouter/link?redirect_uri=https://app.example.com/complete&tenant_id=globex", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: "oss_session", Value: "abc"})
+	rec = httptest.NewRecorder()
+	linkHandler(rec, withProviderPathValue(req), nil, false)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected tenant mismatch to be rejected, got %d", rec.Code)
+	}
+}
+
+func runLinkCallback(t *testing.T, fake *linkOrchestrator, linkSessionID string) *httptest.ResponseRecorder {
+	t.Helper()
+	data := stateData{
+		Provider:     "openrouter",
+		RedirectURI:  "https://app.example.com/complete",
+		CodeVerifier: "verifier",
+		ExpiresAt:    time.Now().Add(time.Minute),
+		State:        "state-token",
+		Flow:         authFlowLink,
+		SessionID:    linkSessionID,
+	}
+	encoded, err := getCookieHandler().Encode(stateCookieName(data.State), data)
+	if err != nil {
+		t.Fatalf("failed to encode state data: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?code=abc&state=state-token", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: stateCookieName(data.State), Value: encoded, Path: "/auth/"})
+	req.AddCookie(&http.Cookie{Name: "oss_session", Value: "abc"})
+	rec := httptest.NewRecorder()
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
+	return rec
+}
+
+func TestCallbackHandlerCompletesLink(t *testing.T) {
+	fake := setupLinkOrchestrator(t, "session-1")
+
+	rec := runLinkCallback(t, fake, "session-1")
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if got := location.Query().Get("status"); got != "success" {
+		t.Fatalf("expected success redirect, got %s", location)
+	}
+	if !strings.Contains(fake.callbackBody, `"link":true`) || !strings.Contains(fake.callbackBody, `"session_id":"session-1"`) {
+		t.Fatalf("expected link payload, got %s", fake.callbackBody)
+	}
+	if !strings.Contains(fake.callbackCookie, "oss_session=abc") {
+		t.Fatalf("expected session cookie to be forwarded, got %q", fake.callbackCookie)
+	}
+}
+
+func TestCallbackHandlerRejectsLinkForDifferentSession(t *testing.T) {
+	fake := setupLinkOrchestrator(t, "session-2")
+
+	rec := runLinkCallback(t, fake, "session-1")
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if got := location.Query().Get("status"); got != "error" {
+		t.Fatalf("expected error redirect, got %s", location)
+	}
+	if fake.callbacks != 0 {
+		t.Fatalf("expected orchestrator callback to be skipped, got %d calls", fake.callbacks)
+	}
+}
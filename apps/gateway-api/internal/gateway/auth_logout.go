@@ -0,0 +1,179 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const (
+	maxLandingPathLength = 2048
+	maxLogoutStateLength = 512
+)
+
+var errInvalidLandingPath = errors.New("landing_path must be an absolute path on the client's origin")
+
+// validateLandingPath accepts the path, query included, a client asks to be
+// returned to after sign-in. It must stay on the origin of the redirect_uri,
+// which was itself checked against the redirect allowlist, so it cannot name
+// a scheme or host, including the //host and /\host forms browsers resolve
+// as one.
+func validateLandingPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	if len(path) > maxLandingPathLength {
+		return fmt.Errorf("landing_path must be at most %d characters", maxLandingPathLength)
+	}
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.ContainsRune(path, '\\') || strings.ContainsFunc(path, unicode.IsControl) {
+		return errInvalidLandingPath
+	}
+	parsed, err := url.Parse(path)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" || parsed.User != nil {
+		return errInvalidLandingPath
+	}
+	return nil
+}
+
+// parsePostLogoutRedirectURI checks a post-logout redirect against the same
+// origin allowlist as sign-in redirects.
+func parsePostLogoutRedirectURI(raw string) (*url.URL, error) {
+	target, err := url.Parse(raw)
+	if raw == "" || err != nil {
+		return nil, errors.New("invalid post_logout_redirect_uri")
+	}
+	if target.Fragment != "" {
+		return nil, errors.New("post_logout_redirect_uri must not have a fragment")
+	}
+	if validateClientRedirectURL(target) != nil {
+		return nil, errors.New("post_logout_redirect_uri must match an allowed origin")
+	}
+	return target, nil
+}
+
+// postLogoutRedirect returns where the registration sends the user after
+// logout: requested when it is one of its post_logout_redirect_uris exactly,
+// or the first of them when nothing was requested. Without any URIs
+// registered, logout does not redirect.
+func (r oidcClientRegistration) postLogoutRedirect(requested string) (string, bool) {
+	if requested == "" {
+		if len(r.PostLogoutRedirectURIs) == 0 {
+			return "", true
+		}
+		return r.PostLogoutRedirectURIs[0], true
+	}
+	target, err := url.Parse(requested)
+	if err != nil || !slices.Contains(r.PostLogoutRedirectURIs, target.String()) {
+		return "", false
+	}
+	return target.String(), true
+}
+
+func auditLogoutEvent(r *http.Request, trusted []*net.IPNet, outcome string, details map[string]any) {
+	emitAuthEvent(r.Context(), r, trusted, auditEventLogout, outcome, details)
+}
+
+// logoutHandler ends the caller's session through the orchestrator's
+// DELETE /auth/session, relays the cookies it clears and returns the user to
+// the client's post_logout_redirect_uri with status and the state the client
+// sent. Callers without a session are already signed out and are redirected
+// the same way. Without a redirect the response is 204.
+func logoutHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet) {
+	query := r.URL.Query()
+	requested := strings.TrimSpace(query.Get("post_logout_redirect_uri"))
+	state := strings.TrimSpace(query.Get("state"))
+	details := map[string]any{}
+	if requested != "" {
+		details["redirect_uri_hash"] = redirectHash(requested)
+		details["redirect_uri_host"] = redirectHost(requested)
+	}
+	deny := func(field, reason, message string) {
+		auditLogoutEvent(r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{"reason": reason}))
+		writeValidationError(w, r, []validationError{{Field: field, Message: message}})
+	}
+	if len(state) > maxLogoutStateLength {
+		deny("state", "invalid_state", fmt.Sprintf("state must be at most %d characters", maxLogoutStateLength))
+		return
+	}
+	tenantID, err := normalizeTenantID(query.Get("tenant_id"))
+	if err != nil {
+		deny("tenant_id", tenantValidationErrorMessage, tenantValidationErrorMessage)
+		return
+	}
+	clientApp, err := normalizeClientApp(query.Get("client_app"))
+	if err != nil {
+		deny("client_app", err.Error(), err.Error())
+		return
+	}
+	r = r.WithContext(withRequestScope(r.Context(), requestScope{TenantID: tenantID, ClientApp: clientApp}))
+
+	registration, registrationFound, registrationsConfigured, regErr := getOidcClientRegistration(tenantID, clientApp)
+	if regErr != nil {
+		auditLogoutEvent(r, trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{"reason": "client_registration_error"}))
+		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to load client configuration", nil)
+		return
+	}
+	target := ""
+	switch {
+	case registrationFound:
+		var ok bool
+		if target, ok = registration.postLogoutRedirect(requested); !ok {
+			deny("post_logout_redirect_uri", "redirect_not_registered", "post_logout_redirect_uri not registered for client")
+			return
+		}
+	case registrationsConfigured:
+		deny("client_app", "client_not_registered", "client_app is not registered")
+		return
+	case requested != "":
+		parsed, err := parsePostLogoutRedirectURI(requested)
+		if err != nil {
+			deny("post_logout_redirect_uri", err.Error(), err.Error())
+			return
+		}
+		target = parsed.String()
+	}
+	landing := stateData{RedirectURI: target, State: state}
+
+	authHeader, cookieHeader, err := linkSessionCredentials(r)
+	if err != nil {
+		deny("session", "invalid_session_credentials", "session credentials invalid")
+		return
+	}
+	if authHeader != "" || cookieHeader != "" {
+		cookies, status, err := revokeOrchestratorSession(r.Context(), authHeader, cookieHeader, audit.RequestID(r.Context()))
+		if err != nil {
+			auditLogoutEvent(r, trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{
+				"reason":      "upstream_error",
+				"status_code": status,
+				"error":       err.Error(),
+			}))
+			if target != "" {
+				redirectWithStatus(w, r, landing, "error", "sign-out failed, please try again")
+				return
+			}
+			writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to end session", nil)
+			return
+		}
+		normalized, _, _ := normalizeUpstreamCookies(cookies)
+		for _, cookie := range normalized {
+			http.SetCookie(w, cookie)
+		}
+	} else {
+		details["reason"] = "no_session"
+	}
+	auditLogoutEvent(r, trustedProxies, auditOutcomeSuccess, details)
+
+	w.Header().Set("Cache-Control", "no-store")
+	if target == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	redirectWithStatus(w, r, landing, "success", "")
+}
@@ -0,0 +1,240 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupLogoutOrchestrator(t *testing.T, status int) *int {
+	t.Helper()
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	calls := 0
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodDelete || req.URL.Path != "/auth/session" {
+				t.Errorf("unexpected orchestrator call %s %s", req.Method, req.URL.Path)
+			}
+			calls++
+			header := make(http.Header)
+			header.Add("Set-Cookie", "oss_session=; Path=/; Max-Age=0; HttpOnly; Secure; SameSite=Lax")
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Header: header}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+	return &calls
+}
+
+func TestValidateLandingPath(t *testing.T) {
+	for _, path := range []string{"", "/", "/dashboard", "/plans/p-1?tab=steps"} {
+		if err := validateLandingPath(path); err != nil {
+			t.Errorf("expected %q to be accepted: %v", path, err)
+		}
+	}
+	for _, path := range []string{"dashboard", "//evil.example.com", "/\\evil.example.com", "https://evil.example.com/", "/a\nb", "/" + strings.Repeat("a", maxLandingPathLength)} {
+		if err := validateLandingPath(path); err == nil {
+			t.Errorf("expected %q to be rejected", path)
+		}
+	}
+}
+
+func TestParseOidcClientRegistrationsValidatesLogoutAndLanding(t *testing.T) {
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+
+	regs, err := parseOidcClientRegistrations(`[{"tenant_id":"acme","app":"gui","client_id":"c","redirect_origins":["https://app.example.com"],"post_logout_redirect_uris":["https://app.example.com/signed-out"],"default_landing_path":"/home"}]`)
+	if err != nil {
+		t.Fatalf("expected registration to parse: %v", err)
+	}
+	reg := regs["acme"]["gui"]
+	if len(reg.PostLogoutRedirectURIs) != 1 || reg.DefaultLandingPath != "/home" {
+		t.Fatalf("unexpected registration %+v", reg)
+	}
+
+	for name, value := range map[string]string{
+		"origin not allowed": `[{"app":"gui","client_id":"c","post_logout_redirect_uris":["https://evil.example.com/out"]}]`,
+		"outside client":     `[{"app":"gui","client_id":"c","redirect_origins":["https://app.example.com"],"post_logout_redirect_uris":["http://localhost:3000/out"]}]`,
+		"fragment":           `[{"app":"gui","client_id":"c","post_logout_redirect_uris":["https://app.example.com/out#x"]}]`,
+		"absolute landing":   `[{"app":"gui","client_id":"c","default_landing_path":"https://evil.example.com/"}]`,
+		"protocol-relative":  `[{"app":"gui","client_id":"c","default_landing_path":"//evil.example.com"}]`,
+	} {
+		if _, err := parseOidcClientRegistrations(value); err == nil {
+			t.Errorf("%s: expected registration to be rejected", name)
+		}
+	}
+}
+
+func TestAuthorizeHandlerAppliesLandingPath(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "default-client")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+	setOidcRegistrations(t, `[{"tenant_id":"","app":"gui","client_id":"gui-client","default_landing_path":"/home"}]`)
+
+	authorize := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?redirect_uri="+url.QueryEscape("https://app.example.com/complete")+query, nil)
+		req.TLS = &tls.ConnectionState{}
+		rec := httptest.NewRecorder()
+		authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
+		return rec
+	}
+	landingFromState := func(rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		for _, cookie := range rec.Result().Cookies() {
+			if !strings.HasPrefix(cookie.Name, stateCookiePrefix) {
+				continue
+			}
+			var data stateData
+			if err := getCookieHandler().Decode(cookie.Name, cookie.Value, &data); err != nil {
+				t.Fatalf("failed to decode state cookie: %v", err)
+			}
+			return data.LandingPath
+		}
+		t.Fatal("expected a state cookie")
+		return ""
+	}
+
+	rec := authorize("&client_app=gui")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	if got := landingFromState(rec); got != "/home" {
+		t.Fatalf("expected the registration's default landing path, got %q", got)
+	}
+
+	rec = authorize("&client_app=gui&landing_path=" + url.QueryEscape("/plans/p-1?tab=steps"))
+	if got := landingFromState(rec); got != "/plans/p-1?tab=steps" {
+		t.Fatalf("expected the requested landing path, got %q", got)
+	}
+
+	rec = authorize("&client_app=gui&landing_path=" + url.QueryEscape("//evil.example.com"))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an off-origin landing path, got %d", rec.Code)
+	}
+	details := extractValidationDetails(t, decodeErrorResponse(t, rec))
+	if len(details) != 1 || details[0].Field != "landing_path" {
+		t.Fatalf("expected a landing_path validation error, got %+v", details)
+	}
+}
+
+func TestCallbackErrorRedirectCarriesLandingPath(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusBadRequest, Body: io.NopCloser(strings.NewReader(`{"code":"invalid_grant"}`)), Header: make(http.Header)}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+
+	data := stateData{
+		Provider:     "openrouter",
+		RedirectURI:  "https://app.example.com/complete",
+		CodeVerifier: "verifier",
+		ExpiresAt:    time.Now().Add(time.Minute),
+		State:        "state-token",
+		LandingPath:  "/plans/p-1",
+	}
+	encoded, err := getCookieHandler().Encode(stateCookieName(data.State), data)
+	if err != nil {
+		t.Fatalf("failed to encode state data: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?code=abc&state=state-token", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: stateCookieName(data.State), Value: encoded, Path: "/auth/"})
+	rec := httptest.NewRecorder()
+
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
+
+	location, err := url.Parse(rec.Result().Header.Get("Location"))
+	if err != nil || rec.Code != http.StatusFound {
+		t.Fatalf("expected an error redirect, got %d %v", rec.Code, err)
+	}
+	if q := location.Query(); q.Get("status") != "error" || q.Get("landing_path") != "/plans/p-1" {
+		t.Fatalf("expected the landing path on the error redirect, got %s", location)
+	}
+}
+
+func TestLogoutHandlerRedirectsToRegisteredURI(t *testing.T) {
+	calls := setupLogoutOrchestrator(t, http.StatusNoContent)
+	setOidcRegistrations(t, `[{"tenant_id":"","app":"gui","client_id":"gui-client","post_logout_redirect_uris":["https://app.example.com/signed-out","https://app.example.com/goodbye"]}]`)
+	logs := captureAuditLogs(t)
+
+	logout := func(query string, withSession bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/logout?client_app=gui"+query, nil)
+		if withSession {
+			req.AddCookie(&http.Cookie{Name: "oss_session", Value: "session-token"})
+		}
+		rec := httptest.NewRecorder()
+		logoutHandler(rec, req, nil)
+		return rec
+	}
+
+	rec := logout("&state=xyz&post_logout_redirect_uri="+url.QueryEscape("https://app.example.com/goodbye"), true)
+	if rec.Code != http.StatusFound || *calls != 1 {
+		t.Fatalf("expected a redirect after revoking the session, got %d (calls=%d)", rec.Code, *calls)
+	}
+	location, _ := url.Parse(rec.Result().Header.Get("Location"))
+	if location.Path != "/goodbye" || location.Query().Get("state") != "xyz" || location.Query().Get("status") != "success" {
+		t.Fatalf("unexpected logout redirect %s", location)
+	}
+	cleared := false
+	for _, cookie := range rec.Result().Cookies() {
+		cleared = cleared || (cookie.Name == "oss_session" && cookie.MaxAge < 0)
+	}
+	if !cleared {
+		t.Fatalf("expected the session cookie to be cleared, got %v", rec.Result().Header.Values("Set-Cookie"))
+	}
+	if !strings.Contains(logs.String(), `"event":"auth.logout"`) {
+		t.Fatalf("expected an auth.logout audit event, got %s", logs.String())
+	}
+
+	rec = logout("", false)
+	if location := rec.Result().Header.Get("Location"); rec.Code != http.StatusFound || !strings.HasPrefix(location, "https://app.example.com/signed-out?") {
+		t.Fatalf("expected the first registered URI by default, got %d %s", rec.Code, location)
+	}
+	if *calls != 1 {
+		t.Fatalf("expected no orchestrator call without a session, got %d", *calls)
+	}
+
+	rec = logout("&post_logout_redirect_uri="+url.QueryEscape("https://app.example.com/elsewhere"), true)
+	if rec.Code != http.StatusBadRequest || *calls != 1 {
+		t.Fatalf("expected an unregistered URI to be rejected before revoking, got %d (calls=%d)", rec.Code, *calls)
+	}
+}
+
+func TestLogoutHandlerWithoutRegistrations(t *testing.T) {
+	calls := setupLogoutOrchestrator(t, http.StatusInternalServerError)
+	setOidcRegistrations(t, "")
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/logout?post_logout_redirect_uri="+url.QueryEscape("https://evil.example.com/"), nil)
+	rec := httptest.NewRecorder()
+	logoutHandler(rec, req, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an off-allowlist redirect to be rejected, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/auth/logout?post_logout_redirect_uri="+url.QueryEscape("https://app.example.com/out"), nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec = httptest.NewRecorder()
+	logoutHandler(rec, req, nil)
+	location, _ := url.Parse(rec.Result().Header.Get("Location"))
+	if rec.Code != http.StatusFound || *calls != 1 || location.Query().Get("status") != "error" {
+		t.Fatalf("expected an error redirect when the orchestrator fails, got %d %s", rec.Code, location)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/auth/logout", nil)
+	rec = httptest.NewRecorder()
+	logoutHandler(rec, req, nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected 204 without a redirect, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Authorize query parameters a client may pass through to the provider when
+// OAUTH_AUTHORIZE_PASSTHROUGH_PARAMS allows them.
+const (
+	passthroughPrompt     = "prompt"
+	passthroughLoginHint  = "login_hint"
+	passthroughUILocales  = "ui_locales"
+	passthroughDomainHint = "domain_hint"
+
+	maxLoginHintLength  = 256
+	maxDomainHintLength = 253
+	maxUILocales        = 10
+)
+
+var (
+	passthroughParams = []string{passthroughPrompt, passthroughLoginHint, passthroughUILocales, passthroughDomainHint}
+	promptValues      = []string{"none", "login", "consent", "select_account", "create"}
+	localeTagPattern  = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
+	domainHintPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+)
+
+// authorizePassthrough holds the validated pass-through parameters of one
+// authorize request, keyed by parameter name.
+type authorizePassthrough map[string]string
+
+// loadPassthroughAllowlist reads OAUTH_AUTHORIZE_PASSTHROUGH_PARAMS, a
+// comma-separated subset of passthroughParams or "none". All of them are
+// allowed by default.
+func loadPassthroughAllowlist() ([]string, error) {
+	raw := strings.TrimSpace(GetEnv("OAUTH_AUTHORIZE_PASSTHROUGH_PARAMS", ""))
+	switch strings.ToLower(raw) {
+	case "":
+		return passthroughParams, nil
+	case "none":
+		return nil, nil
+	}
+	var allowed []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(passthroughParams, name) {
+			return nil, fmt.Errorf("unsupported OAUTH_AUTHORIZE_PASSTHROUGH_PARAMS entry %q (expected %s or none)", name, strings.Join(passthroughParams, ", "))
+		}
+		if !slices.Contains(allowed, name) {
+			allowed = append(allowed, name)
+		}
+	}
+	return allowed, nil
+}
+
+// ValidateAuthorizePassthroughConfig checks OAUTH_AUTHORIZE_PASSTHROUGH_PARAMS
+// at startup.
+func ValidateAuthorizePassthroughConfig() error {
+	_, err := loadPassthroughAllowlist()
+	return err
+}
+
+// parseAuthorizePassthrough validates the allowed pass-through parameters
+// present in query. Parameters outside the allowlist are ignored, like any
+// other unknown query parameter.
+func parseAuthorizePassthrough(query url.Values) (authorizePassthrough, []validationError) {
+	allowed, err := loadPassthroughAllowlist()
+	if err != nil {
+		// Caught at startup; pass nothing through.
+		return nil, nil
+	}
+	var (
+		params authorizePassthrough
+		errs   []validationError
+	)
+	for _, name := range allowed {
+		raw := strings.TrimSpace(query.Get(name))
+		if raw == "" {
+			continue
+		}
+		value, message := normalizePassthroughValue(name, raw)
+		if message != "" {
+			errs = append(errs, validationError{Field: name, Message: message})
+			continue
+		}
+		if params == nil {
+			params = make(authorizePassthrough)
+		}
+		params[name] = value
+	}
+	return params, errs
+}
+
+func normalizePassthroughValue(name, raw string) (string, string) {
+	switch name {
+	case passthroughPrompt:
+		values := strings.Fields(raw)
+		for _, value := range values {
+			if !slices.Contains(promptValues, value) {
+				return "", "prompt must be a space-separated list of " + strings.Join(promptValues, ", ")
+			}
+		}
+		slices.Sort(values)
+		values = slices.Compact(values)
+		if len(values) > 1 && slices.Contains(values, "none") {
+			return "", "prompt none cannot be combined with other values"
+		}
+		return strings.Join(values, " "), ""
+	case passthroughLoginHint:
+		if len(raw) > maxLoginHintLength || !utf8.ValidString(raw) || strings.IndexFunc(raw, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+			return "", fmt.Sprintf("login_hint must be at most %d printable characters", maxLoginHintLength)
+		}
+		return raw, ""
+	case passthroughUILocales:
+		tags := strings.Fields(raw)
+		if len(tags) > maxUILocales {
+			return "", fmt.Sprintf("ui_locales must list at most %d language tags", maxUILocales)
+		}
+		for _, tag := range tags {
+			if !localeTagPattern.MatchString(tag) {
+				return "", "ui_locales must be a space-separated list of language tags"
+			}
+		}
+		return strings.Join(tags, " "), ""
+	case passthroughDomainHint:
+		if len(raw) > maxDomainHintLength || !domainHintPattern.MatchString(raw) {
+			return "", "domain_hint must be a domain name"
+		}
+		return raw, ""
+	}
+	return "", name + " is not supported"
+}
+
+// apply adds the parameters to the provider authorize query, replacing
+// defaults the provider configuration set.
+func (p authorizePassthrough) apply(q url.Values) {
+	for name, value := range p {
+		q.Set(name, value)
+	}
+}
+
+// names lists the parameters passed through, for audit events.
+func (p authorizePassthrough) names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// key serializes the parameters for the authorize deduplication key.
+func (p authorizePassthrough) key() string {
+	var b strings.Builder
+	for _, name := range p.names() {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(p[name])
+		b.WriteByte('\x00')
+	}
+	return b.String()
+}
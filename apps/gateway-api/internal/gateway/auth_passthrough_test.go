@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseAuthorizePassthrough(t *testing.T) {
+	params, errs := parseAuthorizePassthrough(url.Values{
+		"prompt":      {"select_account  consent select_account"},
+		"login_hint":  {"ada@example.com"},
+		"ui_locales":  {"fr-CA  en"},
+		"domain_hint": {"contoso.com"},
+		"display":     {"popup"},
+	})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	want := authorizePassthrough{"prompt": "consent select_account", "login_hint": "ada@example.com", "ui_locales": "fr-CA en", "domain_hint": "contoso.com"}
+	if len(params) != len(want) {
+		t.Fatalf("expected %v, got %v", want, params)
+	}
+	for name, value := range want {
+		if params[name] != value {
+			t.Fatalf("expected %s=%q, got %q", name, value, params[name])
+		}
+	}
+
+	invalid := map[string]string{
+		"prompt":      "none login",
+		"login_hint":  "ada\x00@example.com",
+		"ui_locales":  "en_US",
+		"domain_hint": "contoso.com/evil",
+	}
+	for name, value := range invalid {
+		if _, errs := parseAuthorizePassthrough(url.Values{name: {value}}); len(errs) != 1 || errs[0].Field != name {
+			t.Fatalf("expected %s=%q to be rejected, got %v", name, value, errs)
+		}
+	}
+	if _, errs := parseAuthorizePassthrough(url.Values{"login_hint": {strings.Repeat("a", maxLoginHintLength+1)}}); len(errs) != 1 {
+		t.Fatal("expected an oversized login_hint to be rejected")
+	}
+}
+
+func TestAuthorizePassthroughAllowlist(t *testing.T) {
+	t.Setenv("OAUTH_AUTHORIZE_PASSTHROUGH_PARAMS", "login_hint")
+	params, errs := parseAuthorizePassthrough(url.Values{"login_hint": {"ada"}, "prompt": {"bogus"}})
+	if len(errs) != 0 || len(params) != 1 || params["login_hint"] != "ada" {
+		t.Fatalf("expected only login_hint to pass through, got %v %v", params, errs)
+	}
+	t.Setenv("OAUTH_AUTHORIZE_PASSTHROUGH_PARAMS", "none")
+	if params, _ := parseAuthorizePassthrough(url.Values{"login_hint": {"ada"}}); len(params) != 0 {
+		t.Fatalf("expected nothing to pass through, got %v", params)
+	}
+	t.Setenv("OAUTH_AUTHORIZE_PASSTHROUGH_PARAMS", "login_hint,display")
+	if err := ValidateAuthorizePassthroughConfig(); err == nil {
+		t.Fatal("expected unsupported parameters to be rejected")
+	}
+}
+
+func TestAuthorizeHandlerPassesParametersThrough(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+
+	query := url.Values{
+		"redirect_uri": {"https://app.example.com/complete"},
+		"prompt":       {"select_account"},
+		"login_hint":   {"ada@example.com"},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?"+query.Encode(), nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d", rec.Code)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	if location.Query().Get("prompt") != "select_account" || location.Query().Get("login_hint") != "ada@example.com" {
+		t.Fatalf("expected the parameters on the authorize URL, got %s", location)
+	}
+
+	query.Set("prompt", "none consent")
+	req = httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?"+query.Encode(), nil)
+	req.TLS = &tls.ConnectionState{}
+	rec = httptest.NewRecorder()
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid prompt to be rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	registerAuthProvider(auth0Provider{})
+}
+
+// auth0Provider signs users in with an Auth0 tenant named by AUTH0_DOMAIN,
+// either the tenant domain or a custom domain. Endpoints come from the
+// tenant's discovery document.
+type auth0Provider struct{ baseAuthProvider }
+
+func (auth0Provider) Name() string { return "auth0" }
+
+func (auth0Provider) Configured() bool { return envConfigured("AUTH0_DOMAIN", "AUTH0_CLIENT_ID") }
+
+// auth0Issuer returns the tenant issuer. Auth0 issuers end with a slash and
+// tokens carry it verbatim.
+func auth0Issuer() (string, error) {
+	domain, err := loadPresetDomain("AUTH0_DOMAIN", "example.us.auth0.com")
+	if err != nil {
+		return "", err
+	}
+	return "https://" + domain + "/", nil
+}
+
+func (auth0Provider) Config() (oauthProvider, error) {
+	issuer, err := auth0Issuer()
+	if err != nil {
+		return oauthProvider{}, err
+	}
+	clientID, err := ResolveEnvValue("AUTH0_CLIENT_ID")
+	if err != nil {
+		return oauthProvider{}, fmt.Errorf("failed to load AUTH0_CLIENT_ID: %w", err)
+	}
+	if clientID == "" {
+		return oauthProvider{}, fmt.Errorf("provider auth0 is not configured: AUTH0_CLIENT_ID is not set")
+	}
+	scopes := parseScopeList(GetEnv("AUTH0_SCOPES", "openid profile email"))
+	if strings.TrimSpace(GetEnv("AUTH0_AUDIENCE", "")) == "" {
+		// Without an audience Auth0 issues an opaque token for /userinfo and
+		// drops API scopes, so a scope like read:plans would never arrive.
+		for _, scope := range scopes {
+			if !standardOIDCScopes[scope] {
+				return oauthProvider{}, fmt.Errorf("AUTH0_SCOPES requests API scope %q, which requires AUTH0_AUDIENCE", scope)
+			}
+		}
+	}
+	metadata, err := discoverPresetIssuer("auth0", issuer)
+	if err != nil {
+		return oauthProvider{}, err
+	}
+	return oauthProvider{
+		Name:         "auth0",
+		AuthorizeURL: metadata.authorizationEndpoint,
+		RedirectURI:  oauthRedirectBase() + "/auth/auth0/callback",
+		ClientID:     clientID,
+		Scopes:       scopes,
+	}, nil
+}
+
+// AuthorizeParams adds the API audience and, when set, the organization and
+// connection that pin the login to one Auth0 organization or identity
+// provider.
+func (auth0Provider) AuthorizeParams(_ oauthProvider, q url.Values) {
+	for param, key := range map[string]string{
+		"audience":     "AUTH0_AUDIENCE",
+		"organization": "AUTH0_ORGANIZATION",
+		"connection":   "AUTH0_CONNECTION",
+	} {
+		if value := strings.TrimSpace(GetEnv(key, "")); value != "" {
+			q.Set(param, value)
+		}
+	}
+}
+
+func (auth0Provider) ExchangePayload(_ oauthProvider, payload map[string]any) {
+	issuer, err := auth0Issuer()
+	if err != nil {
+		return
+	}
+	metadata, err := discoverPresetIssuer("auth0", issuer)
+	if err != nil || metadata.tokenEndpoint == "" {
+		return
+	}
+	payload["token_endpoint"] = metadata.tokenEndpoint
+	if audience := strings.TrimSpace(GetEnv("AUTH0_AUDIENCE", "")); audience != "" {
+		payload["audience"] = audience
+	}
+}
+
+func (auth0Provider) JWKSURL() (string, error) {
+	issuer, err := auth0Issuer()
+	if err != nil {
+		return "", err
+	}
+	metadata, err := discoverPresetIssuer("auth0", issuer)
+	if err != nil {
+		return "", err
+	}
+	if metadata.jwksURI == "" {
+		return "", errors.New("auth0 discovery missing jwks_uri")
+	}
+	return metadata.jwksURI, nil
+}
+
+func (auth0Provider) IDTokenIssuers() []string {
+	issuer, err := auth0Issuer()
+	if err != nil {
+		return nil
+	}
+	return []string{strings.TrimRight(issuer, "/")}
+}
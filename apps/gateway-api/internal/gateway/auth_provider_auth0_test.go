@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAuth0ProviderSendsAudienceAndOrganization(t *testing.T) {
+	domain := setupPresetDiscovery(t, "/")
+	t.Setenv("AUTH0_DOMAIN", domain)
+	t.Setenv("AUTH0_CLIENT_ID", "auth0-client")
+	t.Setenv("AUTH0_SCOPES", "openid profile read:plans")
+	t.Setenv("AUTH0_AUDIENCE", "https://api.example.com")
+	t.Setenv("AUTH0_ORGANIZATION", "org_123")
+	t.Setenv("AUTH0_CONNECTION", "")
+
+	cfg, err := (auth0Provider{}).Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	base := "https://" + domain
+	if cfg.AuthorizeURL != base+"/v1/authorize" {
+		t.Fatalf("expected discovered authorize URL, got %q", cfg.AuthorizeURL)
+	}
+	q := url.Values{}
+	(auth0Provider{}).AuthorizeParams(cfg, q)
+	if q.Get("audience") != "https://api.example.com" || q.Get("organization") != "org_123" || q.Has("connection") {
+		t.Fatalf("unexpected authorize parameters %v", q)
+	}
+	payload := map[string]any{}
+	(auth0Provider{}).ExchangePayload(cfg, payload)
+	if payload["token_endpoint"] != base+"/v1/token" || payload["audience"] != "https://api.example.com" {
+		t.Fatalf("unexpected exchange payload %v", payload)
+	}
+	// Auth0 tokens carry the issuer with its trailing slash; the claim is
+	// compared trimmed.
+	if got := expectedIDTokenIssuers("auth0", idTokenClaims{Issuer: base + "/"}); len(got) != 1 || got[0] != base {
+		t.Fatalf("expected trimmed tenant issuer, got %v", got)
+	}
+}
+
+func TestAuth0ProviderConfigurationErrors(t *testing.T) {
+	domain := setupPresetDiscovery(t, "/")
+	t.Setenv("AUTH0_CLIENT_ID", "auth0-client")
+
+	t.Setenv("AUTH0_DOMAIN", domain)
+	t.Setenv("AUTH0_AUDIENCE", "")
+	t.Setenv("AUTH0_SCOPES", "openid read:plans")
+	if _, err := (auth0Provider{}).Config(); err == nil || !strings.Contains(err.Error(), "requires AUTH0_AUDIENCE") {
+		t.Fatalf("expected audience error, got %v", err)
+	}
+
+	t.Setenv("AUTH0_SCOPES", "")
+	t.Setenv("AUTH0_CLIENT_ID", "")
+	if _, err := (auth0Provider{}).Config(); err == nil || !strings.Contains(err.Error(), "AUTH0_CLIENT_ID is not set") {
+		t.Fatalf("expected client id error, got %v", err)
+	}
+
+	t.Setenv("AUTH0_DOMAIN", "")
+	if _, err := (auth0Provider{}).Config(); err == nil || !strings.Contains(err.Error(), "AUTH0_DOMAIN is not set") {
+		t.Fatalf("expected domain error, got %v", err)
+	}
+}
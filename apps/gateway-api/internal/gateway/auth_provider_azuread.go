@@ -0,0 +1,205 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAzureADAuthorityHost = "https://login.microsoftonline.com"
+	defaultAzureADTenant        = "organizations"
+	// azureADConsumersTenantID is the directory that holds personal
+	// Microsoft accounts.
+	azureADConsumersTenantID = "9188040d-6c67-4c5b-b112-36a304b66dad"
+)
+
+var (
+	azureADTenantGUIDPattern   = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	azureADTenantDomainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)+$`)
+)
+
+func init() {
+	registerAuthProvider(azureADProvider{})
+}
+
+// azureADProvider signs users in with Microsoft Entra ID (Azure AD) using the
+// v2.0 endpoints. AZUREAD_TENANT selects the authority: "common",
+// "organizations", "consumers", a directory ID or a verified domain.
+type azureADProvider struct{ baseAuthProvider }
+
+func (azureADProvider) Name() string { return "azuread" }
+
+func (azureADProvider) Configured() bool { return envConfigured("AZUREAD_CLIENT_ID") }
+
+// azureADAuthority is the resolved tenant authority, for example
+// https://login.microsoftonline.com/organizations.
+type azureADAuthority struct {
+	host   string
+	tenant string
+}
+
+func loadAzureADAuthority() (azureADAuthority, error) {
+	host := strings.TrimRight(strings.TrimSpace(GetEnv("AZUREAD_AUTHORITY_HOST", defaultAzureADAuthorityHost)), "/")
+	parsed, err := url.Parse(host)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" || parsed.Path != "" {
+		return azureADAuthority{}, fmt.Errorf("AZUREAD_AUTHORITY_HOST must be an https origin, got %q", host)
+	}
+	tenant := strings.ToLower(strings.TrimSpace(GetEnv("AZUREAD_TENANT", defaultAzureADTenant)))
+	switch {
+	case azureADMultiTenant(tenant):
+	case azureADTenantGUIDPattern.MatchString(tenant), azureADTenantDomainPattern.MatchString(tenant):
+	default:
+		return azureADAuthority{}, fmt.Errorf("AZUREAD_TENANT must be common, organizations, consumers, a directory ID or a domain, got %q", tenant)
+	}
+	return azureADAuthority{host: host, tenant: tenant}, nil
+}
+
+func azureADMultiTenant(tenant string) bool {
+	return tenant == "common" || tenant == "organizations" || tenant == "consumers"
+}
+
+func (a azureADAuthority) url() string {
+	return a.host + "/" + a.tenant
+}
+
+func (azureADProvider) Config() (oauthProvider, error) {
+	authority, err := loadAzureADAuthority()
+	if err != nil {
+		return oauthProvider{}, err
+	}
+	clientID, err := ResolveEnvValue("AZUREAD_CLIENT_ID")
+	if err != nil {
+		return oauthProvider{}, fmt.Errorf("failed to load AZUREAD_CLIENT_ID: %w", err)
+	}
+	if clientID == "" {
+		return oauthProvider{}, fmt.Errorf("provider azuread is not configured")
+	}
+	return oauthProvider{
+		Name:         "azuread",
+		AuthorizeURL: authority.url() + "/oauth2/v2.0/authorize",
+		RedirectURI:  oauthRedirectBase() + "/auth/azuread/callback",
+		ClientID:     clientID,
+		Scopes:       parseScopeList(GetEnv("AZUREAD_SCOPES", "openid profile email offline_access")),
+	}, nil
+}
+
+func (azureADProvider) AuthorizeParams(_ oauthProvider, q url.Values) {
+	if hint := strings.TrimSpace(GetEnv("AZUREAD_DOMAIN_HINT", "")); hint != "" {
+		q.Set("domain_hint", hint)
+	}
+}
+
+// ExchangePayload tells the orchestrator which authority issued the code so it
+// redeems it at the same tenant's token endpoint, and asks it to keep the
+// group claims when AZUREAD_GROUP_CLAIMS is enabled. Entra ID replaces the
+// groups claim with _claim_names/_claim_sources when a user is in too many
+// groups, so those are passed through as well.
+func (azureADProvider) ExchangePayload(_ oauthProvider, payload map[string]any) {
+	authority, err := loadAzureADAuthority()
+	if err != nil {
+		return
+	}
+	payload["authority"] = authority.url() + "/v2.0"
+	payload["token_endpoint"] = authority.url() + "/oauth2/v2.0/token"
+	if getBoolEnv("AZUREAD_GROUP_CLAIMS") {
+		payload["pass_through_claims"] = []string{"groups", "_claim_names", "_claim_sources"}
+	}
+}
+
+func (azureADProvider) JWKSURL() (string, error) {
+	authority, err := loadAzureADAuthority()
+	if err != nil {
+		return "", err
+	}
+	return authority.url() + "/discovery/v2.0/keys", nil
+}
+
+// IDTokenIssuers returns the issuer of a single-tenant authority. Multi-tenant
+// authorities have no fixed issuer and are checked by ValidIDTokenIssuer.
+func (azureADProvider) IDTokenIssuers() []string {
+	authority, err := loadAzureADAuthority()
+	if err != nil || azureADMultiTenant(authority.tenant) {
+		return nil
+	}
+	if azureADTenantGUIDPattern.MatchString(authority.tenant) {
+		return []string{authority.url() + "/v2.0"}
+	}
+	issuer, err := azureADDomainIssuer(authority)
+	if err != nil {
+		return nil
+	}
+	return []string{issuer}
+}
+
+// ValidIDTokenIssuer accepts the per-directory issuer of a multi-tenant
+// authority when it names the directory in the token's tid claim, and the
+// directory is allowed by the authority.
+func (azureADProvider) ValidIDTokenIssuer(claims idTokenClaims) bool {
+	authority, err := loadAzureADAuthority()
+	if err != nil || !azureADMultiTenant(authority.tenant) {
+		return false
+	}
+	tid := strings.ToLower(claims.TenantID)
+	if !azureADTenantGUIDPattern.MatchString(tid) {
+		return false
+	}
+	switch authority.tenant {
+	case "organizations":
+		if tid == azureADConsumersTenantID {
+			return false
+		}
+	case "consumers":
+		if tid != azureADConsumersTenantID {
+			return false
+		}
+	}
+	return strings.TrimRight(claims.Issuer, "/") == authority.host+"/"+tid+"/v2.0"
+}
+
+// azureADDomainIssuers caches the directory issuer discovered for domain
+// tenants; it never changes for a given domain.
+var azureADDomainIssuers sync.Map
+
+func azureADDomainIssuer(authority azureADAuthority) (string, error) {
+	if cached, ok := azureADDomainIssuers.Load(authority.url()); ok {
+		return cached.(string), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authority.url()+"/v2.0/.well-known/openid-configuration", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := identityHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("azuread discovery returned %d", resp.StatusCode)
+	}
+	body, err := readUpstreamBody(resp.Body, maxUpstreamResponseBytes())
+	if err != nil {
+		return "", err
+	}
+	var payload struct {
+		Issuer string `json:"issuer"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", err
+	}
+	if payload.Issuer == "" {
+		return "", errors.New("azuread discovery missing issuer")
+	}
+	issuer := strings.TrimRight(payload.Issuer, "/")
+	azureADDomainIssuers.Store(authority.url(), issuer)
+	return issuer, nil
+}
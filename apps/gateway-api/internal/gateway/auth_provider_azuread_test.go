@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestAzureADProviderAuthorities(t *testing.T) {
+	t.Setenv("AZUREAD_CLIENT_ID", "entra-client")
+	cases := map[string]string{
+		"":                                     "https://login.microsoftonline.com/organizations/oauth2/v2.0/authorize",
+		"common":                               "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
+		"Contoso.onmicrosoft.com":              "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/authorize",
+		"72f988bf-86f1-41af-91ab-2d7cd011db47": "https://login.microsoftonline.com/72f988bf-86f1-41af-91ab-2d7cd011db47/oauth2/v2.0/authorize",
+	}
+	for tenant, want := range cases {
+		t.Setenv("AZUREAD_TENANT", tenant)
+		cfg, err := (azureADProvider{}).Config()
+		if err != nil {
+			t.Fatalf("tenant %q: unexpected error: %v", tenant, err)
+		}
+		if cfg.AuthorizeURL != want {
+			t.Fatalf("tenant %q: expected %s, got %s", tenant, want, cfg.AuthorizeURL)
+		}
+	}
+
+	for _, tenant := range []string{"../evil", "contoso", "tenant/extra"} {
+		t.Setenv("AZUREAD_TENANT", tenant)
+		if _, err := (azureADProvider{}).Config(); err == nil {
+			t.Fatalf("tenant %q: expected configuration error", tenant)
+		}
+	}
+}
+
+func TestAzureADProviderSovereignCloudAndDomainHint(t *testing.T) {
+	t.Setenv("AZUREAD_CLIENT_ID", "entra-client")
+	t.Setenv("AZUREAD_TENANT", "organizations")
+	t.Setenv("AZUREAD_AUTHORITY_HOST", "https://login.microsoftonline.us/")
+	t.Setenv("AZUREAD_DOMAIN_HINT", "contoso.com")
+
+	cfg, err := (azureADProvider{}).Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.AuthorizeURL != "https://login.microsoftonline.us/organizations/oauth2/v2.0/authorize" {
+		t.Fatalf("unexpected authorize URL %s", cfg.AuthorizeURL)
+	}
+	q := url.Values{}
+	(azureADProvider{}).AuthorizeParams(cfg, q)
+	if q.Get("domain_hint") != "contoso.com" {
+		t.Fatalf("expected domain hint, got %v", q)
+	}
+
+	t.Setenv("AZUREAD_AUTHORITY_HOST", "http://login.example.com")
+	if _, err := (azureADProvider{}).Config(); err == nil {
+		t.Fatal("expected plain http authority host to be rejected")
+	}
+}
+
+func TestAzureADProviderExchangePayloadPassesGroupClaims(t *testing.T) {
+	t.Setenv("AZUREAD_TENANT", "common")
+	t.Setenv("AZUREAD_GROUP_CLAIMS", "true")
+
+	payload := map[string]any{}
+	(azureADProvider{}).ExchangePayload(oauthProvider{}, payload)
+	if payload["token_endpoint"] != "https://login.microsoftonline.com/common/oauth2/v2.0/token" {
+		t.Fatalf("unexpected token endpoint %v", payload["token_endpoint"])
+	}
+	claims, ok := payload["pass_through_claims"].([]string)
+	if !ok || len(claims) == 0 || claims[0] != "groups" {
+		t.Fatalf("expected groups pass-through, got %v", payload["pass_through_claims"])
+	}
+}
+
+func TestAzureADProviderIssuerValidation(t *testing.T) {
+	const tid = "72f988bf-86f1-41af-91ab-2d7cd011db47"
+	issuer := "https://login.microsoftonline.com/" + tid + "/v2.0"
+
+	t.Setenv("AZUREAD_TENANT", tid)
+	if got := expectedIDTokenIssuers("azuread", idTokenClaims{}); len(got) != 1 || got[0] != issuer {
+		t.Fatalf("expected single-tenant issuer, got %v", got)
+	}
+
+	t.Setenv("AZUREAD_TENANT", "organizations")
+	if got := expectedIDTokenIssuers("azuread", idTokenClaims{Issuer: issuer, TenantID: tid}); len(got) != 1 || got[0] != issuer {
+		t.Fatalf("expected multi-tenant issuer for tid, got %v", got)
+	}
+	other := idTokenClaims{Issuer: issuer, TenantID: "00000000-0000-0000-0000-000000000001"}
+	if got := expectedIDTokenIssuers("azuread", other); len(got) != 0 {
+		t.Fatalf("expected issuer naming another directory to be rejected, got %v", got)
+	}
+	consumer := idTokenClaims{Issuer: "https://login.microsoftonline.com/" + azureADConsumersTenantID + "/v2.0", TenantID: azureADConsumersTenantID}
+	if got := expectedIDTokenIssuers("azuread", consumer); len(got) != 0 {
+		t.Fatalf("expected personal accounts to be rejected by organizations, got %v", got)
+	}
+}
@@ -0,0 +1,41 @@
+package gateway
+
+import "fmt"
+
+const defaultGoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+func init() {
+	registerAuthProvider(googleProvider{})
+}
+
+// googleProvider signs users in with Google accounts.
+type googleProvider struct{ baseAuthProvider }
+
+func (googleProvider) Name() string { return "google" }
+
+func (googleProvider) Configured() bool { return envConfigured("GOOGLE_OAUTH_CLIENT_ID") }
+
+func (googleProvider) Config() (oauthProvider, error) {
+	clientID, err := ResolveEnvValue("GOOGLE_OAUTH_CLIENT_ID")
+	if err != nil {
+		return oauthProvider{}, fmt.Errorf("failed to load GOOGLE_OAUTH_CLIENT_ID: %w", err)
+	}
+	if clientID == "" {
+		return oauthProvider{}, fmt.Errorf("provider google is not configured")
+	}
+	return oauthProvider{
+		Name:         "google",
+		AuthorizeURL: "https://accounts.google.com/o/oauth2/v2/auth",
+		RedirectURI:  oauthRedirectBase() + "/auth/google/callback",
+		ClientID:     clientID,
+		Scopes:       []string{"openid", "profile", "email", "https://www.googleapis.com/auth/cloud-platform"},
+	}, nil
+}
+
+func (googleProvider) JWKSURL() (string, error) {
+	return GetEnv("GOOGLE_JWKS_URL", defaultGoogleJWKSURL), nil
+}
+
+func (googleProvider) IDTokenIssuers() []string {
+	return []string{"https://accounts.google.com", "accounts.google.com"}
+}
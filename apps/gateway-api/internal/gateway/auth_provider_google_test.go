@@ -0,0 +1,31 @@
+package gateway
+
+import "testing"
+
+func TestGoogleProviderConfig(t *testing.T) {
+	t.Setenv("GOOGLE_OAUTH_CLIENT_ID", "")
+	if _, err := (googleProvider{}).Config(); err == nil {
+		t.Fatal("expected error when GOOGLE_OAUTH_CLIENT_ID is unset")
+	}
+
+	t.Setenv("GOOGLE_OAUTH_CLIENT_ID", "google-client")
+	t.Setenv("OAUTH_REDIRECT_BASE", "https://gateway.example.com/")
+	cfg, err := (googleProvider{}).Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ClientID != "google-client" || cfg.RedirectURI != "https://gateway.example.com/auth/google/callback" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+}
+
+func TestGoogleProviderJWKSURLOverride(t *testing.T) {
+	t.Setenv("GOOGLE_JWKS_URL", "")
+	if got, _ := (googleProvider{}).JWKSURL(); got != defaultGoogleJWKSURL {
+		t.Fatalf("expected default JWKS URL, got %q", got)
+	}
+	t.Setenv("GOOGLE_JWKS_URL", "https://keys.example.com/certs")
+	if got, _ := (googleProvider{}).JWKSURL(); got != "https://keys.example.com/certs" {
+		t.Fatalf("expected JWKS override, got %q", got)
+	}
+}
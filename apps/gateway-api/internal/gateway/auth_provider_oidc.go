@@ -0,0 +1,184 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerAuthProvider(oidcProvider{})
+}
+
+// oidcProvider is the generic OpenID Connect provider configured through
+// OIDC_ISSUER_URL. Endpoints come from the issuer's discovery document.
+type oidcProvider struct{ baseAuthProvider }
+
+func (oidcProvider) Name() string { return "oidc" }
+
+func (oidcProvider) Configured() bool { return envConfigured("OIDC_ISSUER_URL", "OIDC_CLIENT_ID") }
+
+// oidcIssuer returns the configured issuer without a trailing slash.
+func oidcIssuer() string {
+	return strings.TrimRight(strings.TrimSpace(os.Getenv("OIDC_ISSUER_URL")), "/")
+}
+
+func (oidcProvider) Config() (oauthProvider, error) {
+	issuer := strings.TrimSpace(os.Getenv("OIDC_ISSUER_URL"))
+	if issuer == "" {
+		return oauthProvider{}, fmt.Errorf("oidc issuer not configured")
+	}
+	clientID, err := ResolveEnvValue("OIDC_CLIENT_ID")
+	if err != nil {
+		return oauthProvider{}, fmt.Errorf("failed to load OIDC_CLIENT_ID: %w", err)
+	}
+	if clientID == "" {
+		return oauthProvider{}, fmt.Errorf("oidc client id not configured")
+	}
+
+	metadata, err := loadOidcMetadata("oidc", issuer)
+	if err != nil {
+		return oauthProvider{}, err
+	}
+
+	redirectBase := strings.TrimRight(GetEnv("OIDC_REDIRECT_BASE", oauthRedirectBase()), "/")
+	if redirectBase == "" {
+		redirectBase = "http://127.0.0.1:8080"
+	}
+	rawScopes := os.Getenv("OIDC_SCOPES")
+	if strings.TrimSpace(rawScopes) == "" {
+		rawScopes = "openid profile email"
+	}
+	scopes := parseScopeList(rawScopes)
+
+	return oauthProvider{
+		Name:         "oidc",
+		AuthorizeURL: metadata.authorizationEndpoint,
+		RedirectURI:  fmt.Sprintf("%s/auth/oidc/callback", redirectBase),
+		ClientID:     clientID,
+		Scopes:       scopes,
+	}, nil
+}
+
+func (oidcProvider) JWKSURL() (string, error) {
+	issuer := oidcIssuer()
+	if issuer == "" {
+		return "", fmt.Errorf("oidc issuer not configured")
+	}
+	metadata, err := loadOidcMetadata("oidc", issuer)
+	if err != nil {
+		return "", err
+	}
+	if metadata.jwksURI == "" {
+		return "", errors.New("oidc discovery missing jwks_uri")
+	}
+	return metadata.jwksURI, nil
+}
+
+func (oidcProvider) IDTokenIssuers() []string {
+	return []string{oidcIssuer()}
+}
+
+// loadOidcMetadata returns the discovery document of issuer, fetched within
+// provider's discovery timeout and retry policy and cached for 15 minutes.
+func loadOidcMetadata(provider, issuer string) (oidcDiscovery, error) {
+	trimmed := strings.TrimRight(issuer, "/")
+	now := time.Now()
+	cache := &oidcDiscoveryCache
+
+	cache.mu.RLock()
+	if entry, ok := cache.entries[trimmed]; ok && now.Before(entry.expires) {
+		cache.mu.RUnlock()
+		return entry.metadata, nil
+	}
+	cache.mu.RUnlock()
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if entry, ok := cache.entries[trimmed]; ok && now.Before(entry.expires) {
+		return entry.metadata, nil
+	}
+
+	policy := providerCallPolicyFor(provider)
+	discoveryURL := fmt.Sprintf("%s/.well-known/openid-configuration", trimmed)
+	resp, err := policy.do(context.Background(), identityHTTPClient, policy.discoveryTimeout, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	}, retryableDiscovery)
+	if err != nil {
+		return oidcDiscovery{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return oidcDiscovery{}, fmt.Errorf("oidc discovery returned %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	body, err := readUpstreamBody(resp.Body, maxUpstreamResponseBytes())
+	if err != nil {
+		return oidcDiscovery{}, err
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return oidcDiscovery{}, err
+	}
+	if payload.AuthorizationEndpoint == "" {
+		return oidcDiscovery{}, errors.New("oidc discovery missing authorization_endpoint")
+	}
+
+	metadata := oidcDiscovery{
+		issuer:                payload.Issuer,
+		authorizationEndpoint: payload.AuthorizationEndpoint,
+		tokenEndpoint:         payload.TokenEndpoint,
+		jwksURI:               payload.JWKSURI,
+	}
+	if cache.entries == nil {
+		cache.entries = make(map[string]oidcDiscoveryEntry)
+	}
+	cache.entries[trimmed] = oidcDiscoveryEntry{metadata: metadata, expires: now.Add(15 * time.Minute)}
+	return metadata, nil
+}
+
+// standardOIDCScopes are the scopes every OpenID provider understands; the
+// okta and auth0 presets treat anything else as an API scope.
+var standardOIDCScopes = map[string]bool{
+	"openid": true, "profile": true, "email": true, "address": true, "phone": true, "offline_access": true,
+}
+
+// loadPresetDomain reads a bare host name such as dev-123.okta.com from key.
+// A leading https:// and trailing slash are tolerated; paths are not, since
+// they usually mean an issuer URL was pasted where the domain belongs.
+func loadPresetDomain(key, example string) (string, error) {
+	raw := strings.TrimRight(strings.TrimSpace(os.Getenv(key)), "/")
+	if raw == "" {
+		return "", fmt.Errorf("%s is not set; expected a host name such as %s", key, example)
+	}
+	host := strings.TrimPrefix(raw, "https://")
+	if strings.Contains(host, "://") || strings.ContainsAny(host, "/?#@ ") {
+		return "", fmt.Errorf("%s must be a host name such as %s, got %q", key, example, raw)
+	}
+	return strings.ToLower(host), nil
+}
+
+// discoverPresetIssuer fetches the discovery document for issuer and checks
+// that it describes that issuer, so a mistyped domain or authorization server
+// fails with a message naming the provider instead of at token validation.
+func discoverPresetIssuer(provider, issuer string) (oidcDiscovery, error) {
+	metadata, err := loadOidcMetadata(provider, issuer)
+	if err != nil {
+		return oidcDiscovery{}, fmt.Errorf("%s discovery at %s failed: %w", provider, issuer, err)
+	}
+	if strings.TrimRight(metadata.issuer, "/") != strings.TrimRight(issuer, "/") {
+		return oidcDiscovery{}, fmt.Errorf("%s discovery at %s returned issuer %q", provider, issuer, metadata.issuer)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOIDCProviderUsesDiscovery(t *testing.T) {
+	resetOidcCache()
+	t.Cleanup(resetOidcCache)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"authorization_endpoint":"https://issuer.example.com/auth","jwks_uri":"https://issuer.example.com/keys"}`)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("OIDC_ISSUER_URL", server.URL+"/")
+	t.Setenv("OIDC_CLIENT_ID", "oidc-client")
+	t.Setenv("OIDC_SCOPES", "profile")
+
+	cfg, err := (oidcProvider{}).Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.AuthorizeURL != "https://issuer.example.com/auth" {
+		t.Fatalf("expected discovered authorize URL, got %q", cfg.AuthorizeURL)
+	}
+	if len(cfg.Scopes) != 2 || cfg.Scopes[0] != "openid" {
+		t.Fatalf("expected openid to be added to scopes, got %v", cfg.Scopes)
+	}
+	if got, err := (oidcProvider{}).JWKSURL(); err != nil || got != "https://issuer.example.com/keys" {
+		t.Fatalf("expected discovered JWKS URL, got %q, %v", got, err)
+	}
+	if issuers := (oidcProvider{}).IDTokenIssuers(); len(issuers) != 1 || issuers[0] != server.URL {
+		t.Fatalf("expected trimmed issuer, got %v", issuers)
+	}
+}
+
+func TestOIDCProviderRequiresIssuer(t *testing.T) {
+	t.Setenv("OIDC_ISSUER_URL", "")
+	if _, err := (oidcProvider{}).Config(); err == nil {
+		t.Fatal("expected error without OIDC_ISSUER_URL")
+	}
+}
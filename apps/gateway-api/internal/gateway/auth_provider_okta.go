@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var oktaAuthorizationServerPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+func init() {
+	registerAuthProvider(oktaProvider{})
+}
+
+// oktaProvider signs users in with Okta. OKTA_DOMAIN selects the org and
+// OKTA_AUTHORIZATION_SERVER an optional custom authorization server such as
+// "default"; endpoints come from the issuer's discovery document.
+type oktaProvider struct{ baseAuthProvider }
+
+func (oktaProvider) Name() string { return "okta" }
+
+func (oktaProvider) Configured() bool { return envConfigured("OKTA_DOMAIN", "OKTA_CLIENT_ID") }
+
+// oktaIssuer returns the org issuer, or the custom authorization server's
+// issuer when OKTA_AUTHORIZATION_SERVER is set.
+func oktaIssuer() (issuer string, customServer bool, err error) {
+	domain, err := loadPresetDomain("OKTA_DOMAIN", "dev-123456.okta.com")
+	if err != nil {
+		return "", false, err
+	}
+	server := strings.TrimSpace(GetEnv("OKTA_AUTHORIZATION_SERVER", ""))
+	if server == "" {
+		return "https://" + domain, false, nil
+	}
+	if !oktaAuthorizationServerPattern.MatchString(server) {
+		return "", false, fmt.Errorf("OKTA_AUTHORIZATION_SERVER must be an authorization server ID such as default, got %q", server)
+	}
+	return "https://" + domain + "/oauth2/" + server, true, nil
+}
+
+func (oktaProvider) Config() (oauthProvider, error) {
+	issuer, customServer, err := oktaIssuer()
+	if err != nil {
+		return oauthProvider{}, err
+	}
+	clientID, err := ResolveEnvValue("OKTA_CLIENT_ID")
+	if err != nil {
+		return oauthProvider{}, fmt.Errorf("failed to load OKTA_CLIENT_ID: %w", err)
+	}
+	if clientID == "" {
+		return oauthProvider{}, fmt.Errorf("provider okta is not configured: OKTA_CLIENT_ID is not set")
+	}
+	scopes := parseScopeList(GetEnv("OKTA_SCOPES", "openid profile email"))
+	if !customServer {
+		// The org authorization server only issues OpenID and okta.* scopes;
+		// anything else is silently dropped or rejected at authorize time.
+		for _, scope := range scopes {
+			if !standardOIDCScopes[scope] && scope != "groups" && !strings.HasPrefix(scope, "okta.") {
+				return oauthProvider{}, fmt.Errorf("OKTA_SCOPES requests custom scope %q, which requires OKTA_AUTHORIZATION_SERVER", scope)
+			}
+		}
+	}
+	metadata, err := discoverPresetIssuer("okta", issuer)
+	if err != nil {
+		return oauthProvider{}, err
+	}
+	return oauthProvider{
+		Name:         "okta",
+		AuthorizeURL: metadata.authorizationEndpoint,
+		RedirectURI:  oauthRedirectBase() + "/auth/okta/callback",
+		ClientID:     clientID,
+		Scopes:       scopes,
+	}, nil
+}
+
+// AuthorizeParams routes the user straight to an identity provider when
+// OKTA_IDP names one, skipping the Okta sign-in page.
+func (oktaProvider) AuthorizeParams(_ oauthProvider, q url.Values) {
+	if idp := strings.TrimSpace(GetEnv("OKTA_IDP", "")); idp != "" {
+		q.Set("idp", idp)
+	}
+}
+
+func (oktaProvider) ExchangePayload(_ oauthProvider, payload map[string]any) {
+	issuer, _, err := oktaIssuer()
+	if err != nil {
+		return
+	}
+	metadata, err := discoverPresetIssuer("okta", issuer)
+	if err != nil || metadata.tokenEndpoint == "" {
+		return
+	}
+	payload["token_endpoint"] = metadata.tokenEndpoint
+}
+
+func (oktaProvider) JWKSURL() (string, error) {
+	issuer, _, err := oktaIssuer()
+	if err != nil {
+		return "", err
+	}
+	metadata, err := discoverPresetIssuer("okta", issuer)
+	if err != nil {
+		return "", err
+	}
+	if metadata.jwksURI == "" {
+		return "", errors.New("okta discovery missing jwks_uri")
+	}
+	return metadata.jwksURI, nil
+}
+
+func (oktaProvider) IDTokenIssuers() []string {
+	issuer, _, err := oktaIssuer()
+	if err != nil {
+		return nil
+	}
+	return []string{issuer}
+}
@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// setupPresetDiscovery serves a discovery document over TLS for the issuer
+// path and returns the server's host, for use as OKTA_DOMAIN or AUTH0_DOMAIN.
+func setupPresetDiscovery(t *testing.T, issuerPath string) string {
+	t.Helper()
+	resetOidcCache()
+	t.Cleanup(resetOidcCache)
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != strings.TrimRight(issuerPath, "/")+"/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		issuer := server.URL + issuerPath
+		base := strings.TrimRight(issuer, "/")
+		fmt.Fprintf(w, `{"issuer":%q,"authorization_endpoint":%q,"token_endpoint":%q,"jwks_uri":%q}`,
+			issuer, base+"/v1/authorize", base+"/v1/token", base+"/v1/keys")
+	}))
+	t.Cleanup(server.Close)
+	originalClient := identityHTTPClient
+	identityHTTPClient = server.Client()
+	t.Cleanup(func() { identityHTTPClient = originalClient })
+	return strings.TrimPrefix(server.URL, "https://")
+}
+
+func TestOktaProviderUsesCustomAuthorizationServer(t *testing.T) {
+	domain := setupPresetDiscovery(t, "/oauth2/default")
+	t.Setenv("OKTA_DOMAIN", "https://"+domain+"/")
+	t.Setenv("OKTA_AUTHORIZATION_SERVER", "default")
+	t.Setenv("OKTA_CLIENT_ID", "okta-client")
+	t.Setenv("OKTA_SCOPES", "openid profile plans:read")
+	t.Setenv("OKTA_IDP", "0oa1234")
+
+	cfg, err := (oktaProvider{}).Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	issuer := "https://" + domain + "/oauth2/default"
+	if cfg.AuthorizeURL != issuer+"/v1/authorize" {
+		t.Fatalf("expected discovered authorize URL, got %q", cfg.AuthorizeURL)
+	}
+	q := url.Values{}
+	(oktaProvider{}).AuthorizeParams(cfg, q)
+	if q.Get("idp") != "0oa1234" {
+		t.Fatalf("expected idp parameter, got %v", q)
+	}
+	payload := map[string]any{}
+	(oktaProvider{}).ExchangePayload(cfg, payload)
+	if payload["token_endpoint"] != issuer+"/v1/token" {
+		t.Fatalf("expected discovered token endpoint, got %v", payload["token_endpoint"])
+	}
+	if got, err := (oktaProvider{}).JWKSURL(); err != nil || got != issuer+"/v1/keys" {
+		t.Fatalf("expected discovered JWKS URL, got %q, %v", got, err)
+	}
+	if got := expectedIDTokenIssuers("okta", idTokenClaims{}); len(got) != 1 || got[0] != issuer {
+		t.Fatalf("expected authorization server issuer, got %v", got)
+	}
+}
+
+func TestOktaProviderConfigurationErrors(t *testing.T) {
+	domain := setupPresetDiscovery(t, "/oauth2/default")
+	t.Setenv("OKTA_CLIENT_ID", "okta-client")
+
+	cases := []struct {
+		name, domain, server, scopes, want string
+	}{
+		{name: "missing domain", want: "OKTA_DOMAIN is not set"},
+		{name: "issuer pasted as domain", domain: "https://" + domain + "/oauth2/default", want: "must be a host name"},
+		{name: "invalid server", domain: domain, server: "../admin", want: "OKTA_AUTHORIZATION_SERVER must be"},
+		{name: "custom scope on org server", domain: domain, scopes: "openid plans:read", want: "requires OKTA_AUTHORIZATION_SERVER"},
+		{name: "unknown server", domain: domain, server: "missing", want: "okta discovery at https://" + domain + "/oauth2/missing failed"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("OKTA_DOMAIN", tc.domain)
+			t.Setenv("OKTA_AUTHORIZATION_SERVER", tc.server)
+			t.Setenv("OKTA_SCOPES", tc.scopes)
+			_, err := (oktaProvider{}).Config()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
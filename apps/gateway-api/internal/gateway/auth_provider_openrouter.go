@@ -0,0 +1,32 @@
+package gateway
+
+import "fmt"
+
+func init() {
+	registerAuthProvider(openRouterProvider{})
+}
+
+// openRouterProvider connects OpenRouter accounts. OpenRouter does not
+// publish a JWKS or issue ID tokens.
+type openRouterProvider struct{ baseAuthProvider }
+
+func (openRouterProvider) Name() string { return "openrouter" }
+
+func (openRouterProvider) Configured() bool { return envConfigured("OPENROUTER_CLIENT_ID") }
+
+func (openRouterProvider) Config() (oauthProvider, error) {
+	clientID, err := ResolveEnvValue("OPENROUTER_CLIENT_ID")
+	if err != nil {
+		return oauthProvider{}, fmt.Errorf("failed to load OPENROUTER_CLIENT_ID: %w", err)
+	}
+	if clientID == "" {
+		return oauthProvider{}, fmt.Errorf("provider openrouter is not configured")
+	}
+	return oauthProvider{
+		Name:         "openrouter",
+		AuthorizeURL: "https://openrouter.ai/oauth/authorize",
+		RedirectURI:  oauthRedirectBase() + "/auth/openrouter/callback",
+		ClientID:     clientID,
+		Scopes:       []string{"offline", "openid", "profile"},
+	}, nil
+}
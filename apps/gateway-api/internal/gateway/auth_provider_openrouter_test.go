@@ -0,0 +1,25 @@
+package gateway
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOpenRouterProviderConfig(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "")
+	if _, err := (openRouterProvider{}).Config(); err == nil {
+		t.Fatal("expected error when OPENROUTER_CLIENT_ID is unset")
+	}
+
+	t.Setenv("OPENROUTER_CLIENT_ID", "or-client")
+	cfg, err := (openRouterProvider{}).Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.AuthorizeURL != "https://openrouter.ai/oauth/authorize" || cfg.ClientID != "or-client" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if _, err := (openRouterProvider{}).JWKSURL(); !errors.Is(err, errJWKSUnsupported) {
+		t.Fatalf("expected openrouter to have no JWKS, got %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultProviderDiscoveryTimeout = 5 * time.Second
+	defaultProviderRetryBackoff     = 250 * time.Millisecond
+	maxProviderTimeout              = time.Minute
+	maxProviderRetryBackoff         = 10 * time.Second
+	maxProviderRetries              = 3
+)
+
+// providerCallPolicy bounds the calls made for one provider: fetching its
+// OIDC discovery document and exchanging its authorization codes through the
+// orchestrator.
+type providerCallPolicy struct {
+	discoveryTimeout time.Duration
+	callbackTimeout  time.Duration
+	// retries is how many times a failed call is repeated, waiting
+	// retryBackoff before the first retry and twice as long before each
+	// following one.
+	retries      int
+	retryBackoff time.Duration
+}
+
+// providerSettingPrefix is the prefix of a provider's override settings,
+// such as GATEWAY_AUTH_GOOGLE_ for google.
+func providerSettingPrefix(provider string) string {
+	return "GATEWAY_AUTH_" + strings.ToUpper(provider) + "_"
+}
+
+// loadProviderCallPolicy reads the GATEWAY_AUTH_<PROVIDER>_* overrides of
+// provider. Unset settings keep the 5s discovery timeout,
+// ORCHESTRATOR_CALLBACK_TIMEOUT and no retries.
+func loadProviderCallPolicy(provider string) (providerCallPolicy, error) {
+	prefix := providerSettingPrefix(provider)
+	policy := providerCallPolicy{
+		discoveryTimeout: defaultProviderDiscoveryTimeout,
+		callbackTimeout:  orchestratorTimeout,
+		retryBackoff:     defaultProviderRetryBackoff,
+	}
+	for _, setting := range []struct {
+		key    string
+		target *time.Duration
+		max    time.Duration
+	}{
+		{prefix + "DISCOVERY_TIMEOUT", &policy.discoveryTimeout, maxProviderTimeout},
+		{prefix + "CALLBACK_TIMEOUT", &policy.callbackTimeout, maxProviderTimeout},
+		{prefix + "RETRY_BACKOFF", &policy.retryBackoff, maxProviderRetryBackoff},
+	} {
+		raw := strings.TrimSpace(GetEnv(setting.key, ""))
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 || parsed > setting.max {
+			return providerCallPolicy{}, fmt.Errorf("%s must be a duration between 0 and %s", setting.key, setting.max)
+		}
+		*setting.target = parsed
+	}
+	if raw := strings.TrimSpace(GetEnv(prefix+"RETRIES", "")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 || parsed > maxProviderRetries {
+			return providerCallPolicy{}, fmt.Errorf("%sRETRIES must be an integer between 0 and %d", prefix, maxProviderRetries)
+		}
+		policy.retries = parsed
+	}
+	return policy, nil
+}
+
+// ValidateProviderCallConfig checks the GATEWAY_AUTH_<PROVIDER>_* timeout and
+// retry overrides of every registered provider at startup.
+func ValidateProviderCallConfig() error {
+	for _, name := range authProviderNames() {
+		if _, err := loadProviderCallPolicy(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// providerCallPolicyFor returns the policy of provider. Invalid overrides
+// stop the gateway at startup; should one be set afterwards, the defaults
+// apply.
+func providerCallPolicyFor(provider string) providerCallPolicy {
+	policy, err := loadProviderCallPolicy(provider)
+	if err != nil {
+		return providerCallPolicy{discoveryTimeout: defaultProviderDiscoveryTimeout, callbackTimeout: orchestratorTimeout}
+	}
+	return policy
+}
+
+// do sends the request built by newRequest with client, each attempt bounded
+// by timeout, and repeats it while retryable reports the outcome as
+// transient. The returned body must be closed, which releases the attempt's
+// deadline.
+func (p providerCallPolicy) do(ctx context.Context, client *http.Client, timeout time.Duration, newRequest func(context.Context) (*http.Request, error), retryable func(*http.Response, error) bool) (*http.Response, error) {
+	backoff := p.retryBackoff
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		if attempt > 0 {
+			attemptCtx = withUpstreamRetries(attemptCtx, attempt)
+		}
+		req, err := newRequest(attemptCtx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if attempt >= p.retries || ctx.Err() != nil || !retryable(resp, err) {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		cancel()
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// cancelOnClose releases a request's context once its response is read.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// retryableDiscovery retries a discovery request that failed or met a
+// server error; the document fetch is idempotent.
+func retryableDiscovery(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// retryableExchange retries a code exchange only when the connection to the
+// orchestrator could not be opened, so the request was never sent.
+// Authorization codes are single-use: once the request may have reached the
+// orchestrator, as after a timeout, a reset connection or a 502 or 503 from
+// a proxy that forwarded it, the code may already be spent and the exchange
+// is never repeated.
+func retryableExchange(_ *http.Response, err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial" && !opErr.Timeout()
+}
@@ -0,0 +1,162 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestProviderCallPolicyOverrides(t *testing.T) {
+	t.Setenv("GATEWAY_AUTH_GOOGLE_DISCOVERY_TIMEOUT", "8s")
+	t.Setenv("GATEWAY_AUTH_GOOGLE_CALLBACK_TIMEOUT", "30s")
+	t.Setenv("GATEWAY_AUTH_GOOGLE_RETRIES", "2")
+	policy, err := loadProviderCallPolicy("google")
+	if err != nil || policy.discoveryTimeout != 8*time.Second || policy.callbackTimeout != 30*time.Second || policy.retries != 2 {
+		t.Fatalf("unexpected policy %+v: %v", policy, err)
+	}
+	other, err := loadProviderCallPolicy("openrouter")
+	if err != nil || other.discoveryTimeout != defaultProviderDiscoveryTimeout || other.callbackTimeout != orchestratorTimeout || other.retries != 0 {
+		t.Fatalf("expected other providers to keep the defaults, got %+v: %v", other, err)
+	}
+
+	for key, value := range map[string]string{
+		"GATEWAY_AUTH_OKTA_CALLBACK_TIMEOUT": "2m",
+		"GATEWAY_AUTH_OKTA_RETRIES":          "5",
+		"GATEWAY_AUTH_OKTA_RETRY_BACKOFF":    "0s",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if err := ValidateProviderCallConfig(); err == nil {
+				t.Fatalf("expected %s=%s to be rejected", key, value)
+			}
+		})
+	}
+}
+
+func TestCallbackHandlerRetriesUnreachableOrchestrator(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	t.Setenv("GATEWAY_AUTH_OPENROUTER_RETRIES", "2")
+	t.Setenv("GATEWAY_AUTH_OPENROUTER_RETRY_BACKOFF", "1ms")
+	t.Setenv("GATEWAY_AUTH_OPENROUTER_CALLBACK_TIMEOUT", "3s")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+
+	var calls int
+	var deadlines []time.Time
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			deadline, _ := req.Context().Deadline()
+			deadlines = append(deadlines, deadline)
+			if body, _ := io.ReadAll(req.Body); !strings.Contains(string(body), `"code":"abc"`) {
+				t.Errorf("expected every attempt to send the code, got %s", body)
+			}
+			if calls == 1 {
+				return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}")), Header: make(http.Header)}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+
+	data := stateData{
+		Provider:     "openrouter",
+		RedirectURI:  "https://app.example.com/complete",
+		CodeVerifier: "verifier",
+		ExpiresAt:    time.Now().Add(time.Minute),
+		State:        "state-token",
+	}
+	encoded, err := getCookieHandler().Encode(stateCookieName(data.State), data)
+	if err != nil {
+		t.Fatalf("failed to encode state data: %v", err)
+	}
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?code=abc&state=state-token", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: stateCookieName(data.State), Value: encoded, Path: "/auth/"})
+	rec := httptest.NewRecorder()
+
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
+
+	if calls != 2 || rec.Code != http.StatusFound || !strings.Contains(rec.Header().Get("Location"), "status=success") {
+		t.Fatalf("expected the second attempt to succeed, got %d calls, %d %s", calls, rec.Code, rec.Header().Get("Location"))
+	}
+	if deadlines[0].Sub(start) < 2*time.Second || deadlines[0].Sub(start) > 4*time.Second {
+		t.Fatalf("expected the provider's callback timeout, got a deadline %s away", deadlines[0].Sub(start))
+	}
+}
+
+func TestCallbackHandlerDoesNotRetryExchangeThatMayHaveBeenSent(t *testing.T) {
+	t.Setenv("GATEWAY_AUTH_OPENROUTER_RETRIES", "3")
+	t.Setenv("GATEWAY_AUTH_OPENROUTER_RETRY_BACKOFF", "1ms")
+	for name, outcome := range map[string]struct {
+		status int
+		err    error
+	}{
+		"answered":         {status: http.StatusBadRequest},
+		"bad gateway":      {status: http.StatusBadGateway},
+		"unavailable":      {status: http.StatusServiceUnavailable},
+		"connection reset": {err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}},
+		"timeout":          {err: context.DeadlineExceeded},
+		"dial timeout":     {err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{IsTimeout: true}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var calls int
+			client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				if outcome.err != nil {
+					return nil, outcome.err
+				}
+				return &http.Response{StatusCode: outcome.status, Body: io.NopCloser(strings.NewReader(`{"code":"invalid_grant"}`)), Header: make(http.Header)}, nil
+			})}
+			policy := providerCallPolicyFor("openrouter")
+			resp, err := policy.do(context.Background(), client, time.Second, func(ctx context.Context) (*http.Request, error) {
+				return http.NewRequestWithContext(ctx, http.MethodPost, "http://orchestrator.invalid/auth", nil)
+			}, retryableExchange)
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+			if calls != 1 || (err == nil) != (outcome.err == nil) {
+				t.Fatalf("expected one attempt, got %d calls: %v", calls, err)
+			}
+		})
+	}
+}
+
+func TestLoadOidcMetadataUsesProviderPolicy(t *testing.T) {
+	resetOidcCache()
+	t.Cleanup(resetOidcCache)
+	t.Setenv("GATEWAY_AUTH_OKTA_DISCOVERY_TIMEOUT", "20s")
+	t.Setenv("GATEWAY_AUTH_OKTA_RETRIES", "1")
+	t.Setenv("GATEWAY_AUTH_OKTA_RETRY_BACKOFF", "1ms")
+
+	var calls int
+	var deadline time.Time
+	originalClient := identityHTTPClient
+	identityHTTPClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		deadline, _ = req.Context().Deadline()
+		if calls == 1 {
+			return nil, errors.New("connection reset")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"authorization_endpoint":"https://issuer.example.com/auth"}`)), Header: make(http.Header)}, nil
+	})}
+	t.Cleanup(func() { identityHTTPClient = originalClient })
+
+	start := time.Now()
+	if _, err := loadOidcMetadata("okta", "https://issuer.example.com"); err != nil || calls != 2 {
+		t.Fatalf("expected the retry to succeed, got %d calls: %v", calls, err)
+	}
+	if remaining := deadline.Sub(start); remaining < 15*time.Second {
+		t.Fatalf("expected okta's discovery timeout, got a deadline %s away", remaining)
+	}
+}
@@ -1,154 +1,87 @@
 package gateway
 
 import (
-	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"net/http"
 	"net/url"
-	"os"
 	"sort"
 	"strings"
-	"time"
 	"unicode"
 )
 
-func getProviderConfig(provider string) (oauthProvider, error) {
-	switch provider {
-	case "openrouter", "google":
-		redirectBase := strings.TrimRight(GetEnv("OAUTH_REDIRECT_BASE", "http://127.0.0.1:8080"), "/")
-		openrouterClientID, err := ResolveEnvValue("OPENROUTER_CLIENT_ID")
-		if err != nil {
-			return oauthProvider{}, fmt.Errorf("failed to load OPENROUTER_CLIENT_ID: %w", err)
-		}
-		googleClientID, err := ResolveEnvValue("GOOGLE_OAUTH_CLIENT_ID")
-		if err != nil {
-			return oauthProvider{}, fmt.Errorf("failed to load GOOGLE_OAUTH_CLIENT_ID: %w", err)
-		}
-		configs := map[string]oauthProvider{
-			"openrouter": {
-				Name:         "openrouter",
-				AuthorizeURL: "https://openrouter.ai/oauth/authorize",
-				RedirectURI:  fmt.Sprintf("%s/auth/openrouter/callback", redirectBase),
-				ClientID:     openrouterClientID,
-				Scopes:       []string{"offline", "openid", "profile"},
-			},
-			"google": {
-				Name:         "google",
-				AuthorizeURL: "https://accounts.google.com/o/oauth2/v2/auth",
-				RedirectURI:  fmt.Sprintf("%s/auth/google/callback", redirectBase),
-				ClientID:     googleClientID,
-				Scopes:       []string{"openid", "profile", "email", "https://www.googleapis.com/auth/cloud-platform"},
-			},
-		}
-		cfg, ok := configs[provider]
-		if !ok {
-			return oauthProvider{}, fmt.Errorf("unknown provider: %s", provider)
-		}
-		if cfg.ClientID == "" {
-			return oauthProvider{}, fmt.Errorf("provider %s is not configured", provider)
-		}
-		return cfg, nil
-	case "oidc":
-		return getOidcProvider()
-	default:
-		return oauthProvider{}, fmt.Errorf("unknown provider: %s", provider)
-	}
+// authProvider is an OAuth provider the gateway can run authorization flows
+// against. Each provider lives in its own auth_provider_<name>.go file, resolves
+// its own environment and secrets, and registers itself from init.
+type authProvider interface {
+	// Name is the {provider} path segment of the auth routes.
+	Name() string
+	// Configured reports whether the operator has started configuring the
+	// provider, so health checks can skip providers nobody uses.
+	Configured() bool
+	// Config resolves the client configuration, including default scopes.
+	Config() (oauthProvider, error)
+	// AuthorizeParams adds provider-specific parameters to the authorize URL.
+	AuthorizeParams(cfg oauthProvider, q url.Values)
+	// ExchangePayload adjusts the token-exchange payload the callback sends to
+	// the orchestrator.
+	ExchangePayload(cfg oauthProvider, payload map[string]any)
+	// JWKSURL returns the provider's key set URL, or errJWKSUnsupported.
+	JWKSURL() (string, error)
+	// IDTokenIssuers lists the accepted iss values; nil disables ID token
+	// validation for the provider.
+	IDTokenIssuers() []string
 }
 
-func getOidcProvider() (oauthProvider, error) {
-	issuer := strings.TrimSpace(os.Getenv("OIDC_ISSUER_URL"))
-	if issuer == "" {
-		return oauthProvider{}, fmt.Errorf("oidc issuer not configured")
-	}
-	clientID, err := ResolveEnvValue("OIDC_CLIENT_ID")
-	if err != nil {
-		return oauthProvider{}, fmt.Errorf("failed to load OIDC_CLIENT_ID: %w", err)
-	}
-	if clientID == "" {
-		return oauthProvider{}, fmt.Errorf("oidc client id not configured")
-	}
-
-	metadata, err := loadOidcMetadata(issuer)
-	if err != nil {
-		return oauthProvider{}, err
-	}
-
-	redirectBase := strings.TrimRight(GetEnv("OIDC_REDIRECT_BASE", GetEnv("OAUTH_REDIRECT_BASE", "http://127.0.0.1:8080")), "/")
-	if redirectBase == "" {
-		redirectBase = "http://127.0.0.1:8080"
-	}
-	rawScopes := os.Getenv("OIDC_SCOPES")
-	if strings.TrimSpace(rawScopes) == "" {
-		rawScopes = "openid profile email"
-	}
-	scopes := parseScopeList(rawScopes)
+// baseAuthProvider supplies the defaults for the optional authProvider hooks.
+type baseAuthProvider struct{}
 
-	return oauthProvider{
-		Name:         "oidc",
-		AuthorizeURL: metadata.authorizationEndpoint,
-		RedirectURI:  fmt.Sprintf("%s/auth/oidc/callback", redirectBase),
-		ClientID:     clientID,
-		Scopes:       scopes,
-	}, nil
-}
+func (baseAuthProvider) AuthorizeParams(oauthProvider, url.Values)     {}
+func (baseAuthProvider) ExchangePayload(oauthProvider, map[string]any) {}
+func (baseAuthProvider) JWKSURL() (string, error)                      { return "", errJWKSUnsupported }
+func (baseAuthProvider) IDTokenIssuers() []string                      { return nil }
 
-func loadOidcMetadata(issuer string) (oidcDiscovery, error) {
-	trimmed := strings.TrimRight(issuer, "/")
-	now := time.Now()
-	cache := &oidcDiscoveryCache
-
-	cache.mu.RLock()
-	if cache.metadata.authorizationEndpoint != "" && now.Before(cache.expires) {
-		metadata := cache.metadata
-		cache.mu.RUnlock()
-		return metadata, nil
-	}
-	cache.mu.RUnlock()
+var authProviders = map[string]authProvider{}
 
-	cache.mu.Lock()
-	defer cache.mu.Unlock()
-	if cache.metadata.authorizationEndpoint != "" && now.Before(cache.expires) {
-		return cache.metadata, nil
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	discoveryURL := fmt.Sprintf("%s/.well-known/openid-configuration", trimmed)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
-	if err != nil {
-		return oidcDiscovery{}, err
+// registerAuthProvider makes p available under p.Name(). It is called from
+// init, so a duplicate name is a programming error.
+func registerAuthProvider(p authProvider) {
+	name := p.Name()
+	if _, exists := authProviders[name]; exists {
+		panic(fmt.Sprintf("auth provider %q registered twice", name)) // panic: startup-only
 	}
+	authProviders[name] = p
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return oidcDiscovery{}, err
-	}
-	defer resp.Body.Close()
+func lookupAuthProvider(name string) (authProvider, bool) {
+	p, ok := authProviders[name]
+	return p, ok
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return oidcDiscovery{}, fmt.Errorf("oidc discovery returned %d", resp.StatusCode)
+// authProviderNames returns the registered provider names in sorted order.
+func authProviderNames() []string {
+	names := make([]string, 0, len(authProviders))
+	for name := range authProviders {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	var payload struct {
-		AuthorizationEndpoint string `json:"authorization_endpoint"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return oidcDiscovery{}, err
-	}
-	if payload.AuthorizationEndpoint == "" {
-		return oidcDiscovery{}, errors.New("oidc discovery missing authorization_endpoint")
+func getProviderConfig(provider string) (oauthProvider, error) {
+	p, ok := lookupAuthProvider(provider)
+	if !ok {
+		return oauthProvider{}, fmt.Errorf("unknown provider: %s", provider)
 	}
+	return p.Config()
+}
 
-	metadata := oidcDiscovery{authorizationEndpoint: payload.AuthorizationEndpoint}
-	cache.metadata = metadata
-	cache.expires = now.Add(15 * time.Minute)
-	return metadata, nil
+// oauthRedirectBase is the public base URL callbacks are registered under.
+// Provider registrations need a fixed value, so it comes from
+// OAUTH_REDIRECT_BASE or GATEWAY_PUBLIC_BASE_URL rather than the request.
+func oauthRedirectBase() string {
+	return strings.TrimRight(GetEnv("OAUTH_REDIRECT_BASE", GetEnv("GATEWAY_PUBLIC_BASE_URL", "http://127.0.0.1:8080")), "/")
 }
 
-func buildAuthorizeURL(cfg oauthProvider, state, codeChallenge string) (*url.URL, error) {
+func buildAuthorizeURL(cfg oauthProvider, state, codeChallenge, nonce string, passthrough authorizePassthrough) (*url.URL, error) {
 	u, err := url.Parse(cfg.AuthorizeURL)
 	if err != nil {
 		return nil, err
@@ -160,13 +93,29 @@ func buildAuthorizeURL(cfg oauthProvider, state, codeChallenge string) (*url.URL
 	q.Set("state", state)
 	q.Set("code_challenge", codeChallenge)
 	q.Set("code_challenge_method", "S256")
+	if nonce != "" {
+		q.Set("nonce", nonce)
+	}
 	if len(cfg.Scopes) > 0 {
 		q.Set("scope", strings.Join(cfg.Scopes, " "))
 	}
+	if p, ok := lookupAuthProvider(cfg.Name); ok {
+		p.AuthorizeParams(cfg, q)
+	}
+	passthrough.apply(q)
 	u.RawQuery = q.Encode()
 	return u, nil
 }
 
+func requestsOpenIDScope(scopes []string) bool {
+	for _, scope := range scopes {
+		if scope == "openid" {
+			return true
+		}
+	}
+	return false
+}
+
 func parseScopeList(raw string) []string {
 	if strings.TrimSpace(raw) == "" {
 		raw = "openid"
@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"net/url"
+	"testing"
+)
+
+type fakeAuthProvider struct{ baseAuthProvider }
+
+func (fakeAuthProvider) Name() string { return "fake" }
+
+func (fakeAuthProvider) Configured() bool { return true }
+
+func (fakeAuthProvider) Config() (oauthProvider, error) {
+	return oauthProvider{Name: "fake", AuthorizeURL: "https://idp.example.com/authorize", ClientID: "client", Scopes: []string{"openid"}}, nil
+}
+
+func (fakeAuthProvider) AuthorizeParams(_ oauthProvider, q url.Values) { q.Set("prompt", "consent") }
+
+func (fakeAuthProvider) ExchangePayload(_ oauthProvider, payload map[string]any) {
+	payload["audience"] = "api://fake"
+}
+
+func registerTestAuthProvider(t *testing.T, p authProvider) {
+	t.Helper()
+	registerAuthProvider(p)
+	t.Cleanup(func() { delete(authProviders, p.Name()) })
+}
+
+func TestRegisteredProviderHooksShapeAuthorizeURL(t *testing.T) {
+	registerTestAuthProvider(t, fakeAuthProvider{})
+
+	cfg, err := getProviderConfig("fake")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u, err := buildAuthorizeURL(cfg, "state", "challenge", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := u.Query().Get("prompt"); got != "consent" {
+		t.Fatalf("expected provider authorize parameter, got %q", got)
+	}
+	if got := u.Query().Get("client_id"); got != "client" {
+		t.Fatalf("expected standard parameters to remain, got client_id %q", got)
+	}
+}
+
+func TestRegisterAuthProviderRejectsDuplicates(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected duplicate registration to panic")
+		}
+	}()
+	registerAuthProvider(googleProvider{})
+}
+
+func TestBuiltInProvidersAreRegistered(t *testing.T) {
+	names := authProviderNames()
+	for _, want := range []string{"google", "oidc", "openrouter"} {
+		if _, ok := lookupAuthProvider(want); !ok {
+			t.Fatalf("expected %s to be registered, got %v", want, names)
+		}
+	}
+	if _, err := getProviderConfig("missing"); err == nil {
+		t.Fatal("expected unknown provider error")
+	}
+}
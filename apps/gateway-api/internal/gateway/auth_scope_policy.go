@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// resetOauthScopePolicies clears cached scope policies for tests.
+func resetOauthScopePolicies() {
+	oauthScopePoliciesMu.Lock()
+	defer oauthScopePoliciesMu.Unlock()
+	oauthScopePoliciesOnce = sync.Once{}
+	oauthScopePolicies = nil
+	oauthScopePoliciesErr = nil
+}
+
+func loadOauthScopePolicies() ([]oauthScopePolicy, error) {
+	oauthScopePoliciesMu.Lock()
+	defer oauthScopePoliciesMu.Unlock()
+	oauthScopePoliciesOnce.Do(func() {
+		raw, err := ResolveEnvValue("OAUTH_SCOPE_POLICIES")
+		if err != nil {
+			oauthScopePoliciesErr = fmt.Errorf("failed to load OAUTH_SCOPE_POLICIES: %w", err)
+			return
+		}
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" {
+			oauthScopePolicies = nil
+			return
+		}
+		parsed, parseErr := parseOauthScopePolicies(trimmed)
+		if parseErr != nil {
+			oauthScopePoliciesErr = parseErr
+			return
+		}
+		oauthScopePolicies = parsed
+	})
+	if oauthScopePoliciesErr != nil {
+		return nil, oauthScopePoliciesErr
+	}
+	return oauthScopePolicies, nil
+}
+
+func parseOauthScopePolicies(raw string) ([]oauthScopePolicy, error) {
+	type policyPayload struct {
+		TenantID      string   `json:"tenant_id"`
+		AppID         string   `json:"app"`
+		Provider      string   `json:"provider"`
+		AllowedScopes []string `json:"allowed_scopes"`
+		DeniedScopes  []string `json:"denied_scopes"`
+	}
+
+	var payload []policyPayload
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse OAUTH_SCOPE_POLICIES: %w", err)
+	}
+
+	result := make([]oauthScopePolicy, 0, len(payload))
+	for idx, entry := range payload {
+		tenantID, err := normalizeTenantID(entry.TenantID)
+		if err != nil {
+			return nil, fmt.Errorf("scope policy %d: %w", idx, err)
+		}
+		appID := ""
+		if strings.TrimSpace(entry.AppID) != "" {
+			if appID, err = normalizeClientApp(entry.AppID); err != nil {
+				return nil, fmt.Errorf("scope policy %d: %w", idx, err)
+			}
+		}
+		provider := strings.ToLower(strings.TrimSpace(entry.Provider))
+		if _, ok := lookupAuthProvider(provider); provider != "" && !ok {
+			return nil, fmt.Errorf("scope policy %d: unknown provider %q", idx, entry.Provider)
+		}
+		if len(entry.AllowedScopes) == 0 && len(entry.DeniedScopes) == 0 {
+			return nil, fmt.Errorf("scope policy %d: allowed_scopes or denied_scopes is required", idx)
+		}
+		result = append(result, oauthScopePolicy{
+			TenantID:      normalizeTenantKey(tenantID),
+			AppID:         appID,
+			Provider:      provider,
+			AllowedScopes: scopeSet(entry.AllowedScopes),
+			DeniedScopes:  scopeSet(entry.DeniedScopes),
+		})
+	}
+	return result, nil
+}
+
+func scopeSet(scopes []string) map[string]struct{} {
+	if len(scopes) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(scopes))
+	for _, scope := range scopes {
+		if trimmed := strings.TrimSpace(scope); trimmed != "" {
+			set[trimmed] = struct{}{}
+		}
+	}
+	return set
+}
+
+// matches reports whether the policy applies. Empty tenant, app or provider
+// fields act as wildcards so admins can layer global and per-tenant rules.
+func (p oauthScopePolicy) matches(tenantID, appID, provider string) bool {
+	if p.TenantID != "" && p.TenantID != normalizeTenantKey(tenantID) {
+		return false
+	}
+	if p.AppID != "" && p.AppID != appID {
+		return false
+	}
+	if p.Provider != "" && p.Provider != provider {
+		return false
+	}
+	return true
+}
+
+// disallowedScopes returns the requested scopes rejected by every policy that
+// applies to the tenant, client app and provider. The openid scope is always
+// permitted unless a policy denies it explicitly.
+func disallowedScopes(policies []oauthScopePolicy, tenantID, appID, provider string, scopes []string) []string {
+	offending := make(map[string]struct{})
+	for _, policy := range policies {
+		if !policy.matches(tenantID, appID, provider) {
+			continue
+		}
+		for _, scope := range scopes {
+			if _, denied := policy.DeniedScopes[scope]; denied {
+				offending[scope] = struct{}{}
+				continue
+			}
+			if policy.AllowedScopes == nil || scope == "openid" {
+				continue
+			}
+			if _, allowed := policy.AllowedScopes[scope]; !allowed {
+				offending[scope] = struct{}{}
+			}
+		}
+	}
+	if len(offending) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(offending))
+	for scope := range offending {
+		result = append(result, scope)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+func setOauthScopePolicies(t *testing.T, value string) {
+	t.Helper()
+	t.Setenv("OAUTH_SCOPE_POLICIES", value)
+	resetOauthScopePolicies()
+	t.Cleanup(resetOauthScopePolicies)
+}
+
+func TestParseOauthScopePoliciesValidatesEntries(t *testing.T) {
+	cases := map[string]string{
+		"invalid json":     `{`,
+		"unknown provider": `[{"provider":"github","denied_scopes":["repo"]}]`,
+		"invalid tenant":   `[{"tenant_id":"bad tenant","denied_scopes":["email"]}]`,
+		"empty policy":     `[{"tenant_id":"acme"}]`,
+	}
+	for name, raw := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseOauthScopePolicies(raw); err == nil {
+				t.Fatalf("expected error for %s", raw)
+			}
+		})
+	}
+}
+
+func TestDisallowedScopes(t *testing.T) {
+	policies, err := parseOauthScopePolicies(`[
+		{"tenant_id":"acme","provider":"google","denied_scopes":["` + cloudPlatformScope + `"]},
+		{"tenant_id":"acme","app":"cli","allowed_scopes":["profile"]},
+		{"denied_scopes":["offline"]}
+	]`)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	googleScopes := []string{"openid", "profile", "email", cloudPlatformScope}
+
+	cases := []struct {
+		name     string
+		tenant   string
+		app      string
+		provider string
+		scopes   []string
+		want     []string
+	}{
+		{name: "tenant deny", tenant: "ACME", app: "gui", provider: "google", scopes: googleScopes, want: []string{cloudPlatformScope}},
+		{name: "other tenant", tenant: "globex", app: "gui", provider: "google", scopes: googleScopes},
+		{name: "app allow list", tenant: "acme", app: "cli", provider: "google", scopes: googleScopes, want: []string{"email", cloudPlatformScope}},
+		{name: "global deny", tenant: "", app: "gui", provider: "openrouter", scopes: []string{"offline", "openid", "profile"}, want: []string{"offline"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := disallowedScopes(policies, tc.tenant, tc.app, tc.provider, tc.scopes)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("disallowedScopes() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeHandlerRejectsScopesDeniedByPolicy(t *testing.T) {
+	t.Setenv("GOOGLE_OAUTH_CLIENT_ID", "google-client")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+	setOauthScopePolicies(t, `[{"tenant_id":"acme","provider":"google","denied_scopes":["`+cloudPlatformScope+`"]}]`)
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/google/authorize?redirect_uri=https://app.example.com/complete&tenant_id=acme", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+	details := extractValidationDetails(t, decodeErrorResponse(t, rec))
+	if len(details) != 1 || details[0].Field != "scope" || !strings.Contains(details[0].Message, cloudPlatformScope) {
+		t.Fatalf("expected scope validation error listing %s, got %+v", cloudPlatformScope, details)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/auth/google/authorize?redirect_uri=https://app.example.com/complete&tenant_id=globex", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec = httptest.NewRecorder()
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected other tenants to be unaffected, got %d", rec.Code)
+	}
+}
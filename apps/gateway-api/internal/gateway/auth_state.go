@@ -5,7 +5,6 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"net"
 	"net/http"
 	"strings"
@@ -15,31 +14,63 @@ import (
 	"github.com/gorilla/securecookie"
 )
 
+const stateCookiePrefix = "oauth_state_"
+
 var stateTTL = GetDurationEnv("OAUTH_STATE_TTL", 10*time.Minute)
 var cookieHandler *securecookie.SecureCookie
 var cookieHandlerOnce sync.Once
 var generateStateAndPKCEFunc = generateStateAndPKCE
+var generateNonceFunc = generateNonce
 
 func getCookieHandler() *securecookie.SecureCookie {
 	cookieHandlerOnce.Do(func() {
-		hashKey, err := ResolveEnvValue("GATEWAY_COOKIE_HASH_KEY")
-		if err != nil || hashKey == "" {
-			hashKey = string(securecookie.GenerateRandomKey(64))
-		}
-
-		blockKey, err := ResolveEnvValue("GATEWAY_COOKIE_BLOCK_KEY")
-		if err != nil || blockKey == "" {
-			blockKey = string(securecookie.GenerateRandomKey(32))
-		}
-
-		cookieHandler = securecookie.New([]byte(hashKey), []byte(blockKey))
+		cookieHandler = newCookieCodec()
+		// JSON avoids compiling a fresh gob decoder for every state cookie,
+		// which dominated allocations on the callback path.
+		cookieHandler.SetSerializer(stateCookieSerializer{})
 	})
 	return cookieHandler
 }
 
+// stateCookieSerializer writes state cookies as JSON and still reads the gob
+// cookies issued before the switch, so sign-ins in flight during a deploy
+// complete. The gob fallback can go once OAUTH_STATE_TTL has passed on every
+// replica.
+type stateCookieSerializer struct{}
+
+func (stateCookieSerializer) Serialize(src any) ([]byte, error) {
+	return securecookie.JSONEncoder{}.Serialize(src)
+}
+
+func (stateCookieSerializer) Deserialize(src []byte, dst any) error {
+	if len(src) > 0 && src[0] != '{' {
+		return securecookie.GobEncoder{}.Deserialize(src, dst)
+	}
+	return securecookie.JSONEncoder{}.Deserialize(src, dst)
+}
+
+// newCookieCodec signs and encrypts cookies with GATEWAY_COOKIE_HASH_KEY and
+// GATEWAY_COOKIE_BLOCK_KEY, or with random keys that last until restart when
+// they are unset.
+func newCookieCodec() *securecookie.SecureCookie {
+	hashKey, err := ResolveEnvValue("GATEWAY_COOKIE_HASH_KEY")
+	if err != nil || hashKey == "" {
+		hashKey = string(securecookie.GenerateRandomKey(64))
+	}
+
+	blockKey, err := ResolveEnvValue("GATEWAY_COOKIE_BLOCK_KEY")
+	if err != nil || blockKey == "" {
+		blockKey = string(securecookie.GenerateRandomKey(32))
+	}
+
+	return securecookie.New([]byte(hashKey), []byte(blockKey))
+}
+
 func ResetCookieHandler() {
 	cookieHandlerOnce = sync.Once{}
 	cookieHandler = nil
+	deviceCookieCodecOnce = sync.Once{}
+	deviceCookieCodec = nil
 }
 
 func setStateCookie(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecure bool, data stateData) error {
@@ -48,38 +79,44 @@ func setStateCookie(w http.ResponseWriter, r *http.Request, trustedProxies []*ne
 		return errors.New("refusing to issue state cookie over insecure request")
 	}
 
-	encoded, err := getCookieHandler().Encode(stateCookieName(data.State), data)
+	name := stateCookieName(data.State)
+	encoded, err := getCookieHandler().Encode(name, data)
 	if err != nil {
 		return err
 	}
 
-	cookie := &http.Cookie{
-		Name:     stateCookieName(data.State),
-		Value:    encoded,
+	if err := trackStateCookie(w, r, trustedProxies, allowInsecure, data); err != nil {
+		return err
+	}
+	http.SetCookie(w, newStateCookie(r, trustedProxies, allowInsecure, name, encoded, data.ExpiresAt))
+	return nil
+}
+
+// newStateCookie builds a cookie scoped to the auth routes that expires at
+// expires. It is only marked insecure when insecure state cookies are
+// allowed and the request itself was not secure.
+func newStateCookie(r *http.Request, trustedProxies []*net.IPNet, allowInsecure bool, name, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
 		Path:     "/auth/",
-		Expires:  data.ExpiresAt,
-		MaxAge:   int(stateTTL.Seconds()),
+		Expires:  expires,
+		MaxAge:   max(int(time.Until(expires).Seconds()), 1),
 		HttpOnly: true,
-		Secure:   true,
+		Secure:   !allowInsecure || IsRequestSecure(r, trustedProxies) || strings.HasPrefix(PublicBaseURL(r.Context()), "https://"),
 		SameSite: http.SameSiteLaxMode,
 	}
-
-	if allowInsecure && !secureRequest {
-		cookie.Secure = false
-	}
-
-	http.SetCookie(w, cookie)
-	return nil
 }
 
 func readStateCookie(r *http.Request, state string) (stateData, error) {
-	cookie, err := r.Cookie(stateCookieName(state))
+	name := stateCookieName(state)
+	cookie, err := r.Cookie(name)
 	if err != nil {
 		return stateData{}, err
 	}
 
 	var data stateData
-	if err := getCookieHandler().Decode(stateCookieName(state), cookie.Value, &data); err != nil {
+	if err := getCookieHandler().Decode(name, cookie.Value, &data); err != nil {
 		return stateData{}, err
 	}
 
@@ -87,7 +124,8 @@ func readStateCookie(r *http.Request, state string) (stateData, error) {
 		return stateData{}, errors.New("state mismatch")
 	}
 
-	if time.Now().After(data.ExpiresAt) {
+	// Another replica may have issued the state.
+	if clockNow().After(data.ExpiresAt.Add(clockSkewTolerance())) {
 		return stateData{}, errors.New("state expired")
 	}
 
@@ -100,21 +138,8 @@ func deleteStateCookie(w http.ResponseWriter, r *http.Request, trustedProxies []
 		return
 	}
 
-	cookie := &http.Cookie{
-		Name:     stateCookieName(state),
-		Value:    "",
-		Path:     "/auth/",
-		Expires:  time.Unix(0, 0),
-		MaxAge:   -1,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
-	}
-
-	if allowInsecure && !secureRequest {
-		cookie.Secure = false
-	}
-
+	cookie := newStateCookie(r, trustedProxies, allowInsecure, stateCookieName(state), "", time.Unix(0, 0))
+	cookie.MaxAge = -1
 	http.SetCookie(w, cookie)
 }
 
@@ -186,6 +211,10 @@ func generateStateAndPKCE() (string, string, string, error) {
 	return state, verifier, challenge, nil
 }
 
+func generateNonce() (string, error) {
+	return randomString(32)
+}
+
 func randomString(length int) (string, error) {
 	buf := make([]byte, length)
 	if _, err := rand.Read(buf); err != nil {
@@ -200,5 +229,5 @@ func pkceChallenge(verifier string) string {
 }
 
 func stateCookieName(state string) string {
-	return fmt.Sprintf("oauth_state_%s", state)
+	return stateCookiePrefix + state
 }
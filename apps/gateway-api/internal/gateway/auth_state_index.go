@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// stateIndexCookieName lists the outstanding state cookies of a browser.
+	// It deliberately does not start with stateCookiePrefix.
+	stateIndexCookieName     = "oauth_states"
+	defaultMaxStateCookies   = 5
+	maxStateIndexCookieBytes = 2048
+)
+
+// stateIndexEntry records one outstanding authorization attempt.
+type stateIndexEntry struct {
+	State     string `json:"s"`
+	ExpiresAt int64  `json:"e"`
+}
+
+func maxStateCookies() int {
+	if limit := GetIntEnv("GATEWAY_MAX_STATE_COOKIES", defaultMaxStateCookies); limit > 0 {
+		return limit
+	}
+	return defaultMaxStateCookies
+}
+
+// outstandingStates returns the unexpired authorization attempts whose state
+// cookie the browser still holds, oldest first, and the state cookies that
+// should be removed because they no longer decode or have expired. States
+// missing from the index, for example because two tabs started sign-in at
+// the same time and one index write won, are recovered by decoding their
+// cookie.
+func outstandingStates(r *http.Request, now time.Time) ([]stateIndexEntry, []string) {
+	present := make(map[string]*http.Cookie)
+	for _, cookie := range r.Cookies() {
+		if state, ok := strings.CutPrefix(cookie.Name, stateCookiePrefix); ok && state != "" {
+			present[state] = cookie
+		}
+	}
+
+	var indexed []stateIndexEntry
+	if cookie, err := r.Cookie(stateIndexCookieName); err == nil && len(cookie.Value) <= maxStateIndexCookieBytes {
+		if err := getCookieHandler().Decode(stateIndexCookieName, cookie.Value, &indexed); err != nil {
+			indexed = nil
+		}
+	}
+
+	entries := make([]stateIndexEntry, 0, len(present))
+	seen := make(map[string]struct{}, len(present))
+	for _, entry := range indexed {
+		if _, ok := present[entry.State]; !ok || !now.Before(time.Unix(entry.ExpiresAt, 0)) {
+			continue
+		}
+		if _, dup := seen[entry.State]; dup {
+			continue
+		}
+		seen[entry.State] = struct{}{}
+		entries = append(entries, entry)
+	}
+
+	var stale []string
+	for state, cookie := range present {
+		if _, ok := seen[state]; ok {
+			continue
+		}
+		var data stateData
+		if err := getCookieHandler().Decode(cookie.Name, cookie.Value, &data); err != nil || data.State != state || !now.Before(data.ExpiresAt) {
+			stale = append(stale, state)
+			continue
+		}
+		entries = append(entries, stateIndexEntry{State: state, ExpiresAt: data.ExpiresAt.Unix()})
+	}
+
+	// Every state is issued with the same TTL, so expiry order is issuance
+	// order.
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].ExpiresAt < entries[j].ExpiresAt })
+	sort.Strings(stale)
+	return entries, stale
+}
+
+// trackStateCookie adds data.State to the browser's state index and expires
+// the oldest state cookies beyond GATEWAY_MAX_STATE_COOKIES, along with any
+// that are undecodable or expired, so abandoned sign-ins cannot crowd out
+// the domain's other cookies.
+func trackStateCookie(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecure bool, data stateData) error {
+	entries, stale := outstandingStates(r, clockNow())
+	// A deduplicated sign-in re-issues a state the browser may already hold.
+	entries = slices.DeleteFunc(entries, func(entry stateIndexEntry) bool { return entry.State == data.State })
+	entries = append(entries, stateIndexEntry{State: data.State, ExpiresAt: data.ExpiresAt.Unix()})
+	if excess := len(entries) - maxStateCookies(); excess > 0 {
+		for _, evicted := range entries[:excess] {
+			stale = append(stale, evicted.State)
+		}
+		entries = entries[excess:]
+	}
+	for _, state := range stale {
+		deleteStateCookie(w, r, trustedProxies, allowInsecure, state)
+	}
+
+	encoded, err := getCookieHandler().Encode(stateIndexCookieName, entries)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, newStateCookie(r, trustedProxies, allowInsecure, stateIndexCookieName, encoded, time.Unix(entries[len(entries)-1].ExpiresAt, 0)))
+	return nil
+}
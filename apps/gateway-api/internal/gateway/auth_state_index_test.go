@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// browserJar replays the cookies a browser would hold for /auth/ across
+// authorize requests.
+type browserJar map[string]*http.Cookie
+
+func (j browserJar) apply(cookies []*http.Cookie) {
+	for _, cookie := range cookies {
+		if cookie.MaxAge < 0 {
+			delete(j, cookie.Name)
+			continue
+		}
+		j[cookie.Name] = cookie
+	}
+}
+
+func (j browserJar) request() *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "https://gateway.example.com/auth/openrouter/authorize", nil)
+	req.TLS = &tls.ConnectionState{}
+	for _, cookie := range j {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	return req
+}
+
+func issueState(t *testing.T, jar browserJar, state string, expiresAt time.Time) []*http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	data := stateData{Provider: "openrouter", State: state, ExpiresAt: expiresAt}
+	if err := setStateCookie(rec, jar.request(), nil, false, data); err != nil {
+		t.Fatalf("failed to set state cookie: %v", err)
+	}
+	cookies := rec.Result().Cookies()
+	jar.apply(cookies)
+	return cookies
+}
+
+func TestSetStateCookieEvictsOldestBeyondLimit(t *testing.T) {
+	setupTestCookies(t)
+	t.Setenv("GATEWAY_MAX_STATE_COOKIES", "3")
+	jar := browserJar{}
+	base := time.Now().Add(stateTTL)
+
+	for i := 0; i < 3; i++ {
+		issueState(t, jar, fmt.Sprintf("state-%d", i), base.Add(time.Duration(i)*time.Second))
+	}
+	cookies := issueState(t, jar, "state-3", base.Add(3*time.Second))
+
+	evicted := findCookie(cookies, stateCookieName("state-0"))
+	if evicted == nil || evicted.MaxAge != -1 {
+		t.Fatalf("expected the oldest state cookie to be expired, got %#v", evicted)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, ok := jar[stateCookieName(fmt.Sprintf("state-%d", i))]; !ok {
+			t.Fatalf("expected state-%d to remain outstanding", i)
+		}
+	}
+	if len(jar) != 4 {
+		t.Fatalf("expected three state cookies plus the index, got %d cookies", len(jar))
+	}
+
+	entries, stale := outstandingStates(jar.request(), time.Now())
+	if len(entries) != 3 || entries[0].State != "state-1" || entries[2].State != "state-3" || len(stale) != 0 {
+		t.Fatalf("unexpected index %+v (stale %v)", entries, stale)
+	}
+}
+
+func TestSetStateCookieCleansUpOrphanedCookies(t *testing.T) {
+	setupTestCookies(t)
+	t.Setenv("GATEWAY_MAX_STATE_COOKIES", "3")
+	jar := browserJar{}
+	base := time.Now().Add(stateTTL)
+
+	// A state cookie set by a concurrent tab whose index write was lost, and
+	// a cookie that no longer decodes (for example after a key rotation).
+	orphan := stateData{Provider: "openrouter", State: "orphan", ExpiresAt: base}
+	encoded, err := getCookieHandler().Encode(stateCookieName("orphan"), orphan)
+	if err != nil {
+		t.Fatalf("failed to encode state data: %v", err)
+	}
+	jar[stateCookieName("orphan")] = &http.Cookie{Name: stateCookieName("orphan"), Value: encoded}
+	jar[stateCookieName("garbage")] = &http.Cookie{Name: stateCookieName("garbage"), Value: "not-a-cookie"}
+
+	cookies := issueState(t, jar, "state-a", base.Add(time.Second))
+	if cleared := findCookie(cookies, stateCookieName("garbage")); cleared == nil || cleared.MaxAge != -1 {
+		t.Fatalf("expected undecodable state cookie to be expired, got %#v", cleared)
+	}
+	if findCookie(cookies, stateCookieName("orphan")) != nil {
+		t.Fatal("expected the orphaned but valid state cookie to be kept")
+	}
+
+	entries, _ := outstandingStates(jar.request(), time.Now())
+	if len(entries) != 2 || entries[0].State != "orphan" || entries[1].State != "state-a" {
+		t.Fatalf("expected the orphan to be indexed ahead of the new state, got %+v", entries)
+	}
+
+	// Completing a sign-in removes its cookie; the index drops it on the next
+	// write instead of counting it against the limit.
+	delete(jar, stateCookieName("orphan"))
+	issueState(t, jar, "state-b", base.Add(2*time.Second))
+	issueState(t, jar, "state-c", base.Add(3*time.Second))
+	entries, _ = outstandingStates(jar.request(), time.Now())
+	if len(entries) != 3 || entries[0].State != "state-a" {
+		t.Fatalf("expected consumed states to leave the index, got %+v", entries)
+	}
+}
@@ -0,0 +1,219 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+const (
+	defaultConsumedStateKeyPrefix = "gateway:oauth:state"
+	consumedStateSweepInterval    = time.Minute
+	consumedStateNamespace        = "oauth_state"
+)
+
+// consumedStateStore records OAuth state values that have already been used
+// by a callback so a captured callback URL cannot be replayed.
+type consumedStateStore interface {
+	// Consume marks state as used until expiresAt. It returns false when the
+	// state had already been consumed.
+	Consume(ctx context.Context, state string, expiresAt time.Time) (bool, error)
+}
+
+type memoryConsumedStateStore struct {
+	mu        sync.Mutex
+	entries   map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newMemoryConsumedStateStore() *memoryConsumedStateStore {
+	return &memoryConsumedStateStore{
+		entries: make(map[string]time.Time),
+		now:     clockNow,
+	}
+}
+
+func (s *memoryConsumedStateStore) Consume(_ context.Context, state string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) >= consumedStateSweepInterval {
+		for key, expiry := range s.entries {
+			if !now.Before(expiry) {
+				delete(s.entries, key)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	key := consumedStateKey(state)
+	if expiry, ok := s.entries[key]; ok && now.Before(expiry) {
+		return false, nil
+	}
+	s.entries[key] = expiresAt
+	return true, nil
+}
+
+// redisConsumedStateStore shares consumed states across gateway replicas. When
+// Redis is unreachable it falls back to the in-process store so logins keep
+// working; replays are then only detected per replica.
+type redisConsumedStateStore struct {
+	client   *storage.RedisClient
+	prefix   string
+	fallback *memoryConsumedStateStore
+}
+
+func (s *redisConsumedStateStore) Consume(ctx context.Context, state string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	key := s.prefix + ":" + consumedStateKey(state)
+	reply, err := s.client.Do(ctx, "SET", key, "1", "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	switch {
+	case errors.Is(err, storage.ErrRedisNil):
+		return false, nil
+	case err != nil:
+		authLog.WarnContext(ctx, "oauth state store unavailable; using in-memory replay protection", slog.Any("error", err))
+		return s.fallback.Consume(ctx, state, expiresAt)
+	}
+	if reply != "OK" {
+		return false, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	return true, nil
+}
+
+// storageConsumedStateStore keeps consumed states in GATEWAY_STORAGE_URL, so
+// with a file or Redis store they survive restarts. When the store fails it
+// falls back to the in-process store like the Redis backend, unless the
+// strict cold-start policy is in its grace period.
+type storageConsumedStateStore struct {
+	store    storage.Store
+	durable  bool
+	fallback *memoryConsumedStateStore
+}
+
+func (s *storageConsumedStateStore) Consume(ctx context.Context, state string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	firstUse, err := s.store.SetNX(ctx, consumedStateKey(state), []byte("1"), ttl)
+	if err != nil {
+		if currentColdStartPolicy().failClosed(clockNow()) {
+			return false, err
+		}
+		authLog.WarnContext(ctx, "oauth state store unavailable; using in-memory replay protection", slog.Any("error", err))
+		return s.fallback.Consume(ctx, state, expiresAt)
+	}
+	return firstUse, nil
+}
+
+// consumedStatesDurable reports whether states consumed by a previous
+// process are still known to store.
+func consumedStatesDurable(store consumedStateStore) bool {
+	switch store := store.(type) {
+	case *redisConsumedStateStore:
+		return true
+	case *storageConsumedStateStore:
+		return store.durable
+	default:
+		return false
+	}
+}
+
+// consumedStateKey hashes the state so raw state values never sit in memory
+// or Redis longer than the callback that used them.
+func consumedStateKey(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	consumedStatesMu   sync.Mutex
+	consumedStatesOnce sync.Once
+	consumedStates     consumedStateStore
+	consumedStatesErr  error
+)
+
+// resetConsumedStateStore clears the cached store for tests.
+func resetConsumedStateStore() {
+	consumedStatesMu.Lock()
+	defer consumedStatesMu.Unlock()
+	if store, ok := consumedStates.(*redisConsumedStateStore); ok {
+		_ = store.client.Close()
+		forgetRedisClient(redisComponentOAuthState)
+	}
+	consumedStatesOnce = sync.Once{}
+	consumedStates = nil
+	consumedStatesErr = nil
+}
+
+// loadConsumedStateStore selects the backend from OAUTH_STATE_STORE. Without a
+// backend, OAUTH_STATE_REDIS_URL selects Redis and otherwise GATEWAY_STORAGE_URL
+// selects the shared state store.
+func loadConsumedStateStore() (consumedStateStore, error) {
+	consumedStatesMu.Lock()
+	defer consumedStatesMu.Unlock()
+	consumedStatesOnce.Do(func() {
+		redisURL, err := ResolveEnvValue("OAUTH_STATE_REDIS_URL")
+		if err != nil {
+			consumedStatesErr = fmt.Errorf("failed to load OAUTH_STATE_REDIS_URL: %w", err)
+			return
+		}
+		backend := strings.ToLower(strings.TrimSpace(os.Getenv("OAUTH_STATE_STORE")))
+		if backend == "" {
+			switch {
+			case redisURL != "":
+				backend = "redis"
+			case strings.TrimSpace(os.Getenv("GATEWAY_STORAGE_URL")) != "" || strings.TrimSpace(os.Getenv("GATEWAY_STORAGE_URL_FILE")) != "":
+				backend = "storage"
+			}
+		}
+		switch backend {
+		case "", "memory":
+			consumedStates = newMemoryConsumedStateStore()
+		case "storage":
+			store, err := loadStateStorage()
+			if err != nil {
+				consumedStatesErr = err
+				return
+			}
+			consumedStates = &storageConsumedStateStore{
+				store:    storage.Namespace(store, consumedStateNamespace),
+				durable:  storageDurable(store),
+				fallback: newMemoryConsumedStateStore(),
+			}
+		case "redis":
+			if redisURL == "" {
+				consumedStatesErr = errors.New("OAUTH_STATE_STORE=redis requires OAUTH_STATE_REDIS_URL")
+				return
+			}
+			client, err := newRedisClient(redisComponentOAuthState, redisURL, "OAuth state replays are only detected per replica")
+			if err != nil {
+				consumedStatesErr = err
+				return
+			}
+			consumedStates = &redisConsumedStateStore{
+				client:   client,
+				prefix:   GetEnv("OAUTH_STATE_REDIS_KEY_PREFIX", defaultConsumedStateKeyPrefix),
+				fallback: newMemoryConsumedStateStore(),
+			}
+		default:
+			consumedStatesErr = fmt.Errorf("unsupported OAUTH_STATE_STORE %q", backend)
+		}
+	})
+	return consumedStates, consumedStatesErr
+}
@@ -0,0 +1,257 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage/storagetest"
+)
+
+func TestMemoryConsumedStateStoreAcceptsStateOnce(t *testing.T) {
+	store := newMemoryConsumedStateStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	expires := now.Add(time.Minute)
+
+	if ok, err := store.Consume(context.Background(), "state-1", expires); err != nil || !ok {
+		t.Fatalf("expected first use to succeed, got %v, %v", ok, err)
+	}
+	if ok, _ := store.Consume(context.Background(), "state-1", expires); ok {
+		t.Fatal("expected replayed state to be rejected")
+	}
+	if ok, _ := store.Consume(context.Background(), "state-2", expires); !ok {
+		t.Fatal("expected a different state to be accepted")
+	}
+
+	now = now.Add(2 * time.Minute)
+	store.Consume(context.Background(), "state-3", now.Add(time.Minute))
+	if _, tracked := store.entries[consumedStateKey("state-1")]; tracked {
+		t.Fatal("expected expired entries to be swept")
+	}
+}
+
+func TestRedisConsumedStateStoreUsesSetNX(t *testing.T) {
+	redis := storagetest.NewFakeRedis(t)
+	t.Setenv("OAUTH_STATE_STORE", "")
+	t.Setenv("OAUTH_STATE_REDIS_URL", redis.URL())
+	resetConsumedStateStore()
+	t.Cleanup(resetConsumedStateStore)
+
+	store, err := loadConsumedStateStore()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := store.(*redisConsumedStateStore); !ok {
+		t.Fatalf("expected redis store to be selected from the url, got %T", store)
+	}
+	expires := time.Now().Add(time.Minute)
+	if ok, err := store.Consume(context.Background(), "state-1", expires); err != nil || !ok {
+		t.Fatalf("expected first use to succeed, got %v, %v", ok, err)
+	}
+	if ok, err := store.Consume(context.Background(), "state-1", expires); err != nil || ok {
+		t.Fatalf("expected replay to be rejected, got %v, %v", ok, err)
+	}
+
+	cmd := redis.Commands()[0]
+	if len(cmd) != 6 || cmd[3] != "NX" || cmd[4] != "PX" || !strings.HasPrefix(cmd[1], defaultConsumedStateKeyPrefix+":") || strings.Contains(cmd[1], "state-1") {
+		t.Fatalf("unexpected redis command %q", cmd)
+	}
+}
+
+func TestRedisConsumedStateStoreFallsBackToMemory(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client, err := storage.NewRedisClient("redis://" + addr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store := &redisConsumedStateStore{client: client, prefix: "test", fallback: newMemoryConsumedStateStore()}
+	expires := time.Now().Add(time.Minute)
+	if ok, err := store.Consume(context.Background(), "state-1", expires); err != nil || !ok {
+		t.Fatalf("expected fallback to accept first use, got %v, %v", ok, err)
+	}
+	if ok, _ := store.Consume(context.Background(), "state-1", expires); ok {
+		t.Fatal("expected fallback to reject replay")
+	}
+}
+
+func TestLoadConsumedStateStoreRejectsInvalidConfig(t *testing.T) {
+	cases := map[string][2]string{
+		"unknown backend":   {"dynamo", ""},
+		"redis without url": {"redis", ""},
+		"bad scheme":        {"redis", "http://localhost:6379"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("OAUTH_STATE_STORE", tc[0])
+			t.Setenv("OAUTH_STATE_REDIS_URL", tc[1])
+			resetConsumedStateStore()
+			t.Cleanup(resetConsumedStateStore)
+			if _, err := loadConsumedStateStore(); err == nil {
+				t.Fatal("expected configuration error")
+			}
+		})
+	}
+}
+
+func TestCallbackHandlerRejectsReplayedState(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+
+	calls := 0
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"ok":true}`)),
+				Header:     make(http.Header),
+			}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+
+	data := stateData{
+		Provider:     "openrouter",
+		RedirectURI:  "https://app.example.com/complete",
+		CodeVerifier: "verifier",
+		ExpiresAt:    time.Now().Add(time.Minute),
+		State:        "state-token",
+	}
+	encoded, err := getCookieHandler().Encode(stateCookieName(data.State), data)
+	if err != nil {
+		t.Fatalf("failed to encode state data: %v", err)
+	}
+	callback := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?code=abc&state=state-token", nil)
+		req.TLS = &tls.ConnectionState{}
+		req.AddCookie(&http.Cookie{Name: stateCookieName(data.State), Value: encoded, Path: "/auth/"})
+		rec := httptest.NewRecorder()
+		callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
+		return rec
+	}
+
+	if rec := callback(); rec.Code != http.StatusFound {
+		t.Fatalf("expected first callback to redirect, got %d", rec.Code)
+	}
+	rec := callback()
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected replayed callback to be rejected, got %d", rec.Code)
+	}
+	if resp := decodeErrorResponse(t, rec); resp.Code != "invalid_request" {
+		t.Fatalf("expected invalid_request, got %s", resp.Code)
+	}
+	if calls != 1 {
+		t.Fatalf("expected orchestrator to be called once, got %d", calls)
+	}
+}
+
+func TestStorageConsumedStateStoreSurvivesRestart(t *testing.T) {
+	t.Setenv("OAUTH_STATE_STORE", "")
+	t.Setenv("OAUTH_STATE_REDIS_URL", "")
+	t.Setenv("GATEWAY_STORAGE_URL", "file://"+filepath.Join(t.TempDir(), "state.db"))
+	resetStateStorage()
+	resetConsumedStateStore()
+	t.Cleanup(resetStateStorage)
+	t.Cleanup(resetConsumedStateStore)
+
+	store, err := loadConsumedStateStore()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !consumedStatesDurable(store) {
+		t.Fatalf("expected a durable store to be selected from GATEWAY_STORAGE_URL, got %T", store)
+	}
+	expires := time.Now().Add(time.Minute)
+	if ok, err := store.Consume(context.Background(), "state-1", expires); err != nil || !ok {
+		t.Fatalf("expected first use to succeed, got %v, %v", ok, err)
+	}
+
+	resetStateStorage()
+	resetConsumedStateStore()
+	store, _ = loadConsumedStateStore()
+	if ok, err := store.Consume(context.Background(), "state-1", expires); err != nil || ok {
+		t.Fatalf("expected replay after a restart to be rejected, got %v, %v", ok, err)
+	}
+}
+
+func TestStorageConsumedStateStoreColdStartPolicy(t *testing.T) {
+	store := &storageConsumedStateStore{
+		store:    &failingSetNXStore{Store: storage.NewMemoryStore()},
+		durable:  true,
+		fallback: newMemoryConsumedStateStore(),
+	}
+	expires := time.Now().Add(time.Minute)
+	if ok, err := store.Consume(context.Background(), "state-1", expires); err != nil || !ok {
+		t.Fatalf("expected the open policy to fall back to memory, got %v, %v", ok, err)
+	}
+
+	t.Setenv("GATEWAY_COLD_START_POLICY", "strict")
+	t.Setenv("GATEWAY_COLD_START_GRACE", "876000h")
+	if _, err := store.Consume(context.Background(), "state-2", expires); err == nil {
+		t.Fatal("expected the strict policy to fail during the grace period")
+	}
+}
+
+// failingSetNXStore is a store whose writes always fail.
+type failingSetNXStore struct {
+	storage.Store
+}
+
+func (failingSetNXStore) SetNX(context.Context, string, []byte, time.Duration) (bool, error) {
+	return false, errors.New("store unavailable")
+}
+
+func TestCallbackHandlerColdStartRejectsStatesFromBeforeRestart(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	t.Setenv("OAUTH_STATE_STORE", "memory")
+	t.Setenv("GATEWAY_COLD_START_POLICY", "strict")
+	t.Setenv("GATEWAY_COLD_START_GRACE", "876000h")
+	resetConsumedStateStore()
+	t.Cleanup(resetConsumedStateStore)
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+	logs := captureAuditLogs(t)
+
+	data := stateData{
+		Provider:     "openrouter",
+		RedirectURI:  "https://app.example.com/complete",
+		CodeVerifier: "verifier",
+		ExpiresAt:    processStarted.Add(stateTTL - time.Second),
+		State:        "state-token",
+	}
+	encoded, err := getCookieHandler().Encode(stateCookieName(data.State), data)
+	if err != nil {
+		t.Fatalf("failed to encode state data: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?code=abc&state=state-token", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: stateCookieName(data.State), Value: encoded, Path: "/auth/"})
+	rec := httptest.NewRecorder()
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a state issued before the restart to be rejected, got %d", rec.Code)
+	}
+	if !strings.Contains(logs.String(), "state_issued_before_restart") {
+		t.Fatalf("expected the denial to be audited, got %s", logs.String())
+	}
+}
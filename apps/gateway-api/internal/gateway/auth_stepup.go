@@ -0,0 +1,229 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStepUpTokenTTL    = 5 * time.Minute
+	minStepUpSigningKeyBytes = 32
+	maxACRValuesLength       = 256
+	// maxAuthMaxAge bounds max_age to a year; larger values are meaningless
+	// for forcing re-authentication.
+	maxAuthMaxAge = 365 * 24 * 60 * 60
+)
+
+var (
+	errStepUpNotConfigured    = errors.New("GATEWAY_STEPUP_SIGNING_KEY is not configured")
+	errStepUpRequiresIDTokens = errors.New("GATEWAY_STEPUP_SIGNING_KEY requires GATEWAY_VALIDATE_ID_TOKEN=true")
+	errStepUpUnverified       = errors.New("step-up requires a verified id_token auth_time")
+)
+
+// stepUpHandler forces the caller to re-authenticate with an OIDC provider.
+// The provider is taken from the provider query parameter and must accept
+// prompt=login and max_age; the callback returns a short-lived binding token
+// the orchestrator can verify before allowing sensitive capabilities.
+func stepUpHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, allowInsecureStateCookie bool) {
+	provider := strings.TrimSpace(r.URL.Query().Get("provider"))
+	details := map[string]any{"provider": provider}
+	if provider == "" {
+		auditStepUpEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{
+			"reason": "provider_required",
+		}))
+		writeValidationError(w, r, []validationError{{Field: "provider", Message: "provider is required"}})
+		return
+	}
+	if _, err := loadStepUpSigningKey(); err != nil {
+		auditStepUpEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{
+			"reason": "stepup_not_configured",
+			"error":  err.Error(),
+		}))
+		writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "step-up authentication is not configured", nil)
+		return
+	}
+	// A step-up token vouches for a fresh login, which only a verified ID
+	// token's auth_time proves.
+	if !idTokenValidationEnabled() {
+		auditStepUpEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{
+			"reason": "stepup_not_configured",
+			"error":  errStepUpRequiresIDTokens.Error(),
+		}))
+		writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "step-up authentication is not configured", nil)
+		return
+	}
+	if p, ok := lookupAuthProvider(provider); ok && len(p.IDTokenIssuers()) == 0 {
+		auditStepUpEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{
+			"reason": "provider_without_id_tokens",
+		}))
+		writeValidationError(w, r, []validationError{{Field: "provider", Message: "provider does not issue ID tokens"}})
+		return
+	}
+
+	session, ok := requireActiveSession(w, r, trustedProxies, auditStepUpEvent, details, "an active session is required for step-up authentication")
+	if !ok {
+		return
+	}
+	beginAuthorization(w, r, trustedProxies, allowInsecureStateCookie, provider, authFlow{Kind: authFlowStepUp, Session: &session}, nil)
+}
+
+// authContextParams holds the OIDC authentication request parameters that are
+// passed through to the provider.
+type authContextParams struct {
+	ACRValues string
+	MaxAge    *int
+	Prompt    string
+}
+
+// parseAuthContextParams validates the optional acr_values and max_age query
+// parameters. acr_values is a space-separated list of URIs or tokens.
+func parseAuthContextParams(query url.Values) (authContextParams, []validationError) {
+	var (
+		params authContextParams
+		errs   []validationError
+	)
+	if raw := strings.TrimSpace(query.Get("acr_values")); raw != "" {
+		values := strings.Fields(raw)
+		valid := len(raw) <= maxACRValuesLength
+		for _, value := range values {
+			if !isValidACRValue(value) {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			params.ACRValues = strings.Join(values, " ")
+		} else {
+			errs = append(errs, validationError{Field: "acr_values", Message: "acr_values must be a space-separated list of authentication context references"})
+		}
+	}
+	if raw := strings.TrimSpace(query.Get("max_age")); raw != "" {
+		maxAge, err := strconv.Atoi(raw)
+		if err != nil || maxAge < 0 || maxAge > maxAuthMaxAge {
+			errs = append(errs, validationError{Field: "max_age", Message: "max_age must be a non-negative number of seconds"})
+		} else {
+			params.MaxAge = &maxAge
+		}
+	}
+	return params, errs
+}
+
+func isValidACRValue(value string) bool {
+	for _, r := range value {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(":/.-_#?=&~%+@", r):
+		default:
+			return false
+		}
+	}
+	return value != ""
+}
+
+func (p authContextParams) empty() bool {
+	return p.ACRValues == "" && p.MaxAge == nil && p.Prompt == ""
+}
+
+// apply adds the parameters to the provider authorize URL.
+func (p authContextParams) apply(u *url.URL) {
+	if p.empty() {
+		return
+	}
+	q := u.Query()
+	if p.ACRValues != "" {
+		q.Set("acr_values", p.ACRValues)
+	}
+	if p.MaxAge != nil {
+		q.Set("max_age", strconv.Itoa(*p.MaxAge))
+	}
+	if p.Prompt != "" {
+		q.Set("prompt", p.Prompt)
+	}
+	u.RawQuery = q.Encode()
+}
+
+// checkIDTokenAuthTime enforces max_age using the auth_time claim, which
+// providers must return when max_age was requested.
+func checkIDTokenAuthTime(claims idTokenClaims, maxAge int, now time.Time) error {
+	if claims.AuthTime == nil {
+		return errors.New("id_token auth_time missing")
+	}
+	authTime := time.Unix(*claims.AuthTime, 0)
+	if now.Sub(authTime) > time.Duration(maxAge)*time.Second+clockSkewTolerance() {
+		return errors.New("id_token auth_time exceeds max_age")
+	}
+	return nil
+}
+
+func loadStepUpSigningKey() ([]byte, error) {
+	key, err := ResolveEnvValue("GATEWAY_STEPUP_SIGNING_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_STEPUP_SIGNING_KEY: %w", err)
+	}
+	if key == "" {
+		return nil, errStepUpNotConfigured
+	}
+	if len(key) < minStepUpSigningKeyBytes {
+		return nil, fmt.Errorf("GATEWAY_STEPUP_SIGNING_KEY must be at least %d bytes", minStepUpSigningKeyBytes)
+	}
+	return []byte(key), nil
+}
+
+// ValidateStepUpConfig checks at startup that step-up, when enabled, can
+// verify the logins it vouches for.
+func ValidateStepUpConfig() error {
+	if _, err := loadStepUpSigningKey(); err != nil {
+		if errors.Is(err, errStepUpNotConfigured) {
+			return nil
+		}
+		return err
+	}
+	if !idTokenValidationEnabled() {
+		return errStepUpRequiresIDTokens
+	}
+	return nil
+}
+
+func stepUpTokenTTL() time.Duration {
+	return GetDurationEnv("GATEWAY_STEPUP_TOKEN_TTL", defaultStepUpTokenTTL)
+}
+
+// issueStepUpToken mints the binding token returned after a completed step-up.
+// idClaims must come from a verified ID token whose auth_time already passed
+// the max_age check of data; anything less is refused.
+func issueStepUpToken(provider string, data stateData, idClaims idTokenClaims, now time.Time) (string, error) {
+	if data.MaxAge == nil || idClaims.AuthTime == nil {
+		return "", errStepUpUnverified
+	}
+	key, err := loadStepUpSigningKey()
+	if err != nil {
+		return "", err
+	}
+	claims := stepUpClaims{
+		SessionID: data.SessionID,
+		Provider:  provider,
+		TenantID:  data.TenantID,
+		ACR:       idClaims.ACR,
+		AuthTime:  *idClaims.AuthTime,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(stepUpTokenTTL()).Unix(),
+	}
+	return signGatewayToken(key, gatewayTokenStepUp, claims)
+}
+
+// stepUpClaims is the payload of a step-up binding token.
+type stepUpClaims struct {
+	SessionID string `json:"sid"`
+	Provider  string `json:"provider"`
+	TenantID  string `json:"tenant,omitempty"`
+	ACR       string `json:"acr,omitempty"`
+	AuthTime  int64  `json:"auth_time"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
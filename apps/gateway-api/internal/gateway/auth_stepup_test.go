@@ -0,0 +1,289 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testStepUpKey = "0123456789abcdef0123456789abcdef"
+
+// setupStepUp enables step-up for google, whose ID tokens the gateway
+// verifies.
+func setupStepUp(t *testing.T, sessionID string) *linkOrchestrator {
+	t.Helper()
+	fake := setupLinkOrchestrator(t, sessionID)
+	t.Setenv("GATEWAY_STEPUP_SIGNING_KEY", testStepUpKey)
+	t.Setenv("GOOGLE_OAUTH_CLIENT_ID", testIDTokenClientID)
+	t.Setenv("GATEWAY_VALIDATE_ID_TOKEN", "true")
+	return fake
+}
+
+// verifyStepUpToken checks a step-up token the way the orchestrator must:
+// the signature with the shared key and the expiry.
+func verifyStepUpToken(key []byte, token string, now time.Time) (stepUpClaims, error) {
+	var claims stepUpClaims
+	if err := verifyGatewayToken(key, gatewayTokenStepUp, token, &claims); err != nil {
+		return stepUpClaims{}, err
+	}
+	if !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return stepUpClaims{}, errors.New("step-up token expired")
+	}
+	return claims, nil
+}
+
+func decodeStateFromRedirect(t *testing.T, rec *httptest.ResponseRecorder) (*url.URL, stateData) {
+	t.Helper()
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect location: %v", err)
+	}
+	state := location.Query().Get("state")
+	cookie := findCookie(rec.Result().Cookies(), stateCookieName(state))
+	if cookie == nil {
+		t.Fatal("expected state cookie")
+	}
+	var data stateData
+	if err := getCookieHandler().Decode(cookie.Name, cookie.Value, &data); err != nil {
+		t.Fatalf("failed to decode state: %v", err)
+	}
+	return location, data
+}
+
+func TestAuthorizeHandlerPassesAuthContext(t *testing.T) {
+	setupLinkOrchestrator(t, "session-1")
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?redirect_uri=https://app.example.com/complete&acr_values=urn:mace:incommon:iap:silver%20mfa&max_age=300", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location, data := decodeStateFromRedirect(t, rec)
+	if got := location.Query().Get("acr_values"); got != "urn:mace:incommon:iap:silver mfa" {
+		t.Fatalf("expected acr_values to be forwarded, got %q", got)
+	}
+	if got := location.Query().Get("max_age"); got != "300" {
+		t.Fatalf("expected max_age to be forwarded, got %q", got)
+	}
+	if location.Query().Get("prompt") != "" {
+		t.Fatal("expected no prompt on a regular authorize")
+	}
+	if data.MaxAge == nil || *data.MaxAge != 300 || data.ACRValues != "urn:mace:incommon:iap:silver mfa" {
+		t.Fatalf("expected auth context in state, got %+v", data)
+	}
+}
+
+func TestAuthorizeHandlerRejectsInvalidAuthContext(t *testing.T) {
+	setupLinkOrchestrator(t, "session-1")
+
+	cases := map[string]string{
+		"negative max_age":   "max_age=-1",
+		"non-numeric":        "max_age=soon",
+		"invalid acr values": "acr_values=%3Cscript%3E",
+		"oversized acr":      "acr_values=" + strings.Repeat("a", maxACRValuesLength+1),
+	}
+	for name, query := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?redirect_uri=https://app.example.com/complete&"+query, nil)
+			req.TLS = &tls.ConnectionState{}
+			rec := httptest.NewRecorder()
+			authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d", rec.Code)
+			}
+		})
+	}
+}
+
+func TestStepUpHandlerRequiresSigningKey(t *testing.T) {
+	setupLinkOrchestrator(t, "session-1")
+	t.Setenv("GATEWAY_STEPUP_SIGNING_KEY", "")
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/stepup?provider=openrouter&redirect_uri=https://app.example.com/complete", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: "oss_session", Value: "abc"})
+	rec := httptest.NewRecorder()
+	stepUpHandler(rec, req, nil, false)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a signing key, got %d", rec.Code)
+	}
+}
+
+func TestStepUpHandlerRequiresIDTokenValidation(t *testing.T) {
+	setupStepUp(t, "session-1")
+
+	stepUp := func(provider string) int {
+		req := httptest.NewRequest(http.MethodGet, "/auth/stepup?provider="+provider+"&redirect_uri=https://app.example.com/complete", nil)
+		req.TLS = &tls.ConnectionState{}
+		req.AddCookie(&http.Cookie{Name: "oss_session", Value: "abc"})
+		rec := httptest.NewRecorder()
+		stepUpHandler(rec, req, nil, false)
+		return rec.Code
+	}
+	if code := stepUp("openrouter"); code != http.StatusBadRequest {
+		t.Fatalf("expected providers without ID tokens to be rejected, got %d", code)
+	}
+	t.Setenv("GATEWAY_VALIDATE_ID_TOKEN", "false")
+	if code := stepUp("google"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without ID token validation, got %d", code)
+	}
+	if err := ValidateStepUpConfig(); !errors.Is(err, errStepUpRequiresIDTokens) {
+		t.Fatalf("expected startup to reject step-up without ID token validation, got %v", err)
+	}
+	t.Setenv("GATEWAY_STEPUP_SIGNING_KEY", "")
+	if err := ValidateStepUpConfig(); err != nil {
+		t.Fatalf("expected step-up to be optional, got %v", err)
+	}
+}
+
+func TestStepUpHandlerForcesReauthentication(t *testing.T) {
+	setupStepUp(t, "session-1")
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/stepup?provider=google&redirect_uri=https://app.example.com/complete", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	stepUpHandler(rec, req, nil, false)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a session, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/auth/stepup?provider=google&redirect_uri=https://app.example.com/complete&max_age=600", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: "oss_session", Value: "abc"})
+	rec = httptest.NewRecorder()
+	stepUpHandler(rec, req, nil, false)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect to provider, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location, data := decodeStateFromRedirect(t, rec)
+	if location.Query().Get("prompt") != "login" || location.Query().Get("max_age") != "0" {
+		t.Fatalf("expected forced re-authentication, got %s", location.RawQuery)
+	}
+	if data.Flow != authFlowStepUp || data.SessionID != "session-1" || data.MaxAge == nil || *data.MaxAge != 0 {
+		t.Fatalf("expected step-up state, got %+v", data)
+	}
+}
+
+// runStepUpCallback completes a google step-up whose code exchange returns
+// idToken.
+func runStepUpCallback(t *testing.T, fake *linkOrchestrator, idToken string) *url.URL {
+	t.Helper()
+	fake.idToken = idToken
+	forceReauth := 0
+	data := stateData{
+		Provider:     "google",
+		RedirectURI:  "https://app.example.com/complete",
+		CodeVerifier: "verifier",
+		ExpiresAt:    time.Now().Add(time.Minute),
+		State:        "state-token",
+		Nonce:        testIDTokenNonce,
+		TenantID:     "acme",
+		Flow:         authFlowStepUp,
+		SessionID:    "session-1",
+		MaxAge:       &forceReauth,
+	}
+	encoded, err := getCookieHandler().Encode(stateCookieName(data.State), data)
+	if err != nil {
+		t.Fatalf("failed to encode state data: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/google/callback?code=abc&state=state-token", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: stateCookieName(data.State), Value: encoded, Path: "/auth/"})
+	req.AddCookie(&http.Cookie{Name: "oss_session", Value: "abc"})
+	rec := httptest.NewRecorder()
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	return location
+}
+
+func TestCallbackHandlerIssuesStepUpToken(t *testing.T) {
+	signer := setupIDTokenIssuer(t)
+	fake := setupStepUp(t, "session-1")
+	claims := validIDTokenClaims()
+	authTime := time.Now().Unix()
+	claims["auth_time"] = authTime
+
+	location := runStepUpCallback(t, fake, signer.sign(t, "RS256", "rsa-1", claims))
+	if got := location.Query().Get("status"); got != "success" {
+		t.Fatalf("expected success redirect, got %s", location)
+	}
+	if !strings.Contains(fake.callbackBody, `"step_up":true`) {
+		t.Fatalf("expected step-up payload, got %s", fake.callbackBody)
+	}
+	token, err := verifyStepUpToken([]byte(testStepUpKey), location.Query().Get("step_up_token"), time.Now())
+	if err != nil {
+		t.Fatalf("expected a valid step-up token: %v", err)
+	}
+	if token.SessionID != "session-1" || token.Provider != "google" || token.TenantID != "acme" || token.AuthTime != authTime {
+		t.Fatalf("unexpected step-up claims %+v", token)
+	}
+	if token.ExpiresAt-token.IssuedAt != int64(defaultStepUpTokenTTL/time.Second) {
+		t.Fatalf("expected default ttl, got %d seconds", token.ExpiresAt-token.IssuedAt)
+	}
+}
+
+func TestCallbackHandlerRefusesUnverifiedStepUp(t *testing.T) {
+	signer := setupIDTokenIssuer(t)
+	fake := setupStepUp(t, "session-1")
+
+	// The ID token carries no auth_time, so the fresh login is unproven.
+	location := runStepUpCallback(t, fake, signer.sign(t, "RS256", "rsa-1", validIDTokenClaims()))
+	if location.Query().Get("status") != "error" || location.Query().Get("step_up_token") != "" {
+		t.Fatalf("expected the step-up to fail, got %s", location)
+	}
+
+	forceReauth := 0
+	data := stateData{SessionID: "session-1", Flow: authFlowStepUp, MaxAge: &forceReauth}
+	if _, err := issueStepUpToken("google", data, idTokenClaims{}, time.Now()); !errors.Is(err, errStepUpUnverified) {
+		t.Fatalf("expected unverified claims to be refused, got %v", err)
+	}
+}
+
+func TestStepUpTokenRejectsTampering(t *testing.T) {
+	now := time.Now()
+	token, err := signGatewayToken([]byte(testStepUpKey), gatewayTokenStepUp, stepUpClaims{SessionID: "s", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := verifyStepUpToken([]byte(testStepUpKey), token, now); err != nil {
+		t.Fatalf("expected token to verify: %v", err)
+	}
+	if _, err := verifyStepUpToken([]byte(strings.Repeat("x", 32)), token, now); err == nil {
+		t.Fatal("expected a different key to be rejected")
+	}
+	if _, err := verifyStepUpToken([]byte(testStepUpKey), token, now.Add(2*time.Minute)); err == nil {
+		t.Fatal("expected an expired token to be rejected")
+	}
+
+	// The same key and claims minted for another purpose must not pass.
+	other, err := signGatewayToken([]byte(testStepUpKey), gatewayTokenPlanShare, stepUpClaims{SessionID: "s", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := verifyStepUpToken([]byte(testStepUpKey), other, now); err == nil {
+		t.Fatal("expected a token of another type to be rejected")
+	}
+}
+
+func TestCheckIDTokenAuthTime(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-30 * time.Second).Unix()
+	stale := now.Add(-10 * time.Minute).Unix()
+
+	if err := checkIDTokenAuthTime(idTokenClaims{AuthTime: &recent}, 0, now); err != nil {
+		t.Fatalf("expected recent auth_time to pass: %v", err)
+	}
+	if err := checkIDTokenAuthTime(idTokenClaims{AuthTime: &stale}, 300, now); err == nil {
+		t.Fatal("expected stale auth_time to be rejected")
+	}
+	if err := checkIDTokenAuthTime(idTokenClaims{}, 300, now); err == nil {
+		t.Fatal("expected missing auth_time to be rejected")
+	}
+}
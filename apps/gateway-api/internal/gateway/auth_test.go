@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/gorilla/securecookie"
 )
 
 type httpErrorPayload struct {
@@ -66,6 +67,8 @@ func setupTestCookies(t *testing.T) {
 	t.Setenv("GATEWAY_COOKIE_BLOCK_KEY", "1234567890123456")                // 16 bytes
 	ResetCookieHandler()
 	getCookieHandler() // Ensure initialization
+	resetConsumedStateStore()
+	t.Cleanup(resetConsumedStateStore)
 }
 
 func TestNormalizeSessionBindingRejectsInvalidCharacters(t *testing.T) {
@@ -230,7 +233,7 @@ func TestAuthorizeHandlerRejectsOversizedRedirectURI(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?"+values.Encode(), nil)
 	rec := httptest.NewRecorder()
 
-	authorizeHandler(rec, req, nil, false)
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400, got %d", rec.Code)
@@ -263,7 +266,7 @@ func TestCallbackHandlerRejectsOversizedState(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?"+values.Encode(), nil)
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400, got %d", rec.Code)
@@ -292,7 +295,7 @@ func TestAuthorizeHandlerGeneratesPKCEChallenge(t *testing.T) {
 	req.TLS = &tls.ConnectionState{}
 	rec := httptest.NewRecorder()
 
-	authorizeHandler(rec, req, nil, false)
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	res := rec.Result()
 	if res.StatusCode != http.StatusFound {
@@ -360,7 +363,7 @@ func TestAuthorizeHandlerPersistsTenantIDInState(t *testing.T) {
 	req.TLS = &tls.ConnectionState{}
 	rec := httptest.NewRecorder()
 
-	authorizeHandler(rec, req, nil, false)
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusFound {
 		t.Fatalf("expected authorize handler to redirect, got %d", rec.Code)
@@ -397,7 +400,7 @@ func TestAuthorizeHandlerRejectsUnregisteredClientWhenRegistrationsConfigured(t
 	req.TLS = &tls.ConnectionState{}
 	rec := httptest.NewRecorder()
 
-	authorizeHandler(rec, req, nil, false)
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 when client is not registered, got %d", rec.Code)
@@ -420,7 +423,7 @@ func TestAuthorizeHandlerRejectsInvalidTenantID(t *testing.T) {
 	)
 	rec := httptest.NewRecorder()
 
-	authorizeHandler(rec, req, nil, false)
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected invalid tenant to return 400, got %d", rec.Code)
@@ -443,7 +446,7 @@ func TestAuthorizeHandlerRejectsInvalidRedirect(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?redirect_uri=https://evil.example.com", nil)
 	rec := httptest.NewRecorder()
 
-	authorizeHandler(rec, req, nil, false)
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected invalid redirect_uri to return 400, got %d", rec.Code)
@@ -469,7 +472,7 @@ func TestAuthorizeHandlerRejectsMissingRedirect(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize", nil)
 	rec := httptest.NewRecorder()
 
-	authorizeHandler(rec, req, nil, false)
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected missing redirect_uri to return 400, got %d", rec.Code)
@@ -501,7 +504,7 @@ func TestAuthorizeHandlerAllowsExpectedRedirects(t *testing.T) {
 			req.TLS = &tls.ConnectionState{}
 			rec := httptest.NewRecorder()
 
-			authorizeHandler(rec, req, nil, false)
+			authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 			if rec.Code != http.StatusFound {
 				t.Fatalf("expected authorize handler to redirect for %s, got %d", redirectURI, rec.Code)
@@ -520,7 +523,7 @@ func TestAuthorizeHandlerRequiresSessionBindingForRegisteredClient(t *testing.T)
 	req.TLS = &tls.ConnectionState{}
 	rec := httptest.NewRecorder()
 
-	authorizeHandler(rec, req, nil, false)
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 when session_binding missing, got %d", rec.Code)
@@ -541,11 +544,13 @@ func TestAuthorizeHandlerUsesTenantClientRegistration(t *testing.T) {
 	allowedRedirectOrigins = loadAllowedRedirectOrigins()
 	setOidcRegistrations(t, `[{"tenant_id":"acme","app":"gui","client_id":"tenant-client","redirect_origins":["https://app.example.com"],"session_binding_required":false}]`)
 
+	logs := captureAuditLogs(t)
+
 	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?redirect_uri="+url.QueryEscape("https://app.example.com/complete")+"&tenant_id=acme&session_binding=bind-123", nil)
 	req.TLS = &tls.ConnectionState{}
 	rec := httptest.NewRecorder()
 
-	authorizeHandler(rec, req, nil, false)
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusFound {
 		t.Fatalf("expected redirect, got %d", rec.Code)
@@ -561,6 +566,26 @@ func TestAuthorizeHandlerUsesTenantClientRegistration(t *testing.T) {
 	if got := parsed.Query().Get("client_id"); got != "tenant-client" {
 		t.Fatalf("expected tenant client_id, got %s (redirect=%s)", got, location)
 	}
+	if !strings.Contains(logs.String(), `"binding_id_hash":"`+hashSessionBinding("bind-123")+`"`) {
+		t.Fatalf("expected the authorize audit to carry the binding hash, got %s", logs.String())
+	}
+}
+
+func TestReadStateCookieAcceptsGobCookies(t *testing.T) {
+	setupTestCookies(t)
+	data := stateData{Provider: "openrouter", State: "state-token", ExpiresAt: time.Now().Add(time.Minute)}
+	legacy := newCookieCodec()
+	legacy.SetSerializer(securecookie.GobEncoder{})
+	encoded, err := legacy.Encode(stateCookieName(data.State), data)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback", nil)
+	req.AddCookie(&http.Cookie{Name: stateCookieName(data.State), Value: encoded})
+	decoded, err := readStateCookie(req, data.State)
+	if err != nil || decoded.Provider != "openrouter" {
+		t.Fatalf("expected a cookie issued before the JSON switch to decode, got %+v: %v", decoded, err)
+	}
 }
 
 func TestCallbackHandlerRejectsExpiredState(t *testing.T) {
@@ -590,7 +615,7 @@ func TestCallbackHandlerRejectsExpiredState(t *testing.T) {
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 for expired state, got %d", rec.Code)
@@ -606,7 +631,7 @@ func TestCallbackHandlerRejectsMissingParameters(t *testing.T) {
 	req.TLS = &tls.ConnectionState{}
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected missing parameters to return 400, got %d", rec.Code)
@@ -755,7 +780,7 @@ func TestCallbackHandlerRejectsStateMismatch(t *testing.T) {
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 for state mismatch, got %d", rec.Code)
@@ -803,7 +828,7 @@ func TestCallbackHandlerHandlesOrchestratorContactFailure(t *testing.T) {
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadGateway {
 		t.Fatalf("expected 502 when orchestrator contact fails, got %d", rec.Code)
@@ -817,6 +842,56 @@ func TestCallbackHandlerHandlesOrchestratorContactFailure(t *testing.T) {
 	}
 }
 
+func TestCallbackHandlerRejectsOversizedOrchestratorResponse(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	t.Setenv("GATEWAY_MAX_UPSTREAM_RESPONSE_BYTES", "64")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			header := make(http.Header)
+			header.Add("Set-Cookie", "oss_session=session-1; Path=/; HttpOnly; Secure")
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"ok":true,"padding":"` + strings.Repeat("x", 128) + `"}`)),
+				Header:     header,
+			}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+
+	data := stateData{
+		Provider:     "openrouter",
+		RedirectURI:  "https://app.example.com/complete",
+		CodeVerifier: "verifier",
+		ExpiresAt:    time.Now().Add(1 * time.Minute),
+		State:        "state-token",
+	}
+	encoded, err := getCookieHandler().Encode(stateCookieName(data.State), data)
+	if err != nil {
+		t.Fatalf("failed to encode state data: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?code=abc&state=state-token", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.AddCookie(&http.Cookie{Name: stateCookieName(data.State), Value: encoded, Path: "/auth/"})
+	rec := httptest.NewRecorder()
+
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 for oversized orchestrator response, got %d", rec.Code)
+	}
+	if resp := decodeErrorResponse(t, rec); resp.Code != "upstream_error" {
+		t.Fatalf("expected upstream_error code, got %s", resp.Code)
+	}
+	if findCookie(rec.Result().Cookies(), "oss_session") != nil {
+		t.Fatal("expected session cookie to be withheld for oversized responses")
+	}
+}
+
 func TestCallbackHandlerIncludesTenantIDInUpstreamPayload(t *testing.T) {
 	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
 	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
@@ -859,7 +934,7 @@ func TestCallbackHandlerIncludesTenantIDInUpstreamPayload(t *testing.T) {
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if !strings.Contains(capturedBody, `"tenant_id":"acme"`) {
 		t.Fatalf("expected upstream payload to include tenant_id, got %s", capturedBody)
@@ -915,7 +990,7 @@ func TestCallbackHandlerUsesClientIDFromRegistration(t *testing.T) {
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if !strings.Contains(capturedBody, `"client_id":"tenant-client"`) {
 		t.Fatalf("expected upstream payload to include overridden client_id, got %s", capturedBody)
@@ -954,7 +1029,7 @@ func TestCallbackHandlerRejectsStateClientIDMismatch(t *testing.T) {
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected callback handler to reject mismatched client_id, got %d", rec.Code)
@@ -970,9 +1045,12 @@ func TestCallbackHandlerPropagatesSessionBinding(t *testing.T) {
 	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
 	allowedRedirectOrigins = loadAllowedRedirectOrigins()
 	setupTestCookies(t)
+	logs := captureAuditLogs(t)
 
+	var exchanged map[string]any
 	SetOrchestratorClientFactory(func() (*http.Client, error) {
 		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			_ = json.NewDecoder(req.Body).Decode(&exchanged)
 			return &http.Response{
 				StatusCode: http.StatusOK,
 				Body:       io.NopCloser(strings.NewReader("{}")),
@@ -1003,7 +1081,7 @@ func TestCallbackHandlerPropagatesSessionBinding(t *testing.T) {
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusFound {
 		t.Fatalf("expected redirect, got %d", rec.Code)
@@ -1016,6 +1094,14 @@ func TestCallbackHandlerPropagatesSessionBinding(t *testing.T) {
 	if got := parsed.Query().Get("session_binding"); got != "bind-123" {
 		t.Fatalf("expected session_binding to be propagated, got %s (location=%s)", got, location)
 	}
+
+	bindingHash := hashSessionBinding("bind-123")
+	if exchanged["session_binding_hash"] != bindingHash {
+		t.Fatalf("expected the binding hash in the code exchange, got %v", exchanged)
+	}
+	if !strings.Contains(logs.String(), `"binding_id_hash":"`+bindingHash+`"`) || strings.Contains(logs.String(), `"bind-123"`) {
+		t.Fatalf("expected callback audit to carry only the binding hash, got %s", logs.String())
+	}
 }
 
 func TestCallbackHandlerRejectsUnregisteredClientWhenRegistrationsExist(t *testing.T) {
@@ -1052,7 +1138,7 @@ func TestCallbackHandlerRejectsUnregisteredClientWhenRegistrationsExist(t *testi
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 when registrations exist but client app is not registered, got %d", rec.Code)
@@ -1096,7 +1182,7 @@ func TestCallbackHandlerRejectsTamperedTenantID(t *testing.T) {
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 for tampered tenant, got %d", rec.Code)
@@ -1161,7 +1247,7 @@ func TestCallbackHandlerRedirectsOnOrchestratorError(t *testing.T) {
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if callCount != 1 {
 		t.Fatalf("expected single orchestrator call, got %d", callCount)
@@ -1244,7 +1330,7 @@ func TestCallbackHandlerSuccessPropagatesCookies(t *testing.T) {
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if got := atomic.LoadInt32(&requestCount); got != 1 {
 		t.Fatalf("expected orchestrator to be called once, got %d", got)
@@ -1362,7 +1448,7 @@ func TestCallbackHandlerDropsInsecureUpstreamCookie(t *testing.T) {
 	})
 	rec := httptest.NewRecorder()
 
-	callbackHandler(rec, req, nil, false)
+	callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	res := rec.Result()
 	if res.StatusCode != http.StatusFound {
@@ -1431,7 +1517,7 @@ func TestAuthorizeAuditIncludesActorAndTenantHashes(t *testing.T) {
 	req.TLS = &tls.ConnectionState{}
 	rec := httptest.NewRecorder()
 
-	authorizeHandler(rec, req, nil, false)
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusFound {
 		t.Fatalf("expected authorize handler to redirect, got %d", rec.Code)
@@ -1504,7 +1590,7 @@ func TestAuthorizeAuditIncludesTenantHashWhenStateGenerationFails(t *testing.T)
 	req.TLS = &tls.ConnectionState{}
 	rec := httptest.NewRecorder()
 
-	authorizeHandler(rec, req, nil, false)
+	authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
 
 	if rec.Code != http.StatusInternalServerError {
 		t.Fatalf("expected internal error when state generation fails, got %d", rec.Code)
@@ -1734,16 +1820,16 @@ func TestLoadOidcMetadataCachingAndTimeout(t *testing.T) {
 	}))
 	t.Cleanup(server.Close)
 
-	originalClient := http.DefaultClient
-	http.DefaultClient = server.Client()
-	t.Cleanup(func() { http.DefaultClient = originalClient })
+	originalClient := identityHTTPClient
+	identityHTTPClient = server.Client()
+	t.Cleanup(func() { identityHTTPClient = originalClient })
 
 	issuer := server.URL
-	meta1, err := loadOidcMetadata(issuer)
+	meta1, err := loadOidcMetadata("oidc", issuer)
 	if err != nil {
 		t.Fatalf("unexpected error loading metadata: %v", err)
 	}
-	meta2, err := loadOidcMetadata(issuer)
+	meta2, err := loadOidcMetadata("oidc", issuer)
 	if err != nil {
 		t.Fatalf("unexpected error loading cached metadata: %v", err)
 	}
@@ -1754,9 +1840,9 @@ func TestLoadOidcMetadataCachingAndTimeout(t *testing.T) {
 		t.Fatalf("expected discovery endpoint to be called once, got %d", callCount)
 	}
 
-	originalRoundTripper := http.DefaultClient.Transport
+	originalRoundTripper := identityHTTPClient.Transport
 	deadlineObserved := make(chan time.Time, 1)
-	http.DefaultClient.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+	identityHTTPClient.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
 		if d, ok := req.Context().Deadline(); ok {
 			deadlineObserved <- d
 		} else {
@@ -1764,11 +1850,11 @@ func TestLoadOidcMetadataCachingAndTimeout(t *testing.T) {
 		}
 		return nil, context.DeadlineExceeded
 	})
-	t.Cleanup(func() { http.DefaultClient.Transport = originalRoundTripper })
+	t.Cleanup(func() { identityHTTPClient.Transport = originalRoundTripper })
 
 	resetOidcCache()
 	start := time.Now()
-	_, err = loadOidcMetadata(issuer)
+	_, err = loadOidcMetadata("oidc", issuer)
 	if !errors.Is(err, context.DeadlineExceeded) {
 		t.Fatalf("expected deadline exceeded error, got %v", err)
 	}
@@ -2149,8 +2235,7 @@ func TestNormalizeUpstreamCookies(t *testing.T) {
 
 func resetOidcCache() {
 	oidcDiscoveryCache.mu.Lock()
-	oidcDiscoveryCache.metadata = oidcDiscovery{}
-	oidcDiscoveryCache.expires = time.Time{}
+	oidcDiscoveryCache.entries = nil
 	oidcDiscoveryCache.mu.Unlock()
 }
 
@@ -2168,3 +2253,12 @@ type roundTripperFunc func(*http.Request) (*http.Response, error)
 func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 	return fn(req)
 }
+
+// withProviderPathValue sets the {provider} wildcard the way ServeMux would
+// for requests passed straight to a handler.
+func withProviderPathValue(req *http.Request) *http.Request {
+	if parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/auth/"), "/"); len(parts) == 2 {
+		req.SetPathValue("provider", parts[0])
+	}
+	return req
+}
@@ -0,0 +1,370 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+const (
+	authTraceNamespace          = "auth_trace"
+	defaultAuthTraceTTL         = time.Hour
+	maxAuthTraceTTL             = 7 * 24 * time.Hour
+	maxAuthTraceSteps           = 64
+	maxAuthTraceValueLength     = 256
+	maxAuthTraceRequestIDLength = 128
+
+	authTraceKindAuthorize = "authorize"
+	authTraceKindCallback  = "callback"
+
+	authTraceRedacted = "[redacted]"
+)
+
+// authTraceSecretKeys are detail keys whose values a trace never keeps, on
+// top of any key naming a secret, token, password, cookie or verifier.
+var authTraceSecretKeys = map[string]bool{
+	"code":       true,
+	"state":      true,
+	"nonce":      true,
+	"session_id": true,
+}
+
+// authTrace is the recorded sequence of gateway decisions for one sign-in
+// request. Requests of the same sign-in share a FlowID, derived from the
+// OAuth state.
+type authTrace struct {
+	RequestID    string          `json:"request_id"`
+	Kind         string          `json:"kind"`
+	Provider     string          `json:"provider"`
+	TenantIDHash string          `json:"tenant_id_hash,omitempty"`
+	FlowID       string          `json:"flow_id,omitempty"`
+	StartedAt    time.Time       `json:"started_at"`
+	Duration     string          `json:"duration"`
+	Status       int             `json:"status"`
+	Steps        []authTraceStep `json:"steps"`
+	Truncated    bool            `json:"truncated,omitempty"`
+}
+
+// authTraceStep is one decision: an audit event the flow emitted, or a
+// check that passed on the way.
+type authTraceStep struct {
+	Offset  string         `json:"offset"`
+	Step    string         `json:"step"`
+	Outcome string         `json:"outcome,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// authTraceRecorder collects the trace of a request while it is handled.
+type authTraceRecorder struct {
+	start time.Time
+
+	mu       sync.Mutex
+	trace    authTrace
+	tenantID string
+}
+
+type authTraceContextKey struct{}
+
+func authTraceFrom(ctx context.Context) *authTraceRecorder {
+	recorder, _ := ctx.Value(authTraceContextKey{}).(*authTraceRecorder)
+	return recorder
+}
+
+// traceAuthStep adds a decision to the trace of the request in ctx, if one
+// is being recorded. Secret values in details are redacted.
+func traceAuthStep(ctx context.Context, step, outcome string, details map[string]any) {
+	recorder := authTraceFrom(ctx)
+	if recorder == nil {
+		return
+	}
+	entry := authTraceStep{
+		Offset:  clockNow().Sub(recorder.start).String(),
+		Step:    step,
+		Outcome: outcome,
+		Details: redactAuthTraceDetails(details),
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.trace.Steps) >= maxAuthTraceSteps {
+		recorder.trace.Truncated = true
+		return
+	}
+	recorder.trace.Steps = append(recorder.trace.Steps, entry)
+}
+
+// traceAuthTenant records the tenant the sign-in is for, whose settings
+// decide whether the trace is kept.
+func traceAuthTenant(ctx context.Context, tenantID string) {
+	if recorder := authTraceFrom(ctx); recorder != nil {
+		recorder.mu.Lock()
+		recorder.tenantID = tenantID
+		recorder.trace.TenantIDHash = hashTenantID(tenantID)
+		recorder.mu.Unlock()
+	}
+}
+
+// traceAuthFlow links the request to the others of its sign-in through the
+// OAuth state, which is hashed rather than kept.
+func traceAuthFlow(ctx context.Context, state string) {
+	if recorder := authTraceFrom(ctx); recorder != nil && state != "" {
+		recorder.mu.Lock()
+		recorder.trace.FlowID = gatewayAuditLogger.HashIdentity(authTraceNamespace, state)
+		recorder.mu.Unlock()
+	}
+}
+
+func redactAuthTraceDetails(details map[string]any) map[string]any {
+	if len(details) == 0 {
+		return nil
+	}
+	redacted := make(map[string]any, len(details))
+	for key, value := range auditDetails(details) {
+		lower := strings.ToLower(key)
+		switch {
+		case strings.HasSuffix(lower, "_hash"):
+			redacted[key] = value
+		case authTraceSecretKeys[lower],
+			strings.Contains(lower, "secret"),
+			strings.Contains(lower, "token"),
+			strings.Contains(lower, "password"),
+			strings.Contains(lower, "cookie"),
+			strings.Contains(lower, "verifier"),
+			strings.Contains(lower, "authorization"):
+			redacted[key] = authTraceRedacted
+		default:
+			if text, ok := value.(string); ok && len(text) > maxAuthTraceValueLength {
+				value = text[:maxAuthTraceValueLength]
+			}
+			redacted[key] = value
+		}
+	}
+	return redacted
+}
+
+// authTraceCapture stores the traces of tenants that enabled
+// auth.debug_capture in the shared state storage, so the admin API of any
+// replica can return them.
+type authTraceCapture struct {
+	ttl   time.Duration
+	store storage.Store
+}
+
+var (
+	authTraceMu       sync.Mutex
+	authTraceOnce     sync.Once
+	authTraceCapturer *authTraceCapture
+	authTraceErr      error
+)
+
+// resetAuthTraceCapture clears the shared capture for tests.
+func resetAuthTraceCapture() {
+	authTraceMu.Lock()
+	defer authTraceMu.Unlock()
+	authTraceOnce = sync.Once{}
+	authTraceCapturer = nil
+	authTraceErr = nil
+}
+
+func parseAuthTraceConfig() (bool, time.Duration, error) {
+	enabled, err := strconv.ParseBool(GetEnv("GATEWAY_AUTH_DEBUG_CAPTURE", "false"))
+	if err != nil {
+		return false, 0, fmt.Errorf("GATEWAY_AUTH_DEBUG_CAPTURE must be a boolean: %w", err)
+	}
+	ttl := defaultAuthTraceTTL
+	if raw := strings.TrimSpace(GetEnv("GATEWAY_AUTH_DEBUG_CAPTURE_TTL", "")); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 || parsed > maxAuthTraceTTL {
+			return false, 0, fmt.Errorf("GATEWAY_AUTH_DEBUG_CAPTURE_TTL must be a duration between 0 and %s", maxAuthTraceTTL)
+		}
+		ttl = parsed
+	}
+	return enabled, ttl, nil
+}
+
+// ValidateAuthTraceConfig checks GATEWAY_AUTH_DEBUG_CAPTURE and
+// GATEWAY_AUTH_DEBUG_CAPTURE_TTL at startup.
+func ValidateAuthTraceConfig() error {
+	_, _, err := parseAuthTraceConfig()
+	return err
+}
+
+// loadAuthTraceCapture returns the shared capture, or nil when
+// GATEWAY_AUTH_DEBUG_CAPTURE is off.
+func loadAuthTraceCapture() (*authTraceCapture, error) {
+	authTraceMu.Lock()
+	defer authTraceMu.Unlock()
+	authTraceOnce.Do(func() {
+		enabled, ttl, err := parseAuthTraceConfig()
+		if err != nil || !enabled {
+			authTraceErr = err
+			return
+		}
+		store, err := loadStateStorage()
+		if err != nil {
+			authTraceErr = err
+			return
+		}
+		authTraceCapturer = &authTraceCapture{ttl: ttl, store: storage.Namespace(store, authTraceNamespace)}
+	})
+	return authTraceCapturer, authTraceErr
+}
+
+// captureAuthTrace records the decisions next makes for one sign-in request
+// when GATEWAY_AUTH_DEBUG_CAPTURE is on. The trace is stored under the
+// request ID only if the tenant's auth.debug_capture setting is on, or the
+// tenant configuration defaults for sign-ins without a tenant.
+func captureAuthTrace(kind string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		capture, _ := loadAuthTraceCapture()
+		if capture == nil {
+			next(w, r)
+			return
+		}
+		if updated, _ := audit.EnsureRequestID(r, w); updated != nil {
+			r = updated
+		}
+		requestID := audit.RequestID(r.Context())
+		if len(requestID) > maxAuthTraceRequestIDLength {
+			next(w, r)
+			return
+		}
+		start := clockNow()
+		recorder := &authTraceRecorder{start: start, trace: authTrace{
+			RequestID: requestID,
+			Kind:      kind,
+			Provider:  r.PathValue("provider"),
+			StartedAt: start,
+		}}
+		status := 0
+		sw := &sloResponseWriter{ResponseWriter: w, record: func(code int) { status = code }}
+		next(sw, r.WithContext(context.WithValue(r.Context(), authTraceContextKey{}, recorder)))
+		sw.observe(http.StatusOK)
+		capture.save(context.WithoutCancel(r.Context()), recorder, status)
+	}
+}
+
+func (c *authTraceCapture) save(ctx context.Context, recorder *authTraceRecorder, status int) {
+	recorder.mu.Lock()
+	trace := recorder.trace
+	tenantID := recorder.tenantID
+	recorder.mu.Unlock()
+	enabled := TenantConfig(withRequestScope(ctx, requestScope{TenantID: tenantID})).Auth.DebugCapture
+	if enabled == nil || !*enabled {
+		return
+	}
+	trace.Status = status
+	trace.Duration = clockNow().Sub(recorder.start).String()
+	if trace.Steps == nil {
+		trace.Steps = []authTraceStep{}
+	}
+	data, err := json.Marshal(trace)
+	if err == nil {
+		err = c.store.Set(ctx, "request:"+trace.RequestID, data, c.ttl)
+	}
+	if err == nil && trace.FlowID != "" {
+		err = c.store.Set(ctx, authTraceFlowKey(trace.FlowID, trace.Kind), []byte(trace.RequestID), c.ttl)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "gateway.auth.trace_save_failed", slog.String("request_id", trace.RequestID), slog.Any("error", err))
+	}
+}
+
+// authTraceFlowKey indexes the request that started a sign-in and the one
+// that completed it.
+func authTraceFlowKey(flowID, kind string) string {
+	role := "start"
+	if kind == authTraceKindCallback {
+		role = authTraceKindCallback
+	}
+	return "flow:" + flowID + ":" + role
+}
+
+// lookup returns the trace stored for requestID together with the other
+// requests of its sign-in, oldest first. It returns nil when no trace is
+// stored for requestID.
+func (c *authTraceCapture) lookup(ctx context.Context, requestID string) ([]authTrace, error) {
+	first, err := c.get(ctx, requestID)
+	if first == nil || err != nil {
+		return nil, err
+	}
+	traces := []authTrace{*first}
+	if first.FlowID != "" {
+		for _, kind := range []string{authTraceKindAuthorize, authTraceKindCallback} {
+			related, err := c.store.Get(ctx, authTraceFlowKey(first.FlowID, kind))
+			if errors.Is(err, storage.ErrNotFound) || string(related) == requestID {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			trace, err := c.get(ctx, string(related))
+			if err != nil {
+				return nil, err
+			}
+			if trace != nil {
+				traces = append(traces, *trace)
+			}
+		}
+	}
+	slices.SortStableFunc(traces, func(a, b authTrace) int { return a.StartedAt.Compare(b.StartedAt) })
+	return traces, nil
+}
+
+func (c *authTraceCapture) get(ctx context.Context, requestID string) (*authTrace, error) {
+	data, err := c.store.Get(ctx, "request:"+requestID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var trace authTrace
+	if err := json.Unmarshal(data, &trace); err != nil {
+		return nil, err
+	}
+	return &trace, nil
+}
+
+// authTraceResponse is returned by GET /admin/auth/traces/{request_id}.
+type authTraceResponse struct {
+	RequestID string      `json:"request_id"`
+	Traces    []authTrace `json:"traces"`
+}
+
+// registerAdminAuthTraceRoutes serves captured sign-in traces by request ID.
+// Reads are audited like the debug endpoints, since traces describe a user's
+// sign-in.
+func registerAdminAuthTraceRoutes(mux *http.ServeMux, token string) {
+	handle(mux, "GET /admin/auth/traces/{request_id}", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capture, _ := loadAuthTraceCapture()
+		if capture == nil {
+			writeErrorResponse(w, r, http.StatusNotFound, "not_found", "auth debug capture is disabled", nil)
+			return
+		}
+		recordAdminDebugAccess(r.Context(), r, "auth_trace")
+		requestID := r.PathValue("request_id")
+		traces, err := capture.lookup(r.Context(), requestID)
+		if err != nil {
+			writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "auth traces are unavailable", nil)
+			return
+		}
+		if traces == nil {
+			writeErrorResponse(w, r, http.StatusNotFound, "not_found", "no auth trace for request", nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(authTraceResponse{RequestID: requestID, Traces: traces})
+	})), policyAdminToken)
+}
@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func useAuthTraceCapture(t *testing.T) {
+	t.Helper()
+	t.Setenv("GATEWAY_AUTH_DEBUG_CAPTURE", "true")
+	resetStateStorage()
+	resetAuthTraceCapture()
+	t.Cleanup(func() {
+		resetAuthTraceCapture()
+		resetStateStorage()
+	})
+}
+
+// signInForTrace runs an authorize and callback round trip for tenant with
+// the given request IDs and returns the callback response.
+func signInForTrace(t *testing.T, mux *http.ServeMux, tenant, authorizeID, callbackID string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?redirect_uri=https://app.example.com/complete&tenant_id="+tenant, nil)
+	req.TLS = &tls.ConnectionState{}
+	req.Header.Set("X-Request-Id", authorizeID)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("authorize: expected 302, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	state := location.Query().Get("state")
+
+	req = httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?code=secret-code&state="+url.QueryEscape(state), nil)
+	req.TLS = &tls.ConnectionState{}
+	req.Header.Set("X-Request-Id", callbackID)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthTraceCapturesOptedInTenants(t *testing.T) {
+	t.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+	useAuthTraceCapture(t)
+	useTenantConfig(t, `{"tenants": {"acme": {"auth": {"debug_capture": true}}}}`)
+	captureAuditLogs(t)
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("{}")),
+				Header:     make(http.Header),
+			}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, AuthRouteConfig{})
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+	if rec := signInForTrace(t, mux, "acme", "authorize-1", "callback-1"); rec.Code != http.StatusFound {
+		t.Fatalf("callback: expected 302, got %d: %s", rec.Code, rec.Body.String())
+	}
+	signInForTrace(t, mux, "globex", "authorize-2", "callback-2")
+
+	fetch := func(requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/admin/auth/traces/"+requestID, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := fetch("callback-1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the trace, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp authTraceResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Traces) != 2 || resp.Traces[0].RequestID != "authorize-1" || resp.Traces[1].RequestID != "callback-1" {
+		t.Fatalf("expected the authorize and callback traces, got %+v", resp.Traces)
+	}
+	if resp.Traces[0].FlowID == "" || resp.Traces[0].FlowID != resp.Traces[1].FlowID || resp.Traces[1].Status != http.StatusFound {
+		t.Fatalf("expected both requests in one flow, got %+v", resp.Traces)
+	}
+	var steps []string
+	for _, step := range resp.Traces[1].Steps {
+		steps = append(steps, step.Step)
+	}
+	for _, want := range []string{"state_verified", "registration_selected", "upstream_response", "auth.oauth.callback"} {
+		if !strings.Contains(strings.Join(steps, ","), want) {
+			t.Fatalf("expected a %s step, got %v", want, steps)
+		}
+	}
+	if strings.Contains(rec.Body.String(), "secret-code") || strings.Contains(rec.Body.String(), "verifier") {
+		t.Fatalf("expected secrets to be redacted, got %s", rec.Body.String())
+	}
+
+	if rec := fetch("authorize-1"); !strings.Contains(rec.Body.String(), `"callback-1"`) {
+		t.Fatalf("expected the authorize request to find its callback, got %s", rec.Body.String())
+	}
+	if rec := fetch("callback-2"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected tenants that did not opt in to be skipped, got %d", rec.Code)
+	}
+}
+
+func TestRedactAuthTraceDetails(t *testing.T) {
+	details := redactAuthTraceDetails(map[string]any{
+		"state":          "abc",
+		"id_token":       "eyJ",
+		"client_secret":  "s",
+		"tenant_id_hash": "h",
+		"reason":         strings.Repeat("x", 300),
+	})
+	for _, key := range []string{"state", "id_token", "client_secret"} {
+		if details[key] != authTraceRedacted {
+			t.Fatalf("expected %s to be redacted, got %v", key, details[key])
+		}
+	}
+	if details["tenant_id_hash"] != "h" || len(details["reason"].(string)) != maxAuthTraceValueLength {
+		t.Fatalf("unexpected details %v", details)
+	}
+}
+
+func TestAuthTraceConfig(t *testing.T) {
+	resetAuthTraceCapture()
+	t.Cleanup(resetAuthTraceCapture)
+	if capture, err := loadAuthTraceCapture(); capture != nil || err != nil {
+		t.Fatalf("expected capture to be off by default, got %v %v", capture, err)
+	}
+	t.Setenv("GATEWAY_AUTH_DEBUG_CAPTURE_TTL", "30d")
+	if err := ValidateAuthTraceConfig(); err == nil {
+		t.Fatal("expected an invalid TTL to be rejected")
+	}
+}
@@ -9,6 +9,12 @@ const (
 	auditEventAuthorize   = "auth.oauth.authorize"
 	auditEventCallback    = "auth.oauth.callback"
 	auditEventRedirectErr = "auth.oauth.redirect"
+	auditEventJWKS        = "auth.oauth.jwks"
+	auditEventLink        = "auth.oauth.link"
+	auditEventStepUp      = "auth.oauth.stepup"
+	auditEventNegotiate   = "auth.negotiate"
+	auditEventLDAP        = "auth.ldap.login"
+	auditEventLogout      = "auth.logout"
 	auditTargetAuth       = "auth.oauth"
 	auditCapabilityAuth   = "auth.public"
 
@@ -20,6 +26,8 @@ const (
 	defaultAuthIdentityLimit  = 10
 	defaultAuthIPWindow       = time.Minute
 	defaultAuthIdentityWindow = time.Minute
+	defaultAuthJWKSLimit      = 60
+	defaultAuthJWKSWindow     = time.Minute
 
 	tenantValidationErrorMessage = "tenant_id may only include letters, numbers, '.', '_' or '-'"
 	defaultClientApp             = "gui"
@@ -37,6 +45,7 @@ type authorizeRequestParams struct {
 	TenantID    string `json:"tenant_id"`
 	ClientApp   string `validate:"omitempty,max=64" json:"client_app"`
 	BindingID   string `validate:"omitempty,max=256" json:"session_binding"`
+	LandingPath string `validate:"omitempty,max=2048" json:"landing_path"`
 }
 
 type callbackRequestParams struct {
@@ -45,13 +54,22 @@ type callbackRequestParams struct {
 }
 
 type oidcDiscovery struct {
+	issuer                string
 	authorizationEndpoint string
+	tokenEndpoint         string
+	jwksURI               string
 }
 
-var oidcDiscoveryCache struct {
+type oidcDiscoveryEntry struct {
 	metadata oidcDiscovery
 	expires  time.Time
-	mu       sync.RWMutex
+}
+
+// oidcDiscoveryCache holds discovery documents per issuer, since the oidc
+// provider and the okta/auth0 presets each discover their own issuer.
+var oidcDiscoveryCache struct {
+	entries map[string]oidcDiscoveryEntry
+	mu      sync.RWMutex
 }
 
 type redirectOrigin struct {
@@ -78,6 +96,18 @@ type stateData struct {
 	ClientApp    string
 	BindingID    string
 	ClientID     string
+	Nonce        string
+	// Flow and SessionID are set when the authorization was started for an
+	// existing session (account linking or step-up) rather than a sign-in.
+	Flow      string
+	SessionID string
+	// ACRValues and MaxAge record the authentication context requested from
+	// the provider so the callback can enforce max_age against auth_time.
+	ACRValues string
+	MaxAge    *int
+	// LandingPath is where the client should take the user once it handles
+	// the redirect, returned to it as landing_path.
+	LandingPath string
 }
 
 type oidcClientRegistration struct {
@@ -86,8 +116,28 @@ type oidcClientRegistration struct {
 	ClientID               string
 	RedirectOrigins        []redirectOrigin
 	SessionBindingRequired bool
+	// PostLogoutRedirectURIs are the exact URLs GET /auth/logout may send the
+	// user to; the first is the default.
+	PostLogoutRedirectURIs []string
+	// DefaultLandingPath is the landing_path of sign-ins that do not pass one.
+	DefaultLandingPath string
+}
+
+type oauthScopePolicy struct {
+	TenantID      string
+	AppID         string
+	Provider      string
+	AllowedScopes map[string]struct{}
+	DeniedScopes  map[string]struct{}
 }
 
+var (
+	oauthScopePoliciesMu   sync.Mutex
+	oauthScopePoliciesOnce sync.Once
+	oauthScopePolicies     []oauthScopePolicy
+	oauthScopePoliciesErr  error
+)
+
 var (
 	oidcClientRegistrationsMu   sync.Mutex
 	oidcClientRegistrationsOnce sync.Once
@@ -115,3 +165,9 @@ type rateLimitBucket struct {
 	Window       time.Duration
 	Limit        int
 }
+
+// key returns the limiter key for identity. A single concatenation keeps the
+// hot path to one allocation.
+func (b rateLimitBucket) key(identity string) string {
+	return b.Endpoint + "|" + b.IdentityType + "|" + identity
+}
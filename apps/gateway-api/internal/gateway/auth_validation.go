@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
 	"github.com/go-playground/validator/v10"
@@ -21,26 +22,38 @@ var requestValidator = validator.New(validator.WithRequiredStructEnabled())
 var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
 var clientAppPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
 var sessionBindingPattern = regexp.MustCompile(fmt.Sprintf(`^[A-Za-z0-9._-]{1,%d}$`, maxSessionBindingLength))
+var bindingHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
 var allowedRedirectOrigins = loadAllowedRedirectOrigins()
 
+// authDetailsPool holds the detail maps of auth audit events, which are
+// emitted several times per sign-in.
+var authDetailsPool = sync.Pool{New: func() any { return make(map[string]any, 16) }}
+
 func emitAuthEvent(ctx context.Context, r *http.Request, trusted []*net.IPNet, eventName, outcome string, details map[string]any) {
 	actor := hashedActorFromRequest(r, trusted)
 	ctx = audit.WithActor(ctx, actor)
-	sanitised := auditDetails(details)
+	scope := requestScopeFrom(ctx)
+	// The audit logger writes the details before returning, so the map goes
+	// back to the pool once the event is out.
+	sanitised := authDetailsPool.Get().(map[string]any)
+	defer func() {
+		clear(sanitised)
+		authDetailsPool.Put(sanitised)
+	}()
+	sanitised = scope.annotate(audit.SanitizeDetailsInto(sanitised, details))
 	if actor != "" {
-		if sanitised == nil {
-			sanitised = map[string]any{}
-		}
 		sanitised["actor_id"] = actor
 	}
 	event := audit.Event{
-		Name:       eventName,
-		Outcome:    outcome,
-		Target:     auditTargetAuth,
-		Capability: auditCapabilityAuth,
-		ActorID:    actor,
-		Details:    sanitised,
+		Name:        eventName,
+		Outcome:     outcome,
+		Target:      auditTargetAuth,
+		Capability:  auditCapabilityAuth,
+		ActorID:     actor,
+		Correlation: scope.correlation(gatewayAuditLogger),
+		Details:     sanitised,
 	}
+	traceAuthStep(ctx, eventName, outcome, sanitised)
 
 	switch outcome {
 	case auditOutcomeSuccess:
@@ -50,6 +63,13 @@ func emitAuthEvent(ctx context.Context, r *http.Request, trusted []*net.IPNet, e
 	default:
 		gatewayAuditLogger.Error(ctx, event)
 	}
+
+	// Events carrying an "action" annotate an outcome that is reported
+	// separately, so they are not metered again.
+	if _, annotation := details["action"]; !annotation {
+		tenantHash, _ := sanitised["tenant_id_hash"].(string)
+		recordUsage(tenantHash, usageMetricAuthEvents, 1)
+	}
 }
 
 func auditAuthorizeEvent(ctx context.Context, r *http.Request, trusted []*net.IPNet, outcome string, details map[string]any) {
@@ -60,6 +80,22 @@ func auditCallbackEvent(ctx context.Context, r *http.Request, trusted []*net.IPN
 	emitAuthEvent(ctx, r, trusted, auditEventCallback, outcome, details)
 }
 
+func auditLinkEvent(ctx context.Context, r *http.Request, trusted []*net.IPNet, outcome string, details map[string]any) {
+	emitAuthEvent(ctx, r, trusted, auditEventLink, outcome, details)
+}
+
+func auditStepUpEvent(ctx context.Context, r *http.Request, trusted []*net.IPNet, outcome string, details map[string]any) {
+	emitAuthEvent(ctx, r, trusted, auditEventStepUp, outcome, details)
+}
+
+func auditNegotiateEvent(ctx context.Context, r *http.Request, trusted []*net.IPNet, outcome string, details map[string]any) {
+	emitAuthEvent(ctx, r, trusted, auditEventNegotiate, outcome, details)
+}
+
+func auditLDAPEvent(ctx context.Context, r *http.Request, trusted []*net.IPNet, outcome string, details map[string]any) {
+	emitAuthEvent(ctx, r, trusted, auditEventLDAP, outcome, details)
+}
+
 func auditRedirectEvent(ctx context.Context, r *http.Request, trusted []*net.IPNet, outcome string, details map[string]any) {
 	emitAuthEvent(ctx, r, trusted, auditEventRedirectErr, outcome, details)
 }
@@ -108,6 +144,22 @@ func hashTenantID(value string) string {
 	return gatewayAuditLogger.HashIdentity("tenant", value)
 }
 
+// hashSessionBinding derives the binding_id_hash that correlates a login with
+// the collaboration and event streams of the session it created. The raw
+// binding never appears in audit records or leaves the auth flow.
+func hashSessionBinding(value string) string {
+	if value == "" {
+		return ""
+	}
+	return gatewayAuditLogger.HashIdentity("binding", value)
+}
+
+// validBindingHash reports whether value looks like a hash produced by
+// hashSessionBinding, as echoed back by the orchestrator.
+func validBindingHash(value string) bool {
+	return bindingHashPattern.MatchString(value)
+}
+
 func normalizeTenantKey(value string) string {
 	if value == "" {
 		return ""
@@ -126,6 +178,17 @@ func withTenantHash(details map[string]any, tenantHash string) map[string]any {
 	return details
 }
 
+func withBindingHash(details map[string]any, bindingHash string) map[string]any {
+	if bindingHash == "" {
+		return details
+	}
+	if details == nil {
+		details = map[string]any{}
+	}
+	details["binding_id_hash"] = bindingHash
+	return details
+}
+
 func normalizeClientApp(value string) (string, error) {
 	trimmed := strings.TrimSpace(value)
 	if trimmed == "" {
@@ -340,6 +403,12 @@ func validateClientRedirectURL(u *url.URL) error {
 	return errors.New("redirect_uri must match an allowed origin")
 }
 
+// ResetAllowedRedirectOrigins re-reads OAUTH_ALLOWED_REDIRECT_ORIGINS, which
+// is otherwise loaded once at package initialisation.
+func ResetAllowedRedirectOrigins() {
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+}
+
 func originAllowed(u *url.URL) bool {
 	for _, allowed := range allowedRedirectOrigins {
 		if allowed.matches(u) {
@@ -367,7 +436,7 @@ func loadAllowedRedirectOrigins() []redirectOrigin {
 	}
 
 	if len(origins) == 0 {
-		if origin, ok := parseRedirectOrigin(strings.TrimSpace(GetEnv("OAUTH_REDIRECT_BASE", "http://127.0.0.1:8080"))); ok {
+		if origin, ok := parseRedirectOrigin(oauthRedirectBase()); ok {
 			key := originKey(origin)
 			if _, exists := seen[key]; !exists {
 				origins = append(origins, origin)
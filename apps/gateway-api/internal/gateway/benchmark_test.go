@@ -0,0 +1,298 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const benchmarkPlanID = "plan-deadbeef"
+
+// silenceAuditLogs routes audit and slog output to io.Discard for the duration
+// of a benchmark so log formatting does not dominate the measurements.
+func silenceAuditLogs(b *testing.B) {
+	b.Helper()
+	original := slog.Default()
+	originalAudit := gatewayAuditLogger
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	gatewayAuditLogger = audit.Default()
+	resume := audit.SuspendValidation()
+	b.Cleanup(func() {
+		resume()
+		slog.SetDefault(original)
+		gatewayAuditLogger = originalAudit
+	})
+}
+
+func setupBenchmarkAuth(b *testing.B) {
+	b.Helper()
+	silenceAuditLogs(b)
+	b.Setenv("OPENROUTER_CLIENT_ID", "client-id")
+	b.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	b.Setenv("GATEWAY_COOKIE_HASH_KEY", "12345678901234567890123456789012")
+	b.Setenv("GATEWAY_COOKIE_BLOCK_KEY", "1234567890123456")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	ResetCookieHandler()
+	resetOidcClientRegistrations()
+	b.Cleanup(func() {
+		ResetCookieHandler()
+		resetOidcClientRegistrations()
+		allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	})
+}
+
+func BenchmarkAuthorizeHandler(b *testing.B) {
+	setupBenchmarkAuth(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?redirect_uri=https://app.example.com/complete", nil)
+		req.TLS = &tls.ConnectionState{}
+		rec := httptest.NewRecorder()
+		authorizeHandler(rec, withProviderPathValue(req), nil, false, nil)
+		if rec.Code != http.StatusFound {
+			b.Fatalf("unexpected status %d %s", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func BenchmarkCallbackHandler(b *testing.B) {
+	setupBenchmarkAuth(b)
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader("{}")),
+			}, nil
+		})}, nil
+	})
+	b.Cleanup(ResetOrchestratorClient)
+
+	// States are single-use, so each iteration needs its own.
+	stateCookies := make([]*http.Cookie, b.N)
+	for i := range stateCookies {
+		authReq := httptest.NewRequest(http.MethodGet, "/auth/openrouter/authorize?redirect_uri=https://app.example.com/complete", nil)
+		authReq.TLS = &tls.ConnectionState{}
+		authRec := httptest.NewRecorder()
+		authorizeHandler(authRec, withProviderPathValue(authReq), nil, false, nil)
+		for _, cookie := range authRec.Result().Cookies() {
+			if strings.HasPrefix(cookie.Name, stateCookiePrefix) {
+				stateCookies[i] = cookie
+			}
+		}
+		if stateCookies[i] == nil {
+			b.Fatal("expected state cookie from authorize")
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		state := strings.TrimPrefix(stateCookies[i].Name, stateCookiePrefix)
+		req := httptest.NewRequest(http.MethodGet, "/auth/openrouter/callback?code=abc&state="+state, nil)
+		req.TLS = &tls.ConnectionState{}
+		req.AddCookie(stateCookies[i])
+		rec := httptest.NewRecorder()
+		callbackHandler(rec, withProviderPathValue(req), nil, false, nil)
+		if rec.Code != http.StatusFound {
+			b.Fatalf("unexpected status %d %s", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// opaqueReader hides io.WriterTo so io.Copy behaves as it does for real
+// upstream response bodies.
+type opaqueReader struct {
+	r io.Reader
+}
+
+func (o *opaqueReader) Read(p []byte) (int, error) { return o.r.Read(p) }
+
+func (o *opaqueReader) Close() error { return nil }
+
+// discardStreamWriter is a flushable ResponseWriter that drops the body so the
+// benchmark measures proxy overhead rather than recorder buffering.
+type discardStreamWriter struct {
+	header  http.Header
+	status  int
+	flushes int
+}
+
+func newDiscardStreamWriter() *discardStreamWriter {
+	return &discardStreamWriter{header: make(http.Header)}
+}
+
+func (d *discardStreamWriter) Header() http.Header { return d.header }
+
+func (d *discardStreamWriter) Write(p []byte) (int, error) {
+	if d.status == 0 {
+		d.status = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (d *discardStreamWriter) WriteHeader(status int) {
+	if d.status == 0 {
+		d.status = status
+	}
+}
+
+func (d *discardStreamWriter) Flush() { d.flushes++ }
+
+var benchmarkSSEPayload = strings.Repeat("id: 1\nevent: plan.step\ndata: {\"step\":1,\"status\":\"running\"}\n\n", 64)
+
+func newBenchmarkEventsHandler() *EventsHandler {
+	client := &http.Client{Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body:       &opaqueReader{r: strings.NewReader(benchmarkSSEPayload)},
+		}, nil
+	})}
+	return NewEventsHandler(client, "http://orchestrator.internal", time.Minute, nil, nil)
+}
+
+func BenchmarkEventsHandlerStream(b *testing.B) {
+	silenceAuditLogs(b)
+	handler := newBenchmarkEventsHandler()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkSSEPayload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/events?plan_id="+benchmarkPlanID, nil)
+		w := newDiscardStreamWriter()
+		handler.ServeHTTP(w, req)
+		if w.status != http.StatusOK {
+			b.Fatalf("unexpected status %d", w.status)
+		}
+	}
+}
+
+// BenchmarkEventsHandlerConcurrentStreams drives roughly 1k simultaneous
+// streams through a single handler.
+func BenchmarkEventsHandlerConcurrentStreams(b *testing.B) {
+	silenceAuditLogs(b)
+	handler := newBenchmarkEventsHandler()
+	const streams = 1000
+	parallelism := (streams + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkSSEPayload)))
+	b.SetParallelism(parallelism)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req := httptest.NewRequest(http.MethodGet, "/events?plan_id="+benchmarkPlanID, nil)
+			w := newDiscardStreamWriter()
+			handler.ServeHTTP(w, req)
+			if w.status != http.StatusOK {
+				b.Errorf("unexpected status %d", w.status)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkRateLimiterAllow(b *testing.B) {
+	limiter := newRateLimiter("test")
+	bucket := rateLimitBucket{Endpoint: "http_global", IdentityType: "ip", Window: time.Minute, Limit: 1 << 30}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := limiter.Allow(ctx, bucket, "203.0.113.10"); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+// BenchmarkRateLimiterAllowContended compares a single lock with the default
+// shard count while many goroutines rate limit distinct client IPs.
+func BenchmarkRateLimiterAllowContended(b *testing.B) {
+	for _, shards := range []int{1, defaultLimiterShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			b.Setenv("GATEWAY_LIMITER_SHARDS", strconv.Itoa(shards))
+			limiter := newRateLimiter("test")
+			bucket := rateLimitBucket{Endpoint: "http_global", IdentityType: "ip", Window: time.Minute, Limit: 1 << 30}
+			ctx := context.Background()
+			var next atomic.Int64
+
+			b.ReportAllocs()
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				ip := fmt.Sprintf("203.0.113.%d", next.Add(1)%256)
+				for pb.Next() {
+					if _, _, err := limiter.Allow(ctx, bucket, ip); err != nil {
+						b.Errorf("unexpected error: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkConnectionLimiterContended acquires and releases connection slots
+// from many goroutines, as a burst of SSE connects does.
+func BenchmarkConnectionLimiterContended(b *testing.B) {
+	for _, shards := range []int{1, defaultLimiterShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			b.Setenv("GATEWAY_LIMITER_SHARDS", strconv.Itoa(shards))
+			limiter := newConnectionLimiter(1 << 20)
+			var next atomic.Int64
+
+			b.ReportAllocs()
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				ip := fmt.Sprintf("198.51.100.%d", next.Add(1)%256)
+				for pb.Next() {
+					if !limiter.Acquire(ip) {
+						b.Error("unexpected rejection")
+						return
+					}
+					limiter.Release(ip)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkWriteErrorResponse(b *testing.B) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		writeErrorResponse(rec, req, http.StatusBadRequest, "invalid_request", "invalid request", nil)
+	}
+}
+
+func BenchmarkHashIdentity(b *testing.B) {
+	logger := audit.Default()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = logger.HashIdentity("tenant", "acme")
+	}
+}
@@ -0,0 +1,259 @@
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultClockSkewTolerance      = time.Minute
+	maxClockSkewTolerance          = 10 * time.Minute
+	defaultClockDriftCheckInterval = 15 * time.Minute
+	clockDriftQueryTimeout         = 3 * time.Second
+
+	ntpDefaultPort = "123"
+	ntpPacketSize  = 48
+	// ntpEpochOffset is the number of seconds between the NTP epoch (1900)
+	// and the Unix epoch.
+	ntpEpochOffset = 2208988800
+)
+
+// gatewayClock replaces the wall clock behind the gateway's time-sensitive
+// components: rate limiters, state expiry and token validation. Unset means
+// time.Now.
+var gatewayClock atomic.Pointer[func() time.Time]
+
+// clockNow returns the current time from the gateway clock.
+func clockNow() time.Time {
+	if now := gatewayClock.Load(); now != nil {
+		return (*now)()
+	}
+	return time.Now()
+}
+
+// setClock makes now the gateway clock, for tests, and returns a function
+// that restores the previous one. Components read the clock when they are
+// created, so set it before building them.
+func setClock(now func() time.Time) (restore func()) {
+	previous := gatewayClock.Swap(&now)
+	return func() { gatewayClock.Store(previous) }
+}
+
+func loadClockSkewTolerance() (time.Duration, error) {
+	raw := strings.TrimSpace(GetEnv("GATEWAY_CLOCK_SKEW_TOLERANCE", ""))
+	if raw == "" {
+		return defaultClockSkewTolerance, nil
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil || parsed < 0 || parsed > maxClockSkewTolerance {
+		return defaultClockSkewTolerance, fmt.Errorf("GATEWAY_CLOCK_SKEW_TOLERANCE must be a duration between 0 and %s", maxClockSkewTolerance)
+	}
+	return parsed, nil
+}
+
+// clockSkewTolerance returns GATEWAY_CLOCK_SKEW_TOLERANCE, the clock
+// difference accepted when checking issue and expiry times set by another
+// clock: token issuers, and other replicas for state they wrote.
+func clockSkewTolerance() time.Duration {
+	tolerance, _ := loadClockSkewTolerance()
+	return tolerance
+}
+
+// clockDriftConfig selects the NTP servers the local clock is compared with.
+type clockDriftConfig struct {
+	servers  []string
+	interval time.Duration
+	// maxDrift is half the skew tolerance, so two replicas drifting apart
+	// in opposite directions still agree within it.
+	maxDrift time.Duration
+}
+
+func loadClockDriftConfig() (clockDriftConfig, error) {
+	tolerance, err := loadClockSkewTolerance()
+	if err != nil {
+		return clockDriftConfig{}, err
+	}
+	cfg := clockDriftConfig{
+		interval: ResolveDuration([]string{"GATEWAY_CLOCK_DRIFT_CHECK_INTERVAL"}, defaultClockDriftCheckInterval),
+		maxDrift: tolerance / 2,
+	}
+	for _, server := range strings.Split(GetEnv("GATEWAY_CLOCK_NTP_SERVERS", ""), ",") {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), ntpDefaultPort)
+		}
+		host, port, err := net.SplitHostPort(server)
+		if err != nil || host == "" || port == "" {
+			return clockDriftConfig{}, fmt.Errorf("GATEWAY_CLOCK_NTP_SERVERS entry %q must be host or host:port", server)
+		}
+		cfg.servers = append(cfg.servers, server)
+	}
+	return cfg, nil
+}
+
+// ValidateClockConfig checks GATEWAY_CLOCK_SKEW_TOLERANCE and
+// GATEWAY_CLOCK_NTP_SERVERS at startup.
+func ValidateClockConfig() error {
+	_, err := loadClockDriftConfig()
+	return err
+}
+
+// clockDriftResult is the outcome of the last drift check.
+type clockDriftResult struct {
+	server   string
+	offset   time.Duration
+	maxDrift time.Duration
+	err      error
+}
+
+func (r clockDriftResult) exceeded() bool {
+	return r.err == nil && r.offset.Abs() > r.maxDrift
+}
+
+var clockDrift struct {
+	mu   sync.Mutex
+	last *clockDriftResult
+}
+
+// CheckClockDrift compares the local clock with GATEWAY_CLOCK_NTP_SERVERS. It
+// runs once at startup and then as the clock_drift_check maintenance job, and
+// returns an error when the clock is off by more than half of
+// GATEWAY_CLOCK_SKEW_TOLERANCE; until a later check passes, /readyz reports
+// the gateway degraded. Unreachable servers are only logged, so an NTP outage
+// does not keep the gateway from starting.
+func CheckClockDrift(ctx context.Context) error {
+	cfg, err := loadClockDriftConfig()
+	if err != nil || len(cfg.servers) == 0 {
+		return err
+	}
+	result := measureClockDrift(ctx, cfg)
+	if result.exceeded() {
+		return fmt.Errorf("local clock is %s off %s, more than the %s allowed", result.offset.Round(time.Millisecond), result.server, cfg.maxDrift)
+	}
+	return nil
+}
+
+// measureClockDrift asks each server in turn until one answers, records the
+// result for /readyz and logs a clock that has drifted too far or servers
+// that could not be reached.
+func measureClockDrift(ctx context.Context, cfg clockDriftConfig) clockDriftResult {
+	result := clockDriftResult{maxDrift: cfg.maxDrift}
+	var errs []error
+	for _, server := range cfg.servers {
+		offset, err := queryNTPOffset(ctx, server)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", server, err))
+			continue
+		}
+		result.server, result.offset = server, offset
+		break
+	}
+	if result.server == "" {
+		result.err = errors.Join(errs...)
+		slog.WarnContext(ctx, "gateway.clock.drift_check_failed", slog.Any("error", result.err))
+	} else if result.exceeded() {
+		slog.ErrorContext(ctx, "gateway.clock.drift_exceeded",
+			slog.String("server", result.server),
+			slog.Duration("offset", result.offset),
+			slog.Duration("max_drift", cfg.maxDrift))
+	}
+	clockDrift.mu.Lock()
+	clockDrift.last = &result
+	clockDrift.mu.Unlock()
+	return result
+}
+
+// clockDriftHealth reports the last drift check for /readyz. A drifted clock
+// degrades readiness rather than failing it: requests are still served, but
+// state and token checks near the tolerance may fail.
+func clockDriftHealth() (dependencyResult, bool) {
+	clockDrift.mu.Lock()
+	last := clockDrift.last
+	clockDrift.mu.Unlock()
+	if last == nil {
+		return dependencyResult{}, false
+	}
+	result := dependencyResult{Status: healthStatusPass, Optional: last.err != nil}
+	switch {
+	case last.err != nil:
+		result.Status = healthStatusFail
+		result.Error = ptr(last.err.Error())
+	case last.exceeded():
+		result.Status = healthStatusDegraded
+		result.Error = ptr(fmt.Sprintf("clock is %s off %s, more than %s", last.offset.Round(time.Millisecond), last.server, last.maxDrift))
+	default:
+		result.Details = []string{fmt.Sprintf("offset %s from %s", last.offset.Round(time.Millisecond), last.server)}
+	}
+	return result, true
+}
+
+// queryNTPOffset sends one SNTP (RFC 4330) client request to server and
+// returns how far the gateway clock is behind it; a negative offset means the
+// gateway clock is ahead.
+func queryNTPOffset(ctx context.Context, server string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, clockDriftQueryTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	request := make([]byte, ntpPacketSize)
+	request[0] = 0x23 // no leap warning, version 4, client mode
+	sent := clockNow()
+	// The transmit timestamp comes back as the originate timestamp, which
+	// ties the answer to this request.
+	binary.BigEndian.PutUint64(request[40:], toNTPTime(sent))
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	response := make([]byte, ntpPacketSize)
+	n, err := conn.Read(response)
+	received := clockNow()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case n < ntpPacketSize:
+		return 0, errors.New("short NTP response")
+	case response[0]&0x07 != 4:
+		return 0, errors.New("NTP response is not in server mode")
+	case response[0]>>6 == 3:
+		return 0, errors.New("NTP server is not synchronized")
+	case response[1] == 0 || response[1] > 15:
+		return 0, fmt.Errorf("NTP server reports stratum %d", response[1])
+	case binary.BigEndian.Uint64(response[24:]) != binary.BigEndian.Uint64(request[40:]):
+		return 0, errors.New("NTP response does not match the request")
+	}
+	serverReceived := fromNTPTime(binary.BigEndian.Uint64(response[32:]))
+	serverSent := fromNTPTime(binary.BigEndian.Uint64(response[40:]))
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+func fromNTPTime(v uint64) time.Time {
+	seconds := int64(v>>32) - ntpEpochOffset
+	nanos := (v & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(seconds, int64(nanos))
+}
@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startNTPServer answers SNTP requests with a clock offset from the gateway
+// clock and returns its address.
+func startNTPServer(t *testing.T, offset time.Duration) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, ntpPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < ntpPacketSize {
+				continue
+			}
+			now := toNTPTime(clockNow().Add(offset))
+			response := make([]byte, ntpPacketSize)
+			response[0] = 0x24 // version 4, server mode
+			response[1] = 2
+			copy(response[24:32], buf[40:48])
+			binary.BigEndian.PutUint64(response[32:], now)
+			binary.BigEndian.PutUint64(response[40:], now)
+			_, _ = conn.WriteTo(response, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func resetClockDrift(t *testing.T) {
+	t.Helper()
+	clear := func() {
+		clockDrift.mu.Lock()
+		clockDrift.last = nil
+		clockDrift.mu.Unlock()
+	}
+	clear()
+	t.Cleanup(clear)
+}
+
+func TestCheckClockDrift(t *testing.T) {
+	resetClockDrift(t)
+	t.Setenv("GATEWAY_CLOCK_NTP_SERVERS", startNTPServer(t, 10*time.Second))
+	if err := CheckClockDrift(context.Background()); err != nil {
+		t.Fatalf("expected 10s to be within half the default tolerance, got %v", err)
+	}
+	result, ok := clockDriftHealth()
+	if !ok || result.Status != healthStatusPass || len(result.Details) != 1 || !strings.HasPrefix(result.Details[0], "offset 10s") {
+		t.Fatalf("unexpected health result %+v", result)
+	}
+
+	// The gateway clock running 45s behind the server is past the 30s limit.
+	restore := setClock(func() time.Time { return time.Now().Add(-45 * time.Second) })
+	defer restore()
+	t.Setenv("GATEWAY_CLOCK_NTP_SERVERS", startNTPServer(t, 45*time.Second))
+	if err := CheckClockDrift(context.Background()); err == nil || !strings.Contains(err.Error(), "more than the 30s allowed") {
+		t.Fatalf("expected the drift to be reported, got %v", err)
+	}
+	if result, _ := clockDriftHealth(); result.Status != healthStatusDegraded {
+		t.Fatalf("expected readiness to be degraded, got %+v", result)
+	}
+
+	t.Setenv("GATEWAY_CLOCK_SKEW_TOLERANCE", "2m")
+	if err := CheckClockDrift(context.Background()); err != nil {
+		t.Fatalf("expected a larger tolerance to accept the drift, got %v", err)
+	}
+}
+
+func TestCheckClockDriftToleratesUnreachableServers(t *testing.T) {
+	resetClockDrift(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	silent := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	t.Setenv("GATEWAY_CLOCK_NTP_SERVERS", silent)
+	if err := CheckClockDrift(context.Background()); err != nil {
+		t.Fatalf("expected an unreachable server not to fail the check, got %v", err)
+	}
+	if result, _ := clockDriftHealth(); result.Status != healthStatusFail || !result.Optional {
+		t.Fatalf("expected an optional failure, got %+v", result)
+	}
+
+	// The next server is asked when the first does not answer.
+	t.Setenv("GATEWAY_CLOCK_NTP_SERVERS", silent+","+startNTPServer(t, 0))
+	if err := CheckClockDrift(context.Background()); err != nil {
+		t.Fatalf("expected the second server to answer, got %v", err)
+	}
+	if result, _ := clockDriftHealth(); result.Status != healthStatusPass {
+		t.Fatalf("expected the check to pass, got %+v", result)
+	}
+}
+
+func TestNTPTimeRoundTrip(t *testing.T) {
+	at := time.Date(2026, 10, 16, 12, 30, 0, 250_000_000, time.UTC)
+	if got := fromNTPTime(toNTPTime(at)); got.Sub(at).Abs() > time.Microsecond {
+		t.Fatalf("expected %s, got %s", at, got)
+	}
+}
+
+func TestClockConfig(t *testing.T) {
+	for key, value := range map[string]string{
+		"GATEWAY_CLOCK_SKEW_TOLERANCE": "1h",
+		"GATEWAY_CLOCK_NTP_SERVERS":    "pool.ntp.org:",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if err := ValidateClockConfig(); err == nil {
+				t.Fatalf("expected %s=%s to be rejected", key, value)
+			}
+		})
+	}
+	t.Setenv("GATEWAY_CLOCK_NTP_SERVERS", "time.example.com, [2001:db8::123]")
+	cfg, err := loadClockDriftConfig()
+	if err != nil || len(cfg.servers) != 2 || cfg.servers[0] != "time.example.com:123" || cfg.servers[1] != "[2001:db8::123]:123" {
+		t.Fatalf("unexpected servers %v: %v", cfg.servers, err)
+	}
+}
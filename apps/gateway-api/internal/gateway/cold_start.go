@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+// Policies accepted in GATEWAY_COLD_START_POLICY.
+const (
+	coldStartOpen   = "open"
+	coldStartStrict = "strict"
+)
+
+// processStarted is when this gateway process started; security state
+// recorded before it only survives when it was persisted.
+var processStarted = time.Now()
+
+// coldStartPolicy decides what the gateway does shortly after a restart with
+// security state it cannot see: OAuth states issued by the previous process
+// while consumed states were kept in memory, and lockouts it cannot read
+// back from GATEWAY_STORAGE_URL. Under the open policy the gateway proceeds
+// as if nothing had been recorded; under the strict policy it refuses for
+// the grace period, so a forced restart does not reset an attacker's budget.
+type coldStartPolicy struct {
+	strict bool
+	until  time.Time
+}
+
+func parseColdStartPolicy() (coldStartPolicy, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("GATEWAY_COLD_START_POLICY")))
+	grace := ResolveDuration([]string{"GATEWAY_COLD_START_GRACE"}, stateTTL)
+	switch mode {
+	case "", coldStartOpen:
+		return coldStartPolicy{until: processStarted.Add(grace)}, nil
+	case coldStartStrict:
+		return coldStartPolicy{strict: true, until: processStarted.Add(grace)}, nil
+	default:
+		return coldStartPolicy{}, fmt.Errorf("unsupported GATEWAY_COLD_START_POLICY %q", mode)
+	}
+}
+
+// currentColdStartPolicy returns the configured policy. Startup validation
+// rejects invalid values, so a failure here falls back to open.
+func currentColdStartPolicy() coldStartPolicy {
+	policy, _ := parseColdStartPolicy()
+	return policy
+}
+
+// ValidateColdStartPolicy checks GATEWAY_COLD_START_POLICY at startup.
+func ValidateColdStartPolicy() error {
+	_, err := parseColdStartPolicy()
+	return err
+}
+
+// failClosed reports whether missing security state must be treated as a
+// refusal at now.
+func (p coldStartPolicy) failClosed(now time.Time) bool {
+	return p.strict && now.Before(p.until)
+}
+
+// storageDurable reports whether store keeps its contents across restarts.
+func storageDurable(store storage.Store) bool {
+	_, inMemory := store.(*storage.MemoryStore)
+	return !inMemory
+}
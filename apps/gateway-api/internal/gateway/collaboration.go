@@ -2,10 +2,8 @@ package gateway
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
 	"net/http"
@@ -27,12 +25,11 @@ const (
 )
 
 var (
-	collaborationIDPattern                      = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
-	collaborationFilePathLimit                  = 4096
-	collaborationTracer                         = otel.Tracer("gateway.collaboration")
-	collaborationSessionMaxBodyBytes      int64 = 1 << 20
-	defaultCollaborationAuthFailureLimit        = 8
-	defaultCollaborationAuthFailureWindow       = time.Minute
+	collaborationIDPattern                = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+	collaborationFilePathLimit            = 4096
+	collaborationTracer                   = otel.Tracer("gateway.collaboration")
+	defaultCollaborationAuthFailureLimit  = 8
+	defaultCollaborationAuthFailureWindow = time.Minute
 )
 
 // CollaborationRouteConfig captures configuration for the collaboration proxy wiring.
@@ -42,13 +39,19 @@ type CollaborationRouteConfig struct {
 
 // RegisterCollaborationRoutes wires the collaboration WebSocket proxy into the gateway mux.
 func RegisterCollaborationRoutes(mux *http.ServeMux, cfg CollaborationRouteConfig) {
-	orchestratorURL := GetEnv("ORCHESTRATOR_URL", "http://127.0.0.1:4000")
-	target, err := url.Parse(orchestratorURL)
-	if err != nil {
+	if _, err := url.Parse(GetEnv("ORCHESTRATOR_URL", defaultOrchestratorURL)); err != nil {
 		panic(fmt.Sprintf("invalid orchestrator url: %v", err))
 	}
-	proxy := newCollaborationProxy(target)
-	validator := newCollaborationSessionValidator(orchestratorURL)
+	proxy := newCollaborationProxy()
+	validator := newCollaborationSessionValidator()
+	authorizer, err := loadCollaborationAuthorizer()
+	if err != nil {
+		panic(fmt.Sprintf("invalid collaboration authorization configuration: %v", err))
+	}
+	readOnly, err := loadCollaborationReadOnly()
+	if err != nil {
+		panic(fmt.Sprintf("invalid collaboration read-only configuration: %v", err))
+	}
 
 	trustedProxies, err := ParseTrustedProxyCIDRs(cfg.TrustedProxyCIDRs)
 	if err != nil {
@@ -58,7 +61,7 @@ func RegisterCollaborationRoutes(mux *http.ServeMux, cfg CollaborationRouteConfi
 	maxConnections := GetIntEnv("GATEWAY_COLLAB_MAX_CONNECTIONS_PER_IP", 12)
 	limiter := newConnectionLimiter(maxConnections)
 
-	authFailureLimiter := newRateLimiter()
+	authFailureLimiter := newPersistentRateLimiter("collaboration.auth_failure")
 	authFailureBucket := rateLimitBucket{
 		Endpoint:     "collaboration.auth_failure",
 		IdentityType: "ip",
@@ -66,80 +69,70 @@ func RegisterCollaborationRoutes(mux *http.ServeMux, cfg CollaborationRouteConfi
 		Window:       ResolveDuration([]string{"GATEWAY_COLLAB_AUTH_FAILURE_WINDOW"}, defaultCollaborationAuthFailureWindow),
 	}
 
-	mux.Handle("/collaboration/ws", collaborationConnectionLimiter(trustedProxies, limiter, collaborationAuthMiddleware(validator, authFailureLimiter, authFailureBucket, trustedProxies, proxy)))
-}
+	lifetime := loadStreamLifetime("GATEWAY_COLLAB_MAX_STREAM_AGE", "GATEWAY_COLLAB_MAX_STREAM_AGE_JITTER")
+	presence := newCollaborationPresence()
+	presence.subscribe(gatewayEvents)
+	handshake := loadCollaborationHandshake()
+	revalidation := loadCollaborationRevalidation()
+	protocols, err := loadCollaborationProtocols()
+	if err != nil {
+		panic(fmt.Sprintf("invalid collaboration protocol configuration: %v", err))
+	}
+	affinity, err := loadCollaborationAffinity(trustedProxies)
+	if err != nil {
+		panic(fmt.Sprintf("invalid collaboration affinity configuration: %v", err))
+	}
+	upstream := collaborationHeaderMiddleware(collaborationProtocolMiddleware(protocols, collaborationUsageMiddleware(websocketLifetimeMiddleware(lifetime, collaborationRevalidationMiddleware(revalidation, collaborationHandshakeMiddleware(handshake, collaborationPresenceMiddleware(presence, collaborationAffinityMiddleware(affinity, proxy))))))))
 
-type collaborationSession struct {
-	ID       string  `json:"id"`
-	TenantID *string `json:"tenantId"`
+	handle(mux, "GET /collaboration/ws", collaborationConnectionLimiter(trustedProxies, limiter, collaborationAuthMiddleware(validator, authorizer, authFailureLimiter, authFailureBucket, trustedProxies, collaborationReadOnlyMiddleware(readOnly, upstream))),
+		policyStreaming, policySession, policyConnectionLimit, policyLockout, policyReadOnly, policyForwardHeaders(headerGroupCollaboration))
+	handle(mux, "GET /collaboration/presence", collaborationPresenceHandler(validator, authorizer, authFailureLimiter, authFailureBucket, trustedProxies, presence), policySession, policyLockout)
 }
 
-func newCollaborationSessionValidator(orchestratorURL string) func(context.Context, string, string, string) (collaborationSession, int, error) {
-	return func(ctx context.Context, authHeader, cookieHeader, requestID string) (collaborationSession, int, error) {
-		client, err := getOrchestratorClient()
-		if err != nil {
-			return collaborationSession{}, http.StatusBadGateway, err
-		}
-
-		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/auth/session", strings.TrimRight(orchestratorURL, "/")), nil)
-		if err != nil {
-			return collaborationSession{}, http.StatusInternalServerError, err
-		}
-		req.Header.Set("Accept", "application/json")
-		if authHeader != "" {
-			req.Header.Set("Authorization", authHeader)
-		}
-		if cookieHeader != "" {
-			req.Header.Set("Cookie", cookieHeader)
-		}
-		if requestID != "" {
-			req.Header.Set("X-Request-Id", requestID)
-			req.Header.Set("X-Trace-Id", requestID)
-		}
-
-		resp, err := client.Do(req)
-		if err != nil {
-			return collaborationSession{}, http.StatusBadGateway, err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode == http.StatusUnauthorized {
-			return collaborationSession{}, http.StatusUnauthorized, nil
-		}
-		if resp.StatusCode != http.StatusOK {
-			return collaborationSession{}, http.StatusBadGateway, fmt.Errorf("unexpected status %d", resp.StatusCode)
-		}
-
-		var payload struct {
-			Session collaborationSession `json:"session"`
-		}
-		limitedBody := io.LimitReader(resp.Body, collaborationSessionMaxBodyBytes)
-		if err := json.NewDecoder(limitedBody).Decode(&payload); err != nil {
-			return collaborationSession{}, http.StatusBadGateway, err
-		}
-		if payload.Session.ID == "" {
-			return collaborationSession{}, http.StatusUnauthorized, errors.New("missing session id")
-		}
-		return payload.Session, http.StatusOK, nil
+// newCollaborationSessionValidator asks the orchestrator about every connect
+// unless GATEWAY_COLLAB_SESSION_CACHE=cached, in which case accepted sessions
+// are reused for GATEWAY_COLLAB_SESSION_CACHE_TTL.
+func newCollaborationSessionValidator() collaborationSessionValidator {
+	cache, err := loadCollaborationSessionCache()
+	if err != nil {
+		collabLog.Warn("collaboration session cache disabled", slog.Any("error", err))
+		return lookupOrchestratorSession
+	}
+	if cache == nil {
+		return lookupOrchestratorSession
 	}
+	return cache.validate
 }
 
-func newCollaborationProxy(target *url.URL) *httputil.ReverseProxy {
-	proxy := httputil.NewSingleHostReverseProxy(target)
-	transportClient, err := getOrchestratorClient()
-	if err == nil && transportClient != nil {
-		proxy.Transport = transportClient.Transport
-	}
+func newCollaborationProxy() *httputil.ReverseProxy {
+	// Rewrite and Director are mutually exclusive, so build the proxy directly
+	// rather than starting from NewSingleHostReverseProxy. The target and
+	// transport are resolved per request so rebuilt clients take effect.
+	proxy := &httputil.ReverseProxy{Transport: upstreamTransport{}}
 
 	proxy.Rewrite = func(pr *httputil.ProxyRequest) {
+		// Only the upgrade headers the proxy set and the allowlisted client
+		// headers, already checked by collaborationHeaderMiddleware, go
+		// upstream.
+		out := make(http.Header)
+		for _, name := range []string{"Connection", "Upgrade"} {
+			if values := pr.Out.Header.Values(name); len(values) > 0 {
+				out[name] = values
+			}
+		}
+		_ = forwardedHeaders(headerGroupCollaboration).forward(out, pr.In.Header)
+		requestScopeFrom(pr.In.Context()).setUpstreamHeaders(out)
+		pr.Out.Header = out
 		pr.SetXForwarded()
+		base := collaborationUpstream(pr.In.Context())
+		target, err := url.Parse(base)
+		if err != nil {
+			target = &url.URL{}
+		}
 		originalQuery := pr.In.URL.RawQuery
 		pr.Out.URL.Scheme = target.Scheme
 		pr.Out.URL.Host = target.Host
-		pr.Out.URL.Path = "/collaboration/ws"
+		pr.Out.URL.Path = orchestratorAPI.path(pr.In.Context(), strings.TrimRight(base, "/"), orchestratorCollaborationWebSocket)
 		pr.Out.URL.RawPath = ""
 		pr.Out.URL.RawQuery = originalQuery
 		pr.Out.Host = target.Host
@@ -147,21 +140,48 @@ func newCollaborationProxy(target *url.URL) *httputil.ReverseProxy {
 			pr.Out.Header.Set("X-Request-Id", requestID)
 			pr.Out.Header.Set("X-Trace-Id", requestID)
 		}
-		if original := pr.In.Header.Get("Sec-WebSocket-Protocol"); original != "" {
-			pr.Out.Header.Set("Sec-WebSocket-Protocol", original)
+	}
+
+	// The orchestrator may only accept what was offered to it, and a session
+	// it rejects at the handshake may still be cached from an earlier connect.
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if err := validateCollaborationUpgrade(resp); err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			return nil
+		}
+		if cache, _ := loadCollaborationSessionCache(); cache != nil {
+			in := resp.Request
+			cache.invalidate(in.Context(), strings.TrimSpace(in.Header.Get("Authorization")), strings.TrimSpace(in.Header.Get("Cookie")))
 		}
+		return nil
 	}
 
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		slog.WarnContext(r.Context(), "collaboration proxy error", slog.Any("error", err))
+		collabLog.WarnContext(r.Context(), "collaboration proxy error", slog.Any("error", err))
 		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to contact orchestrator", nil)
 	}
 
 	return proxy
 }
 
+// collaborationHeaderMiddleware rejects upgrades whose allowlisted headers
+// exceed their size cap or contain unsafe characters.
+func collaborationHeaderMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := forwardedHeaders(headerGroupCollaboration).check(r.Header); err != nil {
+			recordCollaborationAudit(r.Context(), r, auditOutcomeDenied, map[string]any{"reason": "invalid_header", "error": err.Error()})
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func collaborationAuthMiddleware(
-	validate func(context.Context, string, string, string) (collaborationSession, int, error),
+	validate collaborationSessionValidator,
+	authorize collaborationAuthorizer,
 	failureLimiter *rateLimiter,
 	failureBucket rateLimitBucket,
 	trustedProxies []*net.IPNet,
@@ -193,7 +213,7 @@ func collaborationAuthMiddleware(
 		}
 
 		if authHeader != "" {
-			if len(authHeader) > maxAuthorizationHeaderLen || hasUnsafeHeaderRunes(authHeader) || !strings.HasPrefix(strings.ToLower(authHeader), "bearer ") || strings.TrimSpace(authHeader[7:]) == "" {
+			if !validCollaborationBearer(authHeader) {
 				if handleCollaborationAuthFailure(r.Context(), w, r, failureLimiter, failureBucket, trustedProxies, "invalid_authorization_header", http.StatusBadRequest, "invalid_request", "authorization header invalid", nil, nil) {
 					return
 				}
@@ -214,7 +234,11 @@ func collaborationAuthMiddleware(
 
 		session, status, err := validate(ctx, authHeader, cookieHeader, audit.RequestID(ctx))
 		if err != nil {
-			recordCollaborationAudit(ctx, r, auditOutcomeFailure, map[string]any{"reason": "session_validation_failed", "error": err.Error()})
+			reason := "session_validation_failed"
+			if errors.Is(err, errUpstreamResponseTooLarge) {
+				reason = "upstream_response_too_large"
+			}
+			recordCollaborationAudit(ctx, r, auditOutcomeFailure, map[string]any{"reason": reason, "error": err.Error()})
 			writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to validate session", nil)
 			return
 		}
@@ -223,6 +247,8 @@ func collaborationAuthMiddleware(
 				return
 			}
 		}
+		ctx = withRequestScope(ctx, requestScope{BindingIDHash: session.BindingIDHash})
+		ctx = withSessionExpiry(ctx, session)
 		if sessionID != "" && session.ID != "" && session.ID != sessionID {
 			if handleCollaborationAuthFailure(ctx, w, r, failureLimiter, failureBucket, trustedProxies, "session_mismatch", http.StatusForbidden, "forbidden", "session mismatch", nil, nil) {
 				return
@@ -247,19 +273,36 @@ func collaborationAuthMiddleware(
 			}
 		}
 
-		if sessionID != "" {
-			r.Header.Set("X-Session-Id", sessionID)
-		}
-		if tenantID != "" {
-			r.Header.Set("X-Tenant-Id", tenantID)
-		}
+		ctx = withRequestScope(ctx, requestScope{TenantID: tenantID, SessionID: sessionID})
 		r.Header.Set("X-Project-Id", projectID)
 
-		recordCollaborationAudit(r.Context(), r, auditOutcomeSuccess, map[string]any{"reason": "authorized"})
+		if authorize != nil {
+			access := collaborationAccess{SessionID: sessionID, TenantID: tenantID, ProjectID: projectID, FilePath: filePath}
+			allowed, err := authorize(ctx, access, authHeader, cookieHeader, audit.RequestID(ctx))
+			if err != nil {
+				recordCollaborationAudit(ctx, r, auditOutcomeFailure, map[string]any{"reason": "authorization_failed", "error": err.Error()})
+				writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to authorize collaboration access", nil)
+				return
+			}
+			if !allowed {
+				if handleCollaborationAuthFailure(ctx, w, r, failureLimiter, failureBucket, trustedProxies, "room_forbidden", http.StatusForbidden, "forbidden", "access to this file is not permitted", nil, nil) {
+					return
+				}
+			}
+		}
+
+		recordCollaborationAudit(ctx, r, auditOutcomeSuccess, map[string]any{"reason": "authorized"})
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// validCollaborationBearer reports whether authHeader is a well-formed bearer
+// credential that is safe to forward to the orchestrator.
+func validCollaborationBearer(authHeader string) bool {
+	return len(authHeader) <= maxAuthorizationHeaderLen && !hasUnsafeHeaderRunes(authHeader) &&
+		strings.HasPrefix(strings.ToLower(authHeader), "bearer ") && strings.TrimSpace(authHeader[7:]) != ""
+}
+
 func handleCollaborationAuthFailure(
 	ctx context.Context,
 	w http.ResponseWriter,
@@ -278,7 +321,7 @@ func handleCollaborationAuthFailure(
 	if limited {
 		recordCollaborationAudit(ctx, r, auditOutcomeDenied, map[string]any{
 			"reason":                  "auth_rate_limited",
-			"client_ip_hash":          gatewayAuditLogger.HashIdentity(identity),
+			"client_ip_hash":          hashScopedIdentity(ctx, identity),
 			"retry_after_seconds":     retryAfterToSeconds(retryAfter),
 			"original_failure_reason": reason,
 		})
@@ -336,29 +379,42 @@ func parseCollaborationIdentity(r *http.Request) (string, string, string, string
 }
 
 func recordCollaborationAudit(ctx context.Context, r *http.Request, outcome string, details map[string]any) {
+	recordCollaborationEvent(ctx, r, auditEventCollaborationConnect, outcome, details)
+}
+
+// recordCollaborationEvent audits name against the connection r, hashing the
+// identifiers the auth middleware resolved.
+func recordCollaborationEvent(ctx context.Context, r *http.Request, name, outcome string, details map[string]any) {
 	actor := hashedActorFromRequest(r, nil)
 	ctx = audit.WithActor(ctx, actor)
 	if details == nil {
 		details = map[string]any{}
 	}
 	details["path"] = r.URL.Path
-	if tenant := strings.TrimSpace(r.Header.Get("X-Tenant-Id")); tenant != "" {
-		details["tenant_id_hash"] = gatewayAuditLogger.HashIdentity("tenant", tenant)
+	// Once the auth middleware has resolved the scope it names the tenant and
+	// session; earlier events fall back to what the client claimed. Project
+	// and session IDs are hashed with the tenant's salt when one is
+	// configured; the tenant hash itself stays comparable across tenants.
+	scope := requestScopeFrom(ctx)
+	if scope.TenantID == "" {
+		scope.TenantID = strings.TrimSpace(r.Header.Get("X-Tenant-Id"))
 	}
-	if project := strings.TrimSpace(r.Header.Get("X-Project-Id")); project != "" {
-		details["project_id_hash"] = gatewayAuditLogger.HashIdentity("project", project)
+	if scope.SessionID == "" {
+		scope.SessionID = strings.TrimSpace(r.Header.Get("X-Session-Id"))
 	}
-	if session := strings.TrimSpace(r.Header.Get("X-Session-Id")); session != "" {
-		details["session_id_hash"] = gatewayAuditLogger.HashIdentity("session", session)
+	if project := strings.TrimSpace(r.Header.Get("X-Project-Id")); project != "" {
+		details["project_id_hash"] = gatewayAuditLogger.HashTenantIdentity(scope.TenantID, "project", project)
 	}
+	details = scope.annotate(details)
 
 	event := audit.Event{
-		Name:       auditEventCollaborationConnect,
-		Outcome:    outcome,
-		Target:     auditTargetCollaboration,
-		Capability: auditCapabilityCollaboration,
-		ActorID:    actor,
-		Details:    auditDetails(details),
+		Name:        name,
+		Outcome:     outcome,
+		Target:      auditTargetCollaboration,
+		Capability:  auditCapabilityCollaboration,
+		ActorID:     actor,
+		Correlation: scope.correlation(gatewayAuditLogger),
+		Details:     auditDetails(details),
 	}
 
 	switch outcome {
@@ -369,15 +425,29 @@ func recordCollaborationAudit(ctx context.Context, r *http.Request, outcome stri
 	}
 
 	if reqID := audit.RequestID(ctx); reqID != "" {
-		slog.DebugContext(ctx, "collaboration.websocket.audit", slog.String("request_id", reqID), slog.Any("details", event.Details))
+		collabLog.DebugContext(ctx, "collaboration.websocket.audit", slog.String("request_id", reqID), slog.Any("details", event.Details))
 	}
 }
 
+// collaborationUsageMiddleware meters authenticated connections and their
+// duration against the tenant the auth middleware resolved.
+func collaborationUsageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantHash := usageTenantHash(r.Context(), r)
+		recordUsage(tenantHash, usageMetricProxiedRequests, 1)
+		start := time.Now()
+		defer func() {
+			recordUsage(tenantHash, usageMetricCollaborationSeconds, int64(time.Since(start)/time.Second))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
 func collaborationConnectionLimiter(trusted []*net.IPNet, limiter *connectionLimiter, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ip := ClientIP(r, trusted)
 		if !limiter.Acquire(ip) {
-			recordCollaborationAudit(r.Context(), r, auditOutcomeDenied, map[string]any{"reason": "ip_rate_limited", "ip": gatewayAuditLogger.HashIdentity(ip)})
+			recordCollaborationAudit(r.Context(), r, auditOutcomeDenied, map[string]any{"reason": "ip_rate_limited", "ip": hashScopedIdentity(r.Context(), ip)})
 			writeErrorResponse(w, r, http.StatusTooManyRequests, "rate_limited", "too many connections", map[string]any{"retry_after": 60})
 			return
 		}
@@ -389,19 +459,21 @@ func collaborationConnectionLimiter(trusted []*net.IPNet, limiter *connectionLim
 			})
 		}
 
+		conn := streamConns.connect(streamKindCollaboration)
 		done := make(chan struct{})
 		ctx := r.Context()
-		go func() {
+		conn.goroutine(func() {
 			select {
 			case <-ctx.Done():
 				release()
 			case <-done:
 			}
-		}()
+		})
 
 		defer func() {
 			close(done)
 			release()
+			conn.close()
 		}()
 		next.ServeHTTP(w, r)
 	})
@@ -419,7 +491,7 @@ func registerCollaborationAuthFailure(ctx context.Context, r *http.Request, limi
 
 	allowed, retryAfter, err := limiter.Allow(ctx, bucket, identity)
 	if err != nil {
-		slog.WarnContext(ctx, "gateway.collaboration.auth_rate_limit_error", slog.String("error", err.Error()))
+		collabLog.WarnContext(ctx, "gateway.collaboration.auth_rate_limit_error", slog.String("error", err.Error()))
 		return false, 0, identity
 	}
 	if !allowed {
@@ -0,0 +1,160 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// GATEWAY_COLLAB_AFFINITY values.
+const (
+	collaborationAffinityOff      = "off"
+	collaborationAffinityCookie   = "cookie"
+	collaborationAffinityDocument = "document"
+
+	collaborationAffinityCookieName = "gateway_collab_affinity"
+	defaultCollaborationAffinityTTL = 24 * time.Hour
+	collaborationAffinityIDBytes    = 16
+)
+
+var collaborationAffinityIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{22}$`)
+
+// collaborationAffinity keeps reconnecting clients on one orchestrator
+// instance, which holds the in-memory state of their collaboration session.
+// The gateway hashes an affinity key onto GATEWAY_COLLAB_UPSTREAMS with
+// rendezvous hashing, so every replica picks the same instance for a key and
+// removing an instance only moves the keys it held.
+type collaborationAffinity struct {
+	mode      string
+	upstreams []string
+	ttl       time.Duration
+	trusted   []*net.IPNet
+}
+
+type collaborationUpstreamContextKey struct{}
+
+// loadCollaborationAffinity reads GATEWAY_COLLAB_AFFINITY,
+// GATEWAY_COLLAB_UPSTREAMS and GATEWAY_COLLAB_AFFINITY_COOKIE_TTL. It returns
+// nil when affinity is off.
+func loadCollaborationAffinity(trustedProxies []*net.IPNet) (*collaborationAffinity, error) {
+	var upstreams []string
+	for _, raw := range strings.Split(os.Getenv("GATEWAY_COLLAB_UPSTREAMS"), ",") {
+		raw = strings.TrimRight(strings.TrimSpace(raw), "/")
+		if raw == "" {
+			continue
+		}
+		parsed, err := url.Parse(raw)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.Path != "" || parsed.RawQuery != "" || parsed.User != nil {
+			return nil, fmt.Errorf("GATEWAY_COLLAB_UPSTREAMS entry %q must be an http(s) origin", raw)
+		}
+		upstreams = append(upstreams, parsed.Scheme+"://"+parsed.Host)
+	}
+
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("GATEWAY_COLLAB_AFFINITY")))
+	switch mode {
+	case "":
+		mode = collaborationAffinityOff
+		if len(upstreams) > 0 {
+			mode = collaborationAffinityCookie
+		}
+	case collaborationAffinityOff, collaborationAffinityCookie, collaborationAffinityDocument:
+	default:
+		return nil, fmt.Errorf("unsupported GATEWAY_COLLAB_AFFINITY %q (expected %s, %s or %s)", mode, collaborationAffinityOff, collaborationAffinityCookie, collaborationAffinityDocument)
+	}
+	if mode == collaborationAffinityOff {
+		if len(upstreams) > 0 {
+			return nil, fmt.Errorf("GATEWAY_COLLAB_UPSTREAMS requires GATEWAY_COLLAB_AFFINITY %s or %s", collaborationAffinityCookie, collaborationAffinityDocument)
+		}
+		return nil, nil
+	}
+
+	ttl := GetDurationEnv("GATEWAY_COLLAB_AFFINITY_COOKIE_TTL", defaultCollaborationAffinityTTL)
+	if ttl <= 0 {
+		return nil, fmt.Errorf("GATEWAY_COLLAB_AFFINITY_COOKIE_TTL must be positive")
+	}
+	return &collaborationAffinity{mode: mode, upstreams: upstreams, ttl: ttl, trusted: trustedProxies}, nil
+}
+
+// ValidateCollaborationAffinityConfig checks the collaboration affinity
+// settings at startup.
+func ValidateCollaborationAffinityConfig() error {
+	_, err := loadCollaborationAffinity(nil)
+	return err
+}
+
+// collaborationAffinityMiddleware picks the orchestrator instance for a
+// connect. In cookie mode the key is the affinity cookie, issued on the first
+// connect and left unchanged afterwards so a load balancer can pin the client
+// to a gateway replica with it too. The cookie is an opaque random ID rather
+// than a signed value: choosing it only lets a client choose among equally
+// valid instances. In document mode the key is the tenant, project and file,
+// so all collaborators on a document share an instance.
+func collaborationAffinityMiddleware(affinity *collaborationAffinity, next http.Handler) http.Handler {
+	if affinity == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var key string
+		switch affinity.mode {
+		case collaborationAffinityCookie:
+			if cookie, err := r.Cookie(collaborationAffinityCookieName); err == nil && collaborationAffinityIDPattern.MatchString(cookie.Value) {
+				key = cookie.Value
+			} else if id, err := randomString(collaborationAffinityIDBytes); err == nil {
+				key = id
+				http.SetCookie(w, affinity.cookie(r, id))
+			}
+		case collaborationAffinityDocument:
+			key = strings.Join([]string{requestScopeFrom(r.Context()).TenantID, r.Header.Get("X-Project-Id"), r.URL.Query().Get("filePath")}, "\x00")
+		}
+		if upstream := affinity.pick(key); upstream != "" {
+			r = r.WithContext(context.WithValue(r.Context(), collaborationUpstreamContextKey{}, upstream))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *collaborationAffinity) cookie(r *http.Request, id string) *http.Cookie {
+	return &http.Cookie{
+		Name:     collaborationAffinityCookieName,
+		Value:    id,
+		Path:     "/collaboration/",
+		MaxAge:   int(a.ttl.Seconds()),
+		Expires:  time.Now().Add(a.ttl),
+		HttpOnly: true,
+		Secure:   IsRequestSecure(r, a.trusted) || strings.HasPrefix(PublicBaseURL(r.Context()), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// pick returns the upstream with the highest rendezvous score for key, or ""
+// without GATEWAY_COLLAB_UPSTREAMS.
+func (a *collaborationAffinity) pick(key string) string {
+	var (
+		best      string
+		bestScore uint64
+	)
+	for _, upstream := range a.upstreams {
+		sum := sha256.Sum256([]byte(upstream + "\x00" + key))
+		if score := binary.BigEndian.Uint64(sum[:8]); best == "" || score > bestScore {
+			best, bestScore = upstream, score
+		}
+	}
+	return best
+}
+
+// collaborationUpstream returns the orchestrator origin chosen for the
+// connect, or ORCHESTRATOR_URL.
+func collaborationUpstream(ctx context.Context) string {
+	if upstream, ok := ctx.Value(collaborationUpstreamContextKey{}).(string); ok {
+		return upstream
+	}
+	return orchestratorBaseURL()
+}
@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+)
+
+func TestCollaborationAffinityCookieKeepsUpstream(t *testing.T) {
+	t.Setenv("GATEWAY_COLLAB_AFFINITY", "")
+	t.Setenv("GATEWAY_COLLAB_UPSTREAMS", "http://orchestrator-0:4000, http://orchestrator-1:4000/,http://orchestrator-2:4000")
+	affinity, err := loadCollaborationAffinity(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if affinity.mode != collaborationAffinityCookie {
+		t.Fatalf("expected upstreams to default to cookie affinity, got %q", affinity.mode)
+	}
+	proxy := newCollaborationProxy()
+	var upstream string
+	handler := collaborationAffinityMiddleware(affinity, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out := r.Clone(r.Context())
+		proxy.Rewrite(&httputil.ProxyRequest{In: r, Out: out})
+		upstream = out.URL.Scheme + "://" + out.URL.Host
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/collaboration/ws?filePath=a.txt", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != collaborationAffinityCookieName || cookies[0].Path != "/collaboration/" || !cookies[0].HttpOnly {
+		t.Fatalf("expected an affinity cookie, got %+v", cookies)
+	}
+	first := upstream
+	if first != affinity.pick(cookies[0].Value) {
+		t.Fatalf("expected the cookie to select the upstream, got %s", first)
+	}
+
+	for range 5 {
+		req := httptest.NewRequest(http.MethodGet, "/collaboration/ws?filePath=b.txt", nil)
+		req.AddCookie(cookies[0])
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if upstream != first {
+			t.Fatalf("expected reconnects to reach %s, got %s", first, upstream)
+		}
+		if len(rec.Result().Cookies()) != 0 {
+			t.Fatal("expected an existing cookie to be kept unchanged")
+		}
+	}
+}
+
+func TestCollaborationAffinityDocumentMode(t *testing.T) {
+	t.Setenv("GATEWAY_COLLAB_AFFINITY", "document")
+	t.Setenv("GATEWAY_COLLAB_UPSTREAMS", "http://orchestrator-0:4000,http://orchestrator-1:4000")
+	affinity, err := loadCollaborationAffinity(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var picked []string
+	handler := collaborationAffinityMiddleware(affinity, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		picked = append(picked, collaborationUpstream(r.Context()))
+	}))
+	for _, session := range []string{"alice", "bob"} {
+		req := httptest.NewRequest(http.MethodGet, "/collaboration/ws?filePath=docs/plan.md", nil)
+		req.Header.Set("X-Project-Id", "roadmap")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(withRequestScope(req.Context(), requestScope{TenantID: "acme", SessionID: session})))
+		if len(rec.Result().Cookies()) != 0 {
+			t.Fatal("expected no cookie in document mode")
+		}
+	}
+	if len(picked) != 2 || picked[0] != picked[1] {
+		t.Fatalf("expected collaborators on one document to share an upstream, got %v", picked)
+	}
+}
+
+func TestCollaborationAffinityPickIsConsistent(t *testing.T) {
+	all := &collaborationAffinity{upstreams: []string{"http://a:4000", "http://b:4000", "http://c:4000"}}
+	fewer := &collaborationAffinity{upstreams: []string{"http://a:4000", "http://c:4000"}}
+	seen := make(map[string]int)
+	for i := range 300 {
+		key := fmt.Sprintf("key-%d", i)
+		before := all.pick(key)
+		seen[before]++
+		if before != "http://b:4000" && fewer.pick(key) != before {
+			t.Fatalf("expected %s to stay on %s when another upstream is removed", key, before)
+		}
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected keys to spread over every upstream, got %v", seen)
+	}
+}
+
+func TestLoadCollaborationAffinityRejectsInvalidConfig(t *testing.T) {
+	cases := map[string][2]string{
+		"unknown mode":       {"sticky", ""},
+		"upstreams when off": {"off", "http://orchestrator-0:4000"},
+		"upstream path":      {"cookie", "http://orchestrator-0:4000/api"},
+		"upstream scheme":    {"cookie", "ws://orchestrator-0:4000"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("GATEWAY_COLLAB_AFFINITY", tc[0])
+			t.Setenv("GATEWAY_COLLAB_UPSTREAMS", tc[1])
+			if err := ValidateCollaborationAffinityConfig(); err == nil {
+				t.Fatal("expected configuration error")
+			}
+		})
+	}
+
+	t.Setenv("GATEWAY_COLLAB_AFFINITY", "")
+	t.Setenv("GATEWAY_COLLAB_UPSTREAMS", "")
+	if affinity, err := loadCollaborationAffinity(nil); err != nil || affinity != nil {
+		t.Fatalf("expected affinity to be off by default, got %+v, %v", affinity, err)
+	}
+}
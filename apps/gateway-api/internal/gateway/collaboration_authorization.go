@@ -0,0 +1,267 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+// Modes accepted in GATEWAY_COLLAB_AUTHZ.
+const (
+	collaborationAuthzNone         = "none"
+	collaborationAuthzOrchestrator = "orchestrator"
+	collaborationAuthzPolicy       = "policy"
+)
+
+const (
+	defaultCollaborationAuthzPath     = "/collaboration/authorize"
+	defaultCollaborationAuthzCacheTTL = 30 * time.Second
+	collaborationAuthzCacheNamespace  = "collab_authz"
+)
+
+// collaborationAccess is the room a WebSocket connect asks to join.
+type collaborationAccess struct {
+	SessionID string `json:"sessionId"`
+	TenantID  string `json:"tenantId"`
+	ProjectID string `json:"projectId"`
+	FilePath  string `json:"filePath"`
+}
+
+// collaborationAuthorizer decides whether the caller may edit the room. The
+// credentials are those the session was validated with.
+type collaborationAuthorizer func(ctx context.Context, access collaborationAccess, authHeader, cookieHeader, requestID string) (bool, error)
+
+// orchestratorRoomAuthorizer asks the orchestrator's permission endpoint and
+// remembers grants per session for a short TTL. Denials are never cached so a
+// newly granted permission applies on the next connect.
+type orchestratorRoomAuthorizer struct {
+	// path replaces the orchestrator API's authorize path when set.
+	path  string
+	cache storage.Store
+	ttl   time.Duration
+}
+
+func (a *orchestratorRoomAuthorizer) authorize(ctx context.Context, access collaborationAccess, authHeader, cookieHeader, requestID string) (bool, error) {
+	key := collaborationGrantKey(access)
+	if a.cache != nil {
+		if _, err := a.cache.Get(ctx, key); err == nil {
+			return true, nil
+		}
+	}
+
+	client, orchestratorURL, err := currentOrchestrator()
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	body, _ := json.Marshal(access)
+	target := orchestratorAPI.URL(ctx, orchestratorURL, orchestratorCollaborationAuthorize)
+	if a.path != "" {
+		target = strings.TrimRight(orchestratorURL, "/") + a.path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	if cookieHeader != "" {
+		req.Header.Set("Cookie", cookieHeader)
+	}
+	if requestID != "" {
+		req.Header.Set("X-Request-Id", requestID)
+		req.Header.Set("X-Trace-Id", requestID)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		if cache, _ := loadCollaborationSessionCache(); cache != nil {
+			cache.invalidate(ctx, authHeader, cookieHeader)
+		}
+		return false, nil
+	case http.StatusForbidden, http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		Allowed bool `json:"allowed"`
+	}
+	data, err := readUpstreamBody(resp.Body, maxUpstreamResponseBytes())
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return false, err
+	}
+	if payload.Allowed && a.cache != nil {
+		_ = a.cache.Set(ctx, key, []byte("1"), a.ttl)
+	}
+	return payload.Allowed, nil
+}
+
+// collaborationGrantKey scopes a cached grant to one session and room.
+func collaborationGrantKey(access collaborationAccess) string {
+	sum := sha256.Sum256([]byte(access.TenantID + "\x00" + access.ProjectID + "\x00" + access.FilePath))
+	return access.SessionID + ":" + hex.EncodeToString(sum[:])
+}
+
+// collaborationPolicy is the local alternative to the orchestrator endpoint.
+// A connect is allowed when any rule matches; tenant and project accept "*",
+// and paths use path.Match patterns, with a trailing "/**" matching every
+// file below a directory.
+type collaborationPolicy struct {
+	Rules []collaborationPolicyRule `json:"rules"`
+}
+
+type collaborationPolicyRule struct {
+	Tenant  string   `json:"tenant"`
+	Project string   `json:"project"`
+	Paths   []string `json:"paths"`
+}
+
+func (p *collaborationPolicy) authorize(_ context.Context, access collaborationAccess, _, _, _ string) (bool, error) {
+	for _, rule := range p.Rules {
+		if !matchesPolicyID(rule.Tenant, access.TenantID) || !matchesPolicyID(rule.Project, access.ProjectID) {
+			continue
+		}
+		for _, pattern := range rule.Paths {
+			if matchesPolicyPath(pattern, access.FilePath) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func matchesPolicyID(pattern, value string) bool {
+	return pattern == "*" || pattern == value
+}
+
+func matchesPolicyPath(pattern, filePath string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return dir == "" || strings.HasPrefix(filePath, dir+"/")
+	}
+	matched, _ := path.Match(pattern, filePath)
+	return matched
+}
+
+func parseCollaborationPolicy(raw string) (*collaborationPolicy, error) {
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	var policy collaborationPolicy
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("invalid collaboration policy: %w", err)
+	}
+	for i, rule := range policy.Rules {
+		if rule.Tenant == "" || rule.Project == "" || len(rule.Paths) == 0 {
+			return nil, fmt.Errorf("collaboration policy rule %d needs a tenant, a project and at least one path", i)
+		}
+		for _, pattern := range rule.Paths {
+			if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+				return nil, fmt.Errorf("collaboration policy rule %d: invalid path pattern %q", i, pattern)
+			}
+		}
+	}
+	return &policy, nil
+}
+
+var (
+	collaborationAuthzMu   sync.Mutex
+	collaborationAuthzOnce sync.Once
+	collaborationAuthz     collaborationAuthorizer
+	collaborationAuthzErr  error
+)
+
+// resetCollaborationAuthorizer clears the cached configuration for tests.
+func resetCollaborationAuthorizer() {
+	collaborationAuthzMu.Lock()
+	defer collaborationAuthzMu.Unlock()
+	collaborationAuthzOnce = sync.Once{}
+	collaborationAuthz = nil
+	collaborationAuthzErr = nil
+}
+
+// loadCollaborationAuthorizer returns the room authorization hook selected by
+// GATEWAY_COLLAB_AUTHZ, or nil when rooms are not checked beyond the session
+// and tenant.
+func loadCollaborationAuthorizer() (collaborationAuthorizer, error) {
+	collaborationAuthzMu.Lock()
+	defer collaborationAuthzMu.Unlock()
+	collaborationAuthzOnce.Do(func() {
+		collaborationAuthz, collaborationAuthzErr = parseCollaborationAuthorizer()
+	})
+	return collaborationAuthz, collaborationAuthzErr
+}
+
+func parseCollaborationAuthorizer() (collaborationAuthorizer, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("GATEWAY_COLLAB_AUTHZ")))
+	switch mode {
+	case "", collaborationAuthzNone:
+		return nil, nil
+	case collaborationAuthzOrchestrator:
+		authzPath := GetEnv("GATEWAY_COLLAB_AUTHZ_PATH", "")
+		if authzPath != "" && !strings.HasPrefix(authzPath, "/") {
+			return nil, errors.New("GATEWAY_COLLAB_AUTHZ_PATH must start with /")
+		}
+		authorizer := &orchestratorRoomAuthorizer{
+			path: authzPath,
+			ttl:  GetDurationEnv("GATEWAY_COLLAB_AUTHZ_CACHE_TTL", defaultCollaborationAuthzCacheTTL),
+		}
+		if authorizer.ttl > 0 {
+			store, err := loadStateStorage()
+			if err != nil {
+				return nil, err
+			}
+			authorizer.cache = storage.Namespace(store, collaborationAuthzCacheNamespace)
+		}
+		return authorizer.authorize, nil
+	case collaborationAuthzPolicy:
+		raw, err := ResolveEnvValue("GATEWAY_COLLAB_AUTHZ_POLICY")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load GATEWAY_COLLAB_AUTHZ_POLICY: %w", err)
+		}
+		if strings.TrimSpace(raw) == "" {
+			return nil, errors.New("GATEWAY_COLLAB_AUTHZ=policy requires GATEWAY_COLLAB_AUTHZ_POLICY")
+		}
+		policy, err := parseCollaborationPolicy(raw)
+		if err != nil {
+			return nil, err
+		}
+		return policy.authorize, nil
+	default:
+		return nil, fmt.Errorf("unsupported GATEWAY_COLLAB_AUTHZ %q (expected %s, %s or %s)", mode, collaborationAuthzNone, collaborationAuthzOrchestrator, collaborationAuthzPolicy)
+	}
+}
+
+// ValidateCollaborationAuthorizerConfig checks the GATEWAY_COLLAB_AUTHZ*
+// settings at startup.
+func ValidateCollaborationAuthorizerConfig() error {
+	_, err := loadCollaborationAuthorizer()
+	return err
+}
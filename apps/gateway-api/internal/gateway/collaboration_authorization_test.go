@@ -0,0 +1,190 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+func TestCollaborationPolicy(t *testing.T) {
+	policy, err := parseCollaborationPolicy(`{"rules":[
+		{"tenant":"acme","project":"web","paths":["docs/**","README.md"]},
+		{"tenant":"*","project":"sandbox","paths":["*.txt"]}
+	]}`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, tc := range []struct {
+		access collaborationAccess
+		want   bool
+	}{
+		{collaborationAccess{TenantID: "acme", ProjectID: "web", FilePath: "docs/guide/intro.md"}, true},
+		{collaborationAccess{TenantID: "acme", ProjectID: "web", FilePath: "README.md"}, true},
+		{collaborationAccess{TenantID: "acme", ProjectID: "web", FilePath: "src/main.go"}, false},
+		{collaborationAccess{TenantID: "acme", ProjectID: "web", FilePath: "docs.md"}, false},
+		{collaborationAccess{TenantID: "globex", ProjectID: "web", FilePath: "README.md"}, false},
+		{collaborationAccess{TenantID: "globex", ProjectID: "sandbox", FilePath: "notes.txt"}, true},
+		{collaborationAccess{TenantID: "globex", ProjectID: "sandbox", FilePath: "dir/notes.txt"}, false},
+	} {
+		if got, _ := policy.authorize(context.Background(), tc.access, "", "", ""); got != tc.want {
+			t.Errorf("authorize(%+v) = %v, want %v", tc.access, got, tc.want)
+		}
+	}
+
+	for _, raw := range []string{
+		`{"rules":[{"tenant":"acme","paths":["*"]}]}`,
+		`{"rules":[{"tenant":"acme","project":"web","paths":["[docs"]}]}`,
+		`{"rules":[{"tenant":"acme","project":"web","paths":["*"],"allow":true}]}`,
+	} {
+		if _, err := parseCollaborationPolicy(raw); err == nil {
+			t.Errorf("expected %s to be rejected", raw)
+		}
+	}
+}
+
+type fakePermissionEndpoint struct {
+	mu       sync.Mutex
+	status   int
+	allowed  bool
+	requests []collaborationAccess
+	auth     []string
+}
+
+func (f *fakePermissionEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var access collaborationAccess
+	_ = json.NewDecoder(r.Body).Decode(&access)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, access)
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	if f.status != http.StatusOK {
+		w.WriteHeader(f.status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"allowed": f.allowed})
+}
+
+func newTestRoomAuthorizer(t *testing.T, endpoint *fakePermissionEndpoint) *orchestratorRoomAuthorizer {
+	t.Helper()
+	server := httptest.NewServer(endpoint)
+	t.Cleanup(server.Close)
+	t.Setenv("ORCHESTRATOR_URL", server.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+	return &orchestratorRoomAuthorizer{path: defaultCollaborationAuthzPath, cache: storage.NewMemoryStore(), ttl: time.Minute}
+}
+
+func TestOrchestratorRoomAuthorizerCachesGrants(t *testing.T) {
+	endpoint := &fakePermissionEndpoint{status: http.StatusOK, allowed: true}
+	authorizer := newTestRoomAuthorizer(t, endpoint)
+	access := collaborationAccess{SessionID: "session-1", TenantID: "acme", ProjectID: "web", FilePath: "docs/a.md"}
+
+	for range 2 {
+		allowed, err := authorizer.authorize(context.Background(), access, "Bearer abc", "", "req-1")
+		if err != nil || !allowed {
+			t.Fatalf("expected grant, got %v %v", allowed, err)
+		}
+	}
+	if len(endpoint.requests) != 1 || endpoint.requests[0] != access || endpoint.auth[0] != "Bearer abc" {
+		t.Fatalf("expected one permission check with the caller's credentials, got %+v %v", endpoint.requests, endpoint.auth)
+	}
+
+	other := access
+	other.SessionID = "session-2"
+	_, _ = authorizer.authorize(context.Background(), other, "Bearer def", "", "req-2")
+	if len(endpoint.requests) != 2 {
+		t.Fatalf("expected grants to be cached per session, got %d checks", len(endpoint.requests))
+	}
+}
+
+func TestOrchestratorRoomAuthorizerDenials(t *testing.T) {
+	endpoint := &fakePermissionEndpoint{status: http.StatusOK}
+	authorizer := newTestRoomAuthorizer(t, endpoint)
+	access := collaborationAccess{SessionID: "session-1", TenantID: "acme", ProjectID: "web", FilePath: "docs/a.md"}
+
+	for _, status := range []int{http.StatusOK, http.StatusForbidden, http.StatusUnauthorized} {
+		endpoint.status = status
+		if allowed, err := authorizer.authorize(context.Background(), access, "Bearer abc", "", ""); err != nil || allowed {
+			t.Fatalf("status %d: expected denial, got %v %v", status, allowed, err)
+		}
+	}
+	if len(endpoint.requests) != 3 {
+		t.Fatalf("expected denials not to be cached, got %d checks", len(endpoint.requests))
+	}
+
+	endpoint.status = http.StatusInternalServerError
+	if _, err := authorizer.authorize(context.Background(), access, "Bearer abc", "", ""); err == nil {
+		t.Fatal("expected an error for an unexpected status")
+	}
+}
+
+func TestCollaborationAuthMiddlewareChecksRoomAccess(t *testing.T) {
+	tenant := "acme"
+	validator := func(context.Context, string, string, string) (orchestratorSession, int, error) {
+		return orchestratorSession{ID: "session-1", TenantID: &tenant}, http.StatusOK, nil
+	}
+	var seen collaborationAccess
+	allowed := false
+	authorizer := func(_ context.Context, access collaborationAccess, _, _, _ string) (bool, error) {
+		seen = access
+		return allowed, nil
+	}
+	handler := collaborationAuthMiddleware(validator, authorizer, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=docs/a.md&projectId=web", nil)
+		req.Header.Set("Authorization", "Bearer abc")
+		return req
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest())
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if want := (collaborationAccess{SessionID: "session-1", TenantID: "acme", ProjectID: "web", FilePath: "docs/a.md"}); seen != want {
+		t.Fatalf("expected resolved identity %+v, got %+v", want, seen)
+	}
+
+	allowed = true
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest())
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the connect to be proxied, got %d", rec.Code)
+	}
+}
+
+func TestLoadCollaborationAuthorizer(t *testing.T) {
+	t.Cleanup(resetCollaborationAuthorizer)
+	for name, tc := range map[string]struct {
+		env     map[string]string
+		enabled bool
+		wantErr bool
+	}{
+		"default":        {env: map[string]string{}},
+		"policy":         {env: map[string]string{"GATEWAY_COLLAB_AUTHZ": "policy", "GATEWAY_COLLAB_AUTHZ_POLICY": `{"rules":[]}`}, enabled: true},
+		"missing policy": {env: map[string]string{"GATEWAY_COLLAB_AUTHZ": "policy"}, wantErr: true},
+		"orchestrator":   {env: map[string]string{"GATEWAY_COLLAB_AUTHZ": "orchestrator", "GATEWAY_COLLAB_AUTHZ_CACHE_TTL": "0s"}, enabled: true},
+		"relative path":  {env: map[string]string{"GATEWAY_COLLAB_AUTHZ": "orchestrator", "GATEWAY_COLLAB_AUTHZ_PATH": "authorize"}, wantErr: true},
+		"unknown mode":   {env: map[string]string{"GATEWAY_COLLAB_AUTHZ": "opa"}, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"GATEWAY_COLLAB_AUTHZ", "GATEWAY_COLLAB_AUTHZ_POLICY", "GATEWAY_COLLAB_AUTHZ_PATH", "GATEWAY_COLLAB_AUTHZ_CACHE_TTL"} {
+				t.Setenv(key, tc.env[key])
+			}
+			resetCollaborationAuthorizer()
+			authorizer, err := loadCollaborationAuthorizer()
+			if (err != nil) != tc.wantErr || (authorizer != nil) != tc.enabled {
+				t.Fatalf("unexpected result enabled=%v err=%v", authorizer != nil, err)
+			}
+		})
+	}
+}
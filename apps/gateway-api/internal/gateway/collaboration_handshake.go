@@ -0,0 +1,292 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Client-to-server message types of the y-websocket protocol spoken on
+// /collaboration/ws. Type 2 (auth) is only ever sent by the server.
+const (
+	yMessageSync           = 0
+	yMessageAwareness      = 1
+	yMessageQueryAwareness = 3
+
+	ySyncStep1  = 0
+	ySyncUpdate = 2
+)
+
+const (
+	defaultCollaborationHandshakeFrames   = 2
+	defaultCollaborationHandshakeMaxBytes = 1 << 20
+	collaborationHandshakeReason          = "invalid_handshake"
+	websocketCloseProtocolError           = 1002
+	websocketOpcodeContinuation           = 0x0
+	websocketOpcodeBinary                 = 0x2
+	websocketOpcodeClose                  = 0x8
+)
+
+var errCollaborationHandshakeRejected = errors.New("collaboration handshake rejected")
+
+// collaborationHandshake bounds how much of a new connection is inspected.
+// y-websocket carries no protocol version or document ID in band (the room is
+// derived from the validated filePath), so the opening frames are checked for
+// shape: masked, unfragmented binary frames that decode as y-protocols
+// messages, starting with sync step 1.
+type collaborationHandshake struct {
+	frames   int
+	maxBytes int
+}
+
+func loadCollaborationHandshake() collaborationHandshake {
+	maxBytes := GetIntEnv("GATEWAY_COLLAB_HANDSHAKE_MAX_BYTES", defaultCollaborationHandshakeMaxBytes)
+	if maxBytes <= 0 {
+		maxBytes = defaultCollaborationHandshakeMaxBytes
+	}
+	return collaborationHandshake{
+		frames:   GetIntEnv("GATEWAY_COLLAB_HANDSHAKE_FRAMES", defaultCollaborationHandshakeFrames),
+		maxBytes: maxBytes,
+	}
+}
+
+// collaborationHandshakeMiddleware validates the first client frames of
+// proxied connections before they reach the orchestrator. Compression is not
+// offered upstream so those frames can be read as sent.
+func collaborationHandshakeMiddleware(handshake collaborationHandshake, next http.Handler) http.Handler {
+	if handshake.frames <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Sec-WebSocket-Extensions")
+		next.ServeHTTP(&handshakeResponseWriter{ResponseWriter: w, handshake: handshake, request: r}, r)
+	})
+}
+
+type handshakeResponseWriter struct {
+	http.ResponseWriter
+	handshake collaborationHandshake
+	request   *http.Request
+}
+
+func (hw *handshakeResponseWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// Hijack wraps the client connection. Bytes the server buffered before the
+// hijack are moved into the wrapper so pipelined frames are validated too.
+func (hw *handshakeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(hw.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	var pending []byte
+	if n := brw.Reader.Buffered(); n > 0 {
+		buffered, _ := brw.Reader.Peek(n)
+		pending = bytes.Clone(buffered)
+		_, _ = brw.Reader.Discard(n)
+	}
+	return &handshakeConn{Conn: conn, handshake: hw.handshake, request: hw.request, buf: pending, remaining: hw.handshake.frames}, brw, nil
+}
+
+// handshakeConn holds back client bytes until each frame in them has been
+// validated, then passes the connection through untouched.
+type handshakeConn struct {
+	net.Conn
+	mu        sync.Mutex
+	handshake collaborationHandshake
+	request   *http.Request
+	buf       []byte
+	ready     []byte
+	remaining int
+	seen      int
+	err       error
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	for len(c.ready) == 0 {
+		if c.remaining == 0 {
+			if len(c.buf) == 0 {
+				return c.Conn.Read(p)
+			}
+			c.ready, c.buf = c.buf, nil
+			break
+		}
+		if !c.advance() && c.err != nil {
+			return 0, c.err
+		}
+	}
+	n := copy(p, c.ready)
+	c.ready = c.ready[n:]
+	return n, nil
+}
+
+// Write serialises proxied writes with the close frame sent on rejection.
+func (c *handshakeConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+// advance validates the next buffered frame, reading more from the client
+// when the frame is incomplete. It reports whether a frame became ready.
+func (c *handshakeConn) advance() bool {
+	opcode, payload, size, err := parseClientFrame(c.buf, c.handshake.maxBytes)
+	if err == nil && size > 0 && opcode < websocketOpcodeClose {
+		err = c.validateMessage(opcode, payload)
+	}
+	if err != nil {
+		c.reject(err)
+		return false
+	}
+	if size == 0 {
+		if c.err == nil {
+			chunk := make([]byte, 4096)
+			n, readErr := c.Conn.Read(chunk)
+			c.buf = append(c.buf, chunk[:n]...)
+			c.err = readErr
+		}
+		return false
+	}
+	if opcode == websocketOpcodeClose {
+		// The client is leaving; nothing after a close frame is data.
+		c.remaining = 0
+	}
+	c.ready = append(c.ready, c.buf[:size]...)
+	c.buf = c.buf[size:]
+	return true
+}
+
+func (c *handshakeConn) validateMessage(opcode byte, payload []byte) error {
+	if opcode != websocketOpcodeBinary {
+		return errors.New("expected a binary message")
+	}
+	messageType, syncStep, err := parseYMessage(payload)
+	if err != nil {
+		return err
+	}
+	if c.seen == 0 && (messageType != yMessageSync || syncStep != ySyncStep1) {
+		return errors.New("expected sync step 1 first")
+	}
+	c.seen++
+	c.remaining--
+	return nil
+}
+
+func (c *handshakeConn) reject(err error) {
+	recordCollaborationAudit(c.request.Context(), c.request, auditOutcomeDenied, map[string]any{"reason": collaborationHandshakeReason, "error": err.Error()})
+	c.mu.Lock()
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = c.Conn.Write(websocketCloseFrame(websocketCloseProtocolError, collaborationHandshakeReason+": "+err.Error()))
+	c.mu.Unlock()
+	_ = c.Conn.Close()
+	c.err = errCollaborationHandshakeRejected
+}
+
+// parseClientFrame decodes the first frame in buf. A zero size means buf does
+// not yet hold the whole frame.
+func parseClientFrame(buf []byte, maxPayload int) (byte, []byte, int, error) {
+	if len(buf) < 2 {
+		return 0, nil, 0, nil
+	}
+	fin, opcode := buf[0]&0x80 != 0, buf[0]&0x0f
+	if buf[0]&0x70 != 0 {
+		return 0, nil, 0, errors.New("reserved bits set")
+	}
+	if buf[1]&0x80 == 0 {
+		return 0, nil, 0, errors.New("unmasked client frame")
+	}
+	length, offset := uint64(buf[1]&0x7f), 2
+	switch length {
+	case 126:
+		if len(buf) < 4 {
+			return 0, nil, 0, nil
+		}
+		length, offset = uint64(binary.BigEndian.Uint16(buf[2:])), 4
+	case 127:
+		if len(buf) < 10 {
+			return 0, nil, 0, nil
+		}
+		length, offset = binary.BigEndian.Uint64(buf[2:]), 10
+	}
+	switch {
+	case opcode >= websocketOpcodeClose:
+		if opcode > 0xa || !fin || length > 125 {
+			return 0, nil, 0, errors.New("invalid control frame")
+		}
+	case opcode == websocketOpcodeContinuation || !fin:
+		return 0, nil, 0, errors.New("fragmented message")
+	case opcode > websocketOpcodeBinary:
+		return 0, nil, 0, fmt.Errorf("unknown opcode %d", opcode)
+	}
+	if length > uint64(maxPayload) {
+		return 0, nil, 0, fmt.Errorf("message exceeds %d bytes", maxPayload)
+	}
+	size := offset + 4 + int(length)
+	if len(buf) < size {
+		return 0, nil, 0, nil
+	}
+	mask := buf[offset : offset+4]
+	payload := make([]byte, length)
+	for i := range payload {
+		payload[i] = buf[offset+4+i] ^ mask[i%4]
+	}
+	return opcode, payload, size, nil
+}
+
+// parseYMessage checks that payload is one complete y-protocols message and
+// returns its type and, for sync messages, the sync step.
+func parseYMessage(payload []byte) (uint64, uint64, error) {
+	messageType, rest, ok := readVarUint(payload)
+	if !ok {
+		return 0, 0, errors.New("truncated message type")
+	}
+	var syncStep uint64
+	switch messageType {
+	case yMessageSync:
+		if syncStep, rest, ok = readVarUint(rest); !ok || syncStep > ySyncUpdate {
+			return 0, 0, errors.New("invalid sync step")
+		}
+		if rest, ok = readVarBytes(rest); !ok {
+			return 0, 0, errors.New("truncated sync payload")
+		}
+	case yMessageAwareness:
+		if rest, ok = readVarBytes(rest); !ok {
+			return 0, 0, errors.New("truncated awareness payload")
+		}
+	case yMessageQueryAwareness:
+	default:
+		return 0, 0, fmt.Errorf("unknown message type %d", messageType)
+	}
+	if len(rest) != 0 {
+		return 0, 0, errors.New("trailing bytes after message")
+	}
+	return messageType, syncStep, nil
+}
+
+// readVarUint decodes a lib0 variable-length unsigned integer, which is
+// limited to JavaScript's 53-bit safe range.
+func readVarUint(buf []byte) (uint64, []byte, bool) {
+	var value uint64
+	for i := 0; i < len(buf) && i < 8; i++ {
+		value |= uint64(buf[i]&0x7f) << (7 * i)
+		if buf[i]&0x80 == 0 {
+			return value, buf[i+1:], value < 1<<53
+		}
+	}
+	return 0, nil, false
+}
+
+func readVarBytes(buf []byte) ([]byte, bool) {
+	length, rest, ok := readVarUint(buf)
+	if !ok || length > uint64(len(rest)) {
+		return nil, false
+	}
+	return rest[length:], true
+}
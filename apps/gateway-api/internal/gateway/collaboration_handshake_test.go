@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientFrame builds a masked, unfragmented client-to-server frame.
+func clientFrame(opcode byte, payload []byte) []byte {
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+var (
+	ySyncStep1Message = []byte{yMessageSync, ySyncStep1, 0x01, 0x00}
+	yAwarenessMessage = []byte{yMessageAwareness, 0x02, 0x01, 0x00}
+)
+
+func TestParseYMessage(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		payload []byte
+		valid   bool
+	}{
+		{name: "sync step 1", payload: ySyncStep1Message, valid: true},
+		{name: "sync update", payload: []byte{yMessageSync, ySyncUpdate, 0x02, 0xaa, 0xbb}, valid: true},
+		{name: "awareness", payload: yAwarenessMessage, valid: true},
+		{name: "query awareness", payload: []byte{yMessageQueryAwareness}, valid: true},
+		{name: "multi-byte length", payload: append([]byte{yMessageAwareness, 0x80, 0x01}, make([]byte, 128)...), valid: true},
+		{name: "empty", payload: nil},
+		{name: "auth from client", payload: []byte{2, 0}},
+		{name: "unknown sync step", payload: []byte{yMessageSync, 3, 0x00}},
+		{name: "truncated payload", payload: []byte{yMessageSync, ySyncStep1, 0x05, 0x00}},
+		{name: "trailing bytes", payload: []byte{yMessageQueryAwareness, 0x00}},
+		{name: "unterminated varuint", payload: []byte{0x80, 0x80}},
+		{name: "json", payload: []byte(`{"type":"hello"}`)},
+	} {
+		if _, _, err := parseYMessage(tc.payload); (err == nil) != tc.valid {
+			t.Fatalf("%s: expected valid=%v, got %v", tc.name, tc.valid, err)
+		}
+	}
+}
+
+func TestParseClientFrame(t *testing.T) {
+	frame := clientFrame(websocketOpcodeBinary, ySyncStep1Message)
+	if _, _, size, err := parseClientFrame(frame[:len(frame)-1], 1024); size != 0 || err != nil {
+		t.Fatalf("expected a partial frame to wait for more data, got %d %v", size, err)
+	}
+	opcode, payload, size, err := parseClientFrame(append(frame, 0xff), 1024)
+	if err != nil || opcode != websocketOpcodeBinary || size != len(frame) || !bytes.Equal(payload, ySyncStep1Message) {
+		t.Fatalf("unexpected frame %d % x %d %v", opcode, payload, size, err)
+	}
+
+	unmasked := append([]byte{0x82, byte(len(ySyncStep1Message))}, ySyncStep1Message...)
+	fragmented := clientFrame(websocketOpcodeBinary, ySyncStep1Message)
+	fragmented[0] &^= 0x80
+	compressed := clientFrame(websocketOpcodeBinary, ySyncStep1Message)
+	compressed[0] |= 0x40
+	for name, raw := range map[string][]byte{
+		"unmasked":   unmasked,
+		"fragmented": fragmented,
+		"compressed": compressed,
+		"oversized":  clientFrame(websocketOpcodeBinary, make([]byte, 200)),
+		"opcode":     clientFrame(0x3, nil),
+	} {
+		if _, _, _, err := parseClientFrame(raw, 128); err == nil {
+			t.Fatalf("%s: expected frame to be rejected", name)
+		}
+	}
+}
+
+type handshakeTestServer struct {
+	addr       string
+	received   chan []byte
+	extensions chan string
+}
+
+// newHandshakeTestServer stands in for the proxy: it hijacks the upgrade
+// through the handshake middleware and reports what it read from the client.
+func newHandshakeTestServer(t *testing.T, handshake collaborationHandshake, want int) *handshakeTestServer {
+	t.Helper()
+	s := &handshakeTestServer{received: make(chan []byte, 1), extensions: make(chan string, 1)}
+	server := httptest.NewServer(collaborationHandshakeMiddleware(handshake, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.extensions <- r.Header.Get("Sec-WebSocket-Extensions")
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		if brw.Reader.Buffered() != 0 {
+			t.Errorf("expected buffered client bytes to move into the connection")
+		}
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = brw.Flush()
+		data := make([]byte, want)
+		n, _ := io.ReadFull(conn, data)
+		s.received <- data[:n]
+	})))
+	t.Cleanup(server.Close)
+	s.addr = server.Listener.Addr().String()
+	return s
+}
+
+// connect sends the upgrade request and the frames in a single write so the
+// frames are pipelined behind the request.
+func (s *handshakeTestServer) connect(t *testing.T, frames ...[]byte) *bufio.Reader {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	request := "GET /collaboration/ws?filePath=docs/a.md HTTP/1.1\r\nHost: gateway.local\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Extensions: permessage-deflate\r\n\r\n"
+	if _, err := conn.Write(append([]byte(request), bytes.Join(frames, nil)...)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %v %v", resp, err)
+	}
+	return reader
+}
+
+func TestCollaborationHandshakeForwardsValidFrames(t *testing.T) {
+	frames := [][]byte{
+		clientFrame(websocketOpcodeBinary, ySyncStep1Message),
+		clientFrame(0x9, []byte("ping")),
+		clientFrame(websocketOpcodeBinary, yAwarenessMessage),
+		clientFrame(0x1, []byte("after the handshake")),
+	}
+	want := bytes.Join(frames, nil)
+	server := newHandshakeTestServer(t, collaborationHandshake{frames: 2, maxBytes: 1024}, len(want))
+	server.connect(t, frames...)
+
+	if got := <-server.received; !bytes.Equal(got, want) {
+		t.Fatalf("expected frames to be forwarded unchanged, got % x", got)
+	}
+	if ext := <-server.extensions; ext != "" {
+		t.Fatalf("expected the extension offer to be removed, got %q", ext)
+	}
+}
+
+func TestCollaborationHandshakeRejectsMalformedFrames(t *testing.T) {
+	for name, frames := range map[string][][]byte{
+		"text":              {clientFrame(0x1, []byte(`{"version":1}`))},
+		"awareness first":   {clientFrame(websocketOpcodeBinary, yAwarenessMessage)},
+		"malformed message": {clientFrame(websocketOpcodeBinary, ySyncStep1Message), clientFrame(websocketOpcodeBinary, []byte{0x07})},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := newHandshakeTestServer(t, collaborationHandshake{frames: 2, maxBytes: 1024}, 1<<10)
+			reader := server.connect(t, frames...)
+
+			header := make([]byte, 4)
+			if _, err := io.ReadFull(reader, header); err != nil {
+				t.Fatalf("expected a close frame: %v", err)
+			}
+			if header[0] != 0x88 || binary.BigEndian.Uint16(header[2:]) != websocketCloseProtocolError {
+				t.Fatalf("unexpected close frame % x", header)
+			}
+			reason := make([]byte, int(header[1])-2)
+			_, _ = io.ReadFull(reader, reason)
+			if !strings.HasPrefix(string(reason), collaborationHandshakeReason+": ") {
+				t.Fatalf("unexpected close reason %q", reason)
+			}
+
+			got := <-server.received
+			if len(frames) == 1 && len(got) != 0 {
+				t.Fatalf("expected nothing to be forwarded, got % x", got)
+			}
+			if len(frames) == 2 && !bytes.Equal(got, frames[0]) {
+				t.Fatalf("expected only the valid frame to be forwarded, got % x", got)
+			}
+		})
+	}
+}
+
+func TestLoadCollaborationHandshake(t *testing.T) {
+	if handshake := loadCollaborationHandshake(); handshake.frames != defaultCollaborationHandshakeFrames || handshake.maxBytes != defaultCollaborationHandshakeMaxBytes {
+		t.Fatalf("unexpected defaults %+v", handshake)
+	}
+
+	t.Setenv("GATEWAY_COLLAB_HANDSHAKE_FRAMES", "0")
+	t.Setenv("GATEWAY_COLLAB_HANDSHAKE_MAX_BYTES", "-1")
+	handshake := loadCollaborationHandshake()
+	if handshake.frames != 0 || handshake.maxBytes != defaultCollaborationHandshakeMaxBytes {
+		t.Fatalf("unexpected configuration %+v", handshake)
+	}
+	var extensions string
+	handler := collaborationHandshakeMiddleware(handshake, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		extensions = r.Header.Get("Sec-WebSocket-Extensions")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/collaboration/ws?filePath=docs/a.md", nil)
+	req.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if extensions != "permessage-deflate" {
+		t.Fatalf("expected disabled validation to leave the request untouched, got %q", extensions)
+	}
+}
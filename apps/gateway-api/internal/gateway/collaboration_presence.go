@@ -0,0 +1,339 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+// collaborationRoom identifies a shared document.
+type collaborationRoom struct {
+	TenantID  string
+	ProjectID string
+	FilePath  string
+}
+
+type presenceConnection struct {
+	sessionHash string
+	bindingHash string
+	connectedAt time.Time
+	conn        *presenceConn
+}
+
+// collaborationReconnectCode asks y-websocket clients to reconnect, which
+// re-runs session validation and the read-only check.
+const (
+	collaborationReconnectCode         = 1012
+	collaborationReadOnlyReason        = "read_only"
+	collaborationSessionsFlushedReason = "sessions_flushed"
+)
+
+// collaborationPresence tracks the WebSocket connections this gateway replica
+// is proxying, per room. Session IDs are held only as audit hashes.
+type collaborationPresence struct {
+	mu    sync.Mutex
+	rooms map[collaborationRoom]map[*presenceConnection]struct{}
+	now   func() time.Time
+}
+
+type collaborationPresenceSession struct {
+	SessionHash string    `json:"sessionHash"`
+	Connections int       `json:"connections"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+type collaborationPresenceResponse struct {
+	TenantID    string                         `json:"tenantId"`
+	ProjectID   string                         `json:"projectId"`
+	FilePath    string                         `json:"filePath"`
+	Connections int                            `json:"connections"`
+	Sessions    []collaborationPresenceSession `json:"sessions"`
+}
+
+func newCollaborationPresence() *collaborationPresence {
+	return &collaborationPresence{
+		rooms: make(map[collaborationRoom]map[*presenceConnection]struct{}),
+		now:   time.Now,
+	}
+}
+
+// join records a connection to room and returns it with the function that
+// removes it.
+func (p *collaborationPresence) join(room collaborationRoom, sessionID, bindingHash string) (*presenceConnection, func()) {
+	conn := &presenceConnection{
+		sessionHash: gatewayAuditLogger.HashIdentity("session", sessionID),
+		bindingHash: bindingHash,
+		connectedAt: p.now().UTC(),
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rooms[room] == nil {
+		p.rooms[room] = make(map[*presenceConnection]struct{})
+	}
+	p.rooms[room][conn] = struct{}{}
+
+	var once sync.Once
+	return conn, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			delete(p.rooms[room], conn)
+			if len(p.rooms[room]) == 0 {
+				delete(p.rooms, room)
+			}
+		})
+	}
+}
+
+// disconnect sends a close frame to every proxied connection in the rooms
+// match selects and reports how many were closed.
+func (p *collaborationPresence) disconnect(match func(collaborationRoom) bool, reason string) int {
+	var conns []*presenceConn
+	p.mu.Lock()
+	for room, members := range p.rooms {
+		if !match(room) {
+			continue
+		}
+		for member := range members {
+			if member.conn != nil {
+				conns = append(conns, member.conn)
+			}
+		}
+	}
+	p.mu.Unlock()
+	for _, conn := range conns {
+		conn.close(collaborationReconnectCode, reason)
+	}
+	return len(conns)
+}
+
+// revoke closes the proxied connections of revoked sessions. Unlike
+// disconnect, the close code tells clients not to reconnect with the same
+// credentials.
+func (p *collaborationPresence) revoke(revocation sessionRevocation) int {
+	var conns []*presenceConn
+	p.mu.Lock()
+	for _, members := range p.rooms {
+		for member := range members {
+			if member.conn != nil && revocation.matches(member.sessionHash, member.bindingHash) {
+				conns = append(conns, member.conn)
+			}
+		}
+	}
+	p.mu.Unlock()
+	for _, conn := range conns {
+		conn.close(websocketCloseSessionRevoked, collaborationSessionRevoked)
+	}
+	return len(conns)
+}
+
+// subscribe closes connections that the read-only switch or a session flush
+// has made stale, so their clients reconnect through the current checks, and
+// those of revoked sessions. It only reaches connections on this replica.
+func (p *collaborationPresence) subscribe(bus *eventBus) {
+	subscribe(bus, topicCollaborationReadOnly, "collaboration.presence", func(state collaborationReadOnlyState) {
+		if closed := p.disconnect(func(room collaborationRoom) bool { return state.appliesTo(room.TenantID) }, collaborationReadOnlyReason); closed > 0 {
+			collabLog.Info("closed collaboration connections for read-only mode", slog.Int("connections", closed))
+		}
+	})
+	subscribe(bus, topicCollaborationSessionsFlushed, "collaboration.presence", func(struct{}) {
+		if closed := p.disconnect(func(collaborationRoom) bool { return true }, collaborationSessionsFlushedReason); closed > 0 {
+			collabLog.Info("closed collaboration connections after session flush", slog.Int("connections", closed))
+		}
+	})
+	subscribe(bus, topicSessionsRevoked, "collaboration.presence", func(revocation sessionRevocation) {
+		if closed := p.revoke(revocation); closed > 0 {
+			collabLog.Info("closed collaboration connections for revoked sessions", slog.Int("connections", closed))
+		}
+	})
+}
+
+func (p *collaborationPresence) snapshot(room collaborationRoom) collaborationPresenceResponse {
+	resp := collaborationPresenceResponse{
+		TenantID:  room.TenantID,
+		ProjectID: room.ProjectID,
+		FilePath:  room.FilePath,
+		Sessions:  []collaborationPresenceSession{},
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bySession := make(map[string]*collaborationPresenceSession)
+	for conn := range p.rooms[room] {
+		resp.Connections++
+		session, ok := bySession[conn.sessionHash]
+		if !ok {
+			session = &collaborationPresenceSession{SessionHash: conn.sessionHash, ConnectedAt: conn.connectedAt}
+			bySession[conn.sessionHash] = session
+		}
+		session.Connections++
+		if conn.connectedAt.Before(session.ConnectedAt) {
+			session.ConnectedAt = conn.connectedAt
+		}
+	}
+	for _, session := range bySession {
+		resp.Sessions = append(resp.Sessions, *session)
+	}
+	sort.Slice(resp.Sessions, func(i, j int) bool {
+		if !resp.Sessions[i].ConnectedAt.Equal(resp.Sessions[j].ConnectedAt) {
+			return resp.Sessions[i].ConnectedAt.Before(resp.Sessions[j].ConnectedAt)
+		}
+		return resp.Sessions[i].SessionHash < resp.Sessions[j].SessionHash
+	})
+	return resp
+}
+
+// collaborationPresenceMiddleware registers connections that passed
+// collaborationAuthMiddleware, using the identity it resolved, for as long as
+// the proxied WebSocket stays open.
+func collaborationPresenceMiddleware(presence *collaborationPresence, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := requestScopeFrom(r.Context())
+		room := collaborationRoom{
+			TenantID:  scope.TenantID,
+			ProjectID: r.Header.Get("X-Project-Id"),
+			FilePath:  r.URL.Query().Get("filePath"),
+		}
+		member, leave := presence.join(room, scope.SessionID, scope.BindingIDHash)
+		defer leave()
+		next.ServeHTTP(&presenceResponseWriter{ResponseWriter: w, presence: presence, member: member}, r)
+	})
+}
+
+type presenceResponseWriter struct {
+	http.ResponseWriter
+	presence *collaborationPresence
+	member   *presenceConnection
+}
+
+func (pw *presenceResponseWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// Hijack records the client connection on the presence entry so the
+// registry can close it.
+func (pw *presenceResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(pw.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped := &presenceConn{Conn: conn}
+	pw.presence.mu.Lock()
+	pw.member.conn = wrapped
+	pw.presence.mu.Unlock()
+	return wrapped, brw, nil
+}
+
+// presenceConn serialises proxied writes with the close frame sent by
+// disconnect.
+type presenceConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+func (c *presenceConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *presenceConn) close(code uint16, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = c.Conn.Write(websocketCloseFrame(code, reason))
+	_ = c.Conn.Close()
+}
+
+// collaborationPresenceHandler serves GET /collaboration/presence. Callers
+// authenticate as for the WebSocket and only see rooms in their session's
+// tenant that the room authorization hook, when configured, lets them join.
+// Counts cover this replica's connections.
+func collaborationPresenceHandler(
+	validate collaborationSessionValidator,
+	authorize collaborationAuthorizer,
+	failureLimiter *rateLimiter,
+	failureBucket rateLimitBucket,
+	trustedProxies []*net.IPNet,
+	presence *collaborationPresence,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if updated, _ := audit.EnsureRequestID(r, w); updated != nil {
+			r = updated
+		}
+		reject := func(status int, code, message string) {
+			if limited, retryAfter, _ := registerCollaborationAuthFailure(r.Context(), r, failureLimiter, failureBucket, trustedProxies); limited {
+				respondTooManyRequests(w, r, retryAfter)
+				return
+			}
+			writeErrorResponse(w, r, status, code, message, nil)
+		}
+
+		tenantID, projectID, _, filePath, err := parseCollaborationIdentity(r)
+		if err != nil {
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+			return
+		}
+		if projectID == "" {
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "project id is required", nil)
+			return
+		}
+
+		authHeader := strings.TrimSpace(r.Header.Get("Authorization"))
+		cookieHeader := strings.TrimSpace(r.Header.Get("Cookie"))
+		if authHeader == "" && cookieHeader == "" {
+			reject(http.StatusUnauthorized, "unauthorized", "authentication required")
+			return
+		}
+		if (authHeader != "" && !validCollaborationBearer(authHeader)) || (cookieHeader != "" && validateForwardedCookie(cookieHeader) != nil) {
+			reject(http.StatusBadRequest, "invalid_request", "credentials invalid")
+			return
+		}
+
+		session, status, err := validate(r.Context(), authHeader, cookieHeader, audit.RequestID(r.Context()))
+		if err != nil {
+			writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to validate session", nil)
+			return
+		}
+		if status != http.StatusOK {
+			reject(http.StatusUnauthorized, "unauthorized", "session validation failed")
+			return
+		}
+		// Unlike the WebSocket, presence never takes the tenant from the
+		// request: a session without a tenant cannot prove which rooms it
+		// belongs to.
+		if session.TenantID == nil || *session.TenantID == "" {
+			reject(http.StatusForbidden, "forbidden", "session is not scoped to a tenant")
+			return
+		}
+		if tenantID != "" && tenantID != *session.TenantID {
+			reject(http.StatusForbidden, "forbidden", "tenant mismatch")
+			return
+		}
+		tenantID = *session.TenantID
+
+		if authorize != nil {
+			access := collaborationAccess{SessionID: session.ID, TenantID: tenantID, ProjectID: projectID, FilePath: filePath}
+			allowed, err := authorize(r.Context(), access, authHeader, cookieHeader, audit.RequestID(r.Context()))
+			if err != nil {
+				writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to authorize collaboration access", nil)
+				return
+			}
+			if !allowed {
+				reject(http.StatusForbidden, "forbidden", "access to this file is not permitted")
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(presence.snapshot(collaborationRoom{TenantID: tenantID, ProjectID: projectID, FilePath: filePath}))
+	})
+}
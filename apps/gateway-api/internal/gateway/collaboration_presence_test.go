@@ -0,0 +1,180 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollaborationPresenceAggregatesSessions(t *testing.T) {
+	presence := newCollaborationPresence()
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	presence.now = func() time.Time { return clock }
+
+	room := collaborationRoom{TenantID: "acme", ProjectID: "web", FilePath: "docs/a.md"}
+	_, leaveFirst := presence.join(room, "session-1", "")
+	clock = clock.Add(time.Minute)
+	_, leaveSecond := presence.join(room, "session-1", "")
+	_, leaveOther := presence.join(room, "session-2", "")
+	_, leaveThird := presence.join(collaborationRoom{TenantID: "acme", ProjectID: "web", FilePath: "docs/b.md"}, "session-3", "")
+	defer leaveThird()
+
+	snapshot := presence.snapshot(room)
+	if snapshot.Connections != 3 || len(snapshot.Sessions) != 2 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+	first := snapshot.Sessions[0]
+	if first.Connections != 2 || !first.ConnectedAt.Equal(clock.Add(-time.Minute)) {
+		t.Fatalf("expected the earliest connection of session-1, got %+v", first)
+	}
+	if first.SessionHash == "" || strings.Contains(first.SessionHash, "session-1") {
+		t.Fatalf("expected a hashed session id, got %q", first.SessionHash)
+	}
+
+	leaveFirst()
+	leaveFirst()
+	leaveSecond()
+	if snapshot := presence.snapshot(room); snapshot.Connections != 1 || len(snapshot.Sessions) != 1 {
+		t.Fatalf("expected one remaining connection, got %+v", snapshot)
+	}
+	leaveOther()
+	if _, ok := presence.rooms[room]; ok {
+		t.Fatal("expected empty rooms to be removed")
+	}
+	if snapshot := presence.snapshot(room); snapshot.Connections != 0 || snapshot.Sessions == nil {
+		t.Fatalf("expected an empty session list, got %+v", snapshot)
+	}
+}
+
+func TestCollaborationPresenceMiddlewareTracksOpenConnections(t *testing.T) {
+	presence := newCollaborationPresence()
+	room := collaborationRoom{TenantID: "acme", ProjectID: "web", FilePath: "docs/a.md"}
+	var during int
+	handler := collaborationPresenceMiddleware(presence, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		during = presence.snapshot(room).Connections
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/collaboration/ws?filePath=docs/a.md", nil)
+	req.Header.Set("X-Project-Id", "web")
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(withRequestScope(req.Context(), requestScope{TenantID: "acme", SessionID: "session-1"})))
+
+	if during != 1 {
+		t.Fatalf("expected the connection to be registered while open, got %d", during)
+	}
+	if after := presence.snapshot(room).Connections; after != 0 {
+		t.Fatalf("expected the connection to be removed on close, got %d", after)
+	}
+}
+
+func TestCollaborationPresenceHandler(t *testing.T) {
+	presence := newCollaborationPresence()
+	room := collaborationRoom{TenantID: "acme", ProjectID: "web", FilePath: "docs/a.md"}
+	_, leaveFirst := presence.join(room, "session-1", "")
+	defer leaveFirst()
+	_, leaveOther := presence.join(collaborationRoom{TenantID: "globex", ProjectID: "web", FilePath: "docs/a.md"}, "session-2", "")
+	defer leaveOther()
+
+	tenant := "acme"
+	session := orchestratorSession{ID: "session-1", TenantID: &tenant}
+	validator := func(context.Context, string, string, string) (orchestratorSession, int, error) {
+		return session, http.StatusOK, nil
+	}
+	allowed := true
+	authorizer := func(context.Context, collaborationAccess, string, string, string) (bool, error) {
+		return allowed, nil
+	}
+	handler := collaborationPresenceHandler(validator, authorizer, nil, rateLimitBucket{}, nil, presence)
+	serve := func(query string, authenticated bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/collaboration/presence?"+query, nil)
+		if authenticated {
+			req.Header.Set("Authorization", "Bearer abc")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("filePath=docs/a.md&projectId=web", true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Fatalf("expected no-store, got %q", cc)
+	}
+	var body collaborationPresenceResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if body.TenantID != "acme" || body.Connections != 1 || len(body.Sessions) != 1 {
+		t.Fatalf("expected only the session's tenant to be counted, got %+v", body)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		query         string
+		authenticated bool
+		status        int
+	}{
+		{name: "missing project", query: "filePath=docs/a.md", authenticated: true, status: http.StatusBadRequest},
+		{name: "unauthenticated", query: "filePath=docs/a.md&projectId=web", status: http.StatusUnauthorized},
+		{name: "other tenant", query: "filePath=docs/a.md&projectId=web&tenantId=globex", authenticated: true, status: http.StatusForbidden},
+	} {
+		if rec := serve(tc.query, tc.authenticated); rec.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	allowed = false
+	if rec := serve("filePath=docs/a.md&projectId=web", true); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected room denial to return 403, got %d", rec.Code)
+	}
+
+	allowed = true
+	session.TenantID = nil
+	if rec := serve("filePath=docs/a.md&projectId=web&tenantId=acme", true); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected sessions without a tenant to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestCollaborationPresenceClosesConnectionsOnEvents(t *testing.T) {
+	bus := newEventBus(4)
+	presence := newCollaborationPresence()
+	presence.subscribe(bus)
+	connect := func(tenant string) net.Conn {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		member, leave := presence.join(collaborationRoom{TenantID: tenant, ProjectID: "web", FilePath: "docs/a.md"}, "session-"+tenant, "")
+		t.Cleanup(leave)
+		member.conn = &presenceConn{Conn: server}
+		return client
+	}
+	expectClose := func(client net.Conn, reason string) {
+		t.Helper()
+		_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		frame := make([]byte, 4+len(reason))
+		if _, err := io.ReadFull(client, frame); err != nil {
+			t.Fatalf("expected a close frame: %v", err)
+		}
+		if !bytes.Equal(frame, websocketCloseFrame(collaborationReconnectCode, reason)) {
+			t.Fatalf("unexpected close frame % x", frame)
+		}
+	}
+	acme, globex := connect("acme"), connect("globex")
+
+	publish(bus, topicCollaborationReadOnly, collaborationReadOnlyState{Tenants: []string{"acme"}})
+	expectClose(acme, collaborationReadOnlyReason)
+	_ = globex.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := globex.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected other tenants to stay connected")
+	}
+
+	publish(bus, topicCollaborationSessionsFlushed, struct{}{})
+	expectClose(globex, collaborationSessionsFlushedReason)
+}
@@ -0,0 +1,185 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	websocketProtocolHeader    = "Sec-WebSocket-Protocol"
+	websocketExtensionsHeader  = "Sec-WebSocket-Extensions"
+	websocketPerMessageDeflate = "permessage-deflate"
+)
+
+// collaborationProtocols is the WebSocket negotiation /collaboration/ws
+// allows. Subprotocols are offered upstream only when listed; of the
+// extensions, only permessage-deflate is known, and it is dropped from the
+// offer unless enabled since browsers always send it.
+type collaborationProtocols struct {
+	subprotocols []string
+	deflate      bool
+}
+
+// loadCollaborationProtocols reads GATEWAY_COLLAB_SUBPROTOCOLS and
+// GATEWAY_COLLAB_PERMESSAGE_DEFLATE.
+func loadCollaborationProtocols() (collaborationProtocols, error) {
+	var protocols collaborationProtocols
+	for _, protocol := range strings.Split(GetEnv("GATEWAY_COLLAB_SUBPROTOCOLS", ""), ",") {
+		protocol = strings.TrimSpace(protocol)
+		if protocol == "" {
+			continue
+		}
+		if !isHTTPToken(protocol) {
+			return collaborationProtocols{}, fmt.Errorf("invalid GATEWAY_COLLAB_SUBPROTOCOLS entry %q", protocol)
+		}
+		if !slices.Contains(protocols.subprotocols, protocol) {
+			protocols.subprotocols = append(protocols.subprotocols, protocol)
+		}
+	}
+	deflate, err := strconv.ParseBool(GetEnv("GATEWAY_COLLAB_PERMESSAGE_DEFLATE", "false"))
+	if err != nil {
+		return collaborationProtocols{}, fmt.Errorf("invalid GATEWAY_COLLAB_PERMESSAGE_DEFLATE: %w", err)
+	}
+	// The handshake inspector reads client frames as sent, so it cannot run
+	// on compressed connections.
+	if deflate && loadCollaborationHandshake().frames > 0 {
+		return collaborationProtocols{}, errors.New("GATEWAY_COLLAB_PERMESSAGE_DEFLATE requires GATEWAY_COLLAB_HANDSHAKE_FRAMES=0")
+	}
+	protocols.deflate = deflate
+	return protocols, nil
+}
+
+// ValidateCollaborationProtocolConfig checks the WebSocket negotiation
+// settings at startup.
+func ValidateCollaborationProtocolConfig() error {
+	_, err := loadCollaborationProtocols()
+	return err
+}
+
+// negotiate picks the first subprotocol the client offers that is allowed
+// and filters the extension offer. It returns the values to send upstream,
+// either of which may be empty.
+func (p collaborationProtocols) negotiate(r *http.Request) (string, string, string, error) {
+	offered, err := parseWebSocketTokenList(r.Header.Values(websocketProtocolHeader))
+	if err != nil {
+		return "", "", "invalid_subprotocol", err
+	}
+	var protocol string
+	if len(offered) > 0 {
+		for _, candidate := range offered {
+			if slices.Contains(p.subprotocols, candidate) {
+				protocol = candidate
+				break
+			}
+		}
+		if protocol == "" {
+			return "", "", "unsupported_subprotocol", fmt.Errorf("unsupported subprotocol %q", offered[0])
+		}
+	}
+	var kept []string
+	for _, offer := range splitHeaderValues(r.Header.Values(websocketExtensionsHeader)) {
+		name, _, _ := strings.Cut(offer, ";")
+		name = strings.TrimSpace(name)
+		if !isHTTPToken(name) {
+			return "", "", "invalid_extension", errors.New("malformed extension offer")
+		}
+		if !strings.EqualFold(name, websocketPerMessageDeflate) {
+			return "", "", "unsupported_extension", fmt.Errorf("unsupported extension %q", name)
+		}
+		if p.deflate {
+			kept = append(kept, offer)
+		}
+	}
+	return protocol, strings.Join(kept, ", "), "", nil
+}
+
+// collaborationProtocolMiddleware rejects upgrades that ask for subprotocols
+// or extensions the gateway does not support and forwards only the
+// negotiated ones.
+func collaborationProtocolMiddleware(protocols collaborationProtocols, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocol, extensions, reason, err := protocols.negotiate(r)
+		if err != nil {
+			recordCollaborationAudit(r.Context(), r, auditOutcomeDenied, map[string]any{"reason": reason, "error": err.Error()})
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error(), map[string]any{"reason": reason})
+			return
+		}
+		setOrDeleteHeader(r.Header, websocketProtocolHeader, protocol)
+		setOrDeleteHeader(r.Header, websocketExtensionsHeader, extensions)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateCollaborationUpgrade checks that the orchestrator accepted only
+// what the gateway offered it, so a misbehaving upstream cannot switch the
+// client to a protocol or extension it did not negotiate.
+func validateCollaborationUpgrade(resp *http.Response) error {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil
+	}
+	if accepted := resp.Header.Get(websocketProtocolHeader); accepted != "" && accepted != resp.Request.Header.Get(websocketProtocolHeader) {
+		return fmt.Errorf("orchestrator selected unoffered subprotocol %q", accepted)
+	}
+	offered := resp.Request.Header.Get(websocketExtensionsHeader) != ""
+	for _, extension := range splitHeaderValues(resp.Header.Values(websocketExtensionsHeader)) {
+		name, _, _ := strings.Cut(extension, ";")
+		if !offered || !strings.EqualFold(strings.TrimSpace(name), websocketPerMessageDeflate) {
+			return fmt.Errorf("orchestrator accepted unoffered extension %q", strings.TrimSpace(name))
+		}
+	}
+	return nil
+}
+
+func parseWebSocketTokenList(values []string) ([]string, error) {
+	tokens := splitHeaderValues(values)
+	for _, token := range tokens {
+		if !isHTTPToken(token) {
+			return nil, errors.New("malformed subprotocol list")
+		}
+	}
+	return tokens, nil
+}
+
+// splitHeaderValues splits comma-separated header values, dropping empty
+// elements. Extension parameters never contain commas, so offers survive
+// intact.
+func splitHeaderValues(values []string) []string {
+	var parts []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+	return parts
+}
+
+func setOrDeleteHeader(h http.Header, key, value string) {
+	if value == "" {
+		h.Del(key)
+		return
+	}
+	h.Set(key, value)
+}
+
+// isHTTPToken reports whether s is an RFC 9110 token.
+func isHTTPToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCollaborationProtocolsNegotiate(t *testing.T) {
+	protocols := collaborationProtocols{subprotocols: []string{"y-protocols.v1", "y-protocols.v2"}}
+	cases := []struct {
+		name       string
+		protocol   []string
+		extensions []string
+		deflate    bool
+		wantProto  string
+		wantExt    string
+		wantReason string
+	}{
+		{name: "none"},
+		{name: "first allowed offer", protocol: []string{"legacy, y-protocols.v2", "y-protocols.v1"}, wantProto: "y-protocols.v2"},
+		{name: "unsupported", protocol: []string{"legacy"}, wantReason: "unsupported_subprotocol"},
+		{name: "malformed", protocol: []string{"y protocols"}, wantReason: "invalid_subprotocol"},
+		{name: "deflate dropped", extensions: []string{"permessage-deflate; client_max_window_bits"}},
+		{name: "deflate kept", extensions: []string{"permessage-deflate; client_max_window_bits"}, deflate: true, wantExt: "permessage-deflate; client_max_window_bits"},
+		{name: "unknown extension", extensions: []string{"permessage-deflate, x-webkit-deflate-frame"}, wantReason: "unsupported_extension"},
+		{name: "malformed extension", extensions: []string{"; server_no_context_takeover"}, wantReason: "invalid_extension"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/collaboration/ws", nil)
+			for _, value := range tc.protocol {
+				r.Header.Add(websocketProtocolHeader, value)
+			}
+			for _, value := range tc.extensions {
+				r.Header.Add(websocketExtensionsHeader, value)
+			}
+			p := protocols
+			p.deflate = tc.deflate
+			protocol, extensions, reason, err := p.negotiate(r)
+			if reason != tc.wantReason || (err != nil) != (tc.wantReason != "") {
+				t.Fatalf("expected reason %q, got %q (%v)", tc.wantReason, reason, err)
+			}
+			if protocol != tc.wantProto || extensions != tc.wantExt {
+				t.Fatalf("expected %q / %q, got %q / %q", tc.wantProto, tc.wantExt, protocol, extensions)
+			}
+		})
+	}
+}
+
+func TestCollaborationProtocolMiddleware(t *testing.T) {
+	logs := captureAuditLogs(t)
+	var forwarded http.Header
+	handler := collaborationProtocolMiddleware(collaborationProtocols{subprotocols: []string{"y-protocols.v1"}}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Clone()
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/collaboration/ws", nil)
+	r.Header.Set(websocketProtocolHeader, "other, y-protocols.v1")
+	r.Header.Set(websocketExtensionsHeader, "permessage-deflate")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if forwarded.Get(websocketProtocolHeader) != "y-protocols.v1" || forwarded.Get(websocketExtensionsHeader) != "" {
+		t.Fatalf("expected only the negotiated values upstream, got %v", forwarded)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/collaboration/ws", nil)
+	r.Header.Set(websocketProtocolHeader, "graphql-ws")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "unsupported subprotocol") {
+		t.Fatalf("expected 400 for an unsupported subprotocol, got %d %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(logs.String(), `"reason":"unsupported_subprotocol"`) {
+		t.Fatalf("expected the rejection to be audited, got %s", logs.String())
+	}
+}
+
+func TestValidateCollaborationUpgrade(t *testing.T) {
+	upgrade := func(offeredProtocol, offeredExt, acceptedProtocol, acceptedExt string) error {
+		req := httptest.NewRequest(http.MethodGet, "/collaboration/ws", nil)
+		setOrDeleteHeader(req.Header, websocketProtocolHeader, offeredProtocol)
+		setOrDeleteHeader(req.Header, websocketExtensionsHeader, offeredExt)
+		resp := &http.Response{StatusCode: http.StatusSwitchingProtocols, Header: http.Header{}, Request: req}
+		setOrDeleteHeader(resp.Header, websocketProtocolHeader, acceptedProtocol)
+		setOrDeleteHeader(resp.Header, websocketExtensionsHeader, acceptedExt)
+		return validateCollaborationUpgrade(resp)
+	}
+	if err := upgrade("y-protocols.v1", "permessage-deflate", "y-protocols.v1", "permessage-deflate; server_no_context_takeover"); err != nil {
+		t.Fatalf("expected the negotiated upgrade to pass, got %v", err)
+	}
+	if err := upgrade("", "", "y-protocols.v1", ""); err == nil {
+		t.Fatal("expected an unoffered subprotocol to be rejected")
+	}
+	if err := upgrade("", "", "", "permessage-deflate"); err == nil {
+		t.Fatal("expected an unoffered extension to be rejected")
+	}
+}
+
+func TestLoadCollaborationProtocols(t *testing.T) {
+	t.Setenv("GATEWAY_COLLAB_SUBPROTOCOLS", "y-protocols.v1, y-protocols.v1,,v2")
+	protocols, err := loadCollaborationProtocols()
+	if err != nil || len(protocols.subprotocols) != 2 || protocols.deflate {
+		t.Fatalf("unexpected configuration %+v: %v", protocols, err)
+	}
+	t.Setenv("GATEWAY_COLLAB_SUBPROTOCOLS", "bad protocol")
+	if _, err := loadCollaborationProtocols(); err == nil {
+		t.Fatal("expected an invalid subprotocol to be rejected")
+	}
+	t.Setenv("GATEWAY_COLLAB_SUBPROTOCOLS", "")
+	t.Setenv("GATEWAY_COLLAB_PERMESSAGE_DEFLATE", "true")
+	if _, err := loadCollaborationProtocols(); err == nil {
+		t.Fatal("expected compression to require handshake inspection to be off")
+	}
+	t.Setenv("GATEWAY_COLLAB_HANDSHAKE_FRAMES", "0")
+	if protocols, err := loadCollaborationProtocols(); err != nil || !protocols.deflate {
+		t.Fatalf("expected compression to be enabled, got %+v: %v", protocols, err)
+	}
+}
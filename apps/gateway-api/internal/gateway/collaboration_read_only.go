@@ -0,0 +1,216 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+const (
+	auditEventCollaborationReadOnly = "collaboration.read_only.update"
+	auditTargetCollaborationAdmin   = "collaboration.read_only"
+
+	// collaborationReadOnlyHeader tells the orchestrator whether the proxied
+	// session may only view documents. The gateway always sets it, replacing
+	// any value the client sent.
+	collaborationReadOnlyHeader = "X-Collaboration-Read-Only"
+	collaborationIntentEdit     = "edit"
+
+	collaborationReadOnlyNamespace = "collab_read_only"
+	collaborationReadOnlyStateKey  = "state"
+
+	collaborationReadOnlySourceConfig   = "config"
+	collaborationReadOnlySourceOverride = "override"
+)
+
+// collaborationReadOnlyState lists who may view but not edit documents.
+type collaborationReadOnlyState struct {
+	Global  bool     `json:"global"`
+	Tenants []string `json:"tenants"`
+	Source  string   `json:"source"`
+}
+
+func (s collaborationReadOnlyState) appliesTo(tenantID string) bool {
+	return s.Global || (tenantID != "" && slices.Contains(s.Tenants, tenantID))
+}
+
+// collaborationReadOnly combines the configured switch with the override
+// operators set through the admin API. The override lives in the shared
+// state store so every replica sharing GATEWAY_STORAGE_URL applies it.
+type collaborationReadOnly struct {
+	defaults collaborationReadOnlyState
+	store    storage.Store
+}
+
+// current returns the override when one is set. If the store cannot be read
+// the configured state applies, so a store outage never blocks editing on
+// its own.
+func (c *collaborationReadOnly) current(ctx context.Context) collaborationReadOnlyState {
+	data, err := c.store.Get(ctx, collaborationReadOnlyStateKey)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			collabLog.WarnContext(ctx, "collaboration read-only override unavailable; using configuration", slog.Any("error", err))
+		}
+		return c.defaults
+	}
+	var state collaborationReadOnlyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		collabLog.WarnContext(ctx, "collaboration read-only override invalid; using configuration", slog.Any("error", err))
+		return c.defaults
+	}
+	state.Source = collaborationReadOnlySourceOverride
+	return state
+}
+
+func (c *collaborationReadOnly) override(ctx context.Context, global bool, tenants []string) (collaborationReadOnlyState, error) {
+	normalized, err := normalizeReadOnlyTenants(tenants)
+	if err != nil {
+		return collaborationReadOnlyState{}, err
+	}
+	state := collaborationReadOnlyState{Global: global, Tenants: normalized, Source: collaborationReadOnlySourceOverride}
+	encoded, _ := json.Marshal(state)
+	if err := c.store.Set(ctx, collaborationReadOnlyStateKey, encoded, 0); err != nil {
+		return collaborationReadOnlyState{}, err
+	}
+	return state, nil
+}
+
+func (c *collaborationReadOnly) clear(ctx context.Context) (collaborationReadOnlyState, error) {
+	if err := c.store.Delete(ctx, collaborationReadOnlyStateKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return collaborationReadOnlyState{}, err
+	}
+	return c.defaults, nil
+}
+
+var errInvalidReadOnlyTenant = errors.New("invalid tenant id")
+
+func normalizeReadOnlyTenants(tenants []string) ([]string, error) {
+	normalized := []string{}
+	for _, tenant := range tenants {
+		id, err := normalizeTenantID(tenant)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", errInvalidReadOnlyTenant, tenant)
+		}
+		if id != "" && !slices.Contains(normalized, id) {
+			normalized = append(normalized, id)
+		}
+	}
+	slices.Sort(normalized)
+	return normalized, nil
+}
+
+// collaborationReadOnlyMiddleware runs after collaborationAuthMiddleware. It
+// marks read-only sessions for the orchestrator and turns away connects that
+// ask to edit (intent=edit) while their tenant is read-only. Connects without
+// an intent are proxied and left to the orchestrator to hold read-only.
+// An admin override decides on its own; otherwise a tenant's read_only
+// setting in GATEWAY_TENANT_CONFIG_FILE replaces the configured switch.
+func collaborationReadOnlyMiddleware(readOnly *collaborationReadOnly, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := readOnly.current(r.Context())
+		active := state.appliesTo(requestScopeFrom(r.Context()).TenantID)
+		if setting := TenantConfig(r.Context()).Collaboration.ReadOnly; setting != nil && state.Source != collaborationReadOnlySourceOverride {
+			active = *setting
+		}
+		r.Header.Set(collaborationReadOnlyHeader, strconv.FormatBool(active))
+		if active && strings.EqualFold(strings.TrimSpace(r.URL.Query().Get("intent")), collaborationIntentEdit) {
+			recordCollaborationAudit(r.Context(), r, auditOutcomeDenied, map[string]any{"reason": "read_only", "intent": collaborationIntentEdit})
+			writeErrorResponse(w, r, http.StatusForbidden, "forbidden", "collaboration is read-only", map[string]any{"reason": "read_only"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordCollaborationReadOnlyChange audits an admin change to the switch.
+// Tenants are recorded as hashes like every other tenant identifier.
+func recordCollaborationReadOnlyChange(ctx context.Context, r *http.Request, state collaborationReadOnlyState) {
+	tenantHashes := make([]string, 0, len(state.Tenants))
+	for _, tenant := range state.Tenants {
+		tenantHashes = append(tenantHashes, hashTenantID(tenant))
+	}
+	actor := hashedActorFromRequest(r, nil, "admin")
+	gatewayAuditLogger.Security(audit.WithActor(ctx, actor), audit.Event{
+		Name:       auditEventCollaborationReadOnly,
+		Outcome:    auditOutcomeSuccess,
+		Target:     auditTargetCollaborationAdmin,
+		Capability: auditCapabilityCollaboration,
+		ActorID:    actor,
+		Details: auditDetails(map[string]any{
+			"global":           state.Global,
+			"tenant_id_hashes": tenantHashes,
+			"source":           state.Source,
+			"method":           r.Method,
+		}),
+	})
+}
+
+var (
+	collaborationReadOnlyMu     sync.Mutex
+	collaborationReadOnlyOnce   sync.Once
+	collaborationReadOnlySwitch *collaborationReadOnly
+	collaborationReadOnlyErr    error
+)
+
+// resetCollaborationReadOnly clears the cached configuration for tests.
+func resetCollaborationReadOnly() {
+	collaborationReadOnlyMu.Lock()
+	defer collaborationReadOnlyMu.Unlock()
+	collaborationReadOnlyOnce = sync.Once{}
+	collaborationReadOnlySwitch = nil
+	collaborationReadOnlyErr = nil
+}
+
+// loadCollaborationReadOnly returns the read-only switch configured by
+// GATEWAY_COLLAB_READ_ONLY and GATEWAY_COLLAB_READ_ONLY_TENANTS.
+func loadCollaborationReadOnly() (*collaborationReadOnly, error) {
+	collaborationReadOnlyMu.Lock()
+	defer collaborationReadOnlyMu.Unlock()
+	collaborationReadOnlyOnce.Do(func() {
+		defaults, err := parseCollaborationReadOnlyConfig()
+		if err != nil {
+			collaborationReadOnlyErr = err
+			return
+		}
+		store, err := loadStateStorage()
+		if err != nil {
+			collaborationReadOnlyErr = err
+			return
+		}
+		collaborationReadOnlySwitch = &collaborationReadOnly{
+			defaults: defaults,
+			store:    storage.Namespace(store, collaborationReadOnlyNamespace),
+		}
+	})
+	return collaborationReadOnlySwitch, collaborationReadOnlyErr
+}
+
+func parseCollaborationReadOnlyConfig() (collaborationReadOnlyState, error) {
+	global, err := strconv.ParseBool(GetEnv("GATEWAY_COLLAB_READ_ONLY", "false"))
+	if err != nil {
+		return collaborationReadOnlyState{}, fmt.Errorf("GATEWAY_COLLAB_READ_ONLY must be a boolean: %w", err)
+	}
+	tenants, err := normalizeReadOnlyTenants(strings.Split(os.Getenv("GATEWAY_COLLAB_READ_ONLY_TENANTS"), ","))
+	if err != nil {
+		return collaborationReadOnlyState{}, fmt.Errorf("GATEWAY_COLLAB_READ_ONLY_TENANTS: %w", err)
+	}
+	return collaborationReadOnlyState{Global: global, Tenants: tenants, Source: collaborationReadOnlySourceConfig}, nil
+}
+
+// ValidateCollaborationReadOnlyConfig checks the GATEWAY_COLLAB_READ_ONLY*
+// settings at startup.
+func ValidateCollaborationReadOnlyConfig() error {
+	_, err := parseCollaborationReadOnlyConfig()
+	return err
+}
@@ -0,0 +1,173 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+func useCollaborationReadOnly(t *testing.T, global, tenants string) *collaborationReadOnly {
+	t.Helper()
+	t.Setenv("GATEWAY_COLLAB_READ_ONLY", global)
+	t.Setenv("GATEWAY_COLLAB_READ_ONLY_TENANTS", tenants)
+	t.Setenv("GATEWAY_STORAGE_URL", "memory://")
+	resetStateStorage()
+	resetCollaborationReadOnly()
+	t.Cleanup(func() {
+		resetCollaborationReadOnly()
+		resetStateStorage()
+	})
+	readOnly, err := loadCollaborationReadOnly()
+	if err != nil {
+		t.Fatalf("load read-only switch: %v", err)
+	}
+	return readOnly
+}
+
+func TestCollaborationReadOnlyConfig(t *testing.T) {
+	readOnly := useCollaborationReadOnly(t, "false", " globex, acme ,acme")
+	state := readOnly.current(context.Background())
+	if state.Global || state.Source != collaborationReadOnlySourceConfig || strings.Join(state.Tenants, ",") != "acme,globex" {
+		t.Fatalf("unexpected state %+v", state)
+	}
+	if !state.appliesTo("acme") || state.appliesTo("initech") || state.appliesTo("") {
+		t.Fatalf("expected only listed tenants to be read-only, got %+v", state)
+	}
+	if !(collaborationReadOnlyState{Global: true}).appliesTo("") {
+		t.Fatal("expected the global switch to cover every connect")
+	}
+
+	for _, env := range []map[string]string{
+		{"GATEWAY_COLLAB_READ_ONLY": "sometimes"},
+		{"GATEWAY_COLLAB_READ_ONLY": "false", "GATEWAY_COLLAB_READ_ONLY_TENANTS": "acme,bad tenant"},
+	} {
+		for key, value := range env {
+			t.Setenv(key, value)
+		}
+		if err := ValidateCollaborationReadOnlyConfig(); err == nil {
+			t.Fatalf("expected %v to be rejected", env)
+		}
+	}
+}
+
+func TestCollaborationReadOnlyOverride(t *testing.T) {
+	readOnly := useCollaborationReadOnly(t, "false", "")
+	ctx := context.Background()
+
+	if _, err := readOnly.override(ctx, false, []string{"acme", "bad tenant"}); !errors.Is(err, errInvalidReadOnlyTenant) {
+		t.Fatalf("expected invalid tenant error, got %v", err)
+	}
+	if _, err := readOnly.override(ctx, true, nil); err != nil {
+		t.Fatalf("override: %v", err)
+	}
+	if state := readOnly.current(ctx); !state.Global || state.Source != collaborationReadOnlySourceOverride {
+		t.Fatalf("expected the override to apply, got %+v", state)
+	}
+	if _, err := readOnly.clear(ctx); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	if state := readOnly.current(ctx); state.Global || state.Source != collaborationReadOnlySourceConfig {
+		t.Fatalf("expected the configuration to apply again, got %+v", state)
+	}
+}
+
+func TestCollaborationReadOnlyFallsBackWhenStoreFails(t *testing.T) {
+	readOnly := &collaborationReadOnly{
+		defaults: collaborationReadOnlyState{Tenants: []string{"acme"}, Source: collaborationReadOnlySourceConfig},
+		store:    &recordingStore{Store: storage.NewMemoryStore(), err: errors.New("connection refused")},
+	}
+	if state := readOnly.current(context.Background()); !state.appliesTo("acme") {
+		t.Fatalf("expected the configured state, got %+v", state)
+	}
+}
+
+func TestCollaborationReadOnlyMiddleware(t *testing.T) {
+	readOnly := useCollaborationReadOnly(t, "false", "acme")
+	logs := captureAuditLogs(t)
+	var forwarded string
+	handler := collaborationReadOnlyMiddleware(readOnly, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(collaborationReadOnlyHeader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(tenant, intent string) *httptest.ResponseRecorder {
+		forwarded = ""
+		req := httptest.NewRequest(http.MethodGet, "/collaboration/ws?filePath=docs/a.md&intent="+intent, nil)
+		req.Header.Set("X-Project-Id", "web")
+		req.Header.Set(collaborationReadOnlyHeader, "false")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(withRequestScope(req.Context(), requestScope{TenantID: tenant})))
+		return rec
+	}
+
+	if rec := serve("acme", "view"); rec.Code != http.StatusNoContent || forwarded != "true" {
+		t.Fatalf("expected a read-only view session, got %d with header %q", rec.Code, forwarded)
+	}
+	if rec := serve("globex", "edit"); rec.Code != http.StatusNoContent || forwarded != "false" {
+		t.Fatalf("expected other tenants to edit, got %d with header %q", rec.Code, forwarded)
+	}
+
+	rec := serve("acme", "EDIT")
+	if rec.Code != http.StatusForbidden || forwarded != "" {
+		t.Fatalf("expected edit intent to be rejected, got %d", rec.Code)
+	}
+	var body httpErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != "forbidden" {
+		t.Fatalf("unexpected error body %s", rec.Body.String())
+	}
+	logged := logs.String()
+	for _, want := range []string{auditEventCollaborationConnect, `"reason":"read_only"`, `"intent":"edit"`} {
+		if !strings.Contains(logged, want) {
+			t.Fatalf("expected audit log to contain %s, got %s", want, logged)
+		}
+	}
+}
+
+func TestAdminCollaborationReadOnly(t *testing.T) {
+	readOnly := useCollaborationReadOnly(t, "false", "")
+	logs := captureAuditLogs(t)
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/collaboration/read-only", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodPut, `{"tenants":["acme"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if state := readOnly.current(context.Background()); !state.appliesTo("acme") || state.Global {
+		t.Fatalf("expected acme to be read-only, got %+v", state)
+	}
+	if !strings.Contains(logs.String(), auditEventCollaborationReadOnly) || strings.Contains(logs.String(), `"acme"`) {
+		t.Fatalf("expected a hashed audit record, got %s", logs.String())
+	}
+
+	for _, body := range []string{`{"tenants":["bad tenant"]}`, `{"global":"yes"}`, `{"everyone":true}`} {
+		if rec := serve(http.MethodPut, body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be rejected, got %d", body, rec.Code)
+		}
+	}
+
+	rec = serve(http.MethodGet, "")
+	var state collaborationReadOnlyState
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil || state.Source != collaborationReadOnlySourceOverride {
+		t.Fatalf("unexpected state %s", rec.Body.String())
+	}
+
+	if rec := serve(http.MethodDelete, ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if state := readOnly.current(context.Background()); state.appliesTo("acme") {
+		t.Fatalf("expected the override to be removed, got %+v", state)
+	}
+}
@@ -0,0 +1,185 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const (
+	auditEventCollaborationSessionEnd = "collaboration.websocket.session_end"
+
+	defaultCollaborationRevalidateInterval = 5 * time.Minute
+
+	// Close codes sent when the session behind a connection ends, in the
+	// private-use range and mirroring the matching HTTP statuses.
+	websocketCloseSessionExpired = 4401
+	websocketCloseSessionRevoked = 4403
+
+	collaborationSessionExpired = "session_expired"
+	collaborationSessionRevoked = "session_revoked"
+)
+
+// collaborationRevalidation re-checks the session behind each open
+// /collaboration/ws connection every interval, bypassing the session cache,
+// and closes the connection once the orchestrator no longer accepts it.
+// Connections whose session reported an expiry are also closed when it
+// passes. Lookup errors leave the connection open until the next check.
+type collaborationRevalidation struct {
+	interval time.Duration
+	lookup   collaborationSessionValidator
+	now      func() time.Time
+}
+
+func loadCollaborationRevalidation() collaborationRevalidation {
+	return collaborationRevalidation{
+		interval: GetDurationEnv("GATEWAY_COLLAB_SESSION_REVALIDATE_INTERVAL", defaultCollaborationRevalidateInterval),
+		lookup:   lookupOrchestratorSession,
+		now:      clockNow,
+	}
+}
+
+// collaborationRevalidationMiddleware runs after collaborationAuthMiddleware,
+// which resolved the session and its expiry.
+func collaborationRevalidationMiddleware(rv collaborationRevalidation, next http.Handler) http.Handler {
+	if rv.interval <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &revalidationResponseWriter{ResponseWriter: w, revalidation: rv, request: r}
+		defer rw.stop()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+type revalidationResponseWriter struct {
+	http.ResponseWriter
+	revalidation collaborationRevalidation
+	request      *http.Request
+	conn         *revalidatingConn
+}
+
+func (rw *revalidationResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack wraps the client connection so checks start once the upgrade is
+// handed off to the proxy.
+func (rw *revalidationResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	rw.conn = &revalidatingConn{Conn: conn, done: make(chan struct{})}
+	go rw.conn.watch(rw.revalidation, rw.request)
+	return rw.conn, brw, nil
+}
+
+func (rw *revalidationResponseWriter) stop() {
+	if rw.conn != nil {
+		rw.conn.stop()
+	}
+}
+
+// revalidatingConn serialises writes so the close frame is never interleaved
+// with a proxied write.
+type revalidatingConn struct {
+	net.Conn
+	mu       sync.Mutex
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func (c *revalidatingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *revalidatingConn) stop() {
+	c.stopOnce.Do(func() { close(c.done) })
+}
+
+func (c *revalidatingConn) watch(rv collaborationRevalidation, r *http.Request) {
+	ticker := time.NewTicker(rv.interval)
+	defer ticker.Stop()
+	var expiry *time.Timer
+	var expired <-chan time.Time
+	expiresAt, hasExpiry := sessionExpiry(r.Context())
+	setExpiry := func(at time.Time) {
+		if expiry != nil {
+			expiry.Stop()
+		}
+		expiresAt, hasExpiry = at, true
+		expiry = time.NewTimer(max(at.Sub(rv.now()), 0))
+		expired = expiry.C
+	}
+	defer func() {
+		if expiry != nil {
+			expiry.Stop()
+		}
+	}()
+	if hasExpiry {
+		setExpiry(expiresAt)
+	}
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-expired:
+			c.end(r, collaborationSessionExpired, websocketCloseSessionExpired)
+			return
+		case <-ticker.C:
+			session, ended := rv.check(r)
+			if ended {
+				if hasExpiry && !rv.now().Before(expiresAt) {
+					c.end(r, collaborationSessionExpired, websocketCloseSessionExpired)
+				} else {
+					c.end(r, collaborationSessionRevoked, websocketCloseSessionRevoked)
+				}
+				return
+			}
+			// Sliding sessions move their expiry forward on use.
+			if next, ok := session.expiry(); ok && (!hasExpiry || !next.Equal(expiresAt)) {
+				setExpiry(next)
+			}
+		}
+	}
+}
+
+// check asks the orchestrator about the connection's credentials. It reports
+// the session ended when they are rejected or now resolve to another
+// session, which also drops any cached entry for them.
+func (rv collaborationRevalidation) check(r *http.Request) (orchestratorSession, bool) {
+	ctx := r.Context()
+	authHeader := strings.TrimSpace(r.Header.Get("Authorization"))
+	cookieHeader := strings.TrimSpace(r.Header.Get("Cookie"))
+	session, status, err := rv.lookup(ctx, authHeader, cookieHeader, audit.RequestID(ctx))
+	ended := status == http.StatusUnauthorized || (err == nil && status == http.StatusOK && session.ID != requestScopeFrom(ctx).SessionID)
+	if !ended {
+		if err != nil || status != http.StatusOK {
+			collabLog.WarnContext(ctx, "collaboration session revalidation failed; keeping connection", slog.Int("status", status), slog.Any("error", err))
+		}
+		return session, false
+	}
+	if cache, _ := loadCollaborationSessionCache(); cache != nil {
+		cache.invalidate(ctx, authHeader, cookieHeader)
+	}
+	return session, true
+}
+
+func (c *revalidatingConn) end(r *http.Request, reason string, code uint16) {
+	recordCollaborationEvent(context.WithoutCancel(r.Context()), r, auditEventCollaborationSessionEnd, auditOutcomeDenied, map[string]any{"reason": reason})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = c.Conn.Write(websocketCloseFrame(code, reason))
+	_ = c.Conn.Close()
+}
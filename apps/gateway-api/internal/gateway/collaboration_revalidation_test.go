@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// watchRevalidation starts rv on one end of a pipe and returns the close
+// code the client end receives, or 0 if the connection is still open after
+// wait.
+func watchRevalidation(t *testing.T, ctx context.Context, rv collaborationRevalidation, wait time.Duration) uint16 {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+	r := httptest.NewRequest(http.MethodGet, "/collaboration/ws", nil).WithContext(withRequestScope(ctx, requestScope{SessionID: "s1"}))
+	r.Header.Set("Authorization", "Bearer token")
+	conn := &revalidatingConn{Conn: server, done: make(chan struct{})}
+	t.Cleanup(conn.stop)
+	go conn.watch(rv, r)
+
+	_ = client.SetReadDeadline(time.Now().Add(wait))
+	frame := make([]byte, 4)
+	if _, err := io.ReadFull(client, frame); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return 0
+		}
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if frame[0] != 0x88 {
+		t.Fatalf("expected a close frame, got %x", frame)
+	}
+	return binary.BigEndian.Uint16(frame[2:])
+}
+
+func TestCollaborationRevalidationClosesRevokedSessions(t *testing.T) {
+	logs := captureAuditLogs(t)
+	var calls atomic.Int32
+	rv := collaborationRevalidation{
+		interval: 10 * time.Millisecond,
+		now:      time.Now,
+		lookup: func(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+			if authHeader != "Bearer token" {
+				t.Errorf("expected the connection's credentials, got %q", authHeader)
+			}
+			if calls.Add(1) < 3 {
+				return orchestratorSession{ID: "s1"}, http.StatusOK, nil
+			}
+			return orchestratorSession{}, http.StatusUnauthorized, nil
+		},
+	}
+	if code := watchRevalidation(t, context.Background(), rv, 5*time.Second); code != websocketCloseSessionRevoked {
+		t.Fatalf("expected close code %d, got %d", websocketCloseSessionRevoked, code)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected the session to be checked until rejected, got %d checks", calls.Load())
+	}
+	if !strings.Contains(logs.String(), `"event":"collaboration.websocket.session_end"`) || !strings.Contains(logs.String(), `"reason":"session_revoked"`) {
+		t.Fatalf("expected a session_end audit event, got %s", logs.String())
+	}
+}
+
+func TestCollaborationRevalidationClosesExpiredSessions(t *testing.T) {
+	captureAuditLogs(t)
+	rv := collaborationRevalidation{
+		interval: time.Hour,
+		now:      time.Now,
+		lookup: func(context.Context, string, string, string) (orchestratorSession, int, error) {
+			t.Error("expected expiry to close the connection without a lookup")
+			return orchestratorSession{}, http.StatusOK, nil
+		},
+	}
+	ctx := withSessionExpiry(context.Background(), orchestratorSession{ExpiresAt: time.Now().Add(20 * time.Millisecond).Format(time.RFC3339Nano)})
+	if code := watchRevalidation(t, ctx, rv, 5*time.Second); code != websocketCloseSessionExpired {
+		t.Fatalf("expected close code %d, got %d", websocketCloseSessionExpired, code)
+	}
+
+	// A rejection after the reported expiry counts as expiry, not revocation.
+	rv.interval = 10 * time.Millisecond
+	var clockCalls atomic.Int32
+	rv.now = func() time.Time {
+		// The expiry timer is armed on the first call; the check sees the
+		// session already lapsed.
+		if clockCalls.Add(1) == 1 {
+			return time.Now()
+		}
+		return time.Now().Add(time.Hour)
+	}
+	rv.lookup = func(context.Context, string, string, string) (orchestratorSession, int, error) {
+		return orchestratorSession{}, http.StatusUnauthorized, nil
+	}
+	ctx = withSessionExpiry(context.Background(), orchestratorSession{ExpiresAt: time.Now().Add(30 * time.Minute).Format(time.RFC3339)})
+	if code := watchRevalidation(t, ctx, rv, 5*time.Second); code != websocketCloseSessionExpired {
+		t.Fatalf("expected close code %d, got %d", websocketCloseSessionExpired, code)
+	}
+}
+
+func TestCollaborationRevalidationKeepsConnectionOnLookupErrors(t *testing.T) {
+	rv := collaborationRevalidation{
+		interval: 5 * time.Millisecond,
+		now:      time.Now,
+		lookup: func(context.Context, string, string, string) (orchestratorSession, int, error) {
+			return orchestratorSession{}, http.StatusBadGateway, errors.New("orchestrator unavailable")
+		},
+	}
+	if code := watchRevalidation(t, context.Background(), rv, 100*time.Millisecond); code != 0 {
+		t.Fatalf("expected the connection to stay open, got close code %d", code)
+	}
+}
@@ -0,0 +1,232 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+// Modes accepted in GATEWAY_COLLAB_SESSION_CACHE.
+const (
+	collaborationSessionDirect = "direct"
+	collaborationSessionCached = "cached"
+)
+
+const (
+	defaultCollaborationSessionCacheTTL = 30 * time.Second
+	collaborationSessionCacheNamespace  = "collab_session"
+	collaborationSessionGenerationKey   = "generation"
+)
+
+// collaborationSessionValidator resolves the session behind a WebSocket
+// connect's credentials, returning the orchestrator's status for them.
+type collaborationSessionValidator func(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error)
+
+// collaborationSessionCache remembers sessions the orchestrator accepted for
+// a short TTL, keyed by a hash of the credentials that resolved them. Entries
+// live in the shared state store, so a Redis GATEWAY_STORAGE_URL shares them
+// across replicas. Every key carries the current generation; flushing starts
+// a new generation and leaves the old entries to expire.
+type collaborationSessionCache struct {
+	store  storage.Store
+	ttl    time.Duration
+	lookup collaborationSessionValidator
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	invalidations atomic.Int64
+	flushes       atomic.Int64
+	revocations   atomic.Int64
+}
+
+type collaborationSessionCacheStats struct {
+	Mode          string  `json:"mode"`
+	TTLSeconds    float64 `json:"ttl_seconds,omitempty"`
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	Invalidations int64   `json:"invalidations"`
+	Flushes       int64   `json:"flushes"`
+	Revocations   int64   `json:"revocations"`
+}
+
+func (c *collaborationSessionCache) validate(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+	key, err := c.entryKey(ctx, authHeader, cookieHeader)
+	if err != nil {
+		collabLog.WarnContext(ctx, "collaboration session cache unavailable; validating directly", slog.Any("error", err))
+		return c.lookup(ctx, authHeader, cookieHeader, requestID)
+	}
+
+	data, err := c.store.Get(ctx, key)
+	switch {
+	case err == nil:
+		var session orchestratorSession
+		if json.Unmarshal(data, &session) == nil && session.ID != "" {
+			if !c.revoked(ctx, session) {
+				c.hits.Add(1)
+				return session, http.StatusOK, nil
+			}
+			c.delete(ctx, key)
+		}
+	case !errors.Is(err, storage.ErrNotFound):
+		collabLog.WarnContext(ctx, "collaboration session cache read failed", slog.Any("error", err))
+	}
+	c.misses.Add(1)
+
+	session, status, err := c.lookup(ctx, authHeader, cookieHeader, requestID)
+	switch {
+	case err == nil && status == http.StatusOK:
+		encoded, _ := json.Marshal(session)
+		if err := c.store.Set(ctx, key, encoded, c.ttl); err != nil {
+			collabLog.WarnContext(ctx, "collaboration session cache write failed", slog.Any("error", err))
+		}
+	case status == http.StatusUnauthorized:
+		c.delete(ctx, key)
+	}
+	return session, status, err
+}
+
+// invalidate drops the entry for the given credentials, for example when the
+// orchestrator rejects them after the gateway accepted a cached session.
+func (c *collaborationSessionCache) invalidate(ctx context.Context, authHeader, cookieHeader string) {
+	key, err := c.entryKey(ctx, authHeader, cookieHeader)
+	if err != nil {
+		collabLog.WarnContext(ctx, "collaboration session cache invalidation failed", slog.Any("error", err))
+		return
+	}
+	c.delete(ctx, key)
+}
+
+func (c *collaborationSessionCache) delete(ctx context.Context, key string) {
+	if err := c.store.Delete(ctx, key); err != nil {
+		collabLog.WarnContext(ctx, "collaboration session cache invalidation failed", slog.Any("error", err))
+		return
+	}
+	c.invalidations.Add(1)
+}
+
+// flush makes every cached session unreachable on all replicas sharing the
+// store.
+func (c *collaborationSessionCache) flush(ctx context.Context) error {
+	generation, err := randomString(12)
+	if err != nil {
+		return err
+	}
+	if err := c.store.Set(ctx, collaborationSessionGenerationKey, []byte(generation), 0); err != nil {
+		return err
+	}
+	c.flushes.Add(1)
+	return nil
+}
+
+func (c *collaborationSessionCache) entryKey(ctx context.Context, authHeader, cookieHeader string) (string, error) {
+	generation, err := c.store.Get(ctx, collaborationSessionGenerationKey)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		generation = []byte("0")
+	case err != nil:
+		return "", err
+	}
+	return string(generation) + ":" + collaborationCredentialHash(authHeader, cookieHeader), nil
+}
+
+func (c *collaborationSessionCache) stats() collaborationSessionCacheStats {
+	return collaborationSessionCacheStats{
+		Mode:          collaborationSessionCached,
+		TTLSeconds:    c.ttl.Seconds(),
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Invalidations: c.invalidations.Load(),
+		Flushes:       c.flushes.Load(),
+		Revocations:   c.revocations.Load(),
+	}
+}
+
+// collaborationCredentialHash keys the cache so raw bearer tokens and cookies
+// are never written to the store.
+func collaborationCredentialHash(authHeader, cookieHeader string) string {
+	sum := sha256.Sum256([]byte("authorization\x00" + authHeader + "\x00cookie\x00" + cookieHeader))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	collaborationSessionCacheMu   sync.Mutex
+	collaborationSessionCacheOnce sync.Once
+	collaborationSessions         *collaborationSessionCache
+	collaborationSessionsErr      error
+)
+
+// resetCollaborationSessionCache clears the cached configuration for tests.
+func resetCollaborationSessionCache() {
+	collaborationSessionCacheMu.Lock()
+	defer collaborationSessionCacheMu.Unlock()
+	collaborationSessionCacheOnce = sync.Once{}
+	collaborationSessions = nil
+	collaborationSessionsErr = nil
+}
+
+// loadCollaborationSessionCache returns the session cache, or nil when
+// GATEWAY_COLLAB_SESSION_CACHE selects direct validation.
+func loadCollaborationSessionCache() (*collaborationSessionCache, error) {
+	collaborationSessionCacheMu.Lock()
+	defer collaborationSessionCacheMu.Unlock()
+	collaborationSessionCacheOnce.Do(func() {
+		mode, ttl, err := parseCollaborationSessionCacheConfig()
+		if err != nil || mode == collaborationSessionDirect {
+			collaborationSessionsErr = err
+			return
+		}
+		store, err := loadStateStorage()
+		if err != nil {
+			collaborationSessionsErr = err
+			return
+		}
+		collaborationSessions = &collaborationSessionCache{
+			store:  storage.Namespace(store, collaborationSessionCacheNamespace),
+			ttl:    ttl,
+			lookup: lookupOrchestratorSession,
+		}
+	})
+	return collaborationSessions, collaborationSessionsErr
+}
+
+func parseCollaborationSessionCacheConfig() (string, time.Duration, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("GATEWAY_COLLAB_SESSION_CACHE")))
+	switch mode {
+	case "":
+		mode = collaborationSessionDirect
+	case collaborationSessionDirect, collaborationSessionCached:
+	default:
+		return "", 0, fmt.Errorf("unsupported GATEWAY_COLLAB_SESSION_CACHE %q (expected %s or %s)", mode, collaborationSessionDirect, collaborationSessionCached)
+	}
+	ttl := GetDurationEnv("GATEWAY_COLLAB_SESSION_CACHE_TTL", defaultCollaborationSessionCacheTTL)
+	if ttl <= 0 {
+		return "", 0, errors.New("GATEWAY_COLLAB_SESSION_CACHE_TTL must be positive")
+	}
+	return mode, ttl, nil
+}
+
+// ValidateCollaborationSessionCacheConfig checks the
+// GATEWAY_COLLAB_SESSION_CACHE* settings at startup.
+func ValidateCollaborationSessionCacheConfig() error {
+	_, _, err := parseCollaborationSessionCacheConfig()
+	return err
+}
+
+func collaborationSessionCacheStatus() collaborationSessionCacheStats {
+	if cache, _ := loadCollaborationSessionCache(); cache != nil {
+		return cache.stats()
+	}
+	return collaborationSessionCacheStats{Mode: collaborationSessionDirect}
+}
@@ -0,0 +1,230 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+// recordingStore wraps a store and remembers the keys written to it.
+type recordingStore struct {
+	storage.Store
+	mu   sync.Mutex
+	keys []string
+	err  error
+}
+
+func (s *recordingStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Store.Get(ctx, key)
+}
+
+func (s *recordingStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	s.keys = append(s.keys, key)
+	s.mu.Unlock()
+	return s.Store.Set(ctx, key, value, ttl)
+}
+
+type countingLookup struct {
+	mu     sync.Mutex
+	calls  int
+	status int
+}
+
+func (l *countingLookup) lookup(context.Context, string, string, string) (orchestratorSession, int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	if l.status != http.StatusOK {
+		return orchestratorSession{}, l.status, nil
+	}
+	return orchestratorSession{ID: "session-1", TenantID: ptr("acme")}, http.StatusOK, nil
+}
+
+func newTestCollaborationSessionCache(store storage.Store, lookup *countingLookup) *collaborationSessionCache {
+	return &collaborationSessionCache{store: store, ttl: time.Minute, lookup: lookup.lookup}
+}
+
+func TestCollaborationSessionCacheReusesAcceptedSessions(t *testing.T) {
+	store := &recordingStore{Store: storage.NewMemoryStore()}
+	lookup := &countingLookup{status: http.StatusOK}
+	cache := newTestCollaborationSessionCache(store, lookup)
+	ctx := context.Background()
+
+	for range 3 {
+		session, status, err := cache.validate(ctx, "Bearer token-1", "", "req")
+		if err != nil || status != http.StatusOK || session.ID != "session-1" || session.TenantID == nil || *session.TenantID != "acme" {
+			t.Fatalf("unexpected result %+v %d %v", session, status, err)
+		}
+	}
+	if lookup.calls != 1 {
+		t.Fatalf("expected a single orchestrator lookup, got %d", lookup.calls)
+	}
+	if _, _, _ = cache.validate(ctx, "Bearer token-2", "", "req"); lookup.calls != 2 {
+		t.Fatalf("expected other credentials to miss, got %d lookups", lookup.calls)
+	}
+	for _, key := range store.keys {
+		if strings.Contains(key, "token-") {
+			t.Fatalf("expected credentials to be hashed, got key %q", key)
+		}
+	}
+	if stats := cache.stats(); stats.Hits != 2 || stats.Misses != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCollaborationSessionCacheInvalidation(t *testing.T) {
+	lookup := &countingLookup{status: http.StatusOK}
+	cache := newTestCollaborationSessionCache(storage.NewMemoryStore(), lookup)
+	ctx := context.Background()
+
+	_, _, _ = cache.validate(ctx, "Bearer token", "", "req")
+	cache.invalidate(ctx, "Bearer token", "")
+	_, _, _ = cache.validate(ctx, "Bearer token", "", "req")
+	if lookup.calls != 2 {
+		t.Fatalf("expected invalidated entry to be looked up again, got %d lookups", lookup.calls)
+	}
+
+	if err := cache.flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	_, _, _ = cache.validate(ctx, "Bearer token", "", "req")
+	if lookup.calls != 3 {
+		t.Fatalf("expected flushed entry to be looked up again, got %d lookups", lookup.calls)
+	}
+
+	lookup.status = http.StatusUnauthorized
+	_, _, _ = cache.validate(ctx, "Bearer other", "", "req")
+	if _, status, _ := cache.validate(ctx, "Bearer other", "", "req"); status != http.StatusUnauthorized || lookup.calls != 5 {
+		t.Fatalf("expected rejected sessions not to be cached, status %d after %d lookups", status, lookup.calls)
+	}
+}
+
+func TestCollaborationSessionCacheFallsBackWhenStoreFails(t *testing.T) {
+	store := &recordingStore{Store: storage.NewMemoryStore(), err: errors.New("connection refused")}
+	lookup := &countingLookup{status: http.StatusOK}
+	cache := newTestCollaborationSessionCache(store, lookup)
+
+	for range 2 {
+		if _, status, err := cache.validate(context.Background(), "Bearer token", "", "req"); err != nil || status != http.StatusOK {
+			t.Fatalf("expected direct validation, got %d %v", status, err)
+		}
+	}
+	if lookup.calls != 2 {
+		t.Fatalf("expected every connect to reach the orchestrator, got %d", lookup.calls)
+	}
+}
+
+func useCollaborationSessionCache(t *testing.T, mode string) {
+	t.Helper()
+	t.Setenv("GATEWAY_COLLAB_SESSION_CACHE", mode)
+	t.Setenv("GATEWAY_STORAGE_URL", "memory://")
+	resetStateStorage()
+	resetCollaborationSessionCache()
+	t.Cleanup(func() {
+		resetCollaborationSessionCache()
+		resetStateStorage()
+	})
+}
+
+func TestLoadCollaborationSessionCache(t *testing.T) {
+	useCollaborationSessionCache(t, "")
+	if cache, err := loadCollaborationSessionCache(); err != nil || cache != nil {
+		t.Fatalf("expected direct validation by default, got %v %v", cache, err)
+	}
+
+	useCollaborationSessionCache(t, "cached")
+	t.Setenv("GATEWAY_COLLAB_SESSION_CACHE_TTL", "10s")
+	cache, err := loadCollaborationSessionCache()
+	if err != nil || cache == nil || cache.ttl != 10*time.Second {
+		t.Fatalf("unexpected cache %+v %v", cache, err)
+	}
+
+	for _, env := range []map[string]string{
+		{"GATEWAY_COLLAB_SESSION_CACHE": "redis"},
+		{"GATEWAY_COLLAB_SESSION_CACHE": "cached", "GATEWAY_COLLAB_SESSION_CACHE_TTL": "0s"},
+	} {
+		for key, value := range env {
+			t.Setenv(key, value)
+		}
+		if err := ValidateCollaborationSessionCacheConfig(); err == nil {
+			t.Fatalf("expected %v to be rejected", env)
+		}
+	}
+}
+
+func TestCollaborationProxyInvalidatesRejectedSessions(t *testing.T) {
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer orchestrator.Close()
+	t.Setenv("ORCHESTRATOR_URL", orchestrator.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+	useCollaborationSessionCache(t, "cached")
+
+	cache, err := loadCollaborationSessionCache()
+	if err != nil || cache == nil {
+		t.Fatalf("load cache: %v", err)
+	}
+	lookup := &countingLookup{status: http.StatusOK}
+	cache.lookup = lookup.lookup
+	_, _, _ = cache.validate(context.Background(), "Bearer token", "", "req")
+
+	req := httptest.NewRequest(http.MethodGet, "/collaboration/ws?filePath=a.txt", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	newCollaborationProxy().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected upstream 401 to pass through, got %d", rec.Code)
+	}
+
+	_, _, _ = cache.validate(context.Background(), "Bearer token", "", "req")
+	if lookup.calls != 2 {
+		t.Fatalf("expected the rejected session to be invalidated, got %d lookups", lookup.calls)
+	}
+}
+
+func TestAdminFlushesCollaborationSessions(t *testing.T) {
+	useCollaborationSessionCache(t, "cached")
+	cache, err := loadCollaborationSessionCache()
+	if err != nil || cache == nil {
+		t.Fatalf("load cache: %v", err)
+	}
+	lookup := &countingLookup{status: http.StatusOK}
+	cache.lookup = lookup.lookup
+	_, _, _ = cache.validate(context.Background(), "Bearer token", "", "req")
+
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+	req := httptest.NewRequest(http.MethodPost, "/admin/collaboration/sessions/flush", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var stats collaborationSessionCacheStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if stats.Mode != collaborationSessionCached || stats.Flushes != 1 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	_, _, _ = cache.validate(context.Background(), "Bearer token", "", "req")
+	if lookup.calls != 2 {
+		t.Fatalf("expected flushed session to be looked up again, got %d lookups", lookup.calls)
+	}
+}
@@ -2,22 +2,18 @@ package gateway
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
-	"net/url"
 	"strings"
 	"testing"
 	"time"
 )
 
 func TestCollaborationProxyPreservesQuery(t *testing.T) {
-	target, err := url.Parse("http://orchestrator:4000")
-	if err != nil {
-		t.Fatalf("failed to parse target: %v", err)
-	}
-
-	proxy := newCollaborationProxy(target)
+	t.Setenv("ORCHESTRATOR_URL", "http://orchestrator:4000")
+	proxy := newCollaborationProxy()
 
 	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=example.txt", nil)
 	out := req.Clone(req.Context())
@@ -33,12 +29,8 @@ func TestCollaborationProxyPreservesQuery(t *testing.T) {
 }
 
 func TestCollaborationProxySetsForwardedHeaders(t *testing.T) {
-	target, err := url.Parse("http://orchestrator:4000")
-	if err != nil {
-		t.Fatalf("failed to parse target: %v", err)
-	}
-
-	proxy := newCollaborationProxy(target)
+	t.Setenv("ORCHESTRATOR_URL", "http://orchestrator:4000")
+	proxy := newCollaborationProxy()
 
 	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=example.txt", nil)
 	req.RemoteAddr = "203.0.113.10:12345"
@@ -60,11 +52,11 @@ func TestCollaborationProxySetsForwardedHeaders(t *testing.T) {
 
 func TestCollaborationAuthMiddlewareValidatesSession(t *testing.T) {
 	var called bool
-	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (collaborationSession, int, error) {
-		return collaborationSession{ID: "session-123"}, http.StatusOK, nil
+	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+		return orchestratorSession{ID: "session-123"}, http.StatusOK, nil
 	}
 
-	handler := collaborationAuthMiddleware(validator, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := collaborationAuthMiddleware(validator, nil, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		called = true
 		w.WriteHeader(http.StatusNoContent)
 	}))
@@ -89,13 +81,14 @@ func TestCollaborationAuthMiddlewareValidatesSession(t *testing.T) {
 func TestCollaborationAuthMiddlewareAcceptsQueryIdentity(t *testing.T) {
 	tenant := "tenant-1"
 	var capturedSessionID, capturedTenantID, capturedProjectID string
-	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (collaborationSession, int, error) {
-		return collaborationSession{ID: "session-123", TenantID: &tenant}, http.StatusOK, nil
+	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+		return orchestratorSession{ID: "session-123", TenantID: &tenant}, http.StatusOK, nil
 	}
 
-	handler := collaborationAuthMiddleware(validator, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		capturedSessionID = r.Header.Get("X-Session-Id")
-		capturedTenantID = r.Header.Get("X-Tenant-Id")
+	handler := collaborationAuthMiddleware(validator, nil, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := requestScopeFrom(r.Context())
+		capturedSessionID = scope.SessionID
+		capturedTenantID = scope.TenantID
 		capturedProjectID = r.Header.Get("X-Project-Id")
 		w.WriteHeader(http.StatusNoContent)
 	}))
@@ -110,16 +103,16 @@ func TestCollaborationAuthMiddlewareAcceptsQueryIdentity(t *testing.T) {
 		t.Fatalf("expected status %d, got %d", http.StatusNoContent, rr.Code)
 	}
 	if capturedSessionID != "session-123" || capturedTenantID != tenant || capturedProjectID != "project-1" {
-		t.Fatalf("expected headers to be populated from session/query, got session=%q tenant=%q project=%q", capturedSessionID, capturedTenantID, capturedProjectID)
+		t.Fatalf("expected the identity to be resolved from session/query, got session=%q tenant=%q project=%q", capturedSessionID, capturedTenantID, capturedProjectID)
 	}
 }
 
 func TestCollaborationAuthMiddlewareRejectsInvalidSession(t *testing.T) {
-	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (collaborationSession, int, error) {
-		return collaborationSession{}, http.StatusUnauthorized, nil
+	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+		return orchestratorSession{}, http.StatusUnauthorized, nil
 	}
 
-	handler := collaborationAuthMiddleware(validator, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	handler := collaborationAuthMiddleware(validator, nil, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
 
 	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=example.txt", nil)
 	req.Header.Set("X-Tenant-Id", "tenant-1")
@@ -137,11 +130,11 @@ func TestCollaborationAuthMiddlewareRejectsInvalidSession(t *testing.T) {
 
 func TestCollaborationAuthMiddlewareRejectsTenantMismatch(t *testing.T) {
 	mismatch := "other-tenant"
-	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (collaborationSession, int, error) {
-		return collaborationSession{ID: "session-123", TenantID: &mismatch}, http.StatusOK, nil
+	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+		return orchestratorSession{ID: "session-123", TenantID: &mismatch}, http.StatusOK, nil
 	}
 
-	handler := collaborationAuthMiddleware(validator, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	handler := collaborationAuthMiddleware(validator, nil, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
 
 	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=example.txt", nil)
 	req.Header.Set("X-Tenant-Id", "tenant-1")
@@ -158,11 +151,11 @@ func TestCollaborationAuthMiddlewareRejectsTenantMismatch(t *testing.T) {
 }
 
 func TestCollaborationAuthMiddlewareRejectsSessionMismatch(t *testing.T) {
-	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (collaborationSession, int, error) {
-		return collaborationSession{ID: "session-expected"}, http.StatusOK, nil
+	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+		return orchestratorSession{ID: "session-expected"}, http.StatusOK, nil
 	}
 
-	handler := collaborationAuthMiddleware(validator, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	handler := collaborationAuthMiddleware(validator, nil, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
 
 	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=example.txt", nil)
 	req.Header.Set("X-Tenant-Id", "tenant-1")
@@ -190,7 +183,10 @@ func TestNewCollaborationSessionValidatorPropagatesHeaders(t *testing.T) {
 	}))
 	t.Cleanup(server.Close)
 
-	validator := newCollaborationSessionValidator(server.URL)
+	t.Setenv("ORCHESTRATOR_URL", server.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+	validator := newCollaborationSessionValidator()
 	session, status, err := validator(context.Background(), "Bearer token", "session=abc", "req-123")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -212,7 +208,10 @@ func TestNewCollaborationSessionValidatorHandlesErrors(t *testing.T) {
 	}))
 	t.Cleanup(server.Close)
 
-	validator := newCollaborationSessionValidator(server.URL)
+	t.Setenv("ORCHESTRATOR_URL", server.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+	validator := newCollaborationSessionValidator()
 	_, status, err := validator(context.Background(), "", "", "")
 	if status != http.StatusBadGateway {
 		t.Fatalf("expected status %d, got %d", http.StatusBadGateway, status)
@@ -222,12 +221,30 @@ func TestNewCollaborationSessionValidatorHandlesErrors(t *testing.T) {
 	}
 }
 
+func TestNewCollaborationSessionValidatorRejectsOversizedResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"session":{"id":"session-xyz","padding":"` + strings.Repeat("x", 256) + `"}}`))
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("ORCHESTRATOR_URL", server.URL)
+	t.Setenv("GATEWAY_MAX_UPSTREAM_RESPONSE_BYTES", "128")
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+
+	validator := newCollaborationSessionValidator()
+	_, status, err := validator(context.Background(), "Bearer token", "", "")
+	if status != http.StatusBadGateway || !errors.Is(err, errUpstreamResponseTooLarge) {
+		t.Fatalf("expected 502 with errUpstreamResponseTooLarge, got %d, %v", status, err)
+	}
+}
+
 func TestCollaborationAuthMiddlewareRejectsMissingAuth(t *testing.T) {
-	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (collaborationSession, int, error) {
-		return collaborationSession{ID: "session-123"}, http.StatusOK, nil
+	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+		return orchestratorSession{ID: "session-123"}, http.StatusOK, nil
 	}
 
-	handler := collaborationAuthMiddleware(validator, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	handler := collaborationAuthMiddleware(validator, nil, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
 
 	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=example.txt", nil)
 	req.Header.Set("X-Tenant-Id", "tenant-1")
@@ -243,11 +260,11 @@ func TestCollaborationAuthMiddlewareRejectsMissingAuth(t *testing.T) {
 }
 
 func TestCollaborationAuthMiddlewareRejectsPathTraversal(t *testing.T) {
-	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (collaborationSession, int, error) {
-		return collaborationSession{ID: "session-123"}, http.StatusOK, nil
+	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+		return orchestratorSession{ID: "session-123"}, http.StatusOK, nil
 	}
 
-	handler := collaborationAuthMiddleware(validator, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	handler := collaborationAuthMiddleware(validator, nil, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
 
 	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=../etc/passwd", nil)
 	req.Header.Set("X-Tenant-Id", "tenant-1")
@@ -264,11 +281,11 @@ func TestCollaborationAuthMiddlewareRejectsPathTraversal(t *testing.T) {
 }
 
 func TestCollaborationAuthMiddlewareRejectsInvalidCookie(t *testing.T) {
-	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (collaborationSession, int, error) {
-		return collaborationSession{ID: "session-123"}, http.StatusOK, nil
+	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+		return orchestratorSession{ID: "session-123"}, http.StatusOK, nil
 	}
 
-	handler := collaborationAuthMiddleware(validator, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	handler := collaborationAuthMiddleware(validator, nil, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
 
 	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=example.txt", nil)
 	req.Header.Set("X-Tenant-Id", "tenant-1")
@@ -287,14 +304,14 @@ func TestCollaborationAuthMiddlewareRejectsInvalidCookie(t *testing.T) {
 
 func TestCollaborationAuthMiddlewareRateLimitsFailedAuth(t *testing.T) {
 	base := time.Now()
-	limiter := newRateLimiter()
+	limiter := newRateLimiter("test")
 	limiter.now = func() time.Time { return base }
 
-	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (collaborationSession, int, error) {
-		return collaborationSession{}, http.StatusUnauthorized, nil
+	validator := func(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+		return orchestratorSession{}, http.StatusUnauthorized, nil
 	}
 
-	handler := collaborationAuthMiddleware(validator, limiter, rateLimitBucket{Endpoint: "collaboration.auth_failure", IdentityType: "ip", Window: time.Minute, Limit: 2}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	handler := collaborationAuthMiddleware(validator, nil, limiter, rateLimitBucket{Endpoint: "collaboration.auth_failure", IdentityType: "ip", Window: time.Minute, Limit: 2}, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
 
 	for i := 0; i < 3; i++ {
 		req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=example.txt", nil)
@@ -336,9 +353,10 @@ func TestCollaborationConnectionLimiterReleasesConnections(t *testing.T) {
 		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
 	}
 
-	limiter.mu.Lock()
-	defer limiter.mu.Unlock()
-	if _, ok := limiter.counts["192.0.2.1"]; ok {
+	shard := limiter.shard("192.0.2.1")
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if _, ok := shard.counts["192.0.2.1"]; ok {
 		t.Fatalf("expected connection count to be released")
 	}
 }
@@ -377,9 +395,10 @@ func TestCollaborationConnectionLimiterReleasesOnContextCancel(t *testing.T) {
 		t.Fatalf("handler did not return after context cancel")
 	}
 
-	limiter.mu.Lock()
-	defer limiter.mu.Unlock()
-	if _, ok := limiter.counts["198.51.100.7"]; ok {
+	shard := limiter.shard("198.51.100.7")
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if _, ok := shard.counts["198.51.100.7"]; ok {
 		t.Fatalf("expected connection count to be released after context cancel")
 	}
 }
@@ -541,3 +560,59 @@ func TestValidateCollaborationIdentity(t *testing.T) {
 		})
 	}
 }
+
+func TestCollaborationAuditCarriesSessionBinding(t *testing.T) {
+	bindingHash := hashSessionBinding("bind-123")
+	returned := bindingHash
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"session":{"id":"session-1","tenantId":"acme","bindingIdHash":"` + returned + `"}}`))
+	}))
+	defer orchestrator.Close()
+	t.Setenv("ORCHESTRATOR_URL", orchestrator.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+
+	session, status, err := lookupOrchestratorSession(context.Background(), "Bearer abc", "", "req")
+	if err != nil || status != http.StatusOK || session.BindingIDHash != bindingHash {
+		t.Fatalf("expected the binding hash to be returned, got %+v %d %v", session, status, err)
+	}
+	returned = "bind-123"
+	if session, _, _ := lookupOrchestratorSession(context.Background(), "Bearer abc", "", "req"); session.BindingIDHash != "" {
+		t.Fatalf("expected a malformed binding hash to be dropped, got %q", session.BindingIDHash)
+	}
+
+	logs := captureAuditLogs(t)
+	validator := func(context.Context, string, string, string) (orchestratorSession, int, error) {
+		return orchestratorSession{ID: "session-1", TenantID: ptr("acme"), BindingIDHash: bindingHash}, http.StatusOK, nil
+	}
+	handler := collaborationAuthMiddleware(validator, nil, nil, rateLimitBucket{}, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recordCollaborationAudit(r.Context(), r, auditOutcomeDenied, map[string]any{"reason": "read_only"})
+	}))
+	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=docs/a.md&projectId=web", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := strings.Count(logs.String(), `"binding_id_hash":"`+bindingHash+`"`); got != 2 {
+		t.Fatalf("expected the connect and downstream audits to carry the binding hash, got %d in %s", got, logs.String())
+	}
+}
+
+func TestCollaborationAuditUsesTenantSalt(t *testing.T) {
+	t.Setenv("GATEWAY_AUDIT_TENANT_SALTS", `{"acme":"acme-salt"}`)
+	logs := captureAuditLogs(t)
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/collaboration/ws?filePath=docs/a.md", nil)
+	req.Header.Set("X-Tenant-Id", "acme")
+	req.Header.Set("X-Session-Id", "session-1")
+	recordCollaborationAudit(req.Context(), req, auditOutcomeDenied, map[string]any{"reason": "read_only"})
+
+	logged := logs.String()
+	if !strings.Contains(logged, `"tenant_id_hash":"`+gatewayAuditLogger.HashIdentity("tenant", "acme")+`"`) {
+		t.Fatalf("expected the tenant hash to use the shared salt, got %s", logged)
+	}
+	salted := gatewayAuditLogger.HashTenantIdentity("acme", "session", "session-1")
+	if salted == gatewayAuditLogger.HashIdentity("session", "session-1") || !strings.Contains(logged, `"session_id_hash":"`+salted+`"`) {
+		t.Fatalf("expected the session hash to use the tenant salt, got %s", logged)
+	}
+}
@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/deployment"
+)
+
+// GATEWAY_REGION_HEADER values.
+const (
+	regionHeaderOff     = "off"
+	regionHeaderTrusted = "trusted"
+	regionHeaderAll     = "all"
+
+	regionHeaderName = "X-Gateway-Region"
+)
+
+// withDeploymentAttributes labels a metric observation with attrs and the
+// bounded deployment labels (region, zone and cluster), so metrics from
+// replicas in different regions stay apart once aggregated.
+func withDeploymentAttributes(attrs ...attribute.KeyValue) metric.MeasurementOption {
+	return metric.WithAttributes(append(deployment.Current().MetricAttributes(), attrs...)...)
+}
+
+func parseRegionHeaderMode() (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("GATEWAY_REGION_HEADER")))
+	switch mode {
+	case "":
+		return regionHeaderOff, nil
+	case regionHeaderOff, regionHeaderTrusted, regionHeaderAll:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported GATEWAY_REGION_HEADER %q (expected %s, %s or %s)", mode, regionHeaderOff, regionHeaderTrusted, regionHeaderAll)
+	}
+}
+
+// ValidateRegionHeaderConfig checks GATEWAY_REGION_HEADER at startup.
+func ValidateRegionHeaderConfig() error {
+	_, err := parseRegionHeaderMode()
+	return err
+}
+
+// RegionHeaderMiddleware answers with the X-Gateway-Region header naming the
+// region of this replica, per GATEWAY_REGION_HEADER: never ("off", the
+// default), only to clients connecting from trustedProxies ("trusted"), or to
+// everyone ("all"). The region is withheld from other clients since it
+// reveals the deployment topology.
+func RegionHeaderMiddleware(next http.Handler, trustedProxies []*net.IPNet) http.Handler {
+	mode, err := parseRegionHeaderMode()
+	if err != nil || mode == regionHeaderOff {
+		// Invalid modes are caught at startup.
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		region := deployment.Current().Region
+		if region != "" && (mode == regionHeaderAll || IsTrustedProxy(RequestRemoteIP(r), trustedProxies)) {
+			w.Header().Set(regionHeaderName, region)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/deployment"
+)
+
+func TestRegionHeaderMiddleware(t *testing.T) {
+	deployment.Set(deployment.Metadata{Region: "eu-west-1"})
+	t.Cleanup(func() { deployment.Set(deployment.Metadata{}) })
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(mode, remoteAddr string) string {
+		t.Helper()
+		t.Setenv("GATEWAY_REGION_HEADER", mode)
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		RegionHeaderMiddleware(next, []*net.IPNet{trusted}).ServeHTTP(rec, req)
+		return rec.Header().Get(regionHeaderName)
+	}
+
+	if got := serve("", "10.1.2.3:1234"); got != "" {
+		t.Fatalf("expected no header by default, got %q", got)
+	}
+	if got := serve("trusted", "10.1.2.3:1234"); got != "eu-west-1" {
+		t.Fatalf("expected region for a trusted client, got %q", got)
+	}
+	if got := serve("trusted", "203.0.113.7:1234"); got != "" {
+		t.Fatalf("expected no header for an untrusted client, got %q", got)
+	}
+	if got := serve("all", "203.0.113.7:1234"); got != "eu-west-1" {
+		t.Fatalf("expected region for every client, got %q", got)
+	}
+
+	t.Setenv("GATEWAY_REGION_HEADER", "sometimes")
+	if err := ValidateRegionHeaderConfig(); err == nil {
+		t.Fatal("expected invalid mode to be rejected")
+	}
+}
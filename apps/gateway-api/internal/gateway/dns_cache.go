@@ -0,0 +1,392 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDNSCacheMinTTL      = 5 * time.Second
+	defaultDNSCacheMaxTTL      = time.Minute
+	defaultDNSCacheNegativeTTL = 5 * time.Second
+	defaultDNSCacheMaxStale    = 5 * time.Minute
+	// dnsLookupTimeout bounds a single lookup. Lookups are shared between
+	// callers, so they do not inherit any one caller's deadline.
+	dnsLookupTimeout = 5 * time.Second
+)
+
+// dnsCache resolves upstream hostnames and remembers the answers. Go's
+// resolver does not expose record TTLs, so each entry's lifetime starts at
+// minTTL and doubles, up to maxTTL, for as long as lookups keep returning the
+// same addresses. When a lookup fails, the last good answer keeps being served
+// for up to maxStale so a DNS outage does not take upstreams down with it.
+type dnsCache struct {
+	lookup      func(ctx context.Context, host string) ([]string, error)
+	minTTL      time.Duration
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+	maxStale    time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+
+	hits           atomic.Int64
+	misses         atomic.Int64
+	negativeHits   atomic.Int64
+	staleServed    atomic.Int64
+	lookups        atomic.Int64
+	lookupFailures atomic.Int64
+	refreshes      atomic.Int64
+	dialFailures   atomic.Int64
+}
+
+type dnsEntry struct {
+	addrs      []string
+	err        error
+	ttl        time.Duration
+	resolvedAt time.Time // time of the last successful lookup
+	expiresAt  time.Time
+	lastUsed   time.Time
+	// pending is closed when an in-flight lookup completes.
+	pending chan struct{}
+	// unhealthy holds addresses that recently refused connections, with the
+	// time until which they are tried last.
+	unhealthy map[string]time.Time
+}
+
+// dnsCacheStats is the admin API view of the upstream resolver.
+type dnsCacheStats struct {
+	Entries        int   `json:"entries"`
+	Hits           int64 `json:"hits"`
+	Misses         int64 `json:"misses"`
+	NegativeHits   int64 `json:"negative_hits"`
+	StaleServed    int64 `json:"stale_served"`
+	Lookups        int64 `json:"lookups"`
+	LookupFailures int64 `json:"lookup_failures"`
+	Refreshes      int64 `json:"refreshes"`
+	DialFailures   int64 `json:"dial_failures"`
+}
+
+func newDNSCache(minTTL, maxTTL, negativeTTL, maxStale time.Duration) *dnsCache {
+	return &dnsCache{
+		lookup:      net.DefaultResolver.LookupHost,
+		minTTL:      minTTL,
+		maxTTL:      maxTTL,
+		negativeTTL: negativeTTL,
+		maxStale:    maxStale,
+		now:         time.Now,
+		entries:     make(map[string]*dnsEntry),
+	}
+}
+
+var (
+	dnsCacheMu   sync.Mutex
+	dnsCacheOnce sync.Once
+	sharedDNS    *dnsCache
+	sharedDNSErr error
+)
+
+// resetDNSCache clears the shared resolver for tests.
+func resetDNSCache() {
+	dnsCacheMu.Lock()
+	defer dnsCacheMu.Unlock()
+	dnsCacheOnce = sync.Once{}
+	sharedDNS = nil
+	sharedDNSErr = nil
+}
+
+// loadDNSCache returns the shared upstream resolver, or nil when
+// GATEWAY_DNS_CACHE_ENABLED is false.
+func loadDNSCache() (*dnsCache, error) {
+	dnsCacheMu.Lock()
+	defer dnsCacheMu.Unlock()
+	dnsCacheOnce.Do(func() {
+		enabled, err := strconv.ParseBool(GetEnv("GATEWAY_DNS_CACHE_ENABLED", "true"))
+		if err != nil {
+			sharedDNSErr = fmt.Errorf("GATEWAY_DNS_CACHE_ENABLED must be a boolean: %w", err)
+			return
+		}
+		if !enabled {
+			return
+		}
+		minTTL := ResolveDuration([]string{"GATEWAY_DNS_CACHE_MIN_TTL"}, defaultDNSCacheMinTTL)
+		maxTTL := ResolveDuration([]string{"GATEWAY_DNS_CACHE_MAX_TTL"}, defaultDNSCacheMaxTTL)
+		if maxTTL < minTTL {
+			sharedDNSErr = errors.New("GATEWAY_DNS_CACHE_MAX_TTL must not be less than GATEWAY_DNS_CACHE_MIN_TTL")
+			return
+		}
+		cache := newDNSCache(minTTL, maxTTL,
+			ResolveDuration([]string{"GATEWAY_DNS_CACHE_NEGATIVE_TTL"}, defaultDNSCacheNegativeTTL),
+			ResolveDuration([]string{"GATEWAY_DNS_CACHE_MAX_STALE"}, defaultDNSCacheMaxStale))
+		// A refreshed upstream may point at moved hosts, so its next dials
+		// resolve again rather than wait for the cached answers to expire.
+		subscribe(gatewayEvents, topicUpstreamRefreshed, "dns_cache", func(upstreamRefresh) { cache.expireAll() })
+		sharedDNS = cache
+	})
+	return sharedDNS, sharedDNSErr
+}
+
+// ValidateDNSCacheConfig checks the GATEWAY_DNS_CACHE_* settings at startup.
+func ValidateDNSCacheConfig() error {
+	_, err := loadDNSCache()
+	return err
+}
+
+// upstreamDialContext returns a dial function that resolves hostnames
+// through the shared cache. The cache is looked up on every dial, so clients
+// built before it was configured still use it.
+func upstreamDialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		cache, err := loadDNSCache()
+		if err != nil || cache == nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return cache.dial(ctx, dialer, network, addr)
+	}
+}
+
+// resolve returns the addresses for host, healthy ones first.
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	for {
+		entry := c.entries[host]
+		now := c.now()
+		if entry != nil && entry.pending == nil && now.Before(entry.expiresAt) {
+			entry.lastUsed = now
+			addrs, err := c.answerLocked(entry, now)
+			c.mu.Unlock()
+			if err != nil {
+				c.negativeHits.Add(1)
+			} else {
+				c.hits.Add(1)
+			}
+			return addrs, err
+		}
+		if entry != nil && entry.pending != nil {
+			pending := entry.pending
+			c.mu.Unlock()
+			select {
+			case <-pending:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			c.mu.Lock()
+			continue
+		}
+		c.mu.Unlock()
+		c.misses.Add(1)
+		return c.refresh(ctx, host, true)
+	}
+}
+
+// refresh looks host up and stores the answer. Concurrent callers share one
+// lookup. Background refreshes pass used=false so idle entries age out.
+func (c *dnsCache) refresh(ctx context.Context, host string, used bool) ([]string, error) {
+	c.mu.Lock()
+	entry := c.entries[host]
+	if entry == nil {
+		entry = &dnsEntry{}
+		c.entries[host] = entry
+	}
+	if entry.pending != nil {
+		c.mu.Unlock()
+		return c.resolve(ctx, host)
+	}
+	pending := make(chan struct{})
+	entry.pending = pending
+	c.mu.Unlock()
+
+	lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dnsLookupTimeout)
+	c.lookups.Add(1)
+	addrs, err := c.lookup(lookupCtx, host)
+	cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(pending)
+	entry.pending = nil
+	now := c.now()
+	if used {
+		entry.lastUsed = now
+	}
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+	if err != nil {
+		c.lookupFailures.Add(1)
+		entry.err = err
+		entry.expiresAt = now.Add(c.negativeTTL)
+		return c.answerLocked(entry, now)
+	}
+	if slices.Equal(addrs, entry.addrs) && entry.ttl > 0 {
+		entry.ttl = min(entry.ttl*2, c.maxTTL)
+	} else {
+		entry.ttl = c.minTTL
+	}
+	entry.addrs = addrs
+	entry.err = nil
+	entry.resolvedAt = now
+	entry.expiresAt = now.Add(entry.ttl)
+	return c.answerLocked(entry, now)
+}
+
+// answerLocked returns an entry's addresses, falling back to the last good
+// answer within maxStale when the latest lookup failed.
+func (c *dnsCache) answerLocked(entry *dnsEntry, now time.Time) ([]string, error) {
+	if entry.err != nil {
+		if len(entry.addrs) == 0 || now.Sub(entry.resolvedAt) > entry.ttl+c.maxStale {
+			return nil, entry.err
+		}
+		c.staleServed.Add(1)
+	}
+	ordered := make([]string, 0, len(entry.addrs))
+	var unhealthy []string
+	for _, addr := range entry.addrs {
+		if until, ok := entry.unhealthy[addr]; ok && now.Before(until) {
+			unhealthy = append(unhealthy, addr)
+			continue
+		}
+		ordered = append(ordered, addr)
+	}
+	return append(ordered, unhealthy...), nil
+}
+
+// markDial records whether a connection to addr succeeded, so addresses that
+// refuse connections are tried last until the next minTTL has passed.
+func (c *dnsCache) markDial(host, addr string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[host]
+	if entry == nil {
+		return
+	}
+	if err == nil {
+		delete(entry.unhealthy, addr)
+		return
+	}
+	c.dialFailures.Add(1)
+	if entry.unhealthy == nil {
+		entry.unhealthy = make(map[string]time.Time)
+	}
+	entry.unhealthy[addr] = c.now().Add(c.minTTL)
+}
+
+// dial connects to addr, trying each cached address of its host in turn.
+func (c *dnsCache) dial(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	addrs, err := c.resolve(ctx, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	var lastErr error
+	for _, ip := range addrs {
+		if !matchesNetwork(network, ip) {
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if ctx.Err() != nil {
+			return conn, err
+		}
+		c.markDial(host, ip, err)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "no suitable address found", Addr: host}}
+	}
+	return nil, lastErr
+}
+
+func matchesNetwork(network, addr string) bool {
+	ip := net.ParseIP(addr)
+	switch network {
+	case "tcp4", "udp4":
+		return ip.To4() != nil
+	case "tcp6", "udp6":
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
+
+// refreshDue re-resolves entries that expire within horizon and were used
+// since their last lookup, so busy hostnames are renewed off the request
+// path. Entries idle for longer than maxStale are dropped.
+func (c *dnsCache) refreshDue(ctx context.Context, horizon time.Duration) error {
+	c.mu.Lock()
+	now := c.now()
+	var due []string
+	for host, entry := range c.entries {
+		switch {
+		case entry.pending != nil:
+		case now.Sub(entry.lastUsed) > c.maxStale:
+			delete(c.entries, host)
+		case len(entry.addrs) > 0 && entry.expiresAt.Before(now.Add(horizon)) && entry.lastUsed.After(entry.resolvedAt):
+			due = append(due, host)
+		}
+	}
+	c.mu.Unlock()
+
+	var errs []error
+	for _, host := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		c.refreshes.Add(1)
+		if _, err := c.refresh(ctx, host, false); err != nil {
+			errs = append(errs, fmt.Errorf("resolve %s: %w", host, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// expireAll makes the next use of every entry look its host up again. The
+// previous answers are kept so they can still be served stale if the lookup
+// fails.
+func (c *dnsCache) expireAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		entry.expiresAt = time.Time{}
+	}
+}
+
+func (c *dnsCache) stats() dnsCacheStats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+	return dnsCacheStats{
+		Entries:        entries,
+		Hits:           c.hits.Load(),
+		Misses:         c.misses.Load(),
+		NegativeHits:   c.negativeHits.Load(),
+		StaleServed:    c.staleServed.Load(),
+		Lookups:        c.lookups.Load(),
+		LookupFailures: c.lookupFailures.Load(),
+		Refreshes:      c.refreshes.Load(),
+		DialFailures:   c.dialFailures.Load(),
+	}
+}
+
+// refreshDNSCache renews cached upstream hostnames. It runs as a maintenance
+// job every GATEWAY_DNS_CACHE_MIN_TTL.
+func refreshDNSCache(ctx context.Context) error {
+	cache, err := loadDNSCache()
+	if err != nil || cache == nil {
+		return err
+	}
+	return cache.refreshDue(ctx, cache.minTTL)
+}
@@ -0,0 +1,292 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeDNS struct {
+	mu      sync.Mutex
+	answers map[string][]string
+	err     error
+	calls   atomic.Int64
+}
+
+func (f *fakeDNS) lookup(_ context.Context, host string) ([]string, error) {
+	f.calls.Add(1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if addrs, ok := f.answers[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (f *fakeDNS) set(host string, addrs []string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answers[host] = addrs
+	f.err = err
+}
+
+func newTestDNSCache(dns *fakeDNS, now *time.Time) *dnsCache {
+	cache := newDNSCache(5*time.Second, 20*time.Second, 2*time.Second, time.Minute)
+	cache.lookup = dns.lookup
+	cache.now = func() time.Time { return *now }
+	return cache
+}
+
+func TestDNSCacheGrowsTTLWhileAnswersAreStable(t *testing.T) {
+	now := time.Unix(0, 0)
+	dns := &fakeDNS{answers: map[string][]string{"orchestrator": {"10.0.0.1"}}}
+	cache := newTestDNSCache(dns, &now)
+
+	for _, step := range []struct {
+		advance time.Duration
+		ttl     time.Duration
+	}{{0, 5 * time.Second}, {5 * time.Second, 10 * time.Second}, {10 * time.Second, 20 * time.Second}, {20 * time.Second, 20 * time.Second}} {
+		now = now.Add(step.advance)
+		if _, err := cache.resolve(context.Background(), "orchestrator"); err != nil {
+			t.Fatalf("resolve: %v", err)
+		}
+		if ttl := cache.entries["orchestrator"].ttl; ttl != step.ttl {
+			t.Fatalf("expected ttl %v, got %v", step.ttl, ttl)
+		}
+	}
+	if calls := dns.calls.Load(); calls != 4 {
+		t.Fatalf("expected one lookup per expiry, got %d", calls)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := cache.resolve(context.Background(), "orchestrator"); err != nil || dns.calls.Load() != 4 {
+		t.Fatalf("expected cached answer, err=%v calls=%d", err, dns.calls.Load())
+	}
+
+	dns.set("orchestrator", []string{"10.0.0.2"}, nil)
+	now = now.Add(20 * time.Second)
+	addrs, err := cache.resolve(context.Background(), "orchestrator")
+	if err != nil || !slices.Equal(addrs, []string{"10.0.0.2"}) {
+		t.Fatalf("expected new answer, got %v %v", addrs, err)
+	}
+	if ttl := cache.entries["orchestrator"].ttl; ttl != 5*time.Second {
+		t.Fatalf("expected changed answer to reset ttl, got %v", ttl)
+	}
+}
+
+func TestDNSCacheServesStaleAnswersDuringOutage(t *testing.T) {
+	now := time.Unix(0, 0)
+	dns := &fakeDNS{answers: map[string][]string{"orchestrator": {"10.0.0.1"}}}
+	cache := newTestDNSCache(dns, &now)
+	if _, err := cache.resolve(context.Background(), "orchestrator"); err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+
+	dns.set("orchestrator", nil, errors.New("server misbehaving"))
+	now = now.Add(6 * time.Second)
+	addrs, err := cache.resolve(context.Background(), "orchestrator")
+	if err != nil || !slices.Equal(addrs, []string{"10.0.0.1"}) {
+		t.Fatalf("expected stale answer, got %v %v", addrs, err)
+	}
+	if stats := cache.stats(); stats.StaleServed != 1 || stats.LookupFailures != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// Past ttl+maxStale the failure surfaces.
+	now = now.Add(time.Minute)
+	if _, err := cache.resolve(context.Background(), "orchestrator"); err == nil {
+		t.Fatal("expected lookup error once the stale window has passed")
+	}
+}
+
+func TestDNSCacheCachesNegativeAnswers(t *testing.T) {
+	now := time.Unix(0, 0)
+	dns := &fakeDNS{answers: map[string][]string{}}
+	cache := newTestDNSCache(dns, &now)
+
+	for range 3 {
+		if _, err := cache.resolve(context.Background(), "missing"); err == nil {
+			t.Fatal("expected not found")
+		}
+	}
+	if calls := dns.calls.Load(); calls != 1 {
+		t.Fatalf("expected negative answer to be cached, got %d lookups", calls)
+	}
+	now = now.Add(3 * time.Second)
+	_, _ = cache.resolve(context.Background(), "missing")
+	if calls := dns.calls.Load(); calls != 2 {
+		t.Fatalf("expected lookup after negative ttl, got %d", calls)
+	}
+	if stats := cache.stats(); stats.NegativeHits != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDNSCacheSharesConcurrentLookups(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int64
+	cache := newDNSCache(time.Minute, time.Minute, time.Second, time.Minute)
+	cache.lookup = func(context.Context, string) ([]string, error) {
+		calls.Add(1)
+		<-release
+		return []string{"10.0.0.1"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.resolve(context.Background(), "orchestrator"); err != nil {
+				t.Errorf("resolve: %v", err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected a single shared lookup, got %d", got)
+	}
+}
+
+func TestDNSCacheTriesUnhealthyAddressesLast(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// 127.0.0.2 has no listener on the port, so dialing it fails fast.
+	now := time.Unix(0, 0)
+	dns := &fakeDNS{answers: map[string][]string{"orchestrator": {"127.0.0.2", "127.0.0.1"}}}
+	cache := newTestDNSCache(dns, &now)
+	dialer := &net.Dialer{Timeout: time.Second}
+
+	conn, err := cache.dial(context.Background(), dialer, "tcp", net.JoinHostPort("orchestrator", port))
+	if err != nil {
+		t.Skipf("loopback alias unavailable: %v", err)
+	}
+	conn.Close()
+	addrs, _ := cache.resolve(context.Background(), "orchestrator")
+	if !slices.Equal(addrs, []string{"127.0.0.1", "127.0.0.2"}) {
+		t.Fatalf("expected failed address to be tried last, got %v", addrs)
+	}
+	if stats := cache.stats(); stats.DialFailures != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	now = now.Add(5 * time.Second)
+	cache.entries["orchestrator"].expiresAt = now.Add(time.Minute)
+	if addrs, _ := cache.resolve(context.Background(), "orchestrator"); !slices.Equal(addrs, []string{"127.0.0.2", "127.0.0.1"}) {
+		t.Fatalf("expected address to recover after min ttl, got %v", addrs)
+	}
+}
+
+func TestDNSCacheRefreshDue(t *testing.T) {
+	now := time.Unix(0, 0)
+	dns := &fakeDNS{answers: map[string][]string{"busy": {"10.0.0.1"}, "idle": {"10.0.0.2"}}}
+	cache := newTestDNSCache(dns, &now)
+	for _, host := range []string{"busy", "idle"} {
+		if _, err := cache.resolve(context.Background(), host); err != nil {
+			t.Fatalf("resolve %s: %v", host, err)
+		}
+	}
+	now = now.Add(time.Second)
+	_, _ = cache.resolve(context.Background(), "busy")
+
+	if err := cache.refreshDue(context.Background(), 5*time.Second); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if calls := dns.calls.Load(); calls != 3 {
+		t.Fatalf("expected only the busy host to be refreshed, got %d lookups", calls)
+	}
+	if cache.entries["busy"].resolvedAt != now {
+		t.Fatal("expected busy host to be re-resolved")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := cache.refreshDue(context.Background(), 5*time.Second); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if stats := cache.stats(); stats.Entries != 0 || stats.Refreshes != 1 {
+		t.Fatalf("expected idle entries to be dropped, got %+v", stats)
+	}
+}
+
+func TestLoadDNSCache(t *testing.T) {
+	t.Cleanup(resetDNSCache)
+
+	resetDNSCache()
+	t.Setenv("GATEWAY_DNS_CACHE_ENABLED", "false")
+	if cache, err := loadDNSCache(); err != nil || cache != nil {
+		t.Fatalf("expected disabled cache, got %v %v", cache, err)
+	}
+
+	resetDNSCache()
+	t.Setenv("GATEWAY_DNS_CACHE_ENABLED", "true")
+	t.Setenv("GATEWAY_DNS_CACHE_MIN_TTL", "30s")
+	t.Setenv("GATEWAY_DNS_CACHE_MAX_TTL", "10s")
+	if err := ValidateDNSCacheConfig(); err == nil {
+		t.Fatal("expected max ttl below min ttl to be rejected")
+	}
+
+	resetDNSCache()
+	t.Setenv("GATEWAY_DNS_CACHE_MAX_TTL", "2m")
+	cache, err := loadDNSCache()
+	if err != nil || cache == nil || cache.minTTL != 30*time.Second || cache.maxTTL != 2*time.Minute {
+		t.Fatalf("unexpected cache %+v %v", cache, err)
+	}
+}
+
+func TestDNSCacheExpireAllResolvesAgain(t *testing.T) {
+	cache := newDNSCache(time.Minute, time.Hour, time.Second, time.Hour)
+	lookups := 0
+	cache.lookup = func(context.Context, string) ([]string, error) {
+		lookups++
+		if lookups > 2 {
+			return nil, errors.New("no such host")
+		}
+		return []string{"192.0.2.1"}, nil
+	}
+	resolve := func() []string {
+		addrs, err := cache.resolve(context.Background(), "orchestrator.internal")
+		if err != nil {
+			t.Fatalf("resolve: %v", err)
+		}
+		return addrs
+	}
+
+	resolve()
+	resolve()
+	if lookups != 1 {
+		t.Fatalf("expected a cached answer, got %d lookups", lookups)
+	}
+	cache.expireAll()
+	resolve()
+	if lookups != 2 {
+		t.Fatalf("expected an expired entry to be looked up again, got %d lookups", lookups)
+	}
+	cache.expireAll()
+	if addrs := resolve(); lookups != 3 || len(addrs) != 1 {
+		t.Fatalf("expected the previous answer to be served when the lookup fails, got %v after %d lookups", addrs, lookups)
+	}
+}
@@ -0,0 +1,127 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const (
+	auditEventDrain  = "admin.drain.update"
+	auditTargetDrain = "admin.drain"
+
+	defaultDrainDelay = 5 * time.Second
+)
+
+// drainState takes the gateway out of rotation ahead of shutdown. While
+// draining, /readyz answers 503 so endpoints stop routing new connections to
+// the replica, and public responses ask clients to close their keep-alive
+// connections so they reconnect elsewhere. Requests are still served.
+type drainState struct {
+	mu    sync.Mutex
+	since time.Time
+}
+
+var gatewayDrain drainState
+
+// drainStatus is returned by the /admin/drain endpoints.
+type drainStatus struct {
+	Draining bool       `json:"draining"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+func (d *drainState) draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !d.since.IsZero()
+}
+
+// start begins draining; starting again keeps the original time.
+func (d *drainState) start(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.since.IsZero() {
+		d.since = now
+	}
+}
+
+func (d *drainState) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.since = time.Time{}
+}
+
+func (d *drainState) status() drainStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.since.IsZero() {
+		return drainStatus{}
+	}
+	since := d.since
+	return drainStatus{Draining: true, Since: &since}
+}
+
+// DrainMiddleware asks clients to close their connection after each response
+// while the gateway drains.
+func DrainMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if gatewayDrain.draining() {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// registerAdminDrainRoutes exposes the drain switch. POST /admin/drain starts
+// draining and, unless ?wait=false, holds the response for
+// GATEWAY_DRAIN_DELAY so a Kubernetes preStop hook calling it delays SIGTERM
+// until endpoints have dropped the replica. DELETE cancels a drain.
+func registerAdminDrainRoutes(mux *http.ServeMux, token string) {
+	handle(mux, "GET /admin/drain", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeDrainResponse(w, gatewayDrain.status())
+	})), policyAdminToken)
+
+	handle(mux, "POST /admin/drain", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gatewayDrain.start(time.Now())
+		recordDrainChange(r.Context(), r, true)
+		if r.URL.Query().Get("wait") != "false" {
+			timer := time.NewTimer(ResolveDuration([]string{"GATEWAY_DRAIN_DELAY"}, defaultDrainDelay))
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+			}
+		}
+		writeDrainResponse(w, gatewayDrain.status())
+	})), policyAdminToken)
+
+	handle(mux, "DELETE /admin/drain", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gatewayDrain.stop()
+		recordDrainChange(r.Context(), r, false)
+		writeDrainResponse(w, gatewayDrain.status())
+	})), policyAdminToken)
+}
+
+func writeDrainResponse(w http.ResponseWriter, status drainStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+func recordDrainChange(ctx context.Context, r *http.Request, draining bool) {
+	actor := hashedActorFromRequest(r, nil, "admin")
+	gatewayAuditLogger.Security(audit.WithActor(ctx, actor), audit.Event{
+		Name:    auditEventDrain,
+		Outcome: auditOutcomeSuccess,
+		Target:  auditTargetDrain,
+		ActorID: actor,
+		Details: auditDetails(map[string]any{
+			"draining": draining,
+			"method":   r.Method,
+		}),
+	})
+}
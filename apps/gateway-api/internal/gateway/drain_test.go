@@ -0,0 +1,87 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminDrainTakesGatewayOutOfRotation(t *testing.T) {
+	logs := captureAuditLogs(t)
+	t.Setenv("GATEWAY_DRAIN_DELAY", "1h")
+	t.Setenv("GATEWAY_HEALTH_CHECKS", `{"orchestrator": {"disabled": true}, "indexer": {"disabled": true}}`)
+	resetHealthChecks()
+	t.Cleanup(resetHealthChecks)
+	t.Cleanup(gatewayDrain.stop)
+
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+	RegisterHealthRoutes(mux, time.Now())
+	public := DrainMiddleware(mux)
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		public.ServeHTTP(rec, req)
+		return rec
+	}
+	readiness := func() (int, healthResponse) {
+		t.Helper()
+		rec := serve(http.MethodGet, "/readyz")
+		var resp healthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		return rec.Code, resp
+	}
+
+	if code, resp := readiness(); code != http.StatusOK || resp.Status != readinessOK {
+		t.Fatalf("expected ready before draining, got %d %+v", code, resp)
+	}
+
+	rec := serve(http.MethodPost, "/admin/drain?wait=false")
+	var state drainStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil || rec.Code != http.StatusOK || !state.Draining || state.Since == nil {
+		t.Fatalf("expected the drain to start, got %d %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(logs.String(), auditEventDrain) || !strings.Contains(logs.String(), `"draining":true`) {
+		t.Fatalf("expected the drain to be audited, got %s", logs.String())
+	}
+	code, resp := readiness()
+	if code != http.StatusServiceUnavailable || resp.Status != readinessDraining || resp.Details["drain"].Status != readinessDraining {
+		t.Fatalf("expected a draining gateway to be unready, got %d %+v", code, resp)
+	}
+	if rec := serve(http.MethodGet, "/healthz"); rec.Code != http.StatusOK || rec.Header().Get("Connection") != "close" {
+		t.Fatalf("expected draining responses to close the connection, got %d %v", rec.Code, rec.Header())
+	}
+
+	rec = serve(http.MethodDelete, "/admin/drain")
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil || state.Draining {
+		t.Fatalf("expected the drain to be cancelled, got %s", rec.Body.String())
+	}
+	if code, resp := readiness(); code != http.StatusOK || resp.Status != readinessOK {
+		t.Fatalf("expected ready after cancelling, got %d %+v", code, resp)
+	}
+	if rec := serve(http.MethodGet, "/healthz"); rec.Header().Get("Connection") != "" {
+		t.Fatal("expected connections to be kept once the drain is cancelled")
+	}
+}
+
+func TestAdminDrainWaitsForDelay(t *testing.T) {
+	captureAuditLogs(t)
+	t.Setenv("GATEWAY_DRAIN_DELAY", "50ms")
+	t.Cleanup(gatewayDrain.stop)
+
+	started := time.Now()
+	rec := serveAdminDebug(t, http.MethodPost, "/admin/drain", "s3cret")
+	if rec.Code != http.StatusOK || time.Since(started) < 50*time.Millisecond {
+		t.Fatalf("expected the drain to wait for the delay, got %d after %s", rec.Code, time.Since(started))
+	}
+	if rec := serveAdminDebug(t, http.MethodPost, "/admin/drain", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", rec.Code)
+	}
+}
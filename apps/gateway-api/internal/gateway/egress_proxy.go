@@ -0,0 +1,284 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// Upstream names accepted in GATEWAY_EGRESS_PROXY_OVERRIDES.
+const (
+	egressOrchestrator = "orchestrator"
+	egressIndexer      = "indexer"
+	egressIdentity     = "identity"
+	egressLDAP         = "ldap"
+	egressRedis        = "redis"
+	egressUsageExport  = "usage_export"
+	egressHealthCheck  = "health_check"
+	egressSLOAlert     = "slo_alert"
+)
+
+var egressUpstreams = []string{egressOrchestrator, egressIndexer, egressIdentity, egressLDAP, egressRedis, egressUsageExport, egressHealthCheck, egressSLOAlert}
+
+// identityHTTPClient fetches OIDC discovery documents, JWKS and other
+// identity provider metadata.
+var identityHTTPClient = &http.Client{Transport: observeUpstreamCalls(egressIdentity, newUpstreamTransport(egressIdentity))}
+
+// egressConfig selects the proxy, if any, each upstream connects through.
+// Without GATEWAY_EGRESS_PROXY the standard HTTPS_PROXY, HTTP_PROXY and
+// NO_PROXY variables apply. Loopback destinations are never proxied.
+type egressConfig struct {
+	fallback  func(*url.URL) (*url.URL, error)
+	overrides map[string]func(*url.URL) (*url.URL, error)
+}
+
+var (
+	egressMu     sync.Mutex
+	egressOnce   sync.Once
+	egressCfg    *egressConfig
+	egressCfgErr error
+)
+
+// resetEgressConfig clears the cached egress configuration for tests.
+func resetEgressConfig() {
+	egressMu.Lock()
+	defer egressMu.Unlock()
+	egressOnce = sync.Once{}
+	egressCfg = nil
+	egressCfgErr = nil
+}
+
+func loadEgressConfig() (*egressConfig, error) {
+	egressMu.Lock()
+	defer egressMu.Unlock()
+	egressOnce.Do(func() {
+		egressCfg, egressCfgErr = parseEgressConfig()
+	})
+	return egressCfg, egressCfgErr
+}
+
+// ValidateEgressConfig checks the GATEWAY_EGRESS_PROXY* settings at startup.
+func ValidateEgressConfig() error {
+	_, err := loadEgressConfig()
+	return err
+}
+
+func parseEgressConfig() (*egressConfig, error) {
+	username := strings.TrimSpace(os.Getenv("GATEWAY_EGRESS_PROXY_USERNAME"))
+	password, err := ResolveEnvValue("GATEWAY_EGRESS_PROXY_PASSWORD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_EGRESS_PROXY_PASSWORD: %w", err)
+	}
+	noProxy := GetEnv("GATEWAY_EGRESS_NO_PROXY", firstEnv("NO_PROXY", "no_proxy"))
+	explicit := func(key, raw string) (func(*url.URL) (*url.URL, error), error) {
+		proxyURL, err := parseEgressProxyURL(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if proxyURL.User == nil && username != "" {
+			proxyURL.User = url.UserPassword(username, password)
+		}
+		cfg := httpproxy.Config{HTTPProxy: proxyURL.String(), HTTPSProxy: proxyURL.String(), NoProxy: noProxy}
+		return cfg.ProxyFunc(), nil
+	}
+
+	cfg := &egressConfig{overrides: make(map[string]func(*url.URL) (*url.URL, error))}
+	if raw := strings.TrimSpace(os.Getenv("GATEWAY_EGRESS_PROXY")); raw != "" {
+		if cfg.fallback, err = explicit("GATEWAY_EGRESS_PROXY", raw); err != nil {
+			return nil, err
+		}
+	} else {
+		cfg.fallback = (&httpproxy.Config{
+			HTTPProxy:  firstEnv("HTTP_PROXY", "http_proxy"),
+			HTTPSProxy: firstEnv("HTTPS_PROXY", "https_proxy"),
+			NoProxy:    noProxy,
+		}).ProxyFunc()
+	}
+
+	for _, entry := range strings.Split(os.Getenv("GATEWAY_EGRESS_PROXY_OVERRIDES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("egress proxy override %q must be upstream=proxy-url or upstream=direct", entry)
+		}
+		if !slices.Contains(egressUpstreams, name) {
+			return nil, fmt.Errorf("egress proxy override names unknown upstream %q (expected one of %s)", name, strings.Join(egressUpstreams, ", "))
+		}
+		if strings.EqualFold(value, "direct") {
+			cfg.overrides[name] = func(*url.URL) (*url.URL, error) { return nil, nil }
+			continue
+		}
+		if cfg.overrides[name], err = explicit("GATEWAY_EGRESS_PROXY_OVERRIDES["+name+"]", value); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+func parseEgressProxyURL(raw string) (*url.URL, error) {
+	proxyURL, err := url.Parse(raw)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("proxy must be an absolute URL")
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return proxyURL, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (expected http, https, socks5 or socks5h)", proxyURL.Scheme)
+	}
+}
+
+func firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// egressProxyFor returns the proxy upstream should use to reach target, or
+// nil to connect directly.
+func egressProxyFor(upstream string, target *url.URL) (*url.URL, error) {
+	cfg, err := loadEgressConfig()
+	if err != nil {
+		return nil, err
+	}
+	if override, ok := cfg.overrides[upstream]; ok {
+		return override(target)
+	}
+	return cfg.fallback(target)
+}
+
+// newUpstreamTransport clones the default transport, routing its dials
+// through the upstream resolver and its requests through upstream's egress
+// proxy.
+func newUpstreamTransport(upstream string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = upstreamDialContext(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return egressProxyFor(upstream, req.URL)
+	}
+	return transport
+}
+
+// egressDialContext returns a dial function for non-HTTP upstreams. Targets
+// are matched against the proxy settings as https URLs, so HTTPS_PROXY and
+// NO_PROXY apply; connections are tunnelled with CONNECT through HTTP proxies
+// or with SOCKS5.
+func egressDialContext(upstream string, dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	direct := upstreamDialContext(dialer)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		proxyURL, err := egressProxyFor(upstream, &url.URL{Scheme: "https", Host: addr})
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL == nil {
+			return direct(ctx, network, addr)
+		}
+		switch proxyURL.Scheme {
+		case "socks5", "socks5h":
+			var auth *proxy.Auth
+			if proxyURL.User != nil {
+				password, _ := proxyURL.User.Password()
+				auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+			}
+			socks, err := proxy.SOCKS5("tcp", proxyHostPort(proxyURL), auth, dialContextFunc(direct))
+			if err != nil {
+				return nil, err
+			}
+			conn, err := socks.(proxy.ContextDialer).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, fmt.Errorf("socks5 proxy %s: %w", proxyURL.Redacted(), err)
+			}
+			return conn, nil
+		default:
+			return dialHTTPConnect(ctx, direct, proxyURL, addr)
+		}
+	}
+}
+
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (f dialContextFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(context.Background(), network, addr)
+}
+
+func (f dialContextFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+func proxyHostPort(proxyURL *url.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[proxyURL.Scheme]
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// dialHTTPConnect opens a tunnel to addr through an HTTP(S) proxy.
+func dialHTTPConnect(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", proxyHostPort(proxyURL))
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if proxyURL.Scheme == "https" {
+		secured := tls.Client(conn, &tls.Config{MinVersion: tls.VersionTLS12, ServerName: proxyURL.Hostname()})
+		if err := secured.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+		}
+		conn = secured
+	}
+
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: make(http.Header)}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused tunnel to %s: %s", proxyURL.Redacted(), addr, resp.Status)
+	}
+	// Every upstream protocol tunnelled here starts with the client speaking,
+	// so anything already buffered is a misbehaving proxy.
+	if reader.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s sent data before the tunnel was used", proxyURL.Redacted())
+	}
+	return conn, nil
+}
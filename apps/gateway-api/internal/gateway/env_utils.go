@@ -50,6 +50,16 @@ func ResolveEnvValue(key string) (string, error) {
 	return "", nil
 }
 
+// envConfigured reports whether any of keys, or its _FILE variant, is set.
+func envConfigured(keys ...string) bool {
+	for _, key := range keys {
+		if strings.TrimSpace(os.Getenv(key)) != "" || strings.TrimSpace(os.Getenv(key+"_FILE")) != "" {
+			return true
+		}
+	}
+	return false
+}
+
 func ReadSecretFile(path string) ([]byte, error) {
 	rootDir := strings.TrimSpace(os.Getenv("GATEWAY_SECRET_FILE_ROOT"))
 	return readFileFromAllowedRoot(path, rootDir)
@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultEventBusQueueSize = 64
+
+// eventTopic names an event bus topic and fixes the type of its payload, so
+// publishers and subscribers of a topic cannot disagree on what it carries.
+type eventTopic[T any] struct {
+	name string
+}
+
+// upstreamRefresh is published after an admin refresh rebuilt an upstream
+// client.
+type upstreamRefresh struct {
+	Upstream string
+}
+
+var (
+	topicCollaborationReadOnly        = eventTopic[collaborationReadOnlyState]{name: "collaboration.read_only"}
+	topicCollaborationSessionsFlushed = eventTopic[struct{}]{name: "collaboration.sessions.flushed"}
+	topicUpstreamRefreshed            = eventTopic[upstreamRefresh]{name: "upstream.refreshed"}
+	topicSessionsRevoked              = eventTopic[sessionRevocation]{name: "sessions.revoked"}
+)
+
+// gatewayEvents carries notifications between subsystems of this replica. It
+// is in-process only; state that must reach every replica belongs in the
+// GATEWAY_STORAGE_URL store.
+var gatewayEvents = newEventBus(GetIntEnv("GATEWAY_EVENT_BUS_QUEUE_SIZE", defaultEventBusQueueSize))
+
+// eventBus delivers each published event to every subscriber of its topic.
+// Every subscriber has its own bounded queue drained by one goroutine, so a
+// slow subscriber never blocks publishers or other subscribers; events that
+// do not fit its queue are dropped and counted.
+type eventBus struct {
+	queueSize int
+
+	mu     sync.Mutex
+	topics map[string]*eventBusTopic
+}
+
+type eventBusTopic struct {
+	published   atomic.Int64
+	subscribers []*eventBusSubscriber
+}
+
+type eventBusSubscriber struct {
+	name      string
+	queue     chan any
+	delivered atomic.Int64
+	dropped   atomic.Int64
+}
+
+type eventBusStats struct {
+	Topics []eventBusTopicStats `json:"topics"`
+}
+
+type eventBusTopicStats struct {
+	Topic       string                    `json:"topic"`
+	Published   int64                     `json:"published"`
+	Subscribers []eventBusSubscriberStats `json:"subscribers"`
+}
+
+type eventBusSubscriberStats struct {
+	Name      string `json:"name"`
+	Queued    int    `json:"queued"`
+	Delivered int64  `json:"delivered"`
+	Dropped   int64  `json:"dropped"`
+}
+
+func newEventBus(queueSize int) *eventBus {
+	if queueSize <= 0 {
+		queueSize = defaultEventBusQueueSize
+	}
+	return &eventBus{queueSize: queueSize, topics: make(map[string]*eventBusTopic)}
+}
+
+func (b *eventBus) topic(name string) *eventBusTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &eventBusTopic{}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// subscribe calls handle with every event later published on topic until the
+// returned function is called. name identifies the subscriber in logs and
+// stats. A handler that panics is logged and keeps its subscription.
+func subscribe[T any](b *eventBus, topic eventTopic[T], name string, handle func(T)) func() {
+	sub := &eventBusSubscriber{name: name, queue: make(chan any, b.queueSize)}
+	b.mu.Lock()
+	t := b.topic(topic.name)
+	t.subscribers = append(t.subscribers, sub)
+	b.mu.Unlock()
+
+	go func() {
+		for payload := range sub.queue {
+			func() {
+				defer func() {
+					if recovered := recover(); recovered != nil {
+						slog.Error("event bus subscriber panicked", slog.String("topic", topic.name), slog.String("subscriber", name), slog.Any("panic", recovered))
+					}
+				}()
+				handle(payload.(T))
+			}()
+			sub.delivered.Add(1)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			t := b.topics[topic.name]
+			for i, candidate := range t.subscribers {
+				if candidate == sub {
+					t.subscribers = append(t.subscribers[:i:i], t.subscribers[i+1:]...)
+					break
+				}
+			}
+			close(sub.queue)
+		})
+	}
+}
+
+// publish queues payload for every subscriber of topic without waiting for
+// them to handle it.
+func publish[T any](b *eventBus, topic eventTopic[T], payload T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic.name)
+	t.published.Add(1)
+	for _, sub := range t.subscribers {
+		select {
+		case sub.queue <- payload:
+		default:
+			sub.dropped.Add(1)
+			slog.Warn("event bus subscriber queue full; event dropped", slog.String("topic", topic.name), slog.String("subscriber", sub.name))
+		}
+	}
+}
+
+func (b *eventBus) stats() eventBusStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := eventBusStats{Topics: make([]eventBusTopicStats, 0, len(b.topics))}
+	for name, t := range b.topics {
+		topic := eventBusTopicStats{Topic: name, Published: t.published.Load(), Subscribers: make([]eventBusSubscriberStats, 0, len(t.subscribers))}
+		for _, sub := range t.subscribers {
+			topic.Subscribers = append(topic.Subscribers, eventBusSubscriberStats{
+				Name:      sub.name,
+				Queued:    len(sub.queue),
+				Delivered: sub.delivered.Load(),
+				Dropped:   sub.dropped.Load(),
+			})
+		}
+		stats.Topics = append(stats.Topics, topic)
+	}
+	sort.Slice(stats.Topics, func(i, j int) bool { return stats.Topics[i].Topic < stats.Topics[j].Topic })
+	return stats
+}
@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		var zero T
+		return zero
+	}
+}
+
+func TestEventBusDeliversToSubscribers(t *testing.T) {
+	bus := newEventBus(8)
+	first := make(chan upstreamRefresh, 4)
+	second := make(chan upstreamRefresh, 4)
+	unsubscribe := subscribe(bus, topicUpstreamRefreshed, "first", func(event upstreamRefresh) { first <- event })
+	defer subscribe(bus, topicUpstreamRefreshed, "second", func(event upstreamRefresh) { second <- event })()
+	flushed := make(chan struct{}, 1)
+	defer subscribe(bus, topicCollaborationSessionsFlushed, "flush", func(struct{}) { flushed <- struct{}{} })()
+
+	publish(bus, topicUpstreamRefreshed, upstreamRefresh{Upstream: "orchestrator"})
+	publish(bus, topicUpstreamRefreshed, upstreamRefresh{Upstream: "indexer"})
+	for _, ch := range []chan upstreamRefresh{first, second} {
+		if got := receive(t, ch).Upstream + "," + receive(t, ch).Upstream; got != "orchestrator,indexer" {
+			t.Fatalf("expected events in publish order, got %s", got)
+		}
+	}
+	select {
+	case <-flushed:
+		t.Fatal("expected other topics not to be notified")
+	default:
+	}
+
+	unsubscribe()
+	unsubscribe()
+	publish(bus, topicUpstreamRefreshed, upstreamRefresh{Upstream: "orchestrator"})
+	receive(t, second)
+	select {
+	case <-first:
+		t.Fatal("expected no events after unsubscribing")
+	default:
+	}
+}
+
+func TestEventBusDropsWhenQueueIsFull(t *testing.T) {
+	bus := newEventBus(1)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer subscribe(bus, topicUpstreamRefreshed, "slow", func(upstreamRefresh) {
+		started <- struct{}{}
+		<-release
+	})()
+	fast := make(chan struct{}, 4)
+	defer subscribe(bus, topicUpstreamRefreshed, "fast", func(upstreamRefresh) { fast <- struct{}{} })()
+
+	publish(bus, topicUpstreamRefreshed, upstreamRefresh{})
+	receive(t, started)
+	receive(t, fast)
+	// The slow subscriber is busy with the first event and has room for one
+	// more, so the third is dropped for it alone.
+	publish(bus, topicUpstreamRefreshed, upstreamRefresh{})
+	receive(t, fast)
+	publish(bus, topicUpstreamRefreshed, upstreamRefresh{})
+	receive(t, fast)
+
+	stats := bus.stats()
+	if len(stats.Topics) != 1 || stats.Topics[0].Published != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	for _, sub := range stats.Topics[0].Subscribers {
+		switch sub.Name {
+		case "slow":
+			if sub.Dropped != 1 || sub.Queued != 1 {
+				t.Fatalf("expected one dropped and one queued event, got %+v", sub)
+			}
+		case "fast":
+			if sub.Dropped != 0 {
+				t.Fatalf("expected no events to be dropped, got %+v", sub)
+			}
+		}
+	}
+	close(release)
+	receive(t, started)
+}
+
+func TestEventBusRecoversFromPanickingSubscriber(t *testing.T) {
+	bus := newEventBus(4)
+	handled := make(chan string, 2)
+	defer subscribe(bus, topicUpstreamRefreshed, "flaky", func(event upstreamRefresh) {
+		if event.Upstream == "boom" {
+			panic("boom")
+		}
+		handled <- event.Upstream
+	})()
+
+	publish(bus, topicUpstreamRefreshed, upstreamRefresh{Upstream: "boom"})
+	publish(bus, topicUpstreamRefreshed, upstreamRefresh{Upstream: "orchestrator"})
+	if got := receive(t, handled); got != "orchestrator" {
+		t.Fatalf("expected the subscriber to keep receiving events, got %s", got)
+	}
+}
+
+func TestAdminEventBusStats(t *testing.T) {
+	previous := gatewayEvents
+	gatewayEvents = newEventBus(4)
+	t.Cleanup(func() { gatewayEvents = previous })
+	defer subscribe(gatewayEvents, topicCollaborationSessionsFlushed, "collaboration.presence", func(struct{}) {})()
+	publish(gatewayEvents, topicCollaborationSessionsFlushed, struct{}{})
+
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+	req := httptest.NewRequest(http.MethodGet, "/admin/event-bus", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"subscribers":[{"name":"collaboration.presence"`) {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	var stats eventBusStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || len(stats.Topics) != 1 || stats.Topics[0].Published != 1 {
+		t.Fatalf("unexpected stats %s", rec.Body.String())
+	}
+}
@@ -1,6 +1,7 @@
 package gateway
 
 import (
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
@@ -8,13 +9,13 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
-	"net/url"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/sse"
 )
 
 const (
@@ -23,6 +24,9 @@ const (
 	auditEventPlanEvents     = "plan.events.subscribe"
 	auditTargetPlanEvents    = "plan.events"
 	auditCapabilityPlan      = "plan.events"
+	// sessionBindingHashHeader carries the session's binding_id_hash on the
+	// orchestrator's event stream response.
+	sessionBindingHashHeader = "X-Session-Binding-Hash"
 	// maxAuthorizationHeaderLen allows oversized bearer tokens while bounding resource usage.
 	maxAuthorizationHeaderLen = 4096
 	// maxLastEventIDHeaderLen comfortably supports UUIDs and vendor specific suffixes.
@@ -34,15 +38,7 @@ const (
 	maxForwardedCookieValueLen = 4096
 )
 
-var forwardedSSEHeaders = []string{
-	"X-Agent",
-	"X-Request-Id",
-	"X-B3-Traceid",
-	"X-B3-Spanid",
-	"X-B3-Sampled",
-	"Traceparent",
-	"Tracestate",
-}
+var errStreamRenewed = errors.New("stream closed for renewal")
 
 var planIDPattern = regexp.MustCompile(`(?i)^plan-(?:[0-9a-f]{8}|[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})$`)
 
@@ -54,9 +50,16 @@ func writeUpstreamError(w io.Writer, body []byte) error {
 	return err
 }
 
+// connectionLimiter caps concurrent connections per key. Keys are split
+// across GATEWAY_LIMITER_SHARDS independently locked shards so that connects
+// from different clients do not serialise on one mutex.
 type connectionLimiter struct {
-	mu     sync.Mutex
 	limit  int
+	shards []connectionLimiterShard
+}
+
+type connectionLimiterShard struct {
+	mu     sync.Mutex
 	counts map[string]int
 }
 
@@ -64,23 +67,32 @@ func newConnectionLimiter(limit int) *connectionLimiter {
 	if limit <= 0 {
 		return nil
 	}
-	return &connectionLimiter{
+	l := &connectionLimiter{
 		limit:  limit,
-		counts: make(map[string]int),
+		shards: make([]connectionLimiterShard, limiterShardCount()),
+	}
+	for i := range l.shards {
+		l.shards[i].counts = make(map[string]int)
 	}
+	return l
+}
+
+func (l *connectionLimiter) shard(key string) *connectionLimiterShard {
+	return &l.shards[limiterShard(key, len(l.shards))]
 }
 
 func (l *connectionLimiter) Acquire(key string) bool {
 	if l == nil {
 		return true
 	}
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	current := l.counts[key]
+	shard := l.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	current := shard.counts[key]
 	if current >= l.limit {
 		return false
 	}
-	l.counts[key] = current + 1
+	shard.counts[key] = current + 1
 	return true
 }
 
@@ -88,17 +100,18 @@ func (l *connectionLimiter) Release(key string) {
 	if l == nil {
 		return
 	}
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	current, ok := l.counts[key]
+	shard := l.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	current, ok := shard.counts[key]
 	if !ok {
 		return
 	}
 	if current <= 1 {
-		delete(l.counts, key)
+		delete(shard.counts, key)
 		return
 	}
-	l.counts[key] = current - 1
+	shard.counts[key] = current - 1
 }
 
 // EventRouteConfig captures configuration for the events endpoint wiring.
@@ -116,6 +129,27 @@ type EventsHandler struct {
 	attemptLimiter    *rateLimiter
 	attemptBucket     rateLimitBucket
 	auditLogger       *audit.Logger
+	buffers           *sseBufferPool
+	lifetime          streamLifetime
+	// resolveUpstream, when set, supplies the client and base URL per request
+	// so rebuilt orchestrator clients are used without re-registering routes.
+	resolveUpstream func() (*http.Client, string, error)
+	// failover, when set, lets a stream that fails mid-way resume on another
+	// orchestrator replica.
+	failover *sseFailover
+	// localEvents, when set, supplies gateway-originated events that are
+	// interleaved with the upstream stream.
+	localEvents *planEventHub
+	// redactor, when set, rewrites event data before it is forwarded.
+	redactor *sseRedactor
+	// revocations, when set, ends streams whose login the orchestrator
+	// revokes.
+	revocations *eventsRevocations
+	// binarySafe base64-encodes event data that is not plain UTF-8 text.
+	binarySafe bool
+	// tenantIsolation, when set, checks that the plan belongs to the
+	// caller's tenant before connecting upstream.
+	tenantIsolation *eventsTenantIsolation
 }
 
 // NewEventsHandler constructs an SSE proxy handler that forwards requests to the orchestrator.
@@ -134,13 +168,13 @@ func NewEventsHandler(client *http.Client, orchestratorURL string, heartbeat tim
 		limiter:           limiter,
 		trustedProxies:    trustedProxies,
 		auditLogger:       audit.Default(),
+		buffers:           newSSEBufferPool(defaultSSEBufferBytes),
 	}
 }
 
 // RegisterEventRoutes wires the /events endpoint into the provided mux.
 func RegisterEventRoutes(mux *http.ServeMux, cfg EventRouteConfig) {
-	orchestratorURL := GetEnv("ORCHESTRATOR_URL", "http://127.0.0.1:4000")
-	client, err := getOrchestratorClient()
+	client, orchestratorURL, err := currentOrchestrator()
 	if err != nil {
 		panic(fmt.Sprintf("failed to configure orchestrator client: %v", err))
 	}
@@ -149,15 +183,37 @@ func RegisterEventRoutes(mux *http.ServeMux, cfg EventRouteConfig) {
 	if err != nil {
 		panic(fmt.Sprintf("invalid trusted proxy configuration: %v", err))
 	}
+	failover, err := loadSSEFailover()
+	if err != nil {
+		panic(fmt.Sprintf("invalid SSE failover configuration: %v", err))
+	}
+	tenantIsolation, err := loadEventsTenantIsolation()
+	if err != nil {
+		panic(fmt.Sprintf("invalid SSE tenant isolation configuration: %v", err))
+	}
+	redactor, err := loadSSERedactor()
+	if err != nil {
+		panic(fmt.Sprintf("invalid SSE redaction configuration: %v", err))
+	}
 	handler := NewEventsHandler(client, orchestratorURL, 0, newConnectionLimiter(maxConnections), trustedProxies)
-	handler.attemptLimiter = newRateLimiter()
+	handler.resolveUpstream = currentOrchestrator
+	handler.failover = failover
+	handler.localEvents = localPlanEvents
+	handler.tenantIsolation = tenantIsolation
+	handler.redactor = redactor
+	handler.binarySafe = getBoolEnv("GATEWAY_SSE_BINARY_SAFE")
+	handler.revocations = newEventsRevocations()
+	handler.revocations.subscribe(gatewayEvents)
+	handler.buffers = newSSEBufferPool(GetIntEnv("GATEWAY_SSE_BUFFER_BYTES", defaultSSEBufferBytes))
+	handler.lifetime = loadStreamLifetime("GATEWAY_SSE_MAX_STREAM_AGE", "GATEWAY_SSE_MAX_STREAM_AGE_JITTER")
+	handler.attemptLimiter = newRateLimiter("events.connect")
 	handler.attemptBucket = rateLimitBucket{
 		Endpoint:     "events.connect",
 		IdentityType: "ip",
 		Limit:        ResolveLimit([]string{"GATEWAY_SSE_CONNECT_LIMIT"}, 12),
 		Window:       ResolveDuration([]string{"GATEWAY_SSE_CONNECT_WINDOW"}, time.Minute),
 	}
-	mux.Handle("/events", handler)
+	handle(mux, "GET /events", handler, policyStreaming, policyConnectionLimit, policyConnectRateLimit, policyForwardHeaders(headerGroupEvents))
 }
 
 // ServeHTTP implements http.Handler for the EventsHandler.
@@ -199,9 +255,10 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if identity == "" {
 			identity = "unknown"
 		}
+		var headers rateLimitHeaderTracker
 		allowed, retryAfter, err := h.attemptLimiter.Allow(baseCtx, h.attemptBucket, identity)
 		if err != nil {
-			slog.WarnContext(baseCtx, "gateway.events.rate_limiter_error",
+			eventsLog.WarnContext(baseCtx, "gateway.events.rate_limiter_error",
 				slog.String("plan_id", planID),
 				slog.String("error", err.Error()),
 			)
@@ -212,9 +269,14 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				"client_ip_hash":      clientHash,
 				"retry_after_seconds": retryAfterToSeconds(retryAfter),
 			})
+			headers.deny(w, h.attemptBucket, retryAfter)
 			respondTooManyRequests(w, r, retryAfter)
 			return
 		}
+		if err == nil {
+			headers.observe(baseCtx, h.attemptLimiter, h.attemptBucket, identity)
+			headers.write(w)
+		}
 	}
 
 	if h.limiter != nil {
@@ -232,7 +294,29 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		defer h.limiter.Release(clientAddr)
 	}
 
-	upstreamURL := fmt.Sprintf("%s/plan/%s/events", h.orchestratorURL, url.PathEscape(planID))
+	// A signed share link stands in for the caller's credentials, which are
+	// then not forwarded.
+	shareToken := r.URL.Query().Get("share")
+	if shareToken != "" && !h.authorizePlanShare(baseCtx, w, r, shareToken, planID, clientAddr, planHash, clientHash) {
+		return
+	}
+
+	client, orchestratorURL := h.client, h.orchestratorURL
+	if h.resolveUpstream != nil {
+		resolved, base, err := h.resolveUpstream()
+		if err != nil {
+			h.recordAudit(baseCtx, auditOutcomeFailure, map[string]any{
+				"reason":         "upstream_client_unavailable",
+				"plan_id_hash":   planHash,
+				"client_ip_hash": clientHash,
+			})
+			writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "orchestrator client unavailable", nil)
+			return
+		}
+		client, orchestratorURL = resolved, base
+	}
+
+	upstreamURL := orchestratorAPI.URL(baseCtx, orchestratorURL, orchestratorPlanEvents, "plan_id", planID)
 	ctx, cancel := context.WithCancel(baseCtx)
 	defer cancel()
 
@@ -248,7 +332,9 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	req.Header.Set("Accept", "text/event-stream")
-	if auth := strings.TrimSpace(r.Header.Get("Authorization")); auth != "" {
+	if shareToken != "" {
+		req.Header.Set(planShareHeader, shareToken)
+	} else if auth := strings.TrimSpace(r.Header.Get("Authorization")); auth != "" {
 		if err := validateAuthorizationHeader(auth); err != nil {
 			h.recordAudit(baseCtx, auditOutcomeDenied, map[string]any{
 				"reason":         "invalid_header",
@@ -262,7 +348,8 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		req.Header.Set("Authorization", auth)
 	}
-	if lastEventID := strings.TrimSpace(r.Header.Get("Last-Event-ID")); lastEventID != "" {
+	lastEventID := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if lastEventID != "" {
 		if err := validateLastEventIDHeader(lastEventID); err != nil {
 			h.recordAudit(baseCtx, auditOutcomeDenied, map[string]any{
 				"reason":         "invalid_header",
@@ -276,7 +363,7 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		req.Header.Set("Last-Event-ID", lastEventID)
 	}
-	if cookies := r.Header.Values("Cookie"); len(cookies) > 0 {
+	if cookies := r.Header.Values("Cookie"); len(cookies) > 0 && shareToken == "" {
 		sanitizedCookies := make([]string, 0, len(cookies))
 		for _, cookie := range cookies {
 			if cookie == "" {
@@ -299,14 +386,35 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			req.Header.Add("Cookie", cookie)
 		}
 	}
-	CloneHeaders(req.Header, r.Header, forwardedSSEHeaders)
+	if h.tenantIsolation != nil && shareToken == "" {
+		scoped, ok := h.enforcePlanTenant(baseCtx, w, r, planID, req.Header, planHash, clientAddr)
+		if !ok {
+			return
+		}
+		baseCtx = scoped
+		clientHash = h.scopedClientHash(baseCtx, clientAddr)
+	}
+	if err := forwardedHeaders(headerGroupEvents).forward(req.Header, r.Header); err != nil {
+		h.recordAudit(baseCtx, auditOutcomeDenied, map[string]any{
+			"reason":         "invalid_header",
+			"header":         strings.ToLower(err.header),
+			"detail":         err.err.Error(),
+			"plan_id_hash":   planHash,
+			"client_ip_hash": clientHash,
+		})
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+		return
+	}
+	requestScopeFrom(baseCtx).setUpstreamHeaders(req.Header)
 
 	gatewayAddr := LocalIP(r)
 	appendForwardingHeaders(req.Header, r.Header, clientAddr, gatewayAddr)
+	setForwardedHeaders(req.Header, r, h.trustedProxies, clientAddr)
+	upstream := eventsUpstreamRequest{client: client, planID: planID, header: req.Header.Clone()}
 
-	logger := slog.Default()
+	logger := eventsLog
 
-	resp, err := h.client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		h.recordAudit(baseCtx, auditOutcomeFailure, map[string]any{
 			"reason":         "upstream_unreachable",
@@ -317,15 +425,18 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var closeOnce sync.Once
-	closeBody := func() {
-		closeOnce.Do(func() {
-			if err := resp.Body.Close(); err != nil {
-				logger.WarnContext(ctx, "gateway.events.response_close_failed", slog.String("plan_id", planID), slog.String("error", err.Error()))
-			}
-		})
+	bodyCloser := func(resp *http.Response) func() {
+		var closeOnce sync.Once
+		return func() {
+			closeOnce.Do(func() {
+				if err := resp.Body.Close(); err != nil {
+					logger.WarnContext(ctx, "gateway.events.response_close_failed", slog.String("plan_id", planID), slog.String("error", err.Error()))
+				}
+			})
+		}
 	}
-	defer closeBody()
+	closeBody := bodyCloser(resp)
+	defer func() { closeBody() }()
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
@@ -347,6 +458,12 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The orchestrator names the login behind the session it authorised the
+	// stream for, so the subscription can be traced back to it.
+	if bindingHash := strings.TrimSpace(resp.Header.Get(sessionBindingHashHeader)); validBindingHash(bindingHash) {
+		baseCtx = withRequestScope(baseCtx, requestScope{BindingIDHash: bindingHash})
+	}
+
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		h.recordAudit(baseCtx, auditOutcomeFailure, map[string]any{
@@ -366,6 +483,7 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	} else {
 		w.Header().Set("X-Accel-Buffering", "no")
 	}
+	compress := negotiateSSECompression(w, r)
 	flusher.Flush()
 
 	h.recordAudit(baseCtx, auditOutcomeSuccess, map[string]any{
@@ -374,13 +492,70 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"status_code":    resp.StatusCode,
 	})
 
+	tenantHash := usageTenantHash(baseCtx, r)
+	recordUsage(tenantHash, usageMetricProxiedRequests, 1)
+	streamStart := time.Now()
+	defer func() {
+		recordUsage(tenantHash, usageMetricSSESeconds, int64(time.Since(streamStart)/time.Second))
+	}()
+
+	stream := planStreams.connect(planHash)
+	defer func() {
+		summary := stream.close()
+		h.recordAudit(baseCtx, auditOutcomeSuccess, map[string]any{
+			"reason":            "stream_closed",
+			"plan_id_hash":      planHash,
+			"client_ip_hash":    clientHash,
+			"events":            summary.Events,
+			"bytes":             summary.Bytes,
+			"duration_ms":       summary.Duration.Milliseconds(),
+			"events_per_second": summary.EventsPerSecond,
+		})
+	}()
+
 	writer := &flushingWriter{w: w, flusher: flusher}
+	if compress {
+		writer.compress()
+		defer writer.closeCompression()
+	}
 	errCh := make(chan error, 1)
 
-	go func() {
-		_, err := io.Copy(writer, resp.Body)
+	var expired <-chan time.Time
+	if h.lifetime.enabled() || h.failover != nil || h.localEvents != nil {
+		writer.tracker = newSSEEventIDTracker(lastEventID)
+	}
+	var local <-chan sse.Event
+	if h.localEvents != nil {
+		events, unsubscribe := h.localEvents.subscribe(planID)
+		defer unsubscribe()
+		local = events
+	}
+	revoked, unwatch := h.revocations.register(requestScopeFrom(baseCtx).BindingIDHash)
+	defer unwatch()
+	if h.lifetime.enabled() {
+		expiry := time.NewTimer(h.lifetime.next())
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
+	conn := streamConns.connect(streamKindEvents)
+	defer conn.close()
+	buffers := h.getBufferPool()
+	transform := h.eventTransform()
+	copyStream := func(body io.Reader) {
+		body = stream.reader(body)
+		if transform != nil {
+			errCh <- transformSSEStream(writer, body, transform)
+			return
+		}
+		buf := buffers.get()
+		defer buffers.put(buf)
+		_, err := copySSEStream(writer, body, *buf)
 		errCh <- err
-	}()
+	}
+	conn.goroutine(func() { copyStream(resp.Body) })
+	currentURL := orchestratorURL
+	failovers := 0
 
 	ticker := time.NewTicker(h.heartbeatInterval)
 	defer ticker.Stop()
@@ -394,6 +569,30 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case err := <-errCh:
 			closeBody()
 			if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, io.EOF) {
+				if ctx.Err() == nil && h.failover != nil && failovers < h.failover.maxAttempts && writer.atBoundary() {
+					failovers++
+					next, nextURL, failoverErr := h.failover.resubscribe(ctx, upstream, orchestratorURL, currentURL, writer.lastEventID())
+					if failoverErr == nil {
+						logger.WarnContext(ctx, "gateway.events.upstream_failover",
+							slog.String("plan_id", planID),
+							slog.String("from", currentURL),
+							slog.String("to", nextURL),
+							slog.String("error", err.Error()),
+						)
+						closeBody = bodyCloser(next)
+						currentURL = nextURL
+						if _, writeErr := writer.Write([]byte(failoverPayload)); writeErr != nil {
+							closeBody()
+							return
+						}
+						conn.goroutine(func() { copyStream(next.Body) })
+						continue
+					}
+					logger.WarnContext(ctx, "gateway.events.upstream_failover_failed",
+						slog.String("plan_id", planID),
+						slog.String("error", failoverErr.Error()),
+					)
+				}
 				if ctx.Err() == nil {
 					logger.ErrorContext(ctx, "gateway.events.upstream_error",
 						slog.String("plan_id", planID),
@@ -414,16 +613,54 @@ func (h *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 			return
+		case event := <-local:
+			if err := writer.writeLocalEvent(event); err != nil {
+				closeBody()
+				<-errCh
+				return
+			}
 		case <-ticker.C:
 			if _, err := writer.Write([]byte(heartbeatPayload)); err != nil {
 				closeBody()
 				<-errCh
 				return
 			}
+		case <-revoked:
+			h.recordAudit(baseCtx, auditOutcomeDenied, map[string]any{
+				"reason":         "session_revoked",
+				"plan_id_hash":   planHash,
+				"client_ip_hash": clientHash,
+			})
+			if err := emitSSEErrorEvent(writer, errStreamSessionRevoked); err != nil {
+				logger.WarnContext(ctx, "gateway.events.error_event_failed",
+					slog.String("plan_id", planID),
+					slog.String("error", err.Error()),
+				)
+			}
+			closeBody()
+			<-errCh
+			return
+		case <-expired:
+			if err := writer.renew(); err != nil {
+				logger.WarnContext(ctx, "gateway.events.reconnect_event_failed",
+					slog.String("plan_id", planID),
+					slog.String("error", err.Error()),
+				)
+			}
+			closeBody()
+			<-errCh
+			return
 		}
 	}
 }
 
+func (h *EventsHandler) getBufferPool() *sseBufferPool {
+	if h.buffers == nil {
+		h.buffers = newSSEBufferPool(defaultSSEBufferBytes)
+	}
+	return h.buffers
+}
+
 func (h *EventsHandler) getAuditLogger() *audit.Logger {
 	if h.auditLogger == nil {
 		h.auditLogger = audit.Default()
@@ -487,12 +724,14 @@ func hasUnsafeHeaderRunes(value string) bool {
 
 func (h *EventsHandler) recordAudit(ctx context.Context, outcome string, details map[string]any) {
 	logger := h.getAuditLogger()
+	scope := requestScopeFrom(ctx)
 	event := audit.Event{
-		Name:       auditEventPlanEvents,
-		Outcome:    outcome,
-		Target:     auditTargetPlanEvents,
-		Capability: auditCapabilityPlan,
-		Details:    audit.SanitizeDetails(details),
+		Name:        auditEventPlanEvents,
+		Outcome:     outcome,
+		Target:      auditTargetPlanEvents,
+		Capability:  auditCapabilityPlan,
+		Correlation: scope.correlation(logger),
+		Details:     audit.SanitizeDetails(scope.annotate(details)),
 	}
 	switch outcome {
 	case auditOutcomeSuccess:
@@ -519,10 +758,7 @@ func emitSSEErrorEvent(w io.Writer, upstreamErr error) error {
 	if upstreamErr == nil {
 		return nil
 	}
-	message := sanitizeSSEData(upstreamErr.Error())
-	payload := fmt.Sprintf("event: error\ndata: %s\n\n", message)
-	_, err := w.Write([]byte(payload))
-	return err
+	return sse.NewEncoder(w).Encode(sse.Event{Type: "error", Data: sanitizeSSEData(upstreamErr.Error())})
 }
 
 func sanitizeSSEData(data string) string {
@@ -539,18 +775,95 @@ type flushingWriter struct {
 	w       http.ResponseWriter
 	flusher http.Flusher
 	mu      sync.Mutex
+	// tracker is set when stream renewal or failover is enabled so the
+	// writer knows whether it is safe to append a reconnect event or another
+	// upstream's events.
+	tracker *sseEventIDTracker
+	closed  bool
+	// deferred holds encoded local events waiting for the client to be
+	// between upstream events.
+	deferred [][]byte
+	// gz, when set, compresses the stream; it is flushed along with the
+	// response so compression never holds back a complete event.
+	gz *gzip.Writer
 }
 
 func (fw *flushingWriter) Write(p []byte) (int, error) {
+	return fw.writeChunk(p, true)
+}
+
+// writeChunk writes p and optionally flushes under a single lock acquisition so
+// heartbeats cannot interleave with a partially written event.
+func (fw *flushingWriter) writeChunk(p []byte, flush bool) (int, error) {
 	fw.mu.Lock()
 	defer fw.mu.Unlock()
-	n, err := fw.w.Write(p)
-	if n > 0 {
-		fw.flusher.Flush()
+	if fw.closed {
+		return 0, errStreamRenewed
+	}
+	n, err := fw.dst().Write(p)
+	if fw.tracker != nil && n > 0 {
+		fw.tracker.observe(p[:n])
+	}
+	if err == nil && len(fw.deferred) > 0 {
+		err = fw.writeDeferredLocked()
+		flush = true
+	}
+	if flush && n > 0 {
+		fw.flushLocked()
 	}
 	return n, err
 }
 
+// writeLocked writes a complete payload; fw.mu must be held.
+func (fw *flushingWriter) writeLocked(p []byte, flush bool) error {
+	n, err := fw.dst().Write(p)
+	if fw.tracker != nil && n > 0 {
+		fw.tracker.observe(p[:n])
+	}
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	if flush && n > 0 {
+		fw.flushLocked()
+	}
+	return err
+}
+
+// atBoundary reports whether the client is between events, so another
+// upstream's events can follow without corrupting a partial one.
+func (fw *flushingWriter) atBoundary() bool {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.tracker != nil && fw.tracker.atBoundary()
+}
+
+// lastEventID returns the ID of the last event dispatched to the client.
+func (fw *flushingWriter) lastEventID() string {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.tracker == nil {
+		return ""
+	}
+	return fw.tracker.lastID
+}
+
+// renew stops further writes and, when the client is between events, sends a
+// reconnect event carrying the last event ID. Mid-event the stream is simply
+// ended: EventSource discards the partial event and resumes from Last-Event-ID.
+func (fw *flushingWriter) renew() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.closed = true
+	if fw.tracker == nil || !fw.tracker.atBoundary() {
+		return nil
+	}
+	if err := emitSSEReconnectEvent(fw.dst(), fw.tracker.lastID); err != nil {
+		return err
+	}
+	fw.flushLocked()
+	return nil
+}
+
 func appendForwardingHeaders(dst, src http.Header, clientAddr, gatewayAddr string) {
 	forwardedFor := UniqueHeaderValues(src.Values("X-Forwarded-For"))
 	forwardedFor = AppendAddressIfMissing(forwardedFor, clientAddr)
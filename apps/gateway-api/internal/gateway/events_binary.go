@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/sse"
+)
+
+const (
+	sseEncodingBase64 = "base64"
+	// sseBinaryChunkBytes bounds the raw bytes per base64 chunk so every
+	// encoded data line stays well below sse.DefaultMaxLineBytes.
+	sseBinaryChunkBytes = 32 << 10
+)
+
+// sseBinaryChunk is the data of an event whose original data was not plain
+// text. Clients concatenate the decoded Data of chunks 0..Chunks-1 of the
+// same event type to recover the original bytes; only the last chunk carries
+// the event ID.
+type sseBinaryChunk struct {
+	Encoding string `json:"encoding"`
+	Chunk    int    `json:"chunk"`
+	Chunks   int    `json:"chunks"`
+	Data     string `json:"data"`
+}
+
+// needsBinaryEncoding reports whether data holds bytes that text/event-stream
+// clients cannot be trusted to pass through: invalid UTF-8 or control
+// characters other than tab and the line feeds that separate data lines.
+func needsBinaryEncoding(data string) bool {
+	if !utf8.ValidString(data) {
+		return true
+	}
+	for _, r := range data {
+		if (r < 0x20 && r != '\t' && r != '\n') || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeBinaryEvent passes plain text events through and re-emits others as
+// base64 chunks.
+func encodeBinaryEvent(event sse.Event) []sse.Event {
+	if !needsBinaryEncoding(event.Data) {
+		return []sse.Event{event}
+	}
+	raw := []byte(event.Data)
+	chunks := (len(raw) + sseBinaryChunkBytes - 1) / sseBinaryChunkBytes
+	out := make([]sse.Event, 0, chunks)
+	for i := 0; i < chunks; i++ {
+		part := raw[i*sseBinaryChunkBytes : min((i+1)*sseBinaryChunkBytes, len(raw))]
+		data, _ := json.Marshal(sseBinaryChunk{
+			Encoding: sseEncodingBase64,
+			Chunk:    i,
+			Chunks:   chunks,
+			Data:     base64.StdEncoding.EncodeToString(part),
+		})
+		chunk := sse.Event{Type: event.Type, Data: string(data)}
+		if i == 0 {
+			chunk.Comments = event.Comments
+		}
+		if i == chunks-1 {
+			chunk.ID = event.ID
+			chunk.Retry = event.Retry
+		}
+		out = append(out, chunk)
+	}
+	return out
+}
+
+// eventTransform returns the rewriting applied to upstream events, or nil
+// when they are copied through unchanged.
+func (h *EventsHandler) eventTransform() sseEventTransform {
+	if h.redactor == nil && !h.binarySafe {
+		return nil
+	}
+	return func(event sse.Event) []sse.Event {
+		if h.redactor != nil {
+			redacted, ok := h.redactor.redact(event)
+			if !ok {
+				return nil
+			}
+			event = redacted
+		}
+		if h.binarySafe {
+			return encodeBinaryEvent(event)
+		}
+		return []sse.Event{event}
+	}
+}
@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/sse"
+)
+
+func TestNeedsBinaryEncoding(t *testing.T) {
+	cases := map[string]bool{
+		"":                       false,
+		"plain text":             false,
+		"line one\nline\ttwo":    false,
+		`{"output":"héllo, 世界"}`: false,
+		"nul\x00byte":            true,
+		"bell\x07":               true,
+		"del\x7f":                true,
+		"bad utf8 \xff\xfe":      true,
+	}
+	for data, want := range cases {
+		if got := needsBinaryEncoding(data); got != want {
+			t.Errorf("needsBinaryEncoding(%q) = %v, want %v", data, got, want)
+		}
+	}
+}
+
+func TestEncodeBinaryEventRoundTrips(t *testing.T) {
+	text := sse.Event{Type: "plan.step", ID: "7", Data: "ok"}
+	if out := encodeBinaryEvent(text); len(out) != 1 || out[0].Data != "ok" {
+		t.Fatalf("expected text events to pass through, got %+v", out)
+	}
+
+	raw := strings.Repeat("\x00\xff\x01binary", sseBinaryChunkBytes/4)
+	out := encodeBinaryEvent(sse.Event{Type: "plan.step", ID: "8", Data: raw, Comments: []string{"c"}})
+	if want := (len(raw) + sseBinaryChunkBytes - 1) / sseBinaryChunkBytes; len(out) != want || want < 2 {
+		t.Fatalf("expected %d chunks, got %d", want, len(out))
+	}
+	var decoded []byte
+	for i, event := range out {
+		if event.Type != "plan.step" || strings.ContainsAny(event.Data, "\n") {
+			t.Fatalf("unexpected chunk %d: %+v", i, event)
+		}
+		if (i == len(out)-1) != (event.ID == "8") || (i == 0) != (len(event.Comments) == 1) {
+			t.Fatalf("expected the id on the last chunk and comments on the first, got %+v", event)
+		}
+		var chunk sseBinaryChunk
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %d: %v", i, err)
+		}
+		if chunk.Encoding != sseEncodingBase64 || chunk.Chunk != i || chunk.Chunks != len(out) {
+			t.Fatalf("unexpected chunk header: %+v", chunk)
+		}
+		part, err := base64.StdEncoding.DecodeString(chunk.Data)
+		if err != nil {
+			t.Fatalf("invalid base64 in chunk %d: %v", i, err)
+		}
+		decoded = append(decoded, part...)
+	}
+	if string(decoded) != raw {
+		t.Fatal("expected the chunks to reassemble the original bytes")
+	}
+}
+
+func TestEventsHandlerEncodesBinaryEvents(t *testing.T) {
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("id: 1\nevent: plan.step\ndata: {\"ok\":true}\n\nid: 2\nevent: plan.step\ndata: \x1b[31mred\xff\n\n"))
+	}))
+	defer orchestrator.Close()
+
+	handler := NewEventsHandler(orchestrator.Client(), orchestrator.URL, 0, nil, nil)
+	handler.binarySafe = true
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events?plan_id="+validPlanID, nil))
+
+	body := rec.Body.String()
+	want := `data: {"encoding":"base64","chunk":0,"chunks":1,"data":"` + base64.StdEncoding.EncodeToString([]byte("\x1b[31mred\xff")) + `"}`
+	if rec.Code != http.StatusOK || !strings.Contains(body, `data: {"ok":true}`) || !strings.Contains(body, want) {
+		t.Fatalf("expected the binary event to be base64-encoded, got %d %q", rec.Code, body)
+	}
+	if !strings.Contains(body, "id: 2") {
+		t.Fatalf("expected the encoded event to keep its id, got %q", body)
+	}
+}
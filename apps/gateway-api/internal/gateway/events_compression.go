@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sseGzipWriters recycles gzip writers across event streams. BestSpeed keeps
+// the per-event flush cheap; plan logs compress well even at that level.
+var sseGzipWriters = sync.Pool{
+	New: func() any {
+		gz, _ := gzip.NewWriterLevel(io.Discard, gzip.BestSpeed)
+		return gz
+	},
+}
+
+// sseCompressionEnabled reports whether /events may gzip streams.
+// GATEWAY_SSE_COMPRESSION=false turns it off for deployments behind proxies
+// that buffer compressed responses until they end.
+func sseCompressionEnabled() bool {
+	value := strings.TrimSpace(GetEnv("GATEWAY_SSE_COMPRESSION", "true"))
+	enabled, err := strconv.ParseBool(value)
+	return err != nil || enabled
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip with a
+// non-zero quality. An explicit gzip or x-gzip entry decides on its own;
+// "*" only applies when gzip is not named.
+func acceptsGzip(header string) bool {
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if coding != "*" {
+			return quality > 0
+		}
+		wildcard = quality
+	}
+	return wildcard > 0
+}
+
+// negotiateSSECompression sets the response headers for a gzip stream when
+// the client accepts one and reports whether to compress.
+func negotiateSSECompression(w http.ResponseWriter, r *http.Request) bool {
+	if !sseCompressionEnabled() {
+		return false
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(strings.Join(r.Header.Values("Accept-Encoding"), ",")) {
+		return false
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	return true
+}
+
+// compress routes the writer's output through a pooled gzip writer.
+func (fw *flushingWriter) compress() {
+	gz := sseGzipWriters.Get().(*gzip.Writer)
+	gz.Reset(fw.w)
+	fw.gz = gz
+}
+
+// closeCompression writes the gzip trailer and returns the writer to the
+// pool. Nothing may write to the stream afterwards.
+func (fw *flushingWriter) closeCompression() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.gz == nil {
+		return
+	}
+	if err := fw.gz.Close(); err == nil {
+		fw.flusher.Flush()
+	}
+	fw.gz.Reset(io.Discard)
+	sseGzipWriters.Put(fw.gz)
+	fw.gz = nil
+	fw.closed = true
+}
+
+// dst is where stream bytes are written; fw.mu must be held.
+func (fw *flushingWriter) dst() io.Writer {
+	if fw.gz != nil {
+		return fw.gz
+	}
+	return fw.w
+}
+
+// flushLocked pushes buffered bytes to the client; fw.mu must be held.
+func (fw *flushingWriter) flushLocked() {
+	if fw.gz != nil {
+		_ = fw.gz.Flush()
+	}
+	fw.flusher.Flush()
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := map[string]bool{
+		"":                      false,
+		"gzip":                  true,
+		"br, gzip;q=0.5":        true,
+		"GZIP":                  true,
+		"deflate, *":            true,
+		"gzip;q=0":              false,
+		"identity":              false,
+		"x-gzip; q=1.0, br":     true,
+		"gzip ; q = 0.000, br ": false,
+		"*;q=1, gzip;q=0":       false,
+		"gzip;q=0.5, *;q=0":     true,
+		"br, *;q=0":             false,
+	}
+	for header, want := range cases {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestEventsHandlerCompressesAtEventBoundaries(t *testing.T) {
+	release := make(chan struct{})
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: " + strings.Repeat("step output ", 100) + "\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("data: done\n\n"))
+	}))
+	defer orchestrator.Close()
+	gateway := httptest.NewServer(NewEventsHandler(orchestrator.Client(), orchestrator.URL, 0, nil, nil))
+	defer gateway.Close()
+
+	get := func(acceptEncoding string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/events?plan_id="+validPlanID, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := get("gzip")
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a gzip stream, got %v", resp.Header)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip stream: %v", err)
+	}
+	reader := bufio.NewReader(gz)
+	// The first event must be readable while the upstream is still open.
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data: step output") {
+		t.Fatalf("expected the first event before the stream ends, got %q: %v", line, err)
+	}
+	close(release)
+	rest, err := io.ReadAll(reader)
+	if err != nil || !strings.Contains(string(rest), "data: done") {
+		t.Fatalf("expected the stream to end cleanly, got %q: %v", rest, err)
+	}
+
+	t.Setenv("GATEWAY_SSE_COMPRESSION", "false")
+	plain := get("gzip")
+	defer plain.Body.Close()
+	if plain.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("expected compression to be disabled, got %v", plain.Header)
+	}
+}
@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSSEFailoverCooldown    = 30 * time.Second
+	defaultSSEFailoverMaxAttempts = 3
+	failoverPayload               = ": upstream failover\n\n"
+)
+
+// sseFailover tracks orchestrator replicas an events stream can move to when
+// its upstream fails mid-stream. Health is passive: a replica that drops a
+// stream or fails a resubscribe is skipped until its cooldown expires, and a
+// successful resubscribe clears it again.
+type sseFailover struct {
+	replicas    []string
+	cooldown    time.Duration
+	maxAttempts int
+	now         func() time.Time
+
+	mu             sync.Mutex
+	unhealthyUntil map[string]time.Time
+}
+
+// loadSSEFailover reads GATEWAY_SSE_FAILOVER_URLS and returns nil when no
+// replicas are configured.
+func loadSSEFailover() (*sseFailover, error) {
+	raw := GetEnv("GATEWAY_SSE_FAILOVER_URLS", "")
+	var replicas []string
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimRight(strings.TrimSpace(entry), "/")
+		if entry == "" {
+			continue
+		}
+		parsed, err := url.Parse(entry)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("GATEWAY_SSE_FAILOVER_URLS entry %q must be an absolute http(s) URL", entry)
+		}
+		replicas = append(replicas, entry)
+	}
+	if len(replicas) == 0 {
+		return nil, nil
+	}
+	return newSSEFailover(replicas,
+		ResolveDuration([]string{"GATEWAY_SSE_FAILOVER_COOLDOWN"}, defaultSSEFailoverCooldown),
+		ResolveLimit([]string{"GATEWAY_SSE_FAILOVER_MAX_ATTEMPTS"}, defaultSSEFailoverMaxAttempts)), nil
+}
+
+func newSSEFailover(replicas []string, cooldown time.Duration, maxAttempts int) *sseFailover {
+	return &sseFailover{
+		replicas:       replicas,
+		cooldown:       cooldown,
+		maxAttempts:    maxAttempts,
+		now:            time.Now,
+		unhealthyUntil: make(map[string]time.Time),
+	}
+}
+
+func (f *sseFailover) markUnhealthy(baseURL string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unhealthyUntil[baseURL] = f.now().Add(f.cooldown)
+}
+
+func (f *sseFailover) markHealthy(baseURL string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.unhealthyUntil, baseURL)
+}
+
+// candidates returns the healthy replicas other than current, primary first.
+func (f *sseFailover) candidates(primary, current string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	seen := map[string]bool{current: true}
+	var healthy []string
+	for _, baseURL := range append([]string{primary}, f.replicas...) {
+		if seen[baseURL] {
+			continue
+		}
+		seen[baseURL] = true
+		if until, ok := f.unhealthyUntil[baseURL]; ok {
+			if now.Before(until) {
+				continue
+			}
+			delete(f.unhealthyUntil, baseURL)
+		}
+		healthy = append(healthy, baseURL)
+	}
+	return healthy
+}
+
+// eventsUpstreamRequest carries what is needed to (re)subscribe to a plan's
+// event stream on any orchestrator replica.
+type eventsUpstreamRequest struct {
+	client *http.Client
+	planID string
+	header http.Header
+}
+
+func (u eventsUpstreamRequest) build(ctx context.Context, baseURL, lastEventID string) (*http.Request, error) {
+	upstreamURL := orchestratorAPI.URL(ctx, baseURL, orchestratorPlanEvents, "plan_id", u.planID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstreamURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = u.header.Clone()
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	} else {
+		req.Header.Del("Last-Event-ID")
+	}
+	return req, nil
+}
+
+// resubscribe tries each healthy replica in turn and returns the first stream
+// that answers successfully along with the replica it came from. Each attempt
+// is recorded as a retry of the failed stream.
+func (f *sseFailover) resubscribe(ctx context.Context, upstream eventsUpstreamRequest, primary, current, lastEventID string) (*http.Response, string, error) {
+	f.markUnhealthy(current)
+	candidates := f.candidates(primary, current)
+	if len(candidates) == 0 {
+		return nil, "", errors.New("no healthy orchestrator replica")
+	}
+	var lastErr error
+	for i, baseURL := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		req, err := upstream.build(withUpstreamRetries(ctx, i+1), baseURL, lastEventID)
+		if err != nil {
+			return nil, "", err
+		}
+		resp, err := upstream.client.Do(req)
+		if err != nil {
+			lastErr = err
+			f.markUnhealthy(baseURL)
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			_ = resp.Body.Close()
+			lastErr = fmt.Errorf("replica returned %d", resp.StatusCode)
+			f.markUnhealthy(baseURL)
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			// The replica is up but refused the subscription; every replica
+			// would answer the same, so stop here.
+			_ = resp.Body.Close()
+			return nil, "", fmt.Errorf("replica returned %d", resp.StatusCode)
+		}
+		f.markHealthy(baseURL)
+		return resp, baseURL, nil
+	}
+	return nil, "", lastErr
+}
@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedBody returns data and then fails with err.
+type scriptedBody struct {
+	data io.Reader
+	err  error
+}
+
+func (b *scriptedBody) Read(p []byte) (int, error) {
+	n, err := b.data.Read(p)
+	if err == io.EOF {
+		return n, b.err
+	}
+	return n, err
+}
+
+func (b *scriptedBody) Close() error { return nil }
+
+func newFailoverTestHandler(t *testing.T, primaryBody string, replicaStatus int) (*EventsHandler, *[]string, *sync.Mutex) {
+	t.Helper()
+	var mu sync.Mutex
+	var lastEventIDs []string
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		lastEventIDs = append(lastEventIDs, req.Host+"="+req.Header.Get("Last-Event-ID"))
+		mu.Unlock()
+		switch req.Host {
+		case "primary":
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: &scriptedBody{data: strings.NewReader(primaryBody), err: io.ErrUnexpectedEOF}}, nil
+		default:
+			return &http.Response{StatusCode: replicaStatus, Header: http.Header{}, Body: &scriptedBody{data: strings.NewReader("id: 2\ndata: b\n\n"), err: io.EOF}}, nil
+		}
+	})}
+	handler := NewEventsHandler(client, "http://primary", time.Minute, nil, nil)
+	handler.failover = newSSEFailover([]string{"http://replica"}, time.Minute, defaultSSEFailoverMaxAttempts)
+	return handler, &lastEventIDs, &mu
+}
+
+func TestEventsHandlerFailsOverToReplicaMidStream(t *testing.T) {
+	handler, requests, mu := newFailoverTestHandler(t, "id: 1\ndata: a\n\n", http.StatusOK)
+
+	req := httptest.NewRequest(http.MethodGet, "/events?plan_id="+validPlanID, nil)
+	rec := newFlushingRecorder()
+	handler.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if body != "id: 1\ndata: a\n\n"+failoverPayload+"id: 2\ndata: b\n\n" {
+		t.Fatalf("expected stream to continue on the replica, got %q", body)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(*requests) != 2 || (*requests)[1] != "replica=1" {
+		t.Fatalf("expected replica resubscribe with Last-Event-ID 1, got %v", *requests)
+	}
+	if len(handler.failover.candidates("http://primary", "")) != 1 {
+		t.Fatal("expected failed primary to be skipped during its cooldown")
+	}
+}
+
+func TestEventsHandlerEmitsErrorEventWhenNoReplicaAvailable(t *testing.T) {
+	handler, _, _ := newFailoverTestHandler(t, "id: 1\ndata: a\n\n", http.StatusServiceUnavailable)
+
+	req := httptest.NewRequest(http.MethodGet, "/events?plan_id="+validPlanID, nil)
+	rec := newFlushingRecorder()
+	handler.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if strings.Contains(body, failoverPayload) || !strings.Contains(body, "event: error") {
+		t.Fatalf("expected error event after failed failover, got %q", body)
+	}
+}
+
+func TestEventsHandlerDoesNotFailOverMidEvent(t *testing.T) {
+	handler, requests, mu := newFailoverTestHandler(t, "id: 1\ndata: a\n\nid: 2\ndata: par", http.StatusOK)
+
+	req := httptest.NewRequest(http.MethodGet, "/events?plan_id="+validPlanID, nil)
+	rec := newFlushingRecorder()
+	handler.ServeHTTP(rec, req)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(*requests) != 1 {
+		t.Fatalf("expected no resubscribe while an event was partially written, got %v", *requests)
+	}
+	if strings.Contains(rec.Body.String(), failoverPayload) {
+		t.Fatalf("unexpected failover comment in %q", rec.Body.String())
+	}
+}
+
+func TestSSEFailoverCandidatesSkipUnhealthyUntilCooldown(t *testing.T) {
+	now := time.Unix(0, 0)
+	failover := newSSEFailover([]string{"http://a", "http://b"}, time.Minute, 3)
+	failover.now = func() time.Time { return now }
+
+	failover.markUnhealthy("http://a")
+	if got := failover.candidates("http://primary", "http://primary"); len(got) != 1 || got[0] != "http://b" {
+		t.Fatalf("expected only http://b, got %v", got)
+	}
+	now = now.Add(time.Minute)
+	if got := failover.candidates("http://primary", "http://primary"); len(got) != 2 || got[0] != "http://a" {
+		t.Fatalf("expected http://a back after cooldown, got %v", got)
+	}
+}
+
+func TestLoadSSEFailover(t *testing.T) {
+	t.Setenv("GATEWAY_SSE_FAILOVER_URLS", "")
+	if failover, err := loadSSEFailover(); err != nil || failover != nil {
+		t.Fatalf("expected failover to be disabled by default, got %v, %v", failover, err)
+	}
+
+	t.Setenv("GATEWAY_SSE_FAILOVER_URLS", "https://orch-b:4000/, http://orch-c:4000")
+	failover, err := loadSSEFailover()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failover.replicas) != 2 || failover.replicas[0] != "https://orch-b:4000" {
+		t.Fatalf("unexpected replicas %v", failover.replicas)
+	}
+
+	t.Setenv("GATEWAY_SSE_FAILOVER_URLS", "orch-b:4000")
+	if _, err := loadSSEFailover(); err == nil {
+		t.Fatal("expected invalid replica URL to be rejected")
+	}
+}
@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/sse"
+)
+
+const (
+	// localEventBuffer bounds the gateway-originated events queued per stream.
+	// Publishing never blocks; a stream that falls this far behind misses
+	// events rather than stalling the publisher.
+	localEventBuffer = 32
+)
+
+// planEventHub fans events that originate in the gateway, such as attachment
+// upload progress, out to the plan event streams this instance is serving.
+// Streams served by other replicas do not see them.
+type planEventHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan sse.Event]struct{}
+}
+
+var localPlanEvents = newPlanEventHub()
+
+func newPlanEventHub() *planEventHub {
+	return &planEventHub{subs: make(map[string]map[chan sse.Event]struct{})}
+}
+
+// subscribe registers a stream for planID. The returned function removes the
+// subscription and must be called when the stream ends.
+func (h *planEventHub) subscribe(planID string) (<-chan sse.Event, func()) {
+	ch := make(chan sse.Event, localEventBuffer)
+	h.mu.Lock()
+	if h.subs[planID] == nil {
+		h.subs[planID] = make(map[chan sse.Event]struct{})
+	}
+	h.subs[planID][ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs[planID], ch)
+		if len(h.subs[planID]) == 0 {
+			delete(h.subs, planID)
+		}
+	}
+}
+
+// publish delivers event to every stream subscribed to planID.
+func (h *planEventHub) publish(planID string, event sse.Event) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[planID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// writeLocalEvent writes a gateway-originated event to the client. Upstream
+// events are copied in arbitrary chunks, so when the client is mid-event the
+// local one is held back and written as soon as the upstream event completes.
+func (fw *flushingWriter) writeLocalEvent(event sse.Event) error {
+	var buf bytes.Buffer
+	if err := sse.NewEncoder(&buf).Encode(event); err != nil {
+		return err
+	}
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.closed {
+		return errStreamRenewed
+	}
+	if fw.tracker != nil && !fw.tracker.atBoundary() {
+		if len(fw.deferred) >= localEventBuffer {
+			fw.deferred = fw.deferred[1:]
+		}
+		fw.deferred = append(fw.deferred, buf.Bytes())
+		return nil
+	}
+	return fw.writeLocked(buf.Bytes(), true)
+}
+
+// writeDeferredLocked writes local events held back by writeLocalEvent once
+// the client is between events again.
+func (fw *flushingWriter) writeDeferredLocked() error {
+	for len(fw.deferred) > 0 && fw.tracker != nil && fw.tracker.atBoundary() {
+		payload := fw.deferred[0]
+		fw.deferred = fw.deferred[1:]
+		if err := fw.writeLocked(payload, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
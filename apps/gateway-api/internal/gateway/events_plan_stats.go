@@ -0,0 +1,299 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	defaultPlanMetricsTopN = 20
+	// planStatsOther labels the traffic of plans outside the tracked set.
+	planStatsOther = "other"
+)
+
+// planStreams aggregates /events traffic per plan for the
+// gateway.sse.plan.* metrics.
+var planStreams = newPlanStatsAggregator(func() int {
+	return GetIntEnv("GATEWAY_SSE_PLAN_METRICS_TOP_N", defaultPlanMetricsTopN)
+})
+
+// planStats is the traffic of one plan, keyed by its hash. Streams hold the
+// entry while connected, so the counters are updated without the
+// aggregator's lock.
+type planStats struct {
+	connects  atomic.Int64
+	active    atomic.Int64
+	events    atomic.Int64
+	bytes     atomic.Int64
+	streams   atomic.Int64
+	streamDur atomic.Int64 // total duration of closed streams, in nanoseconds
+}
+
+func (s *planStats) fold(other *planStats) {
+	s.connects.Add(other.connects.Load())
+	s.events.Add(other.events.Load())
+	s.bytes.Add(other.bytes.Load())
+	s.streams.Add(other.streams.Load())
+	s.streamDur.Add(other.streamDur.Load())
+}
+
+// planStatsAggregator bounds metric cardinality to the busiest plans. When
+// it is full, the idle plan with the fewest events is folded into "other" to
+// make room; if every tracked plan is streaming, the new plan is counted
+// under "other" instead. A plan that returns after being folded starts its
+// series again from zero, which metric backends treat as a counter reset.
+type planStatsAggregator struct {
+	limitOnce sync.Once
+	loadLimit func() int
+	limit     int
+
+	mu    sync.Mutex
+	plans map[string]*planStats
+	other planStats
+	now   func() time.Time
+}
+
+func newPlanStatsAggregator(loadLimit func() int) *planStatsAggregator {
+	return &planStatsAggregator{
+		loadLimit: loadLimit,
+		plans:     make(map[string]*planStats),
+		now:       time.Now,
+	}
+}
+
+// planStream is one /events connection's share of its plan's stats.
+type planStream struct {
+	agg     *planStatsAggregator
+	stats   *planStats
+	started time.Time
+	events  atomic.Int64
+	bytes   atomic.Int64
+}
+
+// planStreamSummary describes a finished stream for its audit event.
+type planStreamSummary struct {
+	Events          int64
+	Bytes           int64
+	Duration        time.Duration
+	EventsPerSecond float64
+}
+
+// connect starts accounting a stream for the plan with the given hash. The
+// caller must call close when the stream ends.
+func (a *planStatsAggregator) connect(planHash string) *planStream {
+	registerPlanStatsInstruments(a)
+	a.limitOnce.Do(func() { a.limit = a.loadLimit() })
+	a.mu.Lock()
+	stats, ok := a.plans[planHash]
+	if !ok {
+		stats = &a.other
+		if len(a.plans) >= a.limit {
+			a.evictLocked()
+		}
+		if len(a.plans) < a.limit {
+			stats = &planStats{}
+			a.plans[planHash] = stats
+		}
+	}
+	stats.connects.Add(1)
+	stats.active.Add(1)
+	a.mu.Unlock()
+	return &planStream{agg: a, stats: stats, started: a.now()}
+}
+
+// evictLocked folds the idle plan with the fewest events into "other"; a.mu
+// must be held. Entries are only idle while no stream holds them.
+func (a *planStatsAggregator) evictLocked() {
+	var victim string
+	var victimStats *planStats
+	for hash, stats := range a.plans {
+		if stats.active.Load() > 0 {
+			continue
+		}
+		if victimStats == nil || stats.events.Load() < victimStats.events.Load() {
+			victim, victimStats = hash, stats
+		}
+	}
+	if victimStats == nil {
+		return
+	}
+	delete(a.plans, victim)
+	a.other.fold(victimStats)
+}
+
+// reader counts the bytes and dispatched events read from an upstream body.
+func (s *planStream) reader(body io.Reader) io.Reader {
+	return &sseEventCounter{r: body, stream: s}
+}
+
+// close ends the stream and returns its summary.
+func (s *planStream) close() planStreamSummary {
+	duration := s.agg.now().Sub(s.started)
+	s.agg.mu.Lock()
+	s.stats.streams.Add(1)
+	s.stats.streamDur.Add(int64(duration))
+	s.stats.active.Add(-1)
+	s.agg.mu.Unlock()
+	summary := planStreamSummary{
+		Events:   s.events.Load(),
+		Bytes:    s.bytes.Load(),
+		Duration: duration,
+	}
+	if seconds := duration.Seconds(); seconds > 0 {
+		summary.EventsPerSecond = float64(summary.Events) / seconds
+	}
+	return summary
+}
+
+func (s *planStream) add(events, bytes int64) {
+	if events > 0 {
+		s.events.Add(events)
+		s.stats.events.Add(events)
+	}
+	if bytes > 0 {
+		s.bytes.Add(bytes)
+		s.stats.bytes.Add(bytes)
+	}
+}
+
+// sseEventCounter counts the events dispatched by a text/event-stream as it
+// is read: blank lines ending a block that carried a data field, the events
+// EventSource delivers. Comments and heartbeats are not counted.
+type sseEventCounter struct {
+	r      io.Reader
+	stream *planStream
+
+	prefix  [5]byte
+	lineLen int
+	data    bool
+	skipLF  bool
+}
+
+func (c *sseEventCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	var events int64
+	for _, b := range p[:n] {
+		if c.skipLF {
+			c.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+		if b != '\r' && b != '\n' {
+			if c.lineLen < len(c.prefix) {
+				c.prefix[c.lineLen] = b
+			}
+			c.lineLen++
+			continue
+		}
+		c.skipLF = b == '\r'
+		switch {
+		case c.lineLen == 0:
+			if c.data {
+				events++
+			}
+			c.data = false
+		case c.lineLen == 4 && string(c.prefix[:4]) == "data",
+			c.lineLen >= 5 && string(c.prefix[:]) == "data:":
+			c.data = true
+		}
+		c.lineLen = 0
+	}
+	c.stream.add(events, int64(n))
+	return n, err
+}
+
+// planStatsSnapshot is one plan's series, labelled by hash or "other".
+type planStatsSnapshot struct {
+	plan        string
+	connects    int64
+	events      int64
+	bytes       int64
+	avgDuration float64
+	hasAvg      bool
+}
+
+func snapshotPlanStats(plan string, stats *planStats) planStatsSnapshot {
+	snap := planStatsSnapshot{
+		plan:     plan,
+		connects: stats.connects.Load(),
+		events:   stats.events.Load(),
+		bytes:    stats.bytes.Load(),
+	}
+	if streams := stats.streams.Load(); streams > 0 {
+		snap.avgDuration = time.Duration(stats.streamDur.Load() / streams).Seconds()
+		snap.hasAvg = true
+	}
+	return snap
+}
+
+func (a *planStatsAggregator) snapshot() []planStatsSnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	snaps := make([]planStatsSnapshot, 0, len(a.plans)+1)
+	for hash, stats := range a.plans {
+		snaps = append(snaps, snapshotPlanStats(hash, stats))
+	}
+	return append(snaps, snapshotPlanStats(planStatsOther, &a.other))
+}
+
+var planStatsOnce sync.Once
+
+// registerPlanStatsInstruments publishes per-plan traffic through the global
+// meter provider as gateway.sse.plan.events, gateway.sse.plan.bytes,
+// gateway.sse.plan.connects and gateway.sse.plan.stream_duration, labelled by
+// plan_hash.
+func registerPlanStatsInstruments(a *planStatsAggregator) {
+	planStatsOnce.Do(func() {
+		meter := otel.Meter("gateway.sse")
+		events, err := meter.Int64ObservableCounter("gateway.sse.plan.events",
+			metric.WithDescription("Events proxied from the orchestrator, by plan."))
+		if err != nil {
+			slog.Warn("gateway.sse.plan_metrics_failed", slog.String("error", err.Error()))
+			return
+		}
+		bytes, err := meter.Int64ObservableCounter("gateway.sse.plan.bytes",
+			metric.WithDescription("Bytes proxied from the orchestrator, by plan."),
+			metric.WithUnit("By"))
+		if err != nil {
+			slog.Warn("gateway.sse.plan_metrics_failed", slog.String("error", err.Error()))
+			return
+		}
+		connects, err := meter.Int64ObservableCounter("gateway.sse.plan.connects",
+			metric.WithDescription("Event streams opened, by plan."))
+		if err != nil {
+			slog.Warn("gateway.sse.plan_metrics_failed", slog.String("error", err.Error()))
+			return
+		}
+		duration, err := meter.Float64ObservableGauge("gateway.sse.plan.stream_duration",
+			metric.WithDescription("Average duration of closed event streams, by plan."),
+			metric.WithUnit("s"))
+		if err != nil {
+			slog.Warn("gateway.sse.plan_metrics_failed", slog.String("error", err.Error()))
+			return
+		}
+		_, err = meter.RegisterCallback(func(_ context.Context, observer metric.Observer) error {
+			for _, snap := range a.snapshot() {
+				attrs := withDeploymentAttributes(attribute.String("plan_hash", snap.plan))
+				observer.ObserveInt64(events, snap.events, attrs)
+				observer.ObserveInt64(bytes, snap.bytes, attrs)
+				observer.ObserveInt64(connects, snap.connects, attrs)
+				if snap.hasAvg {
+					observer.ObserveFloat64(duration, snap.avgDuration, attrs)
+				}
+			}
+			return nil
+		}, events, bytes, connects, duration)
+		if err != nil {
+			slog.Warn("gateway.sse.plan_metrics_failed", slog.String("error", err.Error()))
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestSSEEventCounterCountsDispatchedEvents(t *testing.T) {
+	agg := newPlanStatsAggregator(func() int { return 1 })
+	stream := agg.connect("plan-a")
+	raw := ": heartbeat\n\nid: 1\ndata: one\n\nevent: plan.step\r\ndata\r\n\r\nretry: 10\n\ndata: two\ndata: lines\r\n\r\n"
+	if _, err := io.Copy(io.Discard, iotest.OneByteReader(stream.reader(strings.NewReader(raw)))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	summary := stream.close()
+	if summary.Events != 3 || summary.Bytes != int64(len(raw)) {
+		t.Fatalf("expected 3 events and %d bytes, got %+v", len(raw), summary)
+	}
+}
+
+func TestPlanStatsAggregatorBoundsPlans(t *testing.T) {
+	agg := newPlanStatsAggregator(func() int { return 2 })
+	clock := time.Unix(0, 0)
+	agg.now = func() time.Time { return clock }
+
+	busy := agg.connect("busy")
+	busy.add(10, 100)
+	quiet := agg.connect("quiet")
+	quiet.add(1, 10)
+	// Both tracked plans are streaming, so a third is counted under "other".
+	overflow := agg.connect("overflow")
+	overflow.add(5, 50)
+	overflow.close()
+	clock = clock.Add(2 * time.Second)
+	quiet.close()
+
+	// The idle plan with the fewest events makes room for a new one.
+	fresh := agg.connect("fresh")
+	fresh.close()
+
+	got := make(map[string]planStatsSnapshot)
+	for _, snap := range agg.snapshot() {
+		got[snap.plan] = snap
+	}
+	if len(got) != 3 || got["busy"].events != 10 || got["fresh"].connects != 1 {
+		t.Fatalf("unexpected tracked plans: %+v", got)
+	}
+	other := got[planStatsOther]
+	if other.events != 6 || other.bytes != 60 || other.connects != 2 {
+		t.Fatalf("expected overflow and evicted traffic under other, got %+v", other)
+	}
+	if !other.hasAvg || other.avgDuration != 1 {
+		t.Fatalf("expected a 1s average stream duration, got %+v", other)
+	}
+	if summary := busy.close(); summary.Events != 10 || summary.EventsPerSecond != 5 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestEventsHandlerAuditsStreamSummary(t *testing.T) {
+	logs := captureAuditLogs(t)
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: one\n\ndata: two\n\n"))
+	}))
+	defer orchestrator.Close()
+
+	handler := NewEventsHandler(orchestrator.Client(), orchestrator.URL, 0, nil, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events?plan_id="+validPlanID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	output := logs.String()
+	if !strings.Contains(output, `"reason":"stream_closed"`) || !strings.Contains(output, `"events":2`) || !strings.Contains(output, `"bytes":22`) {
+		t.Fatalf("expected a stream summary audit event, got %s", output)
+	}
+}
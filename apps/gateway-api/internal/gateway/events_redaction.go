@@ -0,0 +1,257 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/sse"
+)
+
+// Modes accepted in GATEWAY_SSE_REDACTION.
+const (
+	sseRedactionOff    = "off"
+	sseRedactionOn     = "on"
+	sseRedactionStrict = "strict"
+)
+
+const sseRedactedValue = "[REDACTED]"
+
+// defaultRedactionPatterns match credentials tools commonly echo: bearer
+// tokens, JWTs, provider API keys and PEM private keys.
+var defaultRedactionPatterns = []string{
+	`(?i)\bbearer\s+[a-z0-9._~+/=-]{8,}`,
+	`\beyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`,
+	`\bsk-[A-Za-z0-9_-]{16,}`,
+	`\bAKIA[0-9A-Z]{16}\b`,
+	`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	`\bxox[abprs]-[A-Za-z0-9-]{10,}`,
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+}
+
+// defaultRedactionFields are JSON object keys whose values are always
+// replaced, compared case-insensitively with "-" treated as "_".
+var defaultRedactionFields = []string{
+	"access_token", "api_key", "apikey", "authorization", "client_secret",
+	"password", "passwd", "private_key", "refresh_token", "secret",
+}
+
+// sseRedactionRules is the GATEWAY_SSE_REDACTION_RULES document; its entries
+// are added to the defaults.
+type sseRedactionRules struct {
+	Patterns []string `json:"patterns"`
+	Fields   []string `json:"fields"`
+}
+
+// sseRedactor rewrites plan event data before it reaches clients. JSON data
+// has matching fields replaced and patterns applied to every string value;
+// other data has patterns applied to the raw text, or is dropped in strict
+// mode.
+type sseRedactor struct {
+	strict   bool
+	patterns []*regexp.Regexp
+	fields   map[string]struct{}
+
+	patternRedactions atomic.Int64
+	fieldRedactions   atomic.Int64
+	dropped           atomic.Int64
+}
+
+func newSSERedactor(strict bool, rules sseRedactionRules) (*sseRedactor, error) {
+	r := &sseRedactor{strict: strict, fields: make(map[string]struct{})}
+	for _, pattern := range append(append([]string(nil), defaultRedactionPatterns...), rules.Patterns...) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	for _, field := range append(append([]string(nil), defaultRedactionFields...), rules.Fields...) {
+		if strings.TrimSpace(field) == "" {
+			return nil, fmt.Errorf("redaction fields must not be empty")
+		}
+		r.fields[normalizeRedactionField(field)] = struct{}{}
+	}
+	return r, nil
+}
+
+func normalizeRedactionField(field string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(field)), "-", "_")
+}
+
+// redact returns the event to forward and false when it must be dropped.
+func (r *sseRedactor) redact(event sse.Event) (sse.Event, bool) {
+	for i, comment := range event.Comments {
+		event.Comments[i] = r.redactText(comment)
+	}
+	decoder := json.NewDecoder(strings.NewReader(event.Data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		if r.strict {
+			r.dropped.Add(1)
+			return sse.Event{}, false
+		}
+		event.Data = r.redactText(event.Data)
+		return event, true
+	}
+	redacted, changed := r.redactValue(value)
+	if !changed {
+		return event, true
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(redacted); err != nil {
+		r.dropped.Add(1)
+		return sse.Event{}, false
+	}
+	event.Data = strings.TrimSuffix(buf.String(), "\n")
+	return event, true
+}
+
+func (r *sseRedactor) redactValue(value any) (any, bool) {
+	switch v := value.(type) {
+	case map[string]any:
+		changed := false
+		for key, item := range v {
+			if _, ok := r.fields[normalizeRedactionField(key)]; ok {
+				if item != sseRedactedValue {
+					v[key] = sseRedactedValue
+					r.fieldRedactions.Add(1)
+					changed = true
+				}
+				continue
+			}
+			if redacted, itemChanged := r.redactValue(item); itemChanged {
+				v[key] = redacted
+				changed = true
+			}
+		}
+		return v, changed
+	case []any:
+		changed := false
+		for i, item := range v {
+			if redacted, itemChanged := r.redactValue(item); itemChanged {
+				v[i] = redacted
+				changed = true
+			}
+		}
+		return v, changed
+	case string:
+		redacted := r.redactText(v)
+		return redacted, redacted != v
+	default:
+		return value, false
+	}
+}
+
+func (r *sseRedactor) redactText(text string) string {
+	for _, re := range r.patterns {
+		text = re.ReplaceAllStringFunc(text, func(string) string {
+			r.patternRedactions.Add(1)
+			return sseRedactedValue
+		})
+	}
+	return text
+}
+
+var (
+	sseRedactorMu   sync.Mutex
+	sseRedactorOnce sync.Once
+	sharedRedactor  *sseRedactor
+	sharedRedactErr error
+)
+
+// resetSSERedactor clears the cached configuration for tests.
+func resetSSERedactor() {
+	sseRedactorMu.Lock()
+	defer sseRedactorMu.Unlock()
+	sseRedactorOnce = sync.Once{}
+	sharedRedactor = nil
+	sharedRedactErr = nil
+}
+
+// loadSSERedactor returns the /events redaction stage, or nil when
+// GATEWAY_SSE_REDACTION is off.
+func loadSSERedactor() (*sseRedactor, error) {
+	sseRedactorMu.Lock()
+	defer sseRedactorMu.Unlock()
+	sseRedactorOnce.Do(func() {
+		sharedRedactor, sharedRedactErr = parseSSERedactor()
+		if sharedRedactor != nil {
+			registerRedactionCounters(sharedRedactor)
+		}
+	})
+	return sharedRedactor, sharedRedactErr
+}
+
+func parseSSERedactor() (*sseRedactor, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("GATEWAY_SSE_REDACTION")))
+	switch mode {
+	case "", sseRedactionOff:
+		return nil, nil
+	case sseRedactionOn, sseRedactionStrict:
+	default:
+		return nil, fmt.Errorf("unsupported GATEWAY_SSE_REDACTION %q (expected %s, %s or %s)", mode, sseRedactionOff, sseRedactionOn, sseRedactionStrict)
+	}
+	raw, err := ResolveEnvValue("GATEWAY_SSE_REDACTION_RULES")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_SSE_REDACTION_RULES: %w", err)
+	}
+	var rules sseRedactionRules
+	if strings.TrimSpace(raw) != "" {
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&rules); err != nil {
+			return nil, fmt.Errorf("invalid GATEWAY_SSE_REDACTION_RULES: %w", err)
+		}
+	}
+	return newSSERedactor(mode == sseRedactionStrict, rules)
+}
+
+// ValidateSSERedactionConfig checks the GATEWAY_SSE_REDACTION* settings at
+// startup.
+func ValidateSSERedactionConfig() error {
+	_, err := loadSSERedactor()
+	return err
+}
+
+// registerRedactionCounters publishes the redactor's counts through the
+// global meter provider as gateway.sse.redactions and
+// gateway.sse.redaction.dropped.
+func registerRedactionCounters(r *sseRedactor) {
+	meter := otel.Meter("gateway.sse")
+	redactions, err := meter.Int64ObservableCounter("gateway.sse.redactions",
+		metric.WithDescription("Values redacted from plan events, by rule kind."))
+	if err != nil {
+		slog.Warn("gateway.sse.redaction_counter_failed", slog.String("error", err.Error()))
+		return
+	}
+	dropped, err := meter.Int64ObservableCounter("gateway.sse.redaction.dropped",
+		metric.WithDescription("Plan events dropped by strict redaction."))
+	if err != nil {
+		slog.Warn("gateway.sse.redaction_counter_failed", slog.String("error", err.Error()))
+		return
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, observer metric.Observer) error {
+		observer.ObserveInt64(redactions, r.patternRedactions.Load(), withDeploymentAttributes(attribute.String("rule", "pattern")))
+		observer.ObserveInt64(redactions, r.fieldRedactions.Load(), withDeploymentAttributes(attribute.String("rule", "field")))
+		observer.ObserveInt64(dropped, r.dropped.Load(), withDeploymentAttributes())
+		return nil
+	}, redactions, dropped)
+	if err != nil {
+		slog.Warn("gateway.sse.redaction_counter_failed", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// errStreamSessionRevoked is sent to clients as the stream's error event.
+var errStreamSessionRevoked = errors.New("session revoked")
+
+// eventsRevocations tracks open /events streams by the binding_id_hash the
+// orchestrator reported for them, so a revocation can end them. Streams
+// without a binding hash cannot be matched and run until they close.
+type eventsRevocations struct {
+	mu    sync.Mutex
+	watch map[string]map[chan struct{}]struct{}
+}
+
+func newEventsRevocations() *eventsRevocations {
+	return &eventsRevocations{watch: make(map[string]map[chan struct{}]struct{})}
+}
+
+// register returns a channel closed when bindingHash is revoked and the
+// function that stops watching it, which must be called when the stream
+// ends. A nil registry or empty hash yields a channel that never fires.
+func (e *eventsRevocations) register(bindingHash string) (<-chan struct{}, func()) {
+	if e == nil || bindingHash == "" {
+		return nil, func() {}
+	}
+	ch := make(chan struct{})
+	e.mu.Lock()
+	if e.watch[bindingHash] == nil {
+		e.watch[bindingHash] = make(map[chan struct{}]struct{})
+	}
+	e.watch[bindingHash][ch] = struct{}{}
+	e.mu.Unlock()
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.watch[bindingHash][ch]; !ok {
+			return
+		}
+		delete(e.watch[bindingHash], ch)
+		if len(e.watch[bindingHash]) == 0 {
+			delete(e.watch, bindingHash)
+		}
+	}
+}
+
+// revoke signals every stream registered under a revoked binding hash and
+// reports how many were signalled.
+func (e *eventsRevocations) revoke(revocation sessionRevocation) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	signalled := 0
+	for _, hash := range revocation.BindingHashes {
+		for ch := range e.watch[hash] {
+			close(ch)
+			signalled++
+		}
+		delete(e.watch, hash)
+	}
+	return signalled
+}
+
+// subscribe ends this replica's streams when the orchestrator revokes the
+// logins behind them.
+func (e *eventsRevocations) subscribe(bus *eventBus) {
+	subscribe(bus, topicSessionsRevoked, "events.revocations", func(revocation sessionRevocation) {
+		if closed := e.revoke(revocation); closed > 0 {
+			eventsLog.Info("closed event streams for revoked sessions", slog.Int("streams", closed))
+		}
+	})
+}
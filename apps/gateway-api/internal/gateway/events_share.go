@@ -0,0 +1,239 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const (
+	auditEventPlanShareMint = "plan.events.share.mint"
+	auditEventPlanShareUse  = "plan.events.share.use"
+	auditTargetPlanShare    = "plan.events.share"
+
+	// planShareHeader carries a verified share token to the orchestrator in
+	// place of the caller's credentials.
+	planShareHeader = "X-Plan-Share-Token"
+
+	defaultPlanShareTTL    = time.Hour
+	defaultPlanShareMaxTTL = 24 * time.Hour
+	minPlanShareKeyBytes   = 32
+)
+
+var errPlanShareNotConfigured = errors.New("GATEWAY_SSE_SHARE_KEY is not configured")
+
+// planShareClaims is the payload of a signed /events share link. It grants a
+// read-only view of one plan's event stream until it expires, optionally only
+// to clients in IPRange.
+type planShareClaims struct {
+	ID        string `json:"jti"`
+	PlanID    string `json:"plan"`
+	IPRange   string `json:"ip_range,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func loadPlanShareKey() ([]byte, error) {
+	key, err := ResolveEnvValue("GATEWAY_SSE_SHARE_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_SSE_SHARE_KEY: %w", err)
+	}
+	if key == "" {
+		return nil, errPlanShareNotConfigured
+	}
+	if len(key) < minPlanShareKeyBytes {
+		return nil, fmt.Errorf("GATEWAY_SSE_SHARE_KEY must be at least %d bytes", minPlanShareKeyBytes)
+	}
+	return []byte(key), nil
+}
+
+// ValidatePlanShareConfig checks GATEWAY_SSE_SHARE_KEY at startup when it is
+// set.
+func ValidatePlanShareConfig() error {
+	if _, err := loadPlanShareKey(); err != nil && !errors.Is(err, errPlanShareNotConfigured) {
+		return err
+	}
+	return nil
+}
+
+// verifyPlanShare checks a share token against the requested plan and the
+// client address. The returned error is for the audit record only; callers
+// answer every failure the same way.
+func verifyPlanShare(key []byte, token, planID, clientAddr string, now time.Time) (planShareClaims, error) {
+	var claims planShareClaims
+	if err := verifyGatewayToken(key, gatewayTokenPlanShare, token, &claims); err != nil {
+		return planShareClaims{}, err
+	}
+	switch {
+	case claims.PlanID != planID:
+		return claims, errors.New("plan mismatch")
+	case !now.Before(time.Unix(claims.ExpiresAt, 0)):
+		return claims, errors.New("expired")
+	case claims.IPRange != "":
+		prefix, err := netip.ParsePrefix(claims.IPRange)
+		if err != nil {
+			return claims, errors.New("invalid ip range")
+		}
+		addr, err := netip.ParseAddr(clientAddr)
+		if err != nil || !prefix.Contains(addr.Unmap()) {
+			return claims, errors.New("client outside ip range")
+		}
+	}
+	return claims, nil
+}
+
+// planShareRequest is the body of POST /admin/share.
+type planShareRequest struct {
+	PlanID  string `json:"plan_id"`
+	TTL     string `json:"ttl,omitempty"`
+	IPRange string `json:"ip_range,omitempty"`
+}
+
+type planShareResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// mintPlanShare validates req and signs a share token for it.
+func mintPlanShare(key []byte, req planShareRequest, now time.Time) (planShareClaims, string, error) {
+	if !planIDPattern.MatchString(req.PlanID) {
+		return planShareClaims{}, "", errors.New("plan_id is invalid")
+	}
+	ttl := defaultPlanShareTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 {
+			return planShareClaims{}, "", errors.New("ttl must be a positive duration")
+		}
+		ttl = parsed
+	}
+	if maxTTL := GetDurationEnv("GATEWAY_SSE_SHARE_MAX_TTL", defaultPlanShareMaxTTL); ttl > maxTTL {
+		return planShareClaims{}, "", fmt.Errorf("ttl must not exceed %s", maxTTL)
+	}
+	ipRange := ""
+	if req.IPRange != "" {
+		prefix, err := parseShareIPRange(req.IPRange)
+		if err != nil {
+			return planShareClaims{}, "", err
+		}
+		ipRange = prefix.String()
+	}
+	claims := planShareClaims{
+		ID:        uuid.NewString(),
+		PlanID:    req.PlanID,
+		IPRange:   ipRange,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+	token, err := signGatewayToken(key, gatewayTokenPlanShare, claims)
+	return claims, token, err
+}
+
+// parseShareIPRange accepts a CIDR or a single address.
+func parseShareIPRange(raw string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(raw); err == nil {
+		return prefix.Masked(), nil
+	}
+	if addr, err := netip.ParseAddr(raw); err == nil {
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	return netip.Prefix{}, errors.New("ip_range must be a CIDR or an IP address")
+}
+
+// registerAdminShareRoutes exposes POST /admin/share, which mints a signed,
+// expiring /events link for support engineers to share a live view of a
+// plan without signing in.
+func registerAdminShareRoutes(mux *http.ServeMux, token string) {
+	handle(mux, "POST /admin/share", adminAuthMiddleware(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := loadPlanShareKey()
+		if err != nil {
+			writeErrorResponse(w, r, http.StatusNotFound, "not_found", "plan sharing is disabled", nil)
+			return
+		}
+		var req planShareRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "invalid share request", nil)
+			return
+		}
+		claims, shareToken, err := mintPlanShare(key, req, clockNow())
+		if err != nil {
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+			return
+		}
+		recordPlanShareMint(r.Context(), r, claims)
+
+		query := url.Values{"plan_id": {claims.PlanID}, "share": {shareToken}}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(planShareResponse{
+			ID:        claims.ID,
+			URL:       configuredPublicBaseURL() + "/events?" + query.Encode(),
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+		})
+	})), policyAdminToken)
+}
+
+func recordPlanShareMint(ctx context.Context, r *http.Request, claims planShareClaims) {
+	actor := hashedActorFromRequest(r, nil, "admin")
+	gatewayAuditLogger.Security(audit.WithActor(ctx, actor), audit.Event{
+		Name:    auditEventPlanShareMint,
+		Outcome: auditOutcomeSuccess,
+		Target:  auditTargetPlanShare,
+		ActorID: actor,
+		Details: auditDetails(map[string]any{
+			"share_id":      claims.ID,
+			"plan_id_hash":  gatewayAuditLogger.HashIdentity(claims.PlanID),
+			"ip_restricted": claims.IPRange != "",
+			"ttl_seconds":   claims.ExpiresAt - claims.IssuedAt,
+		}),
+	})
+}
+
+// authorizePlanShare verifies the share token of a /events request and
+// records its use. Every rejected link gets the same 401, so a caller learns
+// nothing about the plan from it.
+func (h *EventsHandler) authorizePlanShare(ctx context.Context, w http.ResponseWriter, r *http.Request, shareToken, planID, clientAddr, planHash, clientHash string) bool {
+	details := map[string]any{
+		"plan_id_hash":   planHash,
+		"client_ip_hash": clientHash,
+	}
+	key, err := loadPlanShareKey()
+	var claims planShareClaims
+	if err == nil {
+		claims, err = verifyPlanShare(key, shareToken, planID, clientAddr, clockNow())
+	}
+	if claims.ID != "" {
+		details["share_id"] = claims.ID
+	}
+	if err != nil {
+		details["reason"] = err.Error()
+		h.recordShareUse(ctx, auditOutcomeDenied, details)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "unauthorized", "share link is invalid or expired", nil)
+		return false
+	}
+	h.recordShareUse(ctx, auditOutcomeSuccess, details)
+	return true
+}
+
+func (h *EventsHandler) recordShareUse(ctx context.Context, outcome string, details map[string]any) {
+	event := audit.Event{
+		Name:       auditEventPlanShareUse,
+		Outcome:    outcome,
+		Target:     auditTargetPlanShare,
+		Capability: auditCapabilityPlan,
+		Details:    audit.SanitizeDetails(details),
+	}
+	h.getAuditLogger().Security(ctx, event)
+}
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPlanShareKey = "0123456789abcdef0123456789abcdef"
+
+func TestPlanShareLinks(t *testing.T) {
+	logs := captureAuditLogs(t)
+	t.Setenv("GATEWAY_SSE_SHARE_KEY", testPlanShareKey)
+	t.Setenv("GATEWAY_PUBLIC_BASE_URL", "https://gateway.example.com")
+
+	var upstream http.Header
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: hello\n\n"))
+	}))
+	defer orchestrator.Close()
+	handler := NewEventsHandler(orchestrator.Client(), orchestrator.URL, 0, nil, nil)
+
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+	mint := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/admin/share", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	stream := func(target, remoteAddr string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Cookie", "session=someone-else")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := mint(`{"plan_id":"` + validPlanID + `","ttl":"10m","ip_range":"192.0.2.0/24"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the share to be minted, got %d %s", rec.Code, rec.Body.String())
+	}
+	var share planShareResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &share); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if !strings.HasPrefix(share.URL, "https://gateway.example.com/events?") || time.Until(share.ExpiresAt) > 10*time.Minute {
+		t.Fatalf("unexpected share %+v", share)
+	}
+	if !strings.Contains(logs.String(), auditEventPlanShareMint) || !strings.Contains(logs.String(), share.ID) {
+		t.Fatalf("expected the mint to be audited, got %s", logs.String())
+	}
+	link, _ := url.Parse(share.URL)
+
+	if rec := stream(link.RequestURI(), "192.0.2.10:4000"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "hello") {
+		t.Fatalf("expected the share link to stream, got %d %s", rec.Code, rec.Body.String())
+	}
+	if upstream.Get(planShareHeader) != link.Query().Get("share") || upstream.Get("Cookie") != "" {
+		t.Fatalf("expected the share token to replace the caller's credentials upstream, got %v", upstream)
+	}
+	if !strings.Contains(logs.String(), auditEventPlanShareUse) {
+		t.Fatalf("expected the use to be audited, got %s", logs.String())
+	}
+
+	if rec := stream(link.RequestURI(), "198.51.100.7:4000"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a client outside the range to be rejected, got %d", rec.Code)
+	}
+	if !strings.Contains(logs.String(), "client outside ip range") {
+		t.Fatalf("expected the rejection reason to be audited, got %s", logs.String())
+	}
+	other := "/events?plan_id=" + legacyPlanID + "&share=" + url.QueryEscape(link.Query().Get("share"))
+	if rec := stream(other, "192.0.2.10:4000"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the link to be bound to its plan, got %d", rec.Code)
+	}
+	if rec := stream(link.RequestURI()+"x", "192.0.2.10:4000"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a tampered link to be rejected, got %d", rec.Code)
+	}
+
+	for _, body := range []string{
+		`{"plan_id":"nope"}`,
+		`{"plan_id":"` + validPlanID + `","ttl":"48h"}`,
+		`{"plan_id":"` + validPlanID + `","ip_range":"somewhere"}`,
+	} {
+		if rec := mint(body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be rejected, got %d", body, rec.Code)
+		}
+	}
+}
+
+func TestVerifyPlanShareExpiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	claims, token, err := mintPlanShare([]byte(testPlanShareKey), planShareRequest{PlanID: validPlanID, TTL: "1m", IPRange: "2001:db8::1"}, now)
+	if err != nil || claims.IPRange != "2001:db8::1/128" {
+		t.Fatalf("unexpected share %+v: %v", claims, err)
+	}
+	if _, err := verifyPlanShare([]byte(testPlanShareKey), token, validPlanID, "2001:db8::1", now.Add(30*time.Second)); err != nil {
+		t.Fatalf("expected the share to be valid, got %v", err)
+	}
+	if _, err := verifyPlanShare([]byte(testPlanShareKey), token, validPlanID, "2001:db8::1", now.Add(time.Minute)); err == nil {
+		t.Fatal("expected the share to expire")
+	}
+}
+
+func TestAdminShareDisabledWithoutKey(t *testing.T) {
+	t.Setenv("GATEWAY_SSE_SHARE_KEY", "")
+	if rec := serveAdminDebug(t, http.MethodPost, "/admin/share", "s3cret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a share key, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/sse"
+)
+
+const (
+	defaultSSEBufferBytes = 32 * 1024
+	minSSEBufferBytes     = 1024
+	maxSSEBufferBytes     = 1 << 20
+)
+
+// sseBufferPool recycles fixed-size copy buffers across event streams so each
+// connection does not allocate its own buffer.
+type sseBufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newSSEBufferPool(size int) *sseBufferPool {
+	size = clampSSEBufferSize(size)
+	p := &sseBufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+func (p *sseBufferPool) get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+func (p *sseBufferPool) put(buf *[]byte) {
+	if buf == nil || len(*buf) != p.size {
+		return
+	}
+	p.pool.Put(buf)
+}
+
+func clampSSEBufferSize(size int) int {
+	switch {
+	case size <= 0:
+		return defaultSSEBufferBytes
+	case size < minSSEBufferBytes:
+		return minSSEBufferBytes
+	case size > maxSSEBufferBytes:
+		return maxSSEBufferBytes
+	default:
+		return size
+	}
+}
+
+// copySSEStream copies src to dst using buf and flushes only once a chunk
+// completes an event, rather than after every read. Partial events stay
+// buffered until their terminating blank line arrives; heartbeats still flush
+// periodically so idle connections are not starved.
+func copySSEStream(dst *flushingWriter, src io.Reader, buf []byte) (int64, error) {
+	var written int64
+	var tail []byte
+	var tailBuf [2]byte
+	for {
+		nr, readErr := src.Read(buf)
+		if nr > 0 {
+			chunk := buf[:nr]
+			flush := containsEventBoundary(tail, chunk)
+			nw, writeErr := dst.writeChunk(chunk, flush)
+			if nw < 0 || nw > nr {
+				nw = 0
+				if writeErr == nil {
+					writeErr = io.ErrShortWrite
+				}
+			}
+			written += int64(nw)
+			if writeErr != nil {
+				return written, writeErr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+			tail = trailingBytes(&tailBuf, tail, chunk)
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				return written, nil
+			}
+			return written, readErr
+		}
+	}
+}
+
+// trailingBytes records the last two bytes seen across chunk boundaries so a
+// blank line split between reads is still detected.
+func trailingBytes(dst *[2]byte, prev, chunk []byte) []byte {
+	if len(chunk) >= len(dst) {
+		copy(dst[:], chunk[len(chunk)-len(dst):])
+		return dst[:]
+	}
+	if len(prev) == 0 {
+		dst[0] = chunk[0]
+		return dst[:1]
+	}
+	last := prev[len(prev)-1]
+	dst[0], dst[1] = last, chunk[0]
+	return dst[:]
+}
+
+var sseEventBoundaries = [][]byte{[]byte("\n\n"), []byte("\r\r"), []byte("\n\r\n")}
+
+// containsEventBoundary reports whether chunk completes the blank line that
+// terminates an SSE event. The trailing bytes of the previous chunk are
+// consulted so boundaries split across reads are detected, while boundaries
+// that already ended inside the previous chunk are not counted twice.
+func containsEventBoundary(tail, chunk []byte) bool {
+	for _, boundary := range sseEventBoundaries {
+		if bytes.Contains(chunk, boundary) {
+			return true
+		}
+	}
+	if len(tail) == 0 {
+		return false
+	}
+	var joined [4]byte
+	n := copy(joined[:], tail)
+	n += copy(joined[n:], chunk)
+	for start := 0; start < len(tail); start++ {
+		for _, boundary := range sseEventBoundaries {
+			if start+len(boundary) > len(tail) && bytes.HasPrefix(joined[start:n], boundary) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sseEventTransform rewrites one upstream event into the events forwarded in
+// its place, which may be none.
+type sseEventTransform func(sse.Event) []sse.Event
+
+// transformSSEStream decodes events from src, passes each through transform
+// and writes the results as complete events, flushing after every one.
+// Stream-level fields such as retry are preserved.
+func transformSSEStream(dst *flushingWriter, src io.Reader, transform sseEventTransform) error {
+	decoder := sse.NewDecoder(src)
+	encoder := sse.NewEncoder(dst)
+	for {
+		event, err := decoder.Decode()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		for _, out := range transform(event) {
+			if err := encoder.Encode(out); err != nil {
+				return err
+			}
+		}
+	}
+}
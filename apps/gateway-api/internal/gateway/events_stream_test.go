@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type chunkedReader struct {
+	chunks []string
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks[0] = c.chunks[0][n:]
+	if c.chunks[0] == "" {
+		c.chunks = c.chunks[1:]
+	}
+	return n, nil
+}
+
+type countingFlushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (c *countingFlushRecorder) Flush() {
+	c.flushes++
+}
+
+func TestCopySSEStreamFlushesOnEventBoundaries(t *testing.T) {
+	rec := &countingFlushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	writer := &flushingWriter{w: rec, flusher: rec}
+	src := &chunkedReader{chunks: []string{"data: one", "\n", "\n", "data: two\n", "\ndata: partial"}}
+
+	written, err := copySSEStream(writer, src, make([]byte, 64))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "data: one\n\ndata: two\n\ndata: partial"
+	if written != int64(len(want)) {
+		t.Fatalf("expected %d bytes written, got %d", len(want), written)
+	}
+	if got := rec.Body.String(); got != want {
+		t.Fatalf("unexpected body %q", got)
+	}
+	if rec.flushes != 2 {
+		t.Fatalf("expected 2 flushes (one per completed event), got %d", rec.flushes)
+	}
+}
+
+func TestCopySSEStreamPropagatesReadErrors(t *testing.T) {
+	rec := &countingFlushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	writer := &flushingWriter{w: rec, flusher: rec}
+	readErr := errors.New("boom")
+
+	_, err := copySSEStream(writer, io.MultiReader(strings.NewReader("data: x\n\n"), &failingReader{err: readErr}), make([]byte, 16))
+	if !errors.Is(err, readErr) {
+		t.Fatalf("expected read error, got %v", err)
+	}
+}
+
+type failingReader struct {
+	err error
+}
+
+func (f *failingReader) Read([]byte) (int, error) {
+	return 0, f.err
+}
+
+func TestContainsEventBoundaryHandlesLineEndings(t *testing.T) {
+	cases := []struct {
+		name  string
+		tail  string
+		chunk string
+		want  bool
+	}{
+		{name: "lf", chunk: "data: x\n\n", want: true},
+		{name: "crlf", chunk: "data: x\r\n\r\n", want: true},
+		{name: "cr", chunk: "data: x\r\r", want: true},
+		{name: "split lf", tail: "x\n", chunk: "\ndata", want: true},
+		{name: "split crlf", tail: "\r\n", chunk: "\r\n", want: true},
+		{name: "single line", chunk: "data: x\n", want: false},
+		{name: "no newline", tail: "ab", chunk: "cd", want: false},
+		{name: "boundary already in tail", tail: "\n\n", chunk: "data", want: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := containsEventBoundary([]byte(tc.tail), []byte(tc.chunk)); got != tc.want {
+				t.Fatalf("containsEventBoundary(%q, %q) = %v, want %v", tc.tail, tc.chunk, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSSEBufferPoolClampsSize(t *testing.T) {
+	if got := newSSEBufferPool(0).size; got != defaultSSEBufferBytes {
+		t.Fatalf("expected default size, got %d", got)
+	}
+	if got := newSSEBufferPool(10).size; got != minSSEBufferBytes {
+		t.Fatalf("expected minimum size, got %d", got)
+	}
+	if got := newSSEBufferPool(maxSSEBufferBytes * 2).size; got != maxSSEBufferBytes {
+		t.Fatalf("expected maximum size, got %d", got)
+	}
+
+	pool := newSSEBufferPool(4096)
+	buf := pool.get()
+	if len(*buf) != 4096 {
+		t.Fatalf("expected 4096 byte buffer, got %d", len(*buf))
+	}
+	pool.put(buf)
+}
+
+func TestRegisterEventRoutesAppliesBufferSize(t *testing.T) {
+	t.Setenv("GATEWAY_SSE_BUFFER_BYTES", "8192")
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+
+	mux := http.NewServeMux()
+	RegisterEventRoutes(mux, EventRouteConfig{})
+	handler, _ := mux.Handler(httptest.NewRequest(http.MethodGet, "/events", nil))
+	events, ok := handler.(*EventsHandler)
+	if !ok {
+		t.Fatalf("expected *EventsHandler, got %T", handler)
+	}
+	if events.buffers.size != 8192 {
+		t.Fatalf("expected buffer size 8192, got %d", events.buffers.size)
+	}
+}
@@ -0,0 +1,239 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+const (
+	defaultPlanOwnerPath     = "/plan/{plan_id}/owner"
+	defaultPlanOwnerCacheTTL = 5 * time.Minute
+	planOwnerCacheNamespace  = "plan_owner"
+	planOwnerPathPlaceholder = "{plan_id}"
+)
+
+// eventsTenantIsolation checks that a plan belongs to the caller's tenant
+// before /events streams it, instead of leaving isolation entirely to the
+// orchestrator. Plans that do not exist and plans of another tenant are both
+// answered with 404 so plan IDs cannot be probed.
+type eventsTenantIsolation struct {
+	// session resolves the caller's session from its credentials.
+	session collaborationSessionValidator
+	// owner returns the plan's tenant ("" for a plan without one) and false
+	// when the orchestrator does not know the plan.
+	owner func(ctx context.Context, planID, authHeader, cookieHeader, requestID string) (string, bool, error)
+}
+
+// enforcePlanTenant reports whether the caller's session may stream planID,
+// writing the error response and audit record when it may not, and returns
+// ctx with the session's request scope. The credentials are the validated
+// ones about to be forwarded upstream.
+func (h *EventsHandler) enforcePlanTenant(ctx context.Context, w http.ResponseWriter, r *http.Request, planID string, upstream http.Header, planHash, clientAddr string) (context.Context, bool) {
+	authHeader := upstream.Get("Authorization")
+	cookieHeader := strings.Join(upstream.Values("Cookie"), "; ")
+	requestID := audit.RequestID(ctx)
+
+	clientHash := h.scopedClientHash(ctx, clientAddr)
+	session, status, err := h.tenantIsolation.session(ctx, authHeader, cookieHeader, requestID)
+	if err == nil && status != http.StatusOK {
+		h.recordAudit(ctx, auditOutcomeDenied, map[string]any{
+			"reason":         "invalid_session",
+			"plan_id_hash":   planHash,
+			"client_ip_hash": clientHash,
+		})
+		writeErrorResponse(w, r, http.StatusUnauthorized, "unauthorized", "authentication required", nil)
+		return ctx, false
+	}
+	var owner string
+	var found bool
+	if err == nil {
+		owner, found, err = h.tenantIsolation.owner(ctx, planID, authHeader, cookieHeader, requestID)
+	}
+	if err != nil {
+		h.recordAudit(ctx, auditOutcomeFailure, map[string]any{
+			"reason":         "tenant_check_failed",
+			"error":          err.Error(),
+			"plan_id_hash":   planHash,
+			"client_ip_hash": clientHash,
+		})
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to check plan access", nil)
+		return ctx, false
+	}
+
+	sessionTenant := ""
+	if session.TenantID != nil {
+		sessionTenant = *session.TenantID
+	}
+	ctx = withRequestScope(ctx, requestScope{TenantID: sessionTenant, SessionID: session.ID, BindingIDHash: session.BindingIDHash})
+	clientHash = h.scopedClientHash(ctx, clientAddr)
+	if found && owner == sessionTenant {
+		return ctx, true
+	}
+	details := map[string]any{
+		"reason":         "plan_not_found",
+		"plan_id_hash":   planHash,
+		"client_ip_hash": clientHash,
+	}
+	if found {
+		details["reason"] = "tenant_mismatch"
+		if sessionTenant != "" {
+			details["session_tenant_hash"] = gatewayAuditLogger.HashIdentity("tenant", sessionTenant)
+		}
+	}
+	h.recordAudit(ctx, auditOutcomeDenied, details)
+	writeErrorResponse(w, r, http.StatusNotFound, "not_found", "plan not found", nil)
+	return ctx, false
+}
+
+// scopedClientHash hashes the client address with the salt of the tenant in
+// ctx, keeping an unknown address empty.
+func (h *EventsHandler) scopedClientHash(ctx context.Context, clientAddr string) string {
+	if clientAddr == "" {
+		return ""
+	}
+	return h.getAuditLogger().HashTenantIdentity(requestScopeFrom(ctx).TenantID, clientAddr)
+}
+
+// planOwnerLookup asks the orchestrator which tenant owns a plan. Owners do
+// not change, so answers are remembered in the state store for the TTL;
+// unknown plans are never cached so a new plan is visible immediately.
+type planOwnerLookup struct {
+	// path replaces the orchestrator API's owner path when set.
+	path  string
+	cache storage.Store
+	ttl   time.Duration
+}
+
+func (l *planOwnerLookup) lookup(ctx context.Context, planID, authHeader, cookieHeader, requestID string) (string, bool, error) {
+	if l.cache != nil {
+		if data, err := l.cache.Get(ctx, planID); err == nil {
+			return string(data), true, nil
+		}
+	}
+
+	client, orchestratorURL, err := currentOrchestrator()
+	if err != nil {
+		return "", false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	target := orchestratorAPI.URL(ctx, orchestratorURL, orchestratorPlanOwner, "plan_id", planID)
+	if l.path != "" {
+		target = strings.TrimRight(orchestratorURL, "/") + strings.ReplaceAll(l.path, planOwnerPathPlaceholder, url.PathEscape(planID))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	if cookieHeader != "" {
+		req.Header.Set("Cookie", cookieHeader)
+	}
+	if requestID != "" {
+		req.Header.Set("X-Request-Id", requestID)
+		req.Header.Set("X-Trace-Id", requestID)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden, http.StatusNotFound:
+		return "", false, nil
+	default:
+		return "", false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		TenantID *string `json:"tenantId"`
+	}
+	data, err := readUpstreamBody(resp.Body, maxUpstreamResponseBytes())
+	if err != nil {
+		return "", false, err
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return "", false, err
+	}
+	tenant := ""
+	if payload.TenantID != nil {
+		tenant = *payload.TenantID
+	}
+	if l.cache != nil {
+		_ = l.cache.Set(ctx, planID, []byte(tenant), l.ttl)
+	}
+	return tenant, true, nil
+}
+
+var (
+	eventsTenantIsolationMu   sync.Mutex
+	eventsTenantIsolationOnce sync.Once
+	sharedTenantIsolation     *eventsTenantIsolation
+	sharedTenantIsolationErr  error
+)
+
+// resetEventsTenantIsolation clears the cached configuration for tests.
+func resetEventsTenantIsolation() {
+	eventsTenantIsolationMu.Lock()
+	defer eventsTenantIsolationMu.Unlock()
+	eventsTenantIsolationOnce = sync.Once{}
+	sharedTenantIsolation = nil
+	sharedTenantIsolationErr = nil
+}
+
+// loadEventsTenantIsolation returns the /events tenant check, or nil unless
+// GATEWAY_SSE_TENANT_ISOLATION is enabled.
+func loadEventsTenantIsolation() (*eventsTenantIsolation, error) {
+	eventsTenantIsolationMu.Lock()
+	defer eventsTenantIsolationMu.Unlock()
+	eventsTenantIsolationOnce.Do(func() {
+		sharedTenantIsolation, sharedTenantIsolationErr = parseEventsTenantIsolation()
+	})
+	return sharedTenantIsolation, sharedTenantIsolationErr
+}
+
+func parseEventsTenantIsolation() (*eventsTenantIsolation, error) {
+	if !getBoolEnv("GATEWAY_SSE_TENANT_ISOLATION") {
+		return nil, nil
+	}
+	ownerPath := GetEnv("GATEWAY_SSE_PLAN_OWNER_PATH", "")
+	if ownerPath != "" && (!strings.HasPrefix(ownerPath, "/") || !strings.Contains(ownerPath, planOwnerPathPlaceholder)) {
+		return nil, errors.New("GATEWAY_SSE_PLAN_OWNER_PATH must start with / and contain " + planOwnerPathPlaceholder)
+	}
+	owners := &planOwnerLookup{
+		path: ownerPath,
+		ttl:  GetDurationEnv("GATEWAY_SSE_PLAN_OWNER_CACHE_TTL", defaultPlanOwnerCacheTTL),
+	}
+	if owners.ttl > 0 {
+		store, err := loadStateStorage()
+		if err != nil {
+			return nil, err
+		}
+		owners.cache = storage.Namespace(store, planOwnerCacheNamespace)
+	}
+	return &eventsTenantIsolation{session: lookupOrchestratorSession, owner: owners.lookup}, nil
+}
+
+// ValidateEventsTenantIsolationConfig checks the GATEWAY_SSE_TENANT_ISOLATION
+// settings at startup.
+func ValidateEventsTenantIsolationConfig() error {
+	_, err := loadEventsTenantIsolation()
+	return err
+}
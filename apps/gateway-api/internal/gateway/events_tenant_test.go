@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const otherPlanID = "plan-6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+func TestEventsTenantIsolation(t *testing.T) {
+	logs := captureAuditLogs(t)
+	var ownerLookups, streams atomic.Int32
+	var streamedTenant atomic.Value
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/session":
+			switch r.Header.Get("Authorization") {
+			case "Bearer acme":
+				_, _ = w.Write([]byte(`{"session":{"id":"s1","tenantId":"acme"}}`))
+			case "Bearer globex":
+				_, _ = w.Write([]byte(`{"session":{"id":"s2","tenantId":"globex"}}`))
+			default:
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		case "/plan/" + validPlanID + "/owner":
+			ownerLookups.Add(1)
+			_, _ = w.Write([]byte(`{"tenantId":"acme"}`))
+		case "/plan/" + validPlanID + "/events":
+			streams.Add(1)
+			streamedTenant.Store(r.Header.Get("X-Tenant-Id"))
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: hello\n\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer orchestrator.Close()
+
+	t.Setenv("ORCHESTRATOR_URL", orchestrator.URL)
+	t.Setenv("GATEWAY_SSE_TENANT_ISOLATION", "true")
+	t.Setenv("GATEWAY_STORAGE_URL", "")
+	ResetOrchestratorClient()
+	resetStateStorage()
+	resetEventsTenantIsolation()
+	t.Cleanup(func() {
+		ResetOrchestratorClient()
+		resetStateStorage()
+		resetEventsTenantIsolation()
+	})
+	isolation, err := loadEventsTenantIsolation()
+	if err != nil || isolation == nil {
+		t.Fatalf("expected tenant isolation to be enabled, got %v", err)
+	}
+	handler := NewEventsHandler(orchestrator.Client(), orchestrator.URL, 0, nil, nil)
+	handler.tenantIsolation = isolation
+
+	serve := func(planID, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/events?plan_id="+planID, nil)
+		req.Header.Set("X-Tenant-Id", "globex")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(validPlanID, "acme"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "hello") {
+		t.Fatalf("expected the owning tenant to stream, got %d %s", rec.Code, rec.Body.String())
+	}
+	if got := streamedTenant.Load(); got != "acme" {
+		t.Fatalf("expected the session's tenant upstream in place of the claimed one, got %v", got)
+	}
+	if !strings.Contains(logs.String(), `"tenant_id_hash":"`+hashTenantID("acme")+`"`) {
+		t.Fatalf("expected the stream to be audited against the session's tenant, got %s", logs.String())
+	}
+
+	mismatch := serve(validPlanID, "globex")
+	unknown := serve(otherPlanID, "globex")
+	if mismatch.Code != http.StatusNotFound || unknown.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for another tenant's plan and an unknown plan, got %d and %d", mismatch.Code, unknown.Code)
+	}
+	if mismatch.Body.String() != unknown.Body.String() {
+		t.Fatalf("expected indistinguishable denials, got %q and %q", mismatch.Body.String(), unknown.Body.String())
+	}
+	if !strings.Contains(logs.String(), `"reason":"tenant_mismatch"`) || !strings.Contains(logs.String(), `"reason":"plan_not_found"`) {
+		t.Fatalf("expected both denials to be audited, got %s", logs.String())
+	}
+
+	if rec := serve(validPlanID, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a session, got %d", rec.Code)
+	}
+	if streams.Load() != 1 {
+		t.Fatalf("expected only the owning tenant to reach the upstream stream, got %d streams", streams.Load())
+	}
+	if ownerLookups.Load() != 1 {
+		t.Fatalf("expected the plan owner to be cached, got %d lookups", ownerLookups.Load())
+	}
+}
+
+func TestParseEventsTenantIsolation(t *testing.T) {
+	t.Setenv("GATEWAY_SSE_TENANT_ISOLATION", "")
+	if isolation, err := parseEventsTenantIsolation(); err != nil || isolation != nil {
+		t.Fatalf("expected isolation to be off by default, got %v %v", isolation, err)
+	}
+	t.Setenv("GATEWAY_SSE_TENANT_ISOLATION", "true")
+	t.Setenv("GATEWAY_SSE_PLAN_OWNER_PATH", "/plans/owner")
+	if _, err := parseEventsTenantIsolation(); err == nil {
+		t.Fatal("expected a path without {plan_id} to be rejected")
+	}
+}
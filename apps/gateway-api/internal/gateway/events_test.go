@@ -498,7 +498,7 @@ func TestEventsHandlerRateLimitsConnectionAttempts(t *testing.T) {
 	defer orchestrator.Close()
 
 	handler := NewEventsHandler(orchestrator.Client(), orchestrator.URL, 0, nil, nil)
-	handler.attemptLimiter = newRateLimiter()
+	handler.attemptLimiter = newRateLimiter("test")
 	now := time.Unix(0, 0)
 	handler.attemptLimiter.now = func() time.Time { return now }
 	handler.attemptBucket = rateLimitBucket{Endpoint: "events.connect", IdentityType: "ip", Limit: 1, Window: time.Minute}
@@ -690,6 +690,35 @@ func TestEventsHandlerReleasesLimiterOnWriterErrors(t *testing.T) {
 	limiter.Release("203.0.113.5")
 }
 
+func TestConnectionLimiterEnforcesLimitPerKeyUnderContention(t *testing.T) {
+	t.Setenv("GATEWAY_LIMITER_SHARDS", "4")
+	limiter := newConnectionLimiter(3)
+	var acquired atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if limiter.Acquire(fmt.Sprintf("198.51.100.%d", i%8)) {
+				acquired.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := acquired.Load(); got != 24 {
+		t.Fatalf("expected three connections for each of eight clients, got %d", got)
+	}
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("198.51.100.%d", i)
+		for j := 0; j < 3; j++ {
+			limiter.Release(key)
+		}
+		if shard := limiter.shard(key); shard.counts[key] != 0 {
+			t.Fatalf("expected %s to be released, got %d", key, shard.counts[key])
+		}
+	}
+}
+
 func TestEventsHandlerTerminatesOnHeartbeatWriteFailure(t *testing.T) {
 	body := newBlockingReadCloser()
 	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
@@ -898,3 +927,31 @@ func (b *blockingReadCloser) Close() error {
 	})
 	return nil
 }
+
+func TestEventsHandlerAuditCarriesSessionBinding(t *testing.T) {
+	bindingHash := hashSessionBinding("bind-123")
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set(sessionBindingHashHeader, bindingHash)
+		_, _ = io.WriteString(w, "data: ok\n\n")
+	}))
+	defer orchestrator.Close()
+
+	var buf bytes.Buffer
+	original := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{})))
+	defer slog.SetDefault(original)
+
+	handler := NewEventsHandler(orchestrator.Client(), orchestrator.URL, 0, nil, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events?plan_id="+validPlanID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected success status, got %d", rec.Code)
+	}
+	if !strings.Contains(buf.String(), `"binding_id_hash":"`+bindingHash+`"`) {
+		t.Fatalf("expected the subscription audit to carry the binding hash, got %s", buf.String())
+	}
+	if rec.Header().Get(sessionBindingHashHeader) != "" {
+		t.Fatal("expected the binding hash not to be forwarded to the client")
+	}
+}
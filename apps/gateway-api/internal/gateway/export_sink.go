@@ -0,0 +1,336 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	errExportObjectExists   = errors.New("export object already exists")
+	errExportObjectNotFound = errors.New("export object not found")
+)
+
+const maxExportObjectBytes = 64 << 20
+
+// exportSink stores write-once objects. Put must fail with
+// errExportObjectExists instead of replacing an object, which is what makes
+// retried exports safe.
+type exportSink interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// newExportSink builds a sink from file:///dir or s3://bucket/prefix.
+func newExportSink(rawURL string) (exportSink, error) {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid export url: %w", err)
+	}
+	switch parsed.Scheme {
+	case "file":
+		if parsed.Host != "" && parsed.Host != "localhost" {
+			return nil, fmt.Errorf("file export url must not name a host, got %q", parsed.Host)
+		}
+		if !filepath.IsAbs(parsed.Path) {
+			return nil, errors.New("file export url must use an absolute path")
+		}
+		if err := os.MkdirAll(parsed.Path, 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create export directory: %w", err)
+		}
+		return &fileExportSink{root: parsed.Path}, nil
+	case "s3":
+		return newS3ExportSink(parsed)
+	default:
+		return nil, fmt.Errorf("unsupported export url scheme %q", parsed.Scheme)
+	}
+}
+
+// fileExportSink writes objects below root. Objects are written to a temporary
+// file and hard-linked into place so readers never see partial objects and an
+// existing object is never replaced.
+type fileExportSink struct {
+	root string
+}
+
+func (s *fileExportSink) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned != "/"+key {
+		return "", fmt.Errorf("invalid export key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *fileExportSink) Put(_ context.Context, key string, data []byte) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Link(tmp.Name(), target); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return errExportObjectExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *fileExportSink) Get(_ context.Context, key string) ([]byte, error) {
+	target, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errExportObjectNotFound
+	}
+	return data, err
+}
+
+// s3ExportSink talks to S3 or an S3-compatible store using path-style
+// requests signed with SigV4. Puts send If-None-Match: * so the store rejects
+// overwrites.
+type s3ExportSink struct {
+	client          *http.Client
+	endpoint        *url.URL
+	bucket          string
+	prefix          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	now             func() time.Time
+}
+
+func newS3ExportSink(parsed *url.URL) (*s3ExportSink, error) {
+	if parsed.Host == "" {
+		return nil, errors.New("s3 export url must name a bucket")
+	}
+	region := GetEnv("GATEWAY_USAGE_EXPORT_S3_REGION", "us-east-1")
+	rawEndpoint := GetEnv("GATEWAY_USAGE_EXPORT_S3_ENDPOINT", "https://s3."+region+".amazonaws.com")
+	endpoint, err := url.Parse(strings.TrimRight(strings.TrimSpace(rawEndpoint), "/"))
+	if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid GATEWAY_USAGE_EXPORT_S3_ENDPOINT %q", rawEndpoint)
+	}
+	accessKeyID, err := ResolveEnvValue("GATEWAY_USAGE_EXPORT_S3_ACCESS_KEY_ID")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_USAGE_EXPORT_S3_ACCESS_KEY_ID: %w", err)
+	}
+	secretAccessKey, err := ResolveEnvValue("GATEWAY_USAGE_EXPORT_S3_SECRET_ACCESS_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_USAGE_EXPORT_S3_SECRET_ACCESS_KEY: %w", err)
+	}
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, errors.New("s3 export requires GATEWAY_USAGE_EXPORT_S3_ACCESS_KEY_ID and GATEWAY_USAGE_EXPORT_S3_SECRET_ACCESS_KEY")
+	}
+	sessionToken, err := ResolveEnvValue("GATEWAY_USAGE_EXPORT_S3_SESSION_TOKEN")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_USAGE_EXPORT_S3_SESSION_TOKEN: %w", err)
+	}
+	return &s3ExportSink{
+		client:          &http.Client{Timeout: 30 * time.Second, Transport: observeUpstreamCalls(egressUsageExport, newUpstreamTransport(egressUsageExport))},
+		endpoint:        endpoint,
+		bucket:          parsed.Host,
+		prefix:          strings.Trim(parsed.Path, "/"),
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		now:             time.Now,
+	}, nil
+}
+
+func (s *s3ExportSink) objectURL(key string) string {
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	return s.endpoint.String() + "/" + s.bucket + "/" + key
+}
+
+func (s *s3ExportSink) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodPut {
+		req.Header.Set("If-None-Match", "*")
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	signSigV4(req, body, s.accessKeyID, s.secretAccessKey, s.region, "s3", s.now())
+	return s.client.Do(req)
+}
+
+func (s *s3ExportSink) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return errExportObjectExists
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	default:
+		return fmt.Errorf("s3 put %s returned %d", key, resp.StatusCode)
+	}
+}
+
+func (s *s3ExportSink) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errExportObjectNotFound
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, fmt.Errorf("s3 get %s returned %d", key, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxExportObjectBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxExportObjectBytes {
+		return nil, fmt.Errorf("s3 object %s exceeds %d bytes", key, maxExportObjectBytes)
+	}
+	return data, nil
+}
+
+// signSigV4 adds AWS Signature Version 4 headers to req. Every header already
+// on the request is signed along with host.
+func signSigV4(req *http.Request, body []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "authorization" || lower == "user-agent" || lower == "content-length" {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[lower] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4EncodePath(req.URL.Path),
+		sigV4CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sigV4EncodePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = sigV4Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func sigV4CanonicalQuery(values url.Values) string {
+	pairs := make([]string, 0, len(values))
+	for name, list := range values {
+		for _, value := range list {
+			pairs = append(pairs, sigV4Escape(name)+"="+sigV4Escape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes everything except the RFC 3986 unreserved
+// characters, as SigV4 requires.
+func sigV4Escape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
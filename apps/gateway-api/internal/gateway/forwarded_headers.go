@@ -0,0 +1,264 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Route groups with their own forwarded header allowlist. Each group's list
+// can be extended with GATEWAY_FORWARDED_HEADERS_<GROUP>.
+const (
+	headerGroupEvents        = "events"
+	headerGroupArtifacts     = "artifacts"
+	headerGroupAttachments   = "attachments"
+	headerGroupCollaboration = "collaboration"
+
+	defaultForwardedHeaderMaxBytes = 1024
+	maxForwardedHeaderMaxBytes     = 64 * 1024
+)
+
+// forwardedHeader is one client header a route group passes to the
+// orchestrator, with the largest value it accepts.
+type forwardedHeader struct {
+	name     string
+	maxBytes int
+}
+
+var traceHeaders = []forwardedHeader{
+	{name: "X-Agent", maxBytes: defaultForwardedHeaderMaxBytes},
+	{name: "X-Request-Id", maxBytes: defaultForwardedHeaderMaxBytes},
+	{name: "X-B3-Traceid", maxBytes: 64},
+	{name: "X-B3-Spanid", maxBytes: 64},
+	{name: "X-B3-Sampled", maxBytes: 8},
+	{name: "Traceparent", maxBytes: 128},
+	{name: "Tracestate", maxBytes: 512},
+}
+
+// defaultForwardedHeaders lists what each group forwards without
+// configuration. Credentials and headers the gateway sets itself are handled
+// by the routes and are not listed, except on the collaboration proxy, which
+// forwards the request it authenticated.
+var defaultForwardedHeaders = map[string][]forwardedHeader{
+	headerGroupEvents: traceHeaders,
+	// Conditional headers let range and conditional requests resume
+	// downloads end-to-end.
+	headerGroupArtifacts: append([]forwardedHeader{
+		{name: "If-Range", maxBytes: defaultForwardedHeaderMaxBytes},
+		{name: "If-None-Match", maxBytes: defaultForwardedHeaderMaxBytes},
+		{name: "If-Modified-Since", maxBytes: 64},
+		{name: "X-Tenant-Id", maxBytes: 256},
+	}, traceHeaders...),
+	headerGroupAttachments: traceHeaders,
+	headerGroupCollaboration: append([]forwardedHeader{
+		{name: "Authorization", maxBytes: maxAuthorizationHeaderLen},
+		{name: "Cookie", maxBytes: maxForwardedCookieHeaderLen},
+		{name: "Origin", maxBytes: defaultForwardedHeaderMaxBytes},
+		{name: "User-Agent", maxBytes: defaultForwardedHeaderMaxBytes},
+		{name: "X-Session-Id", maxBytes: 256},
+		{name: "X-Tenant-Id", maxBytes: 256},
+		{name: "X-Project-Id", maxBytes: 256},
+		{name: "Sec-WebSocket-Key", maxBytes: 64},
+		{name: "Sec-WebSocket-Version", maxBytes: 16},
+		{name: websocketProtocolHeader, maxBytes: defaultForwardedHeaderMaxBytes},
+		{name: websocketExtensionsHeader, maxBytes: defaultForwardedHeaderMaxBytes},
+	}, traceHeaders...),
+}
+
+// reservedForwardedHeaders are owned by the gateway or the transport and
+// cannot be added to an allowlist through configuration.
+var reservedForwardedHeaders = []string{
+	"Authorization", "Cookie", "Host", "Connection", "Upgrade", "Keep-Alive",
+	"Te", "Trailer", "Transfer-Encoding", "Content-Length", "Content-Type",
+	"Forwarded", "X-Real-Ip", serviceTokenHeader, planShareHeader, sessionBindingHashHeader,
+}
+
+func reservedForwardedHeader(name string) bool {
+	for _, prefix := range []string{"Proxy-", "X-Forwarded-", "Sec-Websocket-"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for _, reserved := range reservedForwardedHeaders {
+		if name == http.CanonicalHeaderKey(reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerPolicy is the allowlist of one route group.
+type headerPolicy struct {
+	headers []forwardedHeader
+}
+
+// forwardedHeaderError names the header that failed validation.
+type forwardedHeaderError struct {
+	header string
+	err    error
+}
+
+func (e *forwardedHeaderError) Error() string {
+	return fmt.Sprintf("%s header %v", strings.ToLower(e.header), e.err)
+}
+
+// check validates every allowlisted header present in src.
+func (p headerPolicy) check(src http.Header) *forwardedHeaderError {
+	for _, header := range p.headers {
+		for _, value := range src.Values(header.name) {
+			if len(value) > header.maxBytes {
+				return &forwardedHeaderError{header: header.name, err: fmt.Errorf("exceeds %d bytes", header.maxBytes)}
+			}
+			if hasUnsafeHeaderRunes(value) {
+				return &forwardedHeaderError{header: header.name, err: errors.New("contains invalid characters")}
+			}
+		}
+	}
+	return nil
+}
+
+// forward copies the allowlisted headers from src to dst once all of them
+// pass check; nothing is copied otherwise.
+func (p headerPolicy) forward(dst, src http.Header) *forwardedHeaderError {
+	if err := p.check(src); err != nil {
+		return err
+	}
+	names := make([]string, len(p.headers))
+	for i, header := range p.headers {
+		names[i] = header.name
+	}
+	CloneHeaders(dst, src, names)
+	return nil
+}
+
+func (p headerPolicy) caps() map[string]int {
+	caps := make(map[string]int, len(p.headers))
+	for _, header := range p.headers {
+		caps[header.name] = header.maxBytes
+	}
+	return caps
+}
+
+var (
+	forwardedHeadersMu   sync.Mutex
+	forwardedHeadersOnce sync.Once
+	forwardedHeaderSets  map[string]headerPolicy
+	forwardedHeadersErr  error
+)
+
+// resetForwardedHeaders clears the cached configuration for tests.
+func resetForwardedHeaders() {
+	forwardedHeadersMu.Lock()
+	defer forwardedHeadersMu.Unlock()
+	forwardedHeadersOnce = sync.Once{}
+	forwardedHeaderSets = nil
+	forwardedHeadersErr = nil
+}
+
+func loadForwardedHeaders() (map[string]headerPolicy, error) {
+	forwardedHeadersMu.Lock()
+	defer forwardedHeadersMu.Unlock()
+	forwardedHeadersOnce.Do(func() {
+		forwardedHeaderSets, forwardedHeadersErr = parseForwardedHeaders()
+	})
+	return forwardedHeaderSets, forwardedHeadersErr
+}
+
+// ValidateForwardedHeaderConfig checks the GATEWAY_FORWARDED_HEADERS_*
+// settings at startup.
+func ValidateForwardedHeaderConfig() error {
+	_, err := parseForwardedHeaders()
+	return err
+}
+
+// parseForwardedHeaders adds the configured entries, "Name" or
+// "Name:maxBytes", to each group's defaults. An entry naming a default
+// header changes its size cap.
+func parseForwardedHeaders() (map[string]headerPolicy, error) {
+	policies := make(map[string]headerPolicy, len(defaultForwardedHeaders))
+	for group, defaults := range defaultForwardedHeaders {
+		headers := append([]forwardedHeader(nil), defaults...)
+		key := "GATEWAY_FORWARDED_HEADERS_" + strings.ToUpper(group)
+		for _, entry := range strings.Split(GetEnv(key, ""), ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			header, err := parseForwardedHeader(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s entry %q: %w", key, entry, err)
+			}
+			replaced := false
+			for i := range headers {
+				if http.CanonicalHeaderKey(headers[i].name) == header.name {
+					headers[i].maxBytes, replaced = header.maxBytes, true
+				}
+			}
+			if !replaced {
+				if reservedForwardedHeader(header.name) {
+					return nil, fmt.Errorf("invalid %s entry %q: header is set by the gateway", key, entry)
+				}
+				headers = append(headers, header)
+			}
+		}
+		policies[group] = headerPolicy{headers: headers}
+	}
+	return policies, nil
+}
+
+func parseForwardedHeader(entry string) (forwardedHeader, error) {
+	name, limit, hasLimit := strings.Cut(entry, ":")
+	name = strings.TrimSpace(name)
+	if !isHTTPToken(name) {
+		return forwardedHeader{}, errors.New("header name is not a token")
+	}
+	header := forwardedHeader{name: http.CanonicalHeaderKey(name), maxBytes: defaultForwardedHeaderMaxBytes}
+	if hasLimit {
+		maxBytes, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || maxBytes <= 0 || maxBytes > maxForwardedHeaderMaxBytes {
+			return forwardedHeader{}, fmt.Errorf("size cap must be between 1 and %d bytes", maxForwardedHeaderMaxBytes)
+		}
+		header.maxBytes = maxBytes
+	}
+	return header, nil
+}
+
+// forwardedHeaders returns the allowlist of group. Configuration errors are
+// caught when routes are registered, so they fall back to the defaults here.
+func forwardedHeaders(group string) headerPolicy {
+	policies, err := loadForwardedHeaders()
+	if err != nil {
+		return headerPolicy{headers: defaultForwardedHeaders[group]}
+	}
+	return policies[group]
+}
+
+const forwardHeadersPolicyPrefix = "forward_headers:"
+
+// policyForwardHeaders names group's allowlist in the route table. It is
+// called while routes are registered and panics on invalid configuration.
+func policyForwardHeaders(group string) string {
+	if err := ValidateForwardedHeaderConfig(); err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("invalid forwarded header configuration: %v", err))
+	}
+	return forwardHeadersPolicyPrefix + group
+}
+
+// routeForwardedHeaders returns the size caps of the allowlist a route's
+// policies name, as currently configured, or nil.
+func routeForwardedHeaders(policies []string) map[string]int {
+	for _, policy := range policies {
+		if group, ok := strings.CutPrefix(policy, forwardHeadersPolicyPrefix); ok {
+			configured, err := parseForwardedHeaders()
+			if err != nil {
+				return nil
+			}
+			return configured[group].caps()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func useForwardedHeaders(t *testing.T, env map[string]string) {
+	t.Helper()
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	resetForwardedHeaders()
+	t.Cleanup(resetForwardedHeaders)
+}
+
+func TestParseForwardedHeaders(t *testing.T) {
+	t.Setenv("GATEWAY_FORWARDED_HEADERS_EVENTS", "x-feature-flags, Traceparent:256")
+	t.Setenv("GATEWAY_FORWARDED_HEADERS_ARTIFACTS", "X-Client-Version:32")
+	policies, err := parseForwardedHeaders()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	events := policies[headerGroupEvents].caps()
+	if events["X-Feature-Flags"] != defaultForwardedHeaderMaxBytes || events["Traceparent"] != 256 || len(events) != len(traceHeaders)+1 {
+		t.Fatalf("unexpected events allowlist %v", events)
+	}
+	if policies[headerGroupArtifacts].caps()["X-Client-Version"] != 32 || policies[headerGroupAttachments].caps()["X-Client-Version"] != 0 {
+		t.Fatal("expected additions to apply to their own group only")
+	}
+
+	for _, entry := range []string{"Authorization", "X-Forwarded-Host", "X-Gateway-Service-Token", "Bad Header", "X-Flag:0", "X-Flag:big"} {
+		t.Setenv("GATEWAY_FORWARDED_HEADERS_EVENTS", entry)
+		if err := ValidateForwardedHeaderConfig(); err == nil {
+			t.Fatalf("expected %q to be rejected", entry)
+		}
+	}
+}
+
+func TestHeaderPolicyForward(t *testing.T) {
+	policy := headerPolicy{headers: []forwardedHeader{{name: "X-Agent", maxBytes: 8}, {name: "Traceparent", maxBytes: 64}}}
+	src := http.Header{}
+	src.Set("X-Agent", "cli")
+	src.Set("X-Internal", "secret")
+	dst := http.Header{}
+	if err := policy.forward(dst, src); err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+	if dst.Get("X-Agent") != "cli" || dst.Get("X-Internal") != "" {
+		t.Fatalf("expected only allowlisted headers, got %v", dst)
+	}
+
+	src.Set("X-Agent", "a-very-long-agent")
+	if err := policy.forward(http.Header{}, src); err == nil || err.header != "X-Agent" || !strings.Contains(err.Error(), "exceeds 8 bytes") {
+		t.Fatalf("expected the size cap to apply, got %v", err)
+	}
+	src.Set("X-Agent", "cli")
+	src.Set("Traceparent", "00-é")
+	if err := policy.forward(http.Header{}, src); err == nil || !strings.Contains(err.Error(), "invalid characters") {
+		t.Fatalf("expected unsafe characters to be rejected, got %v", err)
+	}
+}
+
+func TestEventsHandlerForwardsConfiguredHeaders(t *testing.T) {
+	useForwardedHeaders(t, map[string]string{"GATEWAY_FORWARDED_HEADERS_EVENTS": "X-Feature-Flags:16"})
+	var received http.Header
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer orchestrator.Close()
+	handler := NewEventsHandler(orchestrator.Client(), orchestrator.URL, 0, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/events?plan_id="+validPlanID, nil)
+	req.Header.Set("X-Feature-Flags", "beta")
+	req.Header.Set("X-Debug", "1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if received.Get("X-Feature-Flags") != "beta" || received.Get("X-Debug") != "" {
+		t.Fatalf("expected only allowlisted headers upstream, got %v", received)
+	}
+
+	logs := captureAuditLogs(t)
+	received = nil
+	req = httptest.NewRequest(http.MethodGet, "/events?plan_id="+validPlanID, nil)
+	req.Header.Set("X-Feature-Flags", strings.Repeat("f", 17))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || received != nil {
+		t.Fatalf("expected an oversized header to be rejected before connecting, got %d", rec.Code)
+	}
+	if !strings.Contains(logs.String(), `"header":"x-feature-flags"`) {
+		t.Fatalf("expected the rejection to be audited, got %s", logs.String())
+	}
+}
+
+func TestCollaborationProxyForwardsAllowlistedHeaders(t *testing.T) {
+	useForwardedHeaders(t, nil)
+	var received http.Header
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer orchestrator.Close()
+	t.Setenv("ORCHESTRATOR_URL", orchestrator.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+
+	req := httptest.NewRequest(http.MethodGet, "/collaboration/ws?filePath=a.md", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-Session-Id", "session-1")
+	req.Header.Set("X-Debug", "1")
+	req.Header.Set(serviceTokenHeader, "v1.forged.token")
+	collaborationHeaderMiddleware(newCollaborationProxy()).ServeHTTP(httptest.NewRecorder(), req)
+	if received.Get("Authorization") != "Bearer token" || received.Get("X-Session-Id") != "session-1" || received.Get("X-Forwarded-For") == "" {
+		t.Fatalf("expected the authenticated request upstream, got %v", received)
+	}
+	if received.Get("X-Debug") != "" || received.Get(serviceTokenHeader) != "" {
+		t.Fatalf("expected headers outside the allowlist to be dropped, got %v", received)
+	}
+
+	received = nil
+	req.Header.Set("X-Project-Id", strings.Repeat("p", 257))
+	rec := httptest.NewRecorder()
+	collaborationHeaderMiddleware(newCollaborationProxy()).ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || received != nil {
+		t.Fatalf("expected an oversized header to be rejected, got %d", rec.Code)
+	}
+}
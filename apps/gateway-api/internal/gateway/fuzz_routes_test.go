@@ -0,0 +1,202 @@
+//go:build fuzzbeta
+// +build fuzzbeta
+
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+// fuzzRoute describes a registered route and the query parameters its
+// handler reads. Wildcards in the pattern and every listed parameter are
+// filled from fuzz input.
+type fuzzRoute struct {
+	pattern string
+	query   []string
+}
+
+// fuzzRoutes covers the auth, events and collaboration routes.
+// TestFuzzRoutesAreRegistered keeps the table in step with the mux.
+var fuzzRoutes = []fuzzRoute{
+	{pattern: "GET /auth/{provider}/authorize", query: []string{"redirect_uri", "tenant_id", "client_app", "prompt", "max_age", "session_binding", "landing_path"}},
+	{pattern: "GET /auth/{provider}/link", query: []string{"redirect_uri", "tenant_id", "client_app", "prompt", "max_age"}},
+	{pattern: "GET /auth/{provider}/callback", query: []string{"code", "state", "error", "error_description"}},
+	{pattern: "GET /auth/{provider}/jwks", query: []string{"kid"}},
+	{pattern: "GET /auth/providers", query: []string{"tenant_id"}},
+	{pattern: "GET /auth/logout", query: []string{"post_logout_redirect_uri", "state", "tenant_id", "client_app"}},
+	{pattern: "GET /auth/stepup", query: []string{"provider", "redirect_uri", "acr_values", "max_age", "prompt", "client_id", "step_up_token"}},
+	{pattern: "GET /events", query: []string{"plan_id"}},
+	{pattern: "GET /collaboration/ws", query: []string{"filePath", "tenantId", "projectId", "sessionId"}},
+	{pattern: "GET /collaboration/presence", query: []string{"filePath", "tenantId", "projectId"}},
+}
+
+// newFuzzGateway registers the fuzzed routes behind the same router and
+// middleware the gateway serves them with. Upstream calls reach a fake
+// orchestrator that answers event streams and rejects everything else.
+func newFuzzGateway(f *testing.F) http.Handler {
+	f.Helper()
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/events") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprint(w, "event: plan.step\ndata: {}\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = fmt.Fprint(w, `{"code":"unauthorized","message":"session required"}`)
+	}))
+	f.Cleanup(orchestrator.Close)
+
+	// Audit and request logs would dominate each run.
+	original := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	gatewayAuditLogger = audit.Default()
+	f.Cleanup(func() {
+		slog.SetDefault(original)
+		gatewayAuditLogger = audit.Default()
+	})
+
+	f.Setenv("ORCHESTRATOR_URL", orchestrator.URL)
+	f.Setenv("OAUTH_REDIRECT_BASE", "https://app.example.com")
+	f.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	f.Setenv("OPENROUTER_CLIENT_ID", "fuzz-client")
+	// Rate limits would turn most inputs into 429s before they reach a
+	// handler.
+	for _, key := range []string{
+		"GATEWAY_AUTH_IP_RATE_LIMIT_MAX", "GATEWAY_AUTH_ID_RATE_LIMIT_MAX", "GATEWAY_AUTH_JWKS_RATE_LIMIT_MAX",
+		"GATEWAY_SSE_CONNECT_LIMIT", "GATEWAY_SSE_MAX_CONNECTIONS_PER_IP",
+		"GATEWAY_COLLAB_AUTH_FAILURE_LIMIT", "GATEWAY_COLLAB_MAX_CONNECTIONS_PER_IP",
+	} {
+		f.Setenv(key, "1000000")
+	}
+	ResetOrchestratorClient()
+	f.Cleanup(ResetOrchestratorClient)
+
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, AuthRouteConfig{AllowInsecureStateCookie: true})
+	RegisterEventRoutes(mux, EventRouteConfig{})
+	RegisterCollaborationRoutes(mux, CollaborationRouteConfig{})
+	limits, err := RouteBodyLimits("")
+	if err != nil {
+		f.Fatalf("route body limits: %v", err)
+	}
+	router := NewRouter(mux)
+	router.SetBodyLimits(DefaultMaxRequestBodyBytes(), limits)
+	handler := RequestCanonicalizationMiddleware(PublicBaseURLMiddleware(router, nil), DefaultMaxURLBytes())
+	return SecurityHeadersMiddleware(handler)
+}
+
+// fuzzRequest builds a request for route. Wildcards take values[0], values[1]
+// and so on; query parameters cycle through values starting after the
+// wildcards. An empty value leaves a parameter out.
+func fuzzRequest(route fuzzRoute, values []string, authorization string) *http.Request {
+	method, path, _ := strings.Cut(route.pattern, " ")
+	next := 0
+	take := func() string {
+		value := values[next%len(values)]
+		next++
+		return value
+	}
+	var raw strings.Builder
+	for _, segment := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		raw.WriteByte('/')
+		if strings.HasPrefix(segment, "{") {
+			raw.WriteString(url.PathEscape(take()))
+			continue
+		}
+		raw.WriteString(segment)
+	}
+	query := url.Values{}
+	for _, name := range route.query {
+		if value := take(); value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	req := httptest.NewRequest(method, "/", nil)
+	req.URL.RawPath = raw.String()
+	if unescaped, err := url.PathUnescape(req.URL.RawPath); err == nil {
+		req.URL.Path = unescaped
+	}
+	req.URL.RawQuery = query.Encode()
+	req.RequestURI = req.URL.RequestURI()
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return req
+}
+
+// checkErrorEnvelope fails unless an error response is the gateway's JSON
+// error envelope with a code and a message.
+func checkErrorEnvelope(t *testing.T, req *http.Request, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code < http.StatusBadRequest {
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if mediaType != "application/json" {
+		t.Fatalf("%s %s: %d response has content type %q, want application/json; body %q", req.Method, req.RequestURI, rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	var envelope httpErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("%s %s: %d response is not an error envelope: %v; body %q", req.Method, req.RequestURI, rec.Code, err, rec.Body.String())
+	}
+	if envelope.Code == "" || envelope.Message == "" {
+		t.Fatalf("%s %s: %d error envelope lacks code or message: %q", req.Method, req.RequestURI, rec.Code, rec.Body.String())
+	}
+}
+
+func TestFuzzRoutesAreRegistered(t *testing.T) {
+	mux := http.NewServeMux()
+	t.Setenv("ORCHESTRATOR_URL", "http://127.0.0.1:1")
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+	RegisterAuthRoutes(mux, AuthRouteConfig{})
+	RegisterEventRoutes(mux, EventRouteConfig{})
+	RegisterCollaborationRoutes(mux, CollaborationRouteConfig{})
+	for _, route := range fuzzRoutes {
+		req := fuzzRequest(route, []string{"openrouter"}, "")
+		if _, pattern := mux.Handler(req); pattern != route.pattern {
+			t.Errorf("fuzz route %q resolves to %q; update fuzzRoutes", route.pattern, pattern)
+		}
+	}
+}
+
+// FuzzRoutes sends requests derived from fuzzRoutes through the gateway and
+// checks that no handler panics and every error uses the error envelope. Run
+// it with:
+//
+//	go test -tags fuzzbeta -run '^$' -fuzz FuzzRoutes -fuzztime 1m ./internal/gateway
+func FuzzRoutes(f *testing.F) {
+	seeds := []string{
+		"", "openrouter", "unknown", routesPlanID, "plan-nope", "https://app.example.com/done",
+		"https://evil.example.com", "javascript:alert(1)", "%00", "\x00\xff", "-1", "99999999999999999999",
+		"login consent", strings.Repeat("a", 1024), "../../etc/passwd", "a,b;c=d", "ünïcödé", "{}",
+	}
+	for i := range fuzzRoutes {
+		for j, seed := range seeds {
+			f.Add(uint8(i), seed, seeds[(j+1)%len(seeds)], seeds[(j+5)%len(seeds)], "")
+		}
+		f.Add(uint8(i), "openrouter", "https://app.example.com/done", routesPlanID, "Bearer not-a-token")
+	}
+	handler := newFuzzGateway(f)
+
+	f.Fuzz(func(t *testing.T, routeIndex uint8, first, second, third, authorization string) {
+		route := fuzzRoutes[int(routeIndex)%len(fuzzRoutes)]
+		req := fuzzRequest(route, []string{first, second, third}, authorization)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		checkErrorEnvelope(t, req, rec)
+	})
+}
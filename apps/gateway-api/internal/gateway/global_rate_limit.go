@@ -1,15 +1,18 @@
 package gateway
 
 import (
+	"container/list"
 	"context"
-	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
 )
 
 const (
@@ -25,6 +28,75 @@ type rateLimitEvaluator interface {
 	Allow(context.Context, rateLimitBucket, string) (bool, time.Duration, error)
 }
 
+// rateLimitInspector is implemented by limiters that can report how much of a
+// bucket is left without consuming it.
+type rateLimitInspector interface {
+	Status(context.Context, rateLimitBucket, string) (rateLimitStatus, bool)
+}
+
+// rateLimitStatus is the state of one bucket for one identity.
+type rateLimitStatus struct {
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+}
+
+// tighterThan reports whether s leaves the client less headroom than other.
+func (s rateLimitStatus) tighterThan(other rateLimitStatus) bool {
+	if s.Remaining != other.Remaining {
+		return s.Remaining < other.Remaining
+	}
+	return s.Reset > other.Reset
+}
+
+// rateLimitHeaderTracker collects the most restrictive bucket seen while a
+// request passes through one or more limiters.
+type rateLimitHeaderTracker struct {
+	status rateLimitStatus
+	ok     bool
+}
+
+func (t *rateLimitHeaderTracker) observe(ctx context.Context, limiter rateLimitEvaluator, bucket rateLimitBucket, identity string) {
+	inspector, ok := limiter.(rateLimitInspector)
+	if !ok {
+		return
+	}
+	status, ok := inspector.Status(ctx, bucket, identity)
+	if !ok {
+		return
+	}
+	t.record(status)
+}
+
+func (t *rateLimitHeaderTracker) record(status rateLimitStatus) {
+	if !t.ok || status.tighterThan(t.status) {
+		t.status = status
+		t.ok = true
+	}
+}
+
+// write sets X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset
+// (seconds until the window resets). Headers already written by an outer
+// limiter are kept when they are more restrictive.
+func (t *rateLimitHeaderTracker) write(w http.ResponseWriter) {
+	if !t.ok {
+		return
+	}
+	header := w.Header()
+	if existing, err := strconv.Atoi(header.Get("X-RateLimit-Remaining")); err == nil && existing < t.status.Remaining {
+		return
+	}
+	header.Set("X-RateLimit-Limit", strconv.Itoa(t.status.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(t.status.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.Itoa(retryAfterToSeconds(t.status.Reset)))
+}
+
+// deny records an exhausted bucket and writes the headers.
+func (t *rateLimitHeaderTracker) deny(w http.ResponseWriter, bucket rateLimitBucket, retryAfter time.Duration) {
+	t.record(rateLimitStatus{Limit: bucket.Limit, Remaining: 0, Reset: retryAfter})
+	t.write(w)
+}
+
 type globalRateLimitPolicy struct {
 	buckets []rateLimitBucket
 }
@@ -41,16 +113,21 @@ type GlobalRateLimiter struct {
 func NewGlobalRateLimiter(trusted []*net.IPNet) *GlobalRateLimiter {
 	policy := newGlobalRateLimitPolicy()
 	return &GlobalRateLimiter{
-		limiter: newRateLimiter(),
+		limiter: newRateLimiter("http.global"),
 		buckets: policy.buckets,
 		trusted: trusted,
 	}
 }
 
+// Enabled reports whether any global rate limit bucket is configured.
+func (g *GlobalRateLimiter) Enabled() bool {
+	return g != nil && g.limiter != nil && len(g.buckets) > 0
+}
+
 // Middleware wraps the provided handler with global rate limiting. When limits
 // are exceeded the middleware returns a 429 response and emits an audit event.
 func (g *GlobalRateLimiter) Middleware(next http.Handler) http.Handler {
-	if g == nil || g.limiter == nil || len(g.buckets) == 0 {
+	if !g.Enabled() {
 		return next
 	}
 
@@ -60,6 +137,7 @@ func (g *GlobalRateLimiter) Middleware(next http.Handler) http.Handler {
 		}
 		ctx := r.Context()
 		var ipIdentity string
+		var headers rateLimitHeaderTracker
 
 		for _, bucket := range g.buckets {
 			var identity string
@@ -118,11 +196,14 @@ func (g *GlobalRateLimiter) Middleware(next http.Handler) http.Handler {
 					details["identity_hash"] = gatewayAuditLogger.HashIdentity("agent", identity)
 				}
 				auditHTTPRateLimitEvent(ctx, r, g.trusted, details)
+				headers.deny(w, bucket, retryAfter)
 				respondTooManyRequests(w, r, retryAfter)
 				return
 			}
+			headers.observe(ctx, g.limiter, bucket, identity)
 		}
 
+		headers.write(w)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -160,11 +241,32 @@ func auditHTTPRateLimitEvent(ctx context.Context, r *http.Request, trusted []*ne
 	gatewayAuditLogger.Security(ctx, event)
 }
 
+// rateLimiter counts requests per bucket and identity in fixed windows. The
+// windows are split across shards by key, each with its own lock, so
+// concurrent requests for different identities rarely contend. Within a shard
+// the windows are also kept in least recently used order so the map can be
+// capped and expired windows can be removed incrementally by the
+// rate_limit_cleanup maintenance job rather than on the request path.
 type rateLimiter struct {
-	mu          sync.Mutex
-	windows     map[string]rateLimitWindow
-	now         func() time.Time
-	lastCleanup time.Time
+	name       string
+	maxWindows int
+	shards     []rateLimiterShard
+	now        func() time.Time
+	// store, when set, persists windows so lockouts survive restarts. See
+	// newPersistentRateLimiter.
+	store storage.Store
+
+	expired             atomic.Int64
+	evictions           atomic.Int64
+	cleanups            atomic.Int64
+	lastCleanupDuration atomic.Int64
+}
+
+type rateLimiterShard struct {
+	mu         sync.Mutex
+	maxWindows int
+	windows    map[string]*list.Element
+	lru        *list.List // of *rateLimitEntry, most recently used first
 }
 
 type rateLimitWindow struct {
@@ -172,11 +274,34 @@ type rateLimitWindow struct {
 	count   int
 }
 
-func newRateLimiter() *rateLimiter {
-	return &rateLimiter{
-		windows: make(map[string]rateLimitWindow),
-		now:     time.Now,
+type rateLimitEntry struct {
+	key    string
+	window rateLimitWindow
+}
+
+// newRateLimiter returns a limiter reported as name in the admin API. It holds
+// at most GATEWAY_RATE_LIMIT_MAX_WINDOWS windows (0 removes the cap), split
+// evenly across its GATEWAY_LIMITER_SHARDS shards.
+func newRateLimiter(name string) *rateLimiter {
+	r := &rateLimiter{
+		name:       name,
+		maxWindows: GetIntEnv("GATEWAY_RATE_LIMIT_MAX_WINDOWS", defaultRateLimitMaxWindows),
+		shards:     make([]rateLimiterShard, limiterShardCount()),
+		now:        clockNow,
+	}
+	perShard := 0
+	if r.maxWindows > 0 {
+		perShard = (r.maxWindows + len(r.shards) - 1) / len(r.shards)
 	}
+	for i := range r.shards {
+		r.shards[i] = rateLimiterShard{maxWindows: perShard, windows: make(map[string]*list.Element), lru: list.New()}
+	}
+	registerRateLimiter(r)
+	return r
+}
+
+func (r *rateLimiter) shard(key string) *rateLimiterShard {
+	return &r.shards[limiterShard(key, len(r.shards))]
 }
 
 func (r *rateLimiter) Allow(ctx context.Context, bucket rateLimitBucket, identity string) (bool, time.Duration, error) {
@@ -187,45 +312,106 @@ func (r *rateLimiter) Allow(ctx context.Context, bucket rateLimitBucket, identit
 		return true, 0, nil
 	}
 
-	key := fmt.Sprintf("%s|%s|%s", bucket.Endpoint, bucket.IdentityType, identity)
+	key := bucket.key(identity)
 	now := r.now()
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	shard := r.shard(key)
+	var restored rateLimitWindow
+	if r.store != nil && !shard.has(key) {
+		var ok bool
+		if restored, ok = r.restore(ctx, key, now); !ok {
+			return false, bucket.Window, nil
+		}
+	}
 
-	state := r.windows[key]
+	shard.mu.Lock()
+	elem := shard.windows[key]
+	state := restored
+	if elem != nil {
+		state = elem.Value.(*rateLimitEntry).window
+	}
 	if state.expires.IsZero() || now.After(state.expires) {
 		state = rateLimitWindow{expires: now.Add(bucket.Window), count: 0}
 	}
 
-	if state.count >= bucket.Limit {
-		retryAfter := state.expires.Sub(now)
-		if retryAfter < 0 {
-			retryAfter = 0
-		}
-		r.windows[key] = state
-		return false, retryAfter, nil
+	allowed := state.count < bucket.Limit
+	if allowed {
+		state.count++
 	}
+	r.storeLocked(shard, key, elem, state)
+	shard.mu.Unlock()
 
-	state.count++
-	r.windows[key] = state
-	r.maybeCleanup(now)
+	if r.store != nil {
+		r.persist(ctx, key, state, now)
+	}
+	if !allowed {
+		return false, max(state.expires.Sub(now), 0), nil
+	}
 	return true, 0, nil
 }
 
-const rateLimiterCleanupInterval = time.Minute
+func (s *rateLimiterShard) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.windows[key]
+	return ok
+}
 
-func (r *rateLimiter) maybeCleanup(now time.Time) {
-	if r == nil {
+// storeLocked saves state as the shard's most recently used window. A new
+// window that would exceed the shard's cap evicts its least recently used
+// one, which forgets that identity's count: under a flood of new identities,
+// active clients keep their windows and idle ones are dropped first.
+func (r *rateLimiter) storeLocked(shard *rateLimiterShard, key string, elem *list.Element, state rateLimitWindow) {
+	if elem != nil {
+		elem.Value.(*rateLimitEntry).window = state
+		shard.lru.MoveToFront(elem)
 		return
 	}
-	if !r.lastCleanup.IsZero() && now.Sub(r.lastCleanup) < rateLimiterCleanupInterval {
-		return
-	}
-	for key, window := range r.windows {
-		if now.After(window.expires) {
-			delete(r.windows, key)
+	if shard.maxWindows > 0 && shard.lru.Len() >= shard.maxWindows {
+		if oldest := shard.lru.Back(); oldest != nil {
+			shard.lru.Remove(oldest)
+			delete(shard.windows, oldest.Value.(*rateLimitEntry).key)
+			r.evictions.Add(1)
 		}
 	}
-	r.lastCleanup = now
+	shard.windows[key] = shard.lru.PushFront(&rateLimitEntry{key: key, window: state})
+}
+
+// windowCount returns the number of windows across all shards.
+func (r *rateLimiter) windowCount() int {
+	count := 0
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.mu.Lock()
+		count += shard.lru.Len()
+		shard.mu.Unlock()
+	}
+	return count
+}
+
+// Status reports the bucket's limit, remaining requests and time until reset
+// without counting a request. ok is false when the bucket is disabled.
+func (r *rateLimiter) Status(_ context.Context, bucket rateLimitBucket, identity string) (rateLimitStatus, bool) {
+	if r == nil || bucket.Limit <= 0 || bucket.Window <= 0 {
+		return rateLimitStatus{}, false
+	}
+	key := bucket.key(identity)
+	now := r.now()
+
+	shard := r.shard(key)
+	shard.mu.Lock()
+	var state rateLimitWindow
+	if elem := shard.windows[key]; elem != nil {
+		state = elem.Value.(*rateLimitEntry).window
+	}
+	shard.mu.Unlock()
+
+	if state.expires.IsZero() || now.After(state.expires) {
+		return rateLimitStatus{Limit: bucket.Limit, Remaining: bucket.Limit, Reset: bucket.Window}, true
+	}
+	remaining := bucket.Limit - state.count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return rateLimitStatus{Limit: bucket.Limit, Remaining: remaining, Reset: state.expires.Sub(now)}, true
 }
@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const (
+	// defaultMaxHeaderBytes leaves room for the 64KiB cookie header the
+	// streaming routes forward plus the usual request headers.
+	defaultMaxHeaderBytes = 128 << 10
+	defaultMaxHeaderCount = 100
+	minMaxHeaderBytes     = 4 << 10
+
+	auditEventHTTPHeaderLimit = "gateway.http.header_limit"
+)
+
+// headerLimits bounds the header fields of one request.
+type headerLimits struct {
+	maxBytes int
+	maxCount int
+}
+
+func loadHeaderLimits() (headerLimits, error) {
+	limits := headerLimits{maxBytes: defaultMaxHeaderBytes, maxCount: defaultMaxHeaderCount}
+	if raw := strings.TrimSpace(GetEnv("GATEWAY_MAX_HEADER_BYTES", "")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < minMaxHeaderBytes {
+			return limits, fmt.Errorf("GATEWAY_MAX_HEADER_BYTES must be an integer of at least %d", minMaxHeaderBytes)
+		}
+		limits.maxBytes = parsed
+	}
+	if raw := strings.TrimSpace(GetEnv("GATEWAY_MAX_HEADER_COUNT", "")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			return limits, errors.New("GATEWAY_MAX_HEADER_COUNT must be a positive integer")
+		}
+		limits.maxCount = parsed
+	}
+	return limits, nil
+}
+
+// ValidateHeaderLimitConfig checks GATEWAY_MAX_HEADER_BYTES and
+// GATEWAY_MAX_HEADER_COUNT at startup.
+func ValidateHeaderLimitConfig() error {
+	_, err := loadHeaderLimits()
+	return err
+}
+
+// ServerMaxHeaderBytes returns the http.Server MaxHeaderBytes for the
+// gateway's listeners: twice GATEWAY_MAX_HEADER_BYTES, so requests over the
+// limit still reach HeaderLimitMiddleware and get a structured answer. Only
+// heads beyond that get net/http's plain 431.
+func ServerMaxHeaderBytes() int {
+	limits, _ := loadHeaderLimits()
+	return 2 * limits.maxBytes
+}
+
+// HeaderLimitMiddleware answers 431 with the error envelope when a request
+// has more header fields than GATEWAY_MAX_HEADER_COUNT or they take more
+// than GATEWAY_MAX_HEADER_BYTES, measured as written on the wire, and emits a
+// gateway.http.header_limit audit event.
+func HeaderLimitMiddleware(next http.Handler, trusted []*net.IPNet) http.Handler {
+	limits, _ := loadHeaderLimits()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count, size, largest := measureHeaders(r)
+		var reason, message string
+		var limit, observed int
+		switch {
+		case count > limits.maxCount:
+			reason, limit, observed = "count", limits.maxCount, count
+			message = fmt.Sprintf("request has more than %d header fields", limits.maxCount)
+		case size > limits.maxBytes:
+			reason, limit, observed = "bytes", limits.maxBytes, size
+			message = fmt.Sprintf("request headers exceed %d bytes", limits.maxBytes)
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if updated, _ := audit.EnsureRequestID(r, w); updated != nil {
+			r = updated
+		}
+		actor := hashedActorFromRequest(r, trusted)
+		gatewayAuditLogger.Security(audit.WithActor(r.Context(), actor), audit.Event{
+			Name:       auditEventHTTPHeaderLimit,
+			Outcome:    auditOutcomeDenied,
+			Target:     auditTargetHTTP,
+			Capability: auditCapabilityHTTP,
+			ActorID:    actor,
+			Details: auditDetails(map[string]any{
+				"reason":         reason,
+				"limit":          limit,
+				"observed":       observed,
+				"largest_header": largest,
+				"method":         r.Method,
+			}),
+		})
+		writeErrorResponse(w, r, http.StatusRequestHeaderFieldsTooLarge, "header_too_large", message, nil)
+	})
+}
+
+// measureHeaders counts the header fields of r, including Host, and their
+// size as "Name: value\r\n" lines. It also returns the name of the largest
+// field.
+func measureHeaders(r *http.Request) (count, size int, largest string) {
+	largestSize := 0
+	add := func(name, value string) {
+		fieldSize := len(name) + len(value) + 4
+		count++
+		size += fieldSize
+		if fieldSize > largestSize {
+			largest, largestSize = strings.ToLower(name), fieldSize
+		}
+	}
+	if r.Host != "" {
+		add("Host", r.Host)
+	}
+	for name, values := range r.Header {
+		for _, value := range values {
+			add(name, value)
+		}
+	}
+	return count, size, largest
+}
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHeaderLimitMiddlewareRejectsWithEnvelope(t *testing.T) {
+	t.Setenv("GATEWAY_MAX_HEADER_BYTES", "4096")
+	t.Setenv("GATEWAY_MAX_HEADER_COUNT", "10")
+	logs := captureAuditLogs(t)
+	handler := HeaderLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), nil)
+	serve := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(map[string]string{"Authorization": "Bearer token"}); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected a small request to pass, got %d", rec.Code)
+	}
+
+	rec := serve(map[string]string{"Cookie": strings.Repeat("c", 4096)})
+	var body httpErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusRequestHeaderFieldsTooLarge || body.Code != "header_too_large" {
+		t.Fatalf("expected a 431 envelope, got %d %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(logs.String(), `"event":"gateway.http.header_limit"`) || !strings.Contains(logs.String(), `"largest_header":"cookie"`) {
+		t.Fatalf("expected a header limit audit event naming the cookie header, got %s", logs.String())
+	}
+
+	many := make(map[string]string)
+	for i := range 10 {
+		many[fmt.Sprintf("X-Extra-%d", i)] = "1"
+	}
+	// Host counts as a field too, making eleven.
+	if rec := serve(many); rec.Code != http.StatusRequestHeaderFieldsTooLarge || !strings.Contains(rec.Body.String(), "more than 10 header fields") {
+		t.Fatalf("expected too many headers to be rejected, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHeaderLimitConfig(t *testing.T) {
+	if got := ServerMaxHeaderBytes(); got != 2*defaultMaxHeaderBytes {
+		t.Fatalf("expected the server limit to be twice the default, got %d", got)
+	}
+	for key, value := range map[string]string{
+		"GATEWAY_MAX_HEADER_BYTES": "1024",
+		"GATEWAY_MAX_HEADER_COUNT": "0",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if err := ValidateHeaderLimitConfig(); err == nil {
+				t.Fatalf("expected %s=%s to be rejected", key, value)
+			}
+		})
+	}
+}
+
+// A head just over the limit must reach the middleware rather than the
+// server's own plain-text 431.
+func TestHeaderLimitHeadroomReachesMiddleware(t *testing.T) {
+	t.Setenv("GATEWAY_MAX_HEADER_BYTES", "8192")
+	server := httptest.NewUnstartedServer(HeaderLimitMiddleware(http.NotFoundHandler(), nil))
+	server.Config.MaxHeaderBytes = ServerMaxHeaderBytes()
+	server.Start()
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("X-Large", strings.Repeat("x", 12<<10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		t.Fatalf("expected a JSON 431, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
@@ -3,9 +3,8 @@ package gateway
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,6 +13,8 @@ type dependencyResult struct {
 	LatencyMs float64  `json:"latency_ms,omitempty"`
 	Error     *string  `json:"error,omitempty"`
 	Details   []string `json:"details,omitempty"`
+	// Optional dependencies are reported without affecting readiness.
+	Optional bool `json:"optional,omitempty"`
 }
 
 type healthResponse struct {
@@ -23,58 +24,122 @@ type healthResponse struct {
 	Details       map[string]dependencyResult `json:"details"`
 }
 
+const defaultHealthTimeout = 3 * time.Second
+
+// Readiness states reported by /readyz. Only ok and degraded answer 200.
 const (
-	defaultHealthTimeout  = 3 * time.Second
-	orchestratorReadyPath = "/readyz"
-	indexerHealthPath     = "/healthz"
+	readinessOK          = "ok"
+	readinessDegraded    = "degraded"
+	readinessUnavailable = "unavailable"
+	readinessDraining    = "draining"
 )
 
 var (
-	indexerClient      = &http.Client{Timeout: 5 * time.Second}
+	indexerClient      = &http.Client{Timeout: 5 * time.Second, Transport: observeUpstreamCalls(egressIndexer, newUpstreamTransport(egressIndexer))}
 	healthDependencies = []string{"gateway-api"}
 )
 
 // RegisterHealthRoutes registers readiness and liveness endpoints for the gateway.
 func RegisterHealthRoutes(mux *http.ServeMux, startedAt time.Time) {
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		resp := buildHealthResponse(r.Context(), startedAt, false)
-		writeHealthResponse(w, http.StatusOK, resp)
-	})
+	registerLivenessRoute(mux, startedAt)
 
-	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+	handleFunc(mux, "GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		fields, ok := healthFieldsFromRequest(w, r)
+		if !ok {
+			return
+		}
 		resp := buildHealthResponse(r.Context(), startedAt, true)
 		status := http.StatusOK
-		if resp.Status != "ok" {
+		if resp.Status != readinessOK && resp.Status != readinessDegraded {
 			status = http.StatusServiceUnavailable
 		}
-		writeHealthResponse(w, status, resp)
+		writeHealthResponse(w, status, fields.body(resp))
+	})
+
+	handleFunc(mux, "GET /healthz/providers", func(w http.ResponseWriter, r *http.Request) {
+		writeProviderHealth(w, cachedProviderHealth())
+	})
+}
+
+// registerLivenessRoute registers GET /healthz, which answers without
+// checking dependencies. The admin listener serves it too, so the gateway can
+// be probed while it waits for its dependencies before binding the public
+// listener. ?probe= selects a minimal liveness, readiness or startup answer
+// for Kubernetes probes.
+func registerLivenessRoute(mux *http.ServeMux, startedAt time.Time) {
+	handleFunc(mux, "GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		if probe := r.URL.Query().Get("probe"); probe != "" {
+			writeHealthProbe(w, r, probe, startedAt)
+			return
+		}
+		fields, ok := healthFieldsFromRequest(w, r)
+		if !ok {
+			return
+		}
+		resp := buildHealthResponse(r.Context(), startedAt, false)
+		writeHealthResponse(w, http.StatusOK, fields.body(resp))
 	})
 }
 
 func buildHealthResponse(ctx context.Context, startedAt time.Time, includeDependencies bool) healthResponse {
 	details := make(map[string]dependencyResult)
 	for _, name := range healthDependencies {
-		details[name] = dependencyResult{Status: "pass"}
+		details[name] = dependencyResult{Status: healthStatusPass}
+	}
+	if startup, ok := dependencyWaitState.result(); ok {
+		details["startup"] = startup
 	}
 
-	status := "ok"
-	if includeDependencies {
-		depCtx, cancel := context.WithTimeout(ctx, defaultHealthTimeout)
-		defer cancel()
-
-		orchestratorResult := checkOrchestrator(depCtx)
-		details["orchestrator"] = orchestratorResult
-		if orchestratorResult.Status != "pass" {
-			status = "degraded"
+	status := readinessOK
+	if includeDependencies && gatewayDrain.draining() {
+		// A draining gateway is taken out of rotation whatever the state of
+		// its dependencies, so they are not checked.
+		details["drain"] = dependencyResult{Status: readinessDraining}
+		status = readinessDraining
+	} else if includeDependencies {
+		checks, err := loadHealthChecks()
+		if err != nil {
+			details["health_checks"] = failureResult(time.Now(), err.Error())
+			status = readinessUnavailable
 		}
-
-		indexerResult := checkIndexer(depCtx)
-		details["indexer"] = indexerResult
-		if indexerResult.Status != "pass" {
-			status = "degraded"
+		results := make([]dependencyResult, len(checks))
+		var wg sync.WaitGroup
+		for i, check := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = check.run(ctx)
+			}()
+		}
+		wg.Wait()
+		for i, check := range checks {
+			details[check.name] = results[i]
+			switch check.impact(results[i]) {
+			case healthStatusFail:
+				status = readinessUnavailable
+			case healthStatusDegraded:
+				if status == readinessOK {
+					status = readinessDegraded
+				}
+			}
+		}
+		if result, ok := clockDriftHealth(); ok {
+			details["clock"] = result
+			if result.Status == healthStatusDegraded && status == readinessOK {
+				status = readinessDegraded
+			}
+		}
+		for name, result := range checkRedis(ctx) {
+			details[name] = result
+			if result.Status == healthStatusDegraded && status == readinessOK {
+				status = readinessDegraded
+			}
 		}
 	}
 
+	if includeDependencies {
+		lastReadiness.record(status)
+	}
 	return healthResponse{
 		Status:        status,
 		UptimeSeconds: time.Since(startedAt).Seconds(),
@@ -83,70 +148,35 @@ func buildHealthResponse(ctx context.Context, startedAt time.Time, includeDepend
 	}
 }
 
-func writeHealthResponse(w http.ResponseWriter, status int, resp healthResponse) {
+func writeHealthResponse(w http.ResponseWriter, status int, resp any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	encoder := json.NewEncoder(w)
 	_ = encoder.Encode(resp)
 }
 
+// checkOrchestrator runs the built-in orchestrator readiness check without
+// GATEWAY_HEALTH_CHECKS adjustments.
 func checkOrchestrator(ctx context.Context) dependencyResult {
-	start := time.Now()
-	client, err := getOrchestratorClient()
-	if err != nil {
-		return failureResult(start, fmt.Sprintf("orchestrator client unavailable: %v", err))
-	}
-
-	baseURL := strings.TrimRight(GetEnv("ORCHESTRATOR_URL", "http://127.0.0.1:4000"), "/")
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+orchestratorReadyPath, nil)
-	if err != nil {
-		return failureResult(start, fmt.Sprintf("failed to create orchestrator request: %v", err))
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return failureResult(start, fmt.Sprintf("orchestrator request failed: %v", err))
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return failureResult(start, fmt.Sprintf("orchestrator readiness returned %d", resp.StatusCode))
-	}
-
-	return successResult(start)
+	return builtinHealthChecks()[healthCheckOrchestrator].run(ctx)
 }
 
+// checkIndexer runs the built-in indexer health check without
+// GATEWAY_HEALTH_CHECKS adjustments.
 func checkIndexer(ctx context.Context) dependencyResult {
-	start := time.Now()
-	baseURL := strings.TrimRight(GetEnv("INDEXER_URL", "http://127.0.0.1:7071"), "/")
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+indexerHealthPath, nil)
-	if err != nil {
-		return failureResult(start, fmt.Sprintf("failed to create indexer request: %v", err))
-	}
-
-	resp, err := indexerClient.Do(req)
-	if err != nil {
-		return failureResult(start, fmt.Sprintf("indexer request failed: %v", err))
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return failureResult(start, fmt.Sprintf("indexer health returned %d", resp.StatusCode))
-	}
-
-	return successResult(start)
+	return builtinHealthChecks()[healthCheckIndexer].run(ctx)
 }
 
 func successResult(start time.Time) dependencyResult {
 	return dependencyResult{
-		Status:    "pass",
+		Status:    healthStatusPass,
 		LatencyMs: float64(time.Since(start).Milliseconds()),
 	}
 }
 
 func failureResult(start time.Time, message string) dependencyResult {
 	return dependencyResult{
-		Status:    "fail",
+		Status:    healthStatusFail,
 		LatencyMs: float64(time.Since(start).Milliseconds()),
 		Error:     ptr(message),
 	}
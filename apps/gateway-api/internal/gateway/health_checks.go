@@ -0,0 +1,315 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	healthCheckOrchestrator = "orchestrator"
+	healthCheckIndexer      = "indexer"
+
+	// on_failure policies: an unready dependency makes /readyz answer 503, a
+	// degrade dependency only marks the gateway degraded and keeps it in
+	// rotation.
+	healthPolicyUnready = "unready"
+	healthPolicyDegrade = "degrade"
+
+	healthStatusPass     = "pass"
+	healthStatusFail     = "fail"
+	healthStatusDegraded = "degraded"
+
+	// maxHealthCheckBody bounds how much of a response is searched for
+	// expect_body.
+	maxHealthCheckBody = 64 << 10
+)
+
+var (
+	healthCheckNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+	healthCheckMethods     = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+	healthCheckClient = &http.Client{Transport: observeUpstreamCalls(egressHealthCheck, newUpstreamTransport(egressHealthCheck))}
+	healthCheckDial   = egressDialContext(egressHealthCheck, &net.Dialer{KeepAlive: -1})
+)
+
+// healthCheckSettings is one entry of GATEWAY_HEALTH_CHECKS. Entries named
+// orchestrator or indexer adjust the built-in checks, which keep using
+// ORCHESTRATOR_URL and INDEXER_URL; any other entry adds a dependency and
+// needs a url. tcp://host:port URLs only check that a connection opens.
+type healthCheckSettings struct {
+	URL          string `json:"url,omitempty"`
+	Path         string `json:"path,omitempty"`
+	Method       string `json:"method,omitempty"`
+	ExpectStatus []int  `json:"expect_status,omitempty"`
+	ExpectBody   string `json:"expect_body,omitempty"`
+	Timeout      string `json:"timeout,omitempty"`
+	OnFailure    string `json:"on_failure,omitempty"`
+	SlowAfter    string `json:"slow_after,omitempty"`
+	Optional     bool   `json:"optional,omitempty"`
+	Disabled     bool   `json:"disabled,omitempty"`
+}
+
+// healthCheck is a resolved dependency check run by /readyz.
+type healthCheck struct {
+	name         string
+	target       *url.URL
+	path         string
+	method       string
+	expectStatus []int
+	expectBody   string
+	timeout      time.Duration
+	// degradeOnFailure selects the degrade on_failure policy.
+	degradeOnFailure bool
+	// slowAfter, when set, reports a passing check that took longer as
+	// degraded, so a browned-out dependency is visible before it fails.
+	slowAfter time.Duration
+	optional  bool
+}
+
+func builtinHealthChecks() map[string]healthCheck {
+	return map[string]healthCheck{
+		healthCheckOrchestrator: {name: healthCheckOrchestrator, path: "/readyz", method: http.MethodGet, timeout: defaultHealthTimeout},
+		healthCheckIndexer:      {name: healthCheckIndexer, path: "/healthz", method: http.MethodGet, timeout: defaultHealthTimeout},
+	}
+}
+
+func parseHealthChecks(raw string) ([]healthCheck, error) {
+	checks := builtinHealthChecks()
+	if raw != "" {
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		var entries map[string]healthCheckSettings
+		if err := decoder.Decode(&entries); err != nil {
+			return nil, fmt.Errorf("invalid GATEWAY_HEALTH_CHECKS: %w", err)
+		}
+		for name, settings := range entries {
+			if !healthCheckNamePattern.MatchString(name) || slices.Contains(healthDependencies, name) {
+				return nil, fmt.Errorf("health check %q: invalid name", name)
+			}
+			if settings.Disabled {
+				delete(checks, name)
+				continue
+			}
+			check, err := settings.resolve(name, checks[name])
+			if err != nil {
+				return nil, fmt.Errorf("health check %q: %w", name, err)
+			}
+			checks[name] = check
+		}
+	}
+	resolved := make([]healthCheck, 0, len(checks))
+	for _, name := range slices.Sorted(maps.Keys(checks)) {
+		resolved = append(resolved, checks[name])
+	}
+	return resolved, nil
+}
+
+// resolve applies s over base, the built-in check of the same name if any.
+func (s healthCheckSettings) resolve(name string, base healthCheck) (healthCheck, error) {
+	check := base
+	check.name = name
+	builtin := base.name != ""
+	switch {
+	case builtin && s.URL != "":
+		return healthCheck{}, fmt.Errorf("url cannot be set for a built-in check")
+	case !builtin && s.URL == "":
+		return healthCheck{}, fmt.Errorf("url is required")
+	case !builtin:
+		target, err := url.Parse(s.URL)
+		if err != nil || target.Host == "" || !slices.Contains([]string{"http", "https", "tcp"}, target.Scheme) {
+			return healthCheck{}, fmt.Errorf("url must be an absolute http(s) or tcp URL")
+		}
+		check.target = target
+		check.method = http.MethodGet
+		check.timeout = defaultHealthTimeout
+	}
+	if s.Path != "" {
+		if !builtin || !strings.HasPrefix(s.Path, "/") {
+			return healthCheck{}, fmt.Errorf("path must start with / and is only set for built-in checks")
+		}
+		check.path = s.Path
+	}
+	if s.Method != "" {
+		method := strings.ToUpper(s.Method)
+		if !slices.Contains(healthCheckMethods, method) {
+			return healthCheck{}, fmt.Errorf("method must be one of %s", strings.Join(healthCheckMethods, ", "))
+		}
+		check.method = method
+	}
+	for _, status := range s.ExpectStatus {
+		if status < 100 || status > 599 {
+			return healthCheck{}, fmt.Errorf("invalid expect_status %d", status)
+		}
+	}
+	if len(s.ExpectStatus) > 0 {
+		check.expectStatus = s.ExpectStatus
+	}
+	if s.ExpectBody != "" {
+		check.expectBody = s.ExpectBody
+	}
+	if s.Timeout != "" {
+		timeout, err := time.ParseDuration(s.Timeout)
+		if err != nil || timeout <= 0 {
+			return healthCheck{}, fmt.Errorf("invalid timeout %q", s.Timeout)
+		}
+		check.timeout = timeout
+	}
+	switch s.OnFailure {
+	case "":
+	case healthPolicyUnready, healthPolicyDegrade:
+		check.degradeOnFailure = s.OnFailure == healthPolicyDegrade
+	default:
+		return healthCheck{}, fmt.Errorf("on_failure must be %s or %s", healthPolicyUnready, healthPolicyDegrade)
+	}
+	if s.SlowAfter != "" {
+		slowAfter, err := time.ParseDuration(s.SlowAfter)
+		if err != nil || slowAfter <= 0 || slowAfter >= check.timeout {
+			return healthCheck{}, fmt.Errorf("slow_after must be a positive duration below the timeout")
+		}
+		check.slowAfter = slowAfter
+	}
+	check.optional = s.Optional
+	if check.target != nil && check.target.Scheme == "tcp" && (s.Method != "" || len(s.ExpectStatus) > 0 || s.ExpectBody != "") {
+		return healthCheck{}, fmt.Errorf("tcp checks only support timeout, on_failure, slow_after and optional")
+	}
+	if check.method == http.MethodHead && check.expectBody != "" {
+		return healthCheck{}, fmt.Errorf("expect_body cannot be used with HEAD")
+	}
+	return check, nil
+}
+
+// run performs the check. Failures of optional checks are reported but do
+// not affect readiness; see impact.
+func (c healthCheck) run(ctx context.Context) dependencyResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := time.Now()
+	result := c.probe(ctx, start)
+	if result.Status == healthStatusPass && c.slowAfter > 0 && time.Since(start) > c.slowAfter {
+		result.Status = healthStatusDegraded
+		result.Details = append(result.Details, fmt.Sprintf("responded slower than %s", c.slowAfter))
+	}
+	result.Optional = c.optional
+	return result
+}
+
+// impact is the effect of result on the gateway's readiness: "" when it has
+// none, healthStatusDegraded when the gateway stays ready but degraded, and
+// healthStatusFail when the gateway is unready.
+func (c healthCheck) impact(result dependencyResult) string {
+	switch {
+	case c.optional || result.Status == healthStatusPass:
+		return ""
+	case result.Status == healthStatusFail && !c.degradeOnFailure:
+		return healthStatusFail
+	default:
+		return healthStatusDegraded
+	}
+}
+
+func (c healthCheck) probe(ctx context.Context, start time.Time) dependencyResult {
+	client := healthCheckClient
+	var target string
+	switch {
+	case c.name == healthCheckOrchestrator && c.target == nil:
+		orchestratorClient, baseURL, err := currentOrchestrator()
+		if err != nil {
+			return failureResult(start, fmt.Sprintf("orchestrator client unavailable: %v", err))
+		}
+		client, target = orchestratorClient, baseURL+c.path
+	case c.name == healthCheckIndexer && c.target == nil:
+		client = indexerClient
+		target = strings.TrimRight(GetEnv("INDEXER_URL", "http://127.0.0.1:7071"), "/") + c.path
+	case c.target.Scheme == "tcp":
+		conn, err := healthCheckDial(ctx, "tcp", c.target.Host)
+		if err != nil {
+			return failureResult(start, fmt.Sprintf("%s connection failed: %v", c.name, err))
+		}
+		_ = conn.Close()
+		return successResult(start)
+	default:
+		target = c.target.String()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, c.method, target, nil)
+	if err != nil {
+		return failureResult(start, fmt.Sprintf("failed to create %s request: %v", c.name, err))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return failureResult(start, fmt.Sprintf("%s request failed: %v", c.name, err))
+	}
+	defer resp.Body.Close()
+
+	if !c.statusExpected(resp.StatusCode) {
+		return failureResult(start, fmt.Sprintf("%s health check returned %d", c.name, resp.StatusCode))
+	}
+	if c.expectBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBody))
+		if err != nil {
+			return failureResult(start, fmt.Sprintf("%s response could not be read: %v", c.name, err))
+		}
+		if !bytes.Contains(body, []byte(c.expectBody)) {
+			return failureResult(start, fmt.Sprintf("%s response did not contain the expected body", c.name))
+		}
+	}
+	return successResult(start)
+}
+
+func (c healthCheck) statusExpected(status int) bool {
+	if len(c.expectStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	return slices.Contains(c.expectStatus, status)
+}
+
+var (
+	healthChecksMu   sync.Mutex
+	healthChecksOnce sync.Once
+	sharedChecks     []healthCheck
+	sharedChecksErr  error
+)
+
+// resetHealthChecks clears the cached health check configuration for tests.
+func resetHealthChecks() {
+	healthChecksMu.Lock()
+	defer healthChecksMu.Unlock()
+	healthChecksOnce = sync.Once{}
+	sharedChecks = nil
+	sharedChecksErr = nil
+}
+
+// loadHealthChecks returns the dependency checks /readyz runs: the built-in
+// orchestrator and indexer checks as adjusted by GATEWAY_HEALTH_CHECKS, plus
+// any dependencies it adds.
+func loadHealthChecks() ([]healthCheck, error) {
+	healthChecksMu.Lock()
+	defer healthChecksMu.Unlock()
+	healthChecksOnce.Do(func() {
+		raw, err := ResolveEnvValue("GATEWAY_HEALTH_CHECKS")
+		if err != nil {
+			sharedChecksErr = err
+			return
+		}
+		sharedChecks, sharedChecksErr = parseHealthChecks(raw)
+	})
+	return sharedChecks, sharedChecksErr
+}
+
+// ValidateHealthCheckConfig checks GATEWAY_HEALTH_CHECKS at startup.
+func ValidateHealthCheckConfig() error {
+	_, err := loadHealthChecks()
+	return err
+}
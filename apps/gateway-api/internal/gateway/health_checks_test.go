@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseHealthChecks(t *testing.T) {
+	checks, err := parseHealthChecks(`{
+		"orchestrator": {"path": "/health/ready", "expect_status": [200, 204], "timeout": "500ms"},
+		"indexer": {"disabled": true},
+		"vault": {"url": "https://vault:8200/v1/sys/health", "expect_body": "\"sealed\":false", "optional": true},
+		"redis": {"url": "tcp://redis:6379"}
+	}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(checks) != 3 || checks[0].name != healthCheckOrchestrator || checks[1].name != "redis" || checks[2].name != "vault" {
+		t.Fatalf("unexpected checks %+v", checks)
+	}
+	if orchestrator := checks[0]; orchestrator.path != "/health/ready" || orchestrator.method != http.MethodGet || orchestrator.timeout != 500*time.Millisecond || !orchestrator.statusExpected(204) || orchestrator.statusExpected(202) {
+		t.Fatalf("unexpected orchestrator check %+v", orchestrator)
+	}
+	if vault := checks[2]; !vault.optional || vault.timeout != defaultHealthTimeout || !vault.statusExpected(200) {
+		t.Fatalf("unexpected vault check %+v", vault)
+	}
+
+	for _, raw := range []string{
+		`{"orchestrator": {"url": "http://other"}}`,
+		`{"vault": {}}`,
+		`{"vault": {"url": "ftp://vault"}}`,
+		`{"vault": {"url": "http://vault", "path": "/health"}}`,
+		`{"orchestrator": {"path": "health"}}`,
+		`{"orchestrator": {"method": "DELETE"}}`,
+		`{"orchestrator": {"method": "HEAD", "expect_body": "ok"}}`,
+		`{"orchestrator": {"expect_status": [42]}}`,
+		`{"orchestrator": {"timeout": "soon"}}`,
+		`{"redis": {"url": "tcp://redis:6379", "expect_body": "PONG"}}`,
+		`{"gateway-api": {"url": "http://gateway"}}`,
+		`{"Vault": {"url": "http://vault"}}`,
+		`{"vault": {"url": "http://vault", "retries": 3}}`,
+		`{"vault": {"url": "http://vault", "on_failure": "ignore"}}`,
+		`{"vault": {"url": "http://vault", "timeout": "1s", "slow_after": "2s"}}`,
+	} {
+		if _, err := parseHealthChecks(raw); err == nil {
+			t.Fatalf("expected %s to be rejected", raw)
+		}
+	}
+}
+
+func TestReadyzRunsConfiguredHealthChecks(t *testing.T) {
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health/ready" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"ready"}`))
+	}))
+	defer orchestrator.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	t.Setenv("ORCHESTRATOR_URL", orchestrator.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+	serve := func(checks string) (int, healthResponse) {
+		t.Helper()
+		t.Setenv("GATEWAY_HEALTH_CHECKS", checks)
+		resetHealthChecks()
+		t.Cleanup(resetHealthChecks)
+		mux := http.NewServeMux()
+		RegisterHealthRoutes(mux, time.Now())
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp healthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		return rec.Code, resp
+	}
+
+	code, resp := serve(`{
+		"orchestrator": {"path": "/health/ready", "expect_body": "ready"},
+		"indexer": {"disabled": true},
+		"redis": {"url": "tcp://` + listener.Addr().String() + `"},
+		"cache": {"url": "tcp://` + closedAddr + `", "optional": true, "timeout": "200ms"}
+	}`)
+	listener.Close()
+	if code != http.StatusOK || resp.Status != readinessOK {
+		t.Fatalf("expected ready, got %d %+v", code, resp)
+	}
+	if _, ok := resp.Details[healthCheckIndexer]; ok {
+		t.Fatal("expected the disabled indexer check to be skipped")
+	}
+	if resp.Details[healthCheckOrchestrator].Status != "pass" || resp.Details["redis"].Status != "pass" {
+		t.Fatalf("expected the required checks to pass, got %+v", resp.Details)
+	}
+	if cache := resp.Details["cache"]; cache.Status != "fail" || !cache.Optional {
+		t.Fatalf("expected the optional check to fail without affecting readiness, got %+v", cache)
+	}
+
+	code, resp = serve(`{"orchestrator": {"path": "/health/ready", "expect_body": "live"}, "indexer": {"disabled": true}}`)
+	if code != http.StatusServiceUnavailable || resp.Status != readinessUnavailable || resp.Details[healthCheckOrchestrator].Error == nil {
+		t.Fatalf("expected an unexpected body to fail readiness, got %d %+v", code, resp)
+	}
+
+	code, resp = serve(`{
+		"orchestrator": {"path": "/health/ready", "expect_body": "live", "on_failure": "degrade"},
+		"indexer": {"disabled": true}
+	}`)
+	if code != http.StatusOK || resp.Status != readinessDegraded || resp.Details[healthCheckOrchestrator].Status != healthStatusFail {
+		t.Fatalf("expected a degrade dependency to keep the gateway ready, got %d %+v", code, resp)
+	}
+}
+
+func TestHealthCheckSlowAfterDegrades(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer upstream.Close()
+	checks, err := parseHealthChecks(`{"slow": {"url": "` + upstream.URL + `", "slow_after": "1ms"}, "orchestrator": {"disabled": true}, "indexer": {"disabled": true}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result := checks[0].run(context.Background())
+	if result.Status != healthStatusDegraded || checks[0].impact(result) != healthStatusDegraded {
+		t.Fatalf("expected a slow response to degrade, got %+v", result)
+	}
+}
@@ -0,0 +1,182 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Probe selectors accepted by GET /healthz?probe=.
+const (
+	healthProbeLiveness  = "liveness"
+	healthProbeReadiness = "readiness"
+	healthProbeStartup   = "startup"
+)
+
+// readinessProbeMaxAge is how old the readiness answered to
+// ?probe=readiness may get before it is re-evaluated in the background.
+const readinessProbeMaxAge = 5 * time.Second
+
+var healthResponseFields = []string{"status", "uptime_seconds", "timestamp", "details"}
+
+// probeResponse is the whole body of a ?probe= answer.
+type probeResponse struct {
+	Status string `json:"status"`
+}
+
+// readinessSnapshot remembers the last readiness evaluation so readiness
+// probes answer without waiting for dependency checks. A stale snapshot is
+// still served while one background evaluation replaces it.
+type readinessSnapshot struct {
+	mu         sync.Mutex
+	status     string
+	at         time.Time
+	refreshing bool
+}
+
+var lastReadiness readinessSnapshot
+
+func (s *readinessSnapshot) record(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+	s.at = time.Now()
+}
+
+// reset forgets the snapshot for tests.
+func (s *readinessSnapshot) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = ""
+	s.at = time.Time{}
+	s.refreshing = false
+}
+
+// current returns the last readiness, evaluating it first when there is none
+// yet and refreshing it in the background once it is older than
+// readinessProbeMaxAge.
+func (s *readinessSnapshot) current(ctx context.Context, startedAt time.Time) string {
+	s.mu.Lock()
+	status, at := s.status, s.at
+	if status != "" && (s.refreshing || time.Since(at) < readinessProbeMaxAge) {
+		s.mu.Unlock()
+		return status
+	}
+	s.refreshing = true
+	s.mu.Unlock()
+
+	evaluate := func(ctx context.Context) string {
+		defer func() {
+			s.mu.Lock()
+			s.refreshing = false
+			s.mu.Unlock()
+		}()
+		return buildHealthResponse(ctx, startedAt, true).Status
+	}
+	if status == "" {
+		return evaluate(ctx)
+	}
+	go evaluate(context.WithoutCancel(ctx))
+	return status
+}
+
+// writeHealthProbe answers ?probe= with a constant body: liveness always
+// passes, readiness follows /readyz from the last snapshot (draining applies
+// at once) and startup fails while the gateway waits for its dependencies.
+func writeHealthProbe(w http.ResponseWriter, r *http.Request, probe string, startedAt time.Time) {
+	status := readinessOK
+	switch probe {
+	case healthProbeLiveness:
+	case healthProbeReadiness:
+		if gatewayDrain.draining() {
+			status = readinessDraining
+		} else {
+			status = lastReadiness.current(r.Context(), startedAt)
+		}
+	case healthProbeStartup:
+		if startup, ok := dependencyWaitState.result(); ok && startup.Status != healthStatusPass {
+			status = startup.Status
+		}
+	default:
+		writeValidationError(w, r, []validationError{{Field: "probe", Message: "probe must be liveness, readiness or startup"}})
+		return
+	}
+	code := http.StatusOK
+	if status != readinessOK && status != readinessDegraded {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeHealthResponse(w, code, probeResponse{Status: status})
+}
+
+// healthFieldFilter selects the parts of a health response named by
+// ?fields=: top-level fields, and details.<dependency> for single
+// dependencies.
+type healthFieldFilter struct {
+	fields       map[string]bool
+	dependencies []string
+}
+
+func parseHealthFields(raw string) (*healthFieldFilter, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	filter := &healthFieldFilter{fields: make(map[string]bool)}
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if name, ok := strings.CutPrefix(field, "details."); ok && name != "" {
+			filter.dependencies = append(filter.dependencies, name)
+			continue
+		}
+		if !slices.Contains(healthResponseFields, field) {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		filter.fields[field] = true
+	}
+	return filter, nil
+}
+
+// healthFieldsFromRequest parses ?fields=, writing a 400 when it is invalid.
+// A nil filter keeps the whole response.
+func healthFieldsFromRequest(w http.ResponseWriter, r *http.Request) (*healthFieldFilter, bool) {
+	filter, err := parseHealthFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeValidationError(w, r, []validationError{{Field: "fields", Message: err.Error()}})
+		return nil, false
+	}
+	return filter, true
+}
+
+// body returns resp reduced to the selected fields.
+func (f *healthFieldFilter) body(resp healthResponse) any {
+	if f == nil {
+		return resp
+	}
+	body := make(map[string]any, len(f.fields)+1)
+	if f.fields["status"] {
+		body["status"] = resp.Status
+	}
+	if f.fields["uptime_seconds"] {
+		body["uptime_seconds"] = resp.UptimeSeconds
+	}
+	if f.fields["timestamp"] {
+		body["timestamp"] = resp.Timestamp
+	}
+	switch {
+	case f.fields["details"]:
+		body["details"] = resp.Details
+	case len(f.dependencies) > 0:
+		details := make(map[string]dependencyResult, len(f.dependencies))
+		for _, name := range f.dependencies {
+			if result, ok := resp.Details[name]; ok {
+				details[name] = result
+			}
+		}
+		body["details"] = details
+	}
+	return body
+}
@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthProbesAnswerMinimalBodies(t *testing.T) {
+	var checks atomic.Int32
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer orchestrator.Close()
+	t.Setenv("ORCHESTRATOR_URL", orchestrator.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+	t.Setenv("GATEWAY_HEALTH_CHECKS", `{"indexer": {"disabled": true}}`)
+	resetHealthChecks()
+	t.Cleanup(resetHealthChecks)
+	lastReadiness.reset()
+	t.Cleanup(lastReadiness.reset)
+	dependencyWaitState.reset()
+	t.Cleanup(dependencyWaitState.reset)
+	t.Cleanup(gatewayDrain.stop)
+
+	mux := http.NewServeMux()
+	RegisterHealthRoutes(mux, time.Now())
+	probe := func(name string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz?probe="+name, nil))
+		return rec
+	}
+
+	if rec := probe(healthProbeLiveness); rec.Code != http.StatusOK || rec.Body.String() != "{\"status\":\"ok\"}\n" {
+		t.Fatalf("expected a minimal liveness body, got %d %q", rec.Code, rec.Body.String())
+	}
+	if checks.Load() != 0 {
+		t.Fatal("expected liveness not to check dependencies")
+	}
+
+	for range 3 {
+		if rec := probe(healthProbeReadiness); rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "{\"status\":\"unavailable\"}\n" {
+			t.Fatalf("expected readiness to follow the failing orchestrator, got %d %q", rec.Code, rec.Body.String())
+		}
+	}
+	if checks.Load() != 1 {
+		t.Fatalf("expected repeated probes to answer from the last evaluation, got %d checks", checks.Load())
+	}
+	gatewayDrain.start(time.Now())
+	if rec := probe(healthProbeReadiness); rec.Body.String() != "{\"status\":\"draining\"}\n" {
+		t.Fatalf("expected draining to apply at once, got %q", rec.Body.String())
+	}
+	gatewayDrain.stop()
+
+	if rec := probe(healthProbeStartup); rec.Code != http.StatusOK {
+		t.Fatalf("expected startup to pass without a dependency wait, got %d", rec.Code)
+	}
+	dependencyWaitState.update(true, []string{"orchestrator"})
+	if rec := probe(healthProbeStartup); rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "{\"status\":\"waiting\"}\n" {
+		t.Fatalf("expected startup to fail while waiting, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	if rec := probe("deep"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown probe to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestHealthFieldsFilterResponse(t *testing.T) {
+	t.Setenv("GATEWAY_HEALTH_CHECKS", `{"orchestrator": {"disabled": true}, "indexer": {"disabled": true}}`)
+	resetHealthChecks()
+	t.Cleanup(resetHealthChecks)
+	mux := http.NewServeMux()
+	RegisterHealthRoutes(mux, time.Now())
+	serve := func(target string) (int, map[string]json.RawMessage) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+		}
+		return rec.Code, body
+	}
+
+	code, body := serve("/readyz?fields=status,details.gateway-api,details.missing")
+	if code != http.StatusOK || len(body) != 2 || string(body["status"]) != `"ok"` {
+		t.Fatalf("expected only status and details, got %d %v", code, body)
+	}
+	var details map[string]dependencyResult
+	if err := json.Unmarshal(body["details"], &details); err != nil || len(details) != 1 || details["gateway-api"].Status != healthStatusPass {
+		t.Fatalf("expected only the named dependency, got %s", body["details"])
+	}
+
+	if _, body := serve("/healthz?fields=uptime_seconds"); len(body) != 1 || body["uptime_seconds"] == nil {
+		t.Fatalf("expected only uptime_seconds, got %v", body)
+	}
+	if code, _ := serve("/readyz?fields=status,secrets"); code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown field to be rejected, got %d", code)
+	}
+}
@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const defaultProviderHealthTTL = time.Minute
+
+// Provider check statuses, ordered from best to worst.
+const (
+	providerCheckPass = "pass"
+	providerCheckWarn = "warn"
+	providerCheckFail = "fail"
+)
+
+// ProviderHealthCheck is the outcome of one configuration check.
+type ProviderHealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// ProviderHealth summarises the checks for one OAuth provider; Status is
+// the worst status among its checks.
+type ProviderHealth struct {
+	Status string                `json:"status"`
+	Checks []ProviderHealthCheck `json:"checks"`
+}
+
+// ProviderHealthReport covers every configured OAuth provider. Providers
+// with none of their settings present are left out.
+type ProviderHealthReport struct {
+	Status    string                    `json:"status"`
+	CheckedAt time.Time                 `json:"checked_at"`
+	Providers map[string]ProviderHealth `json:"providers"`
+}
+
+// OK reports whether no provider check failed. Warnings do not fail the
+// report.
+func (r ProviderHealthReport) OK() bool {
+	return r.Status != providerCheckFail
+}
+
+var providerHealthCache struct {
+	mu      sync.Mutex
+	report  ProviderHealthReport
+	expires time.Time
+}
+
+// CheckProviders validates the configuration of every configured OAuth
+// provider: the client ID resolves, discovery answers, the authorize URL
+// parses and the callback matches the redirect allowlist. Problems that would
+// otherwise surface only when a user signs in are reported up front.
+func CheckProviders() ProviderHealthReport {
+	report := ProviderHealthReport{
+		Status:    providerCheckPass,
+		CheckedAt: time.Now().UTC(),
+		Providers: make(map[string]ProviderHealth),
+	}
+	for _, name := range authProviderNames() {
+		p, _ := lookupAuthProvider(name)
+		if !p.Configured() {
+			continue
+		}
+		health := checkProvider(p)
+		report.Providers[name] = health
+		report.Status = worseProviderStatus(report.Status, health.Status)
+	}
+	return report
+}
+
+// cachedProviderHealth returns the last report while it is younger than
+// GATEWAY_PROVIDER_HEALTH_TTL, so probes do not hammer discovery endpoints.
+func cachedProviderHealth() ProviderHealthReport {
+	cache := &providerHealthCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	now := time.Now()
+	if now.Before(cache.expires) {
+		return cache.report
+	}
+	cache.report = CheckProviders()
+	cache.expires = now.Add(GetDurationEnv("GATEWAY_PROVIDER_HEALTH_TTL", defaultProviderHealthTTL))
+	return cache.report
+}
+
+func checkProvider(p authProvider) ProviderHealth {
+	var checks []ProviderHealthCheck
+	cfg, err := p.Config()
+	if err != nil {
+		checks = append(checks, ProviderHealthCheck{Name: "config", Status: providerCheckFail, Message: err.Error()})
+	} else {
+		checks = append(checks,
+			ProviderHealthCheck{Name: "config", Status: providerCheckPass},
+			checkProviderAuthorizeURL(cfg),
+			checkProviderRedirectURI(cfg),
+		)
+	}
+	if _, err := p.JWKSURL(); err != nil && !errors.Is(err, errJWKSUnsupported) {
+		checks = append(checks, ProviderHealthCheck{Name: "jwks", Status: providerCheckFail, Message: err.Error()})
+	}
+
+	health := ProviderHealth{Status: providerCheckPass, Checks: checks}
+	for _, check := range checks {
+		health.Status = worseProviderStatus(health.Status, check.Status)
+	}
+	return health
+}
+
+func checkProviderAuthorizeURL(cfg oauthProvider) ProviderHealthCheck {
+	check := ProviderHealthCheck{Name: "authorize_url", Status: providerCheckPass}
+	u, err := url.Parse(cfg.AuthorizeURL)
+	switch {
+	case err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http"):
+		check.Status = providerCheckFail
+		check.Message = fmt.Sprintf("authorize URL %q is not an absolute http(s) URL", cfg.AuthorizeURL)
+	case u.Scheme == "http":
+		check.Status = providerCheckWarn
+		check.Message = "authorize URL does not use https"
+	}
+	return check
+}
+
+func checkProviderRedirectURI(cfg oauthProvider) ProviderHealthCheck {
+	check := ProviderHealthCheck{Name: "redirect_uri", Status: providerCheckPass}
+	u, err := url.Parse(cfg.RedirectURI)
+	switch {
+	case err != nil || u.Host == "":
+		check.Status = providerCheckFail
+		check.Message = fmt.Sprintf("redirect URI %q is not an absolute URL", cfg.RedirectURI)
+	case !originAllowed(u):
+		check.Status = providerCheckFail
+		check.Message = fmt.Sprintf("redirect URI %s is not in OAUTH_ALLOWED_REDIRECT_ORIGINS", cfg.RedirectURI)
+	case u.Scheme != "https":
+		check.Status = providerCheckWarn
+		check.Message = "redirect URI does not use https"
+	}
+	return check
+}
+
+func worseProviderStatus(a, b string) string {
+	rank := map[string]int{providerCheckPass: 0, providerCheckWarn: 1, providerCheckFail: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+func writeProviderHealth(w http.ResponseWriter, report ProviderHealthReport) {
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func resetProviderHealthCache() {
+	providerHealthCache.mu.Lock()
+	providerHealthCache.report = ProviderHealthReport{}
+	providerHealthCache.expires = time.Time{}
+	providerHealthCache.mu.Unlock()
+}
+
+func clearProviderEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range []string{
+		"GOOGLE_OAUTH_CLIENT_ID", "OPENROUTER_CLIENT_ID", "OIDC_ISSUER_URL", "OIDC_CLIENT_ID",
+		"AZUREAD_CLIENT_ID", "OKTA_DOMAIN", "OKTA_CLIENT_ID", "AUTH0_DOMAIN", "AUTH0_CLIENT_ID",
+	} {
+		t.Setenv(key, "")
+		t.Setenv(key+"_FILE", "")
+	}
+}
+
+func TestCheckProvidersSkipsUnconfiguredProviders(t *testing.T) {
+	clearProviderEnv(t)
+	t.Setenv("GOOGLE_OAUTH_CLIENT_ID", "google-client")
+	t.Setenv("OAUTH_REDIRECT_BASE", "")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+
+	report := CheckProviders()
+	if len(report.Providers) != 1 {
+		t.Fatalf("expected only google to be checked, got %+v", report.Providers)
+	}
+	google := report.Providers["google"]
+	// The default redirect base is plain http on loopback.
+	if google.Status != "warn" || !report.OK() {
+		t.Fatalf("expected google to warn about the http redirect base, got %+v", google)
+	}
+}
+
+func TestCheckProvidersFlagsRedirectOutsideAllowlist(t *testing.T) {
+	clearProviderEnv(t)
+	t.Setenv("GOOGLE_OAUTH_CLIENT_ID", "google-client")
+	t.Setenv("OAUTH_REDIRECT_BASE", "https://gateway.example.com")
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	t.Cleanup(func() { allowedRedirectOrigins = loadAllowedRedirectOrigins() })
+
+	report := CheckProviders()
+	if report.OK() {
+		t.Fatalf("expected redirect mismatch to fail, got %+v", report)
+	}
+	var found bool
+	for _, check := range report.Providers["google"].Checks {
+		if check.Name == "redirect_uri" && check.Status == "fail" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected failing redirect_uri check, got %+v", report.Providers["google"])
+	}
+}
+
+func TestCheckProvidersReportsSecretFileErrors(t *testing.T) {
+	clearProviderEnv(t)
+	t.Setenv("OPENROUTER_CLIENT_ID_FILE", "/nonexistent/client-id")
+
+	health := CheckProviders().Providers["openrouter"]
+	if health.Status != "fail" || len(health.Checks) == 0 || health.Checks[0].Name != "config" {
+		t.Fatalf("expected config failure for unreadable secret file, got %+v", health)
+	}
+}
+
+func TestProviderHealthEndpointIsCached(t *testing.T) {
+	clearProviderEnv(t)
+	resetProviderHealthCache()
+	t.Cleanup(resetProviderHealthCache)
+	mux := http.NewServeMux()
+	RegisterHealthRoutes(mux, time.Now())
+
+	t.Setenv("OIDC_CLIENT_ID", "oidc-client")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz/providers", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for a failing provider, got %d", rec.Code)
+	}
+	var report ProviderHealthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	if report.Providers["oidc"].Status != "fail" {
+		t.Fatalf("expected oidc failure, got %+v", report)
+	}
+
+	// Fixing the configuration is not picked up until the cached report expires.
+	t.Setenv("OIDC_CLIENT_ID", "")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz/providers", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected cached report, got %d", rec.Code)
+	}
+	resetProviderHealthCache()
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz/providers", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 after the cache expires, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,477 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+const (
+	defaultHoneypotDelay          = 2 * time.Second
+	maxHoneypotDelay              = 30 * time.Second
+	defaultHoneypotBanThreshold   = 3
+	defaultHoneypotBanWindow      = 10 * time.Minute
+	defaultHoneypotBanDuration    = time.Hour
+	defaultHoneypotReportInterval = 5 * time.Minute
+	// maxHoneypotDelayed bounds the delayed answers held at once; further
+	// hits are answered at once rather than tying up more goroutines.
+	maxHoneypotDelayed = 256
+	// maxHoneypotBans and maxHoneypotReportClients bound the memory a scan
+	// from many addresses can take. maxHoneypotBans only bounds the bans a
+	// replica caches; the state store holds them all.
+	maxHoneypotBans          = 10000
+	maxHoneypotReportClients = 10000
+	maxHoneypotReportedBans  = 20
+
+	honeypotBanNamespace = "honeypot_ban"
+
+	auditEventHoneypotSummary = "gateway.honeypot.summary"
+	auditTargetHoneypot       = "gateway.honeypot"
+)
+
+// defaultHoneypotPaths are common scanner probes, selected by "default" in
+// GATEWAY_HONEYPOT_PATHS.
+var defaultHoneypotPaths = []string{
+	"/wp-admin*", "/wp-login.php", "/wp-content*", "/wp-includes*", "/xmlrpc.php",
+	"/.env*", "/.git*", "/.aws*", "/.ssh*", "/.DS_Store",
+	"/phpmyadmin*", "/vendor/phpunit*", "/cgi-bin*", "/server-status", "/actuator*",
+	"/config.php", "/HNAP1", "/boaform*",
+}
+
+// honeypotPath matches a path exactly or, when prefix is set, by prefix.
+// Matching ignores case.
+type honeypotPath struct {
+	pattern string
+	prefix  bool
+}
+
+func (p honeypotPath) String() string {
+	if p.prefix {
+		return p.pattern + "*"
+	}
+	return p.pattern
+}
+
+func (p honeypotPath) matches(path string) bool {
+	if p.prefix {
+		return len(path) >= len(p.pattern) && strings.EqualFold(path[:len(p.pattern)], p.pattern)
+	}
+	return strings.EqualFold(path, p.pattern)
+}
+
+type honeypotConfig struct {
+	paths          []honeypotPath
+	delay          time.Duration
+	banThreshold   int
+	banWindow      time.Duration
+	banDuration    time.Duration
+	reportInterval time.Duration
+}
+
+func parseHoneypotPaths(raw string) ([]honeypotPath, error) {
+	var paths []honeypotPath
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case entry == "default":
+			for _, path := range defaultHoneypotPaths {
+				paths = append(paths, honeypotPath{pattern: strings.TrimSuffix(path, "*"), prefix: strings.HasSuffix(path, "*")})
+			}
+			continue
+		case !strings.HasPrefix(entry, "/") || entry == "/" || entry == "/*":
+			return nil, fmt.Errorf("GATEWAY_HONEYPOT_PATHS entry %q must be a path below /", entry)
+		case strings.Contains(strings.TrimSuffix(entry, "*"), "*"):
+			return nil, fmt.Errorf("GATEWAY_HONEYPOT_PATHS entry %q may only end with *", entry)
+		}
+		paths = append(paths, honeypotPath{pattern: strings.TrimSuffix(entry, "*"), prefix: strings.HasSuffix(entry, "*")})
+	}
+	return paths, nil
+}
+
+func loadHoneypotConfig() (honeypotConfig, error) {
+	paths, err := parseHoneypotPaths(GetEnv("GATEWAY_HONEYPOT_PATHS", ""))
+	if err != nil {
+		return honeypotConfig{}, err
+	}
+	cfg := honeypotConfig{
+		paths:          paths,
+		delay:          defaultHoneypotDelay,
+		banThreshold:   defaultHoneypotBanThreshold,
+		banWindow:      ResolveDuration([]string{"GATEWAY_HONEYPOT_BAN_WINDOW"}, defaultHoneypotBanWindow),
+		banDuration:    ResolveDuration([]string{"GATEWAY_HONEYPOT_BAN_DURATION"}, defaultHoneypotBanDuration),
+		reportInterval: ResolveDuration([]string{"GATEWAY_HONEYPOT_REPORT_INTERVAL"}, defaultHoneypotReportInterval),
+	}
+	if raw := strings.TrimSpace(GetEnv("GATEWAY_HONEYPOT_DELAY", "")); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < 0 || parsed > maxHoneypotDelay {
+			return honeypotConfig{}, fmt.Errorf("GATEWAY_HONEYPOT_DELAY must be a duration between 0 and %s", maxHoneypotDelay)
+		}
+		cfg.delay = parsed
+	}
+	if raw := strings.TrimSpace(GetEnv("GATEWAY_HONEYPOT_BAN_THRESHOLD", "")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return honeypotConfig{}, errors.New("GATEWAY_HONEYPOT_BAN_THRESHOLD must be a non-negative integer")
+		}
+		cfg.banThreshold = parsed
+	}
+	return cfg, nil
+}
+
+// honeypot answers scanner probes of unrouted paths with a delayed 404 and
+// bans addresses that keep probing. Hit counts and bans are kept in
+// GATEWAY_STORAGE_URL, so a Redis store shares them between replicas and a
+// file or Redis store keeps them across restarts.
+type honeypot struct {
+	cfg     honeypotConfig
+	hits    *rateLimiter
+	bucket  rateLimitBucket
+	delayed chan struct{}
+	now     func() time.Time
+	// store holds each banned address with its ban expiry. It is nil when
+	// the state store cannot be opened, leaving bans to this replica.
+	store storage.Store
+
+	// bans caches the bans this replica has started or looked up.
+	mu     sync.RWMutex
+	bans   map[string]time.Time
+	banned atomic.Int64
+
+	reportMu sync.Mutex
+	report   honeypotReport
+}
+
+// honeypotReport aggregates hits between two summary audit events.
+type honeypotReport struct {
+	hits    int
+	blocked int
+	clients map[string]struct{}
+	paths   map[string]int
+	bans    []string
+	banned  int
+}
+
+func newHoneypot(cfg honeypotConfig) *honeypot {
+	var bans storage.Store
+	if store, err := loadStateStorage(); err != nil {
+		slog.Warn("honeypot bans will not be shared or survive restarts", slog.Any("error", err))
+	} else {
+		bans = storage.Namespace(store, honeypotBanNamespace)
+	}
+	return &honeypot{
+		cfg:  cfg,
+		hits: newPersistentRateLimiter("honeypot"),
+		bucket: rateLimitBucket{
+			Endpoint:     "honeypot",
+			IdentityType: "ip",
+			Limit:        cfg.banThreshold - 1,
+			Window:       cfg.banWindow,
+		},
+		delayed: make(chan struct{}, maxHoneypotDelayed),
+		now:     clockNow,
+		store:   bans,
+		bans:    make(map[string]time.Time),
+	}
+}
+
+var (
+	honeypotMu   sync.Mutex
+	honeypotOnce sync.Once
+	honeypotInst *honeypot
+	honeypotErr  error
+)
+
+// resetHoneypot clears the shared honeypot for tests.
+func resetHoneypot() {
+	honeypotMu.Lock()
+	defer honeypotMu.Unlock()
+	honeypotOnce = sync.Once{}
+	honeypotInst = nil
+	honeypotErr = nil
+}
+
+// loadHoneypot returns the shared honeypot, or nil when
+// GATEWAY_HONEYPOT_PATHS is unset.
+func loadHoneypot() (*honeypot, error) {
+	honeypotMu.Lock()
+	defer honeypotMu.Unlock()
+	honeypotOnce.Do(func() {
+		cfg, err := loadHoneypotConfig()
+		if err != nil {
+			honeypotErr = err
+			return
+		}
+		if len(cfg.paths) > 0 {
+			honeypotInst = newHoneypot(cfg)
+		}
+	})
+	return honeypotInst, honeypotErr
+}
+
+// ValidateHoneypotConfig checks the GATEWAY_HONEYPOT_* settings at startup.
+func ValidateHoneypotConfig() error {
+	_, err := loadHoneypot()
+	return err
+}
+
+// HoneypotMiddleware wraps the public router. Requests from banned addresses
+// get 403 with Retry-After. Requests for a GATEWAY_HONEYPOT_PATHS entry that
+// no route serves are answered with the usual 404 after
+// GATEWAY_HONEYPOT_DELAY, and an address with GATEWAY_HONEYPOT_BAN_THRESHOLD
+// such hits within GATEWAY_HONEYPOT_BAN_WINDOW is banned for
+// GATEWAY_HONEYPOT_BAN_DURATION. Hits are audited in aggregate by the
+// honeypot_report job rather than one event each. Every request from an
+// address this replica has not seen banned looks it up in the state store.
+func HoneypotMiddleware(next http.Handler, trusted []*net.IPNet) http.Handler {
+	pot, _ := loadHoneypot()
+	if pot == nil {
+		return next
+	}
+	resolver, _ := next.(routeResolver)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if remaining := pot.banRemaining(r.Context(), ClientIP(r, trusted)); remaining > 0 {
+			pot.recordBlocked()
+			w.Header().Set("Retry-After", strconv.Itoa(int(remaining.Round(time.Second)/time.Second)))
+			writeErrorResponse(w, r, http.StatusForbidden, "forbidden", "client temporarily blocked", nil)
+			return
+		}
+		path, ok := pot.match(r.URL.Path)
+		if ok && resolver != nil {
+			_, pattern := resolver.Handler(r)
+			ok = pattern == ""
+		}
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pot.hit(r, path, trusted)
+		pot.wait(r.Context())
+		writeErrorResponse(w, r, http.StatusNotFound, "not_found", "not found", nil)
+	})
+}
+
+func (h *honeypot) match(path string) (honeypotPath, bool) {
+	for _, candidate := range h.cfg.paths {
+		if candidate.matches(path) {
+			return candidate, true
+		}
+	}
+	return honeypotPath{}, false
+}
+
+// banRemaining reports how much longer ip is banned. Bans missing from the
+// cache are looked up in the store, and a store failure lets the request
+// through: the honeypot deters scanners rather than guarding anything.
+func (h *honeypot) banRemaining(ctx context.Context, ip string) time.Duration {
+	now := h.now()
+	if h.banned.Load() > 0 {
+		h.mu.RLock()
+		until, ok := h.bans[ip]
+		h.mu.RUnlock()
+		if ok && until.After(now) {
+			return until.Sub(now)
+		}
+	}
+	if h.store == nil {
+		return 0
+	}
+	data, err := h.store.Get(ctx, ip)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			slog.WarnContext(ctx, "honeypot ban lookup failed", slog.Any("error", err))
+		}
+		return 0
+	}
+	until, err := time.Parse(time.RFC3339Nano, string(data))
+	if err != nil || !until.After(now) {
+		return 0
+	}
+	h.remember(ip, until)
+	return until.Sub(now)
+}
+
+// hit counts a honeypot request and bans its address once it reaches the
+// threshold. The address of a trusted proxy is never banned, since that
+// would block every client behind it.
+func (h *honeypot) hit(r *http.Request, path honeypotPath, trusted []*net.IPNet) {
+	ip := ClientIP(r, trusted)
+	banned := false
+	if h.cfg.banThreshold > 0 && h.cfg.banDuration > 0 {
+		parsed := net.ParseIP(ip)
+		if parsed == nil || !IsTrustedProxy(parsed, trusted) {
+			allowed := h.cfg.banThreshold > 1
+			if allowed {
+				allowed, _, _ = h.hits.Allow(r.Context(), h.bucket, ip)
+			}
+			banned = !allowed && h.ban(r.Context(), ip)
+		}
+	}
+
+	h.reportMu.Lock()
+	defer h.reportMu.Unlock()
+	report := &h.report
+	if report.clients == nil {
+		report.clients = make(map[string]struct{})
+		report.paths = make(map[string]int)
+	}
+	report.hits++
+	report.paths[path.String()]++
+	actor := gatewayAuditLogger.HashIdentity(ip)
+	if len(report.clients) < maxHoneypotReportClients {
+		report.clients[actor] = struct{}{}
+	}
+	if banned {
+		report.banned++
+		if len(report.bans) < maxHoneypotReportedBans {
+			report.bans = append(report.bans, actor)
+		}
+	}
+}
+
+// ban blocks ip for the ban duration, reporting whether a new ban started.
+// The ban is written to the store for every replica to see; without one it
+// is only cached here.
+func (h *honeypot) ban(ctx context.Context, ip string) bool {
+	until := h.now().Add(h.cfg.banDuration)
+	if h.store != nil {
+		started, err := h.store.SetNX(ctx, ip, []byte(until.UTC().Format(time.RFC3339Nano)), h.cfg.banDuration)
+		if err == nil {
+			if started {
+				h.remember(ip, until)
+			}
+			return started
+		}
+		slog.WarnContext(ctx, "honeypot ban kept on this replica only", slog.Any("error", err))
+	}
+	return h.remember(ip, until)
+}
+
+// remember caches a ban of ip until the given time, reporting whether it was
+// not cached already. Expired bans are dropped when the table is full; if it
+// is still full the ban is not cached.
+func (h *honeypot) remember(ip string, until time.Time) bool {
+	now := h.now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cached, ok := h.bans[ip]; ok && cached.After(now) {
+		return false
+	}
+	if len(h.bans) >= maxHoneypotBans {
+		h.pruneLocked(now)
+		if len(h.bans) >= maxHoneypotBans {
+			return false
+		}
+	}
+	h.bans[ip] = until
+	h.banned.Store(int64(len(h.bans)))
+	return true
+}
+
+func (h *honeypot) pruneLocked(now time.Time) {
+	for ip, until := range h.bans {
+		if !until.After(now) {
+			delete(h.bans, ip)
+		}
+	}
+	h.banned.Store(int64(len(h.bans)))
+}
+
+func (h *honeypot) recordBlocked() {
+	h.reportMu.Lock()
+	h.report.blocked++
+	h.reportMu.Unlock()
+}
+
+// wait holds the answer for the configured delay, unless too many answers
+// are already waiting or the client goes away.
+func (h *honeypot) wait(ctx context.Context) {
+	if h.cfg.delay <= 0 {
+		return
+	}
+	select {
+	case h.delayed <- struct{}{}:
+		defer func() { <-h.delayed }()
+	default:
+		return
+	}
+	timer := time.NewTimer(h.cfg.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// takeReport returns the hits since the last call and starts a new report.
+// Expired bans are dropped on the way.
+func (h *honeypot) takeReport() honeypotReport {
+	h.mu.Lock()
+	h.pruneLocked(h.now())
+	h.mu.Unlock()
+	h.reportMu.Lock()
+	defer h.reportMu.Unlock()
+	report := h.report
+	h.report = honeypotReport{}
+	return report
+}
+
+// reportHoneypot is the honeypot_report maintenance job. It emits one
+// gateway.honeypot.summary audit event for the hits and blocked requests
+// since its last run, and nothing when there were none.
+func reportHoneypot(ctx context.Context) error {
+	pot, err := loadHoneypot()
+	if err != nil || pot == nil {
+		return err
+	}
+	report := pot.takeReport()
+	if report.hits == 0 && report.blocked == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(report.paths))
+	for path := range report.paths {
+		paths = append(paths, path)
+	}
+	slices.SortFunc(paths, func(a, b string) int {
+		if report.paths[a] != report.paths[b] {
+			return report.paths[b] - report.paths[a]
+		}
+		return strings.Compare(a, b)
+	})
+	topPaths := make(map[string]any)
+	for _, path := range paths[:min(len(paths), 10)] {
+		topPaths[path] = report.paths[path]
+	}
+	gatewayAuditLogger.Security(ctx, audit.Event{
+		Name:       auditEventHoneypotSummary,
+		Outcome:    auditOutcomeDenied,
+		Target:     auditTargetHoneypot,
+		Capability: auditTargetHoneypot,
+		Details: auditDetails(map[string]any{
+			"hits":               report.hits,
+			"blocked":            report.blocked,
+			"clients":            len(report.clients),
+			"bans":               report.banned,
+			"banned_actor_ids":   report.bans,
+			"paths":              topPaths,
+			"active_bans":        int(pot.banned.Load()),
+			"interval_seconds":   int(pot.cfg.reportInterval / time.Second),
+			"ban_threshold":      pot.cfg.banThreshold,
+			"ban_window_seconds": int(pot.cfg.banWindow / time.Second),
+		}),
+	})
+	return nil
+}
@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newHoneypotTestHandler(t *testing.T, trusted []*net.IPNet) http.Handler {
+	t.Helper()
+	resetHoneypot()
+	t.Cleanup(resetHoneypot)
+	resetStateStorage()
+	t.Cleanup(resetStateStorage)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /.well-known/security.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return HoneypotMiddleware(NewRouter(mux), trusted)
+}
+
+func serveHoneypot(handler http.Handler, path, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHoneypotMiddlewareBansRepeatScanners(t *testing.T) {
+	t.Setenv("GATEWAY_HONEYPOT_PATHS", "default,/.well-known*")
+	t.Setenv("GATEWAY_HONEYPOT_DELAY", "0")
+	t.Setenv("GATEWAY_HONEYPOT_BAN_THRESHOLD", "2")
+	logs := captureAuditLogs(t)
+	handler := newHoneypotTestHandler(t, nil)
+
+	// A routed path under a honeypot prefix is served as usual.
+	if rec := serveHoneypot(handler, "/.well-known/security.txt", "203.0.113.7:1234"); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the routed path to be served, got %d", rec.Code)
+	}
+	rec := serveHoneypot(handler, "/WP-Admin/setup.php", "203.0.113.7:1234")
+	var body httpErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusNotFound || body.Code != "not_found" {
+		t.Fatalf("expected the usual 404 envelope, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := serveHoneypot(handler, "/healthz", "203.0.113.7:1234"); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected one hit not to ban, got %d", rec.Code)
+	}
+	serveHoneypot(handler, "/.env", "203.0.113.7:1234")
+	rec = serveHoneypot(handler, "/healthz", "203.0.113.7:1234")
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Retry-After") != "3600" {
+		t.Fatalf("expected the scanner to be banned, got %d retry-after %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := serveHoneypot(handler, "/healthz", "198.51.100.1:1234"); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected other clients to be unaffected, got %d", rec.Code)
+	}
+	if strings.Contains(logs.String(), "gateway.honeypot") {
+		t.Fatalf("expected no per-hit audit events, got %s", logs.String())
+	}
+
+	if err := reportHoneypot(context.Background()); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	for _, want := range []string{`"event":"gateway.honeypot.summary"`, `"hits":2`, `"blocked":1`, `"clients":1`, `"bans":1`, `"/wp-admin*":1`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("expected %s in the summary, got %s", want, logs.String())
+		}
+	}
+	logs.Reset()
+	if err := reportHoneypot(context.Background()); err != nil || logs.Len() != 0 {
+		t.Fatalf("expected a quiet interval to emit nothing, got %v %s", err, logs.String())
+	}
+}
+
+func TestHoneypotBanSurvivesRestart(t *testing.T) {
+	t.Setenv("GATEWAY_STORAGE_URL", "file://"+filepath.Join(t.TempDir(), "state.db"))
+	t.Setenv("GATEWAY_HONEYPOT_PATHS", "/wp-login.php")
+	t.Setenv("GATEWAY_HONEYPOT_DELAY", "0")
+	t.Setenv("GATEWAY_HONEYPOT_BAN_THRESHOLD", "2")
+	handler := newHoneypotTestHandler(t, nil)
+
+	serveHoneypot(handler, "/wp-login.php", "203.0.113.7:1234")
+	serveHoneypot(handler, "/wp-login.php", "203.0.113.7:1234")
+	serveHoneypot(handler, "/wp-login.php", "198.51.100.1:1234")
+	if rec := serveHoneypot(handler, "/healthz", "203.0.113.7:1234"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected the scanner to be banned, got %d", rec.Code)
+	}
+
+	// Rebuilding the honeypot over a reopened file store stands in for a
+	// restarted process or another replica.
+	handler = newHoneypotTestHandler(t, nil)
+	rec := serveHoneypot(handler, "/healthz", "203.0.113.7:1234")
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the ban to survive the restart, got %d", rec.Code)
+	}
+	if rec := serveHoneypot(handler, "/healthz", "198.51.100.1:1234"); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected one earlier hit not to ban, got %d", rec.Code)
+	}
+	serveHoneypot(handler, "/wp-login.php", "198.51.100.1:1234")
+	if rec := serveHoneypot(handler, "/healthz", "198.51.100.1:1234"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected hits before the restart to count toward the ban, got %d", rec.Code)
+	}
+}
+
+func TestHoneypotNeverBansTrustedProxies(t *testing.T) {
+	t.Setenv("GATEWAY_HONEYPOT_PATHS", "/.env")
+	t.Setenv("GATEWAY_HONEYPOT_DELAY", "0")
+	t.Setenv("GATEWAY_HONEYPOT_BAN_THRESHOLD", "1")
+	captureAuditLogs(t)
+	_, proxy, _ := net.ParseCIDR("10.0.0.0/8")
+	handler := newHoneypotTestHandler(t, []*net.IPNet{proxy})
+
+	serveHoneypot(handler, "/.env", "10.0.0.5:1234")
+	if rec := serveHoneypot(handler, "/healthz", "10.0.0.5:1234"); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the proxy address not to be banned, got %d", rec.Code)
+	}
+}
+
+func TestHoneypotDelayEndsWithClient(t *testing.T) {
+	pot := newHoneypot(honeypotConfig{delay: maxHoneypotDelay})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	pot.wait(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected a cancelled request not to be held, waited %s", elapsed)
+	}
+}
+
+func TestHoneypotConfig(t *testing.T) {
+	resetHoneypot()
+	t.Cleanup(resetHoneypot)
+	if pot, err := loadHoneypot(); pot != nil || err != nil {
+		t.Fatalf("expected the honeypot to be off by default, got %v %v", pot, err)
+	}
+	for key, value := range map[string]string{
+		"GATEWAY_HONEYPOT_PATHS":         "wp-admin",
+		"GATEWAY_HONEYPOT_DELAY":         "1m",
+		"GATEWAY_HONEYPOT_BAN_THRESHOLD": "-1",
+	} {
+		t.Run(key, func(t *testing.T) {
+			resetHoneypot()
+			if key != "GATEWAY_HONEYPOT_PATHS" {
+				t.Setenv("GATEWAY_HONEYPOT_PATHS", "default")
+			}
+			t.Setenv(key, value)
+			if err := ValidateHoneypotConfig(); err == nil {
+				t.Fatalf("expected %s=%s to be rejected", key, value)
+			}
+		})
+	}
+	for _, entry := range []string{"/", "/*", "/a*b"} {
+		if _, err := parseHoneypotPaths(entry); err == nil {
+			t.Fatalf("expected %q to be rejected", entry)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultIdentityAssertionTTL  = time.Minute
+	minIdentityAssertionKeyBytes = 32
+)
+
+var errIdentityAssertionNotConfigured = errors.New("GATEWAY_IDENTITY_ASSERTION_KEY is not configured")
+
+// identityAssertion vouches for a user the gateway authenticated itself
+// (Kerberos, LDAP) rather than through an OAuth provider. The orchestrator
+// verifies it with the shared key and mints a session.
+type identityAssertion struct {
+	ID           string   `json:"jti"`
+	Subject      string   `json:"sub"`
+	Method       string   `json:"method"`
+	TenantID     string   `json:"tenant,omitempty"`
+	Groups       []string `json:"groups,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	IssuedAt     int64    `json:"iat"`
+	ExpiresAt    int64    `json:"exp"`
+}
+
+func loadIdentityAssertionKey() ([]byte, error) {
+	key, err := ResolveEnvValue("GATEWAY_IDENTITY_ASSERTION_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_IDENTITY_ASSERTION_KEY: %w", err)
+	}
+	if key == "" {
+		return nil, errIdentityAssertionNotConfigured
+	}
+	if len(key) < minIdentityAssertionKeyBytes {
+		return nil, fmt.Errorf("GATEWAY_IDENTITY_ASSERTION_KEY must be at least %d bytes", minIdentityAssertionKeyBytes)
+	}
+	return []byte(key), nil
+}
+
+// assertionExchangeError reports an orchestrator rejection of an assertion.
+type assertionExchangeError struct {
+	StatusCode int
+	SafeError  string
+	Detail     string
+}
+
+func (e *assertionExchangeError) Error() string {
+	return fmt.Sprintf("orchestrator rejected identity assertion with status %d: %s", e.StatusCode, e.Detail)
+}
+
+// exchangeIdentityAssertion signs assertion and posts it to the orchestrator's
+// /auth/assertion endpoint, returning the session cookies it sets.
+func exchangeIdentityAssertion(ctx context.Context, assertion identityAssertion, requestID string, now time.Time) ([]*http.Cookie, error) {
+	key, err := loadIdentityAssertionKey()
+	if err != nil {
+		return nil, err
+	}
+	assertion.ID = uuid.NewString()
+	assertion.IssuedAt = now.Unix()
+	assertion.ExpiresAt = now.Add(GetDurationEnv("GATEWAY_IDENTITY_ASSERTION_TTL", defaultIdentityAssertionTTL)).Unix()
+	token, err := signGatewayToken(key, gatewayTokenIdentityAssertion, assertion)
+	if err != nil {
+		return nil, err
+	}
+	resp, body, err := postGatewayToken(ctx, orchestratorAuthAssertion, "assertion", token, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		safeError, detailedError, _ := sanitizeOrchestratorError(body)
+		return nil, &assertionExchangeError{StatusCode: resp.StatusCode, SafeError: safeError, Detail: detailedError}
+	}
+	return resp.Cookies(), nil
+}
+
+// postGatewayToken posts {field: token} to an orchestrator endpoint. The
+// returned response body has already been read and closed.
+func postGatewayToken(ctx context.Context, endpoint, field, token, requestID string) (*http.Response, []byte, error) {
+	buf, err := json.Marshal(map[string]string{field: token})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, orchestratorURL, err := currentOrchestrator()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, orchestratorTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, orchestratorAPI.URL(ctx, orchestratorURL, endpoint), bytes.NewReader(buf))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if requestID != "" {
+		req.Header.Set("X-Request-Id", requestID)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readUpstreamBody(resp.Body, maxUpstreamResponseBytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
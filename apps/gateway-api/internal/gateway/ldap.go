@@ -0,0 +1,501 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const (
+	defaultLDAPUserDNTemplate   = "{username}"
+	defaultLDAPUserAttribute    = "uid"
+	defaultLDAPGroupAttribute   = "memberOf"
+	defaultLDAPTimeout          = 5 * time.Second
+	defaultLDAPLockoutThreshold = 5
+	defaultLDAPLockoutWindow    = 15 * time.Minute
+	defaultLDAPLockoutDuration  = 15 * time.Minute
+	maxLDAPLoginBodyBytes       = 8 * 1024
+	maxLDAPPasswordLength       = 1024
+	maxLDAPLockoutEntries       = 10000
+)
+
+// ldapUsernamePattern keeps usernames free of DN and filter metacharacters so
+// they can be substituted into the DN template without escaping.
+var ldapUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,128}$`)
+
+type ldapLoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	TenantID string `json:"tenant_id"`
+}
+
+// ldapGroupMapping grants a tenant and capabilities to members of a directory
+// group.
+type ldapGroupMapping struct {
+	Group        string
+	TenantID     string
+	Capabilities []string
+}
+
+// ldapConfig holds the direct-bind settings. It is nil when LDAP login is
+// disabled.
+type ldapConfig struct {
+	url            string
+	tlsConfig      *tls.Config
+	timeout        time.Duration
+	userDNTemplate string
+	// baseDN, when set, is searched for userAttribute=username after the bind.
+	// Otherwise the bound DN itself is read.
+	baseDN         string
+	userAttribute  string
+	groupAttribute string
+	groupMappings  []ldapGroupMapping
+	lockout        *ldapLockout
+}
+
+// loadLDAPConfig reads the LDAP settings. LDAP login is enabled by setting
+// GATEWAY_LDAP_URL.
+func loadLDAPConfig() (*ldapConfig, error) {
+	rawURL := strings.TrimSpace(os.Getenv("GATEWAY_LDAP_URL"))
+	if rawURL == "" {
+		return nil, nil
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return nil, fmt.Errorf("invalid GATEWAY_LDAP_URL %q", rawURL)
+	}
+	if parsed.Scheme != "ldaps" && parsed.Scheme != "ldap" {
+		return nil, fmt.Errorf("GATEWAY_LDAP_URL must use ldaps:// or ldap:// (with StartTLS), got %q", parsed.Scheme)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile := strings.TrimSpace(os.Getenv("GATEWAY_LDAP_CA_FILE")); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GATEWAY_LDAP_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("GATEWAY_LDAP_CA_FILE contains no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	template := strings.TrimSpace(GetEnv("GATEWAY_LDAP_USER_DN_TEMPLATE", defaultLDAPUserDNTemplate))
+	if !strings.Contains(template, "{username}") {
+		return nil, errors.New("GATEWAY_LDAP_USER_DN_TEMPLATE must contain {username}")
+	}
+	mappings, err := loadLDAPGroupMappings()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := loadIdentityAssertionKey(); err != nil {
+		return nil, err
+	}
+
+	return &ldapConfig{
+		url:            rawURL,
+		tlsConfig:      tlsConfig,
+		timeout:        GetDurationEnv("GATEWAY_LDAP_TIMEOUT", defaultLDAPTimeout),
+		userDNTemplate: template,
+		baseDN:         strings.TrimSpace(os.Getenv("GATEWAY_LDAP_BASE_DN")),
+		userAttribute:  strings.TrimSpace(GetEnv("GATEWAY_LDAP_USER_ATTRIBUTE", defaultLDAPUserAttribute)),
+		groupAttribute: strings.TrimSpace(GetEnv("GATEWAY_LDAP_GROUP_ATTRIBUTE", defaultLDAPGroupAttribute)),
+		groupMappings:  mappings,
+		lockout: newLDAPLockout(
+			GetIntEnv("GATEWAY_LDAP_LOCKOUT_THRESHOLD", defaultLDAPLockoutThreshold),
+			GetDurationEnv("GATEWAY_LDAP_LOCKOUT_WINDOW", defaultLDAPLockoutWindow),
+			GetDurationEnv("GATEWAY_LDAP_LOCKOUT_DURATION", defaultLDAPLockoutDuration),
+		),
+	}, nil
+}
+
+func loadLDAPGroupMappings() ([]ldapGroupMapping, error) {
+	raw, err := ResolveEnvValue("GATEWAY_LDAP_GROUP_MAPPINGS")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_LDAP_GROUP_MAPPINGS: %w", err)
+	}
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	return parseLDAPGroupMappings(raw)
+}
+
+func parseLDAPGroupMappings(raw string) ([]ldapGroupMapping, error) {
+	type mappingPayload struct {
+		Group        string   `json:"group"`
+		TenantID     string   `json:"tenant_id"`
+		Capabilities []string `json:"capabilities"`
+	}
+
+	var payload []mappingPayload
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse GATEWAY_LDAP_GROUP_MAPPINGS: %w", err)
+	}
+	result := make([]ldapGroupMapping, 0, len(payload))
+	for idx, entry := range payload {
+		group := strings.TrimSpace(entry.Group)
+		if group == "" {
+			return nil, fmt.Errorf("ldap group mapping %d: group is required", idx)
+		}
+		tenantID, err := normalizeTenantID(entry.TenantID)
+		if err != nil {
+			return nil, fmt.Errorf("ldap group mapping %d: %w", idx, err)
+		}
+		if tenantID == "" {
+			return nil, fmt.Errorf("ldap group mapping %d: tenant_id is required", idx)
+		}
+		var capabilities []string
+		for _, capability := range entry.Capabilities {
+			if trimmed := strings.TrimSpace(capability); trimmed != "" {
+				capabilities = append(capabilities, trimmed)
+			}
+		}
+		result = append(result, ldapGroupMapping{Group: group, TenantID: tenantID, Capabilities: capabilities})
+	}
+	return result, nil
+}
+
+// ldapGrant is the tenant and capabilities a login resolves to.
+type ldapGrant struct {
+	TenantID     string
+	Groups       []string
+	Capabilities []string
+}
+
+// resolveGrants groups the mappings that match the user's groups by tenant.
+// Without mappings every directory user may sign in without a tenant.
+func (c *ldapConfig) resolveGrants(groups []string) map[string]*ldapGrant {
+	grants := make(map[string]*ldapGrant)
+	if len(c.groupMappings) == 0 {
+		grants[""] = &ldapGrant{}
+		return grants
+	}
+	member := make(map[string]struct{}, len(groups))
+	for _, group := range groups {
+		member[strings.ToLower(strings.TrimSpace(group))] = struct{}{}
+	}
+	for _, mapping := range c.groupMappings {
+		if _, ok := member[strings.ToLower(mapping.Group)]; !ok {
+			continue
+		}
+		grant := grants[mapping.TenantID]
+		if grant == nil {
+			grant = &ldapGrant{TenantID: mapping.TenantID}
+			grants[mapping.TenantID] = grant
+		}
+		grant.Groups = append(grant.Groups, mapping.Group)
+		for _, capability := range mapping.Capabilities {
+			if !slices.Contains(grant.Capabilities, capability) {
+				grant.Capabilities = append(grant.Capabilities, capability)
+			}
+		}
+	}
+	for _, grant := range grants {
+		sort.Strings(grant.Capabilities)
+	}
+	return grants
+}
+
+// authenticate binds as the user and returns the DN and group memberships.
+func (c *ldapConfig) authenticate(r *http.Request, username, password string) (string, []string, error) {
+	conn, err := dialLDAP(r.Context(), c.url, c.tlsConfig, c.timeout)
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.close()
+
+	bindDN := strings.ReplaceAll(c.userDNTemplate, "{username}", username)
+	if err := conn.bind(bindDN, password); err != nil {
+		return "", nil, err
+	}
+
+	var entries []ldapEntry
+	if c.baseDN != "" {
+		entries, err = conn.search(c.baseDN, ldapScopeWholeSubtree, c.userAttribute, username, []string{c.groupAttribute})
+	} else {
+		entries, err = conn.search(bindDN, ldapScopeBaseObject, "", "", []string{c.groupAttribute})
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return "", nil, errors.New("ldap: bound user entry not found")
+	case 1:
+	default:
+		return "", nil, errors.New("ldap: user search matched multiple entries")
+	}
+	return entries[0].DN, entries[0].Attributes[strings.ToLower(c.groupAttribute)], nil
+}
+
+// ldapLockout locks usernames out after repeated failed binds so the gateway
+// cannot be used to brute-force, or lock out, directory accounts.
+type ldapLockout struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	duration  time.Duration
+	entries   map[string]ldapLockoutEntry
+	now       func() time.Time
+}
+
+type ldapLockoutEntry struct {
+	failures    int
+	windowEnds  time.Time
+	lockedUntil time.Time
+}
+
+func newLDAPLockout(threshold int, window, duration time.Duration) *ldapLockout {
+	return &ldapLockout{
+		threshold: threshold,
+		window:    window,
+		duration:  duration,
+		entries:   make(map[string]ldapLockoutEntry),
+		now:       clockNow,
+	}
+}
+
+// locked returns how long username remains locked out.
+func (l *ldapLockout) locked(username string) (time.Duration, bool) {
+	if l.threshold <= 0 {
+		return 0, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[strings.ToLower(username)]
+	if !ok {
+		return 0, false
+	}
+	remaining := entry.lockedUntil.Sub(l.now())
+	return remaining, remaining > 0
+}
+
+// recordFailure counts a failed bind and reports whether it locked the account.
+func (l *ldapLockout) recordFailure(username string) bool {
+	if l.threshold <= 0 {
+		return false
+	}
+	now := l.now()
+	key := strings.ToLower(username)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, tracked := l.entries[key]; !tracked && len(l.entries) >= maxLDAPLockoutEntries {
+		l.evict(now)
+	}
+	entry := l.entries[key]
+	if now.After(entry.windowEnds) {
+		entry = ldapLockoutEntry{windowEnds: now.Add(l.window)}
+	}
+	entry.failures++
+	lockedNow := false
+	if entry.failures >= l.threshold {
+		entry.lockedUntil = now.Add(l.duration)
+		entry.failures = 0
+		entry.windowEnds = entry.lockedUntil
+		lockedNow = true
+	}
+	l.entries[key] = entry
+	return lockedNow
+}
+
+// evict drops expired entries and, if the table is still full, the entry
+// closest to expiring. Unlocked entries go before locked ones so a flood of
+// failures for made-up usernames cannot lift a real account's lockout early.
+func (l *ldapLockout) evict(now time.Time) {
+	var (
+		victim       string
+		victimLocked bool
+		victimEnds   time.Time
+	)
+	for k, entry := range l.entries {
+		locked := now.Before(entry.lockedUntil)
+		if !locked && now.After(entry.windowEnds) {
+			delete(l.entries, k)
+			continue
+		}
+		ends := entry.windowEnds
+		if locked {
+			ends = entry.lockedUntil
+		}
+		if victim == "" || (victimLocked && !locked) || (victimLocked == locked && ends.Before(victimEnds)) {
+			victim, victimLocked, victimEnds = k, locked, ends
+		}
+	}
+	if len(l.entries) >= maxLDAPLockoutEntries {
+		delete(l.entries, victim)
+	}
+}
+
+func (l *ldapLockout) reset(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, strings.ToLower(username))
+}
+
+// ldapLoginHandler authenticates a username and password with an LDAP bind
+// and exchanges the resulting identity for an orchestrator session.
+func ldapLoginHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, cfg *ldapConfig) {
+	var req ldapLoginRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLDAPLoginBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, map[string]any{"reason": "invalid_body"})
+		if requestBodyTooLarge(err) {
+			writeErrorResponse(w, r, http.StatusRequestEntityTooLarge, "payload_too_large", "request body too large", nil)
+			return
+		}
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "request body must be a JSON object", nil)
+		return
+	}
+
+	username := strings.TrimSpace(req.Username)
+	var errs []validationError
+	if !ldapUsernamePattern.MatchString(username) {
+		errs = append(errs, validationError{Field: "username", Message: "username may only include letters, numbers, '.', '_', '@' or '-' (max 128)"})
+	}
+	if req.Password == "" || len(req.Password) > maxLDAPPasswordLength {
+		errs = append(errs, validationError{Field: "password", Message: "password is required"})
+	}
+	requestedTenant, tenantErr := normalizeTenantID(req.TenantID)
+	if tenantErr != nil {
+		errs = append(errs, validationError{Field: "tenant_id", Message: tenantValidationErrorMessage})
+	}
+	if len(errs) > 0 {
+		auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, map[string]any{"reason": errs[0].Message})
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	// Identities are hashed with the salt of the requested tenant until the
+	// grant settles which tenant the sign-in is for.
+	details := map[string]any{"username_hash": gatewayAuditLogger.HashTenantIdentity(requestedTenant, "username", strings.ToLower(username))}
+	if retryAfter, locked := cfg.lockout.locked(username); locked {
+		auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{"reason": "locked_out"}))
+		respondTooManyRequests(w, r, retryAfter)
+		return
+	}
+
+	dn, groups, err := cfg.authenticate(r, username, req.Password)
+	if err != nil {
+		if errors.Is(err, errLDAPInvalidCredentials) {
+			lockedNow := cfg.lockout.recordFailure(username)
+			auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{
+				"reason":     "invalid_credentials",
+				"locked_out": lockedNow,
+			}))
+			writeErrorResponse(w, r, http.StatusUnauthorized, "unauthorized", "invalid username or password", nil)
+			return
+		}
+		auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{
+			"reason": "directory_error",
+			"error":  err.Error(),
+		}))
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to contact directory", nil)
+		return
+	}
+	cfg.lockout.reset(username)
+	details["dn_hash"] = gatewayAuditLogger.HashTenantIdentity(requestedTenant, "dn", strings.ToLower(dn))
+
+	grants := cfg.resolveGrants(groups)
+	var grant *ldapGrant
+	switch {
+	case len(grants) == 0:
+		auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{"reason": "no_group_mapping"}))
+		writeErrorResponse(w, r, http.StatusForbidden, "forbidden", "account is not permitted to sign in", nil)
+		return
+	case requestedTenant != "":
+		grant = grants[requestedTenant]
+		if grant == nil {
+			auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, withTenantHash(mergeDetails(details, map[string]any{
+				"reason": "tenant_not_permitted",
+			}), hashTenantID(requestedTenant)))
+			writeErrorResponse(w, r, http.StatusForbidden, "forbidden", "account is not permitted to sign in to this tenant", nil)
+			return
+		}
+	case len(grants) == 1:
+		for _, only := range grants {
+			grant = only
+		}
+	default:
+		auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{"reason": "tenant_ambiguous"}))
+		writeValidationError(w, r, []validationError{{Field: "tenant_id", Message: "tenant_id is required for accounts with access to multiple tenants"}})
+		return
+	}
+	details = withTenantHash(details, hashTenantID(grant.TenantID))
+	details["username_hash"] = gatewayAuditLogger.HashTenantIdentity(grant.TenantID, "username", strings.ToLower(username))
+	details["dn_hash"] = gatewayAuditLogger.HashTenantIdentity(grant.TenantID, "dn", strings.ToLower(dn))
+
+	cookies, err := exchangeIdentityAssertion(r.Context(), identityAssertion{
+		Subject:      dn,
+		Method:       "ldap",
+		TenantID:     grant.TenantID,
+		Groups:       grant.Groups,
+		Capabilities: grant.Capabilities,
+	}, audit.RequestID(r.Context()), clockNow())
+	if err != nil {
+		var rejected *assertionExchangeError
+		switch {
+		case errors.Is(err, errIdentityAssertionNotConfigured):
+			auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{
+				"reason": "assertion_not_configured",
+			}))
+			writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "ldap authentication is not configured", nil)
+		case errors.As(err, &rejected):
+			auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{
+				"reason":      "upstream_error",
+				"status_code": rejected.StatusCode,
+				"error":       rejected.Detail,
+			}))
+			writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", rejected.SafeError, nil)
+		default:
+			auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{
+				"reason": "upstream_unreachable",
+				"error":  err.Error(),
+			}))
+			writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to contact orchestrator", nil)
+		}
+		return
+	}
+
+	normalized, _, dropped := normalizeUpstreamCookies(cookies)
+	if len(dropped) > 0 {
+		auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeSuccess, mergeDetails(details, map[string]any{
+			"action":  "upstream_cookie_rejected",
+			"cookies": dropped,
+		}))
+	}
+	for _, cookie := range normalized {
+		http.SetCookie(w, cookie)
+	}
+	auditLDAPEvent(r.Context(), r, trustedProxies, auditOutcomeSuccess, mergeDetails(details, map[string]any{
+		"capabilities": grant.Capabilities,
+	}))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(ldapLoginResponse{
+		Status:       "authenticated",
+		TenantID:     grant.TenantID,
+		Capabilities: grant.Capabilities,
+	})
+}
+
+type ldapLoginResponse struct {
+	Status       string   `json:"status"`
+	TenantID     string   `json:"tenant_id,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
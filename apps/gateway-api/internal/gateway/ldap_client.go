@@ -0,0 +1,482 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// This file implements the small subset of LDAPv3 (RFC 4511) the gateway
+// needs for direct-bind logins: StartTLS, simple bind, search and unbind.
+
+const (
+	berClassApplication = 0x40
+	berClassContext     = 0x80
+	berConstructed      = 0x20
+
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x10
+
+	ldapOpBindRequest      = 0
+	ldapOpBindResponse     = 1
+	ldapOpUnbindRequest    = 2
+	ldapOpSearchRequest    = 3
+	ldapOpSearchEntry      = 4
+	ldapOpSearchDone       = 5
+	ldapOpSearchReference  = 19
+	ldapOpExtendedRequest  = 23
+	ldapOpExtendedResponse = 24
+
+	ldapResultSuccess      = 0
+	ldapResultInvalidCreds = 49
+
+	ldapScopeBaseObject     = 0
+	ldapScopeWholeSubtree   = 2
+	ldapFilterEqualityMatch = 3
+	ldapFilterPresent       = 7
+
+	ldapStartTLSOID        = "1.3.6.1.4.1.1466.20037"
+	ldapDefaultPort        = "389"
+	ldapDefaultSecurePort  = "636"
+	ldapSearchSizeLimit    = 2
+	ldapMaxAttributeValues = 1024
+	maxLDAPMessageBytes    = 1 << 20
+)
+
+var errLDAPInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// ldapResultError is a non-success LDAPResult.
+type ldapResultError struct {
+	Code       int
+	Diagnostic string
+}
+
+func (e *ldapResultError) Error() string {
+	if e.Diagnostic == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Diagnostic)
+}
+
+type ldapEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+type ldapConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int64
+}
+
+// dialLDAP connects to an ldaps:// URL, or to an ldap:// URL followed by a
+// mandatory StartTLS. Credentials are never sent in clear text. The timeout
+// covers the whole session.
+func dialLDAP(ctx context.Context, rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*ldapConn, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url: %w", err)
+	}
+	host := parsed.Hostname()
+	if host == "" {
+		return nil, errors.New("ldap url host is required")
+	}
+	cfg := tlsConfig.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	dial := egressDialContext(egressLDAP, &net.Dialer{Timeout: timeout})
+	deadline := time.Now().Add(timeout)
+
+	var conn net.Conn
+	nextID := int64(1)
+	switch parsed.Scheme {
+	case "ldaps":
+		port := parsed.Port()
+		if port == "" {
+			port = ldapDefaultSecurePort
+		}
+		plain, err := dial(ctx, "tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			return nil, fmt.Errorf("ldap dial failed: %w", err)
+		}
+		_ = plain.SetDeadline(deadline)
+		secured := tls.Client(plain, cfg)
+		if err := secured.HandshakeContext(ctx); err != nil {
+			plain.Close()
+			return nil, fmt.Errorf("ldap dial failed: %w", err)
+		}
+		conn = secured
+	case "ldap":
+		port := parsed.Port()
+		if port == "" {
+			port = ldapDefaultPort
+		}
+		plain, err := dial(ctx, "tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			return nil, fmt.Errorf("ldap dial failed: %w", err)
+		}
+		_ = plain.SetDeadline(deadline)
+		starter := &ldapConn{conn: plain, reader: bufio.NewReader(plain), nextID: 1}
+		if err := starter.startTLS(); err != nil {
+			plain.Close()
+			return nil, err
+		}
+		secured := tls.Client(plain, cfg)
+		if err := secured.HandshakeContext(ctx); err != nil {
+			plain.Close()
+			return nil, fmt.Errorf("ldap starttls handshake failed: %w", err)
+		}
+		conn = secured
+		nextID = starter.nextID
+	default:
+		return nil, fmt.Errorf("unsupported ldap url scheme %q", parsed.Scheme)
+	}
+	return &ldapConn{conn: conn, reader: bufio.NewReader(conn), nextID: nextID}, nil
+}
+
+func (c *ldapConn) startTLS() error {
+	op := berEncode(berClassApplication|berConstructed, ldapOpExtendedRequest,
+		berEncode(berClassContext, 0, []byte(ldapStartTLSOID)))
+	id, err := c.send(op)
+	if err != nil {
+		return err
+	}
+	tag, content, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if tag != berClassApplication|berConstructed|ldapOpExtendedResponse {
+		return errors.New("ldap: unexpected starttls response")
+	}
+	if err := parseLDAPResult(content); err != nil {
+		return fmt.Errorf("ldap starttls refused: %w", err)
+	}
+	return nil
+}
+
+// bind performs a simple bind. Empty passwords are rejected because servers
+// treat them as an unauthenticated bind that always succeeds (RFC 4513 5.1.2).
+func (c *ldapConn) bind(dn, password string) error {
+	if password == "" {
+		return errLDAPInvalidCredentials
+	}
+	var body []byte
+	body = append(body, berEncodeInt(berTagInteger, 3)...)
+	body = append(body, berEncode(berTagOctetString, 0, []byte(dn))...)
+	body = append(body, berEncode(berClassContext, 0, []byte(password))...)
+	id, err := c.send(berEncode(berClassApplication|berConstructed, ldapOpBindRequest, body))
+	if err != nil {
+		return err
+	}
+	tag, content, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if tag != berClassApplication|berConstructed|ldapOpBindResponse {
+		return errors.New("ldap: unexpected bind response")
+	}
+	if err := parseLDAPResult(content); err != nil {
+		var result *ldapResultError
+		if errors.As(err, &result) && result.Code == ldapResultInvalidCreds {
+			return errLDAPInvalidCredentials
+		}
+		return err
+	}
+	return nil
+}
+
+// search runs a search with an equality filter (attr=value), or a presence
+// filter (objectClass=*) when attr is empty.
+func (c *ldapConn) search(baseDN string, scope int, attr, value string, attributes []string) ([]ldapEntry, error) {
+	var filter []byte
+	if attr == "" {
+		filter = berEncode(berClassContext, ldapFilterPresent, []byte("objectClass"))
+	} else {
+		filter = berEncode(berClassContext|berConstructed, ldapFilterEqualityMatch, append(
+			berEncode(berTagOctetString, 0, []byte(attr)),
+			berEncode(berTagOctetString, 0, []byte(value))...,
+		))
+	}
+	var attrList []byte
+	for _, name := range attributes {
+		attrList = append(attrList, berEncode(berTagOctetString, 0, []byte(name))...)
+	}
+
+	var body []byte
+	body = append(body, berEncode(berTagOctetString, 0, []byte(baseDN))...)
+	body = append(body, berEncodeInt(berTagEnumerated, int64(scope))...)
+	body = append(body, berEncodeInt(berTagEnumerated, 0)...) // neverDerefAliases
+	body = append(body, berEncodeInt(berTagInteger, ldapSearchSizeLimit)...)
+	body = append(body, berEncodeInt(berTagInteger, 0)...)
+	body = append(body, berEncode(berTagBoolean, 0, []byte{0})...)
+	body = append(body, filter...)
+	body = append(body, berEncode(berConstructed|berTagSequence, 0, attrList)...)
+	id, err := c.send(berEncode(berClassApplication|berConstructed, ldapOpSearchRequest, body))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for {
+		tag, content, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case berClassApplication | berConstructed | ldapOpSearchEntry:
+			entry, err := parseLDAPEntry(content)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case berClassApplication | berConstructed | ldapOpSearchReference:
+			// Referrals to other servers are not followed.
+		case berClassApplication | berConstructed | ldapOpSearchDone:
+			if err := parseLDAPResult(content); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, errors.New("ldap: unexpected search response")
+		}
+	}
+}
+
+// close sends an unbind and closes the connection.
+func (c *ldapConn) close() {
+	_, _ = c.send(berEncode(berClassApplication, ldapOpUnbindRequest, nil))
+	_ = c.conn.Close()
+}
+
+func (c *ldapConn) send(op []byte) (int64, error) {
+	id := c.nextID
+	c.nextID++
+	msg := berEncode(berConstructed|berTagSequence, 0, append(berEncodeInt(berTagInteger, id), op...))
+	if _, err := c.conn.Write(msg); err != nil {
+		return 0, fmt.Errorf("ldap write failed: %w", err)
+	}
+	return id, nil
+}
+
+// receive reads the next message for id and returns its protocol op tag and
+// contents.
+func (c *ldapConn) receive(id int64) (byte, []byte, error) {
+	for {
+		tag, content, err := readBERMessage(c.reader, maxLDAPMessageBytes)
+		if err != nil {
+			return 0, nil, fmt.Errorf("ldap read failed: %w", err)
+		}
+		if tag != berConstructed|berTagSequence {
+			return 0, nil, errors.New("ldap: malformed message")
+		}
+		idTag, idBytes, rest, err := parseBER(content)
+		if err != nil || idTag != berTagInteger {
+			return 0, nil, errors.New("ldap: malformed message id")
+		}
+		opTag, opContent, _, err := parseBER(rest)
+		if err != nil {
+			return 0, nil, errors.New("ldap: malformed protocol op")
+		}
+		msgID := berDecodeInt(idBytes)
+		if msgID == 0 && opTag == berClassApplication|berConstructed|ldapOpExtendedResponse {
+			// Unsolicited notification, normally a notice of disconnection.
+			return 0, nil, errors.New("ldap: server closed the connection")
+		}
+		if msgID != id {
+			continue
+		}
+		return opTag, opContent, nil
+	}
+}
+
+func parseLDAPResult(content []byte) error {
+	tag, code, rest, err := parseBER(content)
+	if err != nil || tag != berTagEnumerated {
+		return errors.New("ldap: malformed result")
+	}
+	_, _, rest, err = parseBER(rest) // matchedDN
+	if err != nil {
+		return errors.New("ldap: malformed result")
+	}
+	_, diagnostic, _, err := parseBER(rest)
+	if err != nil {
+		return errors.New("ldap: malformed result")
+	}
+	if resultCode := int(berDecodeInt(code)); resultCode != ldapResultSuccess {
+		return &ldapResultError{Code: resultCode, Diagnostic: string(diagnostic)}
+	}
+	return nil
+}
+
+func parseLDAPEntry(content []byte) (ldapEntry, error) {
+	_, dn, rest, err := parseBER(content)
+	if err != nil {
+		return ldapEntry{}, errors.New("ldap: malformed search entry")
+	}
+	_, attrs, _, err := parseBER(rest)
+	if err != nil {
+		return ldapEntry{}, errors.New("ldap: malformed search entry")
+	}
+	entry := ldapEntry{DN: string(dn), Attributes: make(map[string][]string)}
+	for len(attrs) > 0 {
+		var attr []byte
+		_, attr, attrs, err = parseBER(attrs)
+		if err != nil {
+			return ldapEntry{}, errors.New("ldap: malformed attribute")
+		}
+		_, name, valueSet, err := parseBER(attr)
+		if err != nil {
+			return ldapEntry{}, errors.New("ldap: malformed attribute")
+		}
+		_, values, _, err := parseBER(valueSet)
+		if err != nil {
+			return ldapEntry{}, errors.New("ldap: malformed attribute values")
+		}
+		key := strings.ToLower(string(name))
+		for len(values) > 0 && len(entry.Attributes[key]) < ldapMaxAttributeValues {
+			var value []byte
+			_, value, values, err = parseBER(values)
+			if err != nil {
+				return ldapEntry{}, errors.New("ldap: malformed attribute value")
+			}
+			entry.Attributes[key] = append(entry.Attributes[key], string(value))
+		}
+	}
+	return entry, nil
+}
+
+// berEncode encodes a single-byte-tag TLV. class carries the class and
+// constructed bits; for universal types it may also carry the tag itself.
+func berEncode(class byte, tag byte, content []byte) []byte {
+	out := []byte{class | tag}
+	out = appendBERLength(out, len(content))
+	return append(out, content...)
+}
+
+func berEncodeInt(tag byte, value int64) []byte {
+	var content []byte
+	for {
+		content = append([]byte{byte(value)}, content...)
+		value >>= 8
+		if (value == 0 && content[0]&0x80 == 0) || (value == -1 && content[0]&0x80 != 0) {
+			break
+		}
+	}
+	return berEncode(tag, 0, content)
+}
+
+func appendBERLength(out []byte, length int) []byte {
+	if length < 0x80 {
+		return append(out, byte(length))
+	}
+	var digits []byte
+	for n := length; n > 0; n >>= 8 {
+		digits = append([]byte{byte(n)}, digits...)
+	}
+	out = append(out, 0x80|byte(len(digits)))
+	return append(out, digits...)
+}
+
+func berDecodeInt(content []byte) int64 {
+	var value int64
+	for i, b := range content {
+		if i == 0 && b&0x80 != 0 {
+			value = -1
+		}
+		value = value<<8 | int64(b)
+	}
+	return value
+}
+
+// parseBER splits the first TLV from data. Unlike encoding/asn1 it accepts
+// the non-minimal long-form lengths some directory servers send.
+func parseBER(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+	tag := data[0]
+	if tag&0x1f == 0x1f {
+		return 0, nil, nil, errors.New("ber: multi-byte tags are not supported")
+	}
+	length, header, err := parseBERLength(data[1:])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	start := 1 + header
+	if length > len(data)-start {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+	return tag, data[start : start+length], data[start+length:], nil
+}
+
+func parseBERLength(data []byte) (int, int, error) {
+	if len(data) == 0 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	first := data[0]
+	if first < 0x80 {
+		return int(first), 1, nil
+	}
+	count := int(first & 0x7f)
+	if count == 0 || count > 4 {
+		return 0, 0, errors.New("ber: unsupported length encoding")
+	}
+	if len(data) < 1+count {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	length := 0
+	for _, b := range data[1 : 1+count] {
+		length = length<<8 | int(b)
+	}
+	if length < 0 {
+		return 0, 0, errors.New("ber: invalid length")
+	}
+	return length, 1 + count, nil
+}
+
+// readBERMessage reads one complete TLV from r.
+func readBERMessage(r *bufio.Reader, limit int) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	header := []byte{first}
+	if first >= 0x80 {
+		count := int(first & 0x7f)
+		if count == 0 || count > 4 {
+			return 0, nil, errors.New("ber: unsupported length encoding")
+		}
+		extra := make([]byte, count)
+		if _, err := io.ReadFull(r, extra); err != nil {
+			return 0, nil, err
+		}
+		header = append(header, extra...)
+	}
+	length, _, err := parseBERLength(header)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > limit {
+		return 0, nil, fmt.Errorf("ber: message of %d bytes exceeds limit", length)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return tag, content, nil
+}
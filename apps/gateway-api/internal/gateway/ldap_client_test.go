@@ -0,0 +1,248 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeLDAPUser struct {
+	dn       string
+	uid      string
+	password string
+	groups   []string
+}
+
+// fakeLDAPServer speaks just enough LDAP for the client: StartTLS, simple
+// bind, search and unbind.
+type fakeLDAPServer struct {
+	users    []fakeLDAPUser
+	startTLS bool
+	tls      *tls.Config
+	binds    atomic.Int32
+}
+
+// startFakeLDAPServer serves ldaps:// (or ldap:// with StartTLS) on a loopback
+// port and returns the URL and a client TLS config trusting its certificate.
+func startFakeLDAPServer(t *testing.T, startTLS bool, users ...fakeLDAPUser) (*fakeLDAPServer, string, *tls.Config) {
+	t.Helper()
+	certSource := httptest.NewTLSServer(nil)
+	serverTLS := &tls.Config{Certificates: certSource.TLS.Certificates}
+	pool := x509.NewCertPool()
+	pool.AddCert(certSource.Certificate())
+	certSource.Close()
+
+	server := &fakeLDAPServer{users: users, startTLS: startTLS, tls: serverTLS}
+	var listener net.Listener
+	var err error
+	scheme := "ldaps"
+	if startTLS {
+		scheme = "ldap"
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	} else {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	}
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server, scheme + "://" + listener.Addr().String(), &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+}
+
+func (s *fakeLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	secured := !s.startTLS
+	for {
+		_, content, err := readBERMessage(reader, maxLDAPMessageBytes)
+		if err != nil {
+			return
+		}
+		_, idBytes, rest, _ := parseBER(content)
+		id := berDecodeInt(idBytes)
+		opTag, op, _, _ := parseBER(rest)
+		switch opTag &^ (berClassApplication | berConstructed) {
+		case ldapOpExtendedRequest:
+			s.reply(conn, id, ldapOpExtendedResponse, fakeLDAPResult(ldapResultSuccess))
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			reader = bufio.NewReader(conn)
+			secured = true
+		case ldapOpBindRequest:
+			if !secured {
+				s.reply(conn, id, ldapOpBindResponse, fakeLDAPResult(13)) // confidentialityRequired
+				continue
+			}
+			s.binds.Add(1)
+			_, _, rest, _ := parseBER(op)
+			_, dn, rest, _ := parseBER(rest)
+			_, password, _, _ := parseBER(rest)
+			code := ldapResultInvalidCreds
+			if user, ok := s.findByDN(string(dn)); ok && user.password == string(password) {
+				code = ldapResultSuccess
+			}
+			s.reply(conn, id, ldapOpBindResponse, fakeLDAPResult(code))
+		case ldapOpSearchRequest:
+			_, base, rest, _ := parseBER(op)
+			_, scope, rest, _ := parseBER(rest)
+			for i := 0; i < 4; i++ {
+				_, _, rest, _ = parseBER(rest)
+			}
+			filterTag, filter, _, _ := parseBER(rest)
+			var matches []fakeLDAPUser
+			if berDecodeInt(scope) == ldapScopeBaseObject {
+				if user, ok := s.findByDN(string(base)); ok {
+					matches = append(matches, user)
+				}
+			} else if filterTag == berClassContext|berConstructed|ldapFilterEqualityMatch {
+				_, _, valueRest, _ := parseBER(filter)
+				_, value, _, _ := parseBER(valueRest)
+				for _, user := range s.users {
+					if user.uid == string(value) {
+						matches = append(matches, user)
+					}
+				}
+			}
+			for _, user := range matches {
+				s.reply(conn, id, ldapOpSearchEntry, fakeLDAPEntry(user))
+			}
+			s.reply(conn, id, ldapOpSearchDone, fakeLDAPResult(ldapResultSuccess))
+		case ldapOpUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *fakeLDAPServer) findByDN(dn string) (fakeLDAPUser, bool) {
+	for _, user := range s.users {
+		if strings.EqualFold(user.dn, dn) {
+			return user, true
+		}
+	}
+	return fakeLDAPUser{}, false
+}
+
+func (s *fakeLDAPServer) reply(conn net.Conn, id int64, op byte, body []byte) {
+	msg := append(berEncodeInt(berTagInteger, id), berEncode(berClassApplication|berConstructed, op, body)...)
+	_, _ = conn.Write(berEncode(berConstructed|berTagSequence, 0, msg))
+}
+
+func fakeLDAPResult(code int) []byte {
+	body := berEncodeInt(berTagEnumerated, int64(code))
+	body = append(body, berEncode(berTagOctetString, 0, nil)...)
+	return append(body, berEncode(berTagOctetString, 0, nil)...)
+}
+
+func fakeLDAPEntry(user fakeLDAPUser) []byte {
+	var values []byte
+	for _, group := range user.groups {
+		values = append(values, berEncode(berTagOctetString, 0, []byte(group))...)
+	}
+	attr := append(berEncode(berTagOctetString, 0, []byte("memberOf")), berEncode(berConstructed|0x11, 0, values)...)
+	attrs := berEncode(berConstructed|berTagSequence, 0, berEncode(berConstructed|berTagSequence, 0, attr))
+	return append(berEncode(berTagOctetString, 0, []byte(user.dn)), attrs...)
+}
+
+func TestBERIntegerRoundTrip(t *testing.T) {
+	for _, value := range []int64{0, 1, 127, 128, 255, 256, 65535, -1, -129} {
+		tag, content, rest, err := parseBER(berEncodeInt(berTagInteger, value))
+		if err != nil || tag != berTagInteger || len(rest) != 0 {
+			t.Fatalf("failed to parse encoded %d: %v", value, err)
+		}
+		if got := berDecodeInt(content); got != value {
+			t.Errorf("expected %d, got %d", value, got)
+		}
+	}
+}
+
+func TestParseBERAcceptsNonMinimalLength(t *testing.T) {
+	// Active Directory encodes short lengths in long form (0x84 00 00 00 03).
+	data := []byte{berTagOctetString, 0x84, 0, 0, 0, 3, 'a', 'b', 'c', 0xff}
+	tag, content, rest, err := parseBER(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tag != berTagOctetString || string(content) != "abc" || len(rest) != 1 {
+		t.Fatalf("unexpected parse result %x %q %x", tag, content, rest)
+	}
+	if _, _, _, err := parseBER([]byte{berTagOctetString, 0x82, 0x01}); err == nil {
+		t.Fatal("expected truncated length to be rejected")
+	}
+}
+
+func TestLDAPClientBindAndSearch(t *testing.T) {
+	for _, startTLS := range []bool{false, true} {
+		server, rawURL, tlsConfig := startFakeLDAPServer(t, startTLS, fakeLDAPUser{
+			dn:       "uid=alice,ou=people,dc=example,dc=com",
+			uid:      "alice",
+			password: "s3cret",
+			groups:   []string{"cn=engineers,ou=groups,dc=example,dc=com"},
+		})
+		conn, err := dialLDAP(context.Background(), rawURL, tlsConfig, time.Second)
+		if err != nil {
+			t.Fatalf("dial (starttls=%v) failed: %v", startTLS, err)
+		}
+		if err := conn.bind("uid=alice,ou=people,dc=example,dc=com", "wrong"); !errors.Is(err, errLDAPInvalidCredentials) {
+			t.Fatalf("expected invalid credentials, got %v", err)
+		}
+		if err := conn.bind("uid=alice,ou=people,dc=example,dc=com", "s3cret"); err != nil {
+			t.Fatalf("bind (starttls=%v) failed: %v", startTLS, err)
+		}
+		entries, err := conn.search("dc=example,dc=com", ldapScopeWholeSubtree, "uid", "alice", []string{"memberOf"})
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Attributes["memberof"][0] != "cn=engineers,ou=groups,dc=example,dc=com" {
+			t.Fatalf("unexpected entries %+v", entries)
+		}
+		conn.close()
+		if server.binds.Load() != 2 {
+			t.Fatalf("expected 2 binds, got %d", server.binds.Load())
+		}
+	}
+}
+
+func TestLDAPClientRejectsEmptyPasswordWithoutContactingServer(t *testing.T) {
+	server, rawURL, tlsConfig := startFakeLDAPServer(t, false)
+	conn, err := dialLDAP(context.Background(), rawURL, tlsConfig, time.Second)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.close()
+	if err := conn.bind("uid=alice,dc=example,dc=com", ""); !errors.Is(err, errLDAPInvalidCredentials) {
+		t.Fatalf("expected empty password to be rejected, got %v", err)
+	}
+	if server.binds.Load() != 0 {
+		t.Fatal("expected no bind to reach the server")
+	}
+}
+
+func TestLDAPClientRequiresTrustedCertificate(t *testing.T) {
+	_, rawURL, _ := startFakeLDAPServer(t, false)
+	if _, err := dialLDAP(context.Background(), rawURL, &tls.Config{MinVersion: tls.VersionTLS12}, time.Second); err == nil {
+		t.Fatal("expected untrusted certificate to be rejected")
+	}
+	if _, err := dialLDAP(context.Background(), "http://127.0.0.1:1", &tls.Config{}, time.Second); err == nil {
+		t.Fatal("expected unsupported scheme to be rejected")
+	}
+}
@@ -0,0 +1,260 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupLDAP(t *testing.T, mappings string, users ...fakeLDAPUser) (*ldapConfig, *fakeLDAPServer) {
+	t.Helper()
+	server, rawURL, tlsConfig := startFakeLDAPServer(t, false, users...)
+	t.Setenv("GATEWAY_LDAP_URL", rawURL)
+	t.Setenv("GATEWAY_LDAP_USER_DN_TEMPLATE", "uid={username},ou=people,dc=example,dc=com")
+	t.Setenv("GATEWAY_LDAP_GROUP_MAPPINGS", mappings)
+	t.Setenv("GATEWAY_LDAP_LOCKOUT_THRESHOLD", "3")
+	t.Setenv("GATEWAY_IDENTITY_ASSERTION_KEY", testAssertionKey)
+	setupTestCookies(t)
+
+	cfg, err := loadLDAPConfig()
+	if err != nil {
+		t.Fatalf("unexpected config error: %v", err)
+	}
+	if cfg == nil {
+		t.Fatal("expected ldap login to be enabled")
+	}
+	cfg.tlsConfig = tlsConfig
+	return cfg, server
+}
+
+func captureAssertion(t *testing.T) *identityAssertion {
+	t.Helper()
+	var claims identityAssertion
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			var body struct {
+				Assertion string `json:"assertion"`
+			}
+			raw, _ := io.ReadAll(req.Body)
+			_ = json.Unmarshal(raw, &body)
+			if err := verifyGatewayToken([]byte(testAssertionKey), gatewayTokenIdentityAssertion, body.Assertion, &claims); err != nil {
+				t.Errorf("expected signed assertion: %v", err)
+			}
+			header := make(http.Header)
+			header.Add("Set-Cookie", "oss_session=session-1; Path=/; HttpOnly; Secure")
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"ok":true}`)), Header: header}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+	return &claims
+}
+
+func ldapLogin(cfg *ldapConfig, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/auth/ldap/login", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	ldapLoginHandler(rec, req, nil, cfg)
+	return rec
+}
+
+var ldapTestUser = fakeLDAPUser{
+	dn:       "uid=alice,ou=people,dc=example,dc=com",
+	uid:      "alice",
+	password: "s3cret",
+	groups: []string{
+		"cn=engineers,ou=groups,dc=example,dc=com",
+		"cn=admins,ou=groups,dc=example,dc=com",
+	},
+}
+
+const ldapTestMappings = `[
+	{"group": "CN=Engineers,OU=Groups,DC=example,DC=com", "tenant_id": "acme", "capabilities": ["repo.read"]},
+	{"group": "cn=admins,ou=groups,dc=example,dc=com", "tenant_id": "acme", "capabilities": ["repo.write", "repo.read"]},
+	{"group": "cn=contractors,ou=groups,dc=example,dc=com", "tenant_id": "globex"}
+]`
+
+func TestLoadLDAPConfig(t *testing.T) {
+	t.Setenv("GATEWAY_LDAP_URL", "")
+	if cfg, err := loadLDAPConfig(); cfg != nil || err != nil {
+		t.Fatalf("expected ldap login to be disabled by default, got %v, %v", cfg, err)
+	}
+
+	t.Setenv("GATEWAY_IDENTITY_ASSERTION_KEY", testAssertionKey)
+	t.Setenv("GATEWAY_LDAP_URL", "http://directory.example.com")
+	if _, err := loadLDAPConfig(); err == nil {
+		t.Fatal("expected non-ldap scheme to be rejected")
+	}
+
+	t.Setenv("GATEWAY_LDAP_URL", "ldaps://directory.example.com")
+	t.Setenv("GATEWAY_LDAP_USER_DN_TEMPLATE", "uid=alice,dc=example,dc=com")
+	if _, err := loadLDAPConfig(); err == nil {
+		t.Fatal("expected template without {username} to be rejected")
+	}
+
+	t.Setenv("GATEWAY_LDAP_USER_DN_TEMPLATE", "")
+	t.Setenv("GATEWAY_LDAP_GROUP_MAPPINGS", `[{"group": "cn=x", "tenant_id": "bad tenant"}]`)
+	if _, err := loadLDAPConfig(); err == nil {
+		t.Fatal("expected invalid mapping tenant to be rejected")
+	}
+
+	t.Setenv("GATEWAY_LDAP_GROUP_MAPPINGS", "")
+	t.Setenv("GATEWAY_IDENTITY_ASSERTION_KEY", "")
+	if _, err := loadLDAPConfig(); err == nil {
+		t.Fatal("expected missing assertion key to be rejected")
+	}
+}
+
+func TestLDAPLoginMintsSessionWithMappedCapabilities(t *testing.T) {
+	cfg, _ := setupLDAP(t, ldapTestMappings, ldapTestUser)
+	claims := captureAssertion(t)
+
+	rec := ldapLogin(cfg, `{"username":"alice","password":"s3cret"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if findCookie(rec.Result().Cookies(), "oss_session") == nil {
+		t.Fatal("expected orchestrator session cookie to be forwarded")
+	}
+	var resp ldapLoginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.TenantID != "acme" || strings.Join(resp.Capabilities, ",") != "repo.read,repo.write" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if claims.Subject != ldapTestUser.dn || claims.Method != "ldap" || claims.TenantID != "acme" || len(claims.Groups) != 2 {
+		t.Fatalf("unexpected assertion claims %+v", claims)
+	}
+}
+
+func TestLDAPLoginLocksOutAfterRepeatedFailures(t *testing.T) {
+	cfg, server := setupLDAP(t, "", ldapTestUser)
+	captureAssertion(t)
+
+	for i := 0; i < 3; i++ {
+		if rec := ldapLogin(cfg, `{"username":"alice","password":"wrong"}`); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i, rec.Code)
+		}
+	}
+	rec := ldapLogin(cfg, `{"username":"ALICE","password":"s3cret"}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected locked out account to get 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After header")
+	}
+	if server.binds.Load() != 3 {
+		t.Fatalf("expected locked out attempt not to reach the directory, got %d binds", server.binds.Load())
+	}
+
+	cfg.lockout.now = func() time.Time { return time.Now().Add(defaultLDAPLockoutDuration + time.Second) }
+	if rec := ldapLogin(cfg, `{"username":"alice","password":"s3cret"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected login after lockout expiry, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLDAPLockoutStaysBounded(t *testing.T) {
+	lockout := newLDAPLockout(2, time.Minute, time.Hour)
+	now := time.Now()
+	lockout.now = func() time.Time { return now }
+	lockout.recordFailure("alice")
+	if !lockout.recordFailure("alice") {
+		t.Fatal("expected alice to be locked out")
+	}
+	for i := 0; i < maxLDAPLockoutEntries+10; i++ {
+		now = now.Add(time.Millisecond)
+		lockout.recordFailure(fmt.Sprintf("user-%d", i))
+	}
+	if len(lockout.entries) != maxLDAPLockoutEntries {
+		t.Fatalf("expected %d entries, got %d", maxLDAPLockoutEntries, len(lockout.entries))
+	}
+	if _, locked := lockout.locked("alice"); !locked {
+		t.Fatal("expected eviction to keep the locked account")
+	}
+	if _, ok := lockout.entries["user-0"]; ok {
+		t.Fatal("expected the oldest unlocked entry to be evicted")
+	}
+}
+
+func TestLDAPLoginTenantSelection(t *testing.T) {
+	contractor := ldapTestUser
+	contractor.groups = append(contractor.groups, "cn=contractors,ou=groups,dc=example,dc=com")
+	cfg, _ := setupLDAP(t, ldapTestMappings, contractor)
+	claims := captureAssertion(t)
+
+	if rec := ldapLogin(cfg, `{"username":"alice","password":"s3cret"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected ambiguous tenant to be rejected, got %d", rec.Code)
+	}
+	if rec := ldapLogin(cfg, `{"username":"alice","password":"s3cret","tenant_id":"initech"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected unmapped tenant to be forbidden, got %d", rec.Code)
+	}
+	if rec := ldapLogin(cfg, `{"username":"alice","password":"s3cret","tenant_id":"globex"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected explicit tenant to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if claims.TenantID != "globex" || len(claims.Capabilities) != 0 {
+		t.Fatalf("unexpected assertion claims %+v", claims)
+	}
+}
+
+func TestLDAPLoginAuditUsesTenantSalt(t *testing.T) {
+	t.Setenv("GATEWAY_AUDIT_TENANT_SALTS", `{"acme":"acme-salt"}`)
+	logs := captureAuditLogs(t)
+	cfg, _ := setupLDAP(t, ldapTestMappings, ldapTestUser)
+	captureAssertion(t)
+
+	if rec := ldapLogin(cfg, `{"username":"alice","password":"s3cret"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	salted := gatewayAuditLogger.HashTenantIdentity("acme", "username", "alice")
+	if salted == gatewayAuditLogger.HashIdentity("username", "alice") || !strings.Contains(logs.String(), `"username_hash":"`+salted+`"`) {
+		t.Fatalf("expected the username hash to use the tenant salt, got %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), `"dn_hash":"`+gatewayAuditLogger.HashTenantIdentity("acme", "dn", ldapTestUser.dn)+`"`) {
+		t.Fatalf("expected the DN hash to use the tenant salt, got %s", logs.String())
+	}
+}
+
+func TestLDAPLoginRejectsUnmappedAccounts(t *testing.T) {
+	outsider := ldapTestUser
+	outsider.groups = []string{"cn=sales,ou=groups,dc=example,dc=com"}
+	cfg, _ := setupLDAP(t, ldapTestMappings, outsider)
+
+	if rec := ldapLogin(cfg, `{"username":"alice","password":"s3cret"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected account without mapped groups to be forbidden, got %d", rec.Code)
+	}
+}
+
+func TestLDAPLoginValidatesInput(t *testing.T) {
+	cfg, server := setupLDAP(t, "", ldapTestUser)
+
+	for _, body := range []string{
+		`not json`,
+		`{"username":"alice,ou=admins","password":"s3cret"}`,
+		`{"username":"alice","password":""}`,
+		`{"username":"alice","password":"s3cret","extra":true}`,
+	} {
+		if rec := ldapLogin(cfg, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got %d", body, rec.Code)
+		}
+	}
+	if server.binds.Load() != 0 {
+		t.Fatal("expected invalid requests not to reach the directory")
+	}
+}
+
+func TestLDAPLoginRouteDisabledByDefault(t *testing.T) {
+	t.Setenv("GATEWAY_LDAP_URL", "")
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, AuthRouteConfig{})
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/ldap/login", strings.NewReader(`{}`))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 when ldap login is disabled, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,28 @@
+package gateway
+
+import "hash/maphash"
+
+const (
+	defaultLimiterShards = 32
+	maxLimiterShards     = 1024
+)
+
+// limiterShardSeed is shared by every limiter so a key always maps to the
+// same shard within the process.
+var limiterShardSeed = maphash.MakeSeed()
+
+// limiterShardCount returns GATEWAY_LIMITER_SHARDS, the number of
+// independently locked shards the rate and connection limiters split their
+// keys across.
+func limiterShardCount() int {
+	shards := GetIntEnv("GATEWAY_LIMITER_SHARDS", defaultLimiterShards)
+	if shards <= 0 {
+		return defaultLimiterShards
+	}
+	return min(shards, maxLimiterShards)
+}
+
+// limiterShard picks the shard of key among n.
+func limiterShard(key string, n int) int {
+	return int(maphash.String(limiterShardSeed, key) % uint64(n))
+}
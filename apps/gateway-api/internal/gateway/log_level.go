@@ -0,0 +1,382 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+// Components with their own log level. Records from the rest of the gateway
+// use the base level.
+const (
+	logComponentKey    = "component"
+	logComponentAuth   = "gateway.auth"
+	logComponentEvents = "gateway.events"
+	logComponentCollab = "gateway.collab"
+
+	logLevelSourceConfig   = "config"
+	logLevelSourceOverride = "override"
+
+	auditEventLogLevel  = "admin.log_level.update"
+	auditTargetLogLevel = "log_level"
+)
+
+var logComponents = []string{logComponentAuth, logComponentEvents, logComponentCollab}
+
+// Loggers for the components with their own level. They forward to the
+// process default logger, so they follow slog.SetDefault.
+var (
+	authLog   = slog.New(&componentHandler{component: logComponentAuth})
+	eventsLog = slog.New(&componentHandler{component: logComponentEvents})
+	collabLog = slog.New(&componentHandler{component: logComponentCollab})
+)
+
+// logLevelState is the effective verbosity reported by /admin/loglevel.
+type logLevelState struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+	Verbose    bool              `json:"verbose"`
+	Source     string            `json:"source"`
+}
+
+// logLevelSettings are the configured or overridden levels: a base level and
+// the components that differ from it.
+type logLevelSettings struct {
+	base       slog.Level
+	components map[string]slog.Level
+}
+
+// logLevelController holds the levels the gateway logs at. Levels come from
+// GATEWAY_LOG_LEVEL and GATEWAY_LOG_LEVELS, can be replaced at runtime
+// through the admin API, and SIGUSR1 toggles every component to debug. The
+// effective levels are kept in LevelVars so log calls never take the lock.
+type logLevelController struct {
+	mu       sync.Mutex
+	config   logLevelSettings
+	override *logLevelSettings
+	verbose  bool
+
+	base       slog.LevelVar
+	components map[string]*slog.LevelVar
+	// min is the lowest effective level, so disabled records are dropped
+	// before they are built.
+	min slog.LevelVar
+}
+
+func newLogLevelController(config logLevelSettings) *logLevelController {
+	c := &logLevelController{config: config, components: make(map[string]*slog.LevelVar, len(logComponents))}
+	for _, component := range logComponents {
+		c.components[component] = new(slog.LevelVar)
+	}
+	c.apply()
+	return c
+}
+
+// apply recomputes the effective levels. Callers hold c.mu, except
+// newLogLevelController.
+func (c *logLevelController) apply() {
+	settings := c.config
+	if c.override != nil {
+		settings = *c.override
+	}
+	base := settings.base
+	if c.verbose {
+		base = slog.LevelDebug
+	}
+	c.base.Set(base)
+	lowest := base
+	for component, level := range c.components {
+		effective, ok := settings.components[component]
+		if !ok || c.verbose {
+			effective = base
+		}
+		level.Set(effective)
+		lowest = min(lowest, effective)
+	}
+	c.min.Set(lowest)
+}
+
+// level returns the effective level of component, or the base level for
+// records without a known component.
+func (c *logLevelController) level(component string) slog.Level {
+	if level, ok := c.components[component]; ok {
+		return level.Level()
+	}
+	return c.base.Level()
+}
+
+func (c *logLevelController) state() logLevelState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := logLevelState{
+		Level:      c.base.Level().String(),
+		Components: make(map[string]string, len(c.components)),
+		Verbose:    c.verbose,
+		Source:     logLevelSourceConfig,
+	}
+	if c.override != nil {
+		state.Source = logLevelSourceOverride
+	}
+	for component, level := range c.components {
+		state.Components[component] = level.Level().String()
+	}
+	return state
+}
+
+func (c *logLevelController) setOverride(settings logLevelSettings) logLevelState {
+	c.mu.Lock()
+	c.override = &settings
+	c.apply()
+	c.mu.Unlock()
+	return c.state()
+}
+
+func (c *logLevelController) clearOverride() logLevelState {
+	c.mu.Lock()
+	c.override = nil
+	c.apply()
+	c.mu.Unlock()
+	return c.state()
+}
+
+func (c *logLevelController) toggleVerbose() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.verbose = !c.verbose
+	c.apply()
+	return c.verbose
+}
+
+var (
+	logLevelsOnce sync.Once
+	logLevels     atomic.Pointer[logLevelController]
+	logLevelsErr  error
+)
+
+// loadLogLevels returns the controller configured from the environment. An
+// invalid configuration logs at info until ValidateLogLevelConfig stops the
+// gateway.
+func loadLogLevels() *logLevelController {
+	logLevelsOnce.Do(func() {
+		config, err := logLevelSettingsFromEnv()
+		if err != nil {
+			logLevelsErr = err
+			config = logLevelSettings{base: slog.LevelInfo}
+		}
+		logLevels.Store(newLogLevelController(config))
+	})
+	return logLevels.Load()
+}
+
+// resetLogLevels clears the cached configuration for tests.
+func resetLogLevels() {
+	logLevelsOnce = sync.Once{}
+	logLevels.Store(nil)
+	logLevelsErr = nil
+}
+
+// ValidateLogLevelConfig reports an invalid GATEWAY_LOG_LEVEL or
+// GATEWAY_LOG_LEVELS.
+func ValidateLogLevelConfig() error {
+	loadLogLevels()
+	return logLevelsErr
+}
+
+// ToggleVerboseLogging switches every component to debug, or back to the
+// configured levels, and reports whether verbose logging is now on. The
+// gateway calls it on SIGUSR1.
+func ToggleVerboseLogging() bool {
+	return loadLogLevels().toggleVerbose()
+}
+
+func logLevelSettingsFromEnv() (logLevelSettings, error) {
+	settings := logLevelSettings{base: slog.LevelInfo}
+	if raw := strings.TrimSpace(GetEnv("GATEWAY_LOG_LEVEL", "")); raw != "" {
+		level, err := parseLogLevel(raw)
+		if err != nil {
+			return settings, fmt.Errorf("GATEWAY_LOG_LEVEL: %w", err)
+		}
+		settings.base = level
+	}
+	components, err := parseComponentLogLevels(GetEnv("GATEWAY_LOG_LEVELS", ""))
+	if err != nil {
+		return settings, fmt.Errorf("GATEWAY_LOG_LEVELS: %w", err)
+	}
+	settings.components = components
+	return settings, nil
+}
+
+// parseComponentLogLevels parses "gateway.auth=debug,gateway.events=warn".
+func parseComponentLogLevels(raw string) (map[string]slog.Level, error) {
+	levels := map[string]slog.Level{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		component, level, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("entry %q must be component=level", entry)
+		}
+		if err := setComponentLogLevel(levels, component, level); err != nil {
+			return nil, err
+		}
+	}
+	return levels, nil
+}
+
+func setComponentLogLevel(levels map[string]slog.Level, component, rawLevel string) error {
+	component = strings.TrimSpace(component)
+	if !slices.Contains(logComponents, component) {
+		return fmt.Errorf("unknown component %q (expected one of %s)", component, strings.Join(logComponents, ", "))
+	}
+	level, err := parseLogLevel(rawLevel)
+	if err != nil {
+		return fmt.Errorf("component %s: %w", component, err)
+	}
+	levels[component] = level
+	return nil
+}
+
+func parseLogLevel(raw string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(raw))); err != nil {
+		return 0, fmt.Errorf("invalid level %q (expected debug, info, warn or error)", raw)
+	}
+	return level, nil
+}
+
+// NewLogHandler wraps next, which should accept every level, in the gateway's
+// runtime log levels. Records carrying a component attribute are held to that
+// component's level; audit records are never dropped.
+func NewLogHandler(next slog.Handler) slog.Handler {
+	return &levelHandler{next: next}
+}
+
+type levelHandler struct {
+	next      slog.Handler
+	component string
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	// Audit records are logged at info and above whatever the levels are;
+	// Handle drops the other records below their component's level.
+	return (level >= slog.LevelInfo || level >= loadLogLevels().min.Level()) && h.next.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	if strings.HasPrefix(record.Message, audit.MessagePrefix) {
+		return h.next.Handle(ctx, record)
+	}
+	component := h.component
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == logComponentKey {
+			component = attr.Value.String()
+			return false
+		}
+		return true
+	})
+	if record.Level < loadLogLevels().level(component) {
+		return nil
+	}
+	return h.next.Handle(ctx, record)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := &levelHandler{next: h.next.WithAttrs(attrs), component: h.component}
+	for _, attr := range attrs {
+		if attr.Key == logComponentKey {
+			clone.component = attr.Value.String()
+		}
+	}
+	return clone
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{next: h.next.WithGroup(name), component: h.component}
+}
+
+// componentHandler tags records with a component and forwards them to the
+// default logger's handler as it is at the time of the call.
+type componentHandler struct {
+	component string
+	// with replays WithAttrs and WithGroup calls on the default handler.
+	with []func(slog.Handler) slog.Handler
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= loadLogLevels().level(h.component) && slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	next := slog.Default().Handler().WithAttrs([]slog.Attr{slog.String(logComponentKey, h.component)})
+	for _, with := range h.with {
+		next = with(next)
+	}
+	return next.Handle(ctx, record)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.extend(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.extend(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *componentHandler) extend(with func(slog.Handler) slog.Handler) slog.Handler {
+	return &componentHandler{component: h.component, with: append(slices.Clone(h.with), with)}
+}
+
+// logLevelRequest replaces the runtime levels until it is deleted again.
+// Components left out log at level.
+type logLevelRequest struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+}
+
+func (req logLevelRequest) settings() (logLevelSettings, error) {
+	settings := logLevelSettings{base: slog.LevelInfo, components: map[string]slog.Level{}}
+	if strings.TrimSpace(req.Level) != "" {
+		level, err := parseLogLevel(req.Level)
+		if err != nil {
+			return settings, err
+		}
+		settings.base = level
+	}
+	for _, component := range slices.Sorted(maps.Keys(req.Components)) {
+		if err := setComponentLogLevel(settings.components, component, req.Components[component]); err != nil {
+			return settings, err
+		}
+	}
+	return settings, nil
+}
+
+// recordLogLevelChange audits an admin change to the log levels.
+func recordLogLevelChange(ctx context.Context, r *http.Request, state logLevelState) {
+	components := make(map[string]any, len(state.Components))
+	for component, level := range state.Components {
+		components[component] = level
+	}
+	actor := hashedActorFromRequest(r, nil, "admin")
+	gatewayAuditLogger.Security(audit.WithActor(ctx, actor), audit.Event{
+		Name:    auditEventLogLevel,
+		Outcome: auditOutcomeSuccess,
+		Target:  auditTargetLogLevel,
+		ActorID: actor,
+		Details: auditDetails(map[string]any{
+			"level":      state.Level,
+			"components": components,
+			"source":     state.Source,
+			"method":     r.Method,
+		}),
+	})
+}
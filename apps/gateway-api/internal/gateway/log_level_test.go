@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+// useLogLevels configures the log levels from the given environment and
+// routes the default logger through the level handler into a buffer.
+func useLogLevels(t *testing.T, level, components string) *bytes.Buffer {
+	t.Helper()
+	t.Setenv("GATEWAY_LOG_LEVEL", level)
+	t.Setenv("GATEWAY_LOG_LEVELS", components)
+	resetLogLevels()
+	t.Cleanup(resetLogLevels)
+	logs := captureAuditLogs(t)
+	slog.SetDefault(slog.New(NewLogHandler(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	return logs
+}
+
+func TestLogLevelConfig(t *testing.T) {
+	for _, tc := range []struct {
+		level, components string
+		valid             bool
+	}{
+		{"", "", true},
+		{"warn", "gateway.auth=debug, gateway.collab=error", true},
+		{"verbose", "", false},
+		{"info", "gateway.auth", false},
+		{"info", "gateway.unknown=debug", false},
+		{"info", "gateway.events=loud", false},
+	} {
+		t.Setenv("GATEWAY_LOG_LEVEL", tc.level)
+		t.Setenv("GATEWAY_LOG_LEVELS", tc.components)
+		resetLogLevels()
+		if err := ValidateLogLevelConfig(); (err == nil) != tc.valid {
+			t.Fatalf("level %q components %q: unexpected error %v", tc.level, tc.components, err)
+		}
+	}
+	resetLogLevels()
+}
+
+func TestLogLevelsPerComponent(t *testing.T) {
+	logs := useLogLevels(t, "warn", "gateway.auth=debug")
+
+	authLog.Debug("auth detail")
+	collabLog.Info("collab detail")
+	collabLog.Warn("collab warning")
+	slog.Info("base detail")
+	gatewayAuditLogger.Info(context.Background(), audit.Event{Name: auditEventLogLevel, Outcome: auditOutcomeSuccess, Target: auditTargetLogLevel})
+
+	out := logs.String()
+	for _, want := range []string{"auth detail", `"component":"gateway.auth"`, "collab warning", "gateway.audit.info"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in logs, got %s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"collab detail", "base detail"} {
+		if strings.Contains(out, unwanted) {
+			t.Fatalf("expected %q to be filtered, got %s", unwanted, out)
+		}
+	}
+
+	if !ToggleVerboseLogging() {
+		t.Fatal("expected verbose logging to be on")
+	}
+	collabLog.Debug("verbose collab")
+	if ToggleVerboseLogging() {
+		t.Fatal("expected verbose logging to be off")
+	}
+	collabLog.Debug("quiet collab")
+	if out := logs.String(); !strings.Contains(out, "verbose collab") || strings.Contains(out, "quiet collab") {
+		t.Fatalf("unexpected logs after toggling %s", out)
+	}
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	logs := useLogLevels(t, "info", "")
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+	serve := func(method, body string) (*httptest.ResponseRecorder, logLevelState) {
+		req := httptest.NewRequest(method, "/admin/loglevel", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		var state logLevelState
+		_ = json.Unmarshal(rec.Body.Bytes(), &state)
+		return rec, state
+	}
+
+	rec, state := serve(http.MethodPut, `{"level":"warn","components":{"gateway.events":"debug"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if state.Level != "WARN" || state.Components[logComponentEvents] != "DEBUG" || state.Components[logComponentAuth] != "WARN" || state.Source != logLevelSourceOverride {
+		t.Fatalf("unexpected state %+v", state)
+	}
+	if !strings.Contains(logs.String(), auditEventLogLevel) {
+		t.Fatalf("expected an audit record, got %s", logs.String())
+	}
+	eventsLog.Debug("events detail")
+	if !strings.Contains(logs.String(), "events detail") {
+		t.Fatalf("expected the override to apply, got %s", logs.String())
+	}
+
+	for _, body := range []string{`{"level":"loud"}`, `{"components":{"gateway.other":"debug"}}`, `{"verbose":true}`} {
+		if rec, _ := serve(http.MethodPut, body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be rejected, got %d", body, rec.Code)
+		}
+	}
+
+	if _, state := serve(http.MethodDelete, ""); state.Level != "INFO" || state.Components[logComponentEvents] != "INFO" || state.Source != logLevelSourceConfig {
+		t.Fatalf("expected the configured levels back, got %+v", state)
+	}
+	if _, state := serve(http.MethodGet, ""); state.Source != logLevelSourceConfig {
+		t.Fatalf("unexpected state %+v", state)
+	}
+}
@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"go.uber.org/goleak"
+)
+
+// goleakOptions ignores the goroutines that live for the whole process: the
+// event bus subscribers registered by the package's wiring.
+var goleakOptions = []goleak.Option{
+	goleak.IgnoreTopFunction("github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/gateway.subscribe[...].func1"),
+}
+
+// TestMain checks every audit event the tests emit against the embedded audit
+// schema, so a renamed event or detail key fails here before it reaches a
+// SIEM parser, and fails the run if any test leaves goroutines behind, such
+// as a streaming handler's copier.
+func TestMain(m *testing.M) {
+	audit.EnableValidation()
+	code := m.Run()
+	if err := goleak.Find(goleakOptions...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	if violations := audit.Violations(); len(violations) > 0 {
+		for _, violation := range violations {
+			fmt.Fprintln(os.Stderr, "audit schema violation:", violation)
+		}
+		if code == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// captureAuditLogs routes the default logger, and with it the gateway audit
+// logger, into a buffer for the rest of the test.
+func captureAuditLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	original := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{})))
+	gatewayAuditLogger = audit.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(original)
+		gatewayAuditLogger = audit.Default()
+	})
+	return &buf
+}
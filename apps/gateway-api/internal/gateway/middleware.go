@@ -1,8 +1,18 @@
 package gateway
 
-import "net/http"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
 
-const defaultMaxBodyBytes = int64(10 << 20) // 10 MiB
+const (
+	defaultMaxBodyBytes = int64(10 << 20) // 10 MiB
+	defaultMaxURLBytes  = 8192
+)
 
 // DefaultMaxRequestBodyBytes returns the default request body size limit the
 // gateway should apply when no override is provided.
@@ -10,6 +20,12 @@ func DefaultMaxRequestBodyBytes() int64 {
 	return defaultMaxBodyBytes
 }
 
+// DefaultMaxURLBytes returns the default limit on the length of a request
+// target (path and query).
+func DefaultMaxURLBytes() int {
+	return defaultMaxURLBytes
+}
+
 // RequestBodyLimitMiddleware constrains the size of incoming request bodies by
 // wrapping the request body with http.MaxBytesReader. When the limit is zero or
 // negative the middleware simply forwards the request without modification.
@@ -67,3 +83,116 @@ func SecurityHeadersMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// routeResolver is implemented by *http.ServeMux.
+type routeResolver interface {
+	Handler(r *http.Request) (http.Handler, string)
+}
+
+// RequestCanonicalizationMiddleware rejects request targets that routing and
+// the handlers' prefix/suffix matching could read in more than one way: URLs
+// longer than maxURLBytes, empty or dot path segments, encoded slashes and
+// backslashes, and encoded control characters. A single trailing slash is
+// dropped so "/events/" and "/events" reach the same handler, unless next is
+// a ServeMux that only routes the slash form. Zero or negative maxURLBytes
+// disables the length check.
+func RequestCanonicalizationMiddleware(next http.Handler, maxURLBytes int) http.Handler {
+	resolver, _ := next.(routeResolver)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.RequestURI
+		if target == "" {
+			target = r.URL.RequestURI()
+		}
+		if maxURLBytes > 0 && len(target) > maxURLBytes {
+			writeErrorResponse(w, r, http.StatusRequestURITooLong, "uri_too_long", fmt.Sprintf("request URL exceeds %d bytes", maxURLBytes), nil)
+			return
+		}
+		if target == "*" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := checkCanonicalPath(r.URL.EscapedPath(), r.URL.Path); err != nil {
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+			return
+		}
+		if err := checkEncodedControlChars(r.URL.RawQuery, "query"); err != nil {
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+			return
+		}
+		if trimmed := trimTrailingSlash(r); trimmed != r {
+			if resolver == nil || routable(resolver, trimmed) || !routable(resolver, r) {
+				r = trimmed
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func checkCanonicalPath(escaped, decoded string) error {
+	if !strings.HasPrefix(decoded, "/") {
+		return errors.New("request path must be absolute")
+	}
+	if strings.Contains(decoded, "\\") {
+		return errors.New("request path must not contain backslashes")
+	}
+	if err := checkEncodedControlChars(escaped, "path"); err != nil {
+		return err
+	}
+	lower := strings.ToLower(escaped)
+	if strings.Contains(lower, "%2f") || strings.Contains(lower, "%5c") {
+		return errors.New("request path must not contain encoded slashes")
+	}
+	segments := strings.Split(decoded, "/")[1:]
+	for i, segment := range segments {
+		switch segment {
+		case "":
+			if i != len(segments)-1 {
+				return errors.New("request path must not contain empty segments")
+			}
+		case ".", "..":
+			return errors.New("request path must not contain dot segments")
+		}
+	}
+	return nil
+}
+
+// checkEncodedControlChars rejects percent-encoded bytes below 0x20 and 0x7f.
+func checkEncodedControlChars(escaped, component string) error {
+	for i := 0; i+2 < len(escaped); i++ {
+		if escaped[i] != '%' {
+			continue
+		}
+		value, err := strconv.ParseUint(escaped[i+1:i+3], 16, 8)
+		if err != nil {
+			continue
+		}
+		if value < 0x20 || value == 0x7f {
+			return fmt.Errorf("request %s must not contain encoded control characters", component)
+		}
+	}
+	return nil
+}
+
+// trimTrailingSlash returns a shallow copy of r without the path's trailing
+// slash, or r itself when there is none to drop.
+func trimTrailingSlash(r *http.Request) *http.Request {
+	if len(r.URL.Path) <= 1 || !strings.HasSuffix(r.URL.Path, "/") {
+		return r
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = strings.TrimSuffix(r.URL.Path, "/")
+	r2.URL.RawPath = strings.TrimSuffix(r.URL.RawPath, "/")
+	return r2
+}
+
+// routable reports whether the mux would serve r itself rather than answer
+// with a not-found or trailing-slash redirect.
+func routable(resolver routeResolver, r *http.Request) bool {
+	_, pattern := resolver.Handler(r)
+	// For a redirect, ServeMux reports the pattern matched after following
+	// it, which is the path with a slash appended.
+	return pattern != "" && !strings.HasSuffix(pattern, r.URL.Path+"/")
+}
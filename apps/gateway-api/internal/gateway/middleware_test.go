@@ -88,6 +88,63 @@ func TestSecurityHeadersMiddleware(t *testing.T) {
 	}
 }
 
+func TestRequestCanonicalizationMiddlewareRejectsAmbiguousTargets(t *testing.T) {
+	handler := RequestCanonicalizationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), 64)
+
+	cases := []struct {
+		target string
+		status int
+	}{
+		{"/auth/google/authorize", http.StatusNoContent},
+		{"/auth/google//authorize", http.StatusBadRequest},
+		{"//auth/google/authorize", http.StatusBadRequest},
+		{"/auth/google/../github/authorize", http.StatusBadRequest},
+		{"/auth/google/%2e%2e/authorize", http.StatusBadRequest},
+		{"/auth/google/./authorize", http.StatusBadRequest},
+		{"/auth/google%2Fauthorize", http.StatusBadRequest},
+		{"/auth/google%5cauthorize", http.StatusBadRequest},
+		{"/auth/google%00/authorize", http.StatusBadRequest},
+		{"/events?plan_id=plan-1%0d%0aX-Injected:1", http.StatusBadRequest},
+		{"/events?plan_id=plan%20one", http.StatusNoContent},
+		{"/events?plan_id=" + strings.Repeat("a", 64), http.StatusRequestURITooLong},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.target, nil))
+		if rr.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d", tc.target, tc.status, rr.Code)
+		}
+	}
+}
+
+func TestRequestCanonicalizationMiddlewareTrimsTrailingSlash(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "events "+r.URL.Path)
+	})
+	mux.HandleFunc("/auth/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "auth "+r.URL.Path)
+	})
+	handler := RequestCanonicalizationMiddleware(mux, DefaultMaxURLBytes())
+
+	cases := map[string]string{
+		"/events/":                "events /events",
+		"/auth/google/authorize/": "auth /auth/google/authorize",
+		// Only the slash form is routable, so it must not be trimmed into a
+		// redirect back to itself.
+		"/auth/": "auth /auth/",
+	}
+	for target, want := range cases {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != want {
+			t.Fatalf("%s: expected 200 %q, got %d %q", target, want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
 func TestGlobalRateLimiterEnforcesIPLimit(t *testing.T) {
 	t.Setenv("GATEWAY_HTTP_RATE_LIMIT_MAX", "1")
 	t.Setenv("GATEWAY_HTTP_RATE_LIMIT_WINDOW", "1m")
@@ -209,7 +266,7 @@ func TestGlobalRateLimiterSetsRequestIDWithoutAuditMiddleware(t *testing.T) {
 }
 
 func TestRateLimiterPrunesExpiredWindows(t *testing.T) {
-	limiter := newRateLimiter()
+	limiter := newRateLimiter("test")
 	base := time.Now()
 	limiter.now = func() time.Time { return base }
 
@@ -222,11 +279,11 @@ func TestRateLimiterPrunesExpiredWindows(t *testing.T) {
 	if !allowed {
 		t.Fatal("expected first identity to be allowed")
 	}
-	if len(limiter.windows) != 1 {
-		t.Fatalf("expected a single window, got %d", len(limiter.windows))
+	if limiter.windowCount() != 1 {
+		t.Fatalf("expected a single window, got %d", limiter.windowCount())
 	}
 
-	limiter.now = func() time.Time { return base.Add(time.Second + rateLimiterCleanupInterval) }
+	limiter.now = func() time.Time { return base.Add(2 * time.Second) }
 
 	allowed, _, err = limiter.Allow(context.Background(), bucket, "second")
 	if err != nil {
@@ -235,10 +292,16 @@ func TestRateLimiterPrunesExpiredWindows(t *testing.T) {
 	if !allowed {
 		t.Fatal("expected second identity to be allowed")
 	}
-	if _, ok := limiter.windows["test|ip|first"]; ok {
+	if limiter.windowCount() != 2 {
+		t.Fatalf("expected requests not to prune windows, got %d", limiter.windowCount())
+	}
+	if removed := limiter.cleanup(context.Background()); removed != 1 {
+		t.Fatalf("expected cleanup to remove one window, got %d", removed)
+	}
+	if hasRateLimitWindow(limiter, "test|ip|first") {
 		t.Fatal("expected expired window to be pruned")
 	}
-	if _, ok := limiter.windows["test|ip|second"]; !ok {
+	if !hasRateLimitWindow(limiter, "test|ip|second") {
 		t.Fatal("expected active window to remain after cleanup")
 	}
 }
@@ -295,3 +358,83 @@ func TestGlobalRateLimiterFailsClosedOnError(t *testing.T) {
 		t.Fatalf("expected audit log to include rate_limiter_error, got %q", logs)
 	}
 }
+
+func TestGlobalRateLimiterSetsRateLimitHeaders(t *testing.T) {
+	t.Setenv("GATEWAY_HTTP_RATE_LIMIT_MAX", "2")
+	t.Setenv("GATEWAY_HTTP_RATE_LIMIT_WINDOW", "1m")
+
+	limiter := NewGlobalRateLimiter(nil)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i, want := range []struct {
+		code      int
+		remaining string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "198.51.100.20:1000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want.code {
+			t.Fatalf("request %d: expected %d, got %d", i, want.code, rec.Code)
+		}
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Fatalf("request %d: expected limit 2, got %q", i, got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != want.remaining {
+			t.Fatalf("request %d: expected remaining %s, got %q", i, want.remaining, got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Reset"); got != "60" {
+			t.Fatalf("request %d: expected reset 60, got %q", i, got)
+		}
+	}
+}
+
+func TestRateLimitHeadersReportMostRestrictiveBucket(t *testing.T) {
+	limiter := newRateLimiter("test")
+	loose := rateLimitBucket{Endpoint: "auth_login", IdentityType: "ip", Window: time.Minute, Limit: 30}
+	tight := rateLimitBucket{Endpoint: "auth_login", IdentityType: "client", Window: 10 * time.Second, Limit: 3}
+	handler := withAuthRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, limiter, []rateLimitBucket{loose, tight}, nil, func(*http.Request) (string, bool) { return "app.example.com", true })
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/authorize", nil)
+	req.RemoteAddr = "198.51.100.30:1000"
+	rec := httptest.NewRecorder()
+	// An outer limiter with more headroom must not mask the tighter bucket.
+	rec.Header().Set("X-RateLimit-Limit", "120")
+	rec.Header().Set("X-RateLimit-Remaining", "119")
+	rec.Header().Set("X-RateLimit-Reset", "60")
+	handler(rec, req)
+
+	if got := rec.Header().Get("X-RateLimit-Limit"); got != "3" {
+		t.Fatalf("expected tightest bucket limit 3, got %q", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "2" {
+		t.Fatalf("expected remaining 2, got %q", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Reset"); got != "10" {
+		t.Fatalf("expected reset 10, got %q", got)
+	}
+}
+
+func TestRateLimiterStatusDoesNotConsume(t *testing.T) {
+	limiter := newRateLimiter("test")
+	bucket := rateLimitBucket{Endpoint: "test", IdentityType: "ip", Window: time.Minute, Limit: 1}
+
+	status, ok := limiter.Status(context.Background(), bucket, "client")
+	if !ok || status.Remaining != 1 || status.Limit != 1 {
+		t.Fatalf("unexpected status for fresh bucket: %+v", status)
+	}
+	if allowed, _, _ := limiter.Allow(context.Background(), bucket, "client"); !allowed {
+		t.Fatal("expected status query not to consume the bucket")
+	}
+	if _, ok := limiter.Status(context.Background(), rateLimitBucket{Endpoint: "off"}, "client"); ok {
+		t.Fatal("expected disabled bucket to report no status")
+	}
+}
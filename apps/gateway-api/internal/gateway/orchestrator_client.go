@@ -7,29 +7,24 @@ import (
 	"net/http"
 	"os"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 var (
-	orchestratorClientOnce    sync.Once
-	orchestratorClient        *http.Client
-	orchestratorClientErr     error
 	orchestratorClientFactory = buildOrchestratorClient
 	loadClientCertificate     = tls.LoadX509KeyPair
 )
 
 func getOrchestratorClient() (*http.Client, error) {
-	orchestratorClientOnce.Do(func() {
-		orchestratorClient, orchestratorClientErr = orchestratorClientFactory()
-	})
-	return orchestratorClient, orchestratorClientErr
+	client, _, err := currentOrchestrator()
+	return client, err
 }
 
 func buildOrchestratorClient() (*http.Client, error) {
-	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport := newUpstreamTransport(egressOrchestrator)
 	transport.ResponseHeaderTimeout = 30 * time.Second
 
 	if getBoolEnv("ORCHESTRATOR_TLS_ENABLED") {
@@ -82,9 +77,7 @@ func ResetOrchestratorClient() {
 }
 
 func resetOrchestratorClient() {
-	orchestratorClientOnce = sync.Once{}
-	orchestratorClient = nil
-	orchestratorClientErr = nil
+	orchestratorUpstream.reset()
 }
 
 func getBoolEnv(key string) bool {
@@ -101,19 +94,56 @@ func getBoolEnv(key string) bool {
 }
 
 type instrumentedTransport struct {
-	base *http.Transport
-	rt   http.RoundTripper
+	base     *http.Transport
+	rt       http.RoundTripper
+	requests atomic.Int64
+	failures atomic.Int64
+	inFlight atomic.Int64
+}
+
+// transportStats reports request counters for an instrumented transport.
+type transportStats struct {
+	Requests int64 `json:"requests"`
+	Failures int64 `json:"failures"`
+	InFlight int64 `json:"in_flight"`
 }
 
 func newInstrumentedTransport(base *http.Transport) http.RoundTripper {
 	return &instrumentedTransport{
 		base: base,
-		rt:   otelhttp.NewTransport(base),
+		rt:   observeUpstreamCalls(egressOrchestrator, otelhttp.NewTransport(base)),
 	}
 }
 
 func (i *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	return i.rt.RoundTrip(req)
+	// Every orchestrator request, proxied ones included, carries a token
+	// minted for it; the caller's request is left untouched.
+	req = req.Clone(req.Context())
+	if err := serviceTokens.sign(req); err != nil {
+		return nil, fmt.Errorf("sign orchestrator request: %w", err)
+	}
+	i.requests.Add(1)
+	i.inFlight.Add(1)
+	defer i.inFlight.Add(-1)
+	started := time.Now()
+	resp, err := i.rt.RoundTrip(req)
+	recordUpstreamTiming(req, resp, err, started)
+	if err != nil {
+		i.failures.Add(1)
+	}
+	return resp, err
+}
+
+func (i *instrumentedTransport) CloseIdleConnections() {
+	i.base.CloseIdleConnections()
+}
+
+func (i *instrumentedTransport) stats() transportStats {
+	return transportStats{
+		Requests: i.requests.Load(),
+		Failures: i.failures.Load(),
+		InFlight: i.inFlight.Load(),
+	}
 }
 
 func (i *instrumentedTransport) Base() *http.Transport {
@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// orchestratorSession is the subset of the orchestrator session payload the
+// gateway relies on.
+type orchestratorSession struct {
+	ID       string  `json:"id"`
+	TenantID *string `json:"tenantId"`
+	// BindingIDHash is the binding_id_hash of the login that created the
+	// session, stored by the orchestrator from the callback exchange.
+	BindingIDHash string `json:"bindingIdHash,omitempty"`
+	// ExpiresAt is the RFC 3339 time the session lapses, when the
+	// orchestrator reports one.
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+// expiry returns when the session lapses, if the orchestrator said so in a
+// form the gateway understands.
+func (s orchestratorSession) expiry() (time.Time, bool) {
+	if s.ExpiresAt == "" {
+		return time.Time{}, false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, s.ExpiresAt)
+	return expiresAt, err == nil
+}
+
+type sessionExpiryContextKey struct{}
+
+// withSessionExpiry carries the expiry of the session serving a request to
+// handlers that outlive the request, such as proxied WebSockets.
+func withSessionExpiry(ctx context.Context, session orchestratorSession) context.Context {
+	expiresAt, ok := session.expiry()
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionExpiryContextKey{}, expiresAt)
+}
+
+func sessionExpiry(ctx context.Context) (time.Time, bool) {
+	expiresAt, ok := ctx.Value(sessionExpiryContextKey{}).(time.Time)
+	return expiresAt, ok
+}
+
+// lookupOrchestratorSession resolves the session identified by the caller's
+// bearer token or cookies via the orchestrator's /auth/session endpoint.
+func lookupOrchestratorSession(ctx context.Context, authHeader, cookieHeader, requestID string) (orchestratorSession, int, error) {
+	client, orchestratorURL, err := currentOrchestrator()
+	if err != nil {
+		return orchestratorSession{}, http.StatusBadGateway, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, orchestratorAPI.URL(ctx, orchestratorURL, orchestratorAuthSession), nil)
+	if err != nil {
+		return orchestratorSession{}, http.StatusInternalServerError, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	if cookieHeader != "" {
+		req.Header.Set("Cookie", cookieHeader)
+	}
+	if requestID != "" {
+		req.Header.Set("X-Request-Id", requestID)
+		req.Header.Set("X-Trace-Id", requestID)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return orchestratorSession{}, http.StatusBadGateway, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return orchestratorSession{}, http.StatusUnauthorized, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return orchestratorSession{}, http.StatusBadGateway, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		Session orchestratorSession `json:"session"`
+	}
+	body, err := readUpstreamBody(resp.Body, maxUpstreamResponseBytes())
+	if err != nil {
+		return orchestratorSession{}, http.StatusBadGateway, err
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return orchestratorSession{}, http.StatusBadGateway, err
+	}
+	if payload.Session.ID == "" {
+		return orchestratorSession{}, http.StatusUnauthorized, errors.New("missing session id")
+	}
+	if !validBindingHash(payload.Session.BindingIDHash) {
+		payload.Session.BindingIDHash = ""
+	}
+	return payload.Session, http.StatusOK, nil
+}
+
+// revokeOrchestratorSession ends the session identified by the caller's
+// bearer token or cookies via the orchestrator's DELETE /auth/session and
+// returns the cookies it set to clear the session. A session the
+// orchestrator no longer knows counts as ended.
+func revokeOrchestratorSession(ctx context.Context, authHeader, cookieHeader, requestID string) ([]*http.Cookie, int, error) {
+	client, orchestratorURL, err := currentOrchestrator()
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, orchestratorAPI.URL(ctx, orchestratorURL, orchestratorAuthSession), nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	if cookieHeader != "" {
+		req.Header.Set("Cookie", cookieHeader)
+	}
+	if requestID != "" {
+		req.Header.Set("X-Request-Id", requestID)
+		req.Header.Set("X-Trace-Id", requestID)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300, resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusNotFound:
+		return resp.Cookies(), resp.StatusCode, nil
+	default:
+		return nil, resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,319 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const (
+	auditEventPlanArtifact  = "plan.artifact.download"
+	auditTargetPlanArtifact = "plan.artifacts"
+
+	usageMetricArtifactBytes = "artifact_bytes"
+
+	artifactCopyBufferBytes = 32 << 10
+	// minArtifactBurstBytes keeps the bucket at least one copy buffer deep so
+	// a single read never waits on more than one refill.
+	minArtifactBurstBytes = artifactCopyBufferBytes
+	// maxRangeHeaderLen bounds forwarded Range values; legitimate clients
+	// resume downloads with a single range.
+	maxRangeHeaderLen = 256
+	// maxBandwidthBuckets caps tracked tenants before idle buckets are pruned.
+	maxBandwidthBuckets = 10000
+)
+
+var artifactIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// forwardedArtifactResponseHeaders are relayed to the client; anything else
+// the orchestrator sets stays internal.
+var forwardedArtifactResponseHeaders = []string{
+	"Accept-Ranges",
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Length",
+	"Content-Range",
+	"Content-Type",
+	"ETag",
+	"Last-Modified",
+}
+
+// ArtifactRouteConfig captures configuration for the artifact download route.
+type ArtifactRouteConfig struct {
+	TrustedProxyCIDRs []string
+}
+
+type artifactHandler struct {
+	resolveUpstream func() (*http.Client, string, error)
+	trustedProxies  []*net.IPNet
+	bandwidth       *bandwidthLimiter
+}
+
+// RegisterArtifactRoutes wires GET /plan/{id}/artifacts/{artifactId}, which
+// streams plan artifacts from the orchestrator.
+func RegisterArtifactRoutes(mux *http.ServeMux, cfg ArtifactRouteConfig) {
+	trustedProxies, err := ParseTrustedProxyCIDRs(cfg.TrustedProxyCIDRs)
+	if err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("invalid trusted proxy configuration: %v", err))
+	}
+	rewriter, err := loadUpstreamURLRewriter(trustedProxies)
+	if err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("invalid response rewrite configuration: %v", err))
+	}
+	handler := &artifactHandler{
+		resolveUpstream: currentOrchestrator,
+		trustedProxies:  trustedProxies,
+		bandwidth:       loadArtifactBandwidthLimiter(),
+	}
+	// Artifact bodies are user content and may be requested in ranges, so
+	// only the orchestrator's error responses are rewritten.
+	handle(mux, "GET /plan/{id}/artifacts/{artifactId}", rewriter.middleware(handler, true), policyResponseRewrite, policyForwardHeaders(headerGroupArtifacts))
+}
+
+func (h *artifactHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	planID := r.PathValue("id")
+	artifactID := r.PathValue("artifactId")
+	details := map[string]any{
+		"plan_id_hash":     gatewayAuditLogger.HashIdentity(planID),
+		"artifact_id_hash": gatewayAuditLogger.HashIdentity(artifactID),
+	}
+	if !planIDPattern.MatchString(planID) || !artifactIDPattern.MatchString(artifactID) {
+		details["reason"] = "invalid_id"
+		h.recordAudit(r, auditOutcomeDenied, details)
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "plan or artifact id is invalid", nil)
+		return
+	}
+
+	client, baseURL, err := h.resolveUpstream()
+	if err != nil {
+		details["reason"] = "upstream_client_unavailable"
+		h.recordAudit(r, auditOutcomeFailure, details)
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "orchestrator client unavailable", nil)
+		return
+	}
+	upstreamURL := orchestratorAPI.URL(r.Context(), baseURL, orchestratorPlanArtifact, "plan_id", planID, "artifact_id", artifactID)
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, upstreamURL, nil)
+	if err != nil {
+		details["reason"] = "upstream_request_failed"
+		h.recordAudit(r, auditOutcomeFailure, details)
+		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to create upstream request", nil)
+		return
+	}
+	if auth := strings.TrimSpace(r.Header.Get("Authorization")); auth != "" {
+		if err := validateAuthorizationHeader(auth); err != nil {
+			details["reason"] = "invalid_header"
+			details["header"] = "authorization"
+			h.recordAudit(r, auditOutcomeDenied, details)
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "authorization header invalid", nil)
+			return
+		}
+		req.Header.Set("Authorization", auth)
+	}
+	for _, cookie := range r.Header.Values("Cookie") {
+		if err := validateForwardedCookie(cookie); err != nil {
+			details["reason"] = "invalid_header"
+			details["header"] = "cookie"
+			h.recordAudit(r, auditOutcomeDenied, details)
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "cookie header invalid", nil)
+			return
+		}
+		req.Header.Add("Cookie", cookie)
+	}
+	// A Range the gateway does not understand is dropped rather than
+	// rejected; the orchestrator then answers with the full artifact, as
+	// RFC 9110 requires for unsupported ranges.
+	if rangeHeader := strings.TrimSpace(r.Header.Get("Range")); rangeHeader != "" && len(rangeHeader) <= maxRangeHeaderLen && strings.HasPrefix(rangeHeader, "bytes=") {
+		req.Header.Set("Range", rangeHeader)
+		details["range"] = true
+	}
+	if err := forwardedHeaders(headerGroupArtifacts).forward(req.Header, r.Header); err != nil {
+		details["reason"] = "invalid_header"
+		details["header"] = strings.ToLower(err.header)
+		h.recordAudit(r, auditOutcomeDenied, details)
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+		return
+	}
+	clientAddr := ClientIP(r, h.trustedProxies)
+	appendForwardingHeaders(req.Header, r.Header, clientAddr, LocalIP(r))
+	setForwardedHeaders(req.Header, r, h.trustedProxies, clientAddr)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		details["reason"] = "upstream_unreachable"
+		h.recordAudit(r, auditOutcomeFailure, details)
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to contact orchestrator", nil)
+		return
+	}
+	defer resp.Body.Close()
+
+	CloneHeaders(w.Header(), resp.Header, forwardedArtifactResponseHeaders)
+	w.WriteHeader(resp.StatusCode)
+
+	tenantHash := usageTenantHash(r.Context(), r)
+	bucket := "tenant:" + tenantHash
+	if tenantHash == "" {
+		bucket = "ip:" + clientAddr
+	}
+	body := h.bandwidth.reader(r.Context(), bucket, resp.Body)
+	buf := make([]byte, artifactCopyBufferBytes)
+	written, copyErr := io.CopyBuffer(w, body, buf)
+
+	recordUsage(tenantHash, usageMetricProxiedRequests, 1)
+	recordUsage(tenantHash, usageMetricArtifactBytes, written)
+	details["status_code"] = resp.StatusCode
+	details["bytes"] = written
+	switch {
+	case copyErr != nil:
+		details["reason"] = "stream_error"
+		h.recordAudit(r, auditOutcomeFailure, details)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		h.recordAudit(r, auditOutcomeDenied, details)
+	case resp.StatusCode >= 400:
+		h.recordAudit(r, auditOutcomeFailure, details)
+	default:
+		h.recordAudit(r, auditOutcomeSuccess, details)
+	}
+}
+
+func (h *artifactHandler) recordAudit(r *http.Request, outcome string, details map[string]any) {
+	event := audit.Event{
+		Name:       auditEventPlanArtifact,
+		Outcome:    outcome,
+		Target:     auditTargetPlanArtifact,
+		Capability: auditCapabilityPlan,
+		ActorID:    hashedActorFromRequest(r, h.trustedProxies),
+		Details:    auditDetails(details),
+	}
+	switch outcome {
+	case auditOutcomeSuccess:
+		gatewayAuditLogger.Info(r.Context(), event)
+	case auditOutcomeDenied:
+		gatewayAuditLogger.Security(r.Context(), event)
+	default:
+		gatewayAuditLogger.Error(r.Context(), event)
+	}
+}
+
+// bandwidthLimiter is a token bucket per key, shared by every download of
+// that key, so a tenant's concurrent downloads together stay within rate.
+type bandwidthLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bandwidthBucket
+	now     func() time.Time
+	sleep   func(context.Context, time.Duration) error
+}
+
+type bandwidthBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newBandwidthLimiter(bytesPerSecond, burst int64) *bandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	if burst < minArtifactBurstBytes {
+		burst = max(bytesPerSecond, minArtifactBurstBytes)
+	}
+	return &bandwidthLimiter{
+		rate:    float64(bytesPerSecond),
+		burst:   float64(burst),
+		buckets: make(map[string]*bandwidthBucket),
+		now:     clockNow,
+		sleep:   sleepContext,
+	}
+}
+
+func loadArtifactBandwidthLimiter() *bandwidthLimiter {
+	return newBandwidthLimiter(
+		int64(GetIntEnv("GATEWAY_ARTIFACT_BANDWIDTH_BYTES_PER_SECOND", 0)),
+		int64(GetIntEnv("GATEWAY_ARTIFACT_BANDWIDTH_BURST_BYTES", 0)),
+	)
+}
+
+// reserve takes n bytes from key's bucket and returns how long the caller
+// must wait before sending them. The bucket may go into debt, which later
+// readers of the same key pay off.
+func (l *bandwidthLimiter) reserve(key string, n int) time.Duration {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBandwidthBuckets {
+			l.pruneLocked(now)
+		}
+		bucket = &bandwidthBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	bucket.tokens -= float64(n)
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / l.rate * float64(time.Second))
+}
+
+// pruneLocked drops buckets that have refilled completely, which are
+// indistinguishable from new ones.
+func (l *bandwidthLimiter) pruneLocked(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// reader throttles src against key's bucket. A nil limiter returns src.
+func (l *bandwidthLimiter) reader(ctx context.Context, key string, src io.Reader) io.Reader {
+	if l == nil {
+		return src
+	}
+	return &throttledReader{ctx: ctx, limiter: l, key: key, src: src}
+}
+
+type throttledReader struct {
+	ctx     context.Context
+	limiter *bandwidthLimiter
+	key     string
+	src     io.Reader
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > int(t.limiter.burst) {
+		p = p[:int(t.limiter.burst)]
+	}
+	n, err := t.src.Read(p)
+	if n > 0 {
+		if wait := t.limiter.reserve(t.key, n); wait > 0 {
+			if sleepErr := t.limiter.sleep(t.ctx, wait); sleepErr != nil {
+				return 0, sleepErr
+			}
+		}
+	}
+	return n, err
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,185 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testArtifactContent = "0123456789abcdefghij"
+
+func newArtifactOrchestrator(t *testing.T, seen chan<- *http.Request) *httptest.Server {
+	t.Helper()
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if seen != nil {
+			seen <- r.Clone(context.Background())
+		}
+		if r.URL.Path != "/plan/"+validPlanID+"/artifacts/report.txt" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("X-Internal-Node", "orchestrator-3")
+		w.Header().Set("Content-Type", "text/plain")
+		http.ServeContent(w, r, "report.txt", modified, strings.NewReader(testArtifactContent))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestArtifactHandler(server *httptest.Server) *artifactHandler {
+	return &artifactHandler{
+		resolveUpstream: func() (*http.Client, string, error) { return server.Client(), server.URL, nil },
+	}
+}
+
+func artifactRequest(planID, artifactID string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/plan/"+planID+"/artifacts/"+artifactID, nil)
+	req.SetPathValue("id", planID)
+	req.SetPathValue("artifactId", artifactID)
+	return req
+}
+
+func TestArtifactDownloadPassesRangeThrough(t *testing.T) {
+	seen := make(chan *http.Request, 1)
+	handler := newTestArtifactHandler(newArtifactOrchestrator(t, seen))
+
+	req := artifactRequest(validPlanID, "report.txt")
+	req.Header.Set("Range", "bytes=10-")
+	req.Header.Set("If-Range", `"v1"`)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); body != testArtifactContent[10:] {
+		t.Fatalf("unexpected partial body %q", body)
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 10-19/20" {
+		t.Fatalf("unexpected Content-Range %q", got)
+	}
+	if rec.Header().Get("Accept-Ranges") != "bytes" || rec.Header().Get("ETag") != `"v1"` {
+		t.Fatalf("expected resumable download headers, got %v", rec.Header())
+	}
+	if rec.Header().Get("X-Internal-Node") != "" {
+		t.Fatal("expected internal upstream headers to be dropped")
+	}
+	upstream := <-seen
+	if upstream.Header.Get("Range") != "bytes=10-" || upstream.Header.Get("If-Range") != `"v1"` || upstream.Header.Get("Authorization") != "Bearer token" {
+		t.Fatalf("expected range and credentials forwarded, got %v", upstream.Header)
+	}
+}
+
+func TestArtifactDownloadDropsUnsupportedRange(t *testing.T) {
+	seen := make(chan *http.Request, 1)
+	handler := newTestArtifactHandler(newArtifactOrchestrator(t, seen))
+
+	req := artifactRequest(validPlanID, "report.txt")
+	req.Header.Set("Range", "items=1-2")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != testArtifactContent {
+		t.Fatalf("expected full artifact, got %d %q", rec.Code, rec.Body.String())
+	}
+	if upstream := <-seen; upstream.Header.Get("Range") != "" {
+		t.Fatalf("expected unsupported range to be dropped, got %q", upstream.Header.Get("Range"))
+	}
+}
+
+func TestArtifactDownloadRelaysUpstreamStatus(t *testing.T) {
+	handler := newTestArtifactHandler(newArtifactOrchestrator(t, nil))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, artifactRequest(validPlanID, "missing.txt"))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+
+	req := artifactRequest(validPlanID, "report.txt")
+	req.Header.Set("Range", "bytes=50-")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable || rec.Header().Get("Content-Range") != "bytes */20" {
+		t.Fatalf("expected 416 with Content-Range, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestArtifactDownloadRejectsInvalidIDs(t *testing.T) {
+	handler := newTestArtifactHandler(newArtifactOrchestrator(t, nil))
+	for _, ids := range [][2]string{{"plan-nope", "report.txt"}, {validPlanID, ".hidden"}, {validPlanID, "a%2Fb"}} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, artifactRequest(ids[0], ids[1]))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %v, got %d", ids, rec.Code)
+		}
+	}
+}
+
+func TestBandwidthLimiterSharesBucketPerKey(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newBandwidthLimiter(64<<10, 64<<10)
+	limiter.now = func() time.Time { return now }
+
+	if wait := limiter.reserve("tenant:a", 64<<10); wait != 0 {
+		t.Fatalf("expected burst to be free, got %v", wait)
+	}
+	if wait := limiter.reserve("tenant:a", 32<<10); wait != 500*time.Millisecond {
+		t.Fatalf("expected half a second debt, got %v", wait)
+	}
+	if wait := limiter.reserve("tenant:b", 32<<10); wait != 0 {
+		t.Fatalf("expected other tenants to be unaffected, got %v", wait)
+	}
+	now = now.Add(time.Second)
+	if wait := limiter.reserve("tenant:a", 32<<10); wait != 0 {
+		t.Fatalf("expected refill to cover the debt, got %v", wait)
+	}
+}
+
+func TestThrottledReaderWaitsForTokens(t *testing.T) {
+	limiter := newBandwidthLimiter(32<<10, 32<<10)
+	now := time.Unix(0, 0)
+	limiter.now = func() time.Time { return now }
+	var slept time.Duration
+	limiter.sleep = func(_ context.Context, d time.Duration) error {
+		slept += d
+		now = now.Add(d)
+		return nil
+	}
+
+	content := bytes.Repeat([]byte("x"), 128<<10)
+	n, err := io.Copy(io.Discard, limiter.reader(context.Background(), "ip:203.0.113.1", bytes.NewReader(content)))
+	if err != nil || n != int64(len(content)) {
+		t.Fatalf("copy: %d %v", n, err)
+	}
+	// The first 32KiB are the burst; the remaining 96KiB take three seconds.
+	if slept != 3*time.Second {
+		t.Fatalf("expected 3s of throttling, got %v", slept)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter.sleep = sleepContext
+	if _, err := io.Copy(io.Discard, limiter.reader(ctx, "ip:203.0.113.1", bytes.NewReader(content))); err != context.Canceled {
+		t.Fatalf("expected cancellation to stop the download, got %v", err)
+	}
+}
+
+func TestNewBandwidthLimiterDisabledWithoutRate(t *testing.T) {
+	if limiter := newBandwidthLimiter(0, 1024); limiter != nil {
+		t.Fatal("expected no limiter without a rate")
+	}
+	src := strings.NewReader("data")
+	var limiter *bandwidthLimiter
+	if limiter.reader(context.Background(), "k", src) != io.Reader(src) {
+		t.Fatal("expected nil limiter to return the source reader")
+	}
+}
@@ -0,0 +1,480 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/sse"
+)
+
+const (
+	auditEventPlanAttachment  = "plan.attachment.upload"
+	auditTargetPlanAttachment = "plan.attachments"
+
+	defaultAttachmentMaxPartBytes int64 = 25 << 20
+	defaultAttachmentMaxParts           = 10
+	// maxAttachmentFieldBytes bounds non-file form fields, which are forwarded
+	// alongside the files.
+	maxAttachmentFieldBytes = 4 << 10
+	// attachmentProgressStep is how many bytes are streamed between progress
+	// events for a single file.
+	attachmentProgressStep  int64 = 1 << 20
+	attachmentProgressEvent       = "attachment.progress"
+)
+
+// defaultAttachmentContentTypes covers the logs, specs and screenshots the
+// GUI attaches to plans.
+var defaultAttachmentContentTypes = []string{
+	"text/plain",
+	"text/markdown",
+	"text/csv",
+	"application/json",
+	"application/yaml",
+	"application/x-yaml",
+	"application/pdf",
+	"image/png",
+	"image/jpeg",
+}
+
+// ErrAttachmentRejected is returned by scanners that refuse an attachment.
+var ErrAttachmentRejected = errors.New("attachment rejected")
+
+// AttachmentInfo describes a file being uploaded to a plan.
+type AttachmentInfo struct {
+	PlanID      string
+	UploadID    string
+	Filename    string
+	ContentType string
+}
+
+// AttachmentScanner inspects attachments while they stream to the
+// orchestrator, for example by feeding them to a virus scanner. Scan returns
+// a reader yielding the content unchanged. To reject the file it returns an
+// error wrapping ErrAttachmentRejected from Read, at the latest in place of
+// io.EOF, which aborts the upload before the orchestrator receives the
+// complete file.
+type AttachmentScanner interface {
+	Scan(ctx context.Context, info AttachmentInfo, content io.Reader) io.Reader
+}
+
+// AttachmentRouteConfig captures configuration for the attachment upload route.
+type AttachmentRouteConfig struct {
+	TrustedProxyCIDRs []string
+	// Scanner, when set, inspects every uploaded file.
+	Scanner AttachmentScanner
+}
+
+type attachmentHandler struct {
+	resolveUpstream func() (*http.Client, string, error)
+	trustedProxies  []*net.IPNet
+	scanner         AttachmentScanner
+	maxPartBytes    int64
+	maxParts        int
+	contentTypes    map[string]struct{}
+	events          *planEventHub
+}
+
+// RegisterAttachmentRoutes wires POST /plan/{id}/attachments, which streams
+// multipart uploads to the orchestrator without buffering whole files.
+func RegisterAttachmentRoutes(mux *http.ServeMux, cfg AttachmentRouteConfig) {
+	trustedProxies, err := ParseTrustedProxyCIDRs(cfg.TrustedProxyCIDRs)
+	if err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("invalid trusted proxy configuration: %v", err))
+	}
+	rewriter, err := loadUpstreamURLRewriter(trustedProxies)
+	if err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("invalid response rewrite configuration: %v", err))
+	}
+	handler := &attachmentHandler{
+		resolveUpstream: currentOrchestrator,
+		trustedProxies:  trustedProxies,
+		scanner:         cfg.Scanner,
+		maxPartBytes:    int64(GetIntEnv("GATEWAY_ATTACHMENT_MAX_PART_BYTES", int(defaultAttachmentMaxPartBytes))),
+		maxParts:        GetIntEnv("GATEWAY_ATTACHMENT_MAX_PARTS", defaultAttachmentMaxParts),
+		contentTypes:    loadAttachmentContentTypes(),
+		events:          localPlanEvents,
+	}
+	handle(mux, "POST /plan/{id}/attachments", rewriter.middleware(handler, false), policyResponseRewrite, policyForwardHeaders(headerGroupAttachments))
+}
+
+func loadAttachmentContentTypes() map[string]struct{} {
+	types := defaultAttachmentContentTypes
+	if raw := strings.TrimSpace(GetEnv("GATEWAY_ATTACHMENT_CONTENT_TYPES", "")); raw != "" {
+		types = strings.Split(raw, ",")
+	}
+	allowed := make(map[string]struct{}, len(types))
+	for _, value := range types {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			allowed[value] = struct{}{}
+		}
+	}
+	return allowed
+}
+
+// attachmentError is a client-facing failure detected while streaming parts.
+type attachmentError struct {
+	status  int
+	code    string
+	message string
+	reason  string
+	// details are added to the audit event.
+	details map[string]any
+}
+
+func (e *attachmentError) Error() string {
+	return e.message
+}
+
+func (h *attachmentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	planID := r.PathValue("id")
+	planHash := gatewayAuditLogger.HashIdentity(planID)
+	if !planIDPattern.MatchString(planID) {
+		h.recordAudit(r, auditOutcomeDenied, map[string]any{"reason": "invalid_plan_id", "plan_id_hash": planHash})
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "plan id is invalid", nil)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		h.recordAudit(r, auditOutcomeDenied, map[string]any{"reason": "unsupported_media_type", "plan_id_hash": planHash})
+		writeErrorResponse(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type", "attachments must be sent as multipart/form-data", nil)
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		h.recordAudit(r, auditOutcomeDenied, map[string]any{"reason": "invalid_body", "plan_id_hash": planHash})
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "multipart body is invalid", nil)
+		return
+	}
+	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	if auth != "" {
+		if err := validateAuthorizationHeader(auth); err != nil {
+			h.recordAudit(r, auditOutcomeDenied, map[string]any{"reason": "invalid_header", "header": "authorization", "plan_id_hash": planHash})
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "authorization header invalid", nil)
+			return
+		}
+	}
+
+	client, baseURL, err := h.resolveUpstream()
+	if err != nil {
+		h.recordAudit(r, auditOutcomeFailure, map[string]any{"reason": "upstream_client_unavailable", "plan_id_hash": planHash})
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "orchestrator client unavailable", nil)
+		return
+	}
+
+	// The upstream body is produced while the client's body is read, so
+	// neither side is held in memory.
+	bodyReader, bodyWriter := io.Pipe()
+	form := multipart.NewWriter(bodyWriter)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	upstreamURL := orchestratorAPI.URL(r.Context(), baseURL, orchestratorPlanAttachments, "plan_id", planID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, upstreamURL, bodyReader)
+	if err != nil {
+		h.recordAudit(r, auditOutcomeFailure, map[string]any{"reason": "upstream_request_failed", "plan_id_hash": planHash})
+		writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "failed to create upstream request", nil)
+		return
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	if err := forwardedHeaders(headerGroupAttachments).forward(req.Header, r.Header); err != nil {
+		h.recordAudit(r, auditOutcomeDenied, map[string]any{"reason": "invalid_header", "header": strings.ToLower(err.header), "plan_id_hash": planHash})
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error(), nil)
+		return
+	}
+	clientAddr := ClientIP(r, h.trustedProxies)
+	appendForwardingHeaders(req.Header, r.Header, clientAddr, LocalIP(r))
+	setForwardedHeaders(req.Header, r, h.trustedProxies, clientAddr)
+
+	type upstreamResult struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan upstreamResult, 1)
+	go func() {
+		resp, err := client.Do(req)
+		done <- upstreamResult{resp: resp, err: err}
+	}()
+
+	files, streamErr := h.streamParts(ctx, planID, reader, form)
+	if streamErr == nil {
+		streamErr = form.Close()
+	}
+	if streamErr != nil {
+		_ = bodyWriter.CloseWithError(streamErr)
+	} else {
+		_ = bodyWriter.Close()
+	}
+	result := <-done
+	if result.resp != nil {
+		defer result.resp.Body.Close()
+	}
+
+	var rejected *attachmentError
+	if errors.As(streamErr, &rejected) {
+		details := map[string]any{"reason": rejected.reason, "plan_id_hash": planHash}
+		for key, value := range rejected.details {
+			details[key] = value
+		}
+		h.recordAudit(r, auditOutcomeDenied, details)
+		writeErrorResponse(w, r, rejected.status, rejected.code, rejected.message, nil)
+		return
+	}
+	if streamErr != nil && result.err == nil && result.resp.StatusCode >= 400 {
+		// The orchestrator refused the upload before reading all of it;
+		// its answer explains why better than the broken pipe does.
+		streamErr = nil
+	}
+	if streamErr != nil {
+		h.recordAudit(r, auditOutcomeFailure, map[string]any{"reason": "stream_error", "plan_id_hash": planHash})
+		if requestBodyTooLarge(streamErr) {
+			writeErrorResponse(w, r, http.StatusRequestEntityTooLarge, "payload_too_large", "request body too large", nil)
+			return
+		}
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to stream attachment", nil)
+		return
+	}
+	if result.err != nil {
+		h.recordAudit(r, auditOutcomeFailure, map[string]any{"reason": "upstream_unreachable", "plan_id_hash": planHash})
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to contact orchestrator", nil)
+		return
+	}
+
+	body, err := readUpstreamBody(result.resp.Body, maxUpstreamResponseBytes())
+	if err != nil {
+		h.recordAudit(r, auditOutcomeFailure, map[string]any{"reason": "upstream_response_invalid", "plan_id_hash": planHash})
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "invalid orchestrator response", nil)
+		return
+	}
+	outcome := auditOutcomeSuccess
+	if result.resp.StatusCode >= 400 {
+		outcome = auditOutcomeFailure
+	}
+	h.recordAudit(r, outcome, map[string]any{"plan_id_hash": planHash, "files": files, "status_code": result.resp.StatusCode})
+	if contentType := result.resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(result.resp.StatusCode)
+	_, _ = w.Write(body)
+}
+
+// streamParts copies the client's parts into form, enforcing the part count,
+// content type allowlist and per-file size limit, and returns the number of
+// files forwarded.
+func (h *attachmentHandler) streamParts(ctx context.Context, planID string, reader *multipart.Reader, form *multipart.Writer) (int, error) {
+	files := 0
+	for parts := 0; ; parts++ {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			if files == 0 {
+				return 0, &attachmentError{http.StatusBadRequest, "invalid_request", "no attachment provided", "missing_file", nil}
+			}
+			return files, nil
+		}
+		if err != nil {
+			if requestBodyTooLarge(err) {
+				return files, err
+			}
+			return files, &attachmentError{http.StatusBadRequest, "invalid_request", "multipart body is invalid", "invalid_body", nil}
+		}
+		if parts >= h.maxParts {
+			return files, &attachmentError{http.StatusBadRequest, "invalid_request", fmt.Sprintf("at most %d parts are allowed", h.maxParts), "too_many_parts", nil}
+		}
+		if part.FileName() == "" {
+			err = h.copyField(part, form)
+		} else {
+			err = h.copyFile(ctx, planID, part, form)
+			files++
+		}
+		_ = part.Close()
+		if err != nil {
+			return files, err
+		}
+	}
+}
+
+func (h *attachmentHandler) copyField(part *multipart.Part, form *multipart.Writer) error {
+	name := part.FormName()
+	if name == "" {
+		return &attachmentError{http.StatusBadRequest, "invalid_request", "form field name is required", "invalid_body", nil}
+	}
+	value, err := io.ReadAll(io.LimitReader(part, maxAttachmentFieldBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(value) > maxAttachmentFieldBytes {
+		return &attachmentError{http.StatusRequestEntityTooLarge, "payload_too_large", fmt.Sprintf("form field %q is too large", name), "field_too_large", nil}
+	}
+	return form.WriteField(name, string(value))
+}
+
+func (h *attachmentHandler) copyFile(ctx context.Context, planID string, part *multipart.Part, form *multipart.Writer) error {
+	contentType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if err != nil {
+		contentType = ""
+	}
+	contentType = strings.ToLower(contentType)
+	if _, ok := h.contentTypes[contentType]; !ok {
+		return &attachmentError{http.StatusUnsupportedMediaType, "unsupported_media_type", "attachment content type is not allowed", "content_type_not_allowed", nil}
+	}
+	info := AttachmentInfo{
+		PlanID:      planID,
+		UploadID:    uuid.NewString(),
+		Filename:    sanitizeAttachmentFilename(part.FileName()),
+		ContentType: contentType,
+	}
+	buffered := bufio.NewReaderSize(part, attachmentSniffBytes)
+	head, err := buffered.Peek(attachmentSniffBytes)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if rejection := checkAttachmentContent(info.Filename, contentType, head); rejection != nil {
+		return rejection
+	}
+	fieldName := part.FormName()
+	if fieldName == "" {
+		fieldName = "file"
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": fieldName, "filename": info.Filename}))
+	header.Set("Content-Type", contentType)
+	dst, err := form.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	progress := &attachmentProgress{info: info, events: h.events, next: attachmentProgressStep}
+	var content io.Reader = &limitedPart{r: buffered, remaining: h.maxPartBytes}
+	content = io.TeeReader(content, progress)
+	if h.scanner != nil {
+		content = h.scanner.Scan(ctx, info, content)
+	}
+	progress.publish("uploading")
+	if _, err := io.Copy(dst, content); err != nil {
+		switch {
+		case errors.Is(err, errAttachmentTooLarge):
+			progress.publish("failed")
+			return &attachmentError{http.StatusRequestEntityTooLarge, "payload_too_large", fmt.Sprintf("attachments must not exceed %d bytes", h.maxPartBytes), "file_too_large", nil}
+		case errors.Is(err, ErrAttachmentRejected):
+			progress.publish("rejected")
+			return &attachmentError{http.StatusUnprocessableEntity, "attachment_rejected", "attachment was rejected by the content scanner", "scan_rejected", nil}
+		default:
+			progress.publish("failed")
+			return err
+		}
+	}
+	progress.publish("completed")
+	return nil
+}
+
+// sanitizeAttachmentFilename keeps the base name of a client supplied file
+// name and strips characters that could break the forwarded part headers.
+func sanitizeAttachmentFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+var errAttachmentTooLarge = errors.New("attachment exceeds size limit")
+
+// limitedPart reads at most remaining bytes and fails, rather than truncating,
+// once the part is longer.
+type limitedPart struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedPart) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errAttachmentTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n - 1, errAttachmentTooLarge
+	}
+	return n, err
+}
+
+// attachmentProgress publishes attachment.progress events on the plan's event
+// stream as bytes pass through it.
+type attachmentProgress struct {
+	info   AttachmentInfo
+	events *planEventHub
+	bytes  int64
+	next   int64
+}
+
+func (p *attachmentProgress) Write(b []byte) (int, error) {
+	p.bytes += int64(len(b))
+	if p.bytes >= p.next {
+		p.next = p.bytes + attachmentProgressStep
+		p.publish("uploading")
+	}
+	return len(b), nil
+}
+
+func (p *attachmentProgress) publish(state string) {
+	if p.events == nil {
+		return
+	}
+	data, err := json.Marshal(map[string]any{
+		"uploadId":    p.info.UploadID,
+		"filename":    p.info.Filename,
+		"contentType": p.info.ContentType,
+		"bytes":       p.bytes,
+		"state":       state,
+	})
+	if err != nil {
+		return
+	}
+	p.events.publish(p.info.PlanID, sse.Event{Type: attachmentProgressEvent, Data: string(data)})
+}
+
+func (h *attachmentHandler) recordAudit(r *http.Request, outcome string, details map[string]any) {
+	event := audit.Event{
+		Name:       auditEventPlanAttachment,
+		Outcome:    outcome,
+		Target:     auditTargetPlanAttachment,
+		Capability: auditCapabilityPlan,
+		ActorID:    hashedActorFromRequest(r, h.trustedProxies),
+		Details:    auditDetails(details),
+	}
+	switch outcome {
+	case auditOutcomeSuccess:
+		gatewayAuditLogger.Info(r.Context(), event)
+	case auditOutcomeDenied:
+		gatewayAuditLogger.Security(r.Context(), event)
+	default:
+		gatewayAuditLogger.Error(r.Context(), event)
+	}
+}
@@ -0,0 +1,352 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/sse"
+)
+
+type uploadedAttachment struct {
+	Filename    string
+	ContentType string
+	Content     string
+}
+
+func newAttachmentOrchestrator(t *testing.T, uploads chan<- []uploadedAttachment) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/plan/"+validPlanID+"/attachments" {
+			http.NotFound(w, r)
+			return
+		}
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var received []uploadedAttachment
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// Aborted uploads never reach the final boundary.
+				return
+			}
+			content, err := io.ReadAll(part)
+			if err != nil {
+				return
+			}
+			received = append(received, uploadedAttachment{Filename: part.FileName(), ContentType: part.Header.Get("Content-Type"), Content: string(content)})
+		}
+		uploads <- received
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"attachments":%d}`, len(received))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestAttachmentHandler(server *httptest.Server) *attachmentHandler {
+	return &attachmentHandler{
+		resolveUpstream: func() (*http.Client, string, error) { return server.Client(), server.URL, nil },
+		maxPartBytes:    defaultAttachmentMaxPartBytes,
+		maxParts:        defaultAttachmentMaxParts,
+		contentTypes:    loadAttachmentContentTypes(),
+		events:          newPlanEventHub(),
+	}
+}
+
+func attachmentRequest(t *testing.T, planID string, files ...uploadedAttachment) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("note", "build logs"); err != nil {
+		t.Fatalf("write field: %v", err)
+	}
+	for _, file := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, file.Filename))
+		header.Set("Content-Type", file.ContentType)
+		part, err := form.CreatePart(header)
+		if err != nil {
+			t.Fatalf("create part: %v", err)
+		}
+		_, _ = io.WriteString(part, file.Content)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatalf("close form: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/plan/"+planID+"/attachments", &body)
+	req.SetPathValue("id", planID)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestAttachmentUploadStreamsToOrchestrator(t *testing.T) {
+	uploads := make(chan []uploadedAttachment, 1)
+	handler := newTestAttachmentHandler(newAttachmentOrchestrator(t, uploads))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, attachmentRequest(t, validPlanID,
+		uploadedAttachment{Filename: `..\..\logs\build.log`, ContentType: "text/plain; charset=utf-8", Content: "line one\nline two\n"},
+		uploadedAttachment{Filename: "spec.json", ContentType: "application/json", Content: `{"ok":true}`},
+	))
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if body := rec.Body.String(); body != `{"attachments":3}` {
+		t.Fatalf("expected orchestrator body to be relayed, got %q", body)
+	}
+	received := <-uploads
+	want := []uploadedAttachment{
+		{Content: "build logs"},
+		{Filename: "build.log", ContentType: "text/plain", Content: "line one\nline two\n"},
+		{Filename: "spec.json", ContentType: "application/json", Content: `{"ok":true}`},
+	}
+	if len(received) != len(want) {
+		t.Fatalf("expected %d parts upstream, got %+v", len(want), received)
+	}
+	for i := range want {
+		if received[i] != want[i] {
+			t.Fatalf("part %d: expected %+v, got %+v", i, want[i], received[i])
+		}
+	}
+}
+
+func TestAttachmentUploadRejectsInvalidRequests(t *testing.T) {
+	uploads := make(chan []uploadedAttachment, 1)
+	server := newAttachmentOrchestrator(t, uploads)
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		limit  int64
+		status int
+		code   string
+	}{
+		{
+			name:   "invalid plan id",
+			req:    func() *http.Request { return attachmentRequest(t, "plan-nope") },
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name: "not multipart",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/plan/"+validPlanID+"/attachments", strings.NewReader("{}"))
+				req.SetPathValue("id", validPlanID)
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			status: http.StatusUnsupportedMediaType,
+			code:   "unsupported_media_type",
+		},
+		{
+			name:   "no files",
+			req:    func() *http.Request { return attachmentRequest(t, validPlanID) },
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name: "content type not allowed",
+			req: func() *http.Request {
+				return attachmentRequest(t, validPlanID, uploadedAttachment{Filename: "run.sh", ContentType: "application/x-sh", Content: "rm -rf /"})
+			},
+			status: http.StatusUnsupportedMediaType,
+			code:   "unsupported_media_type",
+		},
+		{
+			name: "file too large",
+			req: func() *http.Request {
+				return attachmentRequest(t, validPlanID, uploadedAttachment{Filename: "big.log", ContentType: "text/plain", Content: strings.Repeat("x", 65)})
+			},
+			limit:  64,
+			status: http.StatusRequestEntityTooLarge,
+			code:   "payload_too_large",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := newTestAttachmentHandler(server)
+			if tc.limit > 0 {
+				handler.maxPartBytes = tc.limit
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, tc.req())
+			if rec.Code != tc.status {
+				t.Fatalf("expected %d, got %d: %s", tc.status, rec.Code, rec.Body.String())
+			}
+			var body httpErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != tc.code {
+				t.Fatalf("expected JSON %s error, got %q", tc.code, rec.Body.String())
+			}
+		})
+	}
+	select {
+	case received := <-uploads:
+		t.Fatalf("expected no upload to complete, got %+v", received)
+	default:
+	}
+}
+
+// rejectingScanner refuses files containing a marker once it has seen all of
+// the content, as a streaming virus scanner would.
+type rejectingScanner struct {
+	marker string
+}
+
+func (s rejectingScanner) Scan(_ context.Context, _ AttachmentInfo, content io.Reader) io.Reader {
+	return &rejectingReader{r: content, marker: s.marker}
+}
+
+type rejectingReader struct {
+	r      io.Reader
+	marker string
+	seen   bytes.Buffer
+}
+
+func (r *rejectingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.seen.Write(p[:n])
+	if err == io.EOF && strings.Contains(r.seen.String(), r.marker) {
+		return n, fmt.Errorf("%w: signature match", ErrAttachmentRejected)
+	}
+	return n, err
+}
+
+func TestAttachmentUploadAbortsOnScannerRejection(t *testing.T) {
+	uploads := make(chan []uploadedAttachment, 1)
+	handler := newTestAttachmentHandler(newAttachmentOrchestrator(t, uploads))
+	handler.scanner = rejectingScanner{marker: "EICAR"}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, attachmentRequest(t, validPlanID, uploadedAttachment{Filename: "a.txt", ContentType: "text/plain", Content: "X5O!P%@AP EICAR"}))
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+	select {
+	case received := <-uploads:
+		t.Fatalf("expected upload to be aborted, got %+v", received)
+	default:
+	}
+}
+
+func TestAttachmentUploadPublishesProgressEvents(t *testing.T) {
+	uploads := make(chan []uploadedAttachment, 1)
+	handler := newTestAttachmentHandler(newAttachmentOrchestrator(t, uploads))
+	events, unsubscribe := handler.events.subscribe(validPlanID)
+	defer unsubscribe()
+	other, unsubscribeOther := handler.events.subscribe(legacyPlanID)
+	defer unsubscribeOther()
+
+	content := strings.Repeat("a", int(attachmentProgressStep)+10)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, attachmentRequest(t, validPlanID, uploadedAttachment{Filename: "big.log", ContentType: "text/plain", Content: content}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var states []string
+	var last map[string]any
+	for len(events) > 0 {
+		event := <-events
+		if event.Type != attachmentProgressEvent {
+			t.Fatalf("unexpected event type %q", event.Type)
+		}
+		last = nil
+		if err := json.Unmarshal([]byte(event.Data), &last); err != nil {
+			t.Fatalf("decode progress: %v", err)
+		}
+		states = append(states, last["state"].(string))
+	}
+	if len(states) < 3 || states[0] != "uploading" || states[len(states)-1] != "completed" {
+		t.Fatalf("expected uploading progress ending in completed, got %v", states)
+	}
+	if last["filename"] != "big.log" || last["bytes"] != float64(len(content)) || last["uploadId"] == "" {
+		t.Fatalf("unexpected final progress %v", last)
+	}
+	if len(other) != 0 {
+		t.Fatal("expected progress to be scoped to the uploading plan")
+	}
+}
+
+func TestFlushingWriterDefersLocalEventsUntilBoundary(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writer := &flushingWriter{w: rec, flusher: rec, tracker: newSSEEventIDTracker("")}
+
+	if _, err := writer.Write([]byte("id: 7\ndata: upstream")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := writer.writeLocalEvent(sse.Event{Type: attachmentProgressEvent, Data: "{}"}); err != nil {
+		t.Fatalf("write local event: %v", err)
+	}
+	if strings.Contains(rec.Body.String(), attachmentProgressEvent) {
+		t.Fatalf("local event written mid-event: %q", rec.Body.String())
+	}
+	if _, err := writer.Write([]byte("\n\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	want := "id: 7\ndata: upstream\n\nevent: attachment.progress\ndata: {}\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if id := writer.lastEventID(); id != "7" {
+		t.Fatalf("expected local event to keep last event id, got %q", id)
+	}
+}
+
+func TestEventsHandlerInterleavesLocalEvents(t *testing.T) {
+	release := make(chan struct{})
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "id: 1\ndata: upstream\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer orchestrator.Close()
+	defer close(release)
+
+	hub := newPlanEventHub()
+	handler := NewEventsHandler(orchestrator.Client(), orchestrator.URL, 0, nil, nil)
+	handler.localEvents = hub
+	gateway := httptest.NewServer(handler)
+	defer gateway.Close()
+
+	resp, err := gateway.Client().Get(gateway.URL + "/events?plan_id=" + validPlanID)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	decoder := sse.NewDecoder(resp.Body)
+	first, err := decoder.Decode()
+	if err != nil || first.Data != "upstream" {
+		t.Fatalf("expected upstream event, got %+v (%v)", first, err)
+	}
+	hub.publish(validPlanID, sse.Event{Type: attachmentProgressEvent, Data: `{"state":"completed"}`})
+	second, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("decode local event: %v", err)
+	}
+	if second.Type != attachmentProgressEvent || second.Data != `{"state":"completed"}` || second.ID != "1" {
+		t.Fatalf("unexpected local event %+v", second)
+	}
+}
@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type publicBaseURLContextKey struct{}
+
+// configuredPublicBaseURL returns GATEWAY_PUBLIC_BASE_URL without a trailing
+// slash, or "" when unset.
+func configuredPublicBaseURL() string {
+	return strings.TrimRight(strings.TrimSpace(GetEnv("GATEWAY_PUBLIC_BASE_URL", "")), "/")
+}
+
+// ValidatePublicBaseURL checks GATEWAY_PUBLIC_BASE_URL at startup.
+func ValidatePublicBaseURL() error {
+	base := configuredPublicBaseURL()
+	if base == "" {
+		return nil
+	}
+	_, err := parseBaseURLs("GATEWAY_PUBLIC_BASE_URL", base)
+	return err
+}
+
+// PublicBaseURLMiddleware records the external base URL of each request in
+// its context, where PublicBaseURL finds it.
+func PublicBaseURLMiddleware(next http.Handler, trustedProxies []*net.IPNet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := ResolvePublicBaseURL(r, trustedProxies)
+		next.ServeHTTP(w, r.WithContext(WithPublicBaseURL(r.Context(), base)))
+	})
+}
+
+// WithPublicBaseURL returns a context carrying base as the public base URL.
+func WithPublicBaseURL(ctx context.Context, base string) context.Context {
+	return context.WithValue(ctx, publicBaseURLContextKey{}, base)
+}
+
+// PublicBaseURL returns the public base URL recorded by
+// PublicBaseURLMiddleware, or "" outside a request.
+func PublicBaseURL(ctx context.Context) string {
+	base, _ := ctx.Value(publicBaseURLContextKey{}).(string)
+	return base
+}
+
+// ResolvePublicBaseURL returns the base URL clients use to reach the gateway:
+// GATEWAY_PUBLIC_BASE_URL when set, otherwise the scheme and host of the
+// request. Forwarded host and proto headers count only from trusted proxies.
+func ResolvePublicBaseURL(r *http.Request, trustedProxies []*net.IPNet) string {
+	if base := configuredPublicBaseURL(); base != "" {
+		return base
+	}
+	scheme := "http"
+	if IsRequestSecure(r, trustedProxies) {
+		scheme = "https"
+	}
+	host := r.Host
+	if IsTrustedProxy(RequestRemoteIP(r), trustedProxies) {
+		if forwarded, ok := forwardedHost(r); ok {
+			host = forwarded
+		}
+	}
+	return scheme + "://" + host
+}
+
+// publicBaseFor prefers the base recorded in the request context and resolves
+// it otherwise, for handlers mounted outside PublicBaseURLMiddleware.
+func publicBaseFor(r *http.Request, trustedProxies []*net.IPNet) string {
+	if base := PublicBaseURL(r.Context()); base != "" {
+		return base
+	}
+	return ResolvePublicBaseURL(r, trustedProxies)
+}
+
+// forwardedHost returns the first host from X-Forwarded-Host or the
+// Forwarded header, the proxy closest to the client.
+func forwardedHost(r *http.Request) (string, bool) {
+	if value, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Host"), ","); strings.TrimSpace(value) != "" {
+		host := strings.TrimSpace(value)
+		return host, validForwardedHost(host)
+	}
+	for _, forwarded := range r.Header.Values("Forwarded") {
+		element, _, _ := strings.Cut(forwarded, ",")
+		for _, directive := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+			if ok && strings.EqualFold(key, "host") {
+				host := strings.Trim(strings.TrimSpace(value), "\"")
+				return host, validForwardedHost(host)
+			}
+		}
+	}
+	return "", false
+}
+
+// validForwardedHost accepts a bare host[:port] and nothing that could
+// smuggle a path or credentials into generated URLs.
+func validForwardedHost(host string) bool {
+	if host == "" || strings.ContainsAny(host, "/?#@\\ ") {
+		return false
+	}
+	parsed, err := url.Parse("http://" + host)
+	return err == nil && parsed.Host == host
+}
+
+// setForwardedHeaders describes the original request to an upstream: the
+// client address and the public host and scheme, both as RFC 7239 Forwarded
+// and as X-Forwarded-Host/Proto. Values supplied by the client are replaced,
+// since the gateway has already decided which of them to trust.
+func setForwardedHeaders(dst http.Header, r *http.Request, trustedProxies []*net.IPNet, clientAddr string) {
+	base, err := url.Parse(publicBaseFor(r, trustedProxies))
+	if err != nil || base.Host == "" {
+		return
+	}
+	element := []string{"host=" + quoteForwardedValue(base.Host), "proto=" + base.Scheme}
+	if clientAddr != "" {
+		element = append([]string{"for=" + forwardedNode(clientAddr)}, element...)
+	}
+	dst.Set("Forwarded", strings.Join(element, ";"))
+	dst.Set("X-Forwarded-Host", base.Host)
+	dst.Set("X-Forwarded-Proto", base.Scheme)
+}
+
+// forwardedNode formats an address as an RFC 7239 node; IPv6 addresses are
+// bracketed and quoted.
+func forwardedNode(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+		return `"[` + ip.String() + `]"`
+	}
+	return quoteForwardedValue(addr)
+}
+
+func quoteForwardedValue(value string) string {
+	if strings.ContainsAny(value, ":[]\"") {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
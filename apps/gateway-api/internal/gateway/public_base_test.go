@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolvePublicBaseURL(t *testing.T) {
+	trusted, err := ParseTrustedProxyCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("parse trusted proxies: %v", err)
+	}
+	tests := []struct {
+		name   string
+		remote string
+		header map[string]string
+		want   string
+	}{
+		{name: "direct", remote: "203.0.113.5:1234", want: "http://gateway.local"},
+		{name: "untrusted forwarded host", remote: "203.0.113.5:1234", header: map[string]string{"X-Forwarded-Host": "evil.example.com", "X-Forwarded-Proto": "https"}, want: "http://gateway.local"},
+		{name: "trusted forwarded host", remote: "10.0.0.2:1234", header: map[string]string{"X-Forwarded-Host": "app.example.com, proxy.local", "X-Forwarded-Proto": "https"}, want: "https://app.example.com"},
+		{name: "trusted forwarded header", remote: "10.0.0.2:1234", header: map[string]string{"Forwarded": `for=198.51.100.7;host="app.example.com:8443"`}, want: "http://app.example.com:8443"},
+		{name: "trusted invalid forwarded host", remote: "10.0.0.2:1234", header: map[string]string{"X-Forwarded-Host": "app.example.com/evil"}, want: "http://gateway.local"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://gateway.local/x", nil)
+			req.RemoteAddr = tc.remote
+			for key, value := range tc.header {
+				req.Header.Set(key, value)
+			}
+			if got := ResolvePublicBaseURL(req, trusted); got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+
+	t.Setenv("GATEWAY_PUBLIC_BASE_URL", "https://configured.example.com/")
+	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/x", nil)
+	req.RemoteAddr = "10.0.0.2:1"
+	req.Header.Set("X-Forwarded-Host", "app.example.com")
+	if got := ResolvePublicBaseURL(req, trusted); got != "https://configured.example.com" {
+		t.Fatalf("expected configured base to win, got %q", got)
+	}
+}
+
+func TestValidatePublicBaseURL(t *testing.T) {
+	t.Setenv("GATEWAY_PUBLIC_BASE_URL", "")
+	if err := ValidatePublicBaseURL(); err != nil {
+		t.Fatalf("expected unset base to be valid, got %v", err)
+	}
+	t.Setenv("GATEWAY_PUBLIC_BASE_URL", "gateway.example.com")
+	if err := ValidatePublicBaseURL(); err == nil {
+		t.Fatal("expected relative base to be rejected")
+	}
+}
+
+func TestPublicBaseURLMiddlewareStoresBase(t *testing.T) {
+	var got string
+	handler := PublicBaseURLMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = PublicBaseURL(r.Context())
+	}), nil)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://gateway.local/x", nil))
+	if got != "http://gateway.local" {
+		t.Fatalf("expected request base in context, got %q", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/x", nil)
+	req = req.WithContext(WithPublicBaseURL(req.Context(), "https://public.example.com"))
+	if base := publicBaseFor(req, nil); base != "https://public.example.com" {
+		t.Fatalf("expected context base to be preferred, got %q", base)
+	}
+}
+
+func TestSetForwardedHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/x", nil)
+	req.Header.Set("Forwarded", "for=evil;host=evil.example.com")
+	req = req.WithContext(WithPublicBaseURL(req.Context(), "https://app.example.com:8443"))
+
+	header := http.Header{}
+	setForwardedHeaders(header, req, nil, "2001:db8::1")
+	if got := header.Get("Forwarded"); got != `for="[2001:db8::1]";host="app.example.com:8443";proto=https` {
+		t.Fatalf("unexpected Forwarded header %q", got)
+	}
+	if header.Get("X-Forwarded-Host") != "app.example.com:8443" || header.Get("X-Forwarded-Proto") != "https" {
+		t.Fatalf("unexpected X-Forwarded headers %v", header)
+	}
+
+	header = http.Header{}
+	setForwardedHeaders(header, req, nil, "198.51.100.7")
+	if got := header.Get("Forwarded"); got != `for=198.51.100.7;host="app.example.com:8443";proto=https` {
+		t.Fatalf("unexpected Forwarded header %q", got)
+	}
+}
+
+func TestOAuthRedirectBaseFallsBackToPublicBase(t *testing.T) {
+	t.Setenv("OAUTH_REDIRECT_BASE", "")
+	t.Setenv("GATEWAY_PUBLIC_BASE_URL", "https://public.example.com/")
+	if got := oauthRedirectBase(); got != "https://public.example.com" {
+		t.Fatalf("expected public base fallback, got %q", got)
+	}
+	t.Setenv("OAUTH_REDIRECT_BASE", "https://auth.example.com")
+	if got := oauthRedirectBase(); got != "https://auth.example.com" {
+		t.Fatalf("expected explicit redirect base, got %q", got)
+	}
+}
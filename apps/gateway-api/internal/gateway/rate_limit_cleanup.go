@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+	"weak"
+)
+
+const (
+	defaultRateLimitMaxWindows      = 100_000
+	defaultRateLimitCleanupInterval = time.Minute
+	// rateLimitCleanupBatch bounds how many windows cleanup inspects per
+	// lock hold, so a request never waits behind a scan of the whole map.
+	rateLimitCleanupBatch = 1024
+)
+
+// rateLimiters tracks every limiter for the cleanup job and the admin API.
+// The references are weak so limiters built for a discarded mux, as tests
+// do, can still be collected.
+var rateLimiters struct {
+	mu   sync.Mutex
+	refs []weak.Pointer[rateLimiter]
+}
+
+func registerRateLimiter(r *rateLimiter) {
+	rateLimiters.mu.Lock()
+	defer rateLimiters.mu.Unlock()
+	rateLimiters.refs = append(rateLimiters.refs, weak.Make(r))
+}
+
+// liveRateLimiters returns the limiters still in use and forgets the rest.
+func liveRateLimiters() []*rateLimiter {
+	rateLimiters.mu.Lock()
+	defer rateLimiters.mu.Unlock()
+	live := make([]*rateLimiter, 0, len(rateLimiters.refs))
+	refs := rateLimiters.refs[:0]
+	for _, ref := range rateLimiters.refs {
+		if r := ref.Value(); r != nil {
+			live = append(live, r)
+			refs = append(refs, ref)
+		}
+	}
+	clear(rateLimiters.refs[len(refs):])
+	rateLimiters.refs = refs
+	return live
+}
+
+// cleanup removes expired windows from every shard and returns how many it
+// removed.
+func (r *rateLimiter) cleanup(ctx context.Context) int {
+	start := time.Now()
+	removed := 0
+	for i := range r.shards {
+		if ctx.Err() != nil {
+			break
+		}
+		removed += r.cleanupShard(ctx, &r.shards[i])
+	}
+	r.expired.Add(int64(removed))
+	r.cleanups.Add(1)
+	r.lastCleanupDuration.Store(int64(time.Since(start)))
+	return removed
+}
+
+// cleanupShard walks a shard from its least recently used end in batches. A
+// window touched while the lock is released moves to the front and ends the
+// walk early; the next run picks up where this one could not.
+func (r *rateLimiter) cleanupShard(ctx context.Context, shard *rateLimiterShard) int {
+	removed := 0
+	shard.mu.Lock()
+	elem := shard.lru.Back()
+	for elem != nil && ctx.Err() == nil {
+		now := r.now()
+		for i := 0; elem != nil && i < rateLimitCleanupBatch; i++ {
+			prev := elem.Prev()
+			if entry := elem.Value.(*rateLimitEntry); now.After(entry.window.expires) {
+				shard.lru.Remove(elem)
+				delete(shard.windows, entry.key)
+				removed++
+			}
+			elem = prev
+		}
+		if elem == nil {
+			break
+		}
+		shard.mu.Unlock()
+		runtime.Gosched()
+		shard.mu.Lock()
+	}
+	shard.mu.Unlock()
+	return removed
+}
+
+// cleanupRateLimiters removes expired windows from every limiter. It runs as
+// a maintenance job every GATEWAY_RATE_LIMIT_CLEANUP_INTERVAL.
+func cleanupRateLimiters(ctx context.Context) error {
+	for _, r := range liveRateLimiters() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		r.cleanup(ctx)
+	}
+	return nil
+}
+
+// rateLimiterStats is the admin API view of one limiter.
+type rateLimiterStats struct {
+	Name                string `json:"name"`
+	Windows             int    `json:"windows"`
+	MaxWindows          int    `json:"max_windows"`
+	Expired             int64  `json:"expired"`
+	Evictions           int64  `json:"evictions"`
+	Cleanups            int64  `json:"cleanups"`
+	LastCleanupDuration string `json:"last_cleanup_duration,omitempty"`
+}
+
+func (r *rateLimiter) stats() rateLimiterStats {
+	stats := rateLimiterStats{
+		Name:       r.name,
+		Windows:    r.windowCount(),
+		MaxWindows: r.maxWindows,
+		Expired:    r.expired.Load(),
+		Evictions:  r.evictions.Load(),
+		Cleanups:   r.cleanups.Load(),
+	}
+	if r.cleanups.Load() > 0 {
+		stats.LastCleanupDuration = time.Duration(r.lastCleanupDuration.Load()).String()
+	}
+	return stats
+}
+
+func rateLimiterStatsSnapshot() []rateLimiterStats {
+	limiters := liveRateLimiters()
+	stats := make([]rateLimiterStats, 0, len(limiters))
+	for _, r := range limiters {
+		stats = append(stats, r.stats())
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
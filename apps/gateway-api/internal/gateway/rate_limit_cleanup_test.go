@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func hasRateLimitWindow(limiter *rateLimiter, key string) bool {
+	shard := limiter.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	_, ok := shard.windows[key]
+	return ok
+}
+
+func TestRateLimiterEvictsLeastRecentlyUsedWindow(t *testing.T) {
+	// One shard, so the cap applies to all three identities at once.
+	t.Setenv("GATEWAY_LIMITER_SHARDS", "1")
+	t.Setenv("GATEWAY_RATE_LIMIT_MAX_WINDOWS", "2")
+	limiter := newRateLimiter("test")
+	bucket := rateLimitBucket{Endpoint: "test", IdentityType: "ip", Window: time.Minute, Limit: 1}
+	ctx := context.Background()
+
+	limiter.Allow(ctx, bucket, "first")
+	limiter.Allow(ctx, bucket, "second")
+	if allowed, _, _ := limiter.Allow(ctx, bucket, "first"); allowed {
+		t.Fatal("expected first identity to stay limited")
+	}
+	limiter.Allow(ctx, bucket, "third")
+
+	if limiter.windowCount() != 2 {
+		t.Fatalf("expected the cap to hold two windows, got %d", limiter.windowCount())
+	}
+	if hasRateLimitWindow(limiter, "test|ip|second") {
+		t.Fatal("expected the least recently used window to be evicted")
+	}
+	if allowed, _, _ := limiter.Allow(ctx, bucket, "first"); allowed {
+		t.Fatal("expected the recently used window to survive eviction")
+	}
+	if stats := limiter.stats(); stats.Evictions != 1 || stats.Windows != 2 || stats.MaxWindows != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRateLimiterCleanupScansInBatches(t *testing.T) {
+	t.Setenv("GATEWAY_LIMITER_SHARDS", "1")
+	limiter := newRateLimiter("test")
+	base := time.Now()
+	limiter.now = func() time.Time { return base }
+	bucket := rateLimitBucket{Endpoint: "test", IdentityType: "ip", Window: time.Second, Limit: 5}
+	ctx := context.Background()
+
+	expiring := 3*rateLimitCleanupBatch + 7
+	for i := 0; i < expiring; i++ {
+		limiter.Allow(ctx, bucket, fmt.Sprintf("old-%d", i))
+	}
+	limiter.now = func() time.Time { return base.Add(2 * time.Second) }
+	limiter.Allow(ctx, bucket, "fresh")
+
+	if removed := limiter.cleanup(ctx); removed != expiring {
+		t.Fatalf("expected %d expired windows removed, got %d", expiring, removed)
+	}
+	if !hasRateLimitWindow(limiter, "test|ip|fresh") || limiter.windowCount() != 1 {
+		t.Fatalf("expected only the active window to remain, got %d", limiter.windowCount())
+	}
+	stats := limiter.stats()
+	if stats.Expired != int64(expiring) || stats.Cleanups != 1 || stats.LastCleanupDuration == "" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestAdminRateLimiterStats(t *testing.T) {
+	limiter := newRateLimiter("test.admin")
+	limiter.Allow(context.Background(), rateLimitBucket{Endpoint: "test", IdentityType: "ip", Window: time.Minute, Limit: 1}, "client")
+	if err := cleanupRateLimiters(context.Background()); err != nil {
+		t.Fatalf("cleanup job: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+	req := httptest.NewRequest(http.MethodGet, "/admin/rate-limiters", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+
+	var resp rateLimitersResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	for _, stats := range resp.Limiters {
+		if stats.Name == "test.admin" {
+			if stats.Windows != 1 || stats.Cleanups != 1 {
+				t.Fatalf("unexpected stats %+v", stats)
+			}
+			return
+		}
+	}
+	t.Fatalf("expected the limiter to be listed, got %s", rec.Body.String())
+}
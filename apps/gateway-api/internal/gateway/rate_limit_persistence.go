@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+const rateLimitNamespace = "rate_limit"
+
+// persistedRateLimitWindow is the stored form of a rateLimitWindow.
+type persistedRateLimitWindow struct {
+	Count   int       `json:"count"`
+	Expires time.Time `json:"expires"`
+}
+
+// newPersistentRateLimiter returns a limiter whose windows are also written to
+// GATEWAY_STORAGE_URL, so lockouts survive a restart when the store is a file
+// or Redis. It is meant for limiters that only count failures, such as
+// authentication lockouts: every counted request writes to the store. If the
+// store cannot be opened the limiter keeps its windows in memory only.
+func newPersistentRateLimiter(name string) *rateLimiter {
+	r := newRateLimiter(name)
+	store, err := loadStateStorage()
+	if err != nil {
+		slog.Warn("rate limiter windows will not survive restarts", slog.String("limiter", name), slog.Any("error", err))
+		return r
+	}
+	r.store = storage.Namespace(storage.Namespace(store, rateLimitNamespace), name)
+	return r
+}
+
+// restore reads back the window for key the first time this process sees it.
+// Expired windows are already gone from the store, so whatever it returns is
+// still in force. ok is false when the store failed and the cold-start policy
+// refuses to treat the identity as unknown.
+func (r *rateLimiter) restore(ctx context.Context, key string, now time.Time) (rateLimitWindow, bool) {
+	data, err := r.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return rateLimitWindow{}, true
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "rate limiter window unavailable", slog.String("limiter", r.name), slog.Any("error", err))
+		return rateLimitWindow{}, !currentColdStartPolicy().failClosed(now)
+	}
+	var stored persistedRateLimitWindow
+	if err := json.Unmarshal(data, &stored); err != nil || !now.Before(stored.Expires) {
+		return rateLimitWindow{}, true
+	}
+	return rateLimitWindow{expires: stored.Expires, count: stored.Count}, true
+}
+
+// persist writes the window for key with a TTL matching its expiry.
+func (r *rateLimiter) persist(ctx context.Context, key string, window rateLimitWindow, now time.Time) {
+	ttl := window.expires.Sub(now)
+	if ttl <= 0 {
+		return
+	}
+	data, err := json.Marshal(persistedRateLimitWindow{Count: window.count, Expires: window.expires})
+	if err != nil {
+		return
+	}
+	if err := r.store.Set(ctx, key, data, ttl); err != nil {
+		slog.WarnContext(ctx, "failed to persist rate limiter window", slog.String("limiter", r.name), slog.Any("error", err))
+	}
+}
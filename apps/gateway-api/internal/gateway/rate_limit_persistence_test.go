@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+func TestPersistentRateLimiterLockoutSurvivesRestart(t *testing.T) {
+	t.Setenv("GATEWAY_STORAGE_URL", "file://"+filepath.Join(t.TempDir(), "state.db"))
+	resetStateStorage()
+	t.Cleanup(resetStateStorage)
+	bucket := rateLimitBucket{Endpoint: "scim.auth_failure", IdentityType: "ip", Window: time.Minute, Limit: 2}
+	ctx := context.Background()
+
+	limiter := newPersistentRateLimiter("scim.auth_failure")
+	for i := 0; i < 2; i++ {
+		limiter.Allow(ctx, bucket, "203.0.113.9")
+	}
+	if allowed, _, _ := limiter.Allow(ctx, bucket, "203.0.113.9"); allowed {
+		t.Fatal("expected the identity to be locked out")
+	}
+
+	// Reopening the file store stands in for a restarted process.
+	resetStateStorage()
+	restarted := newPersistentRateLimiter("scim.auth_failure")
+	allowed, retryAfter, err := restarted.Allow(ctx, bucket, "203.0.113.9")
+	if err != nil || allowed || retryAfter <= 0 || retryAfter > time.Minute {
+		t.Fatalf("expected the lockout to survive the restart, got %v %v %v", allowed, retryAfter, err)
+	}
+	if allowed, _, _ := restarted.Allow(ctx, bucket, "198.51.100.4"); !allowed {
+		t.Fatal("expected other identities to be unaffected")
+	}
+	if allowed, _, _ := newPersistentRateLimiter("collaboration.auth_failure").Allow(ctx, bucket, "203.0.113.9"); !allowed {
+		t.Fatal("expected limiters not to share windows")
+	}
+}
+
+func TestPersistentRateLimiterColdStartPolicy(t *testing.T) {
+	limiter := newRateLimiter("test")
+	limiter.store = &recordingStore{Store: storage.NewMemoryStore(), err: errors.New("store unavailable")}
+	bucket := rateLimitBucket{Endpoint: "test", IdentityType: "ip", Window: time.Minute, Limit: 5}
+	ctx := context.Background()
+
+	if allowed, _, _ := limiter.Allow(ctx, bucket, "first"); !allowed {
+		t.Fatal("expected the open policy to allow when the store fails")
+	}
+
+	t.Setenv("GATEWAY_COLD_START_POLICY", "strict")
+	t.Setenv("GATEWAY_COLD_START_GRACE", "876000h")
+	allowed, retryAfter, _ := limiter.Allow(ctx, bucket, "second")
+	if allowed || retryAfter != time.Minute {
+		t.Fatalf("expected the strict policy to refuse during the grace period, got %v %v", allowed, retryAfter)
+	}
+	if allowed, _, _ := limiter.Allow(ctx, bucket, "first"); !allowed {
+		t.Fatal("expected windows already in memory to be used without the store")
+	}
+
+	t.Setenv("GATEWAY_COLD_START_GRACE", "1ns")
+	if allowed, _, _ := limiter.Allow(ctx, bucket, "second"); !allowed {
+		t.Fatal("expected the strict policy to allow after the grace period")
+	}
+}
+
+func TestValidateColdStartPolicy(t *testing.T) {
+	t.Setenv("GATEWAY_COLD_START_POLICY", "STRICT")
+	if err := ValidateColdStartPolicy(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Setenv("GATEWAY_COLD_START_POLICY", "closed")
+	if err := ValidateColdStartPolicy(); err == nil {
+		t.Fatal("expected an unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+const redisHealthTimeout = 2 * time.Second
+
+// Components newRedisClient connects, as reported by /readyz.
+const (
+	redisComponentOAuthState = "oauth_state"
+	redisComponentUsage      = "usage"
+	redisComponentStorage    = "storage"
+)
+
+// redisComponent is a gateway subsystem backed by Redis, with what it does
+// while Redis is unreachable.
+type redisComponent struct {
+	client   *storage.RedisClient
+	fallback string
+}
+
+// redisComponents are the Redis clients in use, by the name /readyz reports
+// them under.
+var redisComponents struct {
+	mu      sync.Mutex
+	clients map[string]redisComponent
+}
+
+// newRedisClient connects component to the Redis deployment at rawURL, which
+// may name a standalone server, Sentinel or a cluster. GATEWAY_REDIS_USERNAME
+// and GATEWAY_REDIS_PASSWORD (or their _FILE variants) replace credentials in
+// the URL, so the password can come from a mounted secret. For rediss URLs,
+// GATEWAY_REDIS_TLS_CA_FILE and GATEWAY_REDIS_TLS_SERVER_NAME adjust
+// certificate verification. fallback describes how component degrades while
+// Redis is down; readiness reports it.
+func newRedisClient(component, rawURL, fallback string) (*storage.RedisClient, error) {
+	client, err := storage.NewRedisClient(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	client.SetDialContext(egressDialContext(egressRedis, &net.Dialer{}))
+	if envConfigured("GATEWAY_REDIS_PASSWORD") {
+		password, err := ResolveEnvValue("GATEWAY_REDIS_PASSWORD")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load GATEWAY_REDIS_PASSWORD: %w", err)
+		}
+		username, err := ResolveEnvValue("GATEWAY_REDIS_USERNAME")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load GATEWAY_REDIS_USERNAME: %w", err)
+		}
+		client.SetAuth(username, password)
+	}
+	caFile := strings.TrimSpace(os.Getenv("GATEWAY_REDIS_TLS_CA_FILE"))
+	serverName := strings.TrimSpace(os.Getenv("GATEWAY_REDIS_TLS_SERVER_NAME"))
+	if caFile != "" || serverName != "" {
+		if !strings.HasPrefix(strings.TrimSpace(rawURL), "rediss") {
+			return nil, errors.New("GATEWAY_REDIS_TLS_CA_FILE and GATEWAY_REDIS_TLS_SERVER_NAME require a rediss URL")
+		}
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+		if caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read GATEWAY_REDIS_TLS_CA_FILE: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("GATEWAY_REDIS_TLS_CA_FILE contains no certificates")
+			}
+			tlsConfig.RootCAs = pool
+		}
+		client.SetTLSConfig(tlsConfig)
+	}
+
+	redisComponents.mu.Lock()
+	defer redisComponents.mu.Unlock()
+	if redisComponents.clients == nil {
+		redisComponents.clients = make(map[string]redisComponent)
+	}
+	redisComponents.clients[component] = redisComponent{client: client, fallback: fallback}
+	return client, nil
+}
+
+// forgetRedisClient stops reporting component, when its client is closed.
+func forgetRedisClient(component string) {
+	redisComponents.mu.Lock()
+	defer redisComponents.mu.Unlock()
+	delete(redisComponents.clients, component)
+}
+
+// checkRedis pings the Redis deployment of every Redis-backed component.
+// Redis being down degrades the gateway rather than taking it out of
+// rotation, since each component keeps working on local state; the result
+// names what was lost so the degradation is not missed.
+func checkRedis(ctx context.Context) map[string]dependencyResult {
+	redisComponents.mu.Lock()
+	components := make(map[string]redisComponent, len(redisComponents.clients))
+	for name, component := range redisComponents.clients {
+		components[name] = component
+	}
+	redisComponents.mu.Unlock()
+
+	results := make(map[string]dependencyResult, len(components))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, component := range components {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			pingCtx, cancel := context.WithTimeout(ctx, redisHealthTimeout)
+			defer cancel()
+			result := successResult(start)
+			if err := component.client.Ping(pingCtx); err != nil {
+				result = failureResult(start, err.Error())
+				result.Status = healthStatusDegraded
+				result.Details = []string{"running on local state: " + component.fallback}
+				slog.WarnContext(ctx, "redis unreachable; running on local state",
+					slog.String("component", name), slog.String("topology", component.client.Topology()), slog.String("error", err.Error()))
+			}
+			mu.Lock()
+			results["redis."+name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage/storagetest"
+)
+
+func TestReadinessDegradesWhileRedisIsDown(t *testing.T) {
+	t.Setenv("OAUTH_STATE_STORE", "")
+	t.Setenv("OAUTH_STATE_REDIS_URL", "redis://127.0.0.1:1")
+	t.Setenv("GATEWAY_HEALTH_CHECKS", `{"orchestrator": {"disabled": true}, "indexer": {"disabled": true}}`)
+	resetHealthChecks()
+	t.Cleanup(resetHealthChecks)
+	resetConsumedStateStore()
+	t.Cleanup(resetConsumedStateStore)
+	if _, err := loadConsumedStateStore(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resp := buildHealthResponse(context.Background(), time.Now(), true)
+	if resp.Status != readinessDegraded {
+		t.Fatalf("expected degraded readiness, got %s", resp.Status)
+	}
+	result, ok := resp.Details["redis."+redisComponentOAuthState]
+	if !ok || result.Status != healthStatusDegraded || result.Error == nil {
+		t.Fatalf("expected degraded redis result, got %+v", result)
+	}
+	if len(result.Details) != 1 || !strings.HasPrefix(result.Details[0], "running on local state: ") {
+		t.Fatalf("expected fallback to be reported, got %v", result.Details)
+	}
+
+	resetConsumedStateStore()
+	resp = buildHealthResponse(context.Background(), time.Now(), true)
+	if _, ok := resp.Details["redis."+redisComponentOAuthState]; ok {
+		t.Fatal("expected closed client to stop being reported")
+	}
+}
+
+func TestNewRedisClientAuthenticatesFromSecretFile(t *testing.T) {
+	redis := storagetest.NewFakeRedis(t)
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write password: %v", err)
+	}
+	t.Setenv("GATEWAY_REDIS_USERNAME", "gateway")
+	t.Setenv("GATEWAY_REDIS_PASSWORD_FILE", passwordFile)
+
+	client, err := newRedisClient("test", redis.URL(), "nothing")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		forgetRedisClient("test")
+	})
+
+	results := checkRedis(context.Background())
+	if results["redis.test"].Status != healthStatusPass {
+		t.Fatalf("expected redis check to pass, got %+v", results["redis.test"])
+	}
+	if commands := redis.Commands(); len(commands) == 0 || !slices.Equal(commands[0], []string{"AUTH", "gateway", "s3cret"}) {
+		t.Fatalf("expected AUTH with the configured credentials first, got %q", commands)
+	}
+}
+
+func TestNewRedisClientRejectsTLSSettingsWithoutTLS(t *testing.T) {
+	t.Setenv("GATEWAY_REDIS_TLS_SERVER_NAME", "redis.internal")
+	if _, err := newRedisClient("test", "redis://127.0.0.1:6379", "nothing"); err == nil {
+		t.Fatal("expected TLS settings on a redis URL to be rejected")
+	}
+	t.Cleanup(func() { forgetRedisClient("test") })
+
+	t.Setenv("GATEWAY_REDIS_TLS_CA_FILE", filepath.Join(t.TempDir(), "missing.pem"))
+	if _, err := newRedisClient("test", "rediss://127.0.0.1:6379", "nothing"); err == nil {
+		t.Fatal("expected missing CA file to be rejected")
+	}
+}
@@ -0,0 +1,430 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+// Enforcement levels for GATEWAY_REQUEST_HYGIENE.
+const (
+	requestHygieneOff    = "off"
+	requestHygieneLog    = "log"
+	requestHygieneReject = "reject"
+	requestHygieneStrict = "strict"
+)
+
+const auditEventHTTPRequestHygiene = "gateway.http.request_hygiene"
+
+// Findings recorded for a request head. net/http resolves each of them
+// silently, so a proxy in front of the gateway may have framed the same bytes
+// differently.
+const (
+	hygieneContentLengthWithTransferEncoding = "content_length_with_transfer_encoding"
+	hygieneDuplicateContentLength            = "duplicate_content_length"
+	hygieneTransferEncodingHTTP10            = "transfer_encoding_http10"
+	hygieneObsFold                           = "obs_fold"
+	// Rejected only at the strict level.
+	hygieneBareLF         = "bare_lf"
+	hygieneUnexpectedBody = "unexpected_body"
+)
+
+// maxPendingRequestHeads bounds the heads a connection keeps for requests
+// the middleware has not reached yet, which only pipelining produces.
+const maxPendingRequestHeads = 16
+
+// requestHeadPadding is the slack net/http allows beyond MaxHeaderBytes for
+// the request line.
+const requestHeadPadding = 4096
+
+func requestHygieneLevel() (string, error) {
+	switch level := strings.ToLower(strings.TrimSpace(GetEnv("GATEWAY_REQUEST_HYGIENE", requestHygieneReject))); level {
+	case requestHygieneOff, requestHygieneLog, requestHygieneReject, requestHygieneStrict:
+		return level, nil
+	default:
+		return "", fmt.Errorf("unsupported GATEWAY_REQUEST_HYGIENE %q (expected off, log, reject or strict)", level)
+	}
+}
+
+// ValidateRequestHygieneConfig checks GATEWAY_REQUEST_HYGIENE at startup.
+func ValidateRequestHygieneConfig() error {
+	_, err := requestHygieneLevel()
+	return err
+}
+
+// RequestHygieneListener wraps l so the public server can see how each
+// request head was written on the wire, which net/http normalises before a
+// handler runs. Pair it with RequestHygieneConnContext and
+// RequestHygieneMiddleware. It returns l unchanged when
+// GATEWAY_REQUEST_HYGIENE is off.
+func RequestHygieneListener(l net.Listener) net.Listener {
+	if level, _ := requestHygieneLevel(); level == requestHygieneOff {
+		return l
+	}
+	return hygieneListener{Listener: l, maxHeadBytes: ServerMaxHeaderBytes() + requestHeadPadding}
+}
+
+type hygieneListener struct {
+	net.Listener
+	maxHeadBytes int
+}
+
+func (l hygieneListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &hygieneConn{Conn: conn, scanner: requestHeadScanner{maxHeadBytes: l.maxHeadBytes}}, nil
+}
+
+type hygieneConnContextKey struct{}
+
+// RequestHygieneConnContext is an http.Server ConnContext that hands the
+// connection's recorded request heads to RequestHygieneMiddleware.
+func RequestHygieneConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if hc, ok := conn.(*hygieneConn); ok {
+		return context.WithValue(ctx, hygieneConnContextKey{}, hc)
+	}
+	return ctx
+}
+
+// hygieneConn scans the bytes the server reads for request heads.
+type hygieneConn struct {
+	net.Conn
+	mu      sync.Mutex
+	scanner requestHeadScanner
+	heads   []requestHead
+}
+
+func (c *hygieneConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.heads = append(c.heads, c.scanner.feed(p[:n])...)
+		if len(c.heads) > maxPendingRequestHeads {
+			c.heads = c.heads[len(c.heads)-maxPendingRequestHeads:]
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// findings returns what was recorded for the head of r. Heads of earlier
+// requests the server answered without the handler, such as OPTIONS *, are
+// dropped on the way.
+func (c *hygieneConn) findings(r *http.Request) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, head := range c.heads {
+		if head.method == r.Method && head.target == r.RequestURI {
+			c.heads = c.heads[i+1:]
+			return head.findings
+		}
+	}
+	return nil
+}
+
+type requestHead struct {
+	method   string
+	target   string
+	findings []string
+}
+
+type rawHeader struct {
+	name  string
+	value string
+}
+
+const (
+	scanHead = iota
+	scanBody
+	scanChunkSize
+	scanChunkData
+	scanChunkEnd
+	scanTrailer
+	// scanStopped gives up on the connection: it is no longer HTTP/1.x (an
+	// upgrade or tunnel) or it is malformed in a way the server rejects.
+	scanStopped
+)
+
+// requestHeadScanner follows the HTTP/1.x stream of one connection, records
+// every request head and skips bodies the way net/http frames them, so
+// pipelined requests are found too. Scanning stops on a head longer than
+// maxHeadBytes, which the server rejects anyway.
+type requestHeadScanner struct {
+	maxHeadBytes int
+	state        int
+	line         []byte
+	headBytes    int
+	remaining    int64
+
+	method  string
+	target  string
+	proto   string
+	headers []rawHeader
+	obsFold bool
+	bareLF  bool
+}
+
+func (s *requestHeadScanner) feed(p []byte) []requestHead {
+	maxHeadBytes := s.maxHeadBytes
+	if maxHeadBytes == 0 {
+		maxHeadBytes = http.DefaultMaxHeaderBytes + requestHeadPadding
+	}
+	var heads []requestHead
+	for len(p) > 0 && s.state != scanStopped {
+		if s.state == scanBody || s.state == scanChunkData {
+			n := int64(len(p))
+			if n > s.remaining {
+				n = s.remaining
+			}
+			p = p[n:]
+			if s.remaining -= n; s.remaining == 0 {
+				if s.state == scanBody {
+					s.state = scanHead
+				} else {
+					s.state = scanChunkEnd
+				}
+			}
+			continue
+		}
+		end := bytes.IndexByte(p, '\n')
+		if end < 0 {
+			s.line = append(s.line, p...)
+			if s.headBytes+len(s.line) > maxHeadBytes {
+				s.state = scanStopped
+			}
+			return heads
+		}
+		line := append(s.line, p[:end]...)
+		p = p[end+1:]
+		s.line = s.line[:0]
+		if s.state == scanHead {
+			s.headBytes += len(line) + 1
+		}
+		if s.headBytes > maxHeadBytes || len(line) > maxHeadBytes {
+			s.state = scanStopped
+			return heads
+		}
+		crlf := len(line) > 0 && line[len(line)-1] == '\r'
+		if crlf {
+			line = line[:len(line)-1]
+		}
+		if head, ok := s.scanLine(line, crlf); ok {
+			heads = append(heads, head)
+		}
+	}
+	return heads
+}
+
+// scanLine consumes one line outside a body and reports a completed head.
+func (s *requestHeadScanner) scanLine(line []byte, crlf bool) (requestHead, bool) {
+	switch s.state {
+	case scanHead:
+		if s.method == "" {
+			if len(line) == 0 {
+				s.headBytes = 0
+				return requestHead{}, false
+			}
+			s.bareLF = !crlf
+			s.parseRequestLine(string(line))
+			return requestHead{}, false
+		}
+		if !crlf {
+			s.bareLF = true
+		}
+		switch {
+		case len(line) == 0:
+			return s.finishHead(), true
+		case line[0] == ' ' || line[0] == '\t':
+			// net/http joins folded lines into the previous value.
+			s.obsFold = true
+			if len(s.headers) > 0 {
+				last := &s.headers[len(s.headers)-1]
+				last.value = strings.TrimSpace(last.value + " " + strings.TrimSpace(string(line)))
+			}
+		default:
+			name, value, _ := strings.Cut(string(line), ":")
+			s.headers = append(s.headers, rawHeader{name: strings.ToLower(name), value: strings.TrimSpace(value)})
+		}
+	case scanChunkSize:
+		raw, _, _ := strings.Cut(string(line), ";")
+		size, err := strconv.ParseUint(strings.TrimSpace(raw), 16, 63)
+		switch {
+		case err != nil:
+			s.state = scanStopped
+		case size == 0:
+			s.state = scanTrailer
+		default:
+			s.remaining = int64(size)
+			s.state = scanChunkData
+		}
+	case scanChunkEnd:
+		if len(line) != 0 {
+			s.state = scanStopped
+			return requestHead{}, false
+		}
+		s.state = scanChunkSize
+	case scanTrailer:
+		if len(line) == 0 {
+			s.state = scanHead
+		}
+	}
+	return requestHead{}, false
+}
+
+// parseRequestLine splits the request line like net/http does and stops on
+// anything that is not an HTTP/1.x request, such as WebSocket frames after an
+// upgrade.
+func (s *requestHeadScanner) parseRequestLine(line string) {
+	method, rest, ok1 := strings.Cut(line, " ")
+	target, proto, ok2 := strings.Cut(rest, " ")
+	if !ok1 || !ok2 || !isHTTPToken(method) || !strings.HasPrefix(proto, "HTTP/1.") {
+		s.state = scanStopped
+		return
+	}
+	s.method, s.target, s.proto = method, target, proto
+}
+
+// finishHead records the findings for the head just read and frames its
+// body: chunked when an HTTP/1.1 request names any transfer coding, since
+// net/http drops Content-Length then, and Content-Length otherwise.
+func (s *requestHeadScanner) finishHead() requestHead {
+	var contentLengths, transferEncodings []string
+	for _, header := range s.headers {
+		switch header.name {
+		case "content-length":
+			contentLengths = append(contentLengths, header.value)
+		case "transfer-encoding":
+			transferEncodings = append(transferEncodings, header.value)
+		}
+	}
+	head := requestHead{method: s.method, target: s.target}
+	if len(contentLengths) > 0 && len(transferEncodings) > 0 {
+		head.findings = append(head.findings, hygieneContentLengthWithTransferEncoding)
+	}
+	if len(contentLengths) > 1 || (len(contentLengths) == 1 && strings.Contains(contentLengths[0], ",")) {
+		head.findings = append(head.findings, hygieneDuplicateContentLength)
+	}
+	http10 := s.proto == "HTTP/1.0"
+	if len(transferEncodings) > 0 && http10 {
+		head.findings = append(head.findings, hygieneTransferEncodingHTTP10)
+	}
+	if s.obsFold {
+		head.findings = append(head.findings, hygieneObsFold)
+	}
+	if s.bareLF {
+		head.findings = append(head.findings, hygieneBareLF)
+	}
+
+	var length int64
+	if len(contentLengths) > 0 {
+		raw, _, _ := strings.Cut(contentLengths[0], ",")
+		parsed, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil || parsed < 0 {
+			s.state = scanStopped
+		}
+		length = parsed
+	}
+	chunked := len(transferEncodings) > 0 && !http10
+	if (chunked || length > 0) && (s.method == http.MethodGet || s.method == http.MethodHead) {
+		head.findings = append(head.findings, hygieneUnexpectedBody)
+	}
+	if s.state != scanStopped {
+		switch {
+		case chunked:
+			s.state = scanChunkSize
+		case length > 0:
+			s.remaining = length
+			s.state = scanBody
+		}
+	}
+
+	s.method, s.target, s.proto = "", "", ""
+	s.headers = s.headers[:0]
+	s.obsFold, s.bareLF = false, false
+	s.headBytes = 0
+	return head
+}
+
+// RequestHygieneMiddleware rejects requests whose head was ambiguous on the
+// wire: Content-Length together with Transfer-Encoding, repeated
+// Content-Length, Transfer-Encoding on HTTP/1.0 and folded header lines. The
+// strict level also rejects bare LF line endings and bodies on GET and HEAD.
+// Rejections answer 400 and close the connection, since the server and a
+// proxy in front of it may disagree on where the next request starts. The log
+// level records everything strict would reject and lets it through. Each
+// request with findings the level acts on emits a
+// gateway.http.request_hygiene audit event.
+func RequestHygieneMiddleware(next http.Handler, trusted []*net.IPNet) http.Handler {
+	level, _ := requestHygieneLevel()
+	if level == requestHygieneOff {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _ := r.Context().Value(hygieneConnContextKey{}).(*hygieneConn)
+		if conn == nil || r.ProtoMajor != 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		findings := enforcedFindings(level, conn.findings(r))
+		if len(findings) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rejected := level != requestHygieneLog
+		if updated, _ := audit.EnsureRequestID(r, w); updated != nil {
+			r = updated
+		}
+		auditRequestHygiene(r, trusted, level, findings, rejected)
+		if !rejected {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Connection", "close")
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "request framing is ambiguous", nil)
+	})
+}
+
+// enforcedFindings keeps the findings level acts on. The log level keeps
+// everything strict would reject.
+func enforcedFindings(level string, findings []string) []string {
+	if level != requestHygieneReject {
+		return findings
+	}
+	var enforced []string
+	for _, finding := range findings {
+		if finding != hygieneBareLF && finding != hygieneUnexpectedBody {
+			enforced = append(enforced, finding)
+		}
+	}
+	return enforced
+}
+
+func auditRequestHygiene(r *http.Request, trusted []*net.IPNet, level string, findings []string, rejected bool) {
+	actor := hashedActorFromRequest(r, trusted)
+	outcome := auditOutcomeSuccess
+	if rejected {
+		outcome = auditOutcomeDenied
+	}
+	gatewayAuditLogger.Security(audit.WithActor(r.Context(), actor), audit.Event{
+		Name:       auditEventHTTPRequestHygiene,
+		Outcome:    outcome,
+		Target:     auditTargetHTTP,
+		Capability: auditCapabilityHTTP,
+		ActorID:    actor,
+		Details: auditDetails(map[string]any{
+			"findings": findings,
+			"level":    level,
+			"method":   r.Method,
+			"rejected": rejected,
+		}),
+	})
+}
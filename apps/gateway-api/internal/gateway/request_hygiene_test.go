@@ -0,0 +1,146 @@
+package gateway
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestHeadScannerFollowsPipelinedRequests(t *testing.T) {
+	stream := "POST /a HTTP/1.1\r\nHost: gw\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n0\r\nX-Trailer: 1\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: gw\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n" +
+		"POST /c HTTP/1.1\r\nHost: gw\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nabc" +
+		"POST /d HTTP/1.0\r\nHost: gw\r\nTransfer-Encoding: chunked\r\nContent-Length: 2\r\n\r\nok" +
+		"GET /e HTTP/1.1\r\nHost: gw\r\nX-Note: a\r\n b\r\n\r\n" +
+		"GET /f HTTP/1.1\nHost: gw\nContent-Length: 1\n\nx" +
+		"GET /g HTTP/1.1\r\nHost: gw\r\n\r\n"
+	want := map[string][]string{
+		"/a": nil,
+		"/b": {hygieneContentLengthWithTransferEncoding},
+		"/c": {hygieneDuplicateContentLength},
+		"/d": {hygieneContentLengthWithTransferEncoding, hygieneTransferEncodingHTTP10},
+		"/e": {hygieneObsFold},
+		"/f": {hygieneBareLF, hygieneUnexpectedBody},
+		"/g": nil,
+	}
+
+	// Byte by byte, so every state has to resume across reads.
+	var scanner requestHeadScanner
+	var heads []requestHead
+	for i := range len(stream) {
+		heads = append(heads, scanner.feed([]byte{stream[i]})...)
+	}
+	if len(heads) != len(want) {
+		t.Fatalf("expected %d heads, got %d: %+v", len(want), len(heads), heads)
+	}
+	for _, head := range heads {
+		if !slices.Equal(head.findings, want[head.target]) {
+			t.Errorf("%s %s: expected %v, got %v", head.method, head.target, want[head.target], head.findings)
+		}
+	}
+}
+
+func TestRequestHeadScannerStopsAfterUpgrade(t *testing.T) {
+	var scanner requestHeadScanner
+	heads := scanner.feed([]byte("GET /collaboration/ws HTTP/1.1\r\nHost: gw\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n\x81\x05hello\nPOST /x HTTP/1.1\r\n"))
+	if len(heads) != 1 || scanner.state != scanStopped {
+		t.Fatalf("expected WebSocket frames to stop the scanner, got %+v in state %d", heads, scanner.state)
+	}
+}
+
+func startHygieneServer(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewUnstartedServer(RequestHygieneMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), nil))
+	server.Listener = RequestHygieneListener(server.Listener)
+	server.Config.ConnContext = RequestHygieneConnContext
+	server.Start()
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+// sendRaw writes raw on a new connection and returns the status lines of
+// the responses, reading until the server closes the connection.
+func sendRaw(t *testing.T, addr, raw string) []string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(raw)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(conn)
+	var statuses []string
+	for {
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			return statuses
+		}
+		_ = resp.Body.Close()
+		statuses = append(statuses, resp.Status)
+		if resp.Close {
+			return statuses
+		}
+	}
+}
+
+func TestRequestHygieneMiddlewareRejectsAmbiguousFraming(t *testing.T) {
+	t.Setenv("GATEWAY_REQUEST_HYGIENE", "")
+	logs := captureAuditLogs(t)
+	addr := startHygieneServer(t)
+
+	// The clean requests ahead of the ambiguous one are answered; the
+	// connection ends with the rejection, so nothing smuggled after it runs.
+	statuses := sendRaw(t, addr, "GET /ok HTTP/1.1\r\nHost: gw\r\n\r\n"+
+		"GET /ok HTTP/1.1\nHost: gw\n\n"+
+		"POST /smuggle HTTP/1.1\r\nHost: gw\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"+
+		"GET /admin HTTP/1.1\r\nHost: gw\r\n\r\n")
+	if !slices.Equal(statuses, []string{"204 No Content", "204 No Content", "400 Bad Request"}) {
+		t.Fatalf("unexpected responses %v", statuses)
+	}
+	if statuses := sendRaw(t, addr, "GET /folded HTTP/1.1\r\nHost: gw\r\nX-Note: a\r\n\tb\r\n\r\n"); !slices.Equal(statuses, []string{"400 Bad Request"}) {
+		t.Fatalf("expected a folded header to be rejected, got %v", statuses)
+	}
+	if !strings.Contains(logs.String(), `"event":"gateway.http.request_hygiene"`) || !strings.Contains(logs.String(), hygieneContentLengthWithTransferEncoding) {
+		t.Fatalf("expected a request hygiene audit event, got %s", logs.String())
+	}
+	if strings.Contains(logs.String(), hygieneBareLF) {
+		t.Fatalf("expected bare LF to pass without an event outside strict, got %s", logs.String())
+	}
+}
+
+func TestRequestHygieneLevels(t *testing.T) {
+	t.Setenv("GATEWAY_REQUEST_HYGIENE", "log")
+	logs := captureAuditLogs(t)
+	addr := startHygieneServer(t)
+	if statuses := sendRaw(t, addr, "POST / HTTP/1.1\r\nHost: gw\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n0\r\n\r\n"); !slices.Equal(statuses, []string{"204 No Content"}) {
+		t.Fatalf("expected log to let the request through, got %v", statuses)
+	}
+	if !strings.Contains(logs.String(), `"rejected":false`) {
+		t.Fatalf("expected the finding to be recorded, got %s", logs.String())
+	}
+
+	t.Setenv("GATEWAY_REQUEST_HYGIENE", "strict")
+	addr = startHygieneServer(t)
+	if statuses := sendRaw(t, addr, "GET / HTTP/1.1\nHost: gw\n\n"); !slices.Equal(statuses, []string{"400 Bad Request"}) {
+		t.Fatalf("expected strict to reject bare LF, got %v", statuses)
+	}
+
+	t.Setenv("GATEWAY_REQUEST_HYGIENE", "off")
+	if listener := RequestHygieneListener(nil); listener != nil {
+		t.Fatal("expected off to leave the listener unwrapped")
+	}
+	t.Setenv("GATEWAY_REQUEST_HYGIENE", "lenient")
+	if err := ValidateRequestHygieneConfig(); err == nil {
+		t.Fatal("expected an unknown level to be rejected")
+	}
+}
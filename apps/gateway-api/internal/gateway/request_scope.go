@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+// requestScope is who a request was validated for: the tenant, the client app
+// it signed in through, the session serving it and the binding_id_hash of the
+// login behind that session. Handlers record it once validation succeeds, and
+// audit events, usage metering, bandwidth limits, tenant settings and upstream
+// headers read it from the context instead of parsing the request again.
+type requestScope struct {
+	TenantID      string
+	ClientApp     string
+	SessionID     string
+	BindingIDHash string
+}
+
+type requestScopeContextKey struct{}
+
+// withRequestScope adds the non-empty fields of scope to the scope already
+// carried by ctx.
+func withRequestScope(ctx context.Context, scope requestScope) context.Context {
+	current := requestScopeFrom(ctx)
+	merged := current
+	if scope.TenantID != "" {
+		merged.TenantID = scope.TenantID
+	}
+	if scope.ClientApp != "" {
+		merged.ClientApp = scope.ClientApp
+	}
+	if scope.SessionID != "" {
+		merged.SessionID = scope.SessionID
+	}
+	if scope.BindingIDHash != "" {
+		merged.BindingIDHash = scope.BindingIDHash
+	}
+	if merged == current {
+		return ctx
+	}
+	return context.WithValue(ctx, requestScopeContextKey{}, merged)
+}
+
+// requestScopeFrom returns the scope recorded in ctx; every field is empty
+// before validation.
+func requestScopeFrom(ctx context.Context) requestScope {
+	scope, _ := ctx.Value(requestScopeContextKey{}).(requestScope)
+	return scope
+}
+
+func (s requestScope) tenantHash() string {
+	return hashTenantID(s.TenantID)
+}
+
+// sessionHash hashes the session ID with the tenant's salt, as the
+// collaboration audit events always have.
+func (s requestScope) sessionHash() string {
+	if s.SessionID == "" {
+		return ""
+	}
+	return gatewayAuditLogger.HashTenantIdentity(s.TenantID, "session", s.SessionID)
+}
+
+// annotate adds the hashed scope to audit details, keeping any identifier the
+// caller already set.
+func (s requestScope) annotate(details map[string]any) map[string]any {
+	for key, value := range map[string]string{
+		"tenant_id_hash":  s.tenantHash(),
+		"client_app":      s.ClientApp,
+		"session_id_hash": s.sessionHash(),
+		"binding_id_hash": s.BindingIDHash,
+	} {
+		if value == "" {
+			continue
+		}
+		if details == nil {
+			details = map[string]any{}
+		}
+		if _, ok := details[key]; !ok {
+			details[key] = value
+		}
+	}
+	return details
+}
+
+// correlation returns the scope's correlation IDs for an audit event, or nil
+// when logger has no correlation key.
+func (s requestScope) correlation(logger *audit.Logger) map[string]string {
+	var ids map[string]string
+	for kind, value := range map[string]string{
+		audit.CorrelationTenant:  s.TenantID,
+		audit.CorrelationSession: s.SessionID,
+	} {
+		if id := logger.CorrelationID(kind, value); id != "" {
+			if ids == nil {
+				ids = map[string]string{}
+			}
+			ids[kind] = id
+		}
+	}
+	return ids
+}
+
+// setUpstreamHeaders names the validated tenant and session to an upstream,
+// replacing whatever the client sent in their place.
+func (s requestScope) setUpstreamHeaders(header http.Header) {
+	if s.TenantID != "" {
+		header.Set("X-Tenant-Id", s.TenantID)
+	}
+	if s.SessionID != "" {
+		header.Set("X-Session-Id", s.SessionID)
+	}
+}
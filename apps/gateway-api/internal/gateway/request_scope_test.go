@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+func TestWithRequestScopeMergesFields(t *testing.T) {
+	ctx := withRequestScope(context.Background(), requestScope{TenantID: "acme", BindingIDHash: "binding"})
+	ctx = withRequestScope(ctx, requestScope{SessionID: "session-1", ClientApp: "cli"})
+	want := requestScope{TenantID: "acme", ClientApp: "cli", SessionID: "session-1", BindingIDHash: "binding"}
+	if got := requestScopeFrom(ctx); got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	if same := withRequestScope(ctx, requestScope{TenantID: "acme"}); same != ctx {
+		t.Fatal("expected an unchanged scope to keep the context")
+	}
+	if got := requestScopeFrom(context.Background()); got != (requestScope{}) {
+		t.Fatalf("expected an empty scope before validation, got %+v", got)
+	}
+}
+
+func TestRequestScopeAnnotateKeepsCallerDetails(t *testing.T) {
+	scope := requestScope{TenantID: "acme", ClientApp: "cli", SessionID: "session-1"}
+	details := scope.annotate(map[string]any{"tenant_id_hash": "caller"})
+	if details["tenant_id_hash"] != "caller" {
+		t.Fatalf("expected the caller's tenant hash to win, got %v", details["tenant_id_hash"])
+	}
+	if details["client_app"] != "cli" || details["session_id_hash"] != gatewayAuditLogger.HashTenantIdentity("acme", "session", "session-1") {
+		t.Fatalf("expected the scope to be added, got %v", details)
+	}
+	if _, ok := details["binding_id_hash"]; ok {
+		t.Fatalf("expected empty fields to be left out, got %v", details)
+	}
+	if details := (requestScope{}).annotate(nil); details != nil {
+		t.Fatalf("expected an empty scope to add nothing, got %v", details)
+	}
+}
+
+func TestCollaborationAuditCarriesCorrelationIDs(t *testing.T) {
+	key := strings.Repeat("c", 32)
+	t.Setenv("GATEWAY_AUDIT_CORRELATION_KEY", key)
+	logs := captureAuditLogs(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/collaboration/ws?filePath=a.md", nil)
+	ctx := withRequestScope(req.Context(), requestScope{TenantID: "acme", SessionID: "session-1"})
+	recordCollaborationAudit(ctx, req, auditOutcomeSuccess, map[string]any{"reason": "authorized"})
+
+	var record struct {
+		Correlation map[string]string `json:"correlation"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatalf("invalid audit record %q: %v", logs.String(), err)
+	}
+	want := map[string]string{
+		audit.CorrelationTenant:  audit.CorrelationID([]byte(key), audit.CorrelationTenant, "acme"),
+		audit.CorrelationSession: audit.CorrelationID([]byte(key), audit.CorrelationSession, "session-1"),
+	}
+	if len(record.Correlation) != 2 || record.Correlation[audit.CorrelationTenant] != want[audit.CorrelationTenant] || record.Correlation[audit.CorrelationSession] != want[audit.CorrelationSession] {
+		t.Fatalf("expected correlation IDs %v, got %v", want, record.Correlation)
+	}
+}
+
+func TestCollaborationProxyInjectsRequestScope(t *testing.T) {
+	useForwardedHeaders(t, nil)
+	var received http.Header
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer orchestrator.Close()
+	t.Setenv("ORCHESTRATOR_URL", orchestrator.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+
+	req := httptest.NewRequest(http.MethodGet, "/collaboration/ws?filePath=a.md", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-Tenant-Id", "globex")
+	req = req.WithContext(withRequestScope(req.Context(), requestScope{TenantID: "acme", SessionID: "session-1"}))
+	collaborationHeaderMiddleware(newCollaborationProxy()).ServeHTTP(httptest.NewRecorder(), req)
+	if received.Get("X-Tenant-Id") != "acme" || received.Get("X-Session-Id") != "session-1" {
+		t.Fatalf("expected the validated scope upstream, got %v", received)
+	}
+}
+
+func TestUsageTenantHashPrefersRequestScope(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/plan/p/artifacts/a", nil)
+	req.Header.Set("X-Tenant-Id", "globex")
+	if got := usageTenantHash(req.Context(), req); got != hashTenantID("globex") {
+		t.Fatalf("expected the header tenant without a scope, got %q", got)
+	}
+	ctx := withRequestScope(req.Context(), requestScope{TenantID: "acme"})
+	if got := usageTenantHash(ctx, req); got != hashTenantID("acme") {
+		t.Fatalf("expected the scoped tenant, got %q", got)
+	}
+}
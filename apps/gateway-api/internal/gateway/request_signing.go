@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/requestsign"
+)
+
+var errRequestSigningNotConfigured = errors.New("request signing is not configured")
+
+var (
+	requestSignerMu   sync.Mutex
+	requestSignerOnce sync.Once
+	requestSigner     *requestsign.Signer
+	requestSignerErr  error
+)
+
+// resetRequestSigner clears the cached signer for tests.
+func resetRequestSigner() {
+	requestSignerMu.Lock()
+	defer requestSignerMu.Unlock()
+	requestSignerOnce = sync.Once{}
+	requestSigner = nil
+	requestSignerErr = nil
+}
+
+// loadRequestSigner returns the signer for requests the gateway originates,
+// such as webhook deliveries and mirrored traffic, configured by
+// GATEWAY_REQUEST_SIGNING_KEY (or _FILE), GATEWAY_REQUEST_SIGNING_ALGORITHM
+// and GATEWAY_REQUEST_SIGNING_KEY_ID. It returns
+// errRequestSigningNotConfigured without a key.
+func loadRequestSigner() (*requestsign.Signer, error) {
+	requestSignerMu.Lock()
+	defer requestSignerMu.Unlock()
+	requestSignerOnce.Do(func() {
+		requestSigner, requestSignerErr = parseRequestSigner()
+	})
+	return requestSigner, requestSignerErr
+}
+
+func parseRequestSigner() (*requestsign.Signer, error) {
+	key, err := ResolveEnvValue("GATEWAY_REQUEST_SIGNING_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_REQUEST_SIGNING_KEY: %w", err)
+	}
+	if key == "" {
+		return nil, errRequestSigningNotConfigured
+	}
+	keyID := strings.TrimSpace(GetEnv("GATEWAY_REQUEST_SIGNING_KEY_ID", ""))
+	switch algorithm := strings.ToLower(strings.TrimSpace(GetEnv("GATEWAY_REQUEST_SIGNING_ALGORITHM", requestsign.AlgorithmHMACSHA256))); algorithm {
+	case requestsign.AlgorithmHMACSHA256:
+		if keyID == "" {
+			keyID = requestSigningKeyID([]byte(key))
+		}
+		signer, err := requestsign.NewHMACSigner(keyID, []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("GATEWAY_REQUEST_SIGNING_KEY: %w", err)
+		}
+		return signer, nil
+	case requestsign.AlgorithmEd25519:
+		private, err := parseEd25519PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("GATEWAY_REQUEST_SIGNING_KEY: %w", err)
+		}
+		if keyID == "" {
+			keyID = requestSigningKeyID(private.Public().(ed25519.PublicKey))
+		}
+		signer, err := requestsign.NewEd25519Signer(keyID, private)
+		if err != nil {
+			return nil, fmt.Errorf("GATEWAY_REQUEST_SIGNING_KEY_ID: %w", err)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported GATEWAY_REQUEST_SIGNING_ALGORITHM %q (expected %s or %s)", algorithm, requestsign.AlgorithmHMACSHA256, requestsign.AlgorithmEd25519)
+	}
+}
+
+// requestSigningKeyID derives a key ID from the HMAC secret or Ed25519 public
+// key, so it changes with every rotation without being configured.
+func requestSigningKeyID(material []byte) string {
+	sum := sha256.Sum256(material)
+	return hex.EncodeToString(sum[:8])
+}
+
+// parseEd25519PrivateKey accepts a PKCS #8 PEM block or the base64 encoding
+// of a 32-byte seed or 64-byte private key.
+func parseEd25519PrivateKey(raw string) (ed25519.PrivateKey, error) {
+	if block, _ := pem.Decode([]byte(raw)); block != nil {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("PEM key is not an Ed25519 private key")
+		}
+		return key, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errors.New("must be a PKCS #8 PEM key or base64")
+	}
+	switch len(decoded) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(decoded), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(decoded), nil
+	default:
+		return nil, fmt.Errorf("base64 Ed25519 key must decode to %d or %d bytes", ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+}
+
+// ValidateRequestSigningConfig checks the GATEWAY_REQUEST_SIGNING_* settings
+// at startup. Signing is optional, so an unset key is not an error.
+func ValidateRequestSigningConfig() error {
+	_, err := loadRequestSigner()
+	if errors.Is(err, errRequestSigningNotConfigured) {
+		return nil
+	}
+	return err
+}
+
+// signedTransport wraps base so every request carries the gateway's request
+// signature. Subsystems sending requests on the gateway's own initiative use
+// it so receivers can verify them with requestsign.Verifier; requests go out
+// unsigned when no key is configured.
+func signedTransport(base http.RoundTripper) http.RoundTripper {
+	signer, err := loadRequestSigner()
+	if err != nil {
+		return base
+	}
+	return signer.Transport(base)
+}
@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/requestsign"
+)
+
+func useRequestSigningEnv(t *testing.T, algorithm, key, keyID string) {
+	t.Helper()
+	t.Setenv("GATEWAY_REQUEST_SIGNING_ALGORITHM", algorithm)
+	t.Setenv("GATEWAY_REQUEST_SIGNING_KEY", key)
+	t.Setenv("GATEWAY_REQUEST_SIGNING_KEY_ID", keyID)
+	resetRequestSigner()
+	t.Cleanup(resetRequestSigner)
+}
+
+func TestSignedTransportSignsWithConfiguredKey(t *testing.T) {
+	secret := strings.Repeat("s", requestsign.MinHMACKeyBytes)
+	useRequestSigningEnv(t, "", secret, "webhooks-2026")
+	verifier := &requestsign.Verifier{Keys: map[string]requestsign.VerificationKey{"webhooks-2026": requestsign.HMACKey([]byte(secret))}}
+	server := httptest.NewServer(verifier.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	defer server.Close()
+
+	client := &http.Client{Transport: signedTransport(http.DefaultTransport)}
+	resp, err := client.Post(server.URL+"/hooks", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected signed request to verify, got %d", resp.StatusCode)
+	}
+}
+
+func TestLoadRequestSignerParsesEd25519Keys(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	keys := map[string]string{
+		"pem":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"seed": base64.StdEncoding.EncodeToString(private.Seed()),
+	}
+	for name, key := range keys {
+		t.Run(name, func(t *testing.T) {
+			useRequestSigningEnv(t, "ed25519", key, "")
+			signer, err := loadRequestSigner()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !signer.PublicKey().Equal(public) || signer.KeyID() != requestSigningKeyID(public) {
+				t.Fatalf("unexpected signer %s with key id %s", signer.Algorithm(), signer.KeyID())
+			}
+		})
+	}
+}
+
+func TestValidateRequestSigningConfig(t *testing.T) {
+	useRequestSigningEnv(t, "", "", "")
+	if err := ValidateRequestSigningConfig(); err != nil {
+		t.Fatalf("expected signing to be optional, got %v", err)
+	}
+	if transport := signedTransport(http.DefaultTransport); transport != http.DefaultTransport {
+		t.Fatal("expected requests to go out unsigned without a key")
+	}
+
+	cases := map[string][2]string{
+		"short hmac key":    {"", "short"},
+		"unknown algorithm": {"rsa", strings.Repeat("s", requestsign.MinHMACKeyBytes)},
+		"bad ed25519 key":   {"ed25519", "not a key"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			useRequestSigningEnv(t, tc[0], tc[1], "")
+			if err := ValidateRequestSigningConfig(); err == nil {
+				t.Fatal("expected configuration error")
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// upstreamURLRewriter replaces absolute orchestrator URLs in JSON responses
+// with the gateway's public base so clients never see addresses they cannot
+// reach.
+type upstreamURLRewriter struct {
+	// internalBases returns the URL prefixes to replace, without trailing
+	// slashes. It is evaluated per response so rebuilt orchestrator clients
+	// are honoured.
+	internalBases  func() []string
+	trustedProxies []*net.IPNet
+	maxBytes       int64
+}
+
+// loadUpstreamURLRewriter returns nil when GATEWAY_REWRITE_UPSTREAM_URLS is
+// false. Internal bases are the orchestrator URL, the SSE failover replicas
+// and GATEWAY_REWRITE_INTERNAL_URLS.
+func loadUpstreamURLRewriter(trustedProxies []*net.IPNet) (*upstreamURLRewriter, error) {
+	enabled, err := strconv.ParseBool(GetEnv("GATEWAY_REWRITE_UPSTREAM_URLS", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("GATEWAY_REWRITE_UPSTREAM_URLS must be a boolean: %w", err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	extra, err := parseBaseURLs("GATEWAY_REWRITE_INTERNAL_URLS", GetEnv("GATEWAY_REWRITE_INTERNAL_URLS", ""))
+	if err != nil {
+		return nil, err
+	}
+	replicas, err := parseBaseURLs("GATEWAY_SSE_FAILOVER_URLS", GetEnv("GATEWAY_SSE_FAILOVER_URLS", ""))
+	if err != nil {
+		return nil, err
+	}
+	extra = append(extra, replicas...)
+	if err := ValidatePublicBaseURL(); err != nil {
+		return nil, err
+	}
+	return &upstreamURLRewriter{
+		internalBases: func() []string {
+			return append([]string{orchestratorBaseURL()}, extra...)
+		},
+		trustedProxies: trustedProxies,
+		maxBytes:       maxUpstreamResponseBytes(),
+	}, nil
+}
+
+func parseBaseURLs(key, raw string) ([]string, error) {
+	var bases []string
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimRight(strings.TrimSpace(entry), "/")
+		if entry == "" {
+			continue
+		}
+		parsed, err := url.Parse(entry)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("%s entry %q must be an absolute http(s) URL", key, entry)
+		}
+		bases = append(bases, entry)
+	}
+	return bases, nil
+}
+
+// rewriteURL replaces a leading internal base in value with publicBase.
+func rewriteURL(value string, internalBases []string, publicBase string) (string, bool) {
+	for _, base := range internalBases {
+		if base == "" || len(value) < len(base) || !strings.EqualFold(value[:len(base)], base) {
+			continue
+		}
+		rest := value[len(base):]
+		if rest == "" || strings.ContainsRune("/?#", rune(rest[0])) {
+			return publicBase + rest, true
+		}
+	}
+	return value, false
+}
+
+// rewriteJSONStrings rewrites every string value, but not object keys, in a
+// JSON document. Key order and number formatting are preserved. It returns
+// the original body when nothing changed.
+func rewriteJSONStrings(body []byte, rewrite func(string) (string, bool)) ([]byte, error) {
+	type frame struct {
+		object    bool
+		count     int
+		expectKey bool
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var (
+		out     bytes.Buffer
+		stack   []frame
+		changed bool
+	)
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	writeString := func(s string) {
+		_ = enc.Encode(s)
+		out.Truncate(out.Len() - 1) // Encode appends a newline.
+	}
+	// beforeValue writes the separator preceding a value or key and reports
+	// whether the token is an object key.
+	beforeValue := func() bool {
+		if len(stack) == 0 {
+			return false
+		}
+		top := &stack[len(stack)-1]
+		if top.object {
+			if top.expectKey {
+				if top.count > 0 {
+					out.WriteByte(',')
+				}
+				top.expectKey = false
+				return true
+			}
+			out.WriteByte(':')
+			top.count++
+			top.expectKey = true
+			return false
+		}
+		if top.count > 0 {
+			out.WriteByte(',')
+		}
+		top.count++
+		return false
+	}
+	for {
+		token, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			if len(stack) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch value := token.(type) {
+		case json.Delim:
+			switch value {
+			case '{', '[':
+				beforeValue()
+				stack = append(stack, frame{object: value == '{', expectKey: value == '{'})
+			default:
+				stack = stack[:len(stack)-1]
+			}
+			out.WriteRune(rune(value))
+		case string:
+			if beforeValue() {
+				writeString(value)
+				continue
+			}
+			if rewritten, ok := rewrite(value); ok {
+				value, changed = rewritten, true
+			}
+			writeString(value)
+		case json.Number:
+			beforeValue()
+			out.WriteString(value.String())
+		case bool:
+			beforeValue()
+			out.WriteString(strconv.FormatBool(value))
+		case nil:
+			beforeValue()
+			out.WriteString("null")
+		}
+	}
+	if !changed {
+		return body, nil
+	}
+	return out.Bytes(), nil
+}
+
+// middleware buffers complete JSON responses from next and rewrites internal
+// URLs in them. Partial content, non-JSON and oversized responses pass
+// through untouched. When errorsOnly is set only error responses are
+// rewritten, for routes whose successful bodies are user content.
+func (rw *upstreamURLRewriter) middleware(next http.Handler, errorsOnly bool) http.Handler {
+	if rw == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capture := &rewriteResponseWriter{ResponseWriter: w, limit: rw.maxBytes, errorsOnly: errorsOnly}
+		next.ServeHTTP(capture, r)
+		if !capture.buffering {
+			return
+		}
+		body := capture.buf.Bytes()
+		publicBase := publicBaseFor(r, rw.trustedProxies)
+		bases := rw.internalBases()
+		if rewritten, err := rewriteJSONStrings(body, func(value string) (string, bool) {
+			return rewriteURL(value, bases, publicBase)
+		}); err == nil {
+			body = rewritten
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(capture.status)
+		_, _ = w.Write(body)
+	})
+}
+
+// rewriteResponseWriter holds back a JSON response until the handler
+// finishes, falling back to passing it through once it exceeds limit.
+type rewriteResponseWriter struct {
+	http.ResponseWriter
+	limit       int64
+	errorsOnly  bool
+	status      int
+	wroteHeader bool
+	buffering   bool
+	buf         bytes.Buffer
+}
+
+func (c *rewriteResponseWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	c.status = status
+	header := c.Header()
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	eligible := isJSON && header.Get("Content-Range") == "" && header.Get("Content-Encoding") == "" &&
+		status != http.StatusNoContent && status != http.StatusNotModified && (!c.errorsOnly || status >= 400)
+	if eligible {
+		c.buffering = true
+		header.Del("Content-Length")
+		return
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *rewriteResponseWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.buffering {
+		return c.ResponseWriter.Write(p)
+	}
+	if int64(c.buf.Len()+len(p)) <= c.limit {
+		return c.buf.Write(p)
+	}
+	// Too large to rewrite: send what was held back and stream the rest.
+	c.buffering = false
+	c.ResponseWriter.WriteHeader(c.status)
+	if _, err := c.ResponseWriter.Write(c.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	c.buf.Reset()
+	return c.ResponseWriter.Write(p)
+}
+
+func (c *rewriteResponseWriter) Flush() {
+	if c.buffering {
+		return
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (c *rewriteResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
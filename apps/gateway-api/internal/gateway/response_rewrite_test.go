@@ -0,0 +1,113 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTestRewriter(t *testing.T, trusted ...string) *upstreamURLRewriter {
+	t.Helper()
+	networks, err := ParseTrustedProxyCIDRs(trusted)
+	if err != nil {
+		t.Fatalf("parse trusted proxies: %v", err)
+	}
+	return &upstreamURLRewriter{
+		internalBases:  func() []string { return []string{"http://orchestrator:4000", "https://orch-b.internal"} },
+		trustedProxies: networks,
+		maxBytes:       1 << 10,
+	}
+}
+
+func TestRewriteJSONStringsPreservesDocument(t *testing.T) {
+	body := []byte(`{"b":"http://orchestrator:4000/plan/1","a":[1.50,true,null,{"http://orchestrator:4000":"HTTPS://ORCH-B.INTERNAL?x=1"}],"c":"http://orchestrator:40001/x","d":"<tag>"}`)
+	got, err := rewriteJSONStrings(body, func(value string) (string, bool) {
+		return rewriteURL(value, []string{"http://orchestrator:4000", "https://orch-b.internal"}, "https://gw.example.com")
+	})
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	want := `{"b":"https://gw.example.com/plan/1","a":[1.50,true,null,{"http://orchestrator:4000":"https://gw.example.com?x=1"}],"c":"http://orchestrator:40001/x","d":"<tag>"}`
+	if string(got) != want {
+		t.Fatalf("unexpected rewrite:\n got %s\nwant %s", got, want)
+	}
+
+	unchanged := []byte(`{ "a" : "no urls" }`)
+	got, err = rewriteJSONStrings(unchanged, func(string) (string, bool) { return "", false })
+	if err != nil || string(got) != string(unchanged) {
+		t.Fatalf("expected untouched body, got %q (%v)", got, err)
+	}
+	if _, err := rewriteJSONStrings([]byte(`{"a":`), func(string) (string, bool) { return "", false }); err == nil {
+		t.Fatal("expected malformed JSON to fail")
+	}
+}
+
+func TestUpstreamURLRewriterMiddleware(t *testing.T) {
+	rewriter := newTestRewriter(t)
+	respond := func(status int, contentType, body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		})
+	}
+	large := `{"u":"http://orchestrator:4000/x","pad":"` + strings.Repeat("a", 2<<10) + `"}`
+	tests := []struct {
+		name        string
+		handler     http.Handler
+		errorsOnly  bool
+		wantBody    string
+		wantRewrite bool
+	}{
+		{name: "json", handler: respond(http.StatusCreated, "application/json; charset=utf-8", `{"self":"http://orchestrator:4000/plan/1"}`), wantBody: `{"self":"http://gateway.local/plan/1"}`, wantRewrite: true},
+		{name: "problem json error", handler: respond(http.StatusNotFound, "application/problem+json", `{"docs":"https://orch-b.internal/help"}`), errorsOnly: true, wantBody: `{"docs":"http://gateway.local/help"}`, wantRewrite: true},
+		{name: "success with errors only", handler: respond(http.StatusOK, "application/json", `{"self":"http://orchestrator:4000/plan/1"}`), errorsOnly: true, wantBody: `{"self":"http://orchestrator:4000/plan/1"}`},
+		{name: "not json", handler: respond(http.StatusOK, "text/plain", "http://orchestrator:4000/plan/1"), wantBody: "http://orchestrator:4000/plan/1"},
+		{name: "too large", handler: respond(http.StatusOK, "application/json", large), wantBody: large},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rewriter.middleware(tc.handler, tc.errorsOnly).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://gateway.local/x", nil))
+			if got := rec.Body.String(); got != tc.wantBody {
+				t.Fatalf("expected body %q, got %q", tc.wantBody, got)
+			}
+			if tc.wantRewrite && rec.Header().Get("Content-Length") != strconv.Itoa(len(tc.wantBody)) {
+				t.Fatalf("expected Content-Length to match rewritten body, got %q", rec.Header().Get("Content-Length"))
+			}
+		})
+	}
+
+	var disabled *upstreamURLRewriter
+	handler := respond(http.StatusOK, "application/json", `{}`)
+	if got := disabled.middleware(handler, false); got == nil {
+		t.Fatal("expected nil rewriter to return the handler")
+	}
+}
+
+func TestLoadUpstreamURLRewriter(t *testing.T) {
+	t.Setenv("GATEWAY_REWRITE_UPSTREAM_URLS", "false")
+	if rewriter, err := loadUpstreamURLRewriter(nil); err != nil || rewriter != nil {
+		t.Fatalf("expected rewriting to be disabled, got %v (%v)", rewriter, err)
+	}
+
+	t.Setenv("GATEWAY_REWRITE_UPSTREAM_URLS", "true")
+	t.Setenv("GATEWAY_REWRITE_INTERNAL_URLS", "http://orch-a:4000/, http://orch-b:4000")
+	t.Setenv("GATEWAY_SSE_FAILOVER_URLS", "")
+	rewriter, err := loadUpstreamURLRewriter(nil)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	bases := rewriter.internalBases()
+	if len(bases) != 3 || bases[1] != "http://orch-a:4000" || bases[2] != "http://orch-b:4000" {
+		t.Fatalf("unexpected internal bases %v", bases)
+	}
+
+	t.Setenv("GATEWAY_PUBLIC_BASE_URL", "gw.example.com")
+	if _, err := loadUpstreamURLRewriter(nil); err == nil {
+		t.Fatal("expected relative public base to be rejected")
+	}
+}
@@ -0,0 +1,259 @@
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+const (
+	revocationsPath             = "/internal/revocations"
+	auditEventSessionRevocation = "session.revocation"
+	auditTargetSessionRevoke    = "session.revocation"
+
+	maxRevocationIdentifiers        = 100
+	maxRevocationBodyBytes          = 64 * 1024
+	defaultRevocationFailureLimit   = 10
+	defaultRevocationFailureWindow  = time.Minute
+	maxRevokedSessionIDLen          = 256
+	revocationAuditIdentifierSample = 10
+)
+
+// RevocationRouteConfig captures configuration for the revocation receiver.
+type RevocationRouteConfig struct {
+	TrustedProxyCIDRs []string
+}
+
+// sessionRevocation names sessions the orchestrator revoked, by the audit
+// hash of their ID and by the binding_id_hash of the login behind them.
+type sessionRevocation struct {
+	SessionHashes []string
+	BindingHashes []string
+}
+
+func (s sessionRevocation) matches(sessionHash, bindingHash string) bool {
+	for _, hash := range s.SessionHashes {
+		if sessionHash != "" && hash == sessionHash {
+			return true
+		}
+	}
+	for _, hash := range s.BindingHashes {
+		if bindingHash != "" && hash == bindingHash {
+			return true
+		}
+	}
+	return false
+}
+
+var errRevocationBodyTooLarge = errors.New("request body too large")
+
+// revocationRequest is the body of POST /internal/revocations.
+type revocationRequest struct {
+	SessionIDs      []string `json:"session_ids"`
+	BindingIDHashes []string `json:"binding_id_hashes"`
+}
+
+// RegisterRevocationRoutes wires the orchestrator's session revocation push
+// into mux. It is only registered when GATEWAY_REVOCATION_TOKEN is set.
+func RegisterRevocationRoutes(mux *http.ServeMux, cfg RevocationRouteConfig) {
+	token, err := ResolveEnvValue("GATEWAY_REVOCATION_TOKEN")
+	if err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("failed to load GATEWAY_REVOCATION_TOKEN: %v", err))
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return
+	}
+	trustedProxies, err := ParseTrustedProxyCIDRs(cfg.TrustedProxyCIDRs)
+	if err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("invalid trusted proxy configuration: %v", err))
+	}
+	failureLimiter := newPersistentRateLimiter("revocation.auth_failure")
+	failureBucket := rateLimitBucket{
+		Endpoint:     "revocation.auth_failure",
+		IdentityType: "ip",
+		Limit:        ResolveLimit([]string{"GATEWAY_REVOCATION_AUTH_FAILURE_LIMIT"}, defaultRevocationFailureLimit),
+		Window:       ResolveDuration([]string{"GATEWAY_REVOCATION_AUTH_FAILURE_WINDOW"}, defaultRevocationFailureWindow),
+	}
+	handle(mux, "POST "+revocationsPath, revocationAuthMiddleware(token, failureLimiter, failureBucket, trustedProxies, http.HandlerFunc(serveRevocations)), policyRevocationToken, policyLockout)
+}
+
+func revocationAuthMiddleware(token string, limiter *rateLimiter, bucket rateLimitBucket, trusted []*net.IPNet, next http.Handler) http.Handler {
+	expected := []byte(token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if updated, _ := audit.EnsureRequestID(r, w); updated != nil {
+			r = updated
+		}
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(provided)), expected) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := ClientIP(r, trusted)
+		allowed, retryAfter, err := limiter.Allow(r.Context(), bucket, ip)
+		if err == nil && !allowed {
+			recordRevocationAudit(r, auditOutcomeDenied, map[string]any{
+				"reason":              "auth_rate_limited",
+				"client_ip_hash":      gatewayAuditLogger.HashIdentity(ip),
+				"retry_after_seconds": retryAfterToSeconds(retryAfter),
+			})
+			respondTooManyRequests(w, r, retryAfter)
+			return
+		}
+		recordRevocationAudit(r, auditOutcomeDenied, map[string]any{"reason": "invalid_token", "client_ip_hash": gatewayAuditLogger.HashIdentity(ip)})
+		w.Header().Set("WWW-Authenticate", `Bearer realm="revocations"`)
+		writeErrorResponse(w, r, http.StatusUnauthorized, "unauthorized", "bearer token required", nil)
+	})
+}
+
+// serveRevocations records the revocation in the session cache before
+// answering, so no replica sharing the store accepts a cached entry for the
+// sessions afterwards, then closes this replica's streams for them.
+// Streams on other replicas end at their next session revalidation unless
+// the orchestrator pushes to every replica.
+func serveRevocations(w http.ResponseWriter, r *http.Request) {
+	revocation, err := decodeRevocationRequest(w, r)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errRevocationBodyTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		recordRevocationAudit(r, auditOutcomeDenied, map[string]any{"reason": "invalid_request", "error": err.Error()})
+		writeErrorResponse(w, r, status, "invalid_request", err.Error(), nil)
+		return
+	}
+	if cache, _ := loadCollaborationSessionCache(); cache != nil {
+		if err := cache.revoke(r.Context(), revocation); err != nil {
+			slog.WarnContext(r.Context(), "session revocation could not be recorded in the session cache", slog.Any("error", err))
+			recordRevocationAudit(r, auditOutcomeFailure, map[string]any{"reason": "cache_unavailable", "error": err.Error()})
+			writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "failed to record revocation", nil)
+			return
+		}
+	}
+	publish(gatewayEvents, topicSessionsRevoked, revocation)
+	recordRevocationAudit(r, auditOutcomeSuccess, map[string]any{
+		"session_id_hashes": sampleHashes(revocation.SessionHashes),
+		"binding_id_hashes": sampleHashes(revocation.BindingHashes),
+		"session_count":     len(revocation.SessionHashes),
+		"binding_count":     len(revocation.BindingHashes),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]int{
+		"sessions": len(revocation.SessionHashes),
+		"bindings": len(revocation.BindingHashes),
+	})
+}
+
+func decodeRevocationRequest(w http.ResponseWriter, r *http.Request) (sessionRevocation, error) {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRevocationBodyBytes))
+	decoder.DisallowUnknownFields()
+	var req revocationRequest
+	if err := decoder.Decode(&req); err != nil {
+		if requestBodyTooLarge(err) {
+			return sessionRevocation{}, errRevocationBodyTooLarge
+		}
+		return sessionRevocation{}, errors.New("body must be a JSON object with session_ids and binding_id_hashes")
+	}
+	if len(req.SessionIDs) == 0 && len(req.BindingIDHashes) == 0 {
+		return sessionRevocation{}, errors.New("session_ids or binding_id_hashes is required")
+	}
+	if len(req.SessionIDs)+len(req.BindingIDHashes) > maxRevocationIdentifiers {
+		return sessionRevocation{}, fmt.Errorf("at most %d identifiers may be revoked at once", maxRevocationIdentifiers)
+	}
+	var revocation sessionRevocation
+	for _, id := range req.SessionIDs {
+		id = strings.TrimSpace(id)
+		if id == "" || len(id) > maxRevokedSessionIDLen || hasUnsafeHeaderRunes(id) {
+			return sessionRevocation{}, errors.New("session_ids must be non-empty printable strings")
+		}
+		revocation.SessionHashes = append(revocation.SessionHashes, gatewayAuditLogger.HashIdentity("session", id))
+	}
+	for _, hash := range req.BindingIDHashes {
+		hash = strings.TrimSpace(hash)
+		if !validBindingHash(hash) {
+			return sessionRevocation{}, errors.New("binding_id_hashes must be binding_id_hash values")
+		}
+		revocation.BindingHashes = append(revocation.BindingHashes, hash)
+	}
+	return revocation, nil
+}
+
+// sampleHashes bounds how many identifiers one audit event lists.
+func sampleHashes(hashes []string) []string {
+	if len(hashes) > revocationAuditIdentifierSample {
+		return hashes[:revocationAuditIdentifierSample]
+	}
+	return hashes
+}
+
+func recordRevocationAudit(r *http.Request, outcome string, details map[string]any) {
+	actor := hashedActorFromRequest(r, nil, "orchestrator")
+	event := audit.Event{
+		Name:       auditEventSessionRevocation,
+		Outcome:    outcome,
+		Target:     auditTargetSessionRevoke,
+		Capability: auditTargetSessionRevoke,
+		ActorID:    actor,
+		Details:    auditDetails(details),
+	}
+	ctx := audit.WithActor(r.Context(), actor)
+	if outcome == auditOutcomeSuccess {
+		gatewayAuditLogger.Info(ctx, event)
+		return
+	}
+	gatewayAuditLogger.Security(ctx, event)
+}
+
+// revokedKey marks a revoked session or binding in the session cache.
+func revokedKey(kind, hash string) string {
+	return "revoked:" + kind + ":" + hash
+}
+
+// revoke records the revocation for as long as a cached entry can live.
+func (c *collaborationSessionCache) revoke(ctx context.Context, revocation sessionRevocation) error {
+	for _, hash := range revocation.SessionHashes {
+		if err := c.store.Set(ctx, revokedKey("session", hash), []byte("1"), c.ttl); err != nil {
+			return err
+		}
+	}
+	for _, hash := range revocation.BindingHashes {
+		if err := c.store.Set(ctx, revokedKey("binding", hash), []byte("1"), c.ttl); err != nil {
+			return err
+		}
+	}
+	c.revocations.Add(int64(len(revocation.SessionHashes) + len(revocation.BindingHashes)))
+	return nil
+}
+
+// revoked reports whether a cached session was revoked after it was cached.
+// A store error counts as revoked so the session is checked upstream.
+func (c *collaborationSessionCache) revoked(ctx context.Context, session orchestratorSession) bool {
+	keys := []string{revokedKey("session", gatewayAuditLogger.HashIdentity("session", session.ID))}
+	if session.BindingIDHash != "" {
+		keys = append(keys, revokedKey("binding", session.BindingIDHash))
+	}
+	for _, key := range keys {
+		_, err := c.store.Get(ctx, key)
+		if err == nil {
+			return true
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return true
+		}
+	}
+	return false
+}
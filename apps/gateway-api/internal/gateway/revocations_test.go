@@ -0,0 +1,207 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRevocationToken = "revocation-token-0123456789"
+
+func postRevocation(mux *http.ServeMux, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, revocationsPath, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRevocationRoutesRequireToken(t *testing.T) {
+	t.Setenv("GATEWAY_REVOCATION_TOKEN", "")
+	mux := http.NewServeMux()
+	RegisterRevocationRoutes(mux, RevocationRouteConfig{})
+	if rec := postRevocation(mux, "", `{"session_ids":["s1"]}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected the route to be absent without a token, got %d", rec.Code)
+	}
+
+	logs := captureAuditLogs(t)
+	t.Setenv("GATEWAY_REVOCATION_TOKEN", testRevocationToken)
+	mux = http.NewServeMux()
+	RegisterRevocationRoutes(mux, RevocationRouteConfig{})
+	rec := postRevocation(mux, "wrong", `{"session_ids":["s1"]}`)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected 401 with a challenge, got %d", rec.Code)
+	}
+	if !strings.Contains(logs.String(), `"event":"session.revocation"`) || !strings.Contains(logs.String(), `"reason":"invalid_token"`) {
+		t.Fatalf("expected the rejection to be audited, got %s", logs.String())
+	}
+}
+
+func TestRevocationRequestValidation(t *testing.T) {
+	t.Setenv("GATEWAY_REVOCATION_TOKEN", testRevocationToken)
+	mux := http.NewServeMux()
+	RegisterRevocationRoutes(mux, RevocationRouteConfig{})
+	cases := map[string]string{
+		"empty":         `{}`,
+		"not json":      `session_ids=s1`,
+		"unknown field": `{"session_ids":["s1"],"tokens":["t"]}`,
+		"blank id":      `{"session_ids":[" "]}`,
+		"bad hash":      `{"binding_id_hashes":["not-a-hash"]}`,
+		"too many":      `{"session_ids":["s"` + strings.Repeat(`,"s"`, maxRevocationIdentifiers) + `]}`,
+		"control in id": `{"session_ids":["s\u0001"]}`,
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			if rec := postRevocation(mux, testRevocationToken, body); rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRevocationsBypassCachedSessions(t *testing.T) {
+	t.Setenv("GATEWAY_REVOCATION_TOKEN", testRevocationToken)
+	useCollaborationSessionCache(t, "cached")
+	cache, err := loadCollaborationSessionCache()
+	if err != nil || cache == nil {
+		t.Fatalf("load cache: %v", err)
+	}
+	lookup := &countingLookup{status: http.StatusOK}
+	cache.lookup = lookup.lookup
+	ctx := context.Background()
+	_, _, _ = cache.validate(ctx, "Bearer token", "", "req")
+
+	mux := http.NewServeMux()
+	RegisterRevocationRoutes(mux, RevocationRouteConfig{})
+	rec := postRevocation(mux, testRevocationToken, `{"session_ids":["session-1"],"binding_id_hashes":["`+hashSessionBinding("bind-1")+`"]}`)
+	if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), `"sessions":1`) || !strings.Contains(rec.Body.String(), `"bindings":1`) {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	_, _, _ = cache.validate(ctx, "Bearer token", "", "req")
+	if lookup.calls != 2 {
+		t.Fatalf("expected the revoked session to be looked up again, got %d lookups", lookup.calls)
+	}
+	if stats := cache.stats(); stats.Revocations != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCollaborationPresenceClosesRevokedSessions(t *testing.T) {
+	bus := newEventBus(4)
+	presence := newCollaborationPresence()
+	presence.subscribe(bus)
+	bindingHash := hashSessionBinding("bind-1")
+	connect := func(sessionID, bindingHash string) net.Conn {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		member, leave := presence.join(collaborationRoom{TenantID: "acme", ProjectID: "web", FilePath: "docs/a.md"}, sessionID, bindingHash)
+		t.Cleanup(leave)
+		member.conn = &presenceConn{Conn: server}
+		return client
+	}
+	bySession, byBinding, other := connect("session-1", ""), connect("session-2", bindingHash), connect("session-3", "")
+
+	publish(bus, topicSessionsRevoked, sessionRevocation{
+		SessionHashes: []string{gatewayAuditLogger.HashIdentity("session", "session-1")},
+		BindingHashes: []string{bindingHash},
+	})
+	// Pipe writes block until read, and connections are closed in no
+	// particular order, so both clients are read at once.
+	want := websocketCloseFrame(websocketCloseSessionRevoked, collaborationSessionRevoked)
+	errs := make(chan error, 2)
+	for _, client := range []net.Conn{bySession, byBinding} {
+		go func() {
+			_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			frame := make([]byte, len(want))
+			if _, err := io.ReadFull(client, frame); err != nil || !bytes.Equal(frame, want) {
+				errs <- fmt.Errorf("expected a revocation close frame, got % x (%v)", frame, err)
+				return
+			}
+			errs <- nil
+		}()
+	}
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = other.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := other.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected other sessions to stay connected")
+	}
+}
+
+func TestEventsRevocationsSignalMatchingStreams(t *testing.T) {
+	bus := newEventBus(4)
+	revocations := newEventsRevocations()
+	revocations.subscribe(bus)
+	revoked, stop := revocations.register(hashSessionBinding("bind-1"))
+	defer stop()
+	kept, stopKept := revocations.register(hashSessionBinding("bind-2"))
+	defer stopKept()
+	if never, _ := revocations.register(""); never != nil {
+		t.Fatal("expected streams without a binding hash not to be watched")
+	}
+
+	publish(bus, topicSessionsRevoked, sessionRevocation{BindingHashes: []string{hashSessionBinding("bind-1")}})
+	select {
+	case <-revoked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the revoked stream to be signalled")
+	}
+	select {
+	case <-kept:
+		t.Fatal("expected other streams to keep running")
+	default:
+	}
+}
+
+func TestEventsHandlerEndsRevokedStreams(t *testing.T) {
+	logs := captureAuditLogs(t)
+	bindingHash := hashSessionBinding("bind-1")
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set(sessionBindingHashHeader, bindingHash)
+		_, _ = io.WriteString(w, "data: ok\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer orchestrator.Close()
+
+	handler := NewEventsHandler(orchestrator.Client(), orchestrator.URL, time.Hour, nil, nil)
+	handler.revocations = newEventsRevocations()
+	gateway := httptest.NewServer(handler)
+	defer gateway.Close()
+
+	resp, err := gateway.Client().Get(gateway.URL + "/events?plan_id=" + validPlanID)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer resp.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for handler.revocations.revoke(sessionRevocation{BindingHashes: []string{bindingHash}}) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the stream to be watched for revocation")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	if !strings.Contains(string(body), "event: error\ndata: session revoked\n") {
+		t.Fatalf("expected a revocation error event, got %q", body)
+	}
+	if !strings.Contains(logs.String(), `"reason":"session_revoked"`) {
+		t.Fatalf("expected the revocation to be audited, got %s", logs.String())
+	}
+}
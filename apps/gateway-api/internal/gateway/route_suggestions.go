@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"weak"
+)
+
+// maxSuggestedPathBytes bounds the paths compared against the route table;
+// longer paths get no suggestion.
+const maxSuggestedPathBytes = 256
+
+// productionEnvironment reports whether NODE_ENV names a production
+// deployment.
+func productionEnvironment() bool {
+	switch strings.ToLower(strings.TrimSpace(GetEnv("NODE_ENV", ""))) {
+	case "production", "prod":
+		return true
+	}
+	return false
+}
+
+// routeSuggestionsEnabled reports whether 404 responses name the closest
+// registered route. GATEWAY_ROUTE_SUGGESTIONS is ignored in production so
+// the route table is not disclosed to clients.
+func routeSuggestionsEnabled() bool {
+	return getBoolEnv("GATEWAY_ROUTE_SUGGESTIONS") && !productionEnvironment()
+}
+
+// ValidateRouteSuggestionConfig rejects GATEWAY_ROUTE_SUGGESTIONS in
+// production at startup.
+func ValidateRouteSuggestionConfig() error {
+	if getBoolEnv("GATEWAY_ROUTE_SUGGESTIONS") && productionEnvironment() {
+		return errors.New("GATEWAY_ROUTE_SUGGESTIONS cannot be enabled when NODE_ENV is production")
+	}
+	return nil
+}
+
+// notFound answers requests no route matches with the JSON error body,
+// suggesting the closest route when enabled.
+func notFound(w http.ResponseWriter, r *http.Request, mux *http.ServeMux) {
+	var details any
+	if routeSuggestionsEnabled() {
+		if suggestion := suggestRoute(mux, r.Method, r.URL.Path); suggestion != "" {
+			details = map[string]string{"suggestion": suggestion}
+		}
+	}
+	writeErrorResponse(w, r, http.StatusNotFound, "not_found", "not found", details)
+}
+
+// suggestRoute returns the pattern registered on mux whose path is closest
+// to path by edit distance, with wildcards standing for the segments of path
+// they would match. Routes accepting method win ties. It returns "" when no
+// route is within a third of the path's length.
+func suggestRoute(mux *http.ServeMux, method, path string) string {
+	if len(path) > maxSuggestedPathBytes {
+		return ""
+	}
+	routeTables.mu.Lock()
+	routes := append([]RouteInfo(nil), routeTables.routes[weak.Make(mux)]...)
+	routeTables.mu.Unlock()
+
+	best, bestDistance, bestMethod := "", max(3, len(path)/3)+1, false
+	for _, route := range routes {
+		routeMethod, routePath, ok := strings.Cut(route.Pattern, " ")
+		if !ok {
+			routeMethod, routePath = "", route.Pattern
+		}
+		distance := editDistance(path, fillRouteWildcards(routePath, path))
+		sameMethod := routeMethod == "" || routeMethod == method || (method == http.MethodHead && routeMethod == http.MethodGet)
+		if distance < bestDistance || (distance == bestDistance && sameMethod && !bestMethod) {
+			best, bestDistance, bestMethod = route.Pattern, distance, sameMethod
+		}
+	}
+	return best
+}
+
+// fillRouteWildcards replaces the wildcards of routePath with the segments
+// of path at the same position; a trailing {name...} takes the rest of path.
+func fillRouteWildcards(routePath, path string) string {
+	routeSegments := strings.Split(routePath, "/")
+	pathSegments := strings.Split(path, "/")
+	for i, segment := range routeSegments {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+		if i >= len(pathSegments) {
+			routeSegments[i] = ""
+			continue
+		}
+		if strings.HasSuffix(segment, "...}") {
+			routeSegments[i] = strings.Join(pathSegments[i:], "/")
+			break
+		}
+		routeSegments[i] = pathSegments[i]
+	}
+	return strings.Join(routeSegments, "/")
+}
+
+// editDistance is the Levenshtein distance between a and b in bytes.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
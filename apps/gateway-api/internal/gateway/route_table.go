@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"weak"
+)
+
+// Policies attached to routes in the route table. They name the checks a
+// route applies itself, on top of the listener's middleware.
+const (
+	policyAdminToken       = "auth:admin_token"
+	policySCIMToken        = "auth:scim_token"
+	policyRevocationToken  = "auth:revocation_token"
+	policySession          = "auth:session"
+	policyAuthRateLimit    = "rate_limit:auth"
+	policyJWKSRateLimit    = "rate_limit:jwks"
+	policyConnectRateLimit = "rate_limit:events.connect"
+	policyConnectionLimit  = "connection_limit:ip"
+	policyLockout          = "lockout:auth_failure"
+	policyReadOnly         = "collaboration:read_only"
+	policyResponseRewrite  = "response_rewrite"
+	// policyStreaming marks GET routes that hold the connection open; they
+	// answer HEAD with 405 rather than opening a stream nobody reads.
+	policyStreaming = "streaming"
+)
+
+// RouteInfo describes one registered route for "gateway-api routes".
+type RouteInfo struct {
+	Pattern      string   `json:"pattern"`
+	Policies     []string `json:"policies,omitempty"`
+	MaxBodyBytes int64    `json:"max_body_bytes,omitempty"`
+	// ForwardedHeaders maps the client headers the route passes to the
+	// orchestrator to their size caps.
+	ForwardedHeaders map[string]int `json:"forwarded_headers,omitempty"`
+}
+
+// routeTables records the routes registered on each mux through handle. The
+// muxes are held weakly so tests building many of them do not pin them.
+var routeTables struct {
+	mu     sync.Mutex
+	routes map[weak.Pointer[http.ServeMux]][]RouteInfo
+}
+
+// handle registers handler on mux and records it in the route table with the
+// policies it enforces.
+func handle(mux *http.ServeMux, pattern string, handler http.Handler, policies ...string) {
+	mux.Handle(pattern, handler)
+	routeTables.mu.Lock()
+	defer routeTables.mu.Unlock()
+	if routeTables.routes == nil {
+		routeTables.routes = make(map[weak.Pointer[http.ServeMux]][]RouteInfo)
+	}
+	key := weak.Make(mux)
+	routeTables.routes[key] = append(routeTables.routes[key], RouteInfo{Pattern: pattern, Policies: policies})
+}
+
+// handleFunc is handle for a handler function.
+func handleFunc(mux *http.ServeMux, pattern string, handler http.HandlerFunc, policies ...string) {
+	handle(mux, pattern, handler, policies...)
+}
+
+// routeHasPolicy reports whether the route registered on mux with pattern
+// enforces policy.
+func routeHasPolicy(mux *http.ServeMux, pattern, policy string) bool {
+	routeTables.mu.Lock()
+	defer routeTables.mu.Unlock()
+	for _, route := range routeTables.routes[weak.Make(mux)] {
+		if route.Pattern == pattern {
+			return slices.Contains(route.Policies, policy)
+		}
+	}
+	return false
+}
+
+// Routes returns the routes registered on the router's mux with their
+// policies and effective body limits, ordered by path and method.
+func (rt *Router) Routes() []RouteInfo {
+	routeTables.mu.Lock()
+	routes := append([]RouteInfo(nil), routeTables.routes[weak.Make(rt.mux)]...)
+	routeTables.mu.Unlock()
+	for i := range routes {
+		routes[i].MaxBodyBytes = max(rt.bodyLimit(routes[i].Pattern), 0)
+		routes[i].ForwardedHeaders = routeForwardedHeaders(routes[i].Policies)
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routePath(routes[i].Pattern) < routePath(routes[j].Pattern)
+	})
+	return routes
+}
+
+// routePath strips the method from a ServeMux pattern.
+func routePath(pattern string) string {
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return path
+	}
+	return pattern
+}
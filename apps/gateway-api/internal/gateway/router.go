@@ -0,0 +1,265 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Router serves a ServeMux registered with method and wildcard patterns such
+// as "GET /auth/{provider}/authorize". It answers unknown paths and
+// unsupported methods with the gateway's JSON error body, and labels the
+// request span and HTTP metrics with the matched pattern so telemetry is
+// grouped by route rather than by raw path. HEAD is served by GET routes
+// without a body, and OPTIONS by every path with the methods it allows.
+type Router struct {
+	mux *http.ServeMux
+	// maxBodyBytes and bodyLimits bound request bodies; see SetBodyLimits.
+	maxBodyBytes int64
+	bodyLimits   map[string]int64
+}
+
+// NewRouter wraps mux. Routes must be registered on mux before it serves.
+func NewRouter(mux *http.ServeMux) *Router {
+	return &Router{mux: mux}
+}
+
+// SetBodyLimits bounds request bodies per route. perRoute is keyed by the
+// pattern a route was registered with; other routes use defaultMax. A limit
+// of zero or less leaves bodies unbounded. Requests declaring a larger
+// Content-Length are answered with 413 before the handler runs, and longer
+// bodies fail to read with *http.MaxBytesError.
+func (rt *Router) SetBodyLimits(defaultMax int64, perRoute map[string]int64) {
+	rt.maxBodyBytes = defaultMax
+	rt.bodyLimits = perRoute
+}
+
+func (rt *Router) bodyLimit(pattern string) int64 {
+	if limit, ok := rt.bodyLimits[pattern]; ok {
+		return limit
+	}
+	return rt.maxBodyBytes
+}
+
+// Handler reports the handler and pattern mux would use for r.
+func (rt *Router) Handler(r *http.Request) (http.Handler, string) {
+	return rt.mux.Handler(r)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, pattern := rt.mux.Handler(r)
+	if pattern == "" {
+		// ServeMux reports no pattern for not-found, method-not-allowed and
+		// redirect responses. The 404 and 405 are rewritten; redirects are
+		// replayed as written.
+		captured := &capturedResponse{header: make(http.Header)}
+		handler.ServeHTTP(captured, r)
+		switch captured.status {
+		case http.StatusNotFound:
+			notFound(w, r, rt.mux)
+			return
+		case http.StatusMethodNotAllowed:
+			allowed := captured.header.Get("Allow") + ", " + http.MethodOptions
+			if r.Method == http.MethodOptions {
+				answerOptions(w, r, allowed)
+				return
+			}
+			methodNotAllowed(w, r, allowed)
+			return
+		}
+		captured.replay(w)
+		return
+	}
+	if r.Method == http.MethodHead {
+		w = &headResponseWriter{ResponseWriter: w}
+		if routeHasPolicy(rt.mux, pattern, policyStreaming) {
+			methodNotAllowed(w, r, "GET, OPTIONS")
+			return
+		}
+	}
+	labelRoute(r, pattern)
+	if timings := requestTimingsFrom(r.Context()); timings != nil {
+		timings.setRoute(pattern)
+	}
+	if limit := rt.bodyLimit(pattern); limit > 0 && r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > limit {
+			writeErrorResponse(w, r, http.StatusRequestEntityTooLarge, "payload_too_large", fmt.Sprintf("request body must not exceed %d bytes", limit), nil)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	rt.mux.ServeHTTP(w, r)
+}
+
+// answerOptions advertises the methods allowed on the requested path. A CORS
+// preflight from an origin in OAUTH_ALLOWED_REDIRECT_ORIGINS, the origins
+// the JWKS route shares its keys with, is granted the requested method and
+// headers.
+func answerOptions(w http.ResponseWriter, r *http.Request, allowed string) {
+	headers := w.Header()
+	headers.Set("Allow", allowed)
+	headers.Add("Vary", "Origin")
+	headers.Add("Vary", "Access-Control-Request-Method")
+	headers.Add("Vary", "Access-Control-Request-Headers")
+	origin := strings.TrimSpace(r.Header.Get("Origin"))
+	method := strings.TrimSpace(r.Header.Get("Access-Control-Request-Method"))
+	if origin != "" && method != "" && methodListed(allowed, method) {
+		if parsed, err := url.Parse(origin); err == nil && originAllowed(parsed) {
+			headers.Set("Access-Control-Allow-Origin", origin)
+			headers.Set("Access-Control-Allow-Methods", allowed)
+			if requested, ok := preflightHeaders(r.Header.Get("Access-Control-Request-Headers")); ok && requested != "" {
+				headers.Set("Access-Control-Allow-Headers", requested)
+			}
+			headers.Set("Access-Control-Max-Age", strconv.Itoa(preflightMaxAgeSeconds))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// preflightMaxAgeSeconds is how long browsers may cache a granted preflight.
+const preflightMaxAgeSeconds = 600
+
+func methodListed(allowed, method string) bool {
+	for _, candidate := range strings.Split(allowed, ",") {
+		if strings.TrimSpace(candidate) == method {
+			return true
+		}
+	}
+	return false
+}
+
+// preflightHeaders normalizes an Access-Control-Request-Headers value,
+// reporting false when an entry is not a header name.
+func preflightHeaders(value string) (string, bool) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !isHTTPToken(name) {
+			return "", false
+		}
+		names = append(names, strings.ToLower(name))
+	}
+	return strings.Join(names, ", "), true
+}
+
+// headResponseWriter drops the body a GET handler writes in answer to HEAD,
+// keeping its status and headers.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (hw *headResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (hw *headResponseWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// labelRoute records the path template of pattern as the route of r on the
+// active span and on the otelhttp metric labeler.
+func labelRoute(r *http.Request, pattern string) {
+	path := pattern
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		path = pattern[i+1:]
+	}
+	route := attribute.String("http.route", path)
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(route)
+	span.SetName(r.Method + " " + path)
+	if labeler, ok := otelhttp.LabelerFromContext(r.Context()); ok {
+		labeler.Add(route)
+	}
+}
+
+// capturedResponse buffers the small responses ServeMux writes for requests
+// it cannot route.
+type capturedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (c *capturedResponse) Header() http.Header { return c.header }
+
+func (c *capturedResponse) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *capturedResponse) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	return c.body.Write(p)
+}
+
+func (c *capturedResponse) replay(w http.ResponseWriter) {
+	for key, values := range c.header {
+		w.Header()[key] = values
+	}
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	w.WriteHeader(c.status)
+	_, _ = w.Write(c.body.Bytes())
+}
+
+// defaultRouteBodyLimits are the built-in per-route body limits, tighter than
+// the global default for routes that only ever receive small payloads.
+var defaultRouteBodyLimits = map[string]int64{
+	"GET /auth/{provider}/callback": 4 << 10,
+	"POST /auth/ldap/login":         maxLDAPLoginBodyBytes,
+	// Attachment uploads stream through and are bounded per file by the
+	// handler instead.
+	"POST /plan/{id}/attachments": 0,
+	"POST " + revocationsPath:     maxRevocationBodyBytes,
+	scimUsersPath:                 maxSCIMBodyBytes,
+	scimUsersPath + "/":           maxSCIMBodyBytes,
+}
+
+// RouteBodyLimits returns the built-in per-route body limits overlaid with
+// the entries of raw, a comma-separated list of pattern=bytes pairs such as
+// "POST /auth/ldap/login=4096". A value of 0 removes the limit for that route.
+func RouteBodyLimits(raw string) (map[string]int64, error) {
+	limits := make(map[string]int64, len(defaultRouteBodyLimits))
+	for pattern, limit := range defaultRouteBodyLimits {
+		limits[pattern] = limit
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pattern, value, ok := strings.Cut(entry, "=")
+		pattern = strings.TrimSpace(pattern)
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("route body limit %q must be pattern=bytes", entry)
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("route body limit for %q must be a non-negative byte count", pattern)
+		}
+		limits[pattern] = limit
+	}
+	return limits, nil
+}
+
+// requestBodyTooLarge reports whether err came from reading past a body
+// limit set by the router or http.MaxBytesReader.
+func requestBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
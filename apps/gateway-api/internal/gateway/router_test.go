@@ -0,0 +1,300 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+func TestRouterAnswersUnsupportedMethodsWithJSON(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("DELETE /items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	router := NewRouter(mux)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/items/1", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "DELETE, GET, HEAD, OPTIONS" {
+		t.Fatalf("unexpected Allow header %q", allow)
+	}
+	var body httpErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != "method_not_allowed" {
+		t.Fatalf("expected JSON method_not_allowed error, got %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+	body = httpErrorResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != "not_found" || body.RequestID != "req-1" || body.Details != nil {
+		t.Fatalf("expected JSON not_found error, got %q", rec.Body.String())
+	}
+}
+
+func TestRouterSuggestsClosestRoute(t *testing.T) {
+	t.Setenv("GATEWAY_ROUTE_SUGGESTIONS", "true")
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, AuthRouteConfig{})
+	RegisterHealthRoutes(mux, time.Now())
+	router := NewRouter(mux)
+	suggestion := func(path string) any {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var body struct {
+			Details map[string]any `json:"details"`
+		}
+		if rec.Code != http.StatusNotFound || json.Unmarshal(rec.Body.Bytes(), &body) != nil {
+			t.Fatalf("expected a JSON 404 for %s, got %d", path, rec.Code)
+		}
+		return body.Details["suggestion"]
+	}
+
+	cases := map[string]any{
+		"/healtz":                   "GET /healthz",
+		"/readyz/":                  "GET /readyz",
+		"/auth/google/authorise":    "GET /auth/{provider}/authorize",
+		"/auth/okta/jwk":            "GET /auth/{provider}/jwks",
+		"/plan/unrelated/artifacts": nil,
+	}
+	for path, want := range cases {
+		if got := suggestion(path); got != want {
+			t.Errorf("suggestion for %s = %v, want %v", path, got, want)
+		}
+	}
+
+	t.Setenv("NODE_ENV", "production")
+	if got := suggestion("/healtz"); got != nil {
+		t.Fatalf("expected no suggestions in production, got %v", got)
+	}
+	if err := ValidateRouteSuggestionConfig(); err == nil {
+		t.Fatal("expected suggestions to be rejected in production")
+	}
+}
+
+func TestRouterServesHeadWithoutBody(t *testing.T) {
+	mux := http.NewServeMux()
+	RegisterHealthRoutes(mux, time.Now())
+	handle(mux, "GET /stream", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("expected streaming routes not to run for HEAD")
+	}), policyStreaming)
+	router := NewRouter(mux)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Type") == "" {
+		t.Fatalf("expected headers without a body, got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/stream", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, OPTIONS" || rec.Body.Len() != 0 {
+		t.Fatalf("expected HEAD on a streaming route to be refused, got %d", rec.Code)
+	}
+}
+
+func TestRouterServesHeadOnCollaborationPresence(t *testing.T) {
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/auth/session" || r.Header.Get("Authorization") != "Bearer acme" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"session":{"id":"s1","tenantId":"acme"}}`))
+	}))
+	defer orchestrator.Close()
+	t.Setenv("ORCHESTRATOR_URL", orchestrator.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+
+	mux := http.NewServeMux()
+	RegisterCollaborationRoutes(mux, CollaborationRouteConfig{})
+	req := httptest.NewRequest(http.MethodHead, "/collaboration/presence?filePath=docs/a.md&projectId=web", nil)
+	req.Header.Set("Authorization", "Bearer acme")
+	rec := httptest.NewRecorder()
+	NewRouter(mux).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Type") == "" {
+		t.Fatalf("expected HEAD on presence to return headers without a body, got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+}
+
+func TestRouterAnswersOptions(t *testing.T) {
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	t.Cleanup(func() { allowedRedirectOrigins = loadAllowedRedirectOrigins() })
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, AuthRouteConfig{})
+	RegisterHealthRoutes(mux, time.Now())
+	router := NewRouter(mux)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/healthz", nil))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Allow") != "GET, HEAD, OPTIONS" || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("expected 204 advertising the allowed methods, got %d %v", rec.Code, rec.Header())
+	}
+
+	preflight := func(origin, method, headers string) http.Header {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodOptions, "/auth/google/authorize", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", headers)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d", rec.Code)
+		}
+		return rec.Header()
+	}
+	granted := preflight("https://app.example.com", http.MethodGet, "X-Request-Id, Traceparent")
+	if granted.Get("Access-Control-Allow-Origin") != "https://app.example.com" || granted.Get("Access-Control-Allow-Methods") != "GET, HEAD, OPTIONS" || granted.Get("Access-Control-Allow-Headers") != "x-request-id, traceparent" {
+		t.Fatalf("expected the preflight to be granted, got %v", granted)
+	}
+	for _, denied := range []http.Header{
+		preflight("https://evil.example.com", http.MethodGet, ""),
+		preflight("https://app.example.com", http.MethodDelete, ""),
+	} {
+		if denied.Get("Access-Control-Allow-Origin") != "" {
+			t.Fatalf("expected the preflight to be refused, got %v", denied)
+		}
+	}
+	if headers := preflight("https://app.example.com", http.MethodGet, "bad header"); headers.Get("Access-Control-Allow-Headers") != "" {
+		t.Fatalf("expected invalid header names not to be granted, got %v", headers)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown paths to stay 404, got %d", rec.Code)
+	}
+}
+
+func TestRouterLabelsMetricsWithRoutePattern(t *testing.T) {
+	mux := http.NewServeMux()
+	var provider string
+	mux.HandleFunc("GET /auth/{provider}/authorize", func(w http.ResponseWriter, r *http.Request) {
+		provider = r.PathValue("provider")
+	})
+
+	labeler := &otelhttp.Labeler{}
+	req := httptest.NewRequest(http.MethodGet, "/auth/google/authorize", nil)
+	req = req.WithContext(otelhttp.ContextWithLabeler(context.Background(), labeler))
+	NewRouter(mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	if provider != "google" {
+		t.Fatalf("expected provider path value, got %q", provider)
+	}
+	attrs := labeler.Get()
+	if len(attrs) != 1 || string(attrs[0].Key) != "http.route" || attrs[0].Value.AsString() != "/auth/{provider}/authorize" {
+		t.Fatalf("expected http.route label, got %v", attrs)
+	}
+}
+
+func TestAuthRoutesMatchWholeSegments(t *testing.T) {
+	mux := http.NewServeMux()
+	RegisterAuthRoutes(mux, AuthRouteConfig{})
+	router := NewRouter(mux)
+
+	cases := map[string]int{
+		"POST /auth/google/authorize":      http.StatusMethodNotAllowed,
+		"GET /auth/google/extra/authorize": http.StatusNotFound,
+		"GET /auth/google/unknown":         http.StatusNotFound,
+		// LDAP login is only registered when LDAP is configured.
+		"POST /auth/ldap/login": http.StatusNotFound,
+	}
+	for target, want := range cases {
+		method, path, _ := strings.Cut(target, " ")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		if rec.Code != want {
+			t.Fatalf("%s: expected %d, got %d", target, want, rec.Code)
+		}
+	}
+}
+
+func TestRouterAppliesPerRouteBodyLimits(t *testing.T) {
+	mux := http.NewServeMux()
+	read := func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			if requestBodyTooLarge(err) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	mux.HandleFunc("POST /small", read)
+	mux.HandleFunc("POST /large", read)
+	router := NewRouter(mux)
+	router.SetBodyLimits(64, map[string]int64{"POST /small": 8})
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		chunked bool
+		want    int
+	}{
+		{name: "within route limit", path: "/small", body: "12345678", want: http.StatusNoContent},
+		{name: "declared over route limit", path: "/small", body: "123456789", want: http.StatusRequestEntityTooLarge},
+		{name: "streamed over route limit", path: "/small", body: "123456789", chunked: true, want: http.StatusRequestEntityTooLarge},
+		{name: "default limit applies elsewhere", path: "/large", body: strings.Repeat("a", 64), want: http.StatusNoContent},
+		{name: "over default limit", path: "/large", body: strings.Repeat("a", 65), want: http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, rec.Code)
+			}
+			if tc.want == http.StatusRequestEntityTooLarge && !tc.chunked {
+				var body httpErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != "payload_too_large" {
+					t.Fatalf("expected JSON payload_too_large error, got %q", rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRouteBodyLimitsOverridesDefaults(t *testing.T) {
+	limits, err := RouteBodyLimits("POST /auth/ldap/login=1024, POST /admin/upstreams/refresh=0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if limits["POST /auth/ldap/login"] != 1024 {
+		t.Fatalf("expected override, got %d", limits["POST /auth/ldap/login"])
+	}
+	if limit, ok := limits["POST /admin/upstreams/refresh"]; !ok || limit != 0 {
+		t.Fatalf("expected explicit zero limit, got %d (%v)", limit, ok)
+	}
+	if limits[scimUsersPath] != maxSCIMBodyBytes {
+		t.Fatalf("expected built-in SCIM limit, got %d", limits[scimUsersPath])
+	}
+
+	for _, raw := range []string{"POST /x", "=10", "POST /x=-1", "POST /x=big"} {
+		if _, err := RouteBodyLimits(raw); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// schedulerJitter spreads runs by up to ±10% of the interval so replicas
+	// started together do not hit shared backends in lockstep.
+	schedulerJitter    = 0.1
+	finalRunTimeout    = 5 * time.Second
+	maxJobErrorMessage = 256
+)
+
+// jobOptions tune a scheduled job.
+type jobOptions struct {
+	// runOnStop runs the job once more after the scheduler's context is
+	// cancelled, for jobs that must flush buffered state.
+	runOnStop bool
+}
+
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	run      func(context.Context) error
+	opts     jobOptions
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+// JobStatus is the run history the admin API reports for a job.
+type JobStatus struct {
+	Name          string     `json:"name"`
+	Interval      string     `json:"interval"`
+	Running       bool       `json:"running"`
+	Runs          int64      `json:"runs"`
+	Failures      int64      `json:"failures"`
+	Panics        int64      `json:"panics"`
+	LastStartedAt *time.Time `json:"last_started_at,omitempty"`
+	LastDuration  string     `json:"last_duration,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextRunAt     *time.Time `json:"next_run_at,omitempty"`
+}
+
+// Scheduler runs named maintenance jobs on jittered intervals. A job that
+// fails or panics is logged and counted and runs again on its next tick; it
+// never takes down the scheduler or other jobs.
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    []*scheduledJob
+	started bool
+	now     func() time.Time
+	jitter  func(time.Duration) time.Duration
+}
+
+func newScheduler() *Scheduler {
+	return &Scheduler{now: time.Now, jitter: jitterInterval}
+}
+
+func jitterInterval(interval time.Duration) time.Duration {
+	spread := float64(interval) * schedulerJitter
+	return interval + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// register adds a job. Jobs must be registered before Run; names must be
+// unique.
+func (s *Scheduler) register(name string, interval time.Duration, run func(context.Context) error, opts jobOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		panic(fmt.Sprintf("scheduler: job %q registered after start", name)) // panic: startup-only
+	}
+	if interval <= 0 {
+		panic(fmt.Sprintf("scheduler: job %q needs a positive interval", name)) // panic: startup-only
+	}
+	for _, job := range s.jobs {
+		if job.name == name {
+			panic(fmt.Sprintf("scheduler: duplicate job %q", name)) // panic: startup-only
+		}
+	}
+	s.jobs = append(s.jobs, &scheduledJob{
+		name:     name,
+		interval: interval,
+		run:      run,
+		opts:     opts,
+		status:   JobStatus{Name: name, Interval: interval.String()},
+	})
+}
+
+// Run starts every job and blocks until ctx is cancelled and each job has
+// finished its current run and any final run.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.started = true
+	jobs := append([]*scheduledJob(nil), s.jobs...)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job *scheduledJob) {
+	for {
+		delay := s.jitter(job.interval)
+		next := s.now().Add(delay)
+		job.mu.Lock()
+		job.status.NextRunAt = &next
+		job.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			job.mu.Lock()
+			job.status.NextRunAt = nil
+			job.mu.Unlock()
+			if job.opts.runOnStop {
+				finalCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finalRunTimeout)
+				s.runOnce(finalCtx, job)
+				cancel()
+			}
+			return
+		case <-timer.C:
+		}
+		runCtx, cancel := context.WithTimeout(ctx, job.interval)
+		s.runOnce(runCtx, job)
+		cancel()
+	}
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, job *scheduledJob) {
+	started := s.now()
+	job.mu.Lock()
+	job.status.Running = true
+	job.status.LastStartedAt = &started
+	job.status.NextRunAt = nil
+	job.mu.Unlock()
+
+	panicked, err := runJobSafely(ctx, job)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.Running = false
+	job.status.Runs++
+	job.status.LastDuration = s.now().Sub(started).String()
+	job.status.LastError = ""
+	if panicked {
+		job.status.Panics++
+	}
+	if err != nil {
+		job.status.Failures++
+		message := err.Error()
+		if len(message) > maxJobErrorMessage {
+			message = message[:maxJobErrorMessage]
+		}
+		job.status.LastError = message
+		if !panicked {
+			slog.WarnContext(ctx, "gateway.scheduler.job_failed", slog.String("job", job.name), slog.Any("error", err))
+		}
+	}
+}
+
+func runJobSafely(ctx context.Context, job *scheduledJob) (panicked bool, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			slog.ErrorContext(ctx, "gateway.scheduler.job_panicked",
+				slog.String("job", job.name),
+				slog.Any("panic", recovered),
+				slog.String("stack", string(debug.Stack())))
+			panicked = true
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return false, job.run(ctx)
+}
+
+// Status returns a snapshot of every job in registration order.
+func (s *Scheduler) Status() []JobStatus {
+	s.mu.Lock()
+	jobs := append([]*scheduledJob(nil), s.jobs...)
+	s.mu.Unlock()
+	statuses := make([]JobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		job.mu.Lock()
+		statuses = append(statuses, job.status)
+		job.mu.Unlock()
+	}
+	return statuses
+}
+
+// maintenanceScheduler is the scheduler the admin API reports on.
+var maintenanceScheduler atomic.Pointer[Scheduler]
+
+// NewMaintenanceScheduler registers the gateway's built-in maintenance jobs:
+// upstream client refresh, rate limit window cleanup, the streaming goroutine
+// watchdog, usage flushing, SLO burn rate evaluation and, when configured,
+// usage export, tenant configuration reloads, clock drift checks and honeypot
+// summaries.
+// Configuration errors are returned before anything runs.
+func NewMaintenanceScheduler() (*Scheduler, error) {
+	s := newScheduler()
+	s.register("upstream_refresh",
+		ResolveDuration([]string{"GATEWAY_UPSTREAM_REFRESH_INTERVAL"}, defaultUpstreamConfigRefreshPeriod),
+		refreshUpstreamClients, jobOptions{})
+	s.register("dns_refresh",
+		ResolveDuration([]string{"GATEWAY_DNS_CACHE_MIN_TTL"}, defaultDNSCacheMinTTL),
+		refreshDNSCache, jobOptions{})
+	s.register("rate_limit_cleanup",
+		ResolveDuration([]string{"GATEWAY_RATE_LIMIT_CLEANUP_INTERVAL"}, defaultRateLimitCleanupInterval),
+		cleanupRateLimiters, jobOptions{})
+	s.register("stream_watchdog",
+		ResolveDuration([]string{"GATEWAY_STREAM_WATCHDOG_INTERVAL"}, defaultStreamWatchdogInterval),
+		checkStreamGoroutines, jobOptions{})
+	if tenants, err := loadTenantConfig(); err != nil {
+		return nil, err
+	} else if tenants != nil {
+		s.register("tenant_config_reload",
+			ResolveDuration([]string{"GATEWAY_TENANT_CONFIG_RELOAD_INTERVAL"}, defaultTenantConfigReloadInterval),
+			reloadTenantConfig, jobOptions{})
+	}
+	if err := registerUsageJobs(s); err != nil {
+		return nil, err
+	}
+	if tracker, err := loadSLOTracker(); err != nil {
+		return nil, err
+	} else if tracker != nil {
+		s.register("slo_evaluate",
+			ResolveDuration([]string{"GATEWAY_SLO_EVALUATION_INTERVAL"}, defaultSLOEvaluationInterval),
+			evaluateSLOs, jobOptions{})
+	}
+	if clock, err := loadClockDriftConfig(); err != nil {
+		return nil, err
+	} else if len(clock.servers) > 0 {
+		s.register("clock_drift_check", clock.interval, CheckClockDrift, jobOptions{})
+	}
+	if pot, err := loadHoneypot(); err != nil {
+		return nil, err
+	} else if pot != nil {
+		s.register("honeypot_report", pot.cfg.reportInterval, reportHoneypot, jobOptions{})
+	}
+	maintenanceScheduler.Store(s)
+	return s, nil
+}
@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJitterIntervalStaysWithinBounds(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		got := jitterInterval(time.Minute)
+		if got < 54*time.Second || got > 66*time.Second {
+			t.Fatalf("jittered interval %s outside ±10%%", got)
+		}
+	}
+}
+
+func TestSchedulerIsolatesFailingJobs(t *testing.T) {
+	s := newScheduler()
+	s.jitter = func(time.Duration) time.Duration { return time.Millisecond }
+
+	var healthy, flushed atomic.Int64
+	s.register("healthy", time.Second, func(context.Context) error {
+		healthy.Add(1)
+		return nil
+	}, jobOptions{})
+	s.register("panics", time.Second, func(context.Context) error {
+		panic("boom")
+	}, jobOptions{})
+	s.register("fails", time.Second, func(context.Context) error {
+		return errors.New("backend down")
+	}, jobOptions{})
+	s.register("flush", time.Hour, func(context.Context) error {
+		flushed.Add(1)
+		return nil
+	}, jobOptions{runOnStop: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for healthy.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if healthy.Load() < 3 {
+		t.Fatalf("expected healthy job to keep running, got %d runs", healthy.Load())
+	}
+	statuses := map[string]JobStatus{}
+	for _, status := range s.Status() {
+		statuses[status.Name] = status
+	}
+	if status := statuses["panics"]; status.Panics == 0 || status.Failures != status.Runs || status.LastError != "panic: boom" {
+		t.Fatalf("expected panics to be recovered and counted, got %+v", status)
+	}
+	if status := statuses["fails"]; status.Failures == 0 || status.LastError != "backend down" {
+		t.Fatalf("expected failures to be recorded, got %+v", status)
+	}
+	if status := statuses["healthy"]; status.Failures != 0 || status.LastStartedAt == nil || status.LastDuration == "" {
+		t.Fatalf("unexpected healthy status %+v", status)
+	}
+	if flushed.Load() == 0 {
+		t.Fatal("expected runOnStop job to run after cancellation")
+	}
+}
+
+func TestSchedulerRejectsDuplicateJobs(t *testing.T) {
+	s := newScheduler()
+	s.register("job", time.Second, func(context.Context) error { return nil }, jobOptions{})
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected duplicate registration to panic")
+		}
+	}()
+	s.register("job", time.Second, func(context.Context) error { return nil }, jobOptions{})
+}
+
+func TestAdminJobsListsMaintenanceJobs(t *testing.T) {
+	t.Setenv("GATEWAY_USAGE_STORE", "memory")
+	t.Setenv("GATEWAY_USAGE_EXPORT_URL", "")
+	resetUsageMeter()
+	t.Cleanup(resetUsageMeter)
+	previous := maintenanceScheduler.Load()
+	t.Cleanup(func() { maintenanceScheduler.Store(previous) })
+
+	if _, err := NewMaintenanceScheduler(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+	req := httptest.NewRequest(http.MethodGet, "/admin/jobs", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp jobsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(resp.Jobs) != 6 || resp.Jobs[0].Name != "upstream_refresh" || resp.Jobs[1].Name != "dns_refresh" || resp.Jobs[2].Name != "rate_limit_cleanup" || resp.Jobs[3].Name != "stream_watchdog" || resp.Jobs[4].Name != "usage_flush" || resp.Jobs[5].Name != "slo_evaluate" {
+		t.Fatalf("unexpected jobs %+v", resp.Jobs)
+	}
+}
@@ -0,0 +1,620 @@
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/google/uuid"
+)
+
+const (
+	auditEventSCIM      = "scim.provisioning"
+	auditTargetSCIM     = "scim.users"
+	auditCapabilitySCIM = "scim.provisioning"
+
+	scimUserSchema    = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimPatchOpSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema   = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimContentType   = "application/scim+json"
+	scimUsersPath     = "/scim/v2/Users"
+
+	provisioningUserCreated     = "user.created"
+	provisioningUserUpdated     = "user.updated"
+	provisioningUserDeactivated = "user.deactivated"
+	provisioningUserReactivated = "user.reactivated"
+
+	maxSCIMBodyBytes             = 64 * 1024
+	maxSCIMPatchOperations       = 32
+	maxSCIMEmails                = 10
+	maxSCIMAttributeLength       = 256
+	defaultSCIMAuthFailureLimit  = 10
+	defaultSCIMAuthFailureWindow = time.Minute
+)
+
+var scimIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// SCIMRouteConfig captures configuration for the SCIM provisioning receiver.
+type SCIMRouteConfig struct {
+	TrustedProxyCIDRs []string
+}
+
+type scimName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	Location     string `json:"location,omitempty"`
+}
+
+// scimUser is the subset of the SCIM core User resource the gateway accepts.
+// Extension attributes are ignored.
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Name        *scimName   `json:"name,omitempty"`
+	Emails      []scimEmail `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+type scimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []scimPatchOperation `json:"Operations"`
+}
+
+type scimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type scimErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+var errProvisioningNotConfigured = errors.New("GATEWAY_PROVISIONING_KEY is not configured")
+
+// loadProvisioningKey returns the key that signs provisioning events. It must
+// differ from GATEWAY_IDENTITY_ASSERTION_KEY so that a leaked SCIM channel key
+// cannot mint sign-ins, nor the other way round.
+func loadProvisioningKey() ([]byte, error) {
+	key, err := ResolveEnvValue("GATEWAY_PROVISIONING_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_PROVISIONING_KEY: %w", err)
+	}
+	if key == "" {
+		return nil, errProvisioningNotConfigured
+	}
+	if len(key) < minIdentityAssertionKeyBytes {
+		return nil, fmt.Errorf("GATEWAY_PROVISIONING_KEY must be at least %d bytes", minIdentityAssertionKeyBytes)
+	}
+	if assertionKey, err := loadIdentityAssertionKey(); err == nil && subtle.ConstantTimeCompare(assertionKey, []byte(key)) == 1 {
+		return nil, errors.New("GATEWAY_PROVISIONING_KEY must differ from GATEWAY_IDENTITY_ASSERTION_KEY")
+	}
+	return []byte(key), nil
+}
+
+// provisioningEvent is the normalized lifecycle change forwarded to the
+// orchestrator. Patch events carry only the attributes that changed.
+type provisioningEvent struct {
+	ID        string           `json:"id"`
+	Type      string           `json:"type"`
+	Source    string           `json:"source"`
+	TenantID  string           `json:"tenant,omitempty"`
+	User      provisioningUser `json:"user"`
+	IssuedAt  int64            `json:"iat"`
+	ExpiresAt int64            `json:"exp"`
+}
+
+type provisioningUser struct {
+	ID          string   `json:"id"`
+	ExternalID  string   `json:"external_id,omitempty"`
+	UserName    string   `json:"user_name,omitempty"`
+	DisplayName string   `json:"display_name,omitempty"`
+	GivenName   string   `json:"given_name,omitempty"`
+	FamilyName  string   `json:"family_name,omitempty"`
+	Emails      []string `json:"emails,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+	// Removed lists attributes a patch cleared.
+	Removed []string `json:"removed,omitempty"`
+}
+
+type scimHandler struct {
+	tenantID       string
+	trustedProxies []*net.IPNet
+	now            func() time.Time
+}
+
+// RegisterSCIMRoutes wires the SCIM 2.0 Users receiver into mux. It is only
+// registered when GATEWAY_SCIM_TOKEN is set.
+func RegisterSCIMRoutes(mux *http.ServeMux, cfg SCIMRouteConfig) {
+	token, err := ResolveEnvValue("GATEWAY_SCIM_TOKEN")
+	if err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("failed to load GATEWAY_SCIM_TOKEN: %v", err))
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return
+	}
+	tenantID, err := normalizeTenantID(os.Getenv("GATEWAY_SCIM_TENANT_ID"))
+	if err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("invalid GATEWAY_SCIM_TENANT_ID: %v", err))
+	}
+	if _, err := loadProvisioningKey(); err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("scim provisioning requires GATEWAY_PROVISIONING_KEY: %v", err))
+	}
+	trustedProxies, err := ParseTrustedProxyCIDRs(cfg.TrustedProxyCIDRs)
+	if err != nil {
+		// panic: startup-only
+		panic(fmt.Sprintf("invalid trusted proxy configuration: %v", err))
+	}
+
+	failureLimiter := newPersistentRateLimiter("scim.auth_failure")
+	failureBucket := rateLimitBucket{
+		Endpoint:     "scim.auth_failure",
+		IdentityType: "ip",
+		Limit:        ResolveLimit([]string{"GATEWAY_SCIM_AUTH_FAILURE_LIMIT"}, defaultSCIMAuthFailureLimit),
+		Window:       ResolveDuration([]string{"GATEWAY_SCIM_AUTH_FAILURE_WINDOW"}, defaultSCIMAuthFailureWindow),
+	}
+	h := &scimHandler{tenantID: tenantID, trustedProxies: trustedProxies, now: clockNow}
+
+	handle(mux, scimUsersPath, scimAuthMiddleware(token, failureLimiter, failureBucket, trustedProxies, http.HandlerFunc(h.serveUsers)), policySCIMToken, policyLockout)
+	handle(mux, scimUsersPath+"/", scimAuthMiddleware(token, failureLimiter, failureBucket, trustedProxies, http.HandlerFunc(h.serveUser)), policySCIMToken, policyLockout)
+}
+
+func scimAuthMiddleware(token string, limiter *rateLimiter, bucket rateLimitBucket, trusted []*net.IPNet, next http.Handler) http.Handler {
+	expected := []byte(token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if updated, _ := audit.EnsureRequestID(r, w); updated != nil {
+			r = updated
+		}
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(provided)), expected) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r, trusted)
+		allowed, retryAfter, err := limiter.Allow(r.Context(), bucket, ip)
+		if err == nil && !allowed {
+			emitSCIMEvent(r.Context(), r, trusted, auditOutcomeDenied, map[string]any{
+				"reason":              "auth_rate_limited",
+				"retry_after_seconds": retryAfterToSeconds(retryAfter),
+			})
+			respondTooManyRequests(w, r, retryAfter)
+			return
+		}
+		emitSCIMEvent(r.Context(), r, trusted, auditOutcomeDenied, map[string]any{"reason": "invalid_token"})
+		w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+		writeSCIMError(w, http.StatusUnauthorized, "", "bearer token required")
+	})
+}
+
+func (h *scimHandler) serveUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeSCIMError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+	var user scimUser
+	if !decodeSCIMBody(w, r, &user) {
+		return
+	}
+	if err := validateSCIMUser(&user); err != nil {
+		emitSCIMEvent(r.Context(), r, h.trustedProxies, auditOutcomeDenied, map[string]any{"reason": "invalid_user", "error": err.Error()})
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	active := true
+	if user.Active != nil {
+		active = *user.Active
+	}
+	user.ID = uuid.NewString()
+	user.Active = &active
+	event := provisioningUser{
+		ID:          user.ID,
+		ExternalID:  user.ExternalID,
+		UserName:    user.UserName,
+		DisplayName: user.DisplayName,
+		Active:      &active,
+	}
+	if user.Name != nil {
+		event.GivenName = user.Name.GivenName
+		event.FamilyName = user.Name.FamilyName
+	}
+	for _, email := range user.Emails {
+		event.Emails = append(event.Emails, email.Value)
+	}
+	if !h.forward(w, r, provisioningUserCreated, event) {
+		return
+	}
+
+	location := scimUsersPath + "/" + user.ID
+	user.Schemas = []string{scimUserSchema}
+	user.Meta = &scimMeta{ResourceType: "User", Created: h.now().UTC().Format(time.RFC3339), Location: location}
+	w.Header().Set("Location", location)
+	writeSCIMResponse(w, http.StatusCreated, user)
+}
+
+func (h *scimHandler) serveUser(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, scimUsersPath+"/")
+	if !scimIDPattern.MatchString(id) {
+		writeSCIMError(w, http.StatusNotFound, "", "user not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		var patch scimPatchRequest
+		if !decodeSCIMBody(w, r, &patch) {
+			return
+		}
+		event, err := applySCIMPatch(id, patch)
+		if err != nil {
+			emitSCIMEvent(r.Context(), r, h.trustedProxies, auditOutcomeDenied, map[string]any{"reason": "invalid_patch", "error": err.Error()})
+			writeSCIMError(w, http.StatusBadRequest, scimPatchErrorType(err), err.Error())
+			return
+		}
+		eventType := provisioningUserUpdated
+		if event.Active != nil {
+			eventType = provisioningUserReactivated
+			if !*event.Active {
+				eventType = provisioningUserDeactivated
+			}
+		}
+		if h.forward(w, r, eventType, event) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case http.MethodDelete:
+		// Deletion deprovisions the user; the orchestrator keeps the record for
+		// audit purposes but revokes its sessions.
+		inactive := false
+		if h.forward(w, r, provisioningUserDeactivated, provisioningUser{ID: id, Active: &inactive}) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.Header().Set("Allow", "PATCH, DELETE")
+		writeSCIMError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+	}
+}
+
+// forward signs the provisioning event and posts it to the orchestrator. It
+// writes the error response and returns false when the orchestrator does not
+// accept the event.
+func (h *scimHandler) forward(w http.ResponseWriter, r *http.Request, eventType string, user provisioningUser) bool {
+	details := withTenantHash(map[string]any{
+		"event_type":   eventType,
+		"user_id_hash": gatewayAuditLogger.HashIdentity("scim_user", user.ID),
+	}, hashTenantID(h.tenantID))
+
+	key, err := loadProvisioningKey()
+	if err != nil {
+		emitSCIMEvent(r.Context(), r, h.trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{"reason": "provisioning_not_configured"}))
+		writeSCIMError(w, http.StatusServiceUnavailable, "", "provisioning is not configured")
+		return false
+	}
+	now := h.now()
+	token, err := signGatewayToken(key, gatewayTokenProvisioning, provisioningEvent{
+		ID:        uuid.NewString(),
+		Type:      eventType,
+		Source:    "scim",
+		TenantID:  h.tenantID,
+		User:      user,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(GetDurationEnv("GATEWAY_IDENTITY_ASSERTION_TTL", defaultIdentityAssertionTTL)).Unix(),
+	})
+	if err != nil {
+		emitSCIMEvent(r.Context(), r, h.trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{"reason": "sign_failed", "error": err.Error()}))
+		writeSCIMError(w, http.StatusInternalServerError, "", "failed to sign provisioning event")
+		return false
+	}
+
+	resp, body, err := postGatewayToken(r.Context(), orchestratorProvisioningEvents, "event", token, audit.RequestID(r.Context()))
+	if err != nil {
+		emitSCIMEvent(r.Context(), r, h.trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{"reason": "upstream_unreachable", "error": err.Error()}))
+		writeSCIMError(w, http.StatusBadGateway, "", "failed to contact orchestrator")
+		return false
+	}
+	if resp.StatusCode >= 400 {
+		safeError, detailedError, _ := sanitizeOrchestratorError(body)
+		emitSCIMEvent(r.Context(), r, h.trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{
+			"reason":      "upstream_error",
+			"status_code": resp.StatusCode,
+			"error":       detailedError,
+		}))
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			writeSCIMError(w, http.StatusNotFound, "", "user not found")
+		case resp.StatusCode == http.StatusConflict:
+			writeSCIMError(w, http.StatusConflict, "uniqueness", safeError)
+		case resp.StatusCode < 500:
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", safeError)
+		default:
+			writeSCIMError(w, http.StatusBadGateway, "", safeError)
+		}
+		return false
+	}
+	emitSCIMEvent(r.Context(), r, h.trustedProxies, auditOutcomeSuccess, details)
+	return true
+}
+
+func decodeSCIMBody(w http.ResponseWriter, r *http.Request, target any) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]))
+	if mediaType != scimContentType && mediaType != "application/json" {
+		writeSCIMError(w, http.StatusUnsupportedMediaType, "", "content type must be application/scim+json")
+		return false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSCIMBodyBytes)).Decode(target); err != nil {
+		if requestBodyTooLarge(err) {
+			writeSCIMError(w, http.StatusRequestEntityTooLarge, "", "request body too large")
+			return false
+		}
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "request body must be a JSON object")
+		return false
+	}
+	return true
+}
+
+func validateSCIMUser(user *scimUser) error {
+	if !hasSCIMSchema(user.Schemas, scimUserSchema) {
+		return fmt.Errorf("schemas must include %s", scimUserSchema)
+	}
+	user.UserName = strings.TrimSpace(user.UserName)
+	if user.UserName == "" {
+		return errors.New("userName is required")
+	}
+	fields := map[string]string{"userName": user.UserName, "externalId": user.ExternalID, "displayName": user.DisplayName}
+	if user.Name != nil {
+		fields["name.givenName"] = user.Name.GivenName
+		fields["name.familyName"] = user.Name.FamilyName
+	}
+	for field, value := range fields {
+		if err := validateSCIMString(field, value); err != nil {
+			return err
+		}
+	}
+	return validateSCIMEmails(user.Emails)
+}
+
+func validateSCIMString(field, value string) error {
+	if len(value) > maxSCIMAttributeLength {
+		return fmt.Errorf("%s exceeds %d characters", field, maxSCIMAttributeLength)
+	}
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%s contains control characters", field)
+	}
+	return nil
+}
+
+func validateSCIMEmails(emails []scimEmail) error {
+	if len(emails) > maxSCIMEmails {
+		return fmt.Errorf("at most %d emails are supported", maxSCIMEmails)
+	}
+	for _, email := range emails {
+		if len(email.Value) > maxSCIMAttributeLength {
+			return fmt.Errorf("emails exceeds %d characters", maxSCIMAttributeLength)
+		}
+		if _, err := mail.ParseAddress(email.Value); err != nil {
+			return fmt.Errorf("invalid email %q", email.Value)
+		}
+	}
+	return nil
+}
+
+func hasSCIMSchema(schemas []string, want string) bool {
+	for _, schema := range schemas {
+		if strings.EqualFold(schema, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// errSCIMInvalidPath marks patch errors that SCIM reports as invalidPath.
+var errSCIMInvalidPath = errors.New("unsupported patch path")
+
+func scimPatchErrorType(err error) string {
+	if errors.Is(err, errSCIMInvalidPath) {
+		return "invalidPath"
+	}
+	return "invalidValue"
+}
+
+// applySCIMPatch converts PatchOp operations into a partial user. Operations
+// either name a path or, without a path, carry an object of attributes.
+func applySCIMPatch(id string, patch scimPatchRequest) (provisioningUser, error) {
+	event := provisioningUser{ID: id}
+	if !hasSCIMSchema(patch.Schemas, scimPatchOpSchema) {
+		return event, fmt.Errorf("schemas must include %s", scimPatchOpSchema)
+	}
+	if len(patch.Operations) == 0 {
+		return event, errors.New("Operations is required")
+	}
+	if len(patch.Operations) > maxSCIMPatchOperations {
+		return event, fmt.Errorf("at most %d operations are supported", maxSCIMPatchOperations)
+	}
+	for _, op := range patch.Operations {
+		kind := strings.ToLower(strings.TrimSpace(op.Op))
+		path := strings.TrimSpace(op.Path)
+		switch kind {
+		case "add", "replace":
+			if path == "" {
+				var attrs map[string]json.RawMessage
+				if err := json.Unmarshal(op.Value, &attrs); err != nil {
+					return event, errors.New("patch value must be an object when path is omitted")
+				}
+				for attr, value := range attrs {
+					if err := setSCIMPatchAttribute(&event, attr, value); err != nil {
+						return event, err
+					}
+				}
+				continue
+			}
+			if err := setSCIMPatchAttribute(&event, path, op.Value); err != nil {
+				return event, err
+			}
+		case "remove":
+			switch strings.ToLower(path) {
+			case "displayname", "externalid", "emails", "name.givenname", "name.familyname":
+				event.Removed = append(event.Removed, path)
+			default:
+				return event, fmt.Errorf("%w: cannot remove %q", errSCIMInvalidPath, path)
+			}
+		default:
+			return event, fmt.Errorf("unsupported patch op %q", op.Op)
+		}
+	}
+	return event, nil
+}
+
+func setSCIMPatchAttribute(event *provisioningUser, path string, raw json.RawMessage) error {
+	switch strings.ToLower(path) {
+	case "active":
+		active, err := decodeSCIMBool(raw)
+		if err != nil {
+			return err
+		}
+		event.Active = &active
+		return nil
+	case "emails":
+		var emails []scimEmail
+		if err := json.Unmarshal(raw, &emails); err != nil {
+			return errors.New("emails must be a list")
+		}
+		if err := validateSCIMEmails(emails); err != nil {
+			return err
+		}
+		event.Emails = event.Emails[:0]
+		for _, email := range emails {
+			event.Emails = append(event.Emails, email.Value)
+		}
+		return nil
+	case "name":
+		var name scimName
+		if err := json.Unmarshal(raw, &name); err != nil {
+			return errors.New("name must be an object")
+		}
+		if err := validateSCIMString("name.givenName", name.GivenName); err != nil {
+			return err
+		}
+		if err := validateSCIMString("name.familyName", name.FamilyName); err != nil {
+			return err
+		}
+		event.GivenName = strings.TrimSpace(name.GivenName)
+		event.FamilyName = strings.TrimSpace(name.FamilyName)
+		return nil
+	case "username":
+		return setSCIMPatchString(&event.UserName, "userName", raw, true)
+	case "displayname":
+		return setSCIMPatchString(&event.DisplayName, "displayName", raw, false)
+	case "externalid":
+		return setSCIMPatchString(&event.ExternalID, "externalId", raw, false)
+	case "name.givenname":
+		return setSCIMPatchString(&event.GivenName, "name.givenName", raw, false)
+	case "name.familyname":
+		return setSCIMPatchString(&event.FamilyName, "name.familyName", raw, false)
+	default:
+		return fmt.Errorf("%w: %q", errSCIMInvalidPath, path)
+	}
+}
+
+func setSCIMPatchString(target *string, field string, raw json.RawMessage, required bool) error {
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return fmt.Errorf("%s must be a string", field)
+	}
+	value = strings.TrimSpace(value)
+	if required && value == "" {
+		return fmt.Errorf("%s must not be empty", field)
+	}
+	if err := validateSCIMString(field, value); err != nil {
+		return err
+	}
+	*target = value
+	return nil
+}
+
+// decodeSCIMBool accepts JSON booleans and the "True"/"False" strings some
+// identity providers send.
+func decodeSCIMBool(raw json.RawMessage) (bool, error) {
+	var value bool
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return value, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if parsed, err := strconv.ParseBool(strings.ToLower(strings.TrimSpace(text))); err == nil {
+			return parsed, nil
+		}
+	}
+	return false, errors.New("active must be a boolean")
+}
+
+func writeSCIMResponse(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+func writeSCIMError(w http.ResponseWriter, status int, scimType, detail string) {
+	writeSCIMResponse(w, status, scimErrorResponse{
+		Schemas:  []string{scimErrorSchema},
+		Status:   strconv.Itoa(status),
+		SCIMType: scimType,
+		Detail:   detail,
+	})
+}
+
+func emitSCIMEvent(ctx context.Context, r *http.Request, trusted []*net.IPNet, outcome string, details map[string]any) {
+	actor := hashedActorFromRequest(r, trusted)
+	ctx = audit.WithActor(ctx, actor)
+	event := audit.Event{
+		Name:       auditEventSCIM,
+		Outcome:    outcome,
+		Target:     auditTargetSCIM,
+		Capability: auditCapabilitySCIM,
+		ActorID:    actor,
+		Details:    auditDetails(details),
+	}
+
+	switch outcome {
+	case auditOutcomeSuccess:
+		gatewayAuditLogger.Info(ctx, event)
+	case auditOutcomeDenied:
+		gatewayAuditLogger.Security(ctx, event)
+	default:
+		gatewayAuditLogger.Error(ctx, event)
+	}
+}
@@ -0,0 +1,230 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testSCIMToken       = "scim-token-0123456789"
+	testProvisioningKey = "provisioning-key-0123456789abcdef"
+)
+
+func setupSCIM(t *testing.T, status int) (*http.ServeMux, *[]provisioningEvent) {
+	t.Helper()
+	t.Setenv("GATEWAY_SCIM_TOKEN", testSCIMToken)
+	t.Setenv("GATEWAY_SCIM_TENANT_ID", "acme")
+	t.Setenv("GATEWAY_IDENTITY_ASSERTION_KEY", testAssertionKey)
+	t.Setenv("GATEWAY_PROVISIONING_KEY", testProvisioningKey)
+
+	var events []provisioningEvent
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/provisioning/events" {
+				t.Errorf("unexpected orchestrator path %s", req.URL.Path)
+			}
+			var body struct {
+				Event string `json:"event"`
+			}
+			raw, _ := io.ReadAll(req.Body)
+			_ = json.Unmarshal(raw, &body)
+			var event provisioningEvent
+			if err := verifyGatewayToken([]byte(testProvisioningKey), gatewayTokenProvisioning, body.Event, &event); err != nil {
+				t.Errorf("expected signed provisioning event: %v", err)
+			}
+			events = append(events, event)
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(`{"error":"conflict"}`)), Header: make(http.Header)}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+
+	mux := http.NewServeMux()
+	RegisterSCIMRoutes(mux, SCIMRouteConfig{})
+	return mux, &events
+}
+
+func scimRequest(mux *http.ServeMux, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testSCIMToken)
+	req.Header.Set("Content-Type", scimContentType)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSCIMRoutesDisabledWithoutToken(t *testing.T) {
+	t.Setenv("GATEWAY_SCIM_TOKEN", "")
+	mux := http.NewServeMux()
+	RegisterSCIMRoutes(mux, SCIMRouteConfig{})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/scim/v2/Users", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 when scim is disabled, got %d", rec.Code)
+	}
+}
+
+func TestLoadProvisioningKeyRejectsAssertionKey(t *testing.T) {
+	t.Setenv("GATEWAY_IDENTITY_ASSERTION_KEY", testAssertionKey)
+	t.Setenv("GATEWAY_PROVISIONING_KEY", testAssertionKey)
+	if _, err := loadProvisioningKey(); err == nil {
+		t.Fatal("expected the identity assertion key to be refused")
+	}
+	t.Setenv("GATEWAY_PROVISIONING_KEY", "")
+	if _, err := loadProvisioningKey(); !errors.Is(err, errProvisioningNotConfigured) {
+		t.Fatalf("expected errProvisioningNotConfigured, got %v", err)
+	}
+}
+
+func TestSCIMRequiresBearerToken(t *testing.T) {
+	mux, events := setupSCIM(t, http.StatusOK)
+
+	req := httptest.NewRequest(http.MethodDelete, "/scim/v2/Users/user-1", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != scimContentType {
+		t.Fatalf("expected scim error content type, got %q", got)
+	}
+	if len(*events) != 0 {
+		t.Fatal("expected unauthenticated request not to reach the orchestrator")
+	}
+}
+
+func TestSCIMCreateUserForwardsEvent(t *testing.T) {
+	mux, events := setupSCIM(t, http.StatusOK)
+
+	rec := scimRequest(mux, http.MethodPost, "/scim/v2/Users", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"externalId": "00u1",
+		"userName": "alice@example.com",
+		"name": {"givenName": "Alice", "familyName": "Liddell"},
+		"emails": [{"value": "alice@example.com", "primary": true}],
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"department": "R&D"}
+	}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created scimUser
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if created.ID == "" || created.Active == nil || !*created.Active || created.Meta == nil || created.Meta.ResourceType != "User" {
+		t.Fatalf("unexpected created user %+v", created)
+	}
+	if rec.Header().Get("Location") != "/scim/v2/Users/"+created.ID {
+		t.Fatalf("unexpected location %q", rec.Header().Get("Location"))
+	}
+
+	if len(*events) != 1 {
+		t.Fatalf("expected one event, got %d", len(*events))
+	}
+	event := (*events)[0]
+	if event.Type != provisioningUserCreated || event.Source != "scim" || event.TenantID != "acme" || event.ID == "" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event.User.ID != created.ID || event.User.UserName != "alice@example.com" || event.User.GivenName != "Alice" || event.User.Emails[0] != "alice@example.com" {
+		t.Fatalf("unexpected event user %+v", event.User)
+	}
+}
+
+func TestSCIMCreateUserValidatesSchema(t *testing.T) {
+	mux, events := setupSCIM(t, http.StatusOK)
+
+	for _, body := range []string{
+		`{"userName": "alice"}`,
+		`{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"]}`,
+		`{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "alice", "emails": [{"value": "not-an-email"}]}`,
+		`{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "al\u0000ice"}`,
+	} {
+		rec := scimRequest(mux, http.MethodPost, "/scim/v2/Users", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got %d", body, rec.Code)
+		}
+	}
+	if len(*events) != 0 {
+		t.Fatal("expected invalid users not to be forwarded")
+	}
+}
+
+func TestSCIMPatchDeactivatesUser(t *testing.T) {
+	mux, events := setupSCIM(t, http.StatusOK)
+
+	// Azure AD sends booleans as strings and capitalised ops.
+	rec := scimRequest(mux, http.MethodPatch, "/scim/v2/Users/user-1", `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "Replace", "path": "active", "value": "False"}]
+	}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// Okta sends a value object without a path.
+	rec = scimRequest(mux, http.MethodPatch, "/scim/v2/Users/user-2", `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "value": {"active": true, "displayName": "Bob"}}]
+	}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if len(*events) != 2 {
+		t.Fatalf("expected two events, got %d", len(*events))
+	}
+	if event := (*events)[0]; event.Type != provisioningUserDeactivated || event.User.ID != "user-1" || *event.User.Active {
+		t.Fatalf("unexpected deactivation event %+v", event)
+	}
+	if event := (*events)[1]; event.Type != provisioningUserReactivated || event.User.DisplayName != "Bob" {
+		t.Fatalf("unexpected reactivation event %+v", event)
+	}
+}
+
+func TestSCIMPatchRejectsUnsupportedPath(t *testing.T) {
+	mux, _ := setupSCIM(t, http.StatusOK)
+
+	rec := scimRequest(mux, http.MethodPatch, "/scim/v2/Users/user-1", `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "password", "value": "hunter2"}]
+	}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+	var resp scimErrorResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.SCIMType != "invalidPath" || resp.Status != "400" || resp.Schemas[0] != scimErrorSchema {
+		t.Fatalf("unexpected scim error %+v", resp)
+	}
+}
+
+func TestSCIMDeleteDeactivatesUser(t *testing.T) {
+	mux, events := setupSCIM(t, http.StatusOK)
+
+	rec := scimRequest(mux, http.MethodDelete, "/scim/v2/Users/user-1", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if event := (*events)[0]; event.Type != provisioningUserDeactivated || event.User.ID != "user-1" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestSCIMMapsOrchestratorConflict(t *testing.T) {
+	mux, _ := setupSCIM(t, http.StatusConflict)
+
+	rec := scimRequest(mux, http.MethodPost, "/scim/v2/Users", `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "alice"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rec.Code)
+	}
+	var resp scimErrorResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.SCIMType != "uniqueness" {
+		t.Fatalf("expected uniqueness error, got %+v", resp)
+	}
+}
@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+// Statuses reported for each secret by CheckSecrets.
+const (
+	SecretOK        = "ok"
+	SecretUnset     = "unset"
+	SecretGenerated = "generated"
+	SecretInvalid   = "invalid"
+)
+
+// SecretStatus describes one configured secret without revealing its value.
+type SecretStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SecretsReport is printed by "gateway-api secrets verify".
+type SecretsReport struct {
+	OK      bool           `json:"ok"`
+	Secrets []SecretStatus `json:"secrets"`
+}
+
+// secretCheck loads one secret the way the feature using it does. It
+// returns whether the secret is set, or an error if it cannot be used.
+type secretCheck struct {
+	name string
+	load func() (bool, error)
+	// unset is the status reported when the secret is not set.
+	unset string
+}
+
+func resolvedSecret(name string) func() (bool, error) {
+	return func() (bool, error) {
+		value, err := ResolveEnvValue(name)
+		return strings.TrimSpace(value) != "", err
+	}
+}
+
+func secretChecks() []secretCheck {
+	return []secretCheck{
+		{name: "GATEWAY_COOKIE_HASH_KEY", load: resolvedSecret("GATEWAY_COOKIE_HASH_KEY"), unset: SecretGenerated},
+		{name: "GATEWAY_COOKIE_BLOCK_KEY", load: func() (bool, error) {
+			key, err := ResolveEnvValue("GATEWAY_COOKIE_BLOCK_KEY")
+			if err != nil || key == "" {
+				return false, err
+			}
+			switch len(key) {
+			case 16, 24, 32:
+				return true, nil
+			default:
+				return true, errors.New("must be 16, 24 or 32 bytes")
+			}
+		}, unset: SecretGenerated},
+		{name: "GATEWAY_ADMIN_TOKEN", load: func() (bool, error) {
+			token, err := LoadAdminToken()
+			if err == nil && token == "" && strings.TrimSpace(GetEnv("GATEWAY_ADMIN_ADDR", "")) != "" {
+				err = errors.New("required when GATEWAY_ADMIN_ADDR is set")
+			}
+			return token != "", err
+		}, unset: SecretUnset},
+		{name: "GATEWAY_SCIM_TOKEN", load: resolvedSecret("GATEWAY_SCIM_TOKEN"), unset: SecretUnset},
+		{name: "GATEWAY_REVOCATION_TOKEN", load: resolvedSecret("GATEWAY_REVOCATION_TOKEN"), unset: SecretUnset},
+		{name: "GATEWAY_SERVICE_TOKEN_KEY", load: func() (bool, error) {
+			config, err := loadServiceTokenConfig()
+			return config.key != nil, err
+		}, unset: SecretUnset},
+		{name: "GATEWAY_IDENTITY_ASSERTION_KEY", load: func() (bool, error) {
+			_, err := loadIdentityAssertionKey()
+			if errors.Is(err, errIdentityAssertionNotConfigured) {
+				return false, nil
+			}
+			return true, err
+		}, unset: SecretUnset},
+		{name: "GATEWAY_PROVISIONING_KEY", load: func() (bool, error) {
+			_, err := loadProvisioningKey()
+			if errors.Is(err, errProvisioningNotConfigured) {
+				return false, nil
+			}
+			return true, err
+		}, unset: SecretUnset},
+		{name: "GATEWAY_STEPUP_SIGNING_KEY", load: func() (bool, error) {
+			_, err := loadStepUpSigningKey()
+			if errors.Is(err, errStepUpNotConfigured) {
+				return false, nil
+			}
+			return true, err
+		}, unset: SecretUnset},
+		{name: "GATEWAY_SSE_SHARE_KEY", load: func() (bool, error) {
+			_, err := loadPlanShareKey()
+			if errors.Is(err, errPlanShareNotConfigured) {
+				return false, nil
+			}
+			return true, err
+		}, unset: SecretUnset},
+		{name: "GATEWAY_REQUEST_SIGNING_KEY", load: func() (bool, error) {
+			_, err := parseRequestSigner()
+			if errors.Is(err, errRequestSigningNotConfigured) {
+				return false, nil
+			}
+			return true, err
+		}, unset: SecretUnset},
+		{name: "GATEWAY_AUDIT_HMAC_KEY", load: func() (bool, error) {
+			return envConfigured("GATEWAY_AUDIT_HMAC_KEY"), audit.ValidateConfig()
+		}, unset: SecretUnset},
+		{name: "GATEWAY_AUDIT_CORRELATION_KEY", load: func() (bool, error) {
+			key, err := audit.CorrelationKey()
+			return key != nil, err
+		}, unset: SecretUnset},
+		{name: "GATEWAY_SLO_ALERT_WEBHOOK_URL", load: resolvedSecret("GATEWAY_SLO_ALERT_WEBHOOK_URL"), unset: SecretUnset},
+		{name: "GATEWAY_EGRESS_PROXY_PASSWORD", load: resolvedSecret("GATEWAY_EGRESS_PROXY_PASSWORD"), unset: SecretUnset},
+		{name: "GATEWAY_STORAGE_URL", load: resolvedSecret("GATEWAY_STORAGE_URL"), unset: SecretUnset},
+		{name: "GATEWAY_STORAGE_ENCRYPTION_KEY", load: func() (bool, error) {
+			encryption, err := loadStorageEncryption()
+			return encryption != nil, err
+		}, unset: SecretUnset},
+		{name: "GATEWAY_STORAGE_ENCRYPTION_PREVIOUS_KEYS", load: func() (bool, error) {
+			encryption, err := loadStorageEncryption()
+			return encryption != nil && len(encryption.PreviousKeys) > 0, err
+		}, unset: SecretUnset},
+		{name: "OAUTH_STATE_REDIS_URL", load: resolvedSecret("OAUTH_STATE_REDIS_URL"), unset: SecretUnset},
+		{name: "GATEWAY_USAGE_REDIS_URL", load: resolvedSecret("GATEWAY_USAGE_REDIS_URL"), unset: SecretUnset},
+		{name: "GATEWAY_REDIS_PASSWORD", load: resolvedSecret("GATEWAY_REDIS_PASSWORD"), unset: SecretUnset},
+	}
+}
+
+// CheckSecrets loads every secret the gateway reads, including their _FILE
+// variants, and reports which are set, unset, generated at startup (cookie
+// keys, which then differ per replica and restart) or unusable. Values are
+// never included in the report.
+func CheckSecrets() SecretsReport {
+	report := SecretsReport{OK: true}
+	for _, check := range secretChecks() {
+		status := SecretStatus{Name: check.name, Status: SecretOK}
+		set, err := check.load()
+		switch {
+		case err != nil:
+			status.Status = SecretInvalid
+			status.Error = err.Error()
+			report.OK = false
+		case !set:
+			status.Status = check.unset
+		}
+		report.Secrets = append(report.Secrets, status)
+	}
+	return report
+}
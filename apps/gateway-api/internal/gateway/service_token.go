@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// serviceTokenHeader carries the gateway's proof of origin on every
+	// orchestrator request. Values sent by clients are always replaced.
+	serviceTokenHeader = "X-Gateway-Service-Token"
+
+	defaultServiceTokenTTL       = time.Minute
+	defaultServiceTokenClockSkew = 30 * time.Second
+	minServiceTokenKeyBytes      = 32
+	// serviceTokenKeyReload is how often the key is re-read, so a rotated
+	// GATEWAY_SERVICE_TOKEN_KEY_FILE takes effect without a restart.
+	serviceTokenKeyReload = 30 * time.Second
+)
+
+// serviceToken is signed like identity assertions and bound to one request
+// so a captured token cannot be replayed against another endpoint. nbf is
+// backdated by the configured clock skew for orchestrators whose clock runs
+// behind the gateway's.
+type serviceToken struct {
+	ID        string `json:"jti"`
+	Issuer    string `json:"iss"`
+	Audience  string `json:"aud"`
+	KeyID     string `json:"kid,omitempty"`
+	Method    string `json:"htm"`
+	Path      string `json:"htu"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type serviceTokenConfig struct {
+	key   []byte
+	keyID string
+	ttl   time.Duration
+	skew  time.Duration
+}
+
+func loadServiceTokenConfig() (serviceTokenConfig, error) {
+	key, err := ResolveEnvValue("GATEWAY_SERVICE_TOKEN_KEY")
+	if err != nil {
+		return serviceTokenConfig{}, fmt.Errorf("failed to load GATEWAY_SERVICE_TOKEN_KEY: %w", err)
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return serviceTokenConfig{}, nil
+	}
+	if len(key) < minServiceTokenKeyBytes {
+		return serviceTokenConfig{}, fmt.Errorf("GATEWAY_SERVICE_TOKEN_KEY must be at least %d bytes", minServiceTokenKeyBytes)
+	}
+	keyID := strings.TrimSpace(GetEnv("GATEWAY_SERVICE_TOKEN_KEY_ID", ""))
+	if keyID != "" && !isHTTPToken(keyID) {
+		return serviceTokenConfig{}, fmt.Errorf("invalid GATEWAY_SERVICE_TOKEN_KEY_ID %q", keyID)
+	}
+	ttl := GetDurationEnv("GATEWAY_SERVICE_TOKEN_TTL", defaultServiceTokenTTL)
+	if ttl <= 0 {
+		return serviceTokenConfig{}, errors.New("GATEWAY_SERVICE_TOKEN_TTL must be positive")
+	}
+	return serviceTokenConfig{
+		key:   []byte(key),
+		keyID: keyID,
+		ttl:   ttl,
+		skew:  GetDurationEnv("GATEWAY_SERVICE_TOKEN_CLOCK_SKEW", defaultServiceTokenClockSkew),
+	}, nil
+}
+
+// ValidateServiceTokenConfig checks the service token settings at startup.
+func ValidateServiceTokenConfig() error {
+	_, err := loadServiceTokenConfig()
+	return err
+}
+
+// serviceTokenSigner mints a fresh token per request. Its configuration is
+// reloaded every serviceTokenKeyReload; a reload that fails keeps the
+// previous key in service.
+type serviceTokenSigner struct {
+	mu       sync.Mutex
+	config   serviceTokenConfig
+	loaded   bool
+	loadedAt time.Time
+	load     func() (serviceTokenConfig, error)
+	now      func() time.Time
+}
+
+var serviceTokens = newServiceTokenSigner()
+
+func newServiceTokenSigner() *serviceTokenSigner {
+	return &serviceTokenSigner{load: loadServiceTokenConfig, now: clockNow}
+}
+
+func (s *serviceTokenSigner) current() (serviceTokenConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.loaded && now.Sub(s.loadedAt) < serviceTokenKeyReload {
+		return s.config, nil
+	}
+	config, err := s.load()
+	if err != nil {
+		if !s.loaded {
+			return serviceTokenConfig{}, err
+		}
+		slog.Warn("service token key reload failed; keeping previous key", slog.Any("error", err))
+		s.loadedAt = now
+		return s.config, nil
+	}
+	s.config, s.loaded, s.loadedAt = config, true, now
+	return config, nil
+}
+
+// sign sets serviceTokenHeader on req, or removes it when no key is
+// configured.
+func (s *serviceTokenSigner) sign(req *http.Request) error {
+	config, err := s.current()
+	if err != nil {
+		return err
+	}
+	if config.key == nil {
+		req.Header.Del(serviceTokenHeader)
+		return nil
+	}
+	now := s.now()
+	token, err := signGatewayToken(config.key, gatewayTokenService, serviceToken{
+		ID:        uuid.NewString(),
+		Issuer:    "gateway",
+		Audience:  "orchestrator",
+		KeyID:     config.keyID,
+		Method:    req.Method,
+		Path:      req.URL.EscapedPath(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Add(-config.skew).Unix(),
+		ExpiresAt: now.Add(config.ttl).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	req.Header.Set(serviceTokenHeader, token)
+	return nil
+}
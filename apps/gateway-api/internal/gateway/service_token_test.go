@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testServiceTokenKey = "service-token-key-0123456789abcdef"
+
+func useServiceTokenSigner(t *testing.T) *serviceTokenSigner {
+	t.Helper()
+	original := serviceTokens
+	serviceTokens = newServiceTokenSigner()
+	t.Cleanup(func() { serviceTokens = original })
+	return serviceTokens
+}
+
+func TestServiceTokenSignsRequests(t *testing.T) {
+	t.Setenv("GATEWAY_SERVICE_TOKEN_KEY", testServiceTokenKey)
+	t.Setenv("GATEWAY_SERVICE_TOKEN_KEY_ID", "2024-01")
+	now := time.Unix(1_700_000_000, 0)
+	signer := newServiceTokenSigner()
+	signer.now = func() time.Time { return now }
+
+	req := httptest.NewRequest(http.MethodPost, "http://orchestrator/plan/a%2Fb/approve", nil)
+	if err := signer.sign(req); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	var claims serviceToken
+	if err := verifyGatewayToken([]byte(testServiceTokenKey), gatewayTokenService, req.Header.Get(serviceTokenHeader), &claims); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if claims.Method != http.MethodPost || claims.Path != "/plan/a%2Fb/approve" || claims.KeyID != "2024-01" || claims.Audience != "orchestrator" || claims.ID == "" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	if claims.IssuedAt != now.Unix() || claims.NotBefore != now.Add(-defaultServiceTokenClockSkew).Unix() || claims.ExpiresAt != now.Add(defaultServiceTokenTTL).Unix() {
+		t.Fatalf("unexpected validity window %+v", claims)
+	}
+}
+
+func TestServiceTokenRemovesClientSuppliedTokens(t *testing.T) {
+	t.Setenv("GATEWAY_SERVICE_TOKEN_KEY", "")
+	req := httptest.NewRequest(http.MethodGet, "http://orchestrator/events", nil)
+	req.Header.Set(serviceTokenHeader, "v1.forged.token")
+	if err := newServiceTokenSigner().sign(req); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if req.Header.Get(serviceTokenHeader) != "" {
+		t.Fatal("expected a client-supplied token to be dropped")
+	}
+}
+
+func TestServiceTokenKeyRotation(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	key := testServiceTokenKey
+	var loadErr error
+	signer := newServiceTokenSigner()
+	signer.now = func() time.Time { return now }
+	signer.load = func() (serviceTokenConfig, error) {
+		return serviceTokenConfig{key: []byte(key), ttl: time.Minute}, loadErr
+	}
+	signedWith := func(key string) bool {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "http://orchestrator/events", nil)
+		if err := signer.sign(req); err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return verifyGatewayToken([]byte(key), gatewayTokenService, req.Header.Get(serviceTokenHeader), &serviceToken{}) == nil
+	}
+
+	rotated := strings.Repeat("r", minServiceTokenKeyBytes)
+	_ = signedWith(testServiceTokenKey)
+	key = rotated
+	if !signedWith(testServiceTokenKey) {
+		t.Fatal("expected the key to be reused until the reload interval passes")
+	}
+	now = now.Add(serviceTokenKeyReload)
+	if !signedWith(rotated) {
+		t.Fatal("expected the rotated key after the reload interval")
+	}
+	now = now.Add(serviceTokenKeyReload)
+	loadErr = errors.New("permission denied")
+	if !signedWith(rotated) {
+		t.Fatal("expected a failed reload to keep the previous key")
+	}
+}
+
+func TestInstrumentedTransportAttachesServiceToken(t *testing.T) {
+	t.Setenv("GATEWAY_SERVICE_TOKEN_KEY", testServiceTokenKey)
+	useServiceTokenSigner(t)
+	var received string
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(serviceTokenHeader)
+	}))
+	defer orchestrator.Close()
+
+	client := &http.Client{Transport: newInstrumentedTransport(newUpstreamTransport(egressOrchestrator))}
+	req, _ := http.NewRequest(http.MethodGet, orchestrator.URL+"/auth/session", nil)
+	req.Header.Set(serviceTokenHeader, "v1.forged.token")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	var claims serviceToken
+	if err := verifyGatewayToken([]byte(testServiceTokenKey), gatewayTokenService, received, &claims); err != nil || claims.Path != "/auth/session" {
+		t.Fatalf("expected a signed token for the request, got %q (%v)", received, err)
+	}
+	if req.Header.Get(serviceTokenHeader) != "v1.forged.token" {
+		t.Fatal("expected the caller's request to be left untouched")
+	}
+}
+
+func TestLoadServiceTokenConfig(t *testing.T) {
+	cases := []map[string]string{
+		{"GATEWAY_SERVICE_TOKEN_KEY": "short"},
+		{"GATEWAY_SERVICE_TOKEN_KEY": testServiceTokenKey, "GATEWAY_SERVICE_TOKEN_KEY_ID": "key id"},
+		{"GATEWAY_SERVICE_TOKEN_KEY": testServiceTokenKey, "GATEWAY_SERVICE_TOKEN_TTL": "0s"},
+	}
+	for _, env := range cases {
+		for key, value := range env {
+			t.Setenv(key, value)
+		}
+		if err := ValidateServiceTokenConfig(); err == nil {
+			t.Fatalf("expected %v to be rejected", env)
+		}
+		t.Setenv("GATEWAY_SERVICE_TOKEN_KEY_ID", "")
+		t.Setenv("GATEWAY_SERVICE_TOKEN_TTL", "")
+	}
+	t.Setenv("GATEWAY_SERVICE_TOKEN_KEY", "")
+	if config, err := loadServiceTokenConfig(); err != nil || config.key != nil {
+		t.Fatalf("expected service tokens to be off without a key, got %+v: %v", config, err)
+	}
+}
@@ -0,0 +1,600 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/deployment"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	auditEventSLOBurnRate = "gateway.slo.burn_rate"
+	auditTargetSLO        = "gateway.slo"
+
+	// Route classes with a success objective. Other routes, such as health
+	// checks and the admin API, are not tracked.
+	sloClassAuth   = "auth"
+	sloClassEvents = "events"
+	sloClassProxy  = "proxy"
+
+	defaultSLOObjective          = 0.999
+	defaultSLOAlertRules         = "page=1h/5m:14.4,ticket=6h/30m:6"
+	defaultSLOBudgetWindow       = 30 * 24 * time.Hour
+	defaultSLOMinRequests        = 20
+	defaultSLOEvaluationInterval = time.Minute
+
+	// sloBucketWidth is the resolution of the success counts.
+	sloBucketWidth = time.Minute
+	// maxSLOWindow bounds the budget and alert windows, and with them the
+	// buckets kept per class.
+	maxSLOWindow = 90 * 24 * time.Hour
+)
+
+var (
+	sloClasses         = []string{sloClassAuth, sloClassEvents, sloClassProxy}
+	sloRuleNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+	sloAlertClient = &http.Client{Timeout: 10 * time.Second, Transport: observeUpstreamCalls(egressSLOAlert, newUpstreamTransport(egressSLOAlert))}
+)
+
+// sloRouteClass returns the class of a request path, or "" for routes
+// without an objective.
+func sloRouteClass(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/auth/"):
+		return sloClassAuth
+	case path == "/events":
+		return sloClassEvents
+	case strings.HasPrefix(path, "/plan/"), strings.HasPrefix(path, "/collaboration/"):
+		return sloClassProxy
+	default:
+		return ""
+	}
+}
+
+// sloAlertRule is one entry of GATEWAY_SLO_ALERT_RULES: it fires when the
+// burn rate over both the long and the short window reaches threshold. The
+// short window makes the alert resolve soon after the errors stop.
+type sloAlertRule struct {
+	name      string
+	long      time.Duration
+	short     time.Duration
+	threshold float64
+}
+
+type sloConfig struct {
+	objectives   map[string]float64
+	rules        []sloAlertRule
+	budgetWindow time.Duration
+	minRequests  int64
+	webhookURL   string
+}
+
+func parseSLOObjectives(raw string) (map[string]float64, error) {
+	objectives := make(map[string]float64, len(sloClasses))
+	for _, class := range sloClasses {
+		objectives[class] = defaultSLOObjective
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		class, value, ok := strings.Cut(entry, "=")
+		class = strings.TrimSpace(class)
+		if !ok || !slices.Contains(sloClasses, class) {
+			return nil, fmt.Errorf("GATEWAY_SLO_OBJECTIVES entry %q must be class=target with class one of %s", entry, strings.Join(sloClasses, ", "))
+		}
+		target, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || target <= 0 || target >= 1 {
+			return nil, fmt.Errorf("GATEWAY_SLO_OBJECTIVES target for %s must be between 0 and 1 exclusive", class)
+		}
+		objectives[class] = target
+	}
+	return objectives, nil
+}
+
+// parseSLOAlertRules parses name=long/short:threshold entries such as
+// page=1h/5m:14.4.
+func parseSLOAlertRules(raw string) ([]sloAlertRule, error) {
+	if strings.EqualFold(strings.TrimSpace(raw), "none") {
+		return nil, nil
+	}
+	var rules []sloAlertRule
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		invalid := fmt.Errorf("GATEWAY_SLO_ALERT_RULES entry %q must be name=long/short:threshold", entry)
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok || !sloRuleNamePattern.MatchString(name) {
+			return nil, invalid
+		}
+		windows, threshold, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, invalid
+		}
+		longRaw, shortRaw, ok := strings.Cut(windows, "/")
+		if !ok {
+			return nil, invalid
+		}
+		rule := sloAlertRule{name: name}
+		var err error
+		if rule.long, err = time.ParseDuration(longRaw); err != nil {
+			return nil, invalid
+		}
+		if rule.short, err = time.ParseDuration(shortRaw); err != nil {
+			return nil, invalid
+		}
+		if rule.threshold, err = strconv.ParseFloat(threshold, 64); err != nil || rule.threshold <= 0 {
+			return nil, fmt.Errorf("GATEWAY_SLO_ALERT_RULES rule %s needs a positive threshold", name)
+		}
+		if rule.short < sloBucketWidth || rule.long <= rule.short || rule.long > maxSLOWindow {
+			return nil, fmt.Errorf("GATEWAY_SLO_ALERT_RULES rule %s needs a short window of at least %s and a longer long window of at most %s", name, sloBucketWidth, maxSLOWindow)
+		}
+		if slices.ContainsFunc(rules, func(r sloAlertRule) bool { return r.name == name }) {
+			return nil, fmt.Errorf("GATEWAY_SLO_ALERT_RULES rule %s is listed twice", name)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func loadSLOConfig() (sloConfig, error) {
+	objectives, err := parseSLOObjectives(GetEnv("GATEWAY_SLO_OBJECTIVES", ""))
+	if err != nil {
+		return sloConfig{}, err
+	}
+	rules, err := parseSLOAlertRules(GetEnv("GATEWAY_SLO_ALERT_RULES", defaultSLOAlertRules))
+	if err != nil {
+		return sloConfig{}, err
+	}
+	cfg := sloConfig{objectives: objectives, rules: rules, budgetWindow: defaultSLOBudgetWindow, minRequests: defaultSLOMinRequests}
+	if raw := strings.TrimSpace(GetEnv("GATEWAY_SLO_BUDGET_WINDOW", "")); raw != "" {
+		window, err := time.ParseDuration(raw)
+		if err != nil || window < time.Hour || window > maxSLOWindow {
+			return sloConfig{}, fmt.Errorf("GATEWAY_SLO_BUDGET_WINDOW must be a duration between 1h and %s", maxSLOWindow)
+		}
+		cfg.budgetWindow = window
+	}
+	if raw := strings.TrimSpace(GetEnv("GATEWAY_SLO_MIN_REQUESTS", "")); raw != "" {
+		minRequests, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || minRequests < 1 {
+			return sloConfig{}, errors.New("GATEWAY_SLO_MIN_REQUESTS must be a positive integer")
+		}
+		cfg.minRequests = minRequests
+	}
+	webhook, err := ResolveEnvValue("GATEWAY_SLO_ALERT_WEBHOOK_URL")
+	if err != nil {
+		return sloConfig{}, err
+	}
+	if webhook != "" {
+		parsed, err := url.Parse(webhook)
+		if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return sloConfig{}, errors.New("GATEWAY_SLO_ALERT_WEBHOOK_URL must be an absolute http(s) URL")
+		}
+		cfg.webhookURL = webhook
+	}
+	return cfg, nil
+}
+
+// sloBucket counts the requests of one minute.
+type sloBucket struct {
+	minute int64
+	total  int64
+	errors int64
+}
+
+// sloCounts are the requests of one class over a window.
+type sloCounts struct {
+	total  int64
+	errors int64
+}
+
+// burnRate is how many times faster than the objective allows the window
+// spent error budget: 1 spends exactly the budget over the budget window.
+func (c sloCounts) burnRate(objective float64) float64 {
+	if c.total == 0 {
+		return 0
+	}
+	return float64(c.errors) / float64(c.total) / (1 - objective)
+}
+
+// budgetRemaining is the fraction of the error budget left, negative once
+// it is overspent.
+func (c sloCounts) budgetRemaining(objective float64) float64 {
+	return 1 - c.burnRate(objective)
+}
+
+// sloClassTracker keeps per-minute counts of one class in a ring long
+// enough for the longest window.
+type sloClassTracker struct {
+	mu      sync.Mutex
+	buckets []sloBucket
+}
+
+func (c *sloClassTracker) record(minute int64, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bucket := &c.buckets[minute%int64(len(c.buckets))]
+	if bucket.minute != minute {
+		*bucket = sloBucket{minute: minute}
+	}
+	bucket.total++
+	if failed {
+		bucket.errors++
+	}
+}
+
+// counts sums the window ending with minute.
+func (c *sloClassTracker) counts(minute int64, window time.Duration) sloCounts {
+	n := min(int64((window+sloBucketWidth-1)/sloBucketWidth), int64(len(c.buckets)))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var counts sloCounts
+	for m := minute - n + 1; m <= minute; m++ {
+		if bucket := c.buckets[m%int64(len(c.buckets))]; bucket.minute == m {
+			counts.total += bucket.total
+			counts.errors += bucket.errors
+		}
+	}
+	return counts
+}
+
+// sloTracker tracks the success ratio of each route class and raises burn
+// rate alerts. Counts are kept in memory per replica, so each replica
+// alerts on the traffic it served.
+type sloTracker struct {
+	cfg     sloConfig
+	classes map[string]*sloClassTracker
+	now     func() time.Time
+
+	mu     sync.Mutex
+	firing map[string]bool
+}
+
+func newSLOTracker(cfg sloConfig) *sloTracker {
+	longest := cfg.budgetWindow
+	for _, rule := range cfg.rules {
+		longest = max(longest, rule.long)
+	}
+	t := &sloTracker{cfg: cfg, classes: make(map[string]*sloClassTracker, len(sloClasses)), now: time.Now, firing: map[string]bool{}}
+	for _, class := range sloClasses {
+		t.classes[class] = &sloClassTracker{buckets: make([]sloBucket, longest/sloBucketWidth)}
+	}
+	return t
+}
+
+func (t *sloTracker) minute() int64 {
+	return t.now().Unix() / int64(sloBucketWidth/time.Second)
+}
+
+func (t *sloTracker) record(class string, status int) {
+	if tracker := t.classes[class]; tracker != nil {
+		tracker.record(t.minute(), status >= http.StatusInternalServerError)
+	}
+}
+
+func (t *sloTracker) counts(class string, window time.Duration) sloCounts {
+	return t.classes[class].counts(t.minute(), window)
+}
+
+// windows lists the distinct alert windows, shortest first.
+func (t *sloTracker) windows() []time.Duration {
+	var windows []time.Duration
+	for _, rule := range t.cfg.rules {
+		for _, window := range []time.Duration{rule.long, rule.short} {
+			if !slices.Contains(windows, window) {
+				windows = append(windows, window)
+			}
+		}
+	}
+	slices.Sort(windows)
+	return windows
+}
+
+// sloAlert is the state change of one rule for one class, sent to
+// GATEWAY_SLO_ALERT_WEBHOOK_URL as JSON.
+type sloAlert struct {
+	Status               string  `json:"status"`
+	Class                string  `json:"class"`
+	Rule                 string  `json:"rule"`
+	Objective            float64 `json:"objective"`
+	Threshold            float64 `json:"threshold"`
+	BurnRateLong         float64 `json:"burn_rate_long"`
+	BurnRateShort        float64 `json:"burn_rate_short"`
+	WindowLong           string  `json:"window_long"`
+	WindowShort          string  `json:"window_short"`
+	Requests             int64   `json:"requests"`
+	ErrorBudgetRemaining float64 `json:"error_budget_remaining"`
+	// Replica names the replica whose traffic the alert covers.
+	Replica *deployment.Metadata `json:"replica,omitempty"`
+}
+
+const (
+	sloAlertFiring   = "firing"
+	sloAlertResolved = "resolved"
+)
+
+// evaluate checks every rule against every class and returns the alerts
+// that started or stopped firing since the last evaluation. A rule only
+// fires once the long window holds minRequests requests.
+func (t *sloTracker) evaluate() []sloAlert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var alerts []sloAlert
+	for _, class := range sloClasses {
+		objective := t.cfg.objectives[class]
+		budget := t.counts(class, t.cfg.budgetWindow).budgetRemaining(objective)
+		for _, rule := range t.cfg.rules {
+			long, short := t.counts(class, rule.long), t.counts(class, rule.short)
+			alert := sloAlert{
+				Class:                class,
+				Rule:                 rule.name,
+				Objective:            objective,
+				Threshold:            rule.threshold,
+				BurnRateLong:         roundSLO(long.burnRate(objective)),
+				BurnRateShort:        roundSLO(short.burnRate(objective)),
+				WindowLong:           rule.long.String(),
+				WindowShort:          rule.short.String(),
+				Requests:             long.total,
+				ErrorBudgetRemaining: roundSLO(budget),
+			}
+			firing := long.total >= t.cfg.minRequests && alert.BurnRateLong >= rule.threshold && alert.BurnRateShort >= rule.threshold
+			key := class + "/" + rule.name
+			if firing == t.firing[key] {
+				continue
+			}
+			t.firing[key] = firing
+			alert.Status = sloAlertResolved
+			if firing {
+				alert.Status = sloAlertFiring
+			}
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func roundSLO(value float64) float64 {
+	return math.Round(value*1e4) / 1e4
+}
+
+var (
+	sloTrackerMu   sync.Mutex
+	sloTrackerOnce sync.Once
+	sloTrackerInst *sloTracker
+	sloTrackerErr  error
+	sloGaugesOnce  sync.Once
+)
+
+// resetSLOTracker clears the shared tracker for tests.
+func resetSLOTracker() {
+	sloTrackerMu.Lock()
+	defer sloTrackerMu.Unlock()
+	sloTrackerOnce = sync.Once{}
+	sloTrackerInst = nil
+	sloTrackerErr = nil
+}
+
+// loadSLOTracker returns the shared tracker, or nil when
+// GATEWAY_SLO_OBJECTIVES is off.
+func loadSLOTracker() (*sloTracker, error) {
+	sloTrackerMu.Lock()
+	defer sloTrackerMu.Unlock()
+	sloTrackerOnce.Do(func() {
+		if strings.EqualFold(strings.TrimSpace(GetEnv("GATEWAY_SLO_OBJECTIVES", "")), "off") {
+			return
+		}
+		cfg, err := loadSLOConfig()
+		if err != nil {
+			sloTrackerErr = err
+			return
+		}
+		sloTrackerInst = newSLOTracker(cfg)
+	})
+	return sloTrackerInst, sloTrackerErr
+}
+
+// ValidateSLOConfig checks the GATEWAY_SLO_* settings at startup.
+func ValidateSLOConfig() error {
+	_, err := loadSLOTracker()
+	return err
+}
+
+// SLOMiddleware counts the requests of each route class against its
+// objective. A response below 500 is a success; a stream or WebSocket is
+// counted once its headers are written. It belongs inside DrainMiddleware
+// so a draining replica's refusals do not spend the budget.
+func SLOMiddleware(next http.Handler) http.Handler {
+	tracker, _ := loadSLOTracker()
+	if tracker == nil {
+		return next
+	}
+	registerSLOGauges()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := sloRouteClass(r.URL.Path)
+		if class == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &sloResponseWriter{ResponseWriter: w, record: func(status int) { tracker.record(class, status) }}
+		next.ServeHTTP(sw, r)
+		sw.observe(http.StatusOK)
+	})
+}
+
+// sloResponseWriter reports the response status once.
+type sloResponseWriter struct {
+	http.ResponseWriter
+	record   func(int)
+	recorded bool
+}
+
+func (sw *sloResponseWriter) observe(status int) {
+	if !sw.recorded {
+		sw.recorded = true
+		sw.record(status)
+	}
+}
+
+func (sw *sloResponseWriter) WriteHeader(status int) {
+	if status >= http.StatusOK {
+		sw.observe(status)
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *sloResponseWriter) Write(p []byte) (int, error) {
+	sw.observe(http.StatusOK)
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *sloResponseWriter) Flush() {
+	sw.observe(http.StatusOK)
+	_ = http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+func (sw *sloResponseWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// Hijack hands the connection to a WebSocket proxy; the upgrade counts as a
+// success.
+func (sw *sloResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(sw.ResponseWriter).Hijack()
+	if err == nil {
+		sw.observe(http.StatusSwitchingProtocols)
+	}
+	return conn, brw, err
+}
+
+// evaluateSLOs is the slo_evaluate maintenance job. Each alert that starts
+// or stops firing is audited and, when GATEWAY_SLO_ALERT_WEBHOOK_URL is set,
+// posted there.
+func evaluateSLOs(ctx context.Context) error {
+	tracker, err := loadSLOTracker()
+	if err != nil || tracker == nil {
+		return err
+	}
+	var errs []error
+	for _, alert := range tracker.evaluate() {
+		outcome := auditOutcomeFailure
+		if alert.Status == sloAlertResolved {
+			outcome = auditOutcomeSuccess
+		}
+		slog.WarnContext(ctx, "gateway.slo.burn_rate_alert",
+			slog.String("status", alert.Status),
+			slog.String("class", alert.Class),
+			slog.String("rule", alert.Rule),
+			slog.Float64("burn_rate_long", alert.BurnRateLong),
+			slog.Float64("burn_rate_short", alert.BurnRateShort))
+		gatewayAuditLogger.Security(ctx, audit.Event{
+			Name:       auditEventSLOBurnRate,
+			Outcome:    outcome,
+			Target:     auditTargetSLO,
+			Capability: auditTargetSLO,
+			Details: map[string]any{
+				"class":                  alert.Class,
+				"rule":                   alert.Rule,
+				"objective":              alert.Objective,
+				"threshold":              alert.Threshold,
+				"burn_rate_long":         alert.BurnRateLong,
+				"burn_rate_short":        alert.BurnRateShort,
+				"window_long":            alert.WindowLong,
+				"window_short":           alert.WindowShort,
+				"requests":               alert.Requests,
+				"error_budget_remaining": alert.ErrorBudgetRemaining,
+			},
+		})
+		if tracker.cfg.webhookURL != "" {
+			if err := postSLOAlert(ctx, tracker.cfg.webhookURL, alert); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func postSLOAlert(ctx context.Context, target string, alert sloAlert) error {
+	if replica := deployment.Current(); !replica.IsZero() {
+		alert.Replica = &replica
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("slo alert webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := sloAlertClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("slo alert webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("slo alert webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// registerSLOGauges publishes gateway.slo.burn_rate for every alert window
+// and gateway.slo.error_budget_remaining through the global meter provider.
+func registerSLOGauges() {
+	sloGaugesOnce.Do(func() {
+		meter := otel.Meter("gateway.slo")
+		burnRate, err := meter.Float64ObservableGauge("gateway.slo.burn_rate",
+			metric.WithDescription("Error budget burn rate by route class and window."))
+		if err != nil {
+			slog.Warn("gateway.slo.gauge_failed", slog.String("error", err.Error()))
+			return
+		}
+		budget, err := meter.Float64ObservableGauge("gateway.slo.error_budget_remaining",
+			metric.WithDescription("Fraction of the error budget left over the budget window, by route class."))
+		if err != nil {
+			slog.Warn("gateway.slo.gauge_failed", slog.String("error", err.Error()))
+			return
+		}
+		_, err = meter.RegisterCallback(func(_ context.Context, observer metric.Observer) error {
+			tracker, _ := loadSLOTracker()
+			if tracker == nil {
+				return nil
+			}
+			for _, class := range sloClasses {
+				objective := tracker.cfg.objectives[class]
+				for _, window := range tracker.windows() {
+					observer.ObserveFloat64(burnRate, tracker.counts(class, window).burnRate(objective),
+						withDeploymentAttributes(attribute.String("class", class), attribute.String("window", window.String())))
+				}
+				observer.ObserveFloat64(budget, tracker.counts(class, tracker.cfg.budgetWindow).budgetRemaining(objective),
+					withDeploymentAttributes(attribute.String("class", class)))
+			}
+			return nil
+		}, burnRate, budget)
+		if err != nil {
+			slog.Warn("gateway.slo.gauge_failed", slog.String("error", err.Error()))
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useSLOTracker(t *testing.T, env map[string]string) *sloTracker {
+	t.Helper()
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	resetSLOTracker()
+	t.Cleanup(resetSLOTracker)
+	tracker, err := loadSLOTracker()
+	if err != nil {
+		t.Fatalf("load SLO tracker: %v", err)
+	}
+	return tracker
+}
+
+func TestSLOConfigValidation(t *testing.T) {
+	cases := map[string]map[string]string{
+		"unknown class":     {"GATEWAY_SLO_OBJECTIVES": "admin=0.99"},
+		"objective too big": {"GATEWAY_SLO_OBJECTIVES": "auth=1"},
+		"malformed rule":    {"GATEWAY_SLO_ALERT_RULES": "page=1h:14.4"},
+		"short not shorter": {"GATEWAY_SLO_ALERT_RULES": "page=5m/1h:14.4"},
+		"duplicate rule":    {"GATEWAY_SLO_ALERT_RULES": "page=1h/5m:14.4,page=6h/30m:6"},
+		"budget window":     {"GATEWAY_SLO_BUDGET_WINDOW": "10m"},
+		"webhook":           {"GATEWAY_SLO_ALERT_WEBHOOK_URL": "hooks.example.com/slo"},
+	}
+	for name, env := range cases {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range env {
+				t.Setenv(key, value)
+			}
+			resetSLOTracker()
+			t.Cleanup(resetSLOTracker)
+			if err := ValidateSLOConfig(); err == nil {
+				t.Fatalf("expected %v to be rejected", env)
+			}
+		})
+	}
+
+	tracker := useSLOTracker(t, map[string]string{"GATEWAY_SLO_OBJECTIVES": "events=0.99"})
+	if tracker.cfg.objectives[sloClassEvents] != 0.99 || tracker.cfg.objectives[sloClassAuth] != defaultSLOObjective {
+		t.Fatalf("unexpected objectives %v", tracker.cfg.objectives)
+	}
+	if len(tracker.cfg.rules) != 2 || tracker.cfg.rules[0].long != time.Hour || tracker.cfg.rules[0].short != 5*time.Minute {
+		t.Fatalf("unexpected default rules %+v", tracker.cfg.rules)
+	}
+
+	if tracker := useSLOTracker(t, map[string]string{"GATEWAY_SLO_OBJECTIVES": "off"}); tracker != nil {
+		t.Fatal("expected GATEWAY_SLO_OBJECTIVES=off to disable tracking")
+	}
+}
+
+func TestSLOBurnRateWindows(t *testing.T) {
+	tracker := newSLOTracker(sloConfig{
+		objectives:   map[string]float64{sloClassAuth: 0.99, sloClassEvents: 0.99, sloClassProxy: 0.99},
+		rules:        []sloAlertRule{{name: "page", long: time.Hour, short: 5 * time.Minute, threshold: 10}},
+		budgetWindow: 2 * time.Hour,
+		minRequests:  1,
+	})
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+	// 90 minutes ago: 100 requests, 50 failed. Now: 100 requests, 10 failed.
+	now = now.Add(-90 * time.Minute)
+	for i := range 100 {
+		tracker.record(sloClassAuth, map[bool]int{true: http.StatusBadGateway, false: http.StatusOK}[i < 50])
+	}
+	now = now.Add(90 * time.Minute)
+	for i := range 100 {
+		tracker.record(sloClassAuth, map[bool]int{true: http.StatusServiceUnavailable, false: http.StatusNotFound}[i < 10])
+	}
+
+	if got := tracker.counts(sloClassAuth, 5*time.Minute).burnRate(0.99); got < 9.99 || got > 10.01 {
+		t.Fatalf("expected a 10x burn rate over 5m, got %v", got)
+	}
+	if counts := tracker.counts(sloClassAuth, time.Hour); counts.total != 100 {
+		t.Fatalf("expected the old requests outside the 1h window, got %+v", counts)
+	}
+	// 60 errors in 200 requests against a 1% budget is 30x: the budget is
+	// overspent.
+	if got := tracker.counts(sloClassAuth, 2*time.Hour).budgetRemaining(0.99); got > -28.99 || got < -29.01 {
+		t.Fatalf("expected an overspent budget, got %v", got)
+	}
+	if counts := tracker.counts(sloClassProxy, time.Hour); counts != (sloCounts{}) {
+		t.Fatalf("expected classes to be counted separately, got %+v", counts)
+	}
+}
+
+func TestSLOMiddlewareClassifiesRoutes(t *testing.T) {
+	tracker := useSLOTracker(t, nil)
+	status := map[string]int{"/auth/github/callback": http.StatusBadGateway, "/events": http.StatusOK, "/plan/p/attachments": http.StatusBadRequest, "/healthz": http.StatusInternalServerError}
+	handler := SLOMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status[r.URL.Path])
+		w.WriteHeader(http.StatusOK)
+	}))
+	for path := range status {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	want := map[string]sloCounts{
+		sloClassAuth:   {total: 1, errors: 1},
+		sloClassEvents: {total: 1},
+		sloClassProxy:  {total: 1},
+	}
+	for class, counts := range want {
+		if got := tracker.counts(class, time.Minute); got != counts {
+			t.Errorf("%s: expected %+v, got %+v", class, counts, got)
+		}
+	}
+}
+
+func TestEvaluateSLOsAlertsAndResolves(t *testing.T) {
+	var received []sloAlert
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert sloAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("decode alert: %v", err)
+		}
+		received = append(received, alert)
+	}))
+	defer webhook.Close()
+	tracker := useSLOTracker(t, map[string]string{
+		"GATEWAY_SLO_ALERT_RULES":       "page=1h/5m:14.4",
+		"GATEWAY_SLO_MIN_REQUESTS":      "10",
+		"GATEWAY_SLO_ALERT_WEBHOOK_URL": webhook.URL,
+	})
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+	logs := captureAuditLogs(t)
+
+	for range 5 {
+		tracker.record(sloClassEvents, http.StatusBadGateway)
+	}
+	if err := evaluateSLOs(context.Background()); err != nil || len(received) != 0 {
+		t.Fatalf("expected no alert below GATEWAY_SLO_MIN_REQUESTS, got %v %v", received, err)
+	}
+	for range 5 {
+		tracker.record(sloClassEvents, http.StatusBadGateway)
+	}
+	if err := evaluateSLOs(context.Background()); err != nil {
+		t.Fatalf("evaluate: %v", err)
+	}
+	if len(received) != 1 || received[0].Status != sloAlertFiring || received[0].Class != sloClassEvents || received[0].Rule != "page" || received[0].Requests != 10 {
+		t.Fatalf("expected the page rule to fire for events, got %+v", received)
+	}
+	if !strings.Contains(logs.String(), `"event":"gateway.slo.burn_rate"`) || !strings.Contains(logs.String(), `"outcome":"failure"`) {
+		t.Fatalf("expected a failure audit event, got %s", logs.String())
+	}
+	if err := evaluateSLOs(context.Background()); err != nil || len(received) != 1 {
+		t.Fatalf("expected a firing alert to be sent once, got %+v %v", received, err)
+	}
+
+	// Once the errors leave the short window the alert resolves.
+	now = now.Add(10 * time.Minute)
+	tracker.record(sloClassEvents, http.StatusOK)
+	if err := evaluateSLOs(context.Background()); err != nil {
+		t.Fatalf("evaluate: %v", err)
+	}
+	if len(received) != 2 || received[1].Status != sloAlertResolved || received[1].BurnRateShort != 0 {
+		t.Fatalf("expected the alert to resolve, got %+v", received)
+	}
+}
@@ -0,0 +1,449 @@
+package gateway
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const (
+	defaultSlowRequestsMax           = 50
+	defaultSlowRequestsWindow        = 15 * time.Minute
+	defaultSlowRequestCaptureWindow  = 5 * time.Minute
+	maxSlowRequestCapturedCalls      = 32
+	slowRequestRouteUnmatched        = "unmatched"
+	slowRequestEventStreamMediaType  = "text/event-stream"
+	slowRequestMaxCapturedPathLength = 256
+)
+
+// slowRequest is one entry of the flight recorder. Durations are rendered
+// like the other admin API durations. For streams (SSE and WebSocket
+// upgrades) Duration is the time until the response started, so long-lived
+// connections are ranked by how long they took to open.
+type slowRequest struct {
+	Route         string              `json:"route"`
+	Method        string              `json:"method"`
+	Status        int                 `json:"status"`
+	Streaming     bool                `json:"streaming,omitempty"`
+	StartedAt     time.Time           `json:"started_at"`
+	Duration      string              `json:"duration"`
+	TimeToHeaders string              `json:"time_to_headers,omitempty"`
+	Upstream      string              `json:"upstream,omitempty"`
+	UpstreamCalls int                 `json:"upstream_calls,omitempty"`
+	Gateway       string              `json:"gateway"`
+	TraceID       string              `json:"trace_id,omitempty"`
+	RequestID     string              `json:"request_id,omitempty"`
+	Calls         []slowRequestUpCall `json:"calls,omitempty"`
+	Captured      bool                `json:"captured,omitempty"`
+	duration      time.Duration
+	recordedAt    time.Time
+}
+
+// slowRequestUpCall details one upstream round trip of a request captured
+// while its route was under verbose capture.
+type slowRequestUpCall struct {
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Status   int    `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Offset   string `json:"offset"`
+	Duration string `json:"duration"`
+}
+
+// slowRequestsResponse is returned by GET /admin/slow.
+type slowRequestsResponse struct {
+	Window    string               `json:"window"`
+	Threshold string               `json:"threshold,omitempty"`
+	Captures  []slowRequestCapture `json:"captures"`
+	Requests  []slowRequest        `json:"requests"`
+}
+
+// slowRequestCapture is a route whose requests are recorded in detail until
+// Until because one of them exceeded the threshold.
+type slowRequestCapture struct {
+	Route string    `json:"route"`
+	Until time.Time `json:"until"`
+}
+
+// requestTimings collects the timings of one request as it passes through
+// the gateway. Upstream transports add to it from the request context.
+type requestTimings struct {
+	start    time.Time
+	route    atomic.Pointer[string]
+	upstream atomic.Int64
+	calls    atomic.Int64
+	// capture is set while the route is under verbose capture; the router
+	// decides once the route is known.
+	capture atomic.Bool
+
+	mu       sync.Mutex
+	detailed []slowRequestUpCall
+}
+
+type requestTimingsContextKey struct{}
+
+func requestTimingsFrom(ctx context.Context) *requestTimings {
+	timings, _ := ctx.Value(requestTimingsContextKey{}).(*requestTimings)
+	return timings
+}
+
+// setRoute records the pattern the router matched and starts verbose capture
+// when the route is being watched.
+func (t *requestTimings) setRoute(pattern string) {
+	t.route.Store(&pattern)
+	if recorder, _ := loadSlowRequests(); recorder != nil && recorder.capturing(pattern, time.Now()) {
+		t.capture.Store(true)
+	}
+}
+
+// recordUpstream adds one upstream round trip. elapsed runs until the
+// response headers arrived, since bodies are streamed to the client.
+func (t *requestTimings) recordUpstream(req *http.Request, resp *http.Response, err error, started time.Time, elapsed time.Duration) {
+	t.upstream.Add(int64(elapsed))
+	t.calls.Add(1)
+	if !t.capture.Load() {
+		return
+	}
+	call := slowRequestUpCall{
+		Method:   req.Method,
+		Path:     truncateCapturedPath(req.URL.Path),
+		Offset:   started.Sub(t.start).String(),
+		Duration: elapsed.String(),
+	}
+	if resp != nil {
+		call.Status = resp.StatusCode
+	}
+	if err != nil {
+		call.Error = err.Error()
+	}
+	t.mu.Lock()
+	if len(t.detailed) < maxSlowRequestCapturedCalls {
+		t.detailed = append(t.detailed, call)
+	}
+	t.mu.Unlock()
+}
+
+func truncateCapturedPath(path string) string {
+	if len(path) > slowRequestMaxCapturedPathLength {
+		return path[:slowRequestMaxCapturedPathLength]
+	}
+	return path
+}
+
+// recordUpstreamTiming attributes an upstream round trip to the request it
+// was made for, if the slow request recorder is timing it.
+func recordUpstreamTiming(req *http.Request, resp *http.Response, err error, started time.Time) {
+	if timings := requestTimingsFrom(req.Context()); timings != nil {
+		timings.recordUpstream(req, resp, err, started, time.Since(started))
+	}
+}
+
+// slowRequestRecorder keeps the slowest requests of the last window in
+// memory. When a request exceeds the threshold its route is captured in
+// detail, upstream call by upstream call, for the capture window.
+type slowRequestRecorder struct {
+	max           int
+	window        time.Duration
+	threshold     time.Duration
+	captureWindow time.Duration
+	now           func() time.Time
+
+	mu       sync.Mutex
+	entries  []slowRequest
+	captures map[string]time.Time
+}
+
+func newSlowRequestRecorder(max int, window, threshold, captureWindow time.Duration) *slowRequestRecorder {
+	return &slowRequestRecorder{
+		max:           max,
+		window:        window,
+		threshold:     threshold,
+		captureWindow: captureWindow,
+		now:           time.Now,
+		captures:      make(map[string]time.Time),
+	}
+}
+
+func (s *slowRequestRecorder) capturing(route string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.captures[route]
+	if ok && !now.Before(until) {
+		delete(s.captures, route)
+		return false
+	}
+	return ok
+}
+
+// record adds entry if it is among the slowest of the window, and starts
+// verbose capture of its route when it exceeds the threshold.
+func (s *slowRequestRecorder) record(entry slowRequest) {
+	now := s.now()
+	entry.recordedAt = now
+	if s.add(entry, now) {
+		slog.Warn("slow request; capturing route in detail",
+			slog.String("route", entry.Route),
+			slog.String("duration", entry.Duration),
+			slog.String("upstream", entry.Upstream),
+			slog.String("trace_id", entry.TraceID),
+			slog.String("request_id", entry.RequestID),
+			slog.String("capture_window", s.captureWindow.String()))
+	}
+}
+
+// add stores entry and reports whether it started a new capture.
+func (s *slowRequestRecorder) add(entry slowRequest, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	started := false
+	if s.threshold > 0 && entry.duration >= s.threshold && entry.Route != slowRequestRouteUnmatched {
+		_, watching := s.captures[entry.Route]
+		started = !watching
+		s.captures[entry.Route] = now.Add(s.captureWindow)
+	}
+	if len(s.entries) < s.max {
+		s.entries = append(s.entries, entry)
+		return started
+	}
+	fastest := 0
+	for i := range s.entries {
+		if s.entries[i].duration < s.entries[fastest].duration {
+			fastest = i
+		}
+	}
+	if entry.duration > s.entries[fastest].duration {
+		s.entries[fastest] = entry
+	}
+	return started
+}
+
+// expire drops entries that finished before the window and captures that
+// ran out. Callers hold s.mu.
+func (s *slowRequestRecorder) expire(now time.Time) {
+	cutoff := now.Add(-s.window)
+	s.entries = slices.DeleteFunc(s.entries, func(entry slowRequest) bool {
+		return entry.recordedAt.Before(cutoff)
+	})
+	for route, until := range s.captures {
+		if !now.Before(until) {
+			delete(s.captures, route)
+		}
+	}
+}
+
+func (s *slowRequestRecorder) snapshot() slowRequestsResponse {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	resp := slowRequestsResponse{
+		Window:   s.window.String(),
+		Captures: []slowRequestCapture{},
+		Requests: slices.Clone(s.entries),
+	}
+	if s.threshold > 0 {
+		resp.Threshold = s.threshold.String()
+	}
+	for route, until := range s.captures {
+		resp.Captures = append(resp.Captures, slowRequestCapture{Route: route, Until: until})
+	}
+	slices.SortFunc(resp.Captures, func(a, b slowRequestCapture) int { return strings.Compare(a.Route, b.Route) })
+	slices.SortStableFunc(resp.Requests, func(a, b slowRequest) int {
+		return cmp.Compare(b.duration, a.duration)
+	})
+	if resp.Requests == nil {
+		resp.Requests = []slowRequest{}
+	}
+	return resp
+}
+
+var (
+	slowRequestsMu   sync.Mutex
+	slowRequestsOnce sync.Once
+	slowRequests     *slowRequestRecorder
+	slowRequestsErr  error
+)
+
+// resetSlowRequests clears the shared recorder for tests.
+func resetSlowRequests() {
+	slowRequestsMu.Lock()
+	defer slowRequestsMu.Unlock()
+	slowRequestsOnce = sync.Once{}
+	slowRequests = nil
+	slowRequestsErr = nil
+}
+
+// loadSlowRequests returns the shared flight recorder, or nil when
+// GATEWAY_SLOW_REQUESTS_MAX is 0.
+func loadSlowRequests() (*slowRequestRecorder, error) {
+	slowRequestsMu.Lock()
+	defer slowRequestsMu.Unlock()
+	slowRequestsOnce.Do(func() {
+		threshold := time.Duration(0)
+		if raw := strings.TrimSpace(GetEnv("GATEWAY_SLOW_REQUEST_THRESHOLD", "")); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil || parsed < 0 {
+				slowRequestsErr = errors.New("GATEWAY_SLOW_REQUEST_THRESHOLD must be a non-negative duration")
+				return
+			}
+			threshold = parsed
+		}
+		max := GetIntEnv("GATEWAY_SLOW_REQUESTS_MAX", defaultSlowRequestsMax)
+		if max == 0 {
+			return
+		}
+		slowRequests = newSlowRequestRecorder(max,
+			ResolveDuration([]string{"GATEWAY_SLOW_REQUESTS_WINDOW"}, defaultSlowRequestsWindow),
+			threshold,
+			ResolveDuration([]string{"GATEWAY_SLOW_REQUEST_CAPTURE_WINDOW"}, defaultSlowRequestCaptureWindow))
+	})
+	return slowRequests, slowRequestsErr
+}
+
+// ValidateSlowRequestConfig checks the GATEWAY_SLOW_REQUEST* settings at
+// startup.
+func ValidateSlowRequestConfig() error {
+	_, err := loadSlowRequests()
+	return err
+}
+
+// SlowRequestsEnabled reports whether SlowRequestMiddleware records
+// requests.
+func SlowRequestsEnabled() bool {
+	recorder, _ := loadSlowRequests()
+	return recorder != nil
+}
+
+// SlowRequestMiddleware times every request for the slow request flight
+// recorder served at /admin/slow. It belongs inside the tracing and audit
+// middleware so trace and request IDs are known.
+func SlowRequestMiddleware(next http.Handler) http.Handler {
+	recorder, _ := loadSlowRequests()
+	if recorder == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timings := &requestTimings{start: time.Now()}
+		sw := &slowRequestResponseWriter{ResponseWriter: w, start: timings.start}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), requestTimingsContextKey{}, timings)))
+		recorder.record(timings.entry(r, sw))
+	})
+}
+
+// entry summarises the finished request.
+func (t *requestTimings) entry(r *http.Request, sw *slowRequestResponseWriter) slowRequest {
+	total := time.Since(t.start)
+	route := slowRequestRouteUnmatched
+	if pattern := t.route.Load(); pattern != nil {
+		route = *pattern
+	}
+	upstream := time.Duration(t.upstream.Load())
+	entry := slowRequest{
+		Route:         route,
+		Method:        r.Method,
+		Status:        sw.status,
+		Streaming:     sw.streaming(),
+		StartedAt:     t.start,
+		UpstreamCalls: int(t.calls.Load()),
+		RequestID:     audit.RequestID(r.Context()),
+		Captured:      t.capture.Load(),
+		duration:      total,
+	}
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+	if sw.headersAt > 0 {
+		entry.TimeToHeaders = sw.headersAt.String()
+		if entry.Streaming {
+			entry.duration = sw.headersAt
+		}
+	}
+	entry.Duration = entry.duration.String()
+	if upstream > 0 {
+		entry.Upstream = upstream.String()
+	}
+	entry.Gateway = max(entry.duration-upstream, 0).String()
+	if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.HasTraceID() {
+		entry.TraceID = spanContext.TraceID().String()
+	}
+	t.mu.Lock()
+	entry.Calls = t.detailed
+	t.mu.Unlock()
+	return entry
+}
+
+// slowRequestResponseWriter notes the status and when the response headers
+// were written.
+type slowRequestResponseWriter struct {
+	http.ResponseWriter
+	start     time.Time
+	status    int
+	headersAt time.Duration
+	hijacked  bool
+}
+
+func (sw *slowRequestResponseWriter) WriteHeader(status int) {
+	if sw.status == 0 || (sw.status < http.StatusOK && status >= http.StatusOK) {
+		if sw.status == 0 {
+			sw.headersAt = time.Since(sw.start)
+		}
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *slowRequestResponseWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.headersAt = time.Since(sw.start)
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *slowRequestResponseWriter) Flush() {
+	if sw.status == 0 {
+		sw.headersAt = time.Since(sw.start)
+		sw.status = http.StatusOK
+	}
+	_ = http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+func (sw *slowRequestResponseWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// Hijack hands the connection to a WebSocket proxy; the upgrade counts as
+// the response.
+func (sw *slowRequestResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(sw.ResponseWriter).Hijack()
+	if err == nil {
+		sw.hijacked = true
+		if sw.status == 0 {
+			sw.headersAt = time.Since(sw.start)
+			sw.status = http.StatusSwitchingProtocols
+		}
+	}
+	return conn, brw, err
+}
+
+func (sw *slowRequestResponseWriter) streaming() bool {
+	if sw.hijacked || sw.status == http.StatusSwitchingProtocols {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(sw.Header().Get("Content-Type"), ";")
+	return strings.EqualFold(strings.TrimSpace(mediaType), slowRequestEventStreamMediaType)
+}
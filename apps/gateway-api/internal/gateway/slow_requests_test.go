@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func slowEntry(route string, duration time.Duration) slowRequest {
+	return slowRequest{Route: route, Duration: duration.String(), duration: duration}
+}
+
+func TestSlowRequestRecorderKeepsSlowestInWindow(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	recorder := newSlowRequestRecorder(2, time.Minute, 0, time.Minute)
+	recorder.now = func() time.Time { return now }
+
+	recorder.record(slowEntry("GET /a", 30*time.Millisecond))
+	recorder.record(slowEntry("GET /b", 10*time.Millisecond))
+	recorder.record(slowEntry("GET /c", 20*time.Millisecond))
+	recorder.record(slowEntry("GET /d", 5*time.Millisecond))
+
+	snapshot := recorder.snapshot()
+	if len(snapshot.Requests) != 2 || snapshot.Requests[0].Route != "GET /a" || snapshot.Requests[1].Route != "GET /c" {
+		t.Fatalf("expected the two slowest requests, got %+v", snapshot.Requests)
+	}
+
+	now = now.Add(2 * time.Minute)
+	recorder.record(slowEntry("GET /e", time.Millisecond))
+	if snapshot := recorder.snapshot(); len(snapshot.Requests) != 1 || snapshot.Requests[0].Route != "GET /e" {
+		t.Fatalf("expected old entries to expire, got %+v", snapshot.Requests)
+	}
+}
+
+func TestSlowRequestThresholdStartsCapture(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	recorder := newSlowRequestRecorder(10, time.Hour, 100*time.Millisecond, time.Minute)
+	recorder.now = func() time.Time { return now }
+
+	recorder.record(slowEntry("GET /fast", 50*time.Millisecond))
+	recorder.record(slowEntry("GET /slow", 150*time.Millisecond))
+	recorder.record(slowEntry(slowRequestRouteUnmatched, time.Second))
+
+	if recorder.capturing("GET /fast", now) || !recorder.capturing("GET /slow", now) || recorder.capturing(slowRequestRouteUnmatched, now) {
+		t.Fatalf("expected only GET /slow to be captured, got %+v", recorder.snapshot().Captures)
+	}
+	if recorder.capturing("GET /slow", now.Add(time.Minute)) {
+		t.Fatal("expected the capture to end after the capture window")
+	}
+}
+
+func TestSlowRequestMiddlewareRecordsUpstreamTimings(t *testing.T) {
+	t.Setenv("GATEWAY_SLOW_REQUESTS_MAX", "5")
+	t.Setenv("GATEWAY_SLOW_REQUEST_THRESHOLD", "1ns")
+	resetSlowRequests()
+	t.Cleanup(resetSlowRequests)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	client := &http.Client{Transport: newInstrumentedTransport(http.DefaultTransport.(*http.Transport).Clone())}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /plans/{id}", func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, target.JoinPath("/upstream").String(), nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("upstream request failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+	})
+	handler := SlowRequestMiddleware(NewRouter(mux))
+
+	for range 2 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/plans/42", nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	adminMux := http.NewServeMux()
+	RegisterAdminRoutes(adminMux, AdminRouteConfig{Token: "s3cret"})
+	req := httptest.NewRequest(http.MethodGet, "/admin/slow", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	adminMux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp slowRequestsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(resp.Requests) != 3 || len(resp.Captures) != 1 || resp.Captures[0].Route != "GET /plans/{id}" {
+		t.Fatalf("unexpected flight recorder state %s", rec.Body.String())
+	}
+	var captured *slowRequest
+	for i, entry := range resp.Requests {
+		if entry.Route == "GET /plans/{id}" {
+			if entry.Status != http.StatusAccepted || entry.UpstreamCalls != 1 || entry.Upstream == "" {
+				t.Fatalf("expected upstream timings, got %+v", entry)
+			}
+			if entry.Captured {
+				captured = &resp.Requests[i]
+			}
+		}
+	}
+	// The first request crossed the threshold, so the second was captured.
+	if captured == nil || len(captured.Calls) != 1 || captured.Calls[0].Path != "/upstream" || captured.Calls[0].Status != http.StatusAccepted {
+		t.Fatalf("expected a captured request with its upstream call, got %s", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), slowRequestRouteUnmatched) {
+		t.Fatalf("expected the unmatched request to be recorded, got %s", rec.Body.String())
+	}
+}
+
+func TestSlowRequestConfig(t *testing.T) {
+	t.Setenv("GATEWAY_SLOW_REQUEST_THRESHOLD", "soon")
+	resetSlowRequests()
+	t.Cleanup(resetSlowRequests)
+	if err := ValidateSlowRequestConfig(); err == nil {
+		t.Fatal("expected an invalid threshold to be rejected")
+	}
+
+	t.Setenv("GATEWAY_SLOW_REQUEST_THRESHOLD", "")
+	t.Setenv("GATEWAY_SLOW_REQUESTS_MAX", "0")
+	resetSlowRequests()
+	if err := ValidateSlowRequestConfig(); err != nil || SlowRequestsEnabled() {
+		t.Fatalf("expected recording to be disabled, got %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+const (
+	defaultNegotiateFallbackProvider = "oidc"
+	maxNegotiateTokenBytes           = 64 * 1024
+)
+
+type negotiateRequestParams struct {
+	RedirectURI string `validate:"required,uri,max=2048" json:"redirect_uri"`
+}
+
+// negotiateConfig holds the SPNEGO settings. It is nil when negotiation is
+// disabled.
+type negotiateConfig struct {
+	validator *kerberosValidator
+	// realmTenants maps upper-cased Kerberos realms to tenant IDs. When it is
+	// empty every realm in the keytab is accepted without a tenant.
+	realmTenants     map[string]string
+	fallbackProvider string
+}
+
+// loadNegotiateConfig reads the SPNEGO settings. Negotiation is an enterprise
+// feature: GATEWAY_SPNEGO_ENABLED is rejected unless RUN_MODE=enterprise.
+func loadNegotiateConfig() (*negotiateConfig, error) {
+	if !getBoolEnv("GATEWAY_SPNEGO_ENABLED") {
+		return nil, nil
+	}
+	if !strings.EqualFold(strings.TrimSpace(os.Getenv("RUN_MODE")), "enterprise") {
+		return nil, errors.New("GATEWAY_SPNEGO_ENABLED requires RUN_MODE=enterprise")
+	}
+	path := strings.TrimSpace(os.Getenv("GATEWAY_SPNEGO_KEYTAB"))
+	if path == "" {
+		return nil, errors.New("GATEWAY_SPNEGO_KEYTAB is required when GATEWAY_SPNEGO_ENABLED is set")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GATEWAY_SPNEGO_KEYTAB: %w", err)
+	}
+	if info.Size() > maxKeytabBytes {
+		return nil, fmt.Errorf("GATEWAY_SPNEGO_KEYTAB exceeds %d bytes", maxKeytabBytes)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GATEWAY_SPNEGO_KEYTAB: %w", err)
+	}
+	keys, err := parseKeytab(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GATEWAY_SPNEGO_KEYTAB: %w", err)
+	}
+	realmTenants, err := parseRealmTenants(os.Getenv("GATEWAY_SPNEGO_REALM_TENANTS"))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := loadIdentityAssertionKey(); err != nil {
+		return nil, err
+	}
+	replay, err := loadConsumedStateStore()
+	if err != nil {
+		return nil, err
+	}
+
+	fallback := strings.ToLower(strings.TrimSpace(GetEnv("GATEWAY_SPNEGO_FALLBACK_PROVIDER", defaultNegotiateFallbackProvider)))
+	if fallback == "none" {
+		fallback = ""
+	} else if _, ok := lookupAuthProvider(fallback); !ok {
+		return nil, fmt.Errorf("unsupported GATEWAY_SPNEGO_FALLBACK_PROVIDER %q (expected none or one of %s)", fallback, strings.Join(authProviderNames(), ", "))
+	}
+
+	return &negotiateConfig{
+		validator: &kerberosValidator{
+			keys:             keys,
+			servicePrincipal: strings.TrimSpace(os.Getenv("GATEWAY_SPNEGO_SERVICE_PRINCIPAL")),
+			replay:           replay,
+			now:              clockNow,
+		},
+		realmTenants:     realmTenants,
+		fallbackProvider: fallback,
+	}, nil
+}
+
+// parseRealmTenants parses "REALM=tenant" pairs separated by commas.
+func parseRealmTenants(raw string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		realm, tenant, ok := strings.Cut(item, "=")
+		realm = strings.ToUpper(strings.TrimSpace(realm))
+		if !ok || realm == "" {
+			return nil, fmt.Errorf("invalid GATEWAY_SPNEGO_REALM_TENANTS entry %q", item)
+		}
+		tenantID, err := normalizeTenantID(strings.TrimSpace(tenant))
+		if err != nil || tenantID == "" {
+			return nil, fmt.Errorf("invalid tenant for realm %s in GATEWAY_SPNEGO_REALM_TENANTS", realm)
+		}
+		mapping[realm] = tenantID
+	}
+	return mapping, nil
+}
+
+// tenantForRealm returns the tenant for realm and whether the realm may sign in.
+func (c *negotiateConfig) tenantForRealm(realm string) (string, bool) {
+	if len(c.realmTenants) == 0 {
+		return "", true
+	}
+	tenant, ok := c.realmTenants[strings.ToUpper(realm)]
+	return tenant, ok
+}
+
+// fallbackURL points at the authorize route of the fallback provider with the
+// caller's original query, or nil when fallback is disabled.
+func (c *negotiateConfig) fallbackURL(r *http.Request) *url.URL {
+	if c.fallbackProvider == "" {
+		return nil
+	}
+	return &url.URL{Path: "/auth/" + c.fallbackProvider + "/authorize", RawQuery: r.URL.RawQuery}
+}
+
+// negotiateHandler performs SPNEGO (Kerberos) single sign-on. Browsers without
+// a ticket, and tickets that fail validation, fall back to the configured
+// OAuth provider.
+func negotiateHandler(w http.ResponseWriter, r *http.Request, trustedProxies []*net.IPNet, cfg *negotiateConfig) {
+	params := negotiateRequestParams{RedirectURI: strings.TrimSpace(r.URL.Query().Get("redirect_uri"))}
+	if errs := validateRequestParams(params); len(errs) > 0 {
+		auditNegotiateEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, map[string]any{"reason": errs[0].Message})
+		writeValidationError(w, r, errs)
+		return
+	}
+	redirectURL, err := url.Parse(params.RedirectURI)
+	if err == nil {
+		err = validateClientRedirectURL(redirectURL)
+	}
+	if err != nil {
+		auditNegotiateEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, map[string]any{
+			"reason":            "invalid redirect_uri",
+			"redirect_uri_hash": redirectHash(params.RedirectURI),
+		})
+		writeValidationError(w, r, []validationError{{Field: "redirect_uri", Message: "invalid redirect_uri"}})
+		return
+	}
+
+	scheme, encoded, _ := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+	if !strings.EqualFold(scheme, "Negotiate") || strings.TrimSpace(encoded) == "" {
+		writeNegotiateChallenge(w, r, cfg.fallbackURL(r))
+		return
+	}
+
+	details := map[string]any{"redirect_uri_hash": redirectHash(params.RedirectURI)}
+	identity, err := decodeAndValidateNegotiate(r, cfg, strings.TrimSpace(encoded))
+	if err != nil {
+		auditNegotiateEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{
+			"reason":   "negotiate_failed",
+			"error":    err.Error(),
+			"fallback": cfg.fallbackProvider,
+		}))
+		negotiateFallback(w, r, cfg)
+		return
+	}
+	// Re-salted for the realm's tenant once it is mapped.
+	details["principal_hash"] = gatewayAuditLogger.HashIdentity("principal", identity.String())
+
+	tenantID, allowed := cfg.tenantForRealm(identity.Realm)
+	if !allowed {
+		auditNegotiateEvent(r.Context(), r, trustedProxies, auditOutcomeDenied, mergeDetails(details, map[string]any{
+			"reason":   "realm_not_mapped",
+			"realm":    identity.Realm,
+			"fallback": cfg.fallbackProvider,
+		}))
+		negotiateFallback(w, r, cfg)
+		return
+	}
+	details = withTenantHash(details, hashTenantID(tenantID))
+	details["principal_hash"] = gatewayAuditLogger.HashTenantIdentity(tenantID, "principal", identity.String())
+
+	cookies, err := exchangeIdentityAssertion(r.Context(), identityAssertion{
+		Subject:  identity.String(),
+		Method:   "kerberos",
+		TenantID: tenantID,
+	}, audit.RequestID(r.Context()), clockNow())
+	if err != nil {
+		var rejected *assertionExchangeError
+		switch {
+		case errors.Is(err, errIdentityAssertionNotConfigured):
+			auditNegotiateEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{
+				"reason": "assertion_not_configured",
+			}))
+			writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "negotiate authentication is not configured", nil)
+		case errors.As(err, &rejected):
+			auditNegotiateEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{
+				"reason":      "upstream_error",
+				"status_code": rejected.StatusCode,
+				"error":       rejected.Detail,
+			}))
+			redirectWithStatus(w, r, stateData{RedirectURI: params.RedirectURI}, "error", rejected.SafeError)
+		default:
+			auditNegotiateEvent(r.Context(), r, trustedProxies, auditOutcomeFailure, mergeDetails(details, map[string]any{
+				"reason": "upstream_unreachable",
+				"error":  err.Error(),
+			}))
+			writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to contact orchestrator", nil)
+		}
+		return
+	}
+
+	normalized, _, dropped := normalizeUpstreamCookies(cookies)
+	if len(dropped) > 0 {
+		auditNegotiateEvent(r.Context(), r, trustedProxies, auditOutcomeSuccess, mergeDetails(details, map[string]any{
+			"action":  "upstream_cookie_rejected",
+			"cookies": dropped,
+		}))
+	}
+	for _, cookie := range normalized {
+		http.SetCookie(w, cookie)
+	}
+	auditNegotiateEvent(r.Context(), r, trustedProxies, auditOutcomeSuccess, mergeDetails(details, map[string]any{
+		"redirect_uri_host": redirectHost(params.RedirectURI),
+	}))
+	redirectWithStatus(w, r, stateData{RedirectURI: params.RedirectURI}, "success", "")
+}
+
+func decodeAndValidateNegotiate(r *http.Request, cfg *negotiateConfig, encoded string) (kerberosIdentity, error) {
+	if base64.StdEncoding.DecodedLen(len(encoded)) > maxNegotiateTokenBytes {
+		return kerberosIdentity{}, errors.New("negotiate token too large")
+	}
+	token, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return kerberosIdentity{}, errors.New("negotiate token is not valid base64")
+	}
+	return cfg.validator.validate(r.Context(), token)
+}
+
+// writeNegotiateChallenge asks the browser for a Kerberos ticket. Browsers
+// that cannot negotiate render the body, which forwards them to the fallback
+// provider.
+func writeNegotiateChallenge(w http.ResponseWriter, r *http.Request, fallback *url.URL) {
+	w.Header().Set("WWW-Authenticate", "Negotiate")
+	if fallback == nil {
+		writeErrorResponse(w, r, http.StatusUnauthorized, "unauthorized", "negotiate authentication required", nil)
+		return
+	}
+	target := html.EscapeString(fallback.String())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusUnauthorized)
+	fmt.Fprintf(w, "<!DOCTYPE html><meta http-equiv=\"refresh\" content=\"0;url=%s\"><a href=\"%s\">Continue to sign in</a>\n", target, target)
+}
+
+func negotiateFallback(w http.ResponseWriter, r *http.Request, cfg *negotiateConfig) {
+	if fallback := cfg.fallbackURL(r); fallback != nil {
+		sendRedirect(w, r, fallback)
+		return
+	}
+	writeErrorResponse(w, r, http.StatusUnauthorized, "unauthorized", "negotiate authentication failed", nil)
+}
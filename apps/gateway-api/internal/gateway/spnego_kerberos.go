@@ -0,0 +1,534 @@
+package gateway
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file implements just enough of Kerberos V5 (RFC 4120) to accept an
+// AP-REQ carried in an SPNEGO token (RFC 4178): keytab parsing, the
+// aes128/aes256-cts-hmac-sha1-96 encryption types (RFC 3962) and ticket and
+// authenticator checks. Mutual authentication (AP-REP) is not supported.
+
+const (
+	krbEtypeAES128CTSHMACSHA196 = 17
+	krbEtypeAES256CTSHMACSHA196 = 18
+
+	krbKeyUsageTicket        = 2
+	krbKeyUsageAPReqAuthData = 11
+
+	krbHMACSHA1Length = 12
+	// krbClockSkew matches the Kerberos default tolerance for ticket and
+	// authenticator timestamps.
+	krbClockSkew = 5 * time.Minute
+	// maxKeytabBytes bounds keytab files; real keytabs are a few KB.
+	maxKeytabBytes = 1 << 20
+)
+
+var (
+	oidSPNEGO     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	oidKerberos5  = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+	oidMSKerberos = asn1.ObjectIdentifier{1, 2, 840, 48018, 1, 2, 2}
+)
+
+type keytabEntry struct {
+	Principal string
+	Realm     string
+	KVNO      uint32
+	EType     int32
+	Key       []byte
+}
+
+// parseKeytab decodes an MIT keytab (format version 0x0502).
+func parseKeytab(data []byte) ([]keytabEntry, error) {
+	if len(data) < 2 || data[0] != 0x05 || data[1] != 0x02 {
+		return nil, errors.New("unsupported keytab format")
+	}
+	var entries []keytabEntry
+	for offset := 2; offset < len(data); {
+		if len(data)-offset < 4 {
+			return nil, errors.New("truncated keytab entry")
+		}
+		size := int32(binary.BigEndian.Uint32(data[offset:]))
+		offset += 4
+		if size < 0 {
+			// Negative sizes mark deleted entries. The skip is computed in
+			// 64 bits because -math.MinInt32 does not fit in an int32.
+			skip := -int64(size)
+			if skip > int64(len(data)-offset) {
+				return nil, errors.New("truncated keytab entry")
+			}
+			offset += int(skip)
+			continue
+		}
+		if int(size) > len(data)-offset {
+			return nil, errors.New("truncated keytab entry")
+		}
+		entry, err := parseKeytabEntry(data[offset : offset+int(size)])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		offset += int(size)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("keytab contains no keys")
+	}
+	return entries, nil
+}
+
+func parseKeytabEntry(data []byte) (keytabEntry, error) {
+	r := keytabReader{data: data}
+	components := int(r.uint16())
+	realm := r.counted()
+	names := make([]string, 0, components)
+	for i := 0; i < components; i++ {
+		names = append(names, string(r.counted()))
+	}
+	r.uint32() // name type
+	r.uint32() // timestamp
+	kvno := uint32(r.uint8())
+	etype := int32(r.uint16())
+	key := r.counted()
+	if r.err != nil {
+		return keytabEntry{}, r.err
+	}
+	// Newer keytabs append a 32-bit kvno that supersedes the 8-bit one.
+	if len(r.data)-r.offset >= 4 {
+		if extended := r.uint32(); extended != 0 {
+			kvno = extended
+		}
+	}
+	return keytabEntry{
+		Principal: strings.Join(names, "/"),
+		Realm:     string(realm),
+		KVNO:      kvno,
+		EType:     etype,
+		Key:       append([]byte(nil), key...),
+	}, nil
+}
+
+type keytabReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *keytabReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data)-r.offset < n {
+		r.err = errors.New("truncated keytab entry")
+		return nil
+	}
+	out := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return out
+}
+
+func (r *keytabReader) uint8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *keytabReader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *keytabReader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *keytabReader) counted() []byte {
+	return r.take(int(r.uint16()))
+}
+
+type krbPrincipalName struct {
+	NameType   int32    `asn1:"explicit,tag:0"`
+	NameString []string `asn1:"explicit,tag:1"`
+}
+
+func (p krbPrincipalName) String() string {
+	return strings.Join(p.NameString, "/")
+}
+
+type krbEncryptedData struct {
+	EType  int32  `asn1:"explicit,tag:0"`
+	KVNO   int    `asn1:"optional,explicit,tag:1"`
+	Cipher []byte `asn1:"explicit,tag:2"`
+}
+
+type krbEncryptionKey struct {
+	KeyType  int32  `asn1:"explicit,tag:0"`
+	KeyValue []byte `asn1:"explicit,tag:1"`
+}
+
+type krbAPReq struct {
+	PVNO          int              `asn1:"explicit,tag:0"`
+	MsgType       int              `asn1:"explicit,tag:1"`
+	APOptions     asn1.BitString   `asn1:"explicit,tag:2"`
+	Ticket        asn1.RawValue    `asn1:"explicit,tag:3"`
+	Authenticator krbEncryptedData `asn1:"explicit,tag:4"`
+}
+
+type krbTicket struct {
+	TktVNO  int              `asn1:"explicit,tag:0"`
+	Realm   string           `asn1:"explicit,tag:1"`
+	SName   krbPrincipalName `asn1:"explicit,tag:2"`
+	EncPart krbEncryptedData `asn1:"explicit,tag:3"`
+}
+
+type krbEncTicketPart struct {
+	Flags             asn1.BitString   `asn1:"explicit,tag:0"`
+	Key               krbEncryptionKey `asn1:"explicit,tag:1"`
+	CRealm            string           `asn1:"explicit,tag:2"`
+	CName             krbPrincipalName `asn1:"explicit,tag:3"`
+	Transited         asn1.RawValue    `asn1:"explicit,tag:4"`
+	AuthTime          time.Time        `asn1:"generalized,explicit,tag:5"`
+	StartTime         time.Time        `asn1:"generalized,optional,explicit,tag:6"`
+	EndTime           time.Time        `asn1:"generalized,explicit,tag:7"`
+	RenewTill         time.Time        `asn1:"generalized,optional,explicit,tag:8"`
+	CAddr             asn1.RawValue    `asn1:"optional,explicit,tag:9"`
+	AuthorizationData asn1.RawValue    `asn1:"optional,explicit,tag:10"`
+}
+
+type krbAuthenticator struct {
+	AVNO              int              `asn1:"explicit,tag:0"`
+	CRealm            string           `asn1:"explicit,tag:1"`
+	CName             krbPrincipalName `asn1:"explicit,tag:2"`
+	Cksum             asn1.RawValue    `asn1:"optional,explicit,tag:3"`
+	CUSec             int              `asn1:"explicit,tag:4"`
+	CTime             time.Time        `asn1:"generalized,explicit,tag:5"`
+	SubKey            asn1.RawValue    `asn1:"optional,explicit,tag:6"`
+	SeqNumber         asn1.RawValue    `asn1:"optional,explicit,tag:7"`
+	AuthorizationData asn1.RawValue    `asn1:"optional,explicit,tag:8"`
+}
+
+type negTokenInit struct {
+	MechTypes   []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+	ReqFlags    asn1.BitString          `asn1:"optional,explicit,tag:1"`
+	MechToken   []byte                  `asn1:"optional,explicit,tag:2"`
+	MechListMIC []byte                  `asn1:"optional,explicit,tag:3"`
+}
+
+// parseNegotiateToken unwraps the AP-REQ from an SPNEGO NegTokenInit or a raw
+// Kerberos GSS-API token.
+func parseNegotiateToken(token []byte) (krbAPReq, error) {
+	mech, inner, err := parseGSSInitialToken(token)
+	if err != nil {
+		return krbAPReq{}, err
+	}
+	if mech.Equal(oidSPNEGO) {
+		var init negTokenInit
+		if _, err := asn1.UnmarshalWithParams(inner, &init, "explicit,tag:0"); err != nil {
+			return krbAPReq{}, fmt.Errorf("invalid spnego token: %w", err)
+		}
+		if len(init.MechToken) == 0 {
+			return krbAPReq{}, errors.New("spnego token carries no mechanism token")
+		}
+		mech, inner, err = parseGSSInitialToken(init.MechToken)
+		if err != nil {
+			return krbAPReq{}, err
+		}
+	}
+	if !mech.Equal(oidKerberos5) && !mech.Equal(oidMSKerberos) {
+		return krbAPReq{}, fmt.Errorf("unsupported negotiation mechanism %s", mech)
+	}
+	// Kerberos GSS tokens carry a two byte TOK_ID; 0x0100 is KRB_AP_REQ.
+	if len(inner) < 2 || inner[0] != 0x01 || inner[1] != 0x00 {
+		return krbAPReq{}, errors.New("kerberos token is not an AP-REQ")
+	}
+	var req krbAPReq
+	if _, err := asn1.UnmarshalWithParams(inner[2:], &req, "application,explicit,tag:14"); err != nil {
+		return krbAPReq{}, fmt.Errorf("invalid AP-REQ: %w", err)
+	}
+	if req.PVNO != 5 || req.MsgType != 14 {
+		return krbAPReq{}, errors.New("invalid AP-REQ header")
+	}
+	return req, nil
+}
+
+// parseGSSInitialToken splits an RFC 2743 InitialContextToken into its
+// mechanism OID and mechanism-specific bytes.
+func parseGSSInitialToken(token []byte) (asn1.ObjectIdentifier, []byte, error) {
+	var outer asn1.RawValue
+	if _, err := asn1.Unmarshal(token, &outer); err != nil {
+		return nil, nil, fmt.Errorf("invalid gss token: %w", err)
+	}
+	if outer.Class != asn1.ClassApplication || outer.Tag != 0 {
+		return nil, nil, errors.New("invalid gss token framing")
+	}
+	var mech asn1.ObjectIdentifier
+	inner, err := asn1.Unmarshal(outer.Bytes, &mech)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid gss mechanism: %w", err)
+	}
+	return mech, inner, nil
+}
+
+// kerberosIdentity is the client principal proven by a valid AP-REQ.
+type kerberosIdentity struct {
+	Principal string
+	Realm     string
+}
+
+func (k kerberosIdentity) String() string {
+	return k.Principal + "@" + k.Realm
+}
+
+type kerberosValidator struct {
+	keys []keytabEntry
+	// servicePrincipal, when set, restricts accepted tickets to one service
+	// principal in the keytab ("HTTP/host@REALM").
+	servicePrincipal string
+	replay           consumedStateStore
+	now              func() time.Time
+}
+
+// validate decrypts and checks the ticket and authenticator in token.
+func (v *kerberosValidator) validate(ctx context.Context, token []byte) (kerberosIdentity, error) {
+	req, err := parseNegotiateToken(token)
+	if err != nil {
+		return kerberosIdentity{}, err
+	}
+	var ticket krbTicket
+	if _, err := asn1.UnmarshalWithParams(req.Ticket.Bytes, &ticket, "application,explicit,tag:1"); err != nil {
+		return kerberosIdentity{}, fmt.Errorf("invalid ticket: %w", err)
+	}
+	service := ticket.SName.String() + "@" + ticket.Realm
+	if v.servicePrincipal != "" && !strings.EqualFold(service, v.servicePrincipal) {
+		return kerberosIdentity{}, fmt.Errorf("ticket issued for unexpected service %s", service)
+	}
+	key, err := v.serviceKey(ticket)
+	if err != nil {
+		return kerberosIdentity{}, err
+	}
+
+	plain, err := krbDecrypt(ticket.EncPart.EType, key, krbKeyUsageTicket, ticket.EncPart.Cipher)
+	if err != nil {
+		return kerberosIdentity{}, fmt.Errorf("failed to decrypt ticket: %w", err)
+	}
+	var part krbEncTicketPart
+	if _, err := asn1.UnmarshalWithParams(plain, &part, "application,explicit,tag:3"); err != nil {
+		return kerberosIdentity{}, fmt.Errorf("invalid ticket contents: %w", err)
+	}
+	now := v.now()
+	start := part.StartTime
+	if start.IsZero() {
+		start = part.AuthTime
+	}
+	if start.After(now.Add(krbClockSkew)) {
+		return kerberosIdentity{}, errors.New("ticket not yet valid")
+	}
+	if !now.Before(part.EndTime.Add(krbClockSkew)) {
+		return kerberosIdentity{}, errors.New("ticket expired")
+	}
+
+	if req.Authenticator.EType != part.Key.KeyType {
+		return kerberosIdentity{}, errors.New("authenticator encryption type does not match session key")
+	}
+	plain, err = krbDecrypt(req.Authenticator.EType, part.Key.KeyValue, krbKeyUsageAPReqAuthData, req.Authenticator.Cipher)
+	if err != nil {
+		return kerberosIdentity{}, fmt.Errorf("failed to decrypt authenticator: %w", err)
+	}
+	var auth krbAuthenticator
+	if _, err := asn1.UnmarshalWithParams(plain, &auth, "application,explicit,tag:2"); err != nil {
+		return kerberosIdentity{}, fmt.Errorf("invalid authenticator: %w", err)
+	}
+	identity := kerberosIdentity{Principal: part.CName.String(), Realm: part.CRealm}
+	if auth.CName.String() != identity.Principal || auth.CRealm != identity.Realm {
+		return kerberosIdentity{}, errors.New("authenticator client does not match ticket")
+	}
+	if auth.CTime.Before(now.Add(-krbClockSkew)) || auth.CTime.After(now.Add(krbClockSkew)) {
+		return kerberosIdentity{}, errors.New("authenticator outside clock skew")
+	}
+
+	// Authenticators are single use; the same replay store that guards OAuth
+	// states rejects a captured Negotiate header within the skew window.
+	replayKey := "krb5:" + identity.String() + ":" + auth.CTime.UTC().Format(time.RFC3339) + ":" + strconv.Itoa(auth.CUSec)
+	fresh, err := v.replay.Consume(ctx, replayKey, auth.CTime.Add(krbClockSkew))
+	if err != nil {
+		return kerberosIdentity{}, fmt.Errorf("replay check failed: %w", err)
+	}
+	if !fresh {
+		return kerberosIdentity{}, errors.New("authenticator replayed")
+	}
+	return identity, nil
+}
+
+// serviceKey picks the keytab key for the ticket's service principal,
+// encryption type and key version.
+func (v *kerberosValidator) serviceKey(ticket krbTicket) ([]byte, error) {
+	principal := ticket.SName.String()
+	var best *keytabEntry
+	for i := range v.keys {
+		entry := &v.keys[i]
+		if entry.Principal != principal || !strings.EqualFold(entry.Realm, ticket.Realm) || entry.EType != ticket.EncPart.EType {
+			continue
+		}
+		if ticket.EncPart.KVNO != 0 && entry.KVNO != uint32(ticket.EncPart.KVNO) {
+			continue
+		}
+		if best == nil || entry.KVNO > best.KVNO {
+			best = entry
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no keytab key for %s@%s (etype %d, kvno %d)", principal, ticket.Realm, ticket.EncPart.EType, ticket.EncPart.KVNO)
+	}
+	return best.Key, nil
+}
+
+// krbDecrypt implements decryption for the RFC 3962 AES encryption types:
+// AES-CTS over confounder||plaintext followed by a truncated HMAC-SHA1.
+func krbDecrypt(etype int32, key []byte, usage uint32, ciphertext []byte) ([]byte, error) {
+	switch etype {
+	case krbEtypeAES128CTSHMACSHA196:
+		if len(key) != 16 {
+			return nil, errors.New("invalid aes128 key length")
+		}
+	case krbEtypeAES256CTSHMACSHA196:
+		if len(key) != 32 {
+			return nil, errors.New("invalid aes256 key length")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encryption type %d", etype)
+	}
+	if len(ciphertext) < aes.BlockSize+krbHMACSHA1Length {
+		return nil, errors.New("ciphertext too short")
+	}
+	ke, err := krbDeriveKey(key, usage, 0xAA)
+	if err != nil {
+		return nil, err
+	}
+	ki, err := krbDeriveKey(key, usage, 0x55)
+	if err != nil {
+		return nil, err
+	}
+	body := ciphertext[:len(ciphertext)-krbHMACSHA1Length]
+	checksum := ciphertext[len(ciphertext)-krbHMACSHA1Length:]
+	plain, err := aesCTSDecrypt(ke, body)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, ki)
+	mac.Write(plain)
+	if !hmac.Equal(mac.Sum(nil)[:krbHMACSHA1Length], checksum) {
+		return nil, errors.New("integrity check failed")
+	}
+	return plain[aes.BlockSize:], nil
+}
+
+// krbDeriveKey computes DK(base, usage || kind) from RFC 3961.
+func krbDeriveKey(base []byte, usage uint32, kind byte) ([]byte, error) {
+	constant := make([]byte, 5)
+	binary.BigEndian.PutUint32(constant, usage)
+	constant[4] = kind
+	block, err := aes.NewCipher(base)
+	if err != nil {
+		return nil, err
+	}
+	in := nfold(constant, aes.BlockSize)
+	out := make([]byte, 0, len(base)+aes.BlockSize)
+	for len(out) < len(base) {
+		next := make([]byte, aes.BlockSize)
+		block.Encrypt(next, in)
+		out = append(out, next...)
+		in = next
+	}
+	return out[:len(base)], nil
+}
+
+// nfold stretches or folds in to size bytes as defined in RFC 3961 section
+// 5.1, following the reference MIT implementation.
+func nfold(in []byte, size int) []byte {
+	inBytes, outBytes := len(in), size
+	a, b := outBytes, inBytes
+	for b != 0 {
+		a, b = b, a%b
+	}
+	lcm := outBytes * inBytes / a
+	out := make([]byte, outBytes)
+	carry := 0
+	for i := lcm - 1; i >= 0; i-- {
+		msbit := ((inBytes << 3) - 1 + ((inBytes<<3)+13)*(i/inBytes) + ((inBytes - i%inBytes) << 3)) % (inBytes << 3)
+		hi := int(in[((inBytes-1)-(msbit>>3))%inBytes])
+		lo := int(in[(inBytes-(msbit>>3))%inBytes])
+		carry += ((hi<<8 | lo) >> ((msbit & 7) + 1)) & 0xff
+		carry += int(out[i%outBytes])
+		out[i%outBytes] = byte(carry)
+		carry >>= 8
+	}
+	if carry != 0 {
+		for i := outBytes - 1; i >= 0; i-- {
+			carry += int(out[i])
+			out[i] = byte(carry)
+			carry >>= 8
+		}
+	}
+	return out
+}
+
+// aesCTSDecrypt reverses CBC mode with ciphertext stealing (the CS3 variant
+// used by Kerberos, where the final two blocks are always swapped) and a zero
+// IV.
+func aesCTSDecrypt(key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	size := len(ciphertext)
+	if size < aes.BlockSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	if size == aes.BlockSize {
+		out := make([]byte, aes.BlockSize)
+		block.Decrypt(out, ciphertext)
+		return out, nil
+	}
+	blocks := (size + aes.BlockSize - 1) / aes.BlockSize
+	tail := size - (blocks-1)*aes.BlockSize
+	prefix := (blocks - 2) * aes.BlockSize
+
+	out := make([]byte, size)
+	iv := make([]byte, aes.BlockSize)
+	if prefix > 0 {
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out[:prefix], ciphertext[:prefix])
+		copy(iv, ciphertext[prefix-aes.BlockSize:prefix])
+	}
+
+	last := ciphertext[prefix : prefix+aes.BlockSize]
+	partial := ciphertext[prefix+aes.BlockSize:]
+	decrypted := make([]byte, aes.BlockSize)
+	block.Decrypt(decrypted, last)
+	for i := 0; i < tail; i++ {
+		out[prefix+aes.BlockSize+i] = decrypted[i] ^ partial[i]
+	}
+	stolen := make([]byte, aes.BlockSize)
+	copy(stolen, partial)
+	copy(stolen[tail:], decrypted[tail:])
+	block.Decrypt(out[prefix:prefix+aes.BlockSize], stolen)
+	for i := 0; i < aes.BlockSize; i++ {
+		out[prefix+i] ^= iv[i]
+	}
+	return out, nil
+}
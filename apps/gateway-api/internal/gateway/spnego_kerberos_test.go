@@ -0,0 +1,314 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+// aesCTSEncryptForTest is the inverse of aesCTSDecrypt.
+func aesCTSEncryptForTest(t *testing.T, key, plain []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("invalid key: %v", err)
+	}
+	if len(plain) == aes.BlockSize {
+		out := make([]byte, aes.BlockSize)
+		block.Encrypt(out, plain)
+		return out
+	}
+	padded := make([]byte, (len(plain)+aes.BlockSize-1)/aes.BlockSize*aes.BlockSize)
+	copy(padded, plain)
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(padded, padded)
+	n := len(padded)
+	out := append([]byte(nil), padded[:n-2*aes.BlockSize]...)
+	out = append(out, padded[n-aes.BlockSize:]...)
+	out = append(out, padded[n-2*aes.BlockSize:n-aes.BlockSize]...)
+	return out[:len(plain)]
+}
+
+func krbEncryptForTest(t *testing.T, key []byte, usage uint32, plain []byte) []byte {
+	t.Helper()
+	ke, err := krbDeriveKey(key, usage, 0xAA)
+	if err != nil {
+		t.Fatalf("derive failed: %v", err)
+	}
+	ki, _ := krbDeriveKey(key, usage, 0x55)
+	data := make([]byte, aes.BlockSize, aes.BlockSize+len(plain))
+	_, _ = rand.Read(data)
+	data = append(data, plain...)
+	mac := hmac.New(sha1.New, ki)
+	mac.Write(data)
+	return append(aesCTSEncryptForTest(t, ke, data), mac.Sum(nil)[:krbHMACSHA1Length]...)
+}
+
+func buildKeytabForTest(principal, realm string, kvno uint8, etype uint16, key []byte) []byte {
+	counted := func(b *bytes.Buffer, s []byte) {
+		_ = binary.Write(b, binary.BigEndian, uint16(len(s)))
+		b.Write(s)
+	}
+	var entry bytes.Buffer
+	components := strings.Split(principal, "/")
+	_ = binary.Write(&entry, binary.BigEndian, uint16(len(components)))
+	counted(&entry, []byte(realm))
+	for _, c := range components {
+		counted(&entry, []byte(c))
+	}
+	_ = binary.Write(&entry, binary.BigEndian, uint32(1))
+	_ = binary.Write(&entry, binary.BigEndian, uint32(time.Now().Unix()))
+	entry.WriteByte(kvno)
+	_ = binary.Write(&entry, binary.BigEndian, etype)
+	counted(&entry, key)
+
+	out := []byte{0x05, 0x02}
+	// A deleted entry precedes the real one.
+	out = binary.BigEndian.AppendUint32(out, uint32(0xFFFFFFFC))
+	out = append(out, 0, 0, 0, 0)
+	out = binary.BigEndian.AppendUint32(out, uint32(entry.Len()))
+	return append(out, entry.Bytes()...)
+}
+
+func mustMarshal(t *testing.T, value any, params string) []byte {
+	t.Helper()
+	out, err := asn1.MarshalWithParams(value, params)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	return out
+}
+
+func gssWrap(t *testing.T, mech asn1.ObjectIdentifier, inner []byte) []byte {
+	t.Helper()
+	body := append(mustMarshal(t, mech, ""), inner...)
+	return mustMarshal(t, asn1.RawValue{Class: asn1.ClassApplication, Tag: 0, IsCompound: true, Bytes: body}, "")
+}
+
+type kerberosTestTicket struct {
+	serviceKey []byte
+	service    string
+	realm      string
+	client     string
+	now        time.Time
+	ctime      time.Time
+	endTime    time.Time
+}
+
+// negotiateToken builds an SPNEGO NegTokenInit wrapping an AP-REQ.
+func (k kerberosTestTicket) negotiateToken(t *testing.T) []byte {
+	t.Helper()
+	sessionKey := make([]byte, 32)
+	_, _ = rand.Read(sessionKey)
+	endTime := k.endTime
+	if endTime.IsZero() {
+		endTime = k.now.Add(time.Hour)
+	}
+	ctime := k.ctime
+	if ctime.IsZero() {
+		ctime = k.now
+	}
+	client := krbPrincipalName{NameType: 1, NameString: strings.Split(k.client, "/")}
+
+	encPart := krbEncTicketPart{
+		Key:       krbEncryptionKey{KeyType: krbEtypeAES256CTSHMACSHA196, KeyValue: sessionKey},
+		CRealm:    k.realm,
+		CName:     client,
+		Transited: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: mustMarshal(t, struct{}{}, "")},
+		AuthTime:  k.now.Add(-time.Minute).UTC().Truncate(time.Second),
+		EndTime:   endTime.UTC().Truncate(time.Second),
+	}
+	ticket := krbTicket{
+		TktVNO:  5,
+		Realm:   k.realm,
+		SName:   krbPrincipalName{NameType: 2, NameString: strings.Split(k.service, "/")},
+		EncPart: krbEncryptedData{EType: krbEtypeAES256CTSHMACSHA196, KVNO: 3, Cipher: krbEncryptForTest(t, k.serviceKey, krbKeyUsageTicket, mustMarshal(t, encPart, "application,explicit,tag:3"))},
+	}
+	authenticator := krbAuthenticator{
+		AVNO:   5,
+		CRealm: k.realm,
+		CName:  client,
+		CUSec:  42,
+		CTime:  ctime.UTC().Truncate(time.Second),
+	}
+	req := krbAPReq{
+		PVNO:    5,
+		MsgType: 14,
+		Ticket:  asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: mustMarshal(t, ticket, "application,explicit,tag:1")},
+		Authenticator: krbEncryptedData{
+			EType:  krbEtypeAES256CTSHMACSHA196,
+			Cipher: krbEncryptForTest(t, sessionKey, krbKeyUsageAPReqAuthData, mustMarshal(t, authenticator, "application,explicit,tag:2")),
+		},
+	}
+	krbToken := gssWrap(t, oidMSKerberos, append([]byte{0x01, 0x00}, mustMarshal(t, req, "application,explicit,tag:14")...))
+	init := negTokenInit{MechTypes: []asn1.ObjectIdentifier{oidMSKerberos, oidKerberos5}, MechToken: krbToken}
+	return gssWrap(t, oidSPNEGO, mustMarshal(t, init, "explicit,tag:0"))
+}
+
+func TestNFoldVectors(t *testing.T) {
+	// Test vectors from RFC 3961 appendix A.1.
+	cases := []struct {
+		in   string
+		bits int
+		want string
+	}{
+		{"012345", 64, "be072631276b1955"},
+		{"password", 56, "78a07b6caf85fa"},
+		{"Rough Consensus, and Running Code", 64, "bb6ed30870b7f0e0"},
+		{"password", 168, "59e4a8ca7c0385c3c37b3f6d2000247cb6e6bd5b3e"},
+		{"MASSACHVSETTS INSTITVTE OF TECHNOLOGY", 192, "db3b0d8f0b061e603282b308a50841229ad798fab9540c1b"},
+		{"kerberos", 64, "6b65726265726f73"},
+		{"kerberos", 128, "6b65726265726f737b9b5b2b93132b93"},
+	}
+	for _, tc := range cases {
+		if got := hex.EncodeToString(nfold([]byte(tc.in), tc.bits/8)); got != tc.want {
+			t.Errorf("%d-fold(%q) = %s, want %s", tc.bits, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestAESCTSDecryptVectors(t *testing.T) {
+	// Test vectors from RFC 3962 appendix B ("chicken teriyaki", zero IV).
+	key := []byte("chicken teriyaki")
+	cases := []struct{ plain, cipher string }{
+		{"4920776f756c64206c696b652074686520", "c6353568f2bf8cb4d8a580362da7ff7f97"},
+		{"4920776f756c64206c696b65207468652047656e6572616c20476175277320", "fc00783e0efdb2c1d445d4c8eff7ed2297687268d6ecccc0c07b25e25ecfe5"},
+		{"4920776f756c64206c696b65207468652047656e6572616c2047617527732043", "39312523a78662d5be7fcbcc98ebf5a897687268d6ecccc0c07b25e25ecfe584"},
+	}
+	for _, tc := range cases {
+		ciphertext, _ := hex.DecodeString(tc.cipher)
+		plain, err := aesCTSDecrypt(key, ciphertext)
+		if err != nil {
+			t.Fatalf("decrypt failed: %v", err)
+		}
+		if got := hex.EncodeToString(plain); got != tc.plain {
+			t.Errorf("decrypt(%s) = %s, want %s", tc.cipher, got, tc.plain)
+		}
+		if got := hex.EncodeToString(aesCTSEncryptForTest(t, key, plain)); got != tc.cipher {
+			t.Errorf("encrypt round trip = %s, want %s", got, tc.cipher)
+		}
+	}
+}
+
+func TestKrbDecryptRejectsTampering(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 16)
+	sealed := krbEncryptForTest(t, key, krbKeyUsageTicket, []byte("ticket contents"))
+	plain, err := krbDecrypt(krbEtypeAES128CTSHMACSHA196, key, krbKeyUsageTicket, sealed)
+	if err != nil || string(plain) != "ticket contents" {
+		t.Fatalf("expected round trip, got %q, %v", plain, err)
+	}
+	if _, err := krbDecrypt(krbEtypeAES128CTSHMACSHA196, key, krbKeyUsageAPReqAuthData, sealed); err == nil {
+		t.Fatal("expected a different key usage to fail integrity")
+	}
+	sealed[3] ^= 1
+	if _, err := krbDecrypt(krbEtypeAES128CTSHMACSHA196, key, krbKeyUsageTicket, sealed); err == nil {
+		t.Fatal("expected tampered ciphertext to be rejected")
+	}
+}
+
+func TestParseKeytab(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	entries, err := parseKeytab(buildKeytabForTest("HTTP/gateway.corp.example.com", "CORP.EXAMPLE.COM", 3, krbEtypeAES256CTSHMACSHA196, key))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected deleted entries to be skipped, got %d entries", len(entries))
+	}
+	entry := entries[0]
+	if entry.Principal != "HTTP/gateway.corp.example.com" || entry.Realm != "CORP.EXAMPLE.COM" || entry.KVNO != 3 || entry.EType != krbEtypeAES256CTSHMACSHA196 || !bytes.Equal(entry.Key, key) {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	if _, err := parseKeytab([]byte{0x05, 0x01}); err == nil {
+		t.Fatal("expected unsupported version to be rejected")
+	}
+	for name, size := range map[string]uint32{
+		"min int32 deleted entry":    0x80000000,
+		"oversized deleted entry":    0xFFFFFF00,
+		"deleted entry past the end": 0xFFFFFFFF - 7,
+	} {
+		data := binary.BigEndian.AppendUint32([]byte{0x05, 0x02}, size)
+		if _, err := parseKeytab(append(data, 0, 0, 0)); err == nil {
+			t.Fatalf("%s: expected a truncated keytab error", name)
+		}
+	}
+}
+
+func newTestKerberosValidator(t *testing.T, serviceKey []byte, now time.Time) *kerberosValidator {
+	t.Helper()
+	entries, err := parseKeytab(buildKeytabForTest("HTTP/gateway.corp.example.com", "CORP.EXAMPLE.COM", 3, krbEtypeAES256CTSHMACSHA196, serviceKey))
+	if err != nil {
+		t.Fatalf("keytab: %v", err)
+	}
+	return &kerberosValidator{keys: entries, replay: newMemoryConsumedStateStore(), now: func() time.Time { return now }}
+}
+
+func TestKerberosValidatorAcceptsValidTicket(t *testing.T) {
+	now := time.Now()
+	serviceKey := bytes.Repeat([]byte{9}, 32)
+	validator := newTestKerberosValidator(t, serviceKey, now)
+	ticket := kerberosTestTicket{serviceKey: serviceKey, service: "HTTP/gateway.corp.example.com", realm: "CORP.EXAMPLE.COM", client: "alice", now: now}
+	token := ticket.negotiateToken(t)
+
+	identity, err := validator.validate(context.Background(), token)
+	if err != nil {
+		t.Fatalf("expected ticket to validate: %v", err)
+	}
+	if identity.String() != "alice@CORP.EXAMPLE.COM" {
+		t.Fatalf("unexpected identity %s", identity)
+	}
+	if _, err := validator.validate(context.Background(), token); err == nil || !strings.Contains(err.Error(), "replayed") {
+		t.Fatalf("expected replayed authenticator to be rejected, got %v", err)
+	}
+}
+
+func TestKerberosValidatorRejectsInvalidTickets(t *testing.T) {
+	now := time.Now()
+	serviceKey := bytes.Repeat([]byte{9}, 32)
+	base := kerberosTestTicket{serviceKey: serviceKey, service: "HTTP/gateway.corp.example.com", realm: "CORP.EXAMPLE.COM", client: "alice", now: now}
+
+	cases := map[string]func(kerberosTestTicket) kerberosTestTicket{
+		"wrong key": func(k kerberosTestTicket) kerberosTestTicket {
+			k.serviceKey = bytes.Repeat([]byte{8}, 32)
+			return k
+		},
+		"unknown service": func(k kerberosTestTicket) kerberosTestTicket {
+			k.service = "HTTP/other.corp.example.com"
+			return k
+		},
+		"expired ticket": func(k kerberosTestTicket) kerberosTestTicket {
+			k.endTime = now.Add(-time.Hour)
+			return k
+		},
+		"stale authenticator": func(k kerberosTestTicket) kerberosTestTicket {
+			k.ctime = now.Add(-10 * time.Minute)
+			return k
+		},
+	}
+	for name, mutate := range cases {
+		t.Run(name, func(t *testing.T) {
+			validator := newTestKerberosValidator(t, serviceKey, now)
+			if _, err := validator.validate(context.Background(), mutate(base).negotiateToken(t)); err == nil {
+				t.Fatal("expected validation to fail")
+			}
+		})
+	}
+
+	validator := newTestKerberosValidator(t, serviceKey, now)
+	validator.servicePrincipal = "HTTP/other.corp.example.com@CORP.EXAMPLE.COM"
+	if _, err := validator.validate(context.Background(), base.negotiateToken(t)); err == nil {
+		t.Fatal("expected service principal restriction to apply")
+	}
+	if _, err := validator.validate(context.Background(), []byte("not a token")); err == nil {
+		t.Fatal("expected garbage to be rejected")
+	}
+}
@@ -0,0 +1,185 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAssertionKey = "assertion-key-0123456789abcdef0123"
+
+func setupNegotiate(t *testing.T, serviceKey []byte) *negotiateConfig {
+	t.Helper()
+	keytab := filepath.Join(t.TempDir(), "gateway.keytab")
+	if err := os.WriteFile(keytab, buildKeytabForTest("HTTP/gateway.corp.example.com", "CORP.EXAMPLE.COM", 3, krbEtypeAES256CTSHMACSHA196, serviceKey), 0o600); err != nil {
+		t.Fatalf("failed to write keytab: %v", err)
+	}
+	t.Setenv("RUN_MODE", "enterprise")
+	t.Setenv("GATEWAY_SPNEGO_ENABLED", "true")
+	t.Setenv("GATEWAY_SPNEGO_KEYTAB", keytab)
+	t.Setenv("GATEWAY_SPNEGO_REALM_TENANTS", "corp.example.com=acme")
+	t.Setenv("GATEWAY_IDENTITY_ASSERTION_KEY", testAssertionKey)
+	t.Setenv("OAUTH_ALLOWED_REDIRECT_ORIGINS", "https://app.example.com")
+	allowedRedirectOrigins = loadAllowedRedirectOrigins()
+	setupTestCookies(t)
+
+	cfg, err := loadNegotiateConfig()
+	if err != nil {
+		t.Fatalf("unexpected config error: %v", err)
+	}
+	if cfg == nil {
+		t.Fatal("expected negotiate to be enabled")
+	}
+	return cfg
+}
+
+func TestLoadNegotiateConfigRequiresEnterpriseMode(t *testing.T) {
+	t.Setenv("GATEWAY_SPNEGO_ENABLED", "")
+	if cfg, err := loadNegotiateConfig(); cfg != nil || err != nil {
+		t.Fatalf("expected negotiate to be disabled by default, got %v, %v", cfg, err)
+	}
+
+	t.Setenv("GATEWAY_SPNEGO_ENABLED", "true")
+	t.Setenv("RUN_MODE", "consumer")
+	if _, err := loadNegotiateConfig(); err == nil {
+		t.Fatal("expected consumer mode to be rejected")
+	}
+
+	t.Setenv("RUN_MODE", "enterprise")
+	t.Setenv("GATEWAY_SPNEGO_KEYTAB", "")
+	if _, err := loadNegotiateConfig(); err == nil {
+		t.Fatal("expected missing keytab to be rejected")
+	}
+}
+
+func TestParseRealmTenants(t *testing.T) {
+	mapping, err := parseRealmTenants("corp.example.com=acme, EU.EXAMPLE.COM=acme-eu")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mapping["CORP.EXAMPLE.COM"] != "acme" || mapping["EU.EXAMPLE.COM"] != "acme-eu" {
+		t.Fatalf("unexpected mapping %v", mapping)
+	}
+	for _, raw := range []string{"CORP.EXAMPLE.COM", "=acme", "CORP.EXAMPLE.COM=bad tenant"} {
+		if _, err := parseRealmTenants(raw); err == nil {
+			t.Errorf("expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestNegotiateHandlerChallengesWithFallback(t *testing.T) {
+	cfg := setupNegotiate(t, bytes.Repeat([]byte{9}, 32))
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/negotiate?redirect_uri=https://app.example.com/complete", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	negotiateHandler(rec, req, nil, cfg)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 challenge, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("WWW-Authenticate"); got != "Negotiate" {
+		t.Fatalf("expected Negotiate challenge, got %q", got)
+	}
+	if !strings.Contains(rec.Body.String(), "/auth/oidc/authorize?redirect_uri=https") {
+		t.Fatalf("expected fallback link in body, got %s", rec.Body.String())
+	}
+}
+
+func TestNegotiateHandlerFallsBackOnInvalidToken(t *testing.T) {
+	cfg := setupNegotiate(t, bytes.Repeat([]byte{9}, 32))
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/negotiate?redirect_uri=https://app.example.com/complete", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString([]byte("garbage")))
+	rec := httptest.NewRecorder()
+	negotiateHandler(rec, req, nil, cfg)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected fallback redirect, got %d", rec.Code)
+	}
+	if location := rec.Header().Get("Location"); !strings.HasPrefix(location, "/auth/oidc/authorize?") {
+		t.Fatalf("expected redirect to fallback provider, got %q", location)
+	}
+}
+
+func TestNegotiateHandlerMintsSession(t *testing.T) {
+	serviceKey := bytes.Repeat([]byte{9}, 32)
+	cfg := setupNegotiate(t, serviceKey)
+
+	var assertion string
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/auth/assertion" {
+				t.Errorf("unexpected orchestrator path %s", req.URL.Path)
+			}
+			var body struct {
+				Assertion string `json:"assertion"`
+			}
+			raw, _ := io.ReadAll(req.Body)
+			_ = json.Unmarshal(raw, &body)
+			assertion = body.Assertion
+			header := make(http.Header)
+			header.Add("Set-Cookie", "oss_session=session-1; Path=/; HttpOnly; Secure")
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"ok":true}`)), Header: header}, nil
+		})}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+
+	token := kerberosTestTicket{serviceKey: serviceKey, service: "HTTP/gateway.corp.example.com", realm: "CORP.EXAMPLE.COM", client: "alice", now: time.Now()}.negotiateToken(t)
+	req := httptest.NewRequest(http.MethodGet, "/auth/negotiate?redirect_uri=https://app.example.com/complete", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+	rec := httptest.NewRecorder()
+	negotiateHandler(rec, req, nil, cfg)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if location.Host != "app.example.com" || location.Query().Get("status") != "success" {
+		t.Fatalf("expected success redirect to client, got %s", location)
+	}
+	if findCookie(rec.Result().Cookies(), "oss_session") == nil {
+		t.Fatal("expected orchestrator session cookie to be forwarded")
+	}
+
+	var claims identityAssertion
+	if err := verifyGatewayToken([]byte(testAssertionKey), gatewayTokenIdentityAssertion, assertion, &claims); err != nil {
+		t.Fatalf("expected signed assertion: %v", err)
+	}
+	if claims.Subject != "alice@CORP.EXAMPLE.COM" || claims.Method != "kerberos" || claims.TenantID != "acme" || claims.ID == "" {
+		t.Fatalf("unexpected assertion claims %+v", claims)
+	}
+	if claims.ExpiresAt-claims.IssuedAt != int64(defaultIdentityAssertionTTL/time.Second) {
+		t.Fatalf("expected default assertion ttl, got %d", claims.ExpiresAt-claims.IssuedAt)
+	}
+}
+
+func TestNegotiateHandlerRejectsUnmappedRealm(t *testing.T) {
+	serviceKey := bytes.Repeat([]byte{9}, 32)
+	cfg := setupNegotiate(t, serviceKey)
+	cfg.realmTenants = map[string]string{"OTHER.EXAMPLE.COM": "other"}
+	cfg.fallbackProvider = ""
+
+	token := kerberosTestTicket{serviceKey: serviceKey, service: "HTTP/gateway.corp.example.com", realm: "CORP.EXAMPLE.COM", client: "alice", now: time.Now()}.negotiateToken(t)
+	req := httptest.NewRequest(http.MethodGet, "/auth/negotiate?redirect_uri=https://app.example.com/complete", nil)
+	req.TLS = &tls.ConnectionState{}
+	req.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+	rec := httptest.NewRecorder()
+	negotiateHandler(rec, req, nil, cfg)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without fallback, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDependencyWaitTimeout = 2 * time.Minute
+	dependencyWaitInitialBackoff = 500 * time.Millisecond
+	dependencyWaitMaxBackoff     = 10 * time.Second
+)
+
+// startupWait is the state of the startup dependency gate, reported by
+// /healthz while the gateway waits and after it has finished.
+type startupWait struct {
+	mu       sync.Mutex
+	active   bool
+	attempts int
+	waiting  []string
+}
+
+var dependencyWaitState startupWait
+
+// result reports the gate as a /healthz dependency, or false when the gate
+// never ran.
+func (s *startupWait) result() (dependencyResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attempts == 0 {
+		return dependencyResult{}, false
+	}
+	if s.active {
+		return dependencyResult{Status: "waiting", Details: append([]string(nil), s.waiting...)}, true
+	}
+	return dependencyResult{Status: healthStatusPass}, true
+}
+
+// reset forgets the gate's state for tests.
+func (s *startupWait) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active = false
+	s.attempts = 0
+	s.waiting = nil
+}
+
+func (s *startupWait) update(active bool, waiting []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active = active
+	s.attempts++
+	s.waiting = waiting
+}
+
+// DependencyWaitEnabled reports whether GATEWAY_WAIT_FOR_DEPENDENCIES asks
+// the gateway to wait for its dependencies before serving public traffic.
+func DependencyWaitEnabled() bool {
+	return getBoolEnv("GATEWAY_WAIT_FOR_DEPENDENCIES")
+}
+
+// WaitForDependencies polls the required /readyz dependency checks with
+// jittered exponential backoff until they all pass or
+// GATEWAY_WAIT_FOR_DEPENDENCIES_TIMEOUT (default 2m) elapses, in which case
+// it returns an error naming the dependencies that are still failing. The
+// caller binds the public listener only after it returns nil.
+func WaitForDependencies(ctx context.Context) error {
+	checks, err := loadHealthChecks()
+	if err != nil {
+		return err
+	}
+	timeout := ResolveDuration([]string{"GATEWAY_WAIT_FOR_DEPENDENCIES_TIMEOUT"}, defaultDependencyWaitTimeout)
+	return waitForHealthChecks(ctx, checks, timeout, dependencyWaitInitialBackoff)
+}
+
+func waitForHealthChecks(ctx context.Context, checks []healthCheck, timeout, backoff time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	started := time.Now()
+	for {
+		failing := failingHealthChecks(ctx, checks)
+		if len(failing) == 0 {
+			dependencyWaitState.update(false, nil)
+			slog.InfoContext(ctx, "gateway.startup.dependencies_ready", slog.Duration("waited", time.Since(started)))
+			return nil
+		}
+		dependencyWaitState.update(true, failing)
+		slog.InfoContext(ctx, "gateway.startup.waiting_for_dependencies",
+			slog.String("failing", strings.Join(failing, "; ")),
+			slog.Duration("retry_in", backoff))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("dependencies not ready after %s: %s", timeout, strings.Join(failing, "; "))
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, dependencyWaitMaxBackoff)
+		backoff += rand.N(backoff / 10)
+	}
+}
+
+// failingHealthChecks runs the required checks and describes those that
+// would make the gateway unready. Optional checks and degrade dependencies
+// never hold up startup.
+func failingHealthChecks(ctx context.Context, checks []healthCheck) []string {
+	var (
+		mu      sync.Mutex
+		failing []string
+		wg      sync.WaitGroup
+	)
+	for _, check := range checks {
+		if check.optional {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := check.run(ctx)
+			if check.impact(result) != healthStatusFail {
+				return
+			}
+			reason := check.name
+			if result.Error != nil {
+				reason = *result.Error
+			}
+			mu.Lock()
+			failing = append(failing, reason)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	slices.Sort(failing)
+	return failing
+}
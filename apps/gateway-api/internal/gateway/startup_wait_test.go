@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitForHealthChecksRetriesUntilReady(t *testing.T) {
+	dependencyWaitState.reset()
+	t.Cleanup(dependencyWaitState.reset)
+	var calls atomic.Int32
+	dependency := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer dependency.Close()
+	checks, err := parseHealthChecks(`{"orchestrator":{"disabled":true},"indexer":{"disabled":true},` +
+		`"vault":{"url":"` + dependency.URL + `"},"cache":{"url":"tcp://127.0.0.1:1","optional":true}}`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if err := waitForHealthChecks(context.Background(), checks, 5*time.Second, time.Millisecond); err != nil {
+		t.Fatalf("expected the dependencies to become ready, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected three attempts, got %d", calls.Load())
+	}
+	resp := buildHealthResponse(context.Background(), time.Now(), false)
+	if startup := resp.Details["startup"]; startup.Status != "pass" {
+		t.Fatalf("expected the startup gate to be reported as passed, got %+v", startup)
+	}
+}
+
+func TestWaitForHealthChecksGivesUp(t *testing.T) {
+	dependencyWaitState.reset()
+	t.Cleanup(dependencyWaitState.reset)
+	checks, err := parseHealthChecks(`{"orchestrator":{"disabled":true},"indexer":{"disabled":true},"redis":{"url":"tcp://127.0.0.1:1"}}`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	err = waitForHealthChecks(context.Background(), checks, 50*time.Millisecond, 5*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "redis connection failed") {
+		t.Fatalf("expected the failing dependency to be named, got %v", err)
+	}
+	resp := buildHealthResponse(context.Background(), time.Now(), false)
+	if startup := resp.Details["startup"]; startup.Status != "waiting" || len(startup.Details) != 1 {
+		t.Fatalf("expected the gate to still be waiting, got %+v", startup)
+	}
+}
@@ -0,0 +1,227 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+const stateStorageMigrationTimeout = 30 * time.Second
+
+// stateStorageMigrations lists schema migrations for GATEWAY_STORAGE_URL in
+// order. Append new entries; never reorder or remove them.
+var stateStorageMigrations []storage.Migration
+
+var (
+	stateStorageMu   sync.Mutex
+	stateStorageOnce sync.Once
+	stateStorage     storage.Store
+	stateStorageErr  error
+)
+
+// resetStateStorage clears the cached store for tests.
+func resetStateStorage() {
+	stateStorageMu.Lock()
+	defer stateStorageMu.Unlock()
+	if stateStorage != nil {
+		_ = stateStorage.Close()
+	}
+	forgetRedisClient(redisComponentStorage)
+	stateStorageOnce = sync.Once{}
+	stateStorage = nil
+	stateStorageErr = nil
+}
+
+// loadStateStorage opens GATEWAY_STORAGE_URL and brings its schema up to date.
+// Subsystems take a storage.Namespace of the result instead of opening their
+// own store.
+func loadStateStorage() (storage.Store, error) {
+	stateStorageMu.Lock()
+	defer stateStorageMu.Unlock()
+	stateStorageOnce.Do(func() {
+		rawURL, err := ResolveEnvValue("GATEWAY_STORAGE_URL")
+		if err != nil {
+			stateStorageErr = fmt.Errorf("failed to load GATEWAY_STORAGE_URL: %w", err)
+			return
+		}
+		store, err := openStateStorage(rawURL)
+		if err != nil {
+			stateStorageErr = err
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), stateStorageMigrationTimeout)
+		defer cancel()
+		if err := migrateStateStorageOnStartup(ctx, store); err != nil {
+			_ = store.Close()
+			forgetRedisClient(redisComponentStorage)
+			stateStorageErr = err
+			return
+		}
+		stateStorage = store
+	})
+	return stateStorage, stateStorageErr
+}
+
+// migrateStateStorageOnStartup brings store up to date according to
+// GATEWAY_STORAGE_MIGRATIONS: "auto" (the default) migrates, "manual" refuses
+// to start on an outdated schema so operators run "gateway-api migrate"
+// first.
+func migrateStateStorageOnStartup(ctx context.Context, store storage.Store) error {
+	switch mode := strings.ToLower(strings.TrimSpace(GetEnv("GATEWAY_STORAGE_MIGRATIONS", "auto"))); mode {
+	case "auto":
+		_, err := storage.Migrate(ctx, store, stateStorageMigrations)
+		return err
+	case "manual":
+		current, pending, err := storage.PendingMigrations(ctx, store, stateStorageMigrations)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("storage schema version %d is older than this build expects (%d); run gateway-api migrate", current, len(stateStorageMigrations))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported GATEWAY_STORAGE_MIGRATIONS %q (expected auto or manual)", mode)
+	}
+}
+
+// StorageMigration is a schema migration listed by MigrateStateStorage.
+type StorageMigration struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+}
+
+// StorageMigrationReport is the outcome of "gateway-api migrate".
+type StorageMigrationReport struct {
+	DryRun        bool `json:"dry_run"`
+	SchemaVersion int  `json:"schema_version"`
+	TargetVersion int  `json:"target_version"`
+	// Pending lists the migrations a dry run would apply; Applied those a
+	// run applied, up to a failed one.
+	Pending []StorageMigration `json:"pending,omitempty"`
+	Applied []StorageMigration `json:"applied,omitempty"`
+}
+
+// MigrateStateStorage migrates GATEWAY_STORAGE_URL outside the serving
+// process, taking the same lock as startup migrations, or with dryRun only
+// reports what would be applied. It does not depend on
+// GATEWAY_STORAGE_MIGRATIONS.
+func MigrateStateStorage(ctx context.Context, dryRun bool) (StorageMigrationReport, error) {
+	report := StorageMigrationReport{DryRun: dryRun, TargetVersion: len(stateStorageMigrations)}
+	rawURL, err := ResolveEnvValue("GATEWAY_STORAGE_URL")
+	if err != nil {
+		return report, fmt.Errorf("failed to load GATEWAY_STORAGE_URL: %w", err)
+	}
+	store, err := openStateStorage(rawURL)
+	if err != nil {
+		return report, err
+	}
+	defer func() {
+		_ = store.Close()
+		forgetRedisClient(redisComponentStorage)
+	}()
+
+	current, pending, err := storage.PendingMigrations(ctx, store, stateStorageMigrations)
+	report.SchemaVersion = current
+	if err != nil || dryRun {
+		report.Pending = storageMigrationList(pending)
+		return report, err
+	}
+	applied, err := storage.Migrate(ctx, store, stateStorageMigrations)
+	report.Applied = storageMigrationList(applied)
+	// Another replica may have migrated meanwhile, so read the version back.
+	if version, versionErr := storage.SchemaVersion(ctx, store); versionErr == nil {
+		report.SchemaVersion = version
+	}
+	return report, err
+}
+
+func storageMigrationList(migrations []storage.Migration) []StorageMigration {
+	list := make([]StorageMigration, 0, len(migrations))
+	for _, migration := range migrations {
+		list = append(list, StorageMigration{Version: migration.Version, Name: migration.Name})
+	}
+	return list
+}
+
+// openStateStorage opens rawURL, connecting Redis URLs through
+// newRedisClient so they get the shared Redis settings and health reporting.
+// A file store is encrypted when GATEWAY_STORAGE_ENCRYPTION_KEY is set.
+func openStateStorage(rawURL string) (storage.Store, error) {
+	encryption, err := loadStorageEncryption()
+	if err != nil {
+		return nil, err
+	}
+	scheme, _, _ := strings.Cut(strings.TrimSpace(rawURL), "://")
+	if encryption != nil && scheme != "file" {
+		return nil, errors.New("GATEWAY_STORAGE_ENCRYPTION_KEY requires a file:// GATEWAY_STORAGE_URL")
+	}
+	if storage.IsRedisScheme(scheme) {
+		client, err := newRedisClient(redisComponentStorage, rawURL, "state shared through GATEWAY_STORAGE_URL is kept per replica where the feature allows")
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewRedisStore(client), nil
+	}
+	store, err := storage.OpenWithOptions(rawURL, storage.Options{FileEncryption: encryption, Now: clockNow})
+	if errors.Is(err, storage.ErrUnencryptedFile) {
+		return nil, fmt.Errorf("%w; set GATEWAY_STORAGE_ENCRYPTION_MIGRATE=true for one start to encrypt it", err)
+	}
+	return store, err
+}
+
+// loadStorageEncryption reads GATEWAY_STORAGE_ENCRYPTION_KEY, the
+// comma-separated GATEWAY_STORAGE_ENCRYPTION_PREVIOUS_KEYS it replaced and
+// GATEWAY_STORAGE_ENCRYPTION_MIGRATE. It returns nil when no key is set.
+func loadStorageEncryption() (*storage.FileEncryption, error) {
+	key, err := ResolveEnvValue("GATEWAY_STORAGE_ENCRYPTION_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_STORAGE_ENCRYPTION_KEY: %w", err)
+	}
+	previous, err := ResolveEnvValue("GATEWAY_STORAGE_ENCRYPTION_PREVIOUS_KEYS")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GATEWAY_STORAGE_ENCRYPTION_PREVIOUS_KEYS: %w", err)
+	}
+	if key == "" {
+		if previous != "" {
+			return nil, errors.New("GATEWAY_STORAGE_ENCRYPTION_PREVIOUS_KEYS requires GATEWAY_STORAGE_ENCRYPTION_KEY")
+		}
+		return nil, nil
+	}
+	if len(key) < storage.MinFileEncryptionKeyBytes {
+		return nil, fmt.Errorf("GATEWAY_STORAGE_ENCRYPTION_KEY must be at least %d bytes", storage.MinFileEncryptionKeyBytes)
+	}
+	encryption := &storage.FileEncryption{Key: []byte(key), MigratePlaintext: getBoolEnv("GATEWAY_STORAGE_ENCRYPTION_MIGRATE")}
+	for _, old := range strings.Split(previous, ",") {
+		if old = strings.TrimSpace(old); old == "" {
+			continue
+		}
+		if len(old) < storage.MinFileEncryptionKeyBytes {
+			return nil, fmt.Errorf("GATEWAY_STORAGE_ENCRYPTION_PREVIOUS_KEYS entries must be at least %d bytes", storage.MinFileEncryptionKeyBytes)
+		}
+		encryption.PreviousKeys = append(encryption.PreviousKeys, []byte(old))
+	}
+	return encryption, nil
+}
+
+// InitStateStorage opens the shared state store at startup so configuration
+// and migration errors stop the process before it serves traffic.
+func InitStateStorage() error {
+	_, err := loadStateStorage()
+	return err
+}
+
+// CloseStateStorage closes the shared state store during shutdown.
+func CloseStateStorage() error {
+	stateStorageMu.Lock()
+	defer stateStorageMu.Unlock()
+	if stateStorage == nil {
+		return nil
+	}
+	return stateStorage.Close()
+}
@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+func TestLoadStateStorageDefaultsToMemory(t *testing.T) {
+	t.Setenv("GATEWAY_STORAGE_URL", "")
+	resetStateStorage()
+	t.Cleanup(resetStateStorage)
+
+	store, err := loadStateStorage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := store.(*storage.MemoryStore); !ok {
+		t.Fatalf("expected memory store, got %T", store)
+	}
+}
+
+func TestLoadStateStorageRunsMigrations(t *testing.T) {
+	t.Setenv("GATEWAY_STORAGE_URL", "file://"+filepath.Join(t.TempDir(), "state.db"))
+	resetStateStorage()
+	t.Cleanup(resetStateStorage)
+
+	previous := stateStorageMigrations
+	t.Cleanup(func() { stateStorageMigrations = previous })
+	stateStorageMigrations = []storage.Migration{{Version: 1, Name: "seed", Apply: func(ctx context.Context, store storage.Store) error {
+		return store.Set(ctx, "seeded", []byte("1"), 0)
+	}}}
+
+	if err := InitStateStorage(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store, _ := loadStateStorage()
+	if version, _ := storage.SchemaVersion(context.Background(), store); version != 1 {
+		t.Fatalf("expected schema version 1, got %d", version)
+	}
+}
+
+func TestLoadStateStorageRejectsInvalidURL(t *testing.T) {
+	t.Setenv("GATEWAY_STORAGE_URL", "dynamo://table")
+	resetStateStorage()
+	t.Cleanup(resetStateStorage)
+	if err := InitStateStorage(); err == nil {
+		t.Fatal("expected configuration error")
+	}
+}
+
+func TestManualStorageMigrationsRunThroughMigrateStateStorage(t *testing.T) {
+	t.Setenv("GATEWAY_STORAGE_URL", "file://"+filepath.Join(t.TempDir(), "state.db"))
+	t.Setenv("GATEWAY_STORAGE_MIGRATIONS", "manual")
+	resetStateStorage()
+	t.Cleanup(resetStateStorage)
+
+	previous := stateStorageMigrations
+	t.Cleanup(func() { stateStorageMigrations = previous })
+	stateStorageMigrations = []storage.Migration{{Version: 1, Name: "seed", Apply: func(ctx context.Context, store storage.Store) error {
+		return store.Set(ctx, "seeded", []byte("1"), 0)
+	}}}
+
+	if err := InitStateStorage(); err == nil || !strings.Contains(err.Error(), "gateway-api migrate") {
+		t.Fatalf("expected startup to refuse the outdated schema, got %v", err)
+	}
+
+	report, err := MigrateStateStorage(context.Background(), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.SchemaVersion != 0 || report.TargetVersion != 1 || len(report.Pending) != 1 || len(report.Applied) != 0 {
+		t.Fatalf("unexpected dry run report %+v", report)
+	}
+
+	report, err = MigrateStateStorage(context.Background(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.SchemaVersion != 1 || len(report.Applied) != 1 || report.Applied[0].Name != "seed" {
+		t.Fatalf("unexpected migration report %+v", report)
+	}
+
+	resetStateStorage()
+	if err := InitStateStorage(); err != nil {
+		t.Fatalf("expected migrated store to start, got %v", err)
+	}
+}
+
+func TestLoadStateStorageRejectsUnknownMigrationMode(t *testing.T) {
+	t.Setenv("GATEWAY_STORAGE_URL", "")
+	t.Setenv("GATEWAY_STORAGE_MIGRATIONS", "never")
+	resetStateStorage()
+	t.Cleanup(resetStateStorage)
+	if err := InitStateStorage(); err == nil {
+		t.Fatal("expected configuration error")
+	}
+}
+
+func TestLoadStateStorageEncryptsFileStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	t.Setenv("GATEWAY_STORAGE_URL", "file://"+path)
+	resetStateStorage()
+	t.Cleanup(resetStateStorage)
+
+	store, err := loadStateStorage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Set(context.Background(), "session:s-1", []byte("tenant-a"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resetStateStorage()
+
+	t.Setenv("GATEWAY_STORAGE_ENCRYPTION_KEY", strings.Repeat("k", 32))
+	if err := InitStateStorage(); err == nil || !strings.Contains(err.Error(), "GATEWAY_STORAGE_ENCRYPTION_MIGRATE") {
+		t.Fatalf("expected the plaintext store to be refused without migration, got %v", err)
+	}
+	resetStateStorage()
+	t.Setenv("GATEWAY_STORAGE_ENCRYPTION_MIGRATE", "true")
+	if err := InitStateStorage(); err != nil {
+		t.Fatalf("expected the plaintext store to be migrated, got %v", err)
+	}
+	resetStateStorage()
+	t.Setenv("GATEWAY_STORAGE_ENCRYPTION_MIGRATE", "")
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), `"encryption":"aes-256-gcm"`) || strings.Contains(string(data), "session:s-1") {
+		t.Fatalf("expected an encrypted store file:\n%s", data)
+	}
+
+	t.Setenv("GATEWAY_STORAGE_ENCRYPTION_KEY", strings.Repeat("n", 32))
+	if err := InitStateStorage(); err == nil {
+		t.Fatal("expected the store to fail verification without its key")
+	}
+	resetStateStorage()
+	t.Setenv("GATEWAY_STORAGE_ENCRYPTION_PREVIOUS_KEYS", "short,"+strings.Repeat("k", 32))
+	if err := InitStateStorage(); err == nil {
+		t.Fatal("expected a short previous key to be rejected")
+	}
+	resetStateStorage()
+	t.Setenv("GATEWAY_STORAGE_ENCRYPTION_PREVIOUS_KEYS", strings.Repeat("k", 32))
+	store, err = loadStateStorage()
+	if err != nil {
+		t.Fatalf("expected the previous key to open the store, got %v", err)
+	}
+	if value, err := store.Get(context.Background(), "session:s-1"); err != nil || string(value) != "tenant-a" {
+		t.Fatalf("expected the value after rotation, got %q, %v", value, err)
+	}
+
+	resetStateStorage()
+	t.Setenv("GATEWAY_STORAGE_URL", "")
+	if err := InitStateStorage(); err == nil || !strings.Contains(err.Error(), "file://") {
+		t.Fatalf("expected encryption to require a file store, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package gateway
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	streamKindEvents        = "events"
+	streamKindCollaboration = "collaboration"
+
+	defaultStreamWatchdogInterval = time.Minute
+	// streamLeakGrace is how long a goroutine may outlive its connection
+	// before the watchdog reports it; closing an upstream body or a socket
+	// unblocks its copier almost immediately.
+	streamLeakGrace = 30 * time.Second
+)
+
+// streamConns accounts for the long-lived streaming connections (SSE and
+// collaboration WebSockets) and the goroutines each of them spawns.
+var streamConns = newStreamTracker()
+
+// streamTracker counts open streaming connections and their goroutines. A
+// connection that closes while goroutines it spawned are still running
+// lingers until they exit; the watchdog reports those that linger past
+// streamLeakGrace, since a leaked copier only shows up as memory growth.
+type streamTracker struct {
+	mu        sync.Mutex
+	open      map[string]int64
+	lingering map[*streamConn]struct{}
+	now       func() time.Time
+
+	goroutines atomic.Int64
+	leaked     atomic.Int64
+}
+
+// streamConn is one streaming connection. Its goroutines must be started
+// with goroutine so they are counted.
+type streamConn struct {
+	tracker  *streamTracker
+	kind     string
+	live     atomic.Int64
+	closed   bool
+	closedAt time.Time
+}
+
+// streamStats is the accounting snapshot reported by GET
+// /admin/debug/runtime.
+type streamStats struct {
+	Connections map[string]int64 `json:"connections"`
+	Goroutines  int64            `json:"goroutines"`
+	Lingering   int64            `json:"lingering_goroutines"`
+	Leaked      int64            `json:"leaked_goroutines"`
+}
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{
+		open:      make(map[string]int64),
+		lingering: make(map[*streamConn]struct{}),
+		now:       time.Now,
+	}
+}
+
+// connect registers a streaming connection of the given kind. The caller
+// must call close when the connection ends.
+func (t *streamTracker) connect(kind string) *streamConn {
+	registerStreamGauges()
+	t.mu.Lock()
+	t.open[kind]++
+	t.mu.Unlock()
+	return &streamConn{tracker: t, kind: kind}
+}
+
+// goroutine runs fn on a new goroutine counted against the connection.
+func (c *streamConn) goroutine(fn func()) {
+	c.live.Add(1)
+	c.tracker.goroutines.Add(1)
+	go func() {
+		defer c.done()
+		fn()
+	}()
+}
+
+func (c *streamConn) done() {
+	t := c.tracker
+	t.goroutines.Add(-1)
+	if c.live.Add(-1) > 0 {
+		return
+	}
+	t.mu.Lock()
+	delete(t.lingering, c)
+	t.mu.Unlock()
+}
+
+// close ends the connection. Goroutines still running keep it lingering
+// until they exit.
+func (c *streamConn) close() {
+	t := c.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closedAt = t.now()
+	t.open[c.kind]--
+	if c.live.Load() > 0 {
+		t.lingering[c] = struct{}{}
+	}
+}
+
+func (t *streamTracker) stats() streamStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := streamStats{
+		Connections: make(map[string]int64, len(t.open)),
+		Goroutines:  t.goroutines.Load(),
+		Leaked:      t.leaked.Load(),
+	}
+	for kind, count := range t.open {
+		stats.Connections[kind] = count
+	}
+	for conn := range t.lingering {
+		stats.Lingering += conn.live.Load()
+	}
+	return stats
+}
+
+// check counts, per kind, the goroutines still running more than grace
+// after their connection closed.
+func (t *streamTracker) check(grace time.Duration) map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cutoff := t.now().Add(-grace)
+	var leaked map[string]int64
+	var total int64
+	for conn := range t.lingering {
+		live := conn.live.Load()
+		if live <= 0 || conn.closedAt.After(cutoff) {
+			continue
+		}
+		if leaked == nil {
+			leaked = make(map[string]int64)
+		}
+		leaked[conn.kind] += live
+		total += live
+	}
+	t.leaked.Store(total)
+	return leaked
+}
+
+// checkStreamGoroutines is the stream_watchdog maintenance job. It warns
+// when streaming goroutines outlive their connections.
+func checkStreamGoroutines(ctx context.Context) error {
+	leaked := streamConns.check(streamLeakGrace)
+	if len(leaked) == 0 {
+		return nil
+	}
+	stats := streamConns.stats()
+	for kind, count := range leaked {
+		slog.WarnContext(ctx, "gateway.streams.goroutine_leak",
+			slog.String("kind", kind),
+			slog.Int64("leaked_goroutines", count),
+			slog.Int64("goroutines", stats.Goroutines),
+			slog.Int64("connections", stats.Connections[kind]),
+		)
+	}
+	return nil
+}
+
+var streamGaugesOnce sync.Once
+
+// registerStreamGauges publishes the accounting through the global meter
+// provider as gateway.streams.connections and gateway.streams.goroutines.
+func registerStreamGauges() {
+	streamGaugesOnce.Do(func() {
+		meter := otel.Meter("gateway.streams")
+		connections, err := meter.Int64ObservableGauge("gateway.streams.connections",
+			metric.WithDescription("Open streaming connections by kind."))
+		if err != nil {
+			slog.Warn("gateway.streams.gauge_failed", slog.String("error", err.Error()))
+			return
+		}
+		goroutines, err := meter.Int64ObservableGauge("gateway.streams.goroutines",
+			metric.WithDescription("Goroutines spawned by streaming connections, by state."))
+		if err != nil {
+			slog.Warn("gateway.streams.gauge_failed", slog.String("error", err.Error()))
+			return
+		}
+		_, err = meter.RegisterCallback(func(_ context.Context, observer metric.Observer) error {
+			stats := streamConns.stats()
+			for kind, count := range stats.Connections {
+				observer.ObserveInt64(connections, count, withDeploymentAttributes(attribute.String("kind", kind)))
+			}
+			observer.ObserveInt64(goroutines, max(stats.Goroutines-stats.Lingering, 0), withDeploymentAttributes(attribute.String("state", "active")))
+			observer.ObserveInt64(goroutines, stats.Lingering, withDeploymentAttributes(attribute.String("state", "lingering")))
+			observer.ObserveInt64(goroutines, stats.Leaked, withDeploymentAttributes(attribute.String("state", "leaked")))
+			return nil
+		}, connections, goroutines)
+		if err != nil {
+			slog.Warn("gateway.streams.gauge_failed", slog.String("error", err.Error()))
+		}
+	})
+}
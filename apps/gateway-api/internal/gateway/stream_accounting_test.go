@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+func TestStreamTrackerReportsLingeringGoroutines(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tracker := newStreamTracker()
+	tracker.now = func() time.Time { return now }
+
+	release := make(chan struct{})
+	exited := make(chan struct{})
+	conn := tracker.connect(streamKindEvents)
+	conn.goroutine(func() { <-release })
+	conn.goroutine(func() { close(exited) })
+	<-exited
+
+	closed := tracker.connect(streamKindEvents)
+	closed.close()
+	if stats := tracker.stats(); stats.Connections[streamKindEvents] != 1 || stats.Lingering != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	conn.close()
+	conn.close()
+	if stats := tracker.stats(); stats.Connections[streamKindEvents] != 0 || stats.Goroutines != 1 || stats.Lingering != 1 {
+		t.Fatalf("expected the blocked goroutine to linger, got %+v", stats)
+	}
+	if leaked := tracker.check(time.Minute); len(leaked) != 0 {
+		t.Fatalf("expected no leak within the grace period, got %v", leaked)
+	}
+	now = now.Add(2 * time.Minute)
+	if leaked := tracker.check(time.Minute); leaked[streamKindEvents] != 1 || tracker.stats().Leaked != 1 {
+		t.Fatalf("expected one leaked goroutine, got %v", leaked)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for tracker.stats().Goroutines != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if leaked := tracker.check(time.Minute); len(leaked) != 0 {
+		t.Fatalf("expected the leak to clear, got %v", leaked)
+	}
+	if stats := tracker.stats(); stats.Goroutines != 0 || stats.Lingering != 0 || stats.Leaked != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestEventsHandlerLeavesNoGoroutines(t *testing.T) {
+	defer goleak.VerifyNone(t, append(goleakOptions, goleak.IgnoreCurrent())...)
+
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer orchestrator.Close()
+	defer orchestrator.CloseClientConnections()
+
+	handler := NewEventsHandler(orchestrator.Client(), orchestrator.URL, 5*time.Millisecond, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/events?plan_id="+validPlanID, nil).WithContext(ctx)
+	rec := newFlushingRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rec, req)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for streamConns.stats().Goroutines == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := streamConns.stats(); stats.Connections[streamKindEvents] != 1 || stats.Goroutines != 1 {
+		t.Fatalf("expected the stream to be accounted for, got %+v", stats)
+	}
+
+	cancel()
+	<-done
+	if stats := streamConns.stats(); stats.Connections[streamKindEvents] != 0 || stats.Goroutines != 0 {
+		t.Fatalf("expected the stream's goroutines to exit with it, got %+v", stats)
+	}
+}
@@ -0,0 +1,243 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/sse"
+)
+
+const (
+	streamRenewalReason = "max_stream_age"
+	// websocketCloseGoingAway signals that the endpoint is going away and the
+	// client should reconnect (RFC 6455 section 7.4.1).
+	websocketCloseGoingAway = 1001
+)
+
+// streamLifetime bounds how long a long-lived stream may stay open so clients
+// periodically reconnect, picking up fresh credentials and rebalancing across
+// gateway replicas.
+type streamLifetime struct {
+	MaxAge time.Duration
+	Jitter time.Duration
+}
+
+// loadStreamLifetime resolves the maximum stream age and jitter from the
+// endpoint-specific variable first, then the shared GATEWAY_MAX_STREAM_AGE
+// settings. A zero max age disables renewal.
+func loadStreamLifetime(maxAgeKey, jitterKey string) streamLifetime {
+	maxAge := ResolveDuration([]string{maxAgeKey, "GATEWAY_MAX_STREAM_AGE"}, 0)
+	if maxAge <= 0 {
+		return streamLifetime{}
+	}
+	jitter := ResolveDuration([]string{jitterKey, "GATEWAY_MAX_STREAM_AGE_JITTER"}, maxAge/10)
+	if jitter >= maxAge {
+		jitter = maxAge / 2
+	}
+	return streamLifetime{MaxAge: maxAge, Jitter: jitter}
+}
+
+func (l streamLifetime) enabled() bool {
+	return l.MaxAge > 0
+}
+
+// next returns the lifetime for a new stream. Jitter is subtracted so streams
+// opened together expire at different times without exceeding MaxAge.
+func (l streamLifetime) next() time.Duration {
+	if l.Jitter <= 0 {
+		return l.MaxAge
+	}
+	return l.MaxAge - rand.N(l.Jitter)
+}
+
+// sseEventIDTracker follows the SSE framing of bytes written to the client so
+// the gateway knows the last dispatched event ID and whether it is currently
+// between events.
+type sseEventIDTracker struct {
+	line      []byte
+	overflow  bool
+	skipLF    bool
+	pending   bool
+	pendingID *string
+	lastID    string
+}
+
+func newSSEEventIDTracker(initial string) *sseEventIDTracker {
+	return &sseEventIDTracker{lastID: initial}
+}
+
+func (t *sseEventIDTracker) observe(chunk []byte) {
+	for len(chunk) > 0 {
+		if t.skipLF {
+			t.skipLF = false
+			if chunk[0] == '\n' {
+				chunk = chunk[1:]
+				continue
+			}
+		}
+		idx := bytes.IndexAny(chunk, "\r\n")
+		if idx < 0 {
+			t.appendLine(chunk)
+			return
+		}
+		t.appendLine(chunk[:idx])
+		t.skipLF = chunk[idx] == '\r'
+		t.endLine()
+		chunk = chunk[idx+1:]
+	}
+}
+
+func (t *sseEventIDTracker) appendLine(part []byte) {
+	if len(part) == 0 {
+		return
+	}
+	t.pending = true
+	if t.overflow {
+		return
+	}
+	if len(t.line)+len(part) > maxLastEventIDHeaderLen+len("id: ") {
+		t.overflow = true
+		t.line = t.line[:0]
+		return
+	}
+	t.line = append(t.line, part...)
+}
+
+func (t *sseEventIDTracker) endLine() {
+	line := t.line
+	overflow := t.overflow
+	t.line = t.line[:0]
+	t.overflow = false
+	if len(line) == 0 && !overflow {
+		// A blank line dispatches the event, committing any id it carried.
+		if t.pendingID != nil {
+			t.lastID = *t.pendingID
+			t.pendingID = nil
+		}
+		t.pending = false
+		return
+	}
+	t.pending = true
+	if overflow || !bytes.HasPrefix(line, []byte("id:")) {
+		return
+	}
+	value := bytes.TrimPrefix(line[len("id:"):], []byte(" "))
+	if hasUnsafeHeaderRunes(string(value)) {
+		return
+	}
+	id := string(value)
+	t.pendingID = &id
+}
+
+// atBoundary reports whether everything written so far forms complete events.
+func (t *sseEventIDTracker) atBoundary() bool {
+	return !t.pending
+}
+
+// emitSSEReconnectEvent tells the client to re-establish the stream. The last
+// event ID is repeated in the id field so EventSource sends it back as
+// Last-Event-ID, and is also exposed in the payload for custom clients.
+func emitSSEReconnectEvent(w io.Writer, lastEventID string) error {
+	payload, err := json.Marshal(map[string]string{
+		"reason":      streamRenewalReason,
+		"resumeToken": lastEventID,
+	})
+	if err != nil {
+		return err
+	}
+	return sse.NewEncoder(w).Encode(sse.Event{Type: "reconnect", ID: lastEventID, Data: string(payload)})
+}
+
+// websocketLifetimeMiddleware closes upgraded connections once the configured
+// stream lifetime elapses, sending a close frame so clients reconnect and
+// re-authenticate instead of treating the disconnect as an error.
+func websocketLifetimeMiddleware(lifetime streamLifetime, next http.Handler) http.Handler {
+	if !lifetime.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw := &lifetimeResponseWriter{ResponseWriter: w, lifetime: lifetime.next()}
+		defer lw.stop()
+		next.ServeHTTP(lw, r)
+	})
+}
+
+type lifetimeResponseWriter struct {
+	http.ResponseWriter
+	lifetime time.Duration
+	conn     *lifetimeConn
+}
+
+func (lw *lifetimeResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// Hijack wraps the client connection so the lifetime timer starts once the
+// upgrade is handed off to the proxy.
+func (lw *lifetimeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(lw.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	lw.conn = newLifetimeConn(conn, lw.lifetime)
+	return lw.conn, brw, nil
+}
+
+func (lw *lifetimeResponseWriter) stop() {
+	if lw.conn != nil {
+		lw.conn.stop()
+	}
+}
+
+// lifetimeConn serialises writes so the close frame is never interleaved with
+// a proxied write. Frames larger than the proxy copy buffer may still be cut
+// short, which clients surface as a protocol error and reconnect all the same.
+type lifetimeConn struct {
+	net.Conn
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+func newLifetimeConn(conn net.Conn, lifetime time.Duration) *lifetimeConn {
+	c := &lifetimeConn{Conn: conn}
+	c.timer = time.AfterFunc(lifetime, c.expire)
+	return c
+}
+
+func (c *lifetimeConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *lifetimeConn) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = c.Conn.Write(websocketCloseFrame(websocketCloseGoingAway, streamRenewalReason))
+	_ = c.Conn.Close()
+}
+
+func (c *lifetimeConn) stop() {
+	c.timer.Stop()
+}
+
+// websocketCloseFrame builds an unmasked server-to-client close frame.
+func websocketCloseFrame(code uint16, reason string) []byte {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	frame := make([]byte, 4, 4+len(reason))
+	frame[0] = 0x88
+	frame[1] = byte(2 + len(reason))
+	binary.BigEndian.PutUint16(frame[2:], code)
+	return append(frame, reason...)
+}
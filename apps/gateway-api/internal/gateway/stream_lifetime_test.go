@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadStreamLifetime(t *testing.T) {
+	if lifetime := loadStreamLifetime("GATEWAY_SSE_MAX_STREAM_AGE", "GATEWAY_SSE_MAX_STREAM_AGE_JITTER"); lifetime.enabled() {
+		t.Fatalf("expected renewal to be disabled by default, got %+v", lifetime)
+	}
+
+	t.Setenv("GATEWAY_MAX_STREAM_AGE", "1h")
+	lifetime := loadStreamLifetime("GATEWAY_SSE_MAX_STREAM_AGE", "GATEWAY_SSE_MAX_STREAM_AGE_JITTER")
+	if lifetime.MaxAge != time.Hour || lifetime.Jitter != 6*time.Minute {
+		t.Fatalf("expected shared max age with 10%% jitter, got %+v", lifetime)
+	}
+
+	t.Setenv("GATEWAY_SSE_MAX_STREAM_AGE", "10m")
+	t.Setenv("GATEWAY_SSE_MAX_STREAM_AGE_JITTER", "30m")
+	lifetime = loadStreamLifetime("GATEWAY_SSE_MAX_STREAM_AGE", "GATEWAY_SSE_MAX_STREAM_AGE_JITTER")
+	if lifetime.MaxAge != 10*time.Minute || lifetime.Jitter != 5*time.Minute {
+		t.Fatalf("expected endpoint override with clamped jitter, got %+v", lifetime)
+	}
+}
+
+func TestStreamLifetimeNextStaysWithinJitter(t *testing.T) {
+	lifetime := streamLifetime{MaxAge: time.Minute, Jitter: 10 * time.Second}
+	for i := 0; i < 100; i++ {
+		got := lifetime.next()
+		if got > time.Minute || got <= 50*time.Second {
+			t.Fatalf("lifetime %s outside (50s, 1m]", got)
+		}
+	}
+}
+
+func TestSSEEventIDTrackerCommitsIDOnDispatch(t *testing.T) {
+	tracker := newSSEEventIDTracker("initial")
+	tracker.observe([]byte("id: 4"))
+	tracker.observe([]byte("2\r\ndata: x\r\n"))
+	if tracker.lastID != "initial" || tracker.atBoundary() {
+		t.Fatalf("expected undispatched event, got id %q boundary %v", tracker.lastID, tracker.atBoundary())
+	}
+	tracker.observe([]byte("\r\n"))
+	if tracker.lastID != "42" || !tracker.atBoundary() {
+		t.Fatalf("expected dispatched id 42 at boundary, got id %q boundary %v", tracker.lastID, tracker.atBoundary())
+	}
+	tracker.observe([]byte(": ping\n\ndata: partial"))
+	if tracker.lastID != "42" || tracker.atBoundary() {
+		t.Fatalf("expected partial event to keep id 42, got id %q boundary %v", tracker.lastID, tracker.atBoundary())
+	}
+}
+
+func newRenewingEventsHandler(t *testing.T, upstream string) *EventsHandler {
+	t.Helper()
+	reader, writer := io.Pipe()
+	t.Cleanup(func() { _ = writer.Close() })
+	go func() {
+		_, _ = writer.Write([]byte(upstream))
+	}()
+	client := &http.Client{Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body:       reader,
+		}, nil
+	})}
+	handler := NewEventsHandler(client, "http://orchestrator", time.Minute, nil, nil)
+	handler.lifetime = streamLifetime{MaxAge: 50 * time.Millisecond}
+	return handler
+}
+
+func TestEventsHandlerEmitsReconnectEventAtMaxAge(t *testing.T) {
+	handler := newRenewingEventsHandler(t, "id: 7\nevent: plan.step\ndata: {}\n\n")
+
+	req := httptest.NewRequest(http.MethodGet, "/events?plan_id="+validPlanID, nil)
+	rec := newFlushingRecorder()
+	handler.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.HasSuffix(body, "event: reconnect\nid: 7\ndata: {\"reason\":\"max_stream_age\",\"resumeToken\":\"7\"}\n\n") {
+		t.Fatalf("expected reconnect event with resume token, got %q", body)
+	}
+}
+
+func TestEventsHandlerEndsStreamMidEventWithoutReconnectEvent(t *testing.T) {
+	handler := newRenewingEventsHandler(t, "id: 7\ndata: partial")
+
+	req := httptest.NewRequest(http.MethodGet, "/events?plan_id="+validPlanID, nil)
+	rec := newFlushingRecorder()
+	handler.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if strings.Contains(body, "event: reconnect") {
+		t.Fatalf("expected reconnect event to be withheld mid-event, got %q", body)
+	}
+	if body != "id: 7\ndata: partial" {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
+
+func TestWebsocketCloseFrame(t *testing.T) {
+	frame := websocketCloseFrame(websocketCloseGoingAway, streamRenewalReason)
+	want := append([]byte{0x88, byte(2 + len(streamRenewalReason)), 0x03, 0xe9}, streamRenewalReason...)
+	if string(frame) != string(want) {
+		t.Fatalf("unexpected close frame % x", frame)
+	}
+}
@@ -0,0 +1,257 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultTenantConfigReloadInterval = 30 * time.Second
+
+var tenantFeaturePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// TenantSettings are the per-tenant overrides of gateway behaviour read from
+// GATEWAY_TENANT_CONFIG_FILE. Unset fields inherit from the file's defaults
+// and then from the global environment configuration, which the feature
+// reading the field applies.
+type TenantSettings struct {
+	TenantID      string                      `json:"-"`
+	Features      map[string]bool             `json:"features,omitempty"`
+	Collaboration TenantCollaborationSettings `json:"collaboration"`
+	Auth          TenantAuthSettings          `json:"auth"`
+}
+
+// TenantCollaborationSettings override the collaboration proxy for a tenant.
+type TenantCollaborationSettings struct {
+	// ReadOnly replaces GATEWAY_COLLAB_READ_ONLY* for the tenant. A runtime
+	// override set through the admin API still takes precedence.
+	ReadOnly *bool `json:"read_only,omitempty"`
+}
+
+// TenantAuthSettings override the sign-in flow for a tenant.
+type TenantAuthSettings struct {
+	// DeviceCookieTTL replaces GATEWAY_DEVICE_COOKIE_TTL, as a duration such
+	// as "720h"; "0s" stops issuing device cookies to the tenant's users.
+	DeviceCookieTTL string `json:"device_cookie_ttl,omitempty"`
+	// VerifyNewDevices replaces GATEWAY_DEVICE_VERIFY_NEW.
+	VerifyNewDevices *bool `json:"verify_new_devices,omitempty"`
+	// DebugCapture keeps traces of the tenant's sign-ins for the admin API
+	// while GATEWAY_AUTH_DEBUG_CAPTURE is on.
+	DebugCapture *bool `json:"debug_capture,omitempty"`
+}
+
+// FeatureEnabled reports whether the named feature flag is on for the tenant.
+func (s TenantSettings) FeatureEnabled(name string) bool {
+	return s.Features[name]
+}
+
+// merge layers s over base: set fields of s win and feature flags are
+// combined, with s deciding flags both define.
+func (s TenantSettings) merge(base TenantSettings) TenantSettings {
+	merged := base
+	merged.TenantID = s.TenantID
+	if len(base.Features) > 0 || len(s.Features) > 0 {
+		merged.Features = make(map[string]bool, len(base.Features)+len(s.Features))
+		maps.Copy(merged.Features, base.Features)
+		maps.Copy(merged.Features, s.Features)
+	}
+	if s.Collaboration.ReadOnly != nil {
+		merged.Collaboration.ReadOnly = s.Collaboration.ReadOnly
+	}
+	if s.Auth.DeviceCookieTTL != "" {
+		merged.Auth.DeviceCookieTTL = s.Auth.DeviceCookieTTL
+	}
+	if s.Auth.VerifyNewDevices != nil {
+		merged.Auth.VerifyNewDevices = s.Auth.VerifyNewDevices
+	}
+	if s.Auth.DebugCapture != nil {
+		merged.Auth.DebugCapture = s.Auth.DebugCapture
+	}
+	return merged
+}
+
+// tenantConfigDocument is the schema of GATEWAY_TENANT_CONFIG_FILE.
+type tenantConfigDocument struct {
+	Defaults TenantSettings            `json:"defaults"`
+	Tenants  map[string]TenantSettings `json:"tenants"`
+}
+
+func parseTenantConfig(data []byte) (*tenantConfigDocument, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var doc tenantConfigDocument
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid tenant configuration: %w", err)
+	}
+	if err := validateTenantSettings(doc.Defaults); err != nil {
+		return nil, fmt.Errorf("defaults: %w", err)
+	}
+	tenants := make(map[string]TenantSettings, len(doc.Tenants))
+	for rawID, settings := range doc.Tenants {
+		tenantID, err := normalizeTenantID(rawID)
+		if err != nil || tenantID == "" {
+			return nil, fmt.Errorf("tenant %q: invalid tenant id", rawID)
+		}
+		if err := validateTenantSettings(settings); err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", rawID, err)
+		}
+		key := normalizeTenantKey(tenantID)
+		if _, exists := tenants[key]; exists {
+			return nil, fmt.Errorf("tenant %q: duplicate entry", rawID)
+		}
+		tenants[key] = settings
+	}
+	doc.Tenants = tenants
+	return &doc, nil
+}
+
+func validateTenantSettings(settings TenantSettings) error {
+	for name := range settings.Features {
+		if !tenantFeaturePattern.MatchString(name) {
+			return fmt.Errorf("invalid feature name %q", name)
+		}
+	}
+	if raw := settings.Auth.DeviceCookieTTL; raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil || ttl < 0 || ttl > maxDeviceCookieTTL {
+			return fmt.Errorf("auth.device_cookie_ttl must be a duration between 0s and %s", maxDeviceCookieTTL)
+		}
+	}
+	return nil
+}
+
+// tenantConfig holds the last valid GATEWAY_TENANT_CONFIG_FILE. A reload
+// that fails keeps serving the previous document.
+type tenantConfig struct {
+	path string
+
+	mu          sync.RWMutex
+	doc         *tenantConfigDocument
+	fingerprint [sha256.Size]byte
+}
+
+// settings resolves a tenant's effective overrides: the tenant's entry over
+// the file's defaults.
+func (c *tenantConfig) settings(tenantID string) TenantSettings {
+	c.mu.RLock()
+	doc := c.doc
+	c.mu.RUnlock()
+	resolved := TenantSettings{TenantID: tenantID}
+	if doc == nil {
+		return resolved
+	}
+	resolved = resolved.merge(doc.Defaults)
+	if entry, ok := doc.Tenants[normalizeTenantKey(tenantID)]; ok && tenantID != "" {
+		entry.TenantID = tenantID
+		resolved = entry.merge(resolved)
+	}
+	return resolved
+}
+
+// reload reads the file again and reports whether its contents changed.
+func (c *tenantConfig) reload() (bool, error) {
+	data, err := ReadSecretFile(c.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read GATEWAY_TENANT_CONFIG_FILE: %w", err)
+	}
+	fingerprint := sha256.Sum256(data)
+	c.mu.RLock()
+	unchanged := c.doc != nil && fingerprint == c.fingerprint
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	doc, err := parseTenantConfig(data)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	c.doc = doc
+	c.fingerprint = fingerprint
+	c.mu.Unlock()
+	return true, nil
+}
+
+var (
+	tenantConfigMu   sync.Mutex
+	tenantConfigOnce sync.Once
+	sharedTenants    *tenantConfig
+	sharedTenantsErr error
+)
+
+// resetTenantConfig clears the cached configuration for tests.
+func resetTenantConfig() {
+	tenantConfigMu.Lock()
+	defer tenantConfigMu.Unlock()
+	tenantConfigOnce = sync.Once{}
+	sharedTenants = nil
+	sharedTenantsErr = nil
+}
+
+// loadTenantConfig returns the per-tenant configuration, or nil when
+// GATEWAY_TENANT_CONFIG_FILE is unset.
+func loadTenantConfig() (*tenantConfig, error) {
+	tenantConfigMu.Lock()
+	defer tenantConfigMu.Unlock()
+	tenantConfigOnce.Do(func() {
+		path := strings.TrimSpace(os.Getenv("GATEWAY_TENANT_CONFIG_FILE"))
+		if path == "" {
+			return
+		}
+		config := &tenantConfig{path: path}
+		if _, err := config.reload(); err != nil {
+			sharedTenantsErr = err
+			return
+		}
+		sharedTenants = config
+	})
+	return sharedTenants, sharedTenantsErr
+}
+
+// ValidateTenantConfig checks GATEWAY_TENANT_CONFIG_FILE at startup.
+func ValidateTenantConfig() error {
+	_, err := loadTenantConfig()
+	return err
+}
+
+// reloadTenantConfig picks up edits to GATEWAY_TENANT_CONFIG_FILE. It runs as
+// a maintenance job every GATEWAY_TENANT_CONFIG_RELOAD_INTERVAL.
+func reloadTenantConfig(ctx context.Context) error {
+	config, err := loadTenantConfig()
+	if err != nil || config == nil {
+		return err
+	}
+	changed, err := config.reload()
+	if err != nil {
+		return fmt.Errorf("keeping the previous tenant configuration: %w", err)
+	}
+	if changed {
+		slog.InfoContext(ctx, "tenant configuration reloaded", slog.String("path", config.path))
+	}
+	return nil
+}
+
+// TenantConfig returns the settings of the tenant in the request scope of
+// ctx. Without a tenant or a configuration file every field is unset, so
+// callers fall back to the global configuration.
+func TenantConfig(ctx context.Context) TenantSettings {
+	tenantID := requestScopeFrom(ctx).TenantID
+	config, err := loadTenantConfig()
+	if err != nil {
+		slog.WarnContext(ctx, "tenant configuration unavailable; using global configuration", slog.Any("error", err))
+		return TenantSettings{TenantID: tenantID}
+	}
+	if config == nil {
+		return TenantSettings{TenantID: tenantID}
+	}
+	return config.settings(tenantID)
+}
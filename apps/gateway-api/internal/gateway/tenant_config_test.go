@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func useTenantConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("write tenant config: %v", err)
+	}
+	t.Setenv("GATEWAY_TENANT_CONFIG_FILE", path)
+	resetTenantConfig()
+	t.Cleanup(resetTenantConfig)
+	return path
+}
+
+func TestParseTenantConfig(t *testing.T) {
+	if _, err := parseTenantConfig([]byte(`{"defaults":{"features":{"beta.search":true}},"tenants":{"acme":{"collaboration":{"read_only":true}}}}`)); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for name, raw := range map[string]string{
+		"unknown field":   `{"tenants":{"acme":{"cookie_policy":"strict"}}}`,
+		"invalid tenant":  `{"tenants":{"bad tenant":{}}}`,
+		"duplicate":       `{"tenants":{"acme":{},"ACME":{}}}`,
+		"invalid feature": `{"defaults":{"features":{"Beta Search":true}}}`,
+		"wrong type":      `{"tenants":{"acme":{"collaboration":{"read_only":"yes"}}}}`,
+		"device ttl":      `{"defaults":{"auth":{"device_cookie_ttl":"-1h"}}}`,
+	} {
+		if _, err := parseTenantConfig([]byte(raw)); err == nil {
+			t.Fatalf("%s: expected %s to be rejected", name, raw)
+		}
+	}
+}
+
+func TestTenantConfigPrecedence(t *testing.T) {
+	useTenantConfig(t, `{
+		"defaults": {"features": {"beta.search": true, "exports": false}, "collaboration": {"read_only": false}},
+		"tenants": {"Acme": {"features": {"exports": true}, "collaboration": {"read_only": true}}}
+	}`)
+
+	acme := TenantConfig(withRequestScope(context.Background(), requestScope{TenantID: "acme"}))
+	if acme.TenantID != "acme" || !acme.FeatureEnabled("beta.search") || !acme.FeatureEnabled("exports") {
+		t.Fatalf("expected the tenant entry over the defaults, got %+v", acme)
+	}
+	if acme.Collaboration.ReadOnly == nil || !*acme.Collaboration.ReadOnly {
+		t.Fatalf("expected acme to be read-only, got %+v", acme.Collaboration)
+	}
+	globex := TenantConfig(withRequestScope(context.Background(), requestScope{TenantID: "globex"}))
+	if globex.FeatureEnabled("exports") || globex.Collaboration.ReadOnly == nil || *globex.Collaboration.ReadOnly {
+		t.Fatalf("expected other tenants to get the defaults, got %+v", globex)
+	}
+
+	t.Setenv("GATEWAY_TENANT_CONFIG_FILE", "")
+	resetTenantConfig()
+	if unset := TenantConfig(withRequestScope(context.Background(), requestScope{TenantID: "acme"})); unset.Collaboration.ReadOnly != nil || unset.FeatureEnabled("beta.search") {
+		t.Fatalf("expected no overrides without a file, got %+v", unset)
+	}
+}
+
+func TestReloadTenantConfigKeepsLastValidDocument(t *testing.T) {
+	path := useTenantConfig(t, `{"tenants":{"acme":{"features":{"exports":true}}}}`)
+	ctx := withRequestScope(context.Background(), requestScope{TenantID: "acme"})
+	if err := ValidateTenantConfig(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"tenants":{"acme":{"features":{"exports":false}}}}`), 0o600); err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if err := reloadTenantConfig(context.Background()); err != nil || TenantConfig(ctx).FeatureEnabled("exports") {
+		t.Fatalf("expected the edit to be picked up, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"tenants":`), 0o600); err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if err := reloadTenantConfig(context.Background()); err == nil {
+		t.Fatal("expected an invalid edit to fail the reload")
+	}
+	if settings := TenantConfig(ctx); settings.Features == nil || settings.FeatureEnabled("exports") {
+		t.Fatalf("expected the previous document to stay in use, got %+v", settings)
+	}
+
+	useTenantConfig(t, `{"tenants":{"acme":{"unknown":true}}}`)
+	if err := ValidateTenantConfig(); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Fatalf("expected startup validation to fail, got %v", err)
+	}
+}
+
+func TestCollaborationReadOnlyHonoursTenantConfig(t *testing.T) {
+	readOnly := useCollaborationReadOnly(t, "true", "")
+	useTenantConfig(t, `{"tenants":{"acme":{"collaboration":{"read_only":false}},"globex":{"collaboration":{"read_only":true}}}}`)
+	var forwarded string
+	handler := collaborationReadOnlyMiddleware(readOnly, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(collaborationReadOnlyHeader)
+	}))
+	serve := func(tenant string) string {
+		forwarded = ""
+		req := httptest.NewRequest(http.MethodGet, "/collaboration/ws?filePath=docs/a.md", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(withRequestScope(req.Context(), requestScope{TenantID: tenant})))
+		return forwarded
+	}
+
+	if got := serve("acme"); got != "false" {
+		t.Fatalf("expected the tenant setting to override the global switch, got %q", got)
+	}
+	if got := serve("initech"); got != "true" {
+		t.Fatalf("expected unlisted tenants to follow the global switch, got %q", got)
+	}
+
+	if _, err := readOnly.override(context.Background(), false, nil); err != nil {
+		t.Fatalf("override: %v", err)
+	}
+	if got := serve("globex"); got != "false" {
+		t.Fatalf("expected the admin override to win over the tenant setting, got %q", got)
+	}
+}
@@ -0,0 +1,371 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	upstreamAPIv1   = "v1"
+	upstreamAPIv2   = "v2"
+	upstreamAPIAuto = "auto"
+
+	// upstreamAPIVersionsPath is where an upstream lists the API versions it
+	// serves, as {"versions": ["v1", "v2"]}. Upstreams that answer 404 predate
+	// versioning and serve v1.
+	upstreamAPIVersionsPath    = "/api-versions"
+	upstreamAPIProbeTimeout    = 5 * time.Second
+	upstreamAPIProbeInterval   = 30 * time.Second
+	maxUpstreamAPIVersionsBody = 64 << 10
+)
+
+// Orchestrator endpoints the gateway calls, named independently of the path
+// that serves them in a given API version.
+const (
+	orchestratorAuthCallback           = "auth.callback"
+	orchestratorAuthSession            = "auth.session"
+	orchestratorAuthAssertion          = "auth.assertion"
+	orchestratorProvisioningEvents     = "provisioning.events"
+	orchestratorPlanEvents             = "plan.events"
+	orchestratorPlanOwner              = "plan.owner"
+	orchestratorPlanArtifact           = "plan.artifact"
+	orchestratorPlanAttachments        = "plan.attachments"
+	orchestratorCollaborationWebSocket = "collaboration.ws"
+	orchestratorCollaborationAuthorize = "collaboration.authorize"
+)
+
+var orchestratorPathsV1 = map[string]string{
+	orchestratorAuthCallback:           "/auth/{provider}/callback",
+	orchestratorAuthSession:            "/auth/session",
+	orchestratorAuthAssertion:          "/auth/assertion",
+	orchestratorProvisioningEvents:     "/provisioning/events",
+	orchestratorPlanEvents:             "/plan/{plan_id}/events",
+	orchestratorPlanOwner:              defaultPlanOwnerPath,
+	orchestratorPlanArtifact:           "/plan/{plan_id}/artifacts/{artifact_id}",
+	orchestratorPlanAttachments:        "/plan/{plan_id}/attachments",
+	orchestratorCollaborationWebSocket: "/collaboration/ws",
+	orchestratorCollaborationAuthorize: defaultCollaborationAuthzPath,
+}
+
+var orchestratorAPI = &upstreamAPI{
+	name:       "orchestrator",
+	versionKey: "ORCHESTRATOR_API_VERSION",
+	pathsKey:   "ORCHESTRATOR_API_PATHS",
+	templates: map[string]map[string]string{
+		upstreamAPIv1: orchestratorPathsV1,
+		upstreamAPIv2: prefixedAPIPaths("/v2", orchestratorPathsV1),
+	},
+	client: getOrchestratorClient,
+}
+
+var apiPathPlaceholder = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// prefixedAPIPaths returns templates with prefix in front of every path.
+func prefixedAPIPaths(prefix string, templates map[string]string) map[string]string {
+	prefixed := make(map[string]string, len(templates))
+	for endpoint, template := range templates {
+		prefixed[endpoint] = prefix + template
+	}
+	return prefixed
+}
+
+// upstreamAPIConfig is the resolved <SERVICE>_API_VERSION and
+// <SERVICE>_API_PATHS of one upstream service.
+type upstreamAPIConfig struct {
+	version   string
+	overrides map[string]string
+}
+
+// upstreamAPIDetection is what the versions probe found on one base URL.
+type upstreamAPIDetection struct {
+	version  string
+	detected bool
+	probedAt time.Time
+}
+
+// upstreamAPI maps the endpoints the gateway calls on an upstream service to
+// paths for the API version the service serves. The version is pinned with
+// <SERVICE>_API_VERSION or, with auto, detected per base URL from
+// upstreamAPIVersionsPath; until detection succeeds v1 is assumed and the
+// probe is retried at most every upstreamAPIProbeInterval. Individual paths
+// can be replaced with <SERVICE>_API_PATHS, a JSON object of endpoint name to
+// path template.
+type upstreamAPI struct {
+	name       string
+	versionKey string
+	pathsKey   string
+	templates  map[string]map[string]string
+	client     func() (*http.Client, error)
+
+	configOnce sync.Once
+	config     upstreamAPIConfig
+	configErr  error
+
+	mu         sync.Mutex
+	detections map[string]upstreamAPIDetection
+	now        func() time.Time
+}
+
+func (a *upstreamAPI) loadConfig() (upstreamAPIConfig, error) {
+	a.configOnce.Do(func() {
+		a.config, a.configErr = a.parseConfig()
+	})
+	return a.config, a.configErr
+}
+
+func (a *upstreamAPI) parseConfig() (upstreamAPIConfig, error) {
+	cfg := upstreamAPIConfig{version: strings.ToLower(strings.TrimSpace(GetEnv(a.versionKey, upstreamAPIv1)))}
+	if _, ok := a.templates[cfg.version]; !ok && cfg.version != upstreamAPIAuto {
+		return cfg, fmt.Errorf("%s must be auto or one of %s", a.versionKey, strings.Join(slices.Sorted(maps.Keys(a.templates)), ", "))
+	}
+	raw := strings.TrimSpace(GetEnv(a.pathsKey, ""))
+	if raw == "" {
+		return cfg, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg.overrides); err != nil {
+		return cfg, fmt.Errorf("invalid %s: %w", a.pathsKey, err)
+	}
+	base := a.templates[upstreamAPIv1]
+	for endpoint, template := range cfg.overrides {
+		original, ok := base[endpoint]
+		if !ok {
+			return cfg, fmt.Errorf("%s: unknown endpoint %q", a.pathsKey, endpoint)
+		}
+		if !strings.HasPrefix(template, "/") || strings.ContainsAny(template, "?#") {
+			return cfg, fmt.Errorf("%s: %s must be a path starting with /", a.pathsKey, endpoint)
+		}
+		if !slices.Equal(apiPathParams(template), apiPathParams(original)) {
+			return cfg, fmt.Errorf("%s: %s must use the placeholders %v", a.pathsKey, endpoint, apiPathParams(original))
+		}
+	}
+	return cfg, nil
+}
+
+func apiPathParams(template string) []string {
+	params := apiPathPlaceholder.FindAllString(template, -1)
+	slices.Sort(params)
+	return slices.Compact(params)
+}
+
+// reset forgets the configuration and detected versions for tests.
+func (a *upstreamAPI) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.configOnce = sync.Once{}
+	a.config = upstreamAPIConfig{}
+	a.configErr = nil
+	a.detections = nil
+}
+
+// URL returns baseURL joined with the path of endpoint in the API version
+// baseURL serves. params are placeholder name and value pairs; values are
+// path-escaped.
+func (a *upstreamAPI) URL(ctx context.Context, baseURL, endpoint string, params ...string) string {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return baseURL + a.path(ctx, baseURL, endpoint, params...)
+}
+
+func (a *upstreamAPI) path(ctx context.Context, baseURL, endpoint string, params ...string) string {
+	cfg, err := a.loadConfig()
+	template, ok := cfg.overrides[endpoint]
+	if !ok {
+		version := upstreamAPIv1
+		if err == nil {
+			version = a.version(ctx, cfg, baseURL)
+		}
+		template = a.templates[version][endpoint]
+	}
+	for i := 0; i+1 < len(params); i += 2 {
+		template = strings.ReplaceAll(template, "{"+params[i]+"}", url.PathEscape(params[i+1]))
+	}
+	return template
+}
+
+// version returns the API version to use for baseURL, probing it when the
+// version is auto and nothing has been detected yet.
+func (a *upstreamAPI) version(ctx context.Context, cfg upstreamAPIConfig, baseURL string) string {
+	if cfg.version != upstreamAPIAuto {
+		return cfg.version
+	}
+	a.mu.Lock()
+	detection, ok := a.detections[baseURL]
+	if ok && (detection.detected || a.clock().Sub(detection.probedAt) < upstreamAPIProbeInterval) {
+		a.mu.Unlock()
+		return detection.version
+	}
+	// Claim the probe so concurrent requests keep assuming v1 instead of
+	// probing as well.
+	a.store(baseURL, upstreamAPIDetection{version: upstreamAPIv1, probedAt: a.clock()})
+	a.mu.Unlock()
+	return a.detect(ctx, baseURL)
+}
+
+// detect probes baseURL and records the version it serves. When the probe
+// fails the version detected before, or v1, stays in use.
+func (a *upstreamAPI) detect(ctx context.Context, baseURL string) string {
+	versions, err := a.probe(ctx, baseURL)
+	version := ""
+	if err == nil {
+		version, err = a.newest(versions)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	previous, ok := a.detections[baseURL]
+	if !ok {
+		previous.version = upstreamAPIv1
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "gateway.upstream.api_version_probe_failed",
+			slog.String("upstream", a.name),
+			slog.String("base_url", baseURL),
+			slog.Any("error", err))
+		previous.probedAt = a.clock()
+		a.store(baseURL, previous)
+		return previous.version
+	}
+	a.store(baseURL, upstreamAPIDetection{version: version, detected: true, probedAt: a.clock()})
+	if !previous.detected || previous.version != version {
+		slog.InfoContext(ctx, "gateway.upstream.api_version_detected",
+			slog.String("upstream", a.name),
+			slog.String("base_url", baseURL),
+			slog.String("version", version))
+	}
+	return version
+}
+
+// refresh re-probes baseURL with <SERVICE>_API_VERSION=auto so an
+// upstream upgraded in place is picked up without a restart.
+func (a *upstreamAPI) refresh(ctx context.Context, baseURL string) {
+	if cfg, err := a.loadConfig(); err != nil || cfg.version != upstreamAPIAuto {
+		return
+	}
+	a.detect(ctx, strings.TrimRight(baseURL, "/"))
+}
+
+func (a *upstreamAPI) store(baseURL string, detection upstreamAPIDetection) {
+	if a.detections == nil {
+		a.detections = make(map[string]upstreamAPIDetection)
+	}
+	a.detections[baseURL] = detection
+}
+
+func (a *upstreamAPI) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+// probe asks baseURL which API versions it serves.
+func (a *upstreamAPI) probe(ctx context.Context, baseURL string) ([]string, error) {
+	client, err := a.client()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, upstreamAPIProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+upstreamAPIVersionsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return []string{upstreamAPIv1}, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s returned %d", upstreamAPIVersionsPath, resp.StatusCode)
+	}
+	var payload struct {
+		Versions []string `json:"versions"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxUpstreamAPIVersionsBody)).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("invalid %s response: %w", upstreamAPIVersionsPath, err)
+	}
+	return payload.Versions, nil
+}
+
+// newest picks the newest of versions the gateway has paths for.
+func (a *upstreamAPI) newest(versions []string) (string, error) {
+	for _, version := range slices.Backward(slices.Sorted(maps.Keys(a.templates))) {
+		if slices.Contains(versions, version) {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("no supported API version in %v", versions)
+}
+
+// currentVersion reports the version in use for baseURL without probing, for
+// the admin API.
+func (a *upstreamAPI) currentVersion(baseURL string) string {
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return ""
+	}
+	if cfg.version != upstreamAPIAuto {
+		return cfg.version
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if detection, ok := a.detections[strings.TrimRight(baseURL, "/")]; ok && detection.detected {
+		return detection.version
+	}
+	return ""
+}
+
+// ValidateUpstreamAPIConfig checks ORCHESTRATOR_API_VERSION and
+// ORCHESTRATOR_API_PATHS at startup.
+func ValidateUpstreamAPIConfig() error {
+	_, err := orchestratorAPI.loadConfig()
+	return err
+}
+
+// DetectUpstreamAPIVersions probes the orchestrator at startup. With
+// ORCHESTRATOR_API_VERSION=auto it records the version to use; with v2
+// pinned it warns when the orchestrator does not list it. A failed probe is
+// not fatal: auto falls back to v1 and probes again on later requests.
+func DetectUpstreamAPIVersions(ctx context.Context) error {
+	cfg, err := orchestratorAPI.loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.version == upstreamAPIv1 {
+		return nil
+	}
+	baseURL := orchestratorBaseURL()
+	if cfg.version == upstreamAPIAuto {
+		orchestratorAPI.detect(ctx, baseURL)
+		return nil
+	}
+	versions, err := orchestratorAPI.probe(ctx, baseURL)
+	switch {
+	case err != nil:
+		slog.WarnContext(ctx, "gateway.upstream.api_version_probe_failed",
+			slog.String("upstream", orchestratorAPI.name),
+			slog.String("base_url", baseURL),
+			slog.Any("error", err))
+	case !slices.Contains(versions, cfg.version):
+		slog.WarnContext(ctx, "gateway.upstream.api_version_unsupported",
+			slog.String("upstream", orchestratorAPI.name),
+			slog.String("configured", cfg.version),
+			slog.String("served", strings.Join(versions, ",")))
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func useOrchestratorAPI(t *testing.T, env map[string]string) {
+	t.Helper()
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	orchestratorAPI.reset()
+	t.Cleanup(orchestratorAPI.reset)
+}
+
+func TestUpstreamAPIConfigValidation(t *testing.T) {
+	cases := map[string]map[string]string{
+		"unknown version":       {"ORCHESTRATOR_API_VERSION": "v3"},
+		"invalid paths":         {"ORCHESTRATOR_API_PATHS": "[]"},
+		"unknown endpoint":      {"ORCHESTRATOR_API_PATHS": `{"plan.delete":"/plans/{plan_id}"}`},
+		"relative path":         {"ORCHESTRATOR_API_PATHS": `{"auth.session":"session"}`},
+		"missing placeholder":   {"ORCHESTRATOR_API_PATHS": `{"plan.events":"/plans/events"}`},
+		"unknown placeholder":   {"ORCHESTRATOR_API_PATHS": `{"auth.session":"/sessions/{session_id}"}`},
+		"query in the template": {"ORCHESTRATOR_API_PATHS": `{"auth.session":"/session?current=1"}`},
+	}
+	for name, env := range cases {
+		t.Run(name, func(t *testing.T) {
+			useOrchestratorAPI(t, env)
+			if err := ValidateUpstreamAPIConfig(); err == nil {
+				t.Fatalf("expected %v to be rejected", env)
+			}
+		})
+	}
+	useOrchestratorAPI(t, map[string]string{"ORCHESTRATOR_API_VERSION": "AUTO"})
+	if err := ValidateUpstreamAPIConfig(); err != nil {
+		t.Fatalf("expected auto to be accepted: %v", err)
+	}
+}
+
+func TestUpstreamAPIURLFollowsVersionAndOverrides(t *testing.T) {
+	ctx := context.Background()
+	useOrchestratorAPI(t, nil)
+	if got := orchestratorAPI.URL(ctx, "http://orchestrator/", orchestratorPlanArtifact, "plan_id", "p 1", "artifact_id", "a/b"); got != "http://orchestrator/plan/p%201/artifacts/a%2Fb" {
+		t.Fatalf("unexpected v1 URL %s", got)
+	}
+
+	useOrchestratorAPI(t, map[string]string{
+		"ORCHESTRATOR_API_VERSION": "v2",
+		"ORCHESTRATOR_API_PATHS":   `{"plan.events":"/streams/plans/{plan_id}"}`,
+	})
+	if got := orchestratorAPI.URL(ctx, "http://orchestrator", orchestratorAuthCallback, "provider", "github"); got != "http://orchestrator/v2/auth/github/callback" {
+		t.Fatalf("unexpected v2 URL %s", got)
+	}
+	if got := orchestratorAPI.URL(ctx, "http://orchestrator", orchestratorPlanEvents, "plan_id", "p-1"); got != "http://orchestrator/streams/plans/p-1" {
+		t.Fatalf("expected the override to replace the template, got %s", got)
+	}
+}
+
+func TestUpstreamAPIDetectsVersion(t *testing.T) {
+	var versions atomic.Value
+	versions.Store(`{"versions":["v1","v2","v9"]}`)
+	var probes atomic.Int32
+	var sessionPath atomic.Value
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == upstreamAPIVersionsPath {
+			probes.Add(1)
+			body := versions.Load().(string)
+			if body == "" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(body))
+			return
+		}
+		sessionPath.Store(r.URL.Path)
+		_, _ = w.Write([]byte(`{"session":{"id":"s-1"}}`))
+	}))
+	defer orchestrator.Close()
+	t.Setenv("ORCHESTRATOR_URL", orchestrator.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+	useOrchestratorAPI(t, map[string]string{"ORCHESTRATOR_API_VERSION": "auto"})
+
+	if err := DetectUpstreamAPIVersions(context.Background()); err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if _, _, err := lookupOrchestratorSession(context.Background(), "Bearer token", "", ""); err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if got := sessionPath.Load(); got != "/v2/auth/session" || probes.Load() != 1 {
+		t.Fatalf("expected the detected v2 path after one probe, got %v (probes=%d)", got, probes.Load())
+	}
+	if got := orchestratorAPI.currentVersion(orchestrator.URL); got != upstreamAPIv2 {
+		t.Fatalf("expected the admin view to report v2, got %q", got)
+	}
+
+	// A failed refresh keeps the detected version; a successful one follows
+	// an orchestrator that changed in place.
+	versions.Store("")
+	orchestratorAPI.refresh(context.Background(), orchestrator.URL)
+	if got := orchestratorAPI.currentVersion(orchestrator.URL); got != upstreamAPIv2 {
+		t.Fatalf("expected a failed probe to keep v2, got %q", got)
+	}
+	versions.Store(`{"versions":["v1"]}`)
+	if err := refreshUpstreamClients(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if got := orchestratorAPI.currentVersion(orchestrator.URL); got != upstreamAPIv1 {
+		t.Fatalf("expected the refresh to switch to v1, got %q", got)
+	}
+}
+
+func TestUpstreamAPIFallsBackToV1UntilDetected(t *testing.T) {
+	var probes atomic.Int32
+	var fail atomic.Bool
+	fail.Store(true)
+	orchestrator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer orchestrator.Close()
+	t.Setenv("ORCHESTRATOR_URL", orchestrator.URL)
+	ResetOrchestratorClient()
+	t.Cleanup(ResetOrchestratorClient)
+	useOrchestratorAPI(t, map[string]string{"ORCHESTRATOR_API_VERSION": "auto"})
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	orchestratorAPI.now = func() time.Time { return now }
+	t.Cleanup(func() { orchestratorAPI.now = nil })
+
+	ctx := context.Background()
+	for range 2 {
+		if got := orchestratorAPI.URL(ctx, orchestrator.URL, orchestratorAuthSession); got != orchestrator.URL+"/auth/session" {
+			t.Fatalf("expected the v1 path while the probe fails, got %s", got)
+		}
+	}
+	if probes.Load() != 1 {
+		t.Fatalf("expected failed probes to be retried only after %s, got %d probes", upstreamAPIProbeInterval, probes.Load())
+	}
+
+	// An orchestrator without the versions endpoint serves v1.
+	fail.Store(false)
+	now = now.Add(upstreamAPIProbeInterval)
+	orchestratorAPI.URL(ctx, orchestrator.URL, orchestratorAuthSession)
+	if probes.Load() != 2 || orchestratorAPI.currentVersion(orchestrator.URL) != upstreamAPIv1 {
+		t.Fatalf("expected a 404 to be detected as v1, got %q after %d probes", orchestratorAPI.currentVersion(orchestrator.URL), probes.Load())
+	}
+}
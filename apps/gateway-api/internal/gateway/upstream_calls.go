@@ -0,0 +1,323 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+// Modes accepted in GATEWAY_UPSTREAM_CALL_AUDIT.
+const (
+	upstreamCallAuditOff      = "off"
+	upstreamCallAuditFailures = "failures"
+	upstreamCallAuditAll      = "all"
+)
+
+const (
+	// upstreamEndpointOther collects calls once maxUpstreamCallSeries label
+	// combinations are tracked, keeping the metric cardinality bounded.
+	upstreamEndpointOther = "other"
+	maxUpstreamCallSeries = 512
+)
+
+// upstreamStaticSegment matches the path segments kept in endpoint labels;
+// any other segment (identifiers, hashes, file names) becomes {id}.
+var upstreamStaticSegment = regexp.MustCompile(`^(?:[a-z][a-z_-]{0,31}|v[0-9]{1,2})$`)
+
+// upstreamEndpointLabel reduces an upstream path to a template such as
+// /plan/{id}/approve, so labels stay low-cardinality.
+func upstreamEndpointLabel(path string) string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return "/"
+	}
+	segments := strings.Split(trimmed, "/")
+	for i, segment := range segments {
+		if !upstreamStaticSegment.MatchString(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+func upstreamStatusClass(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode/100) + "xx"
+}
+
+type upstreamRetriesKey struct{}
+
+// withUpstreamRetries marks requests made with ctx as the retries-th retry of
+// an upstream call, for the metrics and audit events of upstream calls.
+func withUpstreamRetries(ctx context.Context, retries int) context.Context {
+	return context.WithValue(ctx, upstreamRetriesKey{}, retries)
+}
+
+func upstreamRetries(ctx context.Context) int {
+	retries, _ := ctx.Value(upstreamRetriesKey{}).(int)
+	return retries
+}
+
+// upstreamCallTransport records every request sent to service: its latency
+// up to the response headers, status and retry count feed the
+// gateway.upstream metrics and, when GATEWAY_UPSTREAM_CALL_AUDIT asks for
+// it, an upstream.call audit event carrying the client's request ID.
+type upstreamCallTransport struct {
+	service string
+	rt      http.RoundTripper
+}
+
+// observeUpstreamCalls wraps rt so the calls it makes to service are
+// recorded.
+func observeUpstreamCalls(service string, rt http.RoundTripper) http.RoundTripper {
+	registerUpstreamCallInstruments(upstreamCalls)
+	return &upstreamCallTransport{service: service, rt: rt}
+}
+
+func (t *upstreamCallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := t.rt.RoundTrip(req)
+	call := upstreamCall{
+		service:  t.service,
+		method:   req.Method,
+		endpoint: upstreamEndpointLabel(req.URL.Path),
+		status:   upstreamStatusClass(resp, err),
+		retries:  upstreamRetries(req.Context()),
+		elapsed:  time.Since(started),
+		err:      err,
+	}
+	if resp != nil {
+		call.statusCode = resp.StatusCode
+	}
+	upstreamCalls.record(call)
+	auditUpstreamCall(req.Context(), call)
+	return resp, err
+}
+
+func (t *upstreamCallTransport) CloseIdleConnections() {
+	if closer, ok := t.rt.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// upstreamCall is one request the gateway sent upstream.
+type upstreamCall struct {
+	service    string
+	method     string
+	endpoint   string
+	status     string
+	statusCode int
+	retries    int
+	elapsed    time.Duration
+	err        error
+}
+
+func (c upstreamCall) failed() bool {
+	return c.err != nil || c.statusCode >= http.StatusInternalServerError
+}
+
+type upstreamCallKey struct {
+	service  string
+	endpoint string
+	status   string
+}
+
+type upstreamCallStats struct {
+	requests int64
+	retries  int64
+	duration time.Duration
+}
+
+// upstreamCallAggregator totals upstream calls by service, endpoint and
+// status class for the observable instruments.
+type upstreamCallAggregator struct {
+	mu    sync.Mutex
+	calls map[upstreamCallKey]*upstreamCallStats
+}
+
+var upstreamCalls = newUpstreamCallAggregator()
+
+func newUpstreamCallAggregator() *upstreamCallAggregator {
+	return &upstreamCallAggregator{calls: make(map[upstreamCallKey]*upstreamCallStats)}
+}
+
+func (a *upstreamCallAggregator) record(call upstreamCall) {
+	key := upstreamCallKey{service: call.service, endpoint: call.endpoint, status: call.status}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats, ok := a.calls[key]
+	if !ok {
+		if len(a.calls) >= maxUpstreamCallSeries {
+			key.endpoint = upstreamEndpointOther
+			stats = a.calls[key]
+		}
+		if stats == nil {
+			stats = &upstreamCallStats{}
+			a.calls[key] = stats
+		}
+	}
+	stats.requests++
+	if call.retries > 0 {
+		stats.retries++
+	}
+	stats.duration += call.elapsed
+}
+
+func (a *upstreamCallAggregator) snapshot() map[upstreamCallKey]upstreamCallStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	snap := make(map[upstreamCallKey]upstreamCallStats, len(a.calls))
+	for key, stats := range a.calls {
+		snap[key] = *stats
+	}
+	return snap
+}
+
+var upstreamCallsOnce sync.Once
+
+// registerUpstreamCallInstruments publishes the totals through the global
+// meter provider as gateway.upstream.requests, gateway.upstream.duration and
+// gateway.upstream.retries, labelled by service, endpoint and status.
+func registerUpstreamCallInstruments(a *upstreamCallAggregator) {
+	upstreamCallsOnce.Do(func() {
+		meter := otel.Meter("gateway.upstream")
+		requests, err := meter.Int64ObservableCounter("gateway.upstream.requests",
+			metric.WithDescription("Requests sent to upstream services."))
+		if err != nil {
+			slog.Warn("gateway.upstream.metrics_failed", slog.String("error", err.Error()))
+			return
+		}
+		duration, err := meter.Float64ObservableCounter("gateway.upstream.duration",
+			metric.WithDescription("Total time upstream services took to return response headers."),
+			metric.WithUnit("s"))
+		if err != nil {
+			slog.Warn("gateway.upstream.metrics_failed", slog.String("error", err.Error()))
+			return
+		}
+		retries, err := meter.Int64ObservableCounter("gateway.upstream.retries",
+			metric.WithDescription("Upstream requests that retried an earlier failed call."))
+		if err != nil {
+			slog.Warn("gateway.upstream.metrics_failed", slog.String("error", err.Error()))
+			return
+		}
+		_, err = meter.RegisterCallback(func(_ context.Context, observer metric.Observer) error {
+			for key, stats := range a.snapshot() {
+				attrs := withDeploymentAttributes(
+					attribute.String("service", key.service),
+					attribute.String("endpoint", key.endpoint),
+					attribute.String("status", key.status),
+				)
+				observer.ObserveInt64(requests, stats.requests, attrs)
+				observer.ObserveFloat64(duration, stats.duration.Seconds(), attrs)
+				observer.ObserveInt64(retries, stats.retries, attrs)
+			}
+			return nil
+		}, requests, duration, retries)
+		if err != nil {
+			slog.Warn("gateway.upstream.metrics_failed", slog.String("error", err.Error()))
+		}
+	})
+}
+
+var (
+	upstreamCallAuditMu   sync.Mutex
+	upstreamCallAuditOnce sync.Once
+	upstreamCallAuditMode string
+)
+
+// resetUpstreamCallAudit clears the cached mode for tests.
+func resetUpstreamCallAudit() {
+	upstreamCallAuditMu.Lock()
+	defer upstreamCallAuditMu.Unlock()
+	upstreamCallAuditOnce = sync.Once{}
+	upstreamCallAuditMode = ""
+}
+
+func parseUpstreamCallAudit() (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("GATEWAY_UPSTREAM_CALL_AUDIT")))
+	switch mode {
+	case "":
+		return upstreamCallAuditOff, nil
+	case upstreamCallAuditOff, upstreamCallAuditFailures, upstreamCallAuditAll:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported GATEWAY_UPSTREAM_CALL_AUDIT %q (expected %s, %s or %s)", mode, upstreamCallAuditOff, upstreamCallAuditFailures, upstreamCallAuditAll)
+	}
+}
+
+// ValidateUpstreamCallAuditConfig checks GATEWAY_UPSTREAM_CALL_AUDIT at
+// startup.
+func ValidateUpstreamCallAuditConfig() error {
+	_, err := parseUpstreamCallAudit()
+	return err
+}
+
+// loadUpstreamCallAudit returns the configured mode; invalid values are
+// caught at startup and disable the audit here.
+func loadUpstreamCallAudit() string {
+	upstreamCallAuditMu.Lock()
+	defer upstreamCallAuditMu.Unlock()
+	upstreamCallAuditOnce.Do(func() {
+		mode, err := parseUpstreamCallAudit()
+		if err != nil {
+			mode = upstreamCallAuditOff
+		}
+		upstreamCallAuditMode = mode
+	})
+	return upstreamCallAuditMode
+}
+
+// auditUpstreamCall records call as an upstream.call event when the
+// configured mode covers it. ctx carries the request ID of the client
+// request that caused the call.
+func auditUpstreamCall(ctx context.Context, call upstreamCall) {
+	switch loadUpstreamCallAudit() {
+	case upstreamCallAuditAll:
+	case upstreamCallAuditFailures:
+		if !call.failed() {
+			return
+		}
+	default:
+		return
+	}
+	details := map[string]any{
+		"service":     call.service,
+		"endpoint":    call.endpoint,
+		"method":      call.method,
+		"duration_ms": call.elapsed.Milliseconds(),
+		"retries":     call.retries,
+	}
+	if call.statusCode != 0 {
+		details["status_code"] = call.statusCode
+	}
+	if call.err != nil {
+		details["error"] = call.err.Error()
+	}
+	event := audit.Event{
+		Name:    "upstream.call",
+		Outcome: auditOutcomeSuccess,
+		Target:  call.service,
+		Details: details,
+	}
+	if call.failed() {
+		event.Outcome = auditOutcomeFailure
+		gatewayAuditLogger.Error(ctx, event)
+		return
+	}
+	gatewayAuditLogger.Info(ctx, event)
+}
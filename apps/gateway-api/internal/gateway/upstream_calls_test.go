@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+func useUpstreamCallAudit(t *testing.T, mode string) {
+	t.Helper()
+	t.Setenv("GATEWAY_UPSTREAM_CALL_AUDIT", mode)
+	resetUpstreamCallAudit()
+	t.Cleanup(resetUpstreamCallAudit)
+	original := upstreamCalls
+	upstreamCalls = newUpstreamCallAggregator()
+	t.Cleanup(func() { upstreamCalls = original })
+}
+
+func TestUpstreamEndpointLabel(t *testing.T) {
+	cases := map[string]string{
+		"":                                  "/",
+		"/events":                           "/events",
+		"/plan/" + validPlanID + "/approve": "/plan/{id}/approve",
+		"/v1/sessions/abc123":               "/v1/sessions/{id}",
+		"/artifacts/report.pdf/":            "/artifacts/{id}",
+		"/Auth/Session":                     "/{id}/{id}",
+	}
+	for path, want := range cases {
+		if got := upstreamEndpointLabel(path); got != want {
+			t.Errorf("upstreamEndpointLabel(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestUpstreamCallTransportRecordsCalls(t *testing.T) {
+	useUpstreamCallAudit(t, upstreamCallAuditAll)
+	logs := captureAuditLogs(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/fail") {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: observeUpstreamCalls(egressIndexer, http.DefaultTransport)}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Id", "req-42")
+	req, _ = audit.EnsureRequestID(req, nil)
+	ctx := withUpstreamRetries(req.Context(), 2)
+	for _, path := range []string{"/plan/" + validPlanID, "/plan/" + validPlanID + "/fail"} {
+		outbound, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL+path, nil)
+		resp, err := client.Do(outbound)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	snap := upstreamCalls.snapshot()
+	ok := snap[upstreamCallKey{service: egressIndexer, endpoint: "/plan/{id}", status: "2xx"}]
+	failed := snap[upstreamCallKey{service: egressIndexer, endpoint: "/plan/{id}/fail", status: "5xx"}]
+	if ok.requests != 1 || ok.retries != 1 || failed.requests != 1 || ok.duration <= 0 {
+		t.Fatalf("unexpected totals %+v", snap)
+	}
+	out := logs.String()
+	for _, want := range []string{`"event":"upstream.call"`, `"request_id":"req-42"`, `"service":"indexer"`, `"endpoint":"/plan/{id}/fail"`, `"status_code":502`, `"retries":2`, `"outcome":"failure"`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s in audit logs, got %s", want, out)
+		}
+	}
+}
+
+func TestUpstreamCallAuditModes(t *testing.T) {
+	useUpstreamCallAudit(t, upstreamCallAuditFailures)
+	logs := captureAuditLogs(t)
+	ctx := context.Background()
+	auditUpstreamCall(ctx, upstreamCall{service: egressOrchestrator, endpoint: "/events", statusCode: http.StatusOK, elapsed: time.Millisecond})
+	if logs.Len() != 0 {
+		t.Fatalf("expected successful calls not to be audited, got %s", logs.String())
+	}
+	auditUpstreamCall(ctx, upstreamCall{service: egressOrchestrator, endpoint: "/events", status: "error", err: errors.New("connection refused")})
+	if !strings.Contains(logs.String(), `"error":"connection refused"`) {
+		t.Fatalf("expected the failed call to be audited, got %s", logs.String())
+	}
+
+	t.Setenv("GATEWAY_UPSTREAM_CALL_AUDIT", "verbose")
+	if err := ValidateUpstreamCallAuditConfig(); err == nil {
+		t.Fatal("expected an unknown mode to be rejected")
+	}
+}
+
+func TestUpstreamCallAggregatorBoundsSeries(t *testing.T) {
+	aggregator := newUpstreamCallAggregator()
+	for i := 0; i < maxUpstreamCallSeries+10; i++ {
+		aggregator.record(upstreamCall{service: egressOrchestrator, endpoint: "/" + strings.Repeat("a", i%32+1) + strings.Repeat("/b", i/32), status: "2xx"})
+	}
+	snap := aggregator.snapshot()
+	if len(snap) != maxUpstreamCallSeries+1 {
+		t.Fatalf("expected %d series, got %d", maxUpstreamCallSeries+1, len(snap))
+	}
+	if other := snap[upstreamCallKey{service: egressOrchestrator, endpoint: upstreamEndpointOther, status: "2xx"}]; other.requests != 10 {
+		t.Fatalf("expected overflow calls under %q, got %+v", upstreamEndpointOther, other)
+	}
+}
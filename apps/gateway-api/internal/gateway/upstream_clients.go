@@ -0,0 +1,303 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultOrchestratorURL             = "http://127.0.0.1:4000"
+	defaultUpstreamConfigRefreshPeriod = 30 * time.Second
+)
+
+// orchestratorConfigKeys lists the environment variables that influence how
+// the orchestrator client is built. A change to any of them triggers a rebuild.
+var orchestratorConfigKeys = []string{
+	"ORCHESTRATOR_URL",
+	"ORCHESTRATOR_TLS_ENABLED",
+	"ORCHESTRATOR_CLIENT_CERT",
+	"ORCHESTRATOR_CLIENT_KEY",
+	"ORCHESTRATOR_CA_CERT",
+	"ORCHESTRATOR_TLS_SERVER_NAME",
+}
+
+// upstreamClient is an immutable snapshot of a built upstream client. Callers
+// keep using the snapshot they obtained even if the registry swaps in a newer
+// one, so in-flight requests are never interrupted by a rebuild.
+type upstreamClient struct {
+	client     *http.Client
+	baseURL    string
+	envKey     string
+	fileKey    string
+	generation uint64
+	builtAt    time.Time
+	tlsEnabled bool
+}
+
+// managedUpstream rebuilds an upstream client whenever its configuration
+// changes. Refresh, which the gateway calls periodically, compares the
+// environment and the TLS material on disk with those of the current client.
+type managedUpstream struct {
+	name     string
+	envKeys  []string
+	fileKeys func() []string
+
+	mu          sync.Mutex
+	current     atomic.Pointer[upstreamClient]
+	generation  uint64
+	lastErr     error
+	lastErrAt   time.Time
+	failures    uint64
+	lastChecked time.Time
+}
+
+// upstreamClientStats is the admin API view of a managed upstream client.
+type upstreamClientStats struct {
+	Name             string          `json:"name"`
+	BaseURL          string          `json:"base_url,omitempty"`
+	Status           string          `json:"status"`
+	Generation       uint64          `json:"generation"`
+	BuiltAt          *time.Time      `json:"built_at,omitempty"`
+	LastCheckedAt    *time.Time      `json:"last_checked_at,omitempty"`
+	TLSEnabled       bool            `json:"tls_enabled"`
+	APIVersion       string          `json:"api_version,omitempty"`
+	RefreshFailures  uint64          `json:"refresh_failures"`
+	LastRefreshError string          `json:"last_refresh_error,omitempty"`
+	LastRefreshErrAt *time.Time      `json:"last_refresh_error_at,omitempty"`
+	Transport        *transportStats `json:"transport,omitempty"`
+}
+
+var orchestratorUpstream = &managedUpstream{
+	name:     "orchestrator",
+	envKeys:  orchestratorConfigKeys,
+	fileKeys: orchestratorTLSFiles,
+}
+
+// orchestratorTLSFiles returns the certificate paths whose contents are
+// watched for rotation. Nothing is watched while TLS is disabled.
+func orchestratorTLSFiles() []string {
+	if !getBoolEnv("ORCHESTRATOR_TLS_ENABLED") {
+		return nil
+	}
+	var paths []string
+	for _, key := range []string{"ORCHESTRATOR_CLIENT_CERT", "ORCHESTRATOR_CLIENT_KEY", "ORCHESTRATOR_CA_CERT"} {
+		if path := strings.TrimSpace(os.Getenv(key)); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// currentOrchestrator returns the orchestrator client together with the base
+// URL it was built for.
+func currentOrchestrator() (*http.Client, string, error) {
+	snapshot, err := orchestratorUpstream.get()
+	if err != nil {
+		return nil, "", err
+	}
+	return snapshot.client, snapshot.baseURL, nil
+}
+
+// orchestratorBaseURL returns the base URL of the current orchestrator client,
+// falling back to the raw environment when no client could be built.
+func orchestratorBaseURL() string {
+	if _, baseURL, err := currentOrchestrator(); err == nil {
+		return baseURL
+	}
+	return strings.TrimRight(GetEnv("ORCHESTRATOR_URL", defaultOrchestratorURL), "/")
+}
+
+func (m *managedUpstream) envFingerprint() string {
+	var b strings.Builder
+	for _, key := range m.envKeys {
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(strings.TrimSpace(os.Getenv(key)))
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// fileFingerprint summarises the size and modification time of watched files
+// so rotated certificates are picked up without hashing their contents.
+func (m *managedUpstream) fileFingerprint() string {
+	if m.fileKeys == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, path := range m.fileKeys() {
+		b.WriteString(path)
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&b, ":%d:%d", info.Size(), info.ModTime().UnixNano())
+		} else {
+			b.WriteString(":missing")
+		}
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// get returns the current snapshot, building the first one on demand.
+// Configuration changes are picked up by Refresh, not per request.
+func (m *managedUpstream) get() (*upstreamClient, error) {
+	if snapshot := m.current.Load(); snapshot != nil {
+		return snapshot, nil
+	}
+	return m.rebuild(m.envFingerprint(), m.fileFingerprint(), false)
+}
+
+// Refresh rebuilds the client if either its environment or watched files
+// changed since the last build. It reports whether a new client was swapped in.
+func (m *managedUpstream) Refresh() (bool, error) {
+	envKey := m.envFingerprint()
+	fileKey := m.fileFingerprint()
+	m.mu.Lock()
+	m.lastChecked = time.Now()
+	m.mu.Unlock()
+	if snapshot := m.current.Load(); snapshot != nil && snapshot.envKey == envKey && snapshot.fileKey == fileKey {
+		return false, nil
+	}
+	previous := m.current.Load()
+	snapshot, err := m.rebuild(envKey, fileKey, true)
+	if err != nil {
+		return false, err
+	}
+	return snapshot != previous, nil
+}
+
+// rebuild builds a new client under the lock. When the build fails and a
+// previous client exists, that client stays in service and the error is only
+// recorded; otherwise the error is returned to the caller.
+func (m *managedUpstream) rebuild(envKey, fileKey string, force bool) (*upstreamClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous := m.current.Load()
+	if previous != nil && previous.envKey == envKey && (!force || previous.fileKey == fileKey) {
+		return previous, nil
+	}
+
+	client, err := orchestratorClientFactory()
+	if err == nil && client == nil {
+		err = fmt.Errorf("%s client factory returned nil", m.name)
+	}
+	if err != nil {
+		m.failures++
+		m.lastErr = err
+		m.lastErrAt = time.Now()
+		if previous != nil {
+			slog.Warn("upstream client rebuild failed; keeping previous client",
+				slog.String("upstream", m.name),
+				slog.Uint64("generation", previous.generation),
+				slog.Any("error", err))
+			return previous, nil
+		}
+		return nil, err
+	}
+
+	m.generation++
+	snapshot := &upstreamClient{
+		client:     client,
+		baseURL:    strings.TrimRight(GetEnv("ORCHESTRATOR_URL", defaultOrchestratorURL), "/"),
+		envKey:     envKey,
+		fileKey:    fileKey,
+		generation: m.generation,
+		builtAt:    time.Now(),
+		tlsEnabled: getBoolEnv("ORCHESTRATOR_TLS_ENABLED"),
+	}
+	m.current.Store(snapshot)
+	m.lastErr = nil
+	if previous != nil {
+		// Only idle connections are closed; requests already using the old
+		// client finish on their existing connections.
+		previous.client.CloseIdleConnections()
+		slog.Info("upstream client rebuilt",
+			slog.String("upstream", m.name),
+			slog.Uint64("generation", snapshot.generation))
+	}
+	return snapshot, nil
+}
+
+func (m *managedUpstream) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if previous := m.current.Swap(nil); previous != nil && previous.client != nil {
+		previous.client.CloseIdleConnections()
+	}
+	m.generation = 0
+	m.lastErr = nil
+	m.lastErrAt = time.Time{}
+	m.failures = 0
+	m.lastChecked = time.Time{}
+}
+
+func (m *managedUpstream) stats() upstreamClientStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := upstreamClientStats{
+		Name:            m.name,
+		Status:          "unavailable",
+		RefreshFailures: m.failures,
+	}
+	if !m.lastChecked.IsZero() {
+		checked := m.lastChecked
+		stats.LastCheckedAt = &checked
+	}
+	if m.lastErr != nil {
+		stats.LastRefreshError = m.lastErr.Error()
+		failedAt := m.lastErrAt
+		stats.LastRefreshErrAt = &failedAt
+	}
+	snapshot := m.current.Load()
+	if snapshot == nil {
+		return stats
+	}
+	stats.Status = "ok"
+	if m.lastErr != nil {
+		stats.Status = "stale"
+	}
+	stats.BaseURL = snapshot.baseURL
+	stats.Generation = snapshot.generation
+	built := snapshot.builtAt
+	stats.BuiltAt = &built
+	stats.TLSEnabled = snapshot.tlsEnabled
+	if transport, ok := snapshot.client.Transport.(*instrumentedTransport); ok {
+		current := transport.stats()
+		stats.Transport = &current
+	}
+	return stats
+}
+
+// refreshUpstreamClients re-checks upstream client configuration and, with
+// ORCHESTRATOR_API_VERSION=auto, the orchestrator's API version. It runs as a
+// maintenance job every GATEWAY_UPSTREAM_REFRESH_INTERVAL.
+func refreshUpstreamClients(ctx context.Context) error {
+	if _, err := orchestratorUpstream.Refresh(); err != nil {
+		return fmt.Errorf("refresh %s client: %w", orchestratorUpstream.name, err)
+	}
+	orchestratorAPI.refresh(ctx, orchestratorBaseURL())
+	return nil
+}
+
+// upstreamTransport resolves the current orchestrator transport per request so
+// long-lived proxies pick up rebuilt clients.
+type upstreamTransport struct{}
+
+func (upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	client, _, err := currentOrchestrator()
+	if err != nil {
+		return nil, err
+	}
+	if client.Transport == nil {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	return client.Transport.RoundTrip(req)
+}
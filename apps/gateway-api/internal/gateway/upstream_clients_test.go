@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func countingClientFactory(t *testing.T, fail *atomic.Bool) *int32 {
+	t.Helper()
+	var builds int32
+	SetOrchestratorClientFactory(func() (*http.Client, error) {
+		atomic.AddInt32(&builds, 1)
+		if fail != nil && fail.Load() {
+			return nil, errors.New("bad tls material")
+		}
+		return &http.Client{}, nil
+	})
+	t.Cleanup(ResetOrchestratorClient)
+	return &builds
+}
+
+func TestOrchestratorClientRebuildsOnRefreshAfterEnvChange(t *testing.T) {
+	t.Setenv("ORCHESTRATOR_URL", "http://orchestrator-a:4000/")
+	builds := countingClientFactory(t, nil)
+
+	first, baseURL, err := currentOrchestrator()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if baseURL != "http://orchestrator-a:4000" {
+		t.Fatalf("unexpected base url %q", baseURL)
+	}
+	if again, _, _ := currentOrchestrator(); again != first || atomic.LoadInt32(builds) != 1 {
+		t.Fatalf("expected client to be reused, got %d builds", atomic.LoadInt32(builds))
+	}
+
+	t.Setenv("ORCHESTRATOR_URL", "http://orchestrator-b:4000")
+	if again, _, _ := currentOrchestrator(); again != first {
+		t.Fatal("expected requests to keep the current client until a refresh")
+	}
+	if rebuilt, err := orchestratorUpstream.Refresh(); err != nil || !rebuilt {
+		t.Fatalf("expected the new url to trigger a rebuild, got %v, %v", rebuilt, err)
+	}
+	second, baseURL, err := currentOrchestrator()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second == first || baseURL != "http://orchestrator-b:4000" {
+		t.Fatalf("expected a rebuilt client for the new url, got %q", baseURL)
+	}
+	if got := orchestratorUpstream.stats().Generation; got != 2 {
+		t.Fatalf("expected generation 2, got %d", got)
+	}
+}
+
+func TestOrchestratorClientRefreshDetectsRotatedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "client.crt")
+	if err := os.WriteFile(certPath, []byte("cert-v1"), 0o600); err != nil {
+		t.Fatalf("failed to write cert: %v", err)
+	}
+	t.Setenv("ORCHESTRATOR_TLS_ENABLED", "true")
+	t.Setenv("ORCHESTRATOR_CLIENT_CERT", certPath)
+	builds := countingClientFactory(t, nil)
+
+	if _, err := getOrchestratorClient(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rebuilt, err := orchestratorUpstream.Refresh(); err != nil || rebuilt {
+		t.Fatalf("expected no rebuild without changes, got %v, %v", rebuilt, err)
+	}
+
+	if err := os.WriteFile(certPath, []byte("cert-v2-rotated"), 0o600); err != nil {
+		t.Fatalf("failed to rotate cert: %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(certPath, future, future); err != nil {
+		t.Fatalf("failed to touch cert: %v", err)
+	}
+	rebuilt, err := orchestratorUpstream.Refresh()
+	if err != nil || !rebuilt {
+		t.Fatalf("expected rotated certificate to trigger a rebuild, got %v, %v", rebuilt, err)
+	}
+	if got := atomic.LoadInt32(builds); got != 2 {
+		t.Fatalf("expected 2 builds, got %d", got)
+	}
+}
+
+func TestOrchestratorClientKeepsPreviousClientWhenRebuildFails(t *testing.T) {
+	t.Setenv("ORCHESTRATOR_URL", "http://orchestrator-a:4000")
+	var fail atomic.Bool
+	builds := countingClientFactory(t, &fail)
+
+	first, err := getOrchestratorClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fail.Store(true)
+	t.Setenv("ORCHESTRATOR_URL", "http://orchestrator-b:4000")
+	if rebuilt, err := orchestratorUpstream.Refresh(); err != nil || rebuilt {
+		t.Fatalf("expected the failed rebuild to keep the previous client, got %v, %v", rebuilt, err)
+	}
+	for i := 0; i < 3; i++ {
+		client, baseURL, err := currentOrchestrator()
+		if err != nil || client != first || baseURL != "http://orchestrator-a:4000" {
+			t.Fatalf("expected previous client to stay in service, got %q, %v", baseURL, err)
+		}
+	}
+	if got := atomic.LoadInt32(builds); got != 2 {
+		t.Fatalf("expected requests not to retry the failed config, got %d builds", got)
+	}
+	stats := orchestratorUpstream.stats()
+	if stats.Status != "stale" || stats.RefreshFailures != 1 || stats.LastRefreshError == "" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	fail.Store(false)
+	if rebuilt, err := orchestratorUpstream.Refresh(); err != nil || !rebuilt {
+		t.Fatalf("expected refresh to recover, got %v, %v", rebuilt, err)
+	}
+	if stats := orchestratorUpstream.stats(); stats.Status != "ok" || stats.BaseURL != "http://orchestrator-b:4000" {
+		t.Fatalf("unexpected stats after recovery %+v", stats)
+	}
+}
@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxUpstreamResponseBytes bounds non-streaming upstream responses. The
+// orchestrator and identity providers return small JSON documents, so 1MiB is
+// generous while still preventing a misbehaving upstream from exhausting memory.
+const defaultMaxUpstreamResponseBytes = 1 << 20
+
+var errUpstreamResponseTooLarge = errors.New("upstream response exceeds size limit")
+
+// maxUpstreamResponseBytes returns the configured cap from
+// GATEWAY_MAX_UPSTREAM_RESPONSE_BYTES. Invalid values stop the gateway at
+// startup; should one be set afterwards, the default applies.
+func maxUpstreamResponseBytes() int64 {
+	return int64(ResolveLimit([]string{"GATEWAY_MAX_UPSTREAM_RESPONSE_BYTES"}, defaultMaxUpstreamResponseBytes))
+}
+
+// ValidateUpstreamResponseConfig checks GATEWAY_MAX_UPSTREAM_RESPONSE_BYTES at
+// startup.
+func ValidateUpstreamResponseConfig() error {
+	if raw := strings.TrimSpace(GetEnv("GATEWAY_MAX_UPSTREAM_RESPONSE_BYTES", "")); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err != nil || parsed <= 0 {
+			return errors.New("GATEWAY_MAX_UPSTREAM_RESPONSE_BYTES must be a positive integer")
+		}
+	}
+	return nil
+}
+
+// readUpstreamBody reads at most limit bytes from body. Bodies larger than the
+// limit are rejected rather than truncated so callers never act on a partial
+// document.
+func readUpstreamBody(body io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w (%d bytes)", errUpstreamResponseTooLarge, limit)
+	}
+	return data, nil
+}
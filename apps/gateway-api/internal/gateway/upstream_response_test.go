@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadUpstreamBodyRejectsOversizedBodies(t *testing.T) {
+	body, err := readUpstreamBody(strings.NewReader("12345"), 5)
+	if err != nil || string(body) != "12345" {
+		t.Fatalf("expected body at the limit to be accepted, got %q, %v", body, err)
+	}
+	if _, err := readUpstreamBody(strings.NewReader("123456"), 5); !errors.Is(err, errUpstreamResponseTooLarge) {
+		t.Fatalf("expected errUpstreamResponseTooLarge, got %v", err)
+	}
+}
+
+func TestMaxUpstreamResponseBytes(t *testing.T) {
+	cases := map[string]int64{
+		"":      defaultMaxUpstreamResponseBytes,
+		"abc":   defaultMaxUpstreamResponseBytes,
+		"0":     defaultMaxUpstreamResponseBytes,
+		"-1":    defaultMaxUpstreamResponseBytes,
+		"65536": 65536,
+	}
+	for raw, want := range cases {
+		t.Setenv("GATEWAY_MAX_UPSTREAM_RESPONSE_BYTES", raw)
+		if got := maxUpstreamResponseBytes(); got != want {
+			t.Fatalf("maxUpstreamResponseBytes() with %q = %d, want %d", raw, got, want)
+		}
+	}
+}
+
+func TestValidateUpstreamResponseConfig(t *testing.T) {
+	for raw, valid := range map[string]bool{"": true, "65536": true, "abc": false, "0": false, "-1": false} {
+		t.Setenv("GATEWAY_MAX_UPSTREAM_RESPONSE_BYTES", raw)
+		if err := ValidateUpstreamResponseConfig(); (err == nil) != valid {
+			t.Fatalf("ValidateUpstreamResponseConfig() with %q = %v", raw, err)
+		}
+	}
+}
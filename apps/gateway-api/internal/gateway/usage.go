@@ -0,0 +1,474 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+)
+
+const (
+	usageMetricAuthEvents           = "auth_events"
+	usageMetricProxiedRequests      = "proxied_requests"
+	usageMetricSSESeconds           = "sse_seconds"
+	usageMetricCollaborationSeconds = "collaboration_seconds"
+
+	// usageUnattributed collects usage that could not be tied to a tenant.
+	usageUnattributed = "unattributed"
+
+	defaultUsageFlushInterval = time.Minute
+	defaultUsageRetention     = 400 * 24 * time.Hour
+	defaultUsageKeyPrefix     = "gateway:usage"
+	maxUsageQueryRange        = 366 * 24 * time.Hour
+)
+
+// usageRecord holds the counters for one tenant in one UTC hour.
+type usageRecord struct {
+	TenantHash string
+	Hour       time.Time
+	Counters   map[string]int64
+}
+
+// usageStore persists hourly usage counters. Add increments existing counters
+// rather than replacing them so several replicas can share one store.
+type usageStore interface {
+	Add(ctx context.Context, records []usageRecord) error
+	// Query returns the records with from <= Hour < to. An empty tenantHash
+	// returns every tenant.
+	Query(ctx context.Context, from, to time.Time, tenantHash string) ([]usageRecord, error)
+}
+
+type usageKey struct {
+	tenantHash string
+	hour       int64
+}
+
+// usageMeter aggregates counters in memory and periodically flushes them to
+// its store.
+type usageMeter struct {
+	mu      sync.Mutex
+	pending map[usageKey]map[string]int64
+	store   usageStore
+	now     func() time.Time
+}
+
+func newUsageMeter(store usageStore) *usageMeter {
+	return &usageMeter{
+		pending: make(map[usageKey]map[string]int64),
+		store:   store,
+		now:     time.Now,
+	}
+}
+
+func (m *usageMeter) add(tenantHash, metric string, delta int64) {
+	if m == nil || delta <= 0 {
+		return
+	}
+	if tenantHash == "" {
+		tenantHash = usageUnattributed
+	}
+	key := usageKey{tenantHash: tenantHash, hour: m.now().UTC().Truncate(time.Hour).Unix()}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counters := m.pending[key]
+	if counters == nil {
+		counters = make(map[string]int64)
+		m.pending[key] = counters
+	}
+	counters[metric] += delta
+}
+
+// flush writes pending counters to the store. Counters are put back when the
+// store fails so the next flush retries them.
+func (m *usageMeter) flush(ctx context.Context) error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[usageKey]map[string]int64)
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := m.store.Add(ctx, usageRecordsFrom(pending)); err != nil {
+		m.mu.Lock()
+		for key, counters := range pending {
+			merged := m.pending[key]
+			if merged == nil {
+				m.pending[key] = counters
+				continue
+			}
+			for metric, value := range counters {
+				merged[metric] += value
+			}
+		}
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// query returns stored and not yet flushed usage in [from, to).
+func (m *usageMeter) query(ctx context.Context, from, to time.Time, tenantHash string) ([]usageRecord, error) {
+	records, err := m.store.Query(ctx, from, to, tenantHash)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	var pending []usageRecord
+	for _, record := range usageRecordsFrom(m.pending) {
+		if (tenantHash == "" || record.TenantHash == tenantHash) && !record.Hour.Before(from) && record.Hour.Before(to) {
+			pending = append(pending, record)
+		}
+	}
+	m.mu.Unlock()
+	return append(records, pending...), nil
+}
+
+func usageRecordsFrom(entries map[usageKey]map[string]int64) []usageRecord {
+	records := make([]usageRecord, 0, len(entries))
+	for key, counters := range entries {
+		copied := make(map[string]int64, len(counters))
+		for metric, value := range counters {
+			copied[metric] = value
+		}
+		records = append(records, usageRecord{TenantHash: key.tenantHash, Hour: time.Unix(key.hour, 0).UTC(), Counters: copied})
+	}
+	return records
+}
+
+type memoryUsageStore struct {
+	mu        sync.Mutex
+	entries   map[usageKey]map[string]int64
+	retention time.Duration
+	now       func() time.Time
+}
+
+func newMemoryUsageStore(retention time.Duration) *memoryUsageStore {
+	return &memoryUsageStore{
+		entries:   make(map[usageKey]map[string]int64),
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+func (s *memoryUsageStore) Add(_ context.Context, records []usageRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := s.now().Add(-s.retention).Unix()
+	for key := range s.entries {
+		if key.hour < cutoff {
+			delete(s.entries, key)
+		}
+	}
+	for _, record := range records {
+		key := usageKey{tenantHash: record.TenantHash, hour: record.Hour.Unix()}
+		counters := s.entries[key]
+		if counters == nil {
+			counters = make(map[string]int64)
+			s.entries[key] = counters
+		}
+		for metric, value := range record.Counters {
+			counters[metric] += value
+		}
+	}
+	return nil
+}
+
+func (s *memoryUsageStore) Query(_ context.Context, from, to time.Time, tenantHash string) ([]usageRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matched := make(map[usageKey]map[string]int64)
+	for key, counters := range s.entries {
+		if (tenantHash == "" || key.tenantHash == tenantHash) && key.hour >= from.Unix() && key.hour < to.Unix() {
+			matched[key] = counters
+		}
+	}
+	return usageRecordsFrom(matched), nil
+}
+
+// redisUsageStore keeps one hash per tenant and hour, plus a set of the
+// tenants seen in each hour so range queries do not need SCAN. A flush that
+// fails part way is retried in full, so counters may be over-reported after
+// Redis errors.
+type redisUsageStore struct {
+	client    *storage.RedisClient
+	prefix    string
+	retention time.Duration
+}
+
+func (s *redisUsageStore) hourPrefix(hour time.Time) string {
+	return s.prefix + ":" + strconv.FormatInt(hour.Unix(), 10)
+}
+
+func (s *redisUsageStore) Add(ctx context.Context, records []usageRecord) error {
+	ttl := strconv.FormatInt(int64(s.retention/time.Second), 10)
+	for _, record := range records {
+		hourKey := s.hourPrefix(record.Hour)
+		key := hourKey + ":" + record.TenantHash
+		for metric, value := range record.Counters {
+			if _, err := s.client.Do(ctx, "HINCRBY", key, metric, strconv.FormatInt(value, 10)); err != nil {
+				return err
+			}
+		}
+		if _, err := s.client.Do(ctx, "EXPIRE", key, ttl); err != nil {
+			return err
+		}
+		if _, err := s.client.Do(ctx, "SADD", hourKey+":tenants", record.TenantHash); err != nil {
+			return err
+		}
+		if _, err := s.client.Do(ctx, "EXPIRE", hourKey+":tenants", ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisUsageStore) Query(ctx context.Context, from, to time.Time, tenantHash string) ([]usageRecord, error) {
+	var records []usageRecord
+	for hour := from.UTC().Truncate(time.Hour); hour.Before(to); hour = hour.Add(time.Hour) {
+		if hour.Before(from) {
+			continue
+		}
+		hourKey := s.hourPrefix(hour)
+		tenants := []string{tenantHash}
+		if tenantHash == "" {
+			reply, err := s.client.Do(ctx, "SMEMBERS", hourKey+":tenants")
+			if err != nil {
+				return nil, err
+			}
+			tenants = redisStrings(reply)
+		}
+		for _, tenant := range tenants {
+			reply, err := s.client.Do(ctx, "HGETALL", hourKey+":"+tenant)
+			if err != nil {
+				return nil, err
+			}
+			fields := redisStrings(reply)
+			if len(fields) == 0 {
+				continue
+			}
+			counters := make(map[string]int64, len(fields)/2)
+			for i := 0; i+1 < len(fields); i += 2 {
+				value, err := strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid usage counter %s: %w", fields[i], err)
+				}
+				counters[fields[i]] = value
+			}
+			records = append(records, usageRecord{TenantHash: tenant, Hour: hour, Counters: counters})
+		}
+	}
+	return records, nil
+}
+
+func redisStrings(reply any) []string {
+	items, _ := reply.([]any)
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		if value, ok := item.(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+var (
+	usageMetersMu   sync.Mutex
+	usageMetersOnce sync.Once
+	usageMeters     *usageMeter
+	usageMetersErr  error
+)
+
+// resetUsageMeter clears the cached meter for tests.
+func resetUsageMeter() {
+	usageMetersMu.Lock()
+	defer usageMetersMu.Unlock()
+	if usageMeters != nil {
+		if store, ok := usageMeters.store.(*redisUsageStore); ok {
+			_ = store.client.Close()
+			forgetRedisClient(redisComponentUsage)
+		}
+	}
+	usageMetersOnce = sync.Once{}
+	usageMeters = nil
+	usageMetersErr = nil
+}
+
+// loadUsageMeter selects the store from GATEWAY_USAGE_STORE. Setting
+// GATEWAY_USAGE_REDIS_URL without a store selects Redis automatically.
+func loadUsageMeter() (*usageMeter, error) {
+	usageMetersMu.Lock()
+	defer usageMetersMu.Unlock()
+	usageMetersOnce.Do(func() {
+		redisURL, err := ResolveEnvValue("GATEWAY_USAGE_REDIS_URL")
+		if err != nil {
+			usageMetersErr = fmt.Errorf("failed to load GATEWAY_USAGE_REDIS_URL: %w", err)
+			return
+		}
+		retention := GetDurationEnv("GATEWAY_USAGE_RETENTION", defaultUsageRetention)
+		backend := strings.ToLower(strings.TrimSpace(os.Getenv("GATEWAY_USAGE_STORE")))
+		if backend == "" && redisURL != "" {
+			backend = "redis"
+		}
+		switch backend {
+		case "", "memory":
+			usageMeters = newUsageMeter(newMemoryUsageStore(retention))
+		case "redis":
+			if redisURL == "" {
+				usageMetersErr = errors.New("GATEWAY_USAGE_STORE=redis requires GATEWAY_USAGE_REDIS_URL")
+				return
+			}
+			client, err := newRedisClient(redisComponentUsage, redisURL, "usage counters are buffered in memory until Redis returns")
+			if err != nil {
+				usageMetersErr = err
+				return
+			}
+			usageMeters = newUsageMeter(&redisUsageStore{
+				client:    client,
+				prefix:    GetEnv("GATEWAY_USAGE_REDIS_KEY_PREFIX", defaultUsageKeyPrefix),
+				retention: retention,
+			})
+		default:
+			usageMetersErr = fmt.Errorf("unsupported GATEWAY_USAGE_STORE %q", backend)
+		}
+	})
+	return usageMeters, usageMetersErr
+}
+
+// recordUsage adds delta to metric for the tenant. Usage is dropped when the
+// meter is misconfigured; NewMaintenanceScheduler reports that at startup.
+func recordUsage(tenantHash, metric string, delta int64) {
+	meter, err := loadUsageMeter()
+	if err != nil {
+		return
+	}
+	meter.add(tenantHash, metric, delta)
+}
+
+// usageTenantHash attributes a proxied request to the tenant in the request
+// scope of ctx. Routes that do not resolve a session fall back to the tenant
+// named in the X-Tenant-Id header.
+func usageTenantHash(ctx context.Context, r *http.Request) string {
+	if hash := requestScopeFrom(ctx).tenantHash(); hash != "" {
+		return hash
+	}
+	tenant, err := normalizeTenantID(strings.TrimSpace(r.Header.Get("X-Tenant-Id")))
+	if err != nil {
+		return ""
+	}
+	return hashTenantID(tenant)
+}
+
+// registerUsageJobs validates the usage configuration and schedules the
+// periodic flush, which also runs once on shutdown, and the export when
+// GATEWAY_USAGE_EXPORT_URL is set.
+func registerUsageJobs(s *Scheduler) error {
+	meter, err := loadUsageMeter()
+	if err != nil {
+		return err
+	}
+	s.register("usage_flush", GetDurationEnv("GATEWAY_USAGE_FLUSH_INTERVAL", defaultUsageFlushInterval),
+		meter.flush, jobOptions{runOnStop: true})
+
+	exporter, err := loadUsageExporter()
+	if err != nil || exporter == nil {
+		return err
+	}
+	s.register("usage_export", GetDurationEnv("GATEWAY_USAGE_EXPORT_INTERVAL", defaultUsageExportInterval),
+		exporter.exportPending, jobOptions{})
+	return nil
+}
+
+type usageTenantReport struct {
+	TenantHash string           `json:"tenant_hash"`
+	Counters   map[string]int64 `json:"counters"`
+}
+
+type usageResponse struct {
+	From    time.Time           `json:"from"`
+	To      time.Time           `json:"to"`
+	Tenants []usageTenantReport `json:"tenants"`
+}
+
+// writeUsageResponse serves /admin/usage. from and to are RFC 3339 times and
+// default to the start of the current UTC month and now. tenant_id filters to
+// one tenant.
+func writeUsageResponse(w http.ResponseWriter, r *http.Request) {
+	meter, err := loadUsageMeter()
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusServiceUnavailable, "service_unavailable", "usage metering is not configured", nil)
+		return
+	}
+	now := meter.now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := now
+	var errs []validationError
+	query := r.URL.Query()
+	if raw := strings.TrimSpace(query.Get("from")); raw != "" {
+		if from, err = time.Parse(time.RFC3339, raw); err != nil {
+			errs = append(errs, validationError{Field: "from", Message: "from must be an RFC 3339 timestamp"})
+		}
+	}
+	if raw := strings.TrimSpace(query.Get("to")); raw != "" {
+		if to, err = time.Parse(time.RFC3339, raw); err != nil {
+			errs = append(errs, validationError{Field: "to", Message: "to must be an RFC 3339 timestamp"})
+		}
+	}
+	if len(errs) == 0 && !to.After(from) {
+		errs = append(errs, validationError{Field: "to", Message: "to must be after from"})
+	}
+	if len(errs) == 0 && to.Sub(from) > maxUsageQueryRange {
+		errs = append(errs, validationError{Field: "to", Message: "time range must not exceed 366 days"})
+	}
+	tenantID, tenantErr := normalizeTenantID(query.Get("tenant_id"))
+	if tenantErr != nil {
+		errs = append(errs, validationError{Field: "tenant_id", Message: tenantValidationErrorMessage})
+	}
+	if len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	// Counters are hourly, so the range is widened to whole hours.
+	from = from.UTC().Truncate(time.Hour)
+	if truncated := to.UTC().Truncate(time.Hour); !truncated.Equal(to) {
+		to = truncated.Add(time.Hour)
+	}
+	records, err := meter.query(r.Context(), from, to, hashTenantID(tenantID))
+	if err != nil {
+		slog.WarnContext(r.Context(), "admin usage query failed", slog.Any("error", err))
+		writeErrorResponse(w, r, http.StatusBadGateway, "upstream_error", "failed to read usage store", nil)
+		return
+	}
+
+	totals := make(map[string]map[string]int64)
+	for _, record := range records {
+		counters := totals[record.TenantHash]
+		if counters == nil {
+			counters = make(map[string]int64)
+			totals[record.TenantHash] = counters
+		}
+		for metric, value := range record.Counters {
+			counters[metric] += value
+		}
+	}
+	resp := usageResponse{From: from, To: to.UTC(), Tenants: make([]usageTenantReport, 0, len(totals))}
+	for tenant, counters := range totals {
+		resp.Tenants = append(resp.Tenants, usageTenantReport{TenantHash: tenant, Counters: counters})
+	}
+	sort.Slice(resp.Tenants, func(i, j int) bool { return resp.Tenants[i].TenantHash < resp.Tenants[j].TenantHash })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,425 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultUsageExportInterval = 5 * time.Minute
+	defaultUsageExportDelay    = 5 * time.Minute
+	defaultUsageExportLookback = 24 * time.Hour
+	// defaultUsageExportCorrectionWindow is how long after sealing an hour
+	// the exporter still compares it with the usage store.
+	defaultUsageExportCorrectionWindow = 6 * time.Hour
+)
+
+var errUsagePartitionCorrupt = errors.New("usage partition is corrupt")
+
+// usageExportLine is one row of an exported partition. Seq starts at 1 and
+// increases by one per row so consumers can detect truncated partitions.
+// Rows of a correction partition carry its number and hold the change to
+// each counter, which may be negative.
+type usageExportLine struct {
+	Seq        int64            `json:"seq"`
+	Hour       time.Time        `json:"hour"`
+	Correction int              `json:"correction,omitempty"`
+	TenantHash string           `json:"tenant_hash"`
+	Counters   map[string]int64 `json:"counters"`
+}
+
+// usageExportMarker is written after its partition and tells consumers the
+// partition is complete. Consumers should ignore partitions without one.
+type usageExportMarker struct {
+	Hour       time.Time `json:"hour"`
+	Correction int       `json:"correction,omitempty"`
+	Records    int64     `json:"records"`
+	SHA256     string    `json:"sha256"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// usageExportKeys returns the partition and marker keys for an hour.
+func usageExportKeys(hour time.Time) (string, string) {
+	base := usageExportBase(hour)
+	return base + ".jsonl", base + ".marker.json"
+}
+
+// usageCorrectionKeys returns the keys of the nth correction of an hour.
+func usageCorrectionKeys(hour time.Time, n int) (string, string) {
+	base := usageExportBase(hour) + ".correction-" + strconv.Itoa(n)
+	return base + ".jsonl", base + ".marker.json"
+}
+
+func usageExportBase(hour time.Time) string {
+	return "usage/" + hour.UTC().Format("2006-01-02") + "/" + hour.UTC().Format("15")
+}
+
+// encodeUsagePartition renders records as JSON lines ordered by tenant hash
+// so the same store contents always produce the same bytes. correction is 0
+// for the hour's partition itself.
+func encodeUsagePartition(hour time.Time, correction int, records []usageRecord) ([]byte, int64) {
+	sort.Slice(records, func(i, j int) bool { return records[i].TenantHash < records[j].TenantHash })
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	var seq int64
+	for _, record := range records {
+		seq++
+		_ = encoder.Encode(usageExportLine{Seq: seq, Hour: hour.UTC(), Correction: correction, TenantHash: record.TenantHash, Counters: record.Counters})
+	}
+	return buf.Bytes(), seq
+}
+
+// usageTotals maps tenant hashes to their counters.
+type usageTotals map[string]map[string]int64
+
+// addPartition adds the rows of a verified partition.
+func (t usageTotals) addPartition(data []byte) error {
+	for _, raw := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		if len(raw) == 0 {
+			continue
+		}
+		var line usageExportLine
+		if err := json.Unmarshal(raw, &line); err != nil {
+			return err
+		}
+		counters := t[line.TenantHash]
+		if counters == nil {
+			counters = make(map[string]int64, len(line.Counters))
+			t[line.TenantHash] = counters
+		}
+		for name, value := range line.Counters {
+			counters[name] += value
+		}
+	}
+	return nil
+}
+
+// diff returns, per tenant, the counters of records that differ from t.
+// Counters t holds but records lack come back negated.
+func (t usageTotals) diff(records []usageRecord) []usageRecord {
+	current := make(usageTotals, len(records))
+	for _, record := range records {
+		current[record.TenantHash] = record.Counters
+	}
+	tenants := make(map[string]struct{}, len(t)+len(current))
+	for tenant := range t {
+		tenants[tenant] = struct{}{}
+	}
+	for tenant := range current {
+		tenants[tenant] = struct{}{}
+	}
+	var deltas []usageRecord
+	for tenant := range tenants {
+		changed := make(map[string]int64)
+		for name, value := range current[tenant] {
+			if delta := value - t[tenant][name]; delta != 0 {
+				changed[name] = delta
+			}
+		}
+		for name, value := range t[tenant] {
+			if _, ok := current[tenant][name]; !ok && value != 0 {
+				changed[name] = -value
+			}
+		}
+		if len(changed) > 0 {
+			deltas = append(deltas, usageRecord{TenantHash: tenant, Counters: changed})
+		}
+	}
+	return deltas
+}
+
+// verifyUsagePartition checks that data holds contiguous sequence numbers for
+// hour and returns the row count.
+func verifyUsagePartition(hour time.Time, data []byte) (int64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), maxExportObjectBytes)
+	var seq int64
+	for scanner.Scan() {
+		var line usageExportLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return seq, fmt.Errorf("row %d: %w", seq+1, err)
+		}
+		seq++
+		if line.Seq != seq {
+			return seq, fmt.Errorf("expected sequence %d, got %d", seq, line.Seq)
+		}
+		if !line.Hour.Equal(hour) {
+			return seq, fmt.Errorf("row %d belongs to %s", seq, line.Hour.Format(time.RFC3339))
+		}
+	}
+	return seq, scanner.Err()
+}
+
+// usageExporter writes each closed hour of usage to the sink exactly once.
+// An hour is closed once delay has passed since it ended, which must leave
+// every replica time to flush its counters.
+type usageExporter struct {
+	store    usageStore
+	sink     exportSink
+	delay    time.Duration
+	lookback time.Duration
+	// correctionWindow is how long sealed hours are compared with the store
+	// for usage flushed after the export; 0 disables corrections.
+	correctionWindow time.Duration
+	now              func() time.Time
+	// next is the first hour not yet known to be exported.
+	next time.Time
+}
+
+// loadUsageExporter returns nil when GATEWAY_USAGE_EXPORT_URL is unset.
+func loadUsageExporter() (*usageExporter, error) {
+	rawURL := GetEnv("GATEWAY_USAGE_EXPORT_URL", "")
+	if rawURL == "" {
+		return nil, nil
+	}
+	meter, err := loadUsageMeter()
+	if err != nil {
+		return nil, err
+	}
+	sink, err := newExportSink(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	delay := GetDurationEnv("GATEWAY_USAGE_EXPORT_DELAY", defaultUsageExportDelay)
+	if flush := GetDurationEnv("GATEWAY_USAGE_FLUSH_INTERVAL", defaultUsageFlushInterval); delay <= flush {
+		return nil, fmt.Errorf("GATEWAY_USAGE_EXPORT_DELAY (%s) must be longer than GATEWAY_USAGE_FLUSH_INTERVAL (%s)", delay, flush)
+	}
+	correctionWindow := GetDurationEnv("GATEWAY_USAGE_EXPORT_CORRECTION_WINDOW", defaultUsageExportCorrectionWindow)
+	if retention := GetDurationEnv("GATEWAY_USAGE_RETENTION", defaultUsageRetention); correctionWindow < 0 || correctionWindow >= retention {
+		return nil, fmt.Errorf("GATEWAY_USAGE_EXPORT_CORRECTION_WINDOW must be between 0 and GATEWAY_USAGE_RETENTION (%s)", retention)
+	}
+	return &usageExporter{
+		store:            meter.store,
+		sink:             sink,
+		delay:            delay,
+		lookback:         GetDurationEnv("GATEWAY_USAGE_EXPORT_LOOKBACK", defaultUsageExportLookback),
+		correctionWindow: correctionWindow,
+		now:              time.Now,
+	}, nil
+}
+
+// closedBefore returns the end of the most recent closed hour.
+func (e *usageExporter) closedBefore() time.Time {
+	return e.now().UTC().Add(-e.delay).Truncate(time.Hour)
+}
+
+// exportPending exports every closed hour within the lookback window that has
+// no marker yet, oldest first, stopping at the first failure. It then writes
+// corrections for the hours sealed within the correction window.
+func (e *usageExporter) exportPending(ctx context.Context) error {
+	end := e.closedBefore()
+	start := end.Add(-e.lookback).Truncate(time.Hour)
+	if e.next.After(start) {
+		start = e.next
+	}
+	for hour := start; hour.Before(end); hour = hour.Add(time.Hour) {
+		if err := e.exportHour(ctx, hour); err != nil {
+			return fmt.Errorf("export usage for %s: %w", hour.Format(time.RFC3339), err)
+		}
+		e.next = hour.Add(time.Hour)
+	}
+	if e.correctionWindow <= 0 {
+		return nil
+	}
+	for hour := end.Add(-e.correctionWindow).Truncate(time.Hour); hour.Before(end); hour = hour.Add(time.Hour) {
+		if err := e.correctHour(ctx, hour); err != nil {
+			return fmt.Errorf("correct usage for %s: %w", hour.Format(time.RFC3339), err)
+		}
+	}
+	return nil
+}
+
+func (e *usageExporter) exportHour(ctx context.Context, hour time.Time) error {
+	objectKey, markerKey := usageExportKeys(hour)
+	if _, err := e.sink.Get(ctx, markerKey); err == nil {
+		return nil
+	} else if !errors.Is(err, errExportObjectNotFound) {
+		return err
+	}
+
+	records, err := e.store.Query(ctx, hour, hour.Add(time.Hour), "")
+	if err != nil {
+		return err
+	}
+	payload, count := encodeUsagePartition(hour, 0, records)
+	return e.writePartition(ctx, hour, 0, objectKey, markerKey, payload, count)
+}
+
+// correctHour writes the next correction of a sealed hour when the store no
+// longer matches what was exported for it, usually because a replica flushed
+// after the export delay. Hours not sealed yet are left to exportHour.
+func (e *usageExporter) correctHour(ctx context.Context, hour time.Time) error {
+	exported, corrections, err := e.exportedUsage(ctx, hour)
+	if errors.Is(err, errExportObjectNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	records, err := e.store.Query(ctx, hour, hour.Add(time.Hour), "")
+	if err != nil {
+		return err
+	}
+	deltas := exported.diff(records)
+	if len(deltas) == 0 {
+		return nil
+	}
+	next := corrections + 1
+	objectKey, markerKey := usageCorrectionKeys(hour, next)
+	payload, count := encodeUsagePartition(hour, next, deltas)
+	return e.writePartition(ctx, hour, next, objectKey, markerKey, payload, count)
+}
+
+// exportedUsage sums the sealed partition of hour and its sealed corrections
+// and reports how many corrections there are. It returns
+// errExportObjectNotFound when the hour is not sealed and an error wrapping
+// errUsagePartitionCorrupt when a partition does not match its marker.
+func (e *usageExporter) exportedUsage(ctx context.Context, hour time.Time) (usageTotals, int, error) {
+	totals := make(usageTotals)
+	for n := 0; ; n++ {
+		objectKey, markerKey := usageExportKeys(hour)
+		if n > 0 {
+			objectKey, markerKey = usageCorrectionKeys(hour, n)
+		}
+		rawMarker, err := e.sink.Get(ctx, markerKey)
+		if errors.Is(err, errExportObjectNotFound) && n > 0 {
+			return totals, n - 1, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+		payload, err := e.sink.Get(ctx, objectKey)
+		if errors.Is(err, errExportObjectNotFound) {
+			return nil, 0, fmt.Errorf("%w: %s has a marker but no data", errUsagePartitionCorrupt, objectKey)
+		} else if err != nil {
+			return nil, 0, err
+		}
+		var marker usageExportMarker
+		if err := json.Unmarshal(rawMarker, &marker); err != nil || marker.SHA256 != sha256Hex(payload) {
+			return nil, 0, fmt.Errorf("%w: %s does not match its marker", errUsagePartitionCorrupt, objectKey)
+		}
+		if count, err := verifyUsagePartition(hour, payload); err != nil || count != marker.Records {
+			return nil, 0, fmt.Errorf("%w: %s has missing rows", errUsagePartitionCorrupt, objectKey)
+		}
+		if err := totals.addPartition(payload); err != nil {
+			return nil, 0, fmt.Errorf("%w: %s: %v", errUsagePartitionCorrupt, objectKey, err)
+		}
+	}
+}
+
+// writePartition writes payload under objectKey once and seals it with a
+// marker under markerKey.
+func (e *usageExporter) writePartition(ctx context.Context, hour time.Time, correction int, objectKey, markerKey string, payload []byte, count int64) error {
+	if err := e.sink.Put(ctx, objectKey, payload); errors.Is(err, errExportObjectExists) {
+		// An earlier attempt stopped before its marker. The partition is
+		// write-once, so seal what was written and flag any difference.
+		existing, err := e.sink.Get(ctx, objectKey)
+		if err != nil {
+			return err
+		}
+		if sha256Hex(existing) != sha256Hex(payload) {
+			slog.WarnContext(ctx, "gateway.usage.export_drift", slog.String("partition", objectKey))
+		}
+		if count, err = verifyUsagePartition(hour, existing); err != nil {
+			return fmt.Errorf("existing partition %s is corrupt: %w", objectKey, err)
+		}
+		payload = existing
+	} else if err != nil {
+		return err
+	}
+
+	marker, err := json.Marshal(usageExportMarker{
+		Hour:       hour.UTC(),
+		Correction: correction,
+		Records:    count,
+		SHA256:     sha256Hex(payload),
+		ExportedAt: e.now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := e.sink.Put(ctx, markerKey, marker); err != nil && !errors.Is(err, errExportObjectExists) {
+		return err
+	}
+	return nil
+}
+
+// UsageExportReport lists the problems ReconcileUsageExport found in an
+// exported range.
+type UsageExportReport struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Verified int       `json:"verified"`
+	// Missing hours have neither a partition nor a marker.
+	Missing []time.Time `json:"missing,omitempty"`
+	// Unsealed hours have a partition but no marker.
+	Unsealed []time.Time `json:"unsealed,omitempty"`
+	// Corrupt hours have a partition that does not match its marker or has
+	// gaps in its sequence numbers.
+	Corrupt []time.Time `json:"corrupt,omitempty"`
+	// Drifted hours were exported but the usage store now holds different
+	// counters than the partition and its corrections, usually because a
+	// replica flushed after the export delay and no correction followed yet.
+	Drifted []time.Time `json:"drifted,omitempty"`
+}
+
+// OK reports whether every hour in the range was exported intact.
+func (r UsageExportReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Unsealed) == 0 && len(r.Corrupt) == 0 && len(r.Drifted) == 0
+}
+
+// ReconcileUsageExport checks every hour in [from, to) of the configured
+// export for gaps. With checkStore it also compares each partition with the
+// current contents of the usage store.
+func ReconcileUsageExport(ctx context.Context, from, to time.Time, checkStore bool) (UsageExportReport, error) {
+	exporter, err := loadUsageExporter()
+	if err != nil {
+		return UsageExportReport{}, err
+	}
+	if exporter == nil {
+		return UsageExportReport{}, errors.New("GATEWAY_USAGE_EXPORT_URL is not set")
+	}
+	return exporter.reconcile(ctx, from, to, checkStore)
+}
+
+func (e *usageExporter) reconcile(ctx context.Context, from, to time.Time, checkStore bool) (UsageExportReport, error) {
+	report := UsageExportReport{From: from.UTC().Truncate(time.Hour), To: to.UTC()}
+	for hour := report.From; hour.Before(report.To); hour = hour.Add(time.Hour) {
+		exported, _, err := e.exportedUsage(ctx, hour)
+		switch {
+		case errors.Is(err, errExportObjectNotFound):
+			objectKey, _ := usageExportKeys(hour)
+			if _, objectErr := e.sink.Get(ctx, objectKey); objectErr == nil {
+				report.Unsealed = append(report.Unsealed, hour)
+			} else if errors.Is(objectErr, errExportObjectNotFound) {
+				report.Missing = append(report.Missing, hour)
+			} else {
+				return report, objectErr
+			}
+			continue
+		case errors.Is(err, errUsagePartitionCorrupt):
+			report.Corrupt = append(report.Corrupt, hour)
+			continue
+		case err != nil:
+			return report, err
+		}
+		report.Verified++
+
+		if checkStore {
+			records, err := e.store.Query(ctx, hour, hour.Add(time.Hour), "")
+			if err != nil {
+				return report, err
+			}
+			if len(exported.diff(records)) > 0 {
+				report.Drifted = append(report.Drifted, hour)
+			}
+		}
+	}
+	return report, nil
+}
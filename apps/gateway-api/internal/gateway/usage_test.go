@@ -0,0 +1,213 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage/storagetest"
+)
+
+type failingUsageStore struct {
+	*memoryUsageStore
+	err error
+}
+
+func (s *failingUsageStore) Add(ctx context.Context, records []usageRecord) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.memoryUsageStore.Add(ctx, records)
+}
+
+func TestUsageMeterFlushesHourlyCounters(t *testing.T) {
+	store := newMemoryUsageStore(defaultUsageRetention)
+	meter := newUsageMeter(store)
+	now := time.Date(2026, 3, 4, 10, 15, 0, 0, time.UTC)
+	meter.now = func() time.Time { return now }
+
+	meter.add("tenant-a", usageMetricAuthEvents, 1)
+	meter.add("tenant-a", usageMetricAuthEvents, 2)
+	meter.add("", usageMetricProxiedRequests, 1)
+	now = now.Add(time.Hour)
+	meter.add("tenant-a", usageMetricSSESeconds, 30)
+	meter.add("tenant-a", usageMetricSSESeconds, 0)
+
+	if err := meter.flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records, _ := store.Query(context.Background(), now.Add(-2*time.Hour), now.Add(time.Hour), "")
+	if len(records) != 3 {
+		t.Fatalf("expected three hourly records, got %+v", records)
+	}
+	byTenantHour := map[string]map[string]int64{}
+	for _, record := range records {
+		byTenantHour[record.TenantHash+"@"+record.Hour.Format("15")] = record.Counters
+	}
+	if byTenantHour["tenant-a@10"][usageMetricAuthEvents] != 3 {
+		t.Fatalf("expected auth events to be summed, got %+v", byTenantHour)
+	}
+	if byTenantHour["tenant-a@11"][usageMetricSSESeconds] != 30 {
+		t.Fatalf("expected sse seconds in the next hour, got %+v", byTenantHour)
+	}
+	if byTenantHour[usageUnattributed+"@10"][usageMetricProxiedRequests] != 1 {
+		t.Fatalf("expected unattributed usage, got %+v", byTenantHour)
+	}
+}
+
+func TestUsageMeterRetainsCountersWhenFlushFails(t *testing.T) {
+	store := &failingUsageStore{memoryUsageStore: newMemoryUsageStore(defaultUsageRetention), err: errors.New("down")}
+	meter := newUsageMeter(store)
+
+	meter.add("tenant-a", usageMetricAuthEvents, 2)
+	if err := meter.flush(context.Background()); err == nil {
+		t.Fatal("expected flush error")
+	}
+	meter.add("tenant-a", usageMetricAuthEvents, 1)
+
+	// Unflushed counters are still visible to queries.
+	now := time.Now()
+	records, err := meter.query(context.Background(), now.Add(-time.Hour), now.Add(time.Hour), "tenant-a")
+	if err != nil || len(records) != 1 || records[0].Counters[usageMetricAuthEvents] != 3 {
+		t.Fatalf("expected pending counters to be merged, got %+v, %v", records, err)
+	}
+
+	store.err = nil
+	if err := meter.flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records, _ = store.Query(context.Background(), now.Add(-time.Hour), now.Add(time.Hour), "")
+	if len(records) != 1 || records[0].Counters[usageMetricAuthEvents] != 3 {
+		t.Fatalf("expected retried counters to be stored once, got %+v", records)
+	}
+}
+
+func TestMemoryUsageStorePrunesExpiredHours(t *testing.T) {
+	store := newMemoryUsageStore(24 * time.Hour)
+	now := time.Date(2026, 3, 4, 10, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	old := usageRecord{TenantHash: "tenant-a", Hour: now.Add(-48 * time.Hour), Counters: map[string]int64{usageMetricAuthEvents: 1}}
+	_ = store.Add(context.Background(), []usageRecord{old})
+	_ = store.Add(context.Background(), []usageRecord{{TenantHash: "tenant-b", Hour: now, Counters: map[string]int64{usageMetricAuthEvents: 1}}})
+
+	records, _ := store.Query(context.Background(), now.Add(-72*time.Hour), now.Add(time.Hour), "")
+	if len(records) != 1 || records[0].TenantHash != "tenant-b" {
+		t.Fatalf("expected expired hour to be pruned, got %+v", records)
+	}
+}
+
+func TestRedisUsageStoreRoundTrip(t *testing.T) {
+	redis := storagetest.NewFakeRedis(t)
+	client, err := storage.NewRedisClient(redis.URL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	store := &redisUsageStore{client: client, prefix: "test:usage", retention: time.Hour}
+
+	hour := time.Date(2026, 3, 4, 10, 0, 0, 0, time.UTC)
+	records := []usageRecord{
+		{TenantHash: "tenant-a", Hour: hour, Counters: map[string]int64{usageMetricAuthEvents: 2}},
+		{TenantHash: "tenant-b", Hour: hour.Add(time.Hour), Counters: map[string]int64{usageMetricProxiedRequests: 5}},
+	}
+	if err := store.Add(context.Background(), records); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Add(context.Background(), records[:1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	all, err := store.Query(context.Background(), hour, hour.Add(2*time.Hour), "")
+	if err != nil || len(all) != 2 {
+		t.Fatalf("expected both hours, got %+v, %v", all, err)
+	}
+	filtered, err := store.Query(context.Background(), hour, hour.Add(2*time.Hour), "tenant-a")
+	if err != nil || len(filtered) != 1 || filtered[0].Counters[usageMetricAuthEvents] != 4 {
+		t.Fatalf("expected incremented tenant counters, got %+v, %v", filtered, err)
+	}
+}
+
+func TestLoadUsageMeterRejectsInvalidConfig(t *testing.T) {
+	t.Setenv("GATEWAY_USAGE_STORE", "dynamo")
+	t.Setenv("GATEWAY_USAGE_REDIS_URL", "")
+	resetUsageMeter()
+	t.Cleanup(resetUsageMeter)
+	if _, err := loadUsageMeter(); err == nil {
+		t.Fatal("expected configuration error")
+	}
+	if _, err := NewMaintenanceScheduler(); err == nil {
+		t.Fatal("expected NewMaintenanceScheduler to surface the configuration error")
+	}
+}
+
+func getUsage(t *testing.T, mux *http.ServeMux, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/admin/usage"+query, nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminUsageReportsTenantTotals(t *testing.T) {
+	t.Setenv("GATEWAY_USAGE_STORE", "memory")
+	t.Setenv("GATEWAY_USAGE_REDIS_URL", "")
+	resetUsageMeter()
+	t.Cleanup(resetUsageMeter)
+
+	acme := hashTenantID("acme")
+	recordUsage(acme, usageMetricAuthEvents, 2)
+	recordUsage(acme, usageMetricProxiedRequests, 1)
+	recordUsage(hashTenantID("globex"), usageMetricAuthEvents, 1)
+
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+
+	rec := getUsage(t, mux, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp usageResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(resp.Tenants) != 2 || resp.From.Day() != 1 {
+		t.Fatalf("unexpected usage report %+v", resp)
+	}
+
+	rec = getUsage(t, mux, "?tenant_id=acme")
+	resp = usageResponse{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Tenants) != 1 || resp.Tenants[0].TenantHash != acme {
+		t.Fatalf("expected only acme usage, got %+v", resp)
+	}
+	if counters := resp.Tenants[0].Counters; counters[usageMetricAuthEvents] != 2 || counters[usageMetricProxiedRequests] != 1 {
+		t.Fatalf("unexpected counters %+v", counters)
+	}
+}
+
+func TestAdminUsageValidatesRange(t *testing.T) {
+	t.Setenv("GATEWAY_USAGE_STORE", "memory")
+	resetUsageMeter()
+	t.Cleanup(resetUsageMeter)
+
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, AdminRouteConfig{Token: "s3cret"})
+
+	for _, query := range []string{
+		"?from=yesterday",
+		"?from=2026-03-02T00:00:00Z&to=2026-03-01T00:00:00Z",
+		"?from=2024-01-01T00:00:00Z&to=2026-01-01T00:00:00Z",
+		"?tenant_id=" + "bad%20tenant",
+	} {
+		if rec := getUsage(t, mux, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got %d", query, rec.Code)
+		}
+	}
+}
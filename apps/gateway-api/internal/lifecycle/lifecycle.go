@@ -0,0 +1,171 @@
+// Package lifecycle starts and stops the gateway's subsystems in dependency
+// order. A component starts after everything it depends on and stops before
+// any of them, so servers stop accepting requests before the stores and
+// flushers behind them shut down.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const defaultStopTimeout = 10 * time.Second
+
+// Component is one subsystem. Start must return once the component is ready;
+// long-running work belongs in goroutines that Stop winds down. Either hook
+// may be nil.
+type Component struct {
+	Name      string
+	DependsOn []string
+	Start     func(ctx context.Context) error
+	Stop      func(ctx context.Context) error
+	// StopTimeout bounds Stop; zero means 10s.
+	StopTimeout time.Duration
+}
+
+// Manager owns the registered components.
+type Manager struct {
+	mu         sync.Mutex
+	components []Component
+	started    []Component
+	failed     chan error
+	failOnce   sync.Once
+}
+
+func New() *Manager {
+	return &Manager{failed: make(chan error, 1)}
+}
+
+// Register adds a component. Names must be unique; dependencies may be
+// registered later but must exist by the time Start runs.
+func (m *Manager) Register(component Component) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if component.Name == "" {
+		return errors.New("lifecycle: component name is required")
+	}
+	for _, existing := range m.components {
+		if existing.Name == component.Name {
+			return fmt.Errorf("lifecycle: duplicate component %q", component.Name)
+		}
+	}
+	m.components = append(m.components, component)
+	return nil
+}
+
+// order returns the components with every dependency ahead of its
+// dependants, keeping registration order otherwise.
+func (m *Manager) order() ([]Component, error) {
+	byName := make(map[string]Component, len(m.components))
+	for _, component := range m.components {
+		byName[component.Name] = component
+	}
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(m.components))
+	ordered := make([]Component, 0, len(m.components))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("lifecycle: dependency cycle %v", append(path, name))
+		}
+		component, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("lifecycle: %q depends on unknown component %q", path[len(path)-1], name)
+		}
+		state[name] = visiting
+		for _, dependency := range component.DependsOn {
+			if err := visit(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		ordered = append(ordered, component)
+		return nil
+	}
+	for _, component := range m.components {
+		if err := visit(component.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Start starts every component in dependency order. If one fails, the
+// components already started are stopped again and the error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	ordered, err := m.order()
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, component := range ordered {
+		if component.Start != nil {
+			if err := component.Start(ctx); err != nil {
+				startErr := fmt.Errorf("start %s: %w", component.Name, err)
+				if stopErr := m.Stop(context.WithoutCancel(ctx)); stopErr != nil {
+					return errors.Join(startErr, stopErr)
+				}
+				return startErr
+			}
+		}
+		m.mu.Lock()
+		m.started = append(m.started, component)
+		m.mu.Unlock()
+		slog.DebugContext(ctx, "lifecycle.component_started", slog.String("component", component.Name))
+	}
+	return nil
+}
+
+// Stop stops started components in reverse start order, giving each its own
+// timeout. Every component is stopped even if an earlier one fails; the
+// errors are joined. Stop is safe to call more than once.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	started := m.started
+	m.started = nil
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(started) - 1; i >= 0; i-- {
+		component := started[i]
+		if component.Stop == nil {
+			continue
+		}
+		timeout := component.StopTimeout
+		if timeout <= 0 {
+			timeout = defaultStopTimeout
+		}
+		stopCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := component.Stop(stopCtx)
+		cancel()
+		if err != nil {
+			slog.WarnContext(ctx, "lifecycle.component_stop_failed", slog.String("component", component.Name), slog.Any("error", err))
+			errs = append(errs, fmt.Errorf("stop %s: %w", component.Name, err))
+			continue
+		}
+		slog.DebugContext(ctx, "lifecycle.component_stopped", slog.String("component", component.Name))
+	}
+	return errors.Join(errs...)
+}
+
+// Fail reports that a running component died, for example a server whose
+// listener failed. Only the first failure is kept.
+func (m *Manager) Fail(err error) {
+	m.failOnce.Do(func() { m.failed <- err })
+}
+
+// Failed delivers the first error passed to Fail.
+func (m *Manager) Failed() <-chan error {
+	return m.failed
+}
@@ -0,0 +1,120 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func recordingComponent(name string, log *[]string, deps ...string) Component {
+	return Component{
+		Name:      name,
+		DependsOn: deps,
+		Start: func(context.Context) error {
+			*log = append(*log, "start "+name)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			*log = append(*log, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestManagerStartsDependenciesFirstAndStopsInReverse(t *testing.T) {
+	var log []string
+	m := New()
+	// Registered out of order on purpose.
+	_ = m.Register(recordingComponent("http", &log, "maintenance"))
+	_ = m.Register(recordingComponent("maintenance", &log, "storage"))
+	_ = m.Register(recordingComponent("storage", &log))
+
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "start storage,start maintenance,start http,stop http,stop maintenance,stop storage"
+	if got := strings.Join(log, ","); got != want {
+		t.Fatalf("unexpected order\n got: %s\nwant: %s", got, want)
+	}
+	if err := m.Stop(context.Background()); err != nil || len(log) != 6 {
+		t.Fatal("expected a second Stop to be a no-op")
+	}
+}
+
+func TestManagerRollsBackOnStartFailure(t *testing.T) {
+	var log []string
+	m := New()
+	_ = m.Register(recordingComponent("storage", &log))
+	_ = m.Register(Component{
+		Name:      "http",
+		DependsOn: []string{"storage"},
+		Start:     func(context.Context) error { return errors.New("address in use") },
+	})
+
+	err := m.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "start http: address in use") {
+		t.Fatalf("expected start error, got %v", err)
+	}
+	if got := strings.Join(log, ","); got != "start storage,stop storage" {
+		t.Fatalf("expected started components to be stopped, got %s", got)
+	}
+}
+
+func TestManagerRejectsInvalidGraphs(t *testing.T) {
+	m := New()
+	_ = m.Register(Component{Name: "a", DependsOn: []string{"b"}})
+	_ = m.Register(Component{Name: "b", DependsOn: []string{"a"}})
+	if err := m.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+
+	m = New()
+	_ = m.Register(Component{Name: "a", DependsOn: []string{"missing"}})
+	if err := m.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "unknown component") {
+		t.Fatalf("expected unknown dependency error, got %v", err)
+	}
+
+	if err := m.Register(Component{Name: "a"}); err == nil {
+		t.Fatal("expected duplicate name to be rejected")
+	}
+}
+
+func TestManagerStopAppliesTimeoutAndContinues(t *testing.T) {
+	var log []string
+	m := New()
+	_ = m.Register(recordingComponent("storage", &log))
+	_ = m.Register(Component{
+		Name:        "stuck",
+		DependsOn:   []string{"storage"},
+		StopTimeout: 10 * time.Millisecond,
+		Stop: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := m.Stop(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if log[len(log)-1] != "stop storage" {
+		t.Fatalf("expected later components to stop after a timeout, got %v", log)
+	}
+}
+
+func TestManagerKeepsFirstFailure(t *testing.T) {
+	m := New()
+	m.Fail(errors.New("first"))
+	m.Fail(errors.New("second"))
+	if err := <-m.Failed(); err.Error() != "first" {
+		t.Fatalf("expected first failure, got %v", err)
+	}
+}
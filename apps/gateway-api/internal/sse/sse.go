@@ -0,0 +1,257 @@
+// Package sse reads and writes text/event-stream data as described by the
+// HTML Living Standard. The Decoder follows the standard's parsing rules
+// (CR, LF and CRLF line endings, multi-line data, comments, retry and the
+// NUL-in-id rule) on arbitrarily chunked input; the Encoder writes events
+// that a conforming client parses back into the same fields.
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxLineBytes bounds a single line read by a Decoder.
+const DefaultMaxLineBytes = 64 << 10
+
+// ErrLineTooLong is returned when a line exceeds the Decoder's limit.
+var ErrLineTooLong = errors.New("sse: line too long")
+
+// Event is one dispatched event. Data lines are joined with "\n". ID is the
+// last event ID in effect after the event, which EventSource reports even for
+// events that did not carry an id field. Retry is zero unless the event set
+// a reconnection time. Comments holds comment lines seen since the previous
+// event, without the leading colon.
+type Event struct {
+	Type     string
+	ID       string
+	Data     string
+	Retry    time.Duration
+	Comments []string
+}
+
+// Name returns the event type, defaulting to "message" as EventSource does.
+func (e Event) Name() string {
+	if e.Type == "" {
+		return "message"
+	}
+	return e.Type
+}
+
+// Decoder parses events from a stream.
+type Decoder struct {
+	r            *bufio.Reader
+	maxLineBytes int
+	lastID       string
+	skipLF       bool
+	started      bool
+	line         []byte
+}
+
+// NewDecoder returns a Decoder reading from r with DefaultMaxLineBytes.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), maxLineBytes: DefaultMaxLineBytes}
+}
+
+// SetMaxLineBytes changes the line length limit; n <= 0 restores the default.
+func (d *Decoder) SetMaxLineBytes(n int) {
+	if n <= 0 {
+		n = DefaultMaxLineBytes
+	}
+	d.maxLineBytes = n
+}
+
+// SetLastEventID seeds the last event ID, for example from the
+// Last-Event-ID a stream was resumed with.
+func (d *Decoder) SetLastEventID(id string) {
+	d.lastID = id
+}
+
+// LastEventID returns the last event ID in effect.
+func (d *Decoder) LastEventID() string {
+	return d.lastID
+}
+
+// Decode returns the next event. Blocks with no data, like a block of only
+// comments or a retry field, are not dispatched; their comments and retry
+// carry over to the next event. At the end of the stream an incomplete event
+// is discarded and io.EOF is returned.
+func (d *Decoder) Decode() (Event, error) {
+	var (
+		event   Event
+		data    strings.Builder
+		hasData bool
+		pending = d.lastID
+	)
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return Event{}, err
+		}
+		if len(line) == 0 {
+			d.lastID = pending
+			if !hasData {
+				event.Type = ""
+				continue
+			}
+			event.ID = d.lastID
+			event.Data = data.String()
+			return event, nil
+		}
+		if line[0] == ':' {
+			event.Comments = append(event.Comments, string(bytes.TrimPrefix(line[1:], []byte(" "))))
+			continue
+		}
+		field, value := line, []byte(nil)
+		if idx := bytes.IndexByte(line, ':'); idx >= 0 {
+			field, value = line[:idx], bytes.TrimPrefix(line[idx+1:], []byte(" "))
+		}
+		switch string(field) {
+		case "event":
+			event.Type = string(value)
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.Write(value)
+			hasData = true
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				pending = string(value)
+			}
+		case "retry":
+			if ms, ok := parseRetry(value); ok {
+				event.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+func parseRetry(value []byte) (int64, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	for _, b := range value {
+		if b < '0' || b > '9' {
+			return 0, false
+		}
+	}
+	ms, err := strconv.ParseInt(string(value), 10, 64)
+	return ms, err == nil
+}
+
+// readLine returns the next line without its terminator. A line is ended by
+// CR, LF or CRLF; an unterminated line at the end of the stream is dropped.
+func (d *Decoder) readLine() ([]byte, error) {
+	if !d.started {
+		d.started = true
+		// A leading UTF-8 byte order mark is not part of the first line.
+		if bom, err := d.r.Peek(3); err == nil && bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+			_, _ = d.r.Discard(3)
+		}
+	}
+	d.line = d.line[:0]
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if d.skipLF {
+			d.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+		switch b {
+		case '\r':
+			d.skipLF = true
+			return d.line, nil
+		case '\n':
+			return d.line, nil
+		}
+		if len(d.line) >= d.maxLineBytes {
+			return nil, ErrLineTooLong
+		}
+		d.line = append(d.line, b)
+	}
+}
+
+// Encoder writes events to a stream. Each call writes a complete event or
+// comment in a single Write.
+type Encoder struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// ErrInvalidField is returned for event types or IDs a client could not parse
+// back, such as ones containing line breaks or, for IDs, NUL.
+var ErrInvalidField = errors.New("sse: event type and id must be single lines without NUL")
+
+// Encode writes e. Data is split on CR, LF and CRLF into data lines, so it
+// decodes to the same text with line endings normalised to "\n". An empty ID
+// is omitted rather than resetting the client's last event ID.
+func (enc *Encoder) Encode(e Event) error {
+	if strings.ContainsAny(e.Type, "\r\n") || strings.ContainsAny(e.ID, "\r\n\x00") {
+		return ErrInvalidField
+	}
+	enc.buf.Reset()
+	for _, comment := range e.Comments {
+		enc.writeComment(comment)
+	}
+	if e.Type != "" {
+		enc.buf.WriteString("event: ")
+		enc.buf.WriteString(e.Type)
+		enc.buf.WriteByte('\n')
+	}
+	if e.ID != "" {
+		enc.buf.WriteString("id: ")
+		enc.buf.WriteString(e.ID)
+		enc.buf.WriteByte('\n')
+	}
+	if e.Retry > 0 {
+		enc.buf.WriteString("retry: ")
+		enc.buf.WriteString(strconv.FormatInt(e.Retry.Milliseconds(), 10))
+		enc.buf.WriteByte('\n')
+	}
+	for _, line := range splitLines(e.Data) {
+		enc.buf.WriteString("data: ")
+		enc.buf.WriteString(line)
+		enc.buf.WriteByte('\n')
+	}
+	enc.buf.WriteByte('\n')
+	_, err := enc.w.Write(enc.buf.Bytes())
+	return err
+}
+
+// Comment writes a comment block, which clients ignore; proxies use it as a
+// heartbeat. Line breaks in text start further comment lines.
+func (enc *Encoder) Comment(text string) error {
+	enc.buf.Reset()
+	enc.writeComment(text)
+	enc.buf.WriteByte('\n')
+	_, err := enc.w.Write(enc.buf.Bytes())
+	return err
+}
+
+func (enc *Encoder) writeComment(text string) {
+	for _, line := range splitLines(text) {
+		enc.buf.WriteString(": ")
+		enc.buf.WriteString(line)
+		enc.buf.WriteByte('\n')
+	}
+}
+
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,162 @@
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func decodeAll(t testing.TB, r io.Reader) ([]Event, error) {
+	t.Helper()
+	dec := NewDecoder(r)
+	var events []Event
+	for {
+		event, err := dec.Decode()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return events, nil
+			}
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
+
+func TestDecoderParsesFields(t *testing.T) {
+	stream := "\xef\xbb\xbf: hello\r\n" +
+		"event: plan.step\r\n" +
+		"id: 42\r\n" +
+		"retry: 1500\r\n" +
+		"data: first\r\n" +
+		"data:second\r\n" +
+		"\r\n" +
+		"data\n" +
+		"\n" +
+		"id: bad\x00id\r" +
+		"data: {\"ok\":true}\r" +
+		"\r" +
+		"id\n" +
+		"\n" +
+		"data: trailing without blank line"
+
+	events, err := decodeAll(t, strings.NewReader(stream))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Event{
+		{Type: "plan.step", ID: "42", Data: "first\nsecond", Retry: 1500 * time.Millisecond, Comments: []string{"hello"}},
+		{ID: "42", Data: ""},
+		{ID: "42", Data: `{"ok":true}`},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("unexpected events:\n got %#v\nwant %#v", events, want)
+	}
+}
+
+func TestDecoderCommitsIDOfBlocksWithoutData(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("id: 7\n\nevent: ignored\n\ndata: x\n\n"))
+	dec.SetLastEventID("3")
+	event, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if event.ID != "7" || event.Name() != "message" || event.Data != "x" {
+		t.Fatalf("unexpected event %#v", event)
+	}
+	if dec.LastEventID() != "7" {
+		t.Fatalf("expected last event id 7, got %q", dec.LastEventID())
+	}
+}
+
+func TestDecoderHandlesSplitLineEndings(t *testing.T) {
+	stream := "data: a\r\n\r\ndata: b\r\n\r\n"
+	events, err := decodeAll(t, iotest.OneByteReader(strings.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 2 || events[0].Data != "a" || events[1].Data != "b" {
+		t.Fatalf("unexpected events %#v", events)
+	}
+}
+
+func TestDecoderLimitsLineLength(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("data: " + strings.Repeat("x", 100) + "\n\n"))
+	dec.SetMaxLineBytes(32)
+	if _, err := dec.Decode(); !errors.Is(err, ErrLineTooLong) {
+		t.Fatalf("expected ErrLineTooLong, got %v", err)
+	}
+}
+
+func TestEncoderWritesDecodableEvents(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.Comment("keepalive"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	event := Event{Type: "error", ID: "9", Data: "line one\r\nline two\rline three", Retry: 2 * time.Second}
+	if err := enc.Encode(event); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := ": keepalive\n\nevent: error\nid: 9\nretry: 2000\ndata: line one\ndata: line two\ndata: line three\n\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected encoding %q", buf.String())
+	}
+
+	events, err := decodeAll(t, &buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 1 || events[0].Data != "line one\nline two\nline three" || events[0].Comments[0] != "keepalive" {
+		t.Fatalf("unexpected round trip %#v", events)
+	}
+
+	for _, bad := range []Event{{Type: "a\nb"}, {ID: "a\rb"}, {ID: "a\x00b"}} {
+		if err := enc.Encode(bad); !errors.Is(err, ErrInvalidField) {
+			t.Fatalf("expected ErrInvalidField for %#v, got %v", bad, err)
+		}
+	}
+}
+
+func FuzzDecoder(f *testing.F) {
+	f.Add("event: a\nid: 1\ndata: x\n\n")
+	f.Add(": c\r\ndata:y\r\ndata\r\n\r\n")
+	f.Add("retry: 10\rid: \x00\r\rdata: z")
+	f.Fuzz(func(t *testing.T, stream string) {
+		whole, wholeErr := decodeAll(t, strings.NewReader(stream))
+		chunked, chunkedErr := decodeAll(t, iotest.OneByteReader(strings.NewReader(stream)))
+		if (wholeErr == nil) != (chunkedErr == nil) || !reflect.DeepEqual(whole, chunked) {
+			t.Fatalf("chunking changed the result: %#v (%v) vs %#v (%v)", whole, wholeErr, chunked, chunkedErr)
+		}
+	})
+}
+
+func FuzzEncoderRoundTrip(f *testing.F) {
+	f.Add("update", "1", "hello\nworld")
+	f.Add("", "", "")
+	f.Add(" spaced", " id", "  data\r\n")
+	f.Fuzz(func(t *testing.T, eventType, id, data string) {
+		var buf bytes.Buffer
+		event := Event{Type: eventType, ID: id, Data: data}
+		if err := NewEncoder(&buf).Encode(event); err != nil {
+			if !errors.Is(err, ErrInvalidField) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return
+		}
+		dec := NewDecoder(&buf)
+		dec.SetMaxLineBytes(len(buf.String()) + 1)
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("failed to decode %q: %v", buf.String(), err)
+		}
+		wantData := strings.ReplaceAll(strings.ReplaceAll(data, "\r\n", "\n"), "\r", "\n")
+		if got.Type != eventType || got.ID != id || got.Data != wantData {
+			t.Fatalf("round trip mismatch: %#v from %#v", got, event)
+		}
+	})
+}
@@ -0,0 +1,432 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	fileStoreFormat  = "gateway-storage"
+	fileStoreVersion = 1
+	// fileStoreEncryptedVersion marks encrypted logs so builds without
+	// encryption support refuse them instead of misreading them.
+	fileStoreEncryptedVersion = 2
+	// fileStoreCompactSlack is how many superseded records the log may hold
+	// beyond twice the live entry count before it is rewritten.
+	fileStoreCompactSlack = 1024
+	maxFileStoreLineBytes = 4 << 20
+)
+
+type fileStoreHeader struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	Encryption string `json:"encryption,omitempty"`
+	FileID     []byte `json:"file_id,omitempty"`
+}
+
+type fileStoreRecord struct {
+	Op        string `json:"op"`
+	Key       string `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// fileStoreLog is the log file records are appended to; tests replace it to
+// inject write failures.
+type fileStoreLog interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Stat() (os.FileInfo, error)
+	Close() error
+}
+
+// FileStore is an embedded store backed by an append-only log. Every write is
+// fsynced before it returns, a torn final record from a crash is discarded on
+// open, and the log is compacted when superseded records dominate it. Only one
+// store may open a given file: an exclusive lock on <path>.lock is held until
+// Close.
+type FileStore struct {
+	mu      sync.Mutex
+	path    string
+	lock    *os.File
+	file    fileStoreLog
+	entries map[string]memoryEntry
+	records int
+	now     func() time.Time
+	cipher  *fileCipher
+	fileID  []byte
+	// migratePlaintext lets an encrypted store open an unencrypted log.
+	migratePlaintext bool
+}
+
+// OpenFileStore opens or creates the unencrypted log at path.
+func OpenFileStore(path string) (*FileStore, error) {
+	return OpenEncryptedFileStore(path, nil)
+}
+
+// OpenEncryptedFileStore opens or creates the log at path, encrypting it with
+// enc when enc is not nil. Every record is verified on open and any failure is
+// returned as an error. The log is rewritten on open, which encrypts a
+// plaintext log when enc.MigratePlaintext is set and re-encrypts records
+// sealed with a previous key, so rotating keys only needs a restart with the
+// old key in PreviousKeys.
+// Verification binds records to their file and position; records missing
+// from the end of the log, as a crash may leave it, are not detected.
+func OpenEncryptedFileStore(path string, enc *FileEncryption) (*FileStore, error) {
+	return openFileStore(path, enc, time.Now)
+}
+
+func openFileStore(path string, enc *FileEncryption, now func() time.Time) (*FileStore, error) {
+	if !filepath.IsAbs(path) {
+		return nil, errors.New("file storage path must be absolute")
+	}
+	s := &FileStore{path: path, entries: make(map[string]memoryEntry), now: now}
+	if enc != nil {
+		c, err := newFileCipher(enc)
+		if err != nil {
+			return nil, err
+		}
+		s.cipher = c
+		s.migratePlaintext = enc.MigratePlaintext
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	// The lock lives beside the log because compaction replaces the log's
+	// inode, which would drop a lock held on the log itself.
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	s.lock = lock
+	if err := s.load(); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) load() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	line, err := readLogLine(reader)
+	if err != nil {
+		return fmt.Errorf("storage file %s has no header: %w", s.path, err)
+	}
+	var header fileStoreHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Format != fileStoreFormat {
+		return fmt.Errorf("storage file %s is not a gateway storage file", s.path)
+	}
+	if header.Version > fileStoreEncryptedVersion {
+		return fmt.Errorf("storage file %s has version %d; this build supports up to %d", s.path, header.Version, fileStoreEncryptedVersion)
+	}
+	encrypted := header.Encryption != ""
+	switch {
+	case encrypted && header.Encryption != fileStoreEncryption:
+		return fmt.Errorf("storage file %s uses unsupported encryption %q", s.path, header.Encryption)
+	case encrypted && s.cipher == nil:
+		return fmt.Errorf("storage file %s is encrypted but no storage encryption key is configured", s.path)
+	case encrypted && len(header.FileID) != fileStoreIDBytes:
+		return fmt.Errorf("storage file %s has an invalid file id", s.path)
+	case !encrypted && s.cipher != nil && !s.migratePlaintext:
+		return fmt.Errorf("%w: %s", ErrUnencryptedFile, s.path)
+	}
+
+	now := s.now()
+	for {
+		line, err := readLogLine(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var sealed fileStoreSealed
+		if encrypted {
+			err = json.Unmarshal(line, &sealed)
+		}
+		var record fileStoreRecord
+		if err == nil && !encrypted {
+			err = json.Unmarshal(line, &record)
+		}
+		if err != nil {
+			// Only the last record can be torn by a crash; compaction
+			// rewrites the file without it.
+			if _, peekErr := reader.Peek(1); errors.Is(peekErr, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("storage file %s is corrupt: %w", s.path, err)
+		}
+		if encrypted {
+			plaintext, err := s.cipher.open(header.FileID, s.records, sealed)
+			if err == nil {
+				err = json.Unmarshal(plaintext, &record)
+			}
+			if err != nil {
+				return fmt.Errorf("storage file %s failed verification: %w", s.path, err)
+			}
+		}
+		s.apply(record, now)
+	}
+}
+
+func readLogLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxFileStoreLineBytes {
+			return nil, errors.New("storage record exceeds size limit")
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+func (s *FileStore) apply(record fileStoreRecord, now time.Time) {
+	s.records++
+	switch record.Op {
+	case "set":
+		entry := memoryEntry{value: record.Value}
+		if record.ExpiresAt != 0 {
+			entry.expiresAt = time.Unix(0, record.ExpiresAt)
+		}
+		if entry.expired(now) {
+			delete(s.entries, record.Key)
+			return
+		}
+		s.entries[record.Key] = entry
+	case "del":
+		delete(s.entries, record.Key)
+	}
+}
+
+// compact rewrites the log with only live entries and swaps it into place.
+func (s *FileStore) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".storage-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// Each rewrite gets a new file id so records cannot be copied between
+	// generations of the log.
+	header := fileStoreHeader{Format: fileStoreFormat, Version: fileStoreVersion}
+	if s.cipher != nil {
+		if header.FileID, err = newFileStoreID(); err != nil {
+			tmp.Close()
+			return err
+		}
+		header.Version = fileStoreEncryptedVersion
+		header.Encryption = fileStoreEncryption
+	}
+	writer := bufio.NewWriter(tmp)
+	_ = json.NewEncoder(writer).Encode(header)
+	now := s.now()
+	records := 0
+	for key, entry := range s.entries {
+		if entry.expired(now) {
+			delete(s.entries, key)
+			continue
+		}
+		line, err := s.encodeRecord(header.FileID, records, recordFor(key, entry))
+		if err == nil {
+			_, err = writer.Write(line)
+		}
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		records++
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		return fmt.Errorf("failed to sync storage directory: %w", err)
+	}
+	s.records = records
+	s.fileID = header.FileID
+	if s.file != nil {
+		_ = s.file.Close()
+		s.file = nil
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	return nil
+}
+
+func recordFor(key string, entry memoryEntry) fileStoreRecord {
+	record := fileStoreRecord{Op: "set", Key: key, Value: entry.value}
+	if !entry.expiresAt.IsZero() {
+		record.ExpiresAt = entry.expiresAt.UnixNano()
+	}
+	return record
+}
+
+// encodeRecord returns the log line for record, sealed as the seq-th record
+// of the file when the store is encrypted.
+func (s *FileStore) encodeRecord(fileID []byte, seq int, record fileStoreRecord) ([]byte, error) {
+	line, err := json.Marshal(record)
+	if err != nil || s.cipher == nil {
+		return append(line, '\n'), err
+	}
+	sealed, err := s.cipher.seal(fileID, seq, line)
+	if err != nil {
+		return nil, err
+	}
+	line, err = json.Marshal(sealed)
+	return append(line, '\n'), err
+}
+
+func (s *FileStore) appendLocked(record fileStoreRecord) error {
+	if s.file == nil {
+		return errors.New("storage: file store is closed")
+	}
+	line, err := s.encodeRecord(s.fileID, s.records, record)
+	if err != nil {
+		return err
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(line)
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		// Part or all of the record may have reached the disk. Cutting it
+		// off lets the next record take its seq; should that fail, the seq
+		// is spent so no two records are sealed with it, and the log is
+		// rewritten from memory to drop a torn line.
+		if truncErr := s.file.Truncate(info.Size()); truncErr != nil {
+			s.records++
+			_ = s.compact()
+		}
+		return err
+	}
+	s.records++
+	return nil
+}
+
+// maybeCompactLocked runs after the in-memory entries reflect the latest
+// record. A failed compaction leaves the existing log in place and is retried
+// on the next write, so the error is not surfaced to the writer.
+func (s *FileStore) maybeCompactLocked() {
+	if s.records > 2*len(s.entries)+fileStoreCompactSlack {
+		_ = s.compact()
+	}
+}
+
+func (s *FileStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || entry.expired(s.now()) {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), entry.value...), nil
+}
+
+func (s *FileStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := validateKey(key, ttl); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := memoryEntry{value: append([]byte(nil), value...), expiresAt: expiryFor(s.now(), ttl)}
+	if err := s.appendLocked(recordFor(key, entry)); err != nil {
+		return err
+	}
+	s.entries[key] = entry
+	s.maybeCompactLocked()
+	return nil
+}
+
+func (s *FileStore) SetNX(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if err := validateKey(key, ttl); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if entry, ok := s.entries[key]; ok && !entry.expired(now) {
+		return false, nil
+	}
+	entry := memoryEntry{value: append([]byte(nil), value...), expiresAt: expiryFor(now, ttl)}
+	if err := s.appendLocked(recordFor(key, entry)); err != nil {
+		return false, err
+	}
+	s.entries[key] = entry
+	s.maybeCompactLocked()
+	return true, nil
+}
+
+func (s *FileStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; !ok {
+		return nil
+	}
+	if err := s.appendLocked(fileStoreRecord{Op: "del", Key: key}); err != nil {
+		return err
+	}
+	delete(s.entries, key)
+	s.maybeCompactLocked()
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+		s.file = nil
+	}
+	if s.lock != nil {
+		_ = s.lock.Close()
+		s.lock = nil
+	}
+	return err
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package storage
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile opens the lock file at path. Advisory locks are not available
+// here, so exclusive access is not enforced.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage lock: %w", err)
+	}
+	return file, nil
+}
+
+// syncDir is a no-op where directories cannot be opened for syncing.
+func syncDir(string) error { return nil }
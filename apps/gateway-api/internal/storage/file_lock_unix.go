@@ -0,0 +1,38 @@
+//go:build unix
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile opens the lock file at path and takes an exclusive flock on it,
+// failing at once if another process, or another store in this process,
+// holds it. Closing the returned file releases the lock.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage lock: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("storage file is locked by another process (%s)", path)
+		}
+		return nil, fmt.Errorf("failed to lock storage: %w", err)
+	}
+	return file, nil
+}
+
+// syncDir fsyncs the directory at path so a rename into it survives a crash.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
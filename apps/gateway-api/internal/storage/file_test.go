@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStorePersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	ctx := context.Background()
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = store.Set(ctx, "kept", []byte("v1"), 0)
+	_ = store.Set(ctx, "kept", []byte("v2"), 0)
+	_ = store.Set(ctx, "deleted", []byte("v"), 0)
+	_ = store.Delete(ctx, "deleted")
+	_ = store.Set(ctx, "expiring", []byte("v"), time.Hour)
+	_ = store.Close()
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = reopened.Close() })
+	if value, err := reopened.Get(ctx, "kept"); err != nil || string(value) != "v2" {
+		t.Fatalf("expected latest value, got %q, %v", value, err)
+	}
+	if _, err := reopened.Get(ctx, "deleted"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleted key to stay deleted, got %v", err)
+	}
+	if _, err := reopened.Get(ctx, "expiring"); err != nil {
+		t.Fatalf("expected ttl entry to survive reopen, got %v", err)
+	}
+
+	// Opening compacts the log down to the header plus live entries.
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Fatalf("expected compacted log with 3 lines, got %d:\n%s", lines, data)
+	}
+}
+
+func TestFileStoreHoldsAnExclusiveLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second, err := OpenFileStore(path); err == nil {
+		_ = second.Close()
+		t.Fatal("expected a second open of a locked store to fail")
+	}
+	_ = store.Close()
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("expected the lock to be released on close: %v", err)
+	}
+	_ = reopened.Close()
+}
+
+func TestFileStoreDropsExpiredEntriesOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, _ := OpenFileStore(path)
+	_ = store.Set(context.Background(), "k", []byte("v"), time.Minute)
+	_ = store.Close()
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = reopened.Close() })
+	reopened.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if _, err := reopened.Get(context.Background(), "k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected expired entry to be gone, got %v", err)
+	}
+}
+
+func TestFileStoreIgnoresTornFinalRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, _ := OpenFileStore(path)
+	_ = store.Set(context.Background(), "k", []byte("v"), 0)
+	_ = store.Close()
+
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	_, _ = file.WriteString(`{"op":"set","key":"torn","val`)
+	_ = file.Close()
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("expected torn record to be discarded, got %v", err)
+	}
+	t.Cleanup(func() { _ = reopened.Close() })
+	if value, err := reopened.Get(context.Background(), "k"); err != nil || string(value) != "v" {
+		t.Fatalf("expected earlier records to survive, got %q, %v", value, err)
+	}
+}
+
+func TestFileStoreRejectsCorruptOrNewerFiles(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]string{
+		"foreign":  "hello\n",
+		"newer":    `{"format":"gateway-storage","version":99}` + "\n",
+		"corrupt":  `{"format":"gateway-storage","version":1}` + "\nnot json\n" + `{"op":"del","key":"k"}` + "\n",
+		"headless": "",
+	}
+	for name, contents := range cases {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := OpenFileStore(path); err == nil {
+			t.Errorf("expected %s file to be rejected", name)
+		}
+	}
+}
+
+func TestFileStoreCompactsWhileRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, _ := OpenFileStore(path)
+	t.Cleanup(func() { _ = store.Close() })
+	ctx := context.Background()
+	for i := 0; i < fileStoreCompactSlack+10; i++ {
+		if err := store.Set(ctx, "k", []byte("v"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if store.records > fileStoreCompactSlack {
+		t.Fatalf("expected log to be compacted, have %d records", store.records)
+	}
+	if value, err := store.Get(ctx, "k"); err != nil || string(value) != "v" {
+		t.Fatalf("expected value after compaction, got %q, %v", value, err)
+	}
+}
+
+func testEncryptionKey(name string) []byte {
+	return []byte(strings.Repeat(name, MinFileEncryptionKeyBytes/len(name)+1))
+}
+
+func TestEncryptedFileStoreHidesAndVerifiesRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	ctx := context.Background()
+	enc := &FileEncryption{Key: testEncryptionKey("current")}
+	store, err := OpenEncryptedFileStore(path, enc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = store.Set(ctx, "tenant:acme", []byte("secret-value"), 0)
+	_ = store.Set(ctx, "tenant:globex", []byte("other"), 0)
+	_ = store.Close()
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "acme") || strings.Contains(string(data), "c2VjcmV0") {
+		t.Fatalf("expected keys and values to be encrypted:\n%s", data)
+	}
+	if _, err := OpenFileStore(path); err == nil {
+		t.Fatal("expected an encrypted file to need a key")
+	}
+	if _, err := OpenEncryptedFileStore(path, &FileEncryption{Key: testEncryptionKey("wrong")}); err == nil {
+		t.Fatal("expected the wrong key to be rejected")
+	}
+
+	reopened, err := OpenEncryptedFileStore(path, enc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value, err := reopened.Get(ctx, "tenant:acme"); err != nil || string(value) != "secret-value" {
+		t.Fatalf("expected the value to round-trip, got %q, %v", value, err)
+	}
+	_ = reopened.Close()
+
+	// Swapping two records keeps every record authentic but moves them, which
+	// verification must notice.
+	data, _ = os.ReadFile(path)
+	lines := strings.SplitAfter(string(data), "\n")
+	lines[1], lines[2] = lines[2], lines[1]
+	_ = os.WriteFile(path, []byte(strings.Join(lines, "")), 0o600)
+	if _, err := OpenEncryptedFileStore(path, enc); err == nil || !strings.Contains(err.Error(), "verification") {
+		t.Fatalf("expected reordered records to fail verification, got %v", err)
+	}
+	_ = os.WriteFile(path, []byte(strings.Replace(string(data), `"data":"`, `"data":"AA`, 1)), 0o600)
+	if _, err := OpenEncryptedFileStore(path, enc); err == nil {
+		t.Fatal("expected a modified record to fail verification")
+	}
+}
+
+func TestEncryptedFileStoreRotatesAndMigrates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	ctx := context.Background()
+	plain, _ := OpenFileStore(path)
+	_ = plain.Set(ctx, "k", []byte("v"), 0)
+	_ = plain.Close()
+
+	old := &FileEncryption{Key: testEncryptionKey("old")}
+	if _, err := OpenEncryptedFileStore(path, old); !errors.Is(err, ErrUnencryptedFile) {
+		t.Fatalf("expected a plaintext file to be refused without migration, got %v", err)
+	}
+	migrated, err := OpenEncryptedFileStore(path, &FileEncryption{Key: old.Key, MigratePlaintext: true})
+	if err != nil {
+		t.Fatalf("expected a plaintext file to be migrated, got %v", err)
+	}
+	_ = migrated.Close()
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), `"key":"k"`) {
+		t.Fatalf("expected the migrated file to be encrypted:\n%s", data)
+	}
+
+	rotated, err := OpenEncryptedFileStore(path, &FileEncryption{Key: testEncryptionKey("new"), PreviousKeys: [][]byte{old.Key}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value, err := rotated.Get(ctx, "k"); err != nil || string(value) != "v" {
+		t.Fatalf("expected the value after rotation, got %q, %v", value, err)
+	}
+	_ = rotated.Close()
+	// Opening re-encrypted every record, so the old key is no longer needed.
+	if _, err := OpenEncryptedFileStore(path, &FileEncryption{Key: testEncryptionKey("new")}); err != nil {
+		t.Fatalf("expected records to be re-encrypted with the new key, got %v", err)
+	}
+
+	if _, err := OpenEncryptedFileStore(path, &FileEncryption{Key: []byte("short")}); err == nil {
+		t.Fatal("expected a short key to be rejected")
+	}
+	if _, err := OpenWithOptions("memory://", Options{FileEncryption: old}); err == nil {
+		t.Fatal("expected encryption to require file storage")
+	}
+}
+
+// failingLog writes part of the first record it is given and then fails, or
+// fails to sync it.
+type failingLog struct {
+	fileStoreLog
+	failSync bool
+}
+
+func (l *failingLog) Write(p []byte) (int, error) {
+	if l.failSync {
+		return l.fileStoreLog.Write(p)
+	}
+	n, _ := l.fileStoreLog.Write(p[:len(p)/2])
+	return n, errors.New("disk full")
+}
+
+func (l *failingLog) Sync() error {
+	if l.failSync {
+		return errors.New("sync failed")
+	}
+	return l.fileStoreLog.Sync()
+}
+
+func TestEncryptedFileStoreRecoversFromFailedAppends(t *testing.T) {
+	for name, failSync := range map[string]bool{"partial write": false, "failed sync": true} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.db")
+			enc := &FileEncryption{Key: testEncryptionKey("current")}
+			ctx := context.Background()
+			store, err := OpenEncryptedFileStore(path, enc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_ = store.Set(ctx, "a", []byte("1"), 0)
+			healthy := store.file
+			store.file = &failingLog{fileStoreLog: healthy, failSync: failSync}
+			if err := store.Set(ctx, "b", []byte("2"), 0); err == nil {
+				t.Fatal("expected the injected failure to be returned")
+			}
+			store.file = healthy
+			if err := store.Set(ctx, "c", []byte("3"), 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_ = store.Close()
+
+			reopened, err := OpenEncryptedFileStore(path, enc)
+			if err != nil {
+				t.Fatalf("expected the log to verify after a failed append, got %v", err)
+			}
+			defer reopened.Close()
+			if value, err := reopened.Get(ctx, "c"); err != nil || string(value) != "3" {
+				t.Fatalf("expected the later record, got %q, %v", value, err)
+			}
+			if _, err := reopened.Get(ctx, "b"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected the failed record to be dropped, got %v", err)
+			}
+		})
+	}
+}
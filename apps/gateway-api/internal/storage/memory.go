@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const memorySweepInterval = time.Minute
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+func expiryFor(now time.Time, ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// MemoryStore keeps entries in process. Expired entries are swept at most once
+// a minute on write.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return newMemoryStore(time.Now)
+}
+
+func newMemoryStore(now func() time.Time) *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry), now: now}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || entry.expired(s.now()) {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), entry.value...), nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := validateKey(key, ttl); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.sweepLocked(now)
+	s.entries[key] = memoryEntry{value: append([]byte(nil), value...), expiresAt: expiryFor(now, ttl)}
+	return nil
+}
+
+func (s *MemoryStore) SetNX(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if err := validateKey(key, ttl); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.sweepLocked(now)
+	if entry, ok := s.entries[key]; ok && !entry.expired(now) {
+		return false, nil
+	}
+	s.entries[key] = memoryEntry{value: append([]byte(nil), value...), expiresAt: expiryFor(now, ttl)}
+	return true, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	for key, entry := range s.entries {
+		if entry.expired(now) {
+			delete(s.entries, key)
+		}
+	}
+	s.lastSweep = now
+}
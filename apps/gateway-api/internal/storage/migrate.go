@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	schemaVersionKey   = "storage:schema_version"
+	migrationLockKey   = "storage:migration_lock"
+	migrationLockTTL   = time.Minute
+	migrationLockRetry = 200 * time.Millisecond
+)
+
+// Migration upgrades stored data from Version-1 to Version. Apply must be safe
+// to rerun: if a replica dies mid-migration the next one starts it again.
+type Migration struct {
+	Version int
+	Name    string
+	Apply   func(ctx context.Context, store Store) error
+}
+
+// SchemaVersion returns the highest migration applied to store, or 0.
+func SchemaVersion(ctx context.Context, store Store) (int, error) {
+	raw, err := store.Get(ctx, schemaVersionKey)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, fmt.Errorf("invalid storage schema version %q", raw)
+	}
+	return version, nil
+}
+
+// PendingMigrations returns the schema version of store and the migrations
+// Migrate would apply to it, without applying them or taking the lock. It is
+// the dry run of Migrate and fails the same way on misnumbered migrations or
+// a store written by a newer build.
+func PendingMigrations(ctx context.Context, store Store, migrations []Migration) (int, []Migration, error) {
+	if err := checkMigrations(migrations); err != nil {
+		return 0, nil, err
+	}
+	return pendingMigrations(ctx, store, migrations)
+}
+
+// Migrate applies, in order, every migration newer than the stored schema
+// version. Migrations must be numbered 1, 2, 3, ... without gaps. A store
+// written by a newer build is rejected rather than silently downgraded.
+// Replicas serialise on a lock key, so only one runs migrations at a time.
+// It returns the migrations it applied.
+func Migrate(ctx context.Context, store Store, migrations []Migration) ([]Migration, error) {
+	if err := checkMigrations(migrations); err != nil {
+		return nil, err
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	lock := []byte(hex.EncodeToString(token))
+	for {
+		acquired, err := store.SetNX(ctx, migrationLockKey, lock, migrationLockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for storage migration lock: %w", ctx.Err())
+		case <-time.After(migrationLockRetry):
+		}
+	}
+	defer releaseMigrationLock(context.WithoutCancel(ctx), store, lock)
+
+	_, pending, err := pendingMigrations(ctx, store, migrations)
+	if err != nil {
+		return nil, err
+	}
+	for i, migration := range pending {
+		// Each migration gets the full lock lifetime, so a slow one does not
+		// let another replica start migrating behind it.
+		if i > 0 {
+			if err := store.Set(ctx, migrationLockKey, lock, migrationLockTTL); err != nil {
+				return pending[:i], err
+			}
+		}
+		if err := migration.Apply(ctx, store); err != nil {
+			return pending[:i], fmt.Errorf("storage migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+		}
+		if err := store.Set(ctx, schemaVersionKey, []byte(strconv.Itoa(migration.Version)), 0); err != nil {
+			return pending[:i], err
+		}
+	}
+	return pending, nil
+}
+
+func checkMigrations(migrations []Migration) error {
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return fmt.Errorf("migration %q has version %d, expected %d", migration.Name, migration.Version, i+1)
+		}
+	}
+	return nil
+}
+
+func pendingMigrations(ctx context.Context, store Store, migrations []Migration) (int, []Migration, error) {
+	current, err := SchemaVersion(ctx, store)
+	if err != nil {
+		return 0, nil, err
+	}
+	if current > len(migrations) {
+		return current, nil, fmt.Errorf("storage schema version %d is newer than this build supports (%d)", current, len(migrations))
+	}
+	return current, migrations[current:], nil
+}
+
+// releaseMigrationLock deletes the lock unless it expired and another
+// replica took it over.
+func releaseMigrationLock(ctx context.Context, store Store, lock []byte) {
+	held, err := store.Get(ctx, migrationLockKey)
+	if err != nil || !bytes.Equal(held, lock) {
+		return
+	}
+	_ = store.Delete(ctx, migrationLockKey)
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMigrateAppliesPendingMigrationsOnce(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	var applied []int
+	migration := func(version int) Migration {
+		return Migration{Version: version, Name: "test", Apply: func(context.Context, Store) error {
+			applied = append(applied, version)
+			return nil
+		}}
+	}
+
+	if _, err := Migrate(ctx, store, []Migration{migration(1)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := Migrate(ctx, store, []Migration{migration(1), migration(2), migration(3)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(applied) != 3 || applied[0] != 1 || applied[1] != 2 || applied[2] != 3 {
+		t.Fatalf("expected each migration once in order, got %v", applied)
+	}
+	if version, _ := SchemaVersion(ctx, store); version != 3 {
+		t.Fatalf("expected schema version 3, got %d", version)
+	}
+	if _, err := store.Get(ctx, migrationLockKey); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected migration lock to be released")
+	}
+
+	// An older build must not run against a newer schema.
+	if _, err := Migrate(ctx, store, []Migration{migration(1)}); err == nil {
+		t.Fatal("expected newer schema to be rejected")
+	}
+}
+
+func TestMigrateStopsAtFailedMigration(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	migrations := []Migration{
+		{Version: 1, Name: "ok", Apply: func(context.Context, Store) error { return nil }},
+		{Version: 2, Name: "broken", Apply: func(context.Context, Store) error { return errors.New("boom") }},
+	}
+	if _, err := Migrate(ctx, store, migrations); err == nil {
+		t.Fatal("expected migration error")
+	}
+	if version, _ := SchemaVersion(ctx, store); version != 1 {
+		t.Fatalf("expected schema version to stop at 1, got %d", version)
+	}
+}
+
+func TestMigrateRejectsMisnumberedMigrations(t *testing.T) {
+	noop := func(context.Context, Store) error { return nil }
+	_, err := Migrate(context.Background(), NewMemoryStore(), []Migration{{Version: 2, Name: "gap", Apply: noop}})
+	if err == nil {
+		t.Fatal("expected numbering error")
+	}
+}
+
+func TestMigrateWaitsForLock(t *testing.T) {
+	store := NewMemoryStore()
+	_ = store.Set(context.Background(), migrationLockKey, []byte("1"), time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Migrate(ctx, store, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to time out waiting for the lock, got %v", err)
+	}
+}
+
+func TestPendingMigrationsDoesNotApply(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	applied := 0
+	migrations := []Migration{
+		{Version: 1, Name: "first", Apply: func(context.Context, Store) error { applied++; return nil }},
+		{Version: 2, Name: "second", Apply: func(context.Context, Store) error { applied++; return nil }},
+	}
+	if _, err := Migrate(ctx, store, migrations[:1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	current, pending, err := PendingMigrations(ctx, store, migrations)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current != 1 || len(pending) != 1 || pending[0].Name != "second" {
+		t.Fatalf("expected migration 2 pending at version 1, got %d %v", current, pending)
+	}
+	if applied != 1 {
+		t.Fatalf("expected dry run not to apply migrations, applied %d", applied)
+	}
+	if _, _, err := PendingMigrations(ctx, store, nil); err == nil {
+		t.Fatal("expected newer schema to be rejected")
+	}
+}
+
+func TestMigrateKeepsLockTakenOverByAnotherReplica(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	migrations := []Migration{{Version: 1, Name: "slow", Apply: func(ctx context.Context, store Store) error {
+		// Simulate the lock expiring and another replica taking it.
+		return store.Set(ctx, migrationLockKey, []byte("other"), time.Minute)
+	}}}
+	if _, err := Migrate(ctx, store, migrations); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if held, err := store.Get(ctx, migrationLockKey); err != nil || string(held) != "other" {
+		t.Fatalf("expected the other replica's lock to be kept, got %q, %v", held, err)
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RedisStore keeps entries in Redis so every gateway replica sees the same
+// state. Values are stored as plain strings and TTLs as PX expiries.
+type RedisStore struct {
+	client *RedisClient
+}
+
+func NewRedisStore(client *RedisClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := s.client.Do(ctx, "GET", key)
+	if errors.Is(err, ErrRedisNil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	return []byte(value), nil
+}
+
+func setArgs(key string, value []byte, ttl time.Duration, extra ...string) []string {
+	args := append([]string{"SET", key, string(value)}, extra...)
+	if ttl > 0 {
+		// Redis rejects PX 0, so sub-millisecond TTLs round up.
+		args = append(args, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+	return args
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := validateKey(key, ttl); err != nil {
+		return err
+	}
+	_, err := s.client.Do(ctx, setArgs(key, value, ttl)...)
+	return err
+}
+
+func (s *RedisStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if err := validateKey(key, ttl); err != nil {
+		return false, err
+	}
+	_, err := s.client.Do(ctx, setArgs(key, value, ttl, "NX")...)
+	if errors.Is(err, ErrRedisNil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	_, err := s.client.Do(ctx, "DEL", key)
+	return err
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
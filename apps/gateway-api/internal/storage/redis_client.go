@@ -0,0 +1,675 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisDialTimeout = 2 * time.Second
+	// maxRedisBulkBytes bounds bulk replies; the gateway only stores small
+	// markers and counters.
+	maxRedisBulkBytes = 1 << 20
+)
+
+// ErrRedisNil is returned when Redis replies with a nil bulk string, for
+// example when SET NX does not store the value.
+var ErrRedisNil = errors.New("redis: nil reply")
+
+// Topologies a RedisClient connects to, selected by the URL scheme.
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
+
+const (
+	defaultRedisPort         = "6379"
+	defaultRedisSentinelPort = "26379"
+	// maxRedisRedirects bounds the MOVED and ASK replies followed for one
+	// cluster command.
+	maxRedisRedirects = 5
+	redisClusterSlots = 16384
+)
+
+// RedisClient is a minimal RESP2 client covering the handful of commands the
+// gateway needs. It pools connections per node, so commands run
+// concurrently, and replaces connections after errors. Behind Sentinel it
+// asks the sentinels for the current master and asks again after a
+// failover; in a cluster it routes each command by the slot of its key and
+// learns slot owners from MOVED replies.
+type RedisClient struct {
+	topology string
+	addrs    []string
+	master   string
+	db       int
+	timeout  time.Duration
+
+	// mu guards the settings below and the routing state; it is never held
+	// during network I/O.
+	mu        sync.Mutex
+	username  string
+	password  string
+	tlsConfig *tls.Config
+	dial      func(ctx context.Context, network, addr string) (net.Conn, error)
+	// idle holds the authenticated connections to each node that no
+	// command is using.
+	idle   map[string][]*redisConn
+	closed bool
+	// primary is the node standalone and Sentinel commands go to; it is
+	// cleared to resolve the master again.
+	primary string
+	// slots maps cluster slots to the node that last claimed them.
+	slots map[uint16]string
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// maxRedisIdleConns bounds the idle connections kept per node; busier
+// moments open more and close the surplus once they are done.
+const maxRedisIdleConns = 8
+
+// errRedisClosed is returned for commands sent after Close.
+var errRedisClosed = errors.New("redis: client closed")
+
+// NewRedisClient parses a Redis URL:
+//
+//	redis://[user:password@]host:6379[/db]
+//	redis+sentinel://[user:password@]s1:26379,s2:26379/master[/db]
+//	redis+cluster://[user:password@]n1:6379,n2:6379
+//
+// The rediss variants of each scheme use TLS for every connection, including
+// those to the sentinels. Credentials authenticate to the data nodes only.
+func NewRedisClient(rawURL string) (*RedisClient, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	scheme, rest, ok := strings.Cut(rawURL, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid redis url %q", rawURL)
+	}
+	client := &RedisClient{timeout: defaultRedisDialTimeout, idle: make(map[string][]*redisConn), slots: make(map[uint16]string)}
+	base, topology, _ := strings.Cut(scheme, "+")
+	switch base {
+	case "redis":
+	case "rediss":
+		client.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	default:
+		return nil, fmt.Errorf("unsupported redis url scheme %q", scheme)
+	}
+	defaultPort := defaultRedisPort
+	switch topology {
+	case "":
+		client.topology = RedisStandalone
+	case RedisSentinel:
+		client.topology = RedisSentinel
+		defaultPort = defaultRedisSentinelPort
+	case RedisCluster:
+		client.topology = RedisCluster
+	default:
+		return nil, fmt.Errorf("unsupported redis url scheme %q", scheme)
+	}
+
+	// url.Parse rejects host lists, so the hosts are split off first and the
+	// rest is parsed with the first of them.
+	authority, path, _ := strings.Cut(rest, "/")
+	userinfo, hosts := "", authority
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		userinfo, hosts = authority[:i+1], authority[i+1:]
+	}
+	for _, host := range strings.Split(hosts, ",") {
+		parsed, err := url.Parse(base + "://" + strings.TrimSpace(host))
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis url: %w", err)
+		}
+		if parsed.Hostname() == "" {
+			return nil, errors.New("redis url host is required")
+		}
+		port := parsed.Port()
+		if port == "" {
+			port = defaultPort
+		}
+		client.addrs = append(client.addrs, net.JoinHostPort(parsed.Hostname(), port))
+	}
+	if client.topology == RedisStandalone && len(client.addrs) > 1 {
+		return nil, errors.New("redis url names several hosts; use redis+sentinel or redis+cluster")
+	}
+	parsed, err := url.Parse(base + "://" + userinfo + "placeholder/" + path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	if parsed.User != nil {
+		client.username = parsed.User.Username()
+		client.password, _ = parsed.User.Password()
+		if client.password == "" {
+			// redis://secret@host is commonly used for password-only auth.
+			client.password, client.username = client.username, ""
+		}
+	}
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if client.topology == RedisSentinel {
+		client.master = segments[0]
+		if client.master == "" {
+			return nil, errors.New("redis sentinel url must name the master, as in redis+sentinel://host:26379/mymaster")
+		}
+		segments = segments[1:]
+	}
+	if len(segments) > 1 {
+		return nil, fmt.Errorf("invalid redis url path %q", parsed.Path)
+	}
+	if len(segments) == 1 && segments[0] != "" {
+		db, err := strconv.Atoi(segments[0])
+		if err != nil || db < 0 {
+			return nil, fmt.Errorf("invalid redis database %q", segments[0])
+		}
+		if client.topology == RedisCluster && db != 0 {
+			return nil, errors.New("redis cluster only supports database 0")
+		}
+		client.db = db
+	}
+	return client, nil
+}
+
+// Topology reports whether the client talks to a standalone server, a
+// Sentinel-managed master or a cluster.
+func (c *RedisClient) Topology() string {
+	return c.topology
+}
+
+// SetAuth replaces the credentials from the URL, for example with a password
+// kept in a secret file.
+func (c *RedisClient) SetAuth(username, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.username, c.password = username, password
+}
+
+// SetTLSConfig replaces the TLS settings of a rediss client, for example to
+// trust a private CA. Without a ServerName each connection verifies the host
+// it dials. It must be called before the first command.
+func (c *RedisClient) SetTLSConfig(cfg *tls.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tlsConfig = cfg
+}
+
+// Do sends a command and returns its reply: string for simple and bulk
+// strings, int64 for integers and []any for arrays. In a cluster the command
+// is routed by its first argument, the key.
+func (c *RedisClient) Do(ctx context.Context, args ...string) (any, error) {
+	if c.topology == RedisCluster {
+		return c.doCluster(ctx, args)
+	}
+	addr, err := c.primaryAddr(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.acquire(ctx, addr)
+	if err != nil {
+		c.forgetPrimary(addr)
+		return nil, err
+	}
+	reply, err := c.roundTrip(ctx, conn, args)
+	if isRedisReadOnly(err) {
+		// The master was demoted by a failover that the next command
+		// resolves.
+		c.release(addr, conn, false)
+		c.dropIdle(addr)
+		c.forgetPrimary(addr)
+		return reply, err
+	}
+	if !c.release(addr, conn, redisConnReusable(err)) {
+		c.dropIdle(addr)
+		c.forgetPrimary(addr)
+	}
+	return reply, err
+}
+
+// Ping checks that the client can reach the node its commands go to.
+func (c *RedisClient) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// SetDialContext replaces the function used to open connections, for example
+// to resolve the host through a cache. It must be called before the first
+// command.
+func (c *RedisClient) SetDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dial = dial
+}
+
+// Close closes the idle connections; those in use are closed when their
+// command completes. Commands sent afterwards fail.
+func (c *RedisClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	idle := c.idle
+	c.idle = make(map[string][]*redisConn)
+	c.mu.Unlock()
+	var errs []error
+	for _, conns := range idle {
+		for _, conn := range conns {
+			errs = append(errs, conn.conn.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// redisConnReusable reports whether a connection can serve another command
+// after one that ended with err: replies, including nil and server errors,
+// leave it in step with the server, while I/O errors do not.
+func redisConnReusable(err error) bool {
+	return err == nil || errors.Is(err, ErrRedisNil) || isRedisServerError(err)
+}
+
+// acquire takes an idle connection to the data node at addr or opens and
+// authenticates a new one.
+func (c *RedisClient) acquire(ctx context.Context, addr string) (*redisConn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errRedisClosed
+	}
+	if conns := c.idle[addr]; len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		c.idle[addr] = conns[:len(conns)-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	username, password := c.username, c.password
+	c.mu.Unlock()
+
+	node, err := c.dialNode(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		args := []string{"AUTH", password}
+		if username != "" {
+			args = []string{"AUTH", username, password}
+		}
+		if _, err := c.roundTrip(ctx, node, args); err != nil {
+			node.conn.Close()
+			return nil, fmt.Errorf("redis auth failed: %w", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTrip(ctx, node, []string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			node.conn.Close()
+			return nil, fmt.Errorf("redis select failed: %w", err)
+		}
+	}
+	return node, nil
+}
+
+// release returns conn to the idle connections of addr when reusable and
+// closes it otherwise, reporting reusable.
+func (c *RedisClient) release(addr string, conn *redisConn, reusable bool) bool {
+	if reusable {
+		c.mu.Lock()
+		if !c.closed && len(c.idle[addr]) < maxRedisIdleConns {
+			c.idle[addr] = append(c.idle[addr], conn)
+			c.mu.Unlock()
+			return true
+		}
+		c.mu.Unlock()
+	}
+	_ = conn.conn.Close()
+	return reusable
+}
+
+// dropIdle closes the idle connections to addr, after one of them failed or
+// the node changed role.
+func (c *RedisClient) dropIdle(addr string) {
+	c.mu.Lock()
+	conns := c.idle[addr]
+	delete(c.idle, addr)
+	c.mu.Unlock()
+	for _, conn := range conns {
+		_ = conn.conn.Close()
+	}
+}
+
+// primaryAddr returns the node standalone and Sentinel commands go to,
+// resolving it when it is not known.
+func (c *RedisClient) primaryAddr(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	addr := c.primary
+	c.mu.Unlock()
+	if addr != "" {
+		return addr, nil
+	}
+	addr, err := c.resolvePrimary(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.primary = addr
+	c.mu.Unlock()
+	return addr, nil
+}
+
+// forgetPrimary makes a Sentinel client ask the sentinels for the master
+// again, unless a concurrent command already replaced addr; a standalone
+// client keeps its only address.
+func (c *RedisClient) forgetPrimary(addr string) {
+	if c.topology != RedisSentinel {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.primary == addr {
+		c.primary = ""
+	}
+}
+
+// resolvePrimary returns the address of the node to send commands to. Behind
+// Sentinel it asks each sentinel in turn for the master and checks the
+// answer with ROLE, so a sentinel that has not yet seen a failover is
+// skipped.
+func (c *RedisClient) resolvePrimary(ctx context.Context) (string, error) {
+	if c.topology != RedisSentinel {
+		return c.addrs[0], nil
+	}
+	var errs []error
+	for _, sentinel := range c.addrs {
+		addr, err := c.askSentinel(ctx, sentinel)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sentinel %s: %w", sentinel, err))
+			continue
+		}
+		conn, err := c.acquire(ctx, addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		reply, err := c.roundTrip(ctx, conn, []string{"ROLE"})
+		if role, _ := reply.([]any); err != nil || len(role) == 0 || role[0] != "master" {
+			c.release(addr, conn, false)
+			c.dropIdle(addr)
+			errs = append(errs, fmt.Errorf("sentinel %s named %s, which is not a master", sentinel, addr))
+			continue
+		}
+		c.release(addr, conn, true)
+		return addr, nil
+	}
+	return "", fmt.Errorf("redis sentinel: no master %q found: %w", c.master, errors.Join(errs...))
+}
+
+func (c *RedisClient) askSentinel(ctx context.Context, sentinel string) (string, error) {
+	conn, err := c.dialNode(ctx, sentinel)
+	if err != nil {
+		return "", err
+	}
+	defer conn.conn.Close()
+	reply, err := c.roundTrip(ctx, conn, []string{"SENTINEL", "GET-MASTER-ADDR-BY-NAME", c.master})
+	if err != nil {
+		return "", err
+	}
+	hostPort, _ := reply.([]any)
+	if len(hostPort) != 2 {
+		return "", fmt.Errorf("unexpected sentinel reply %v", reply)
+	}
+	host, _ := hostPort[0].(string)
+	port, _ := hostPort[1].(string)
+	return net.JoinHostPort(host, port), nil
+}
+
+// doCluster sends args to the node owning the slot of its key, following
+// MOVED and ASK redirects. Slots the client has not learned yet go to the
+// first reachable seed node, which redirects them.
+func (c *RedisClient) doCluster(ctx context.Context, args []string) (any, error) {
+	slot := redisKeySlot(args)
+	addr := c.slotOwner(slot)
+	asking := false
+	for range maxRedisRedirects {
+		if addr == "" {
+			var err error
+			if addr, err = c.clusterSeed(ctx); err != nil {
+				return nil, err
+			}
+		}
+		conn, err := c.acquire(ctx, addr)
+		if errors.Is(err, errRedisClosed) {
+			return nil, err
+		}
+		if err != nil {
+			c.forgetSlot(slot, addr)
+			addr, asking = "", false
+			continue
+		}
+		if asking {
+			if _, err := c.roundTrip(ctx, conn, []string{"ASKING"}); err != nil {
+				c.release(addr, conn, redisConnReusable(err))
+				return nil, err
+			}
+		}
+		reply, err := c.roundTrip(ctx, conn, args)
+		if !c.release(addr, conn, redisConnReusable(err)) {
+			c.dropIdle(addr)
+			c.forgetSlot(slot, addr)
+			return reply, err
+		}
+		if kind, target, ok := redisRedirect(err); ok {
+			if kind == "MOVED" {
+				c.mu.Lock()
+				c.slots[slot] = target
+				c.mu.Unlock()
+			}
+			addr, asking = target, kind == "ASK"
+			continue
+		}
+		return reply, err
+	}
+	return nil, fmt.Errorf("redis cluster: too many redirects for slot %d", slot)
+}
+
+// slotOwner returns the node that last claimed slot, or "" when unknown.
+func (c *RedisClient) slotOwner(slot uint16) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.slots[slot]
+}
+
+// forgetSlot drops the owner of slot when it is still addr.
+func (c *RedisClient) forgetSlot(slot uint16, addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.slots[slot] == addr {
+		delete(c.slots, slot)
+	}
+}
+
+// clusterSeed returns the first seed node accepting a connection.
+func (c *RedisClient) clusterSeed(ctx context.Context) (string, error) {
+	var errs []error
+	for _, addr := range c.addrs {
+		conn, err := c.acquire(ctx, addr)
+		if errors.Is(err, errRedisClosed) {
+			return "", err
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.release(addr, conn, true)
+		return addr, nil
+	}
+	return "", fmt.Errorf("redis cluster: no seed node reachable: %w", errors.Join(errs...))
+}
+
+// dialNode opens a connection to addr, with TLS for rediss URLs.
+func (c *RedisClient) dialNode(ctx context.Context, addr string) (*redisConn, error) {
+	c.mu.Lock()
+	dial, tlsConfig := c.dial, c.tlsConfig
+	c.mu.Unlock()
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn, err := dial(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis dial failed: %w", err)
+	}
+	if tlsConfig != nil {
+		cfg := tlsConfig.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		secured := tls.Client(conn, cfg)
+		if err := secured.HandshakeContext(dialCtx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis dial failed: %w", err)
+		}
+		conn = secured
+	}
+	return &redisConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (c *RedisClient) roundTrip(ctx context.Context, node *redisConn, args []string) (any, error) {
+	deadline := time.Now().Add(c.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := node.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := node.conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	return readRedisReply(node.reader)
+}
+
+type redisServerError string
+
+func (e redisServerError) Error() string { return "redis: " + string(e) }
+
+func isRedisServerError(err error) bool {
+	var serverErr redisServerError
+	return errors.As(err, &serverErr)
+}
+
+// isRedisReadOnly reports a write refused by a replica.
+func isRedisReadOnly(err error) bool {
+	var serverErr redisServerError
+	return errors.As(err, &serverErr) && strings.HasPrefix(string(serverErr), "READONLY")
+}
+
+// redisRedirect parses the MOVED and ASK errors a cluster node answers for
+// slots it does not serve, returning the kind and the node to ask instead.
+func redisRedirect(err error) (string, string, bool) {
+	var serverErr redisServerError
+	if !errors.As(err, &serverErr) {
+		return "", "", false
+	}
+	fields := strings.Fields(string(serverErr))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", "", false
+	}
+	return fields[0], fields[2], true
+}
+
+// redisKeySlot returns the cluster slot of the key in args[1], honouring
+// {hash tags}. Commands without a key use slot 0.
+func redisKeySlot(args []string) uint16 {
+	if len(args) < 2 {
+		return 0
+	}
+	key := args[1]
+	if open := strings.IndexByte(key, '{'); open >= 0 {
+		if end := strings.IndexByte(key[open+1:], '}'); end > 0 {
+			key = key[open+1 : open+1+end]
+		}
+	}
+	return crc16(key) % redisClusterSlots
+}
+
+// crc16 is the CRC-16/XMODEM checksum Redis Cluster hashes keys with.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func readRedisReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisServerError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length: %w", err)
+		}
+		if size < 0 {
+			return nil, ErrRedisNil
+		}
+		if size > maxRedisBulkBytes {
+			return nil, fmt.Errorf("redis: bulk reply of %d bytes exceeds limit", size)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length: %w", err)
+		}
+		if count < 0 {
+			return nil, ErrRedisNil
+		}
+		items := make([]any, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := readRedisReply(r)
+			if err != nil && !errors.Is(err, ErrRedisNil) {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,140 @@
+// Package storage provides the key-value persistence shared by gateway
+// subsystems. Every backend implements the same narrow Store interface so a
+// feature can be developed against memory and deployed against a file or Redis
+// without code changes.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get when a key is absent or expired.
+var ErrNotFound = errors.New("storage: key not found")
+
+// Store is a key-value store with per-key expiry. A ttl of zero stores the
+// value without expiry. Implementations are safe for concurrent use.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetNX stores value only if key is absent and reports whether it did.
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	Delete(ctx context.Context, key string) error
+	Close() error
+}
+
+// Open returns the store described by rawURL:
+//
+//	memory://                  in-process, lost on restart
+//	file:///var/lib/gateway/db single-process embedded file
+//	redis://host:6379/0        shared across replicas (rediss:// for TLS)
+//
+// Redis may also be reached through Sentinel or a cluster; see
+// NewRedisClient. An empty URL selects memory.
+func Open(rawURL string) (Store, error) {
+	return OpenWithOptions(rawURL, Options{})
+}
+
+// Options adjusts how OpenWithOptions opens a store.
+type Options struct {
+	// FileEncryption encrypts file stores at rest. Setting it for any other
+	// backend is an error rather than a silent no-op.
+	FileEncryption *FileEncryption
+	// Now is the clock memory and file stores expire entries by; nil means
+	// time.Now. Redis expires entries by its own clock.
+	Now func() time.Time
+}
+
+// OpenWithOptions is Open with options applied.
+func OpenWithOptions(rawURL string, opts Options) (Store, error) {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		if opts.FileEncryption != nil {
+			return nil, errors.New("storage encryption requires file storage")
+		}
+		return newMemoryStore(opts.Now), nil
+	}
+	if scheme, _, _ := strings.Cut(rawURL, "://"); IsRedisScheme(scheme) {
+		if opts.FileEncryption != nil {
+			return nil, errors.New("storage encryption requires file storage")
+		}
+		client, err := NewRedisClient(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisStore(client), nil
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage url: %w", err)
+	}
+	if opts.FileEncryption != nil && parsed.Scheme != "file" {
+		return nil, errors.New("storage encryption requires file storage")
+	}
+	switch parsed.Scheme {
+	case "memory":
+		return newMemoryStore(opts.Now), nil
+	case "file":
+		if parsed.Host != "" && parsed.Host != "localhost" {
+			return nil, fmt.Errorf("file storage url must not name a host, got %q", parsed.Host)
+		}
+		return openFileStore(parsed.Path, opts.FileEncryption, opts.Now)
+	default:
+		return nil, fmt.Errorf("unsupported storage url scheme %q", parsed.Scheme)
+	}
+}
+
+// IsRedisScheme reports whether a storage URL scheme selects Redis.
+func IsRedisScheme(scheme string) bool {
+	base, topology, _ := strings.Cut(scheme, "+")
+	return (base == "redis" || base == "rediss") && (topology == "" || topology == RedisSentinel || topology == RedisCluster)
+}
+
+// Namespace returns a view of store whose keys are prefixed with prefix and a
+// colon, so subsystems sharing one store cannot collide. Closing the view does
+// not close store.
+func Namespace(store Store, prefix string) Store {
+	return &namespacedStore{store: store, prefix: prefix + ":"}
+}
+
+type namespacedStore struct {
+	store  Store
+	prefix string
+}
+
+func (s *namespacedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.store.Get(ctx, s.prefix+key)
+}
+
+func (s *namespacedStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.store.Set(ctx, s.prefix+key, value, ttl)
+}
+
+func (s *namespacedStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.store.SetNX(ctx, s.prefix+key, value, ttl)
+}
+
+func (s *namespacedStore) Delete(ctx context.Context, key string) error {
+	return s.store.Delete(ctx, s.prefix+key)
+}
+
+func (s *namespacedStore) Close() error {
+	return nil
+}
+
+func validateKey(key string, ttl time.Duration) error {
+	if key == "" {
+		return errors.New("storage: key must not be empty")
+	}
+	if ttl < 0 {
+		return errors.New("storage: ttl must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/storage/storagetest"
+)
+
+func openTestStores(t *testing.T) map[string]Store {
+	t.Helper()
+	fileStore, err := OpenFileStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	encryptedStore, err := OpenEncryptedFileStore(filepath.Join(t.TempDir(), "state.db"), &FileEncryption{Key: testEncryptionKey("current")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	redisStore, err := Open(storagetest.NewFakeRedis(t).URL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stores := map[string]Store{"memory": NewMemoryStore(), "file": fileStore, "encrypted-file": encryptedStore, "redis": redisStore}
+	for _, store := range stores {
+		t.Cleanup(func() { _ = store.Close() })
+	}
+	return stores
+}
+
+func TestStoreConformance(t *testing.T) {
+	for name, store := range openTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if _, err := store.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+			if err := store.Set(ctx, "k", []byte("v1"), 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value, err := store.Get(ctx, "k"); err != nil || string(value) != "v1" {
+				t.Fatalf("expected v1, got %q, %v", value, err)
+			}
+			if ok, err := store.SetNX(ctx, "k", []byte("v2"), time.Minute); err != nil || ok {
+				t.Fatalf("expected SetNX on existing key to fail, got %v, %v", ok, err)
+			}
+			if ok, err := store.SetNX(ctx, "n", []byte("v3"), time.Minute); err != nil || !ok {
+				t.Fatalf("expected SetNX on new key to succeed, got %v, %v", ok, err)
+			}
+			if err := store.Delete(ctx, "k"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := store.Get(ctx, "k"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected deleted key to be gone, got %v", err)
+			}
+			if err := store.Set(ctx, "", []byte("v"), 0); err == nil {
+				t.Fatal("expected empty key to be rejected")
+			}
+			if err := store.Set(ctx, "k", []byte("v"), -time.Second); err == nil {
+				t.Fatal("expected negative ttl to be rejected")
+			}
+		})
+	}
+}
+
+func TestMemoryStoreExpiresEntries(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_ = store.Set(ctx, "k", []byte("v"), time.Minute)
+	now = now.Add(time.Minute)
+	if _, err := store.Get(ctx, "k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected expired key to be gone, got %v", err)
+	}
+	if ok, _ := store.SetNX(ctx, "k", []byte("v"), time.Minute); !ok {
+		t.Fatal("expected SetNX to reuse an expired key")
+	}
+}
+
+func TestNamespaceIsolatesKeys(t *testing.T) {
+	base := NewMemoryStore()
+	ctx := context.Background()
+	a := Namespace(base, "a")
+	b := Namespace(base, "b")
+
+	_ = a.Set(ctx, "k", []byte("from-a"), 0)
+	if _, err := b.Get(ctx, "k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected namespaces to be isolated, got %v", err)
+	}
+	if value, _ := base.Get(ctx, "a:k"); string(value) != "from-a" {
+		t.Fatalf("expected prefixed key in base store, got %q", value)
+	}
+	_ = a.Close()
+	if value, _ := a.Get(ctx, "k"); string(value) != "from-a" {
+		t.Fatal("expected closing a namespace to leave the base store open")
+	}
+}
+
+func TestRedisStoreSendsPXExpiry(t *testing.T) {
+	redis := storagetest.NewFakeRedis(t)
+	client, err := NewRedisClient(redis.URL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store := NewRedisStore(client)
+	t.Cleanup(func() { _ = store.Close() })
+
+	if _, err := store.SetNX(context.Background(), "k", []byte("v"), 1500*time.Microsecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(redis.Commands()[0], " "); got != "SET k v NX PX 1" {
+		t.Fatalf("unexpected command %q", got)
+	}
+}
+
+func TestOpenRejectsUnknownScheme(t *testing.T) {
+	for _, raw := range []string{"dynamo://table", "file://host/tmp/db", "file:relative/db"} {
+		if _, err := Open(raw); err == nil {
+			t.Errorf("expected %q to be rejected", raw)
+		}
+	}
+	store, err := Open("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := store.(*MemoryStore); !ok {
+		t.Fatalf("expected memory store by default, got %T", store)
+	}
+}
+
+func TestRedisClientFollowsSentinel(t *testing.T) {
+	master := storagetest.NewFakeRedis(t)
+	stale := storagetest.NewFakeRedis(t)
+	stale.Replica = true
+	staleSentinel := storagetest.NewFakeRedis(t)
+	staleSentinel.SentinelMaster = stale.Addr()
+	sentinel := storagetest.NewFakeRedis(t)
+	sentinel.SentinelMaster = master.Addr()
+
+	client, err := NewRedisClient("redis+sentinel://" + staleSentinel.Addr() + "," + sentinel.Addr() + "/mymaster")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	store := NewRedisStore(client)
+	if err := store.Set(context.Background(), "k", []byte("v"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value, _ := master.Value("k"); value != "v" {
+		t.Fatal("expected the write to reach the master the second sentinel named")
+	}
+	if got := strings.Join(sentinel.Commands()[0], " "); got != "SENTINEL GET-MASTER-ADDR-BY-NAME mymaster" {
+		t.Fatalf("unexpected sentinel command %q", got)
+	}
+}
+
+func TestRedisClientFollowsClusterRedirects(t *testing.T) {
+	owner := storagetest.NewFakeRedis(t)
+	seed := storagetest.NewFakeRedis(t)
+	seed.MovedTo = owner.Addr()
+	seed.MovedSlot = redisKeySlot
+
+	client, err := NewRedisClient("redis+cluster://127.0.0.1:1," + seed.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	store := NewRedisStore(client)
+	ctx := context.Background()
+	for _, value := range []string{"a", "b"} {
+		if err := store.Set(ctx, "{user}:k", []byte(value), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	seedCommands := len(seed.Commands())
+	if seedCommands != 1 {
+		t.Fatalf("expected the seed to be asked once before the slot was learned, got %d", seedCommands)
+	}
+	if value, err := store.Get(ctx, "{user}:k"); err != nil || string(value) != "b" {
+		t.Fatalf("expected the owner to hold the key, got %q (%v)", value, err)
+	}
+	if redisKeySlot([]string{"GET", "{user}:a"}) != redisKeySlot([]string{"GET", "{user}:b"}) || redisKeySlot([]string{"GET", "123456789"}) != 0x31C3%redisClusterSlots {
+		t.Fatal("unexpected key slot")
+	}
+}
+
+// stalledConn holds its reads until release is closed.
+type stalledConn struct {
+	net.Conn
+	release chan struct{}
+}
+
+func (c stalledConn) Read(p []byte) (int, error) {
+	<-c.release
+	return c.Conn.Read(p)
+}
+
+func TestRedisClientRunsCommandsConcurrently(t *testing.T) {
+	server := storagetest.NewFakeRedis(t)
+	client, err := NewRedisClient(server.URL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	release := make(chan struct{})
+	var dials atomic.Int32
+	client.SetDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err == nil && dials.Add(1) == 1 {
+			return stalledConn{Conn: conn, release: release}, nil
+		}
+		return conn, err
+	})
+
+	stalled := make(chan error, 1)
+	go func() { stalled <- client.Ping(context.Background()) }()
+	for dials.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("expected a second connection to serve the command while the first stalls: %v", err)
+	}
+	close(release)
+	if err := <-stalled; err != nil {
+		t.Fatalf("unexpected error from the stalled command: %v", err)
+	}
+	if err := client.Ping(context.Background()); err != nil || dials.Load() != 2 {
+		t.Fatalf("expected pooled connections to be reused, got %d dials: %v", dials.Load(), err)
+	}
+}
+
+func TestNewRedisClientParsesTopologies(t *testing.T) {
+	for raw, want := range map[string]string{
+		"redis://:secret@cache:6380/2":                   RedisStandalone,
+		"rediss+sentinel://s1,[::1]:26380/mymaster/1":    RedisSentinel,
+		"redis+cluster://user:secret@n1:7000,n2:7001,n3": RedisCluster,
+	} {
+		client, err := NewRedisClient(raw)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", raw, err)
+		}
+		if client.Topology() != want {
+			t.Fatalf("%s: expected %s, got %s", raw, want, client.Topology())
+		}
+	}
+	client, _ := NewRedisClient("rediss+sentinel://s1,[::1]:26380/mymaster/1")
+	if strings.Join(client.addrs, ",") != "s1:26379,[::1]:26380" || client.master != "mymaster" || client.db != 1 || client.tlsConfig == nil {
+		t.Fatalf("unexpected sentinel client %+v", client)
+	}
+	for _, raw := range []string{
+		"redis://a:6379,b:6379",
+		"redis+sentinel://s1:26379",
+		"redis+cluster://n1:7000/1",
+		"redis+replica://n1",
+	} {
+		if _, err := NewRedisClient(raw); err == nil {
+			t.Errorf("expected %q to be rejected", raw)
+		}
+	}
+}
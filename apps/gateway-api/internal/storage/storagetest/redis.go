@@ -0,0 +1,191 @@
+// Package storagetest provides test doubles for the storage backends.
+package storagetest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// FakeRedis is an in-process RESP server implementing GET, SET (NX, PX), DEL,
+// HINCRBY, HGETALL, SADD, SMEMBERS, EXPIRE, PING, AUTH, ROLE and SENTINEL
+// GET-MASTER-ADDR-BY-NAME. Expiry is ignored; TTL handling is covered by the
+// other backends. Configure the fields before the first connection.
+type FakeRedis struct {
+	// SentinelMaster makes the server a sentinel naming that master address.
+	SentinelMaster string
+	// Replica makes ROLE report a replica.
+	Replica bool
+	// MovedTo makes the server a cluster node redirecting keyed commands to
+	// that address, reporting the slot MovedSlot computes.
+	MovedTo   string
+	MovedSlot func(args []string) uint16
+
+	listener net.Listener
+	mu       sync.Mutex
+	keys     map[string]string
+	hashes   map[string]map[string]int64
+	sets     map[string]map[string]struct{}
+	commands [][]string
+}
+
+// NewFakeRedis starts a server on a loopback port, stopped when t ends.
+func NewFakeRedis(t testing.TB) *FakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	f := &FakeRedis{
+		listener: listener,
+		keys:     make(map[string]string),
+		hashes:   make(map[string]map[string]int64),
+		sets:     make(map[string]map[string]struct{}),
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+// Addr returns the host:port the server listens on.
+func (f *FakeRedis) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// URL returns a redis:// URL for the server.
+func (f *FakeRedis) URL() string {
+	return "redis://" + f.Addr()
+}
+
+// Commands returns every command received so far, in order.
+func (f *FakeRedis) Commands() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string(nil), f.commands...)
+}
+
+// Value returns the string stored under key.
+func (f *FakeRedis) Value(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.keys[key]
+	return value, ok
+}
+
+func (f *FakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		count, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+		args := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			sizeLine, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			size, _ := strconv.Atoi(strings.TrimSpace(sizeLine[1:]))
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				return
+			}
+			args = append(args, string(buf[:size]))
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, args)
+		reply := f.reply(args)
+		f.mu.Unlock()
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (f *FakeRedis) reply(args []string) string {
+	switch command := strings.ToUpper(args[0]); {
+	case f.MovedTo != "" && len(args) > 1 && command != "AUTH" && command != "SELECT":
+		return "-MOVED " + strconv.Itoa(int(f.MovedSlot(args))) + " " + f.MovedTo + "\r\n"
+	case command == "SENTINEL" && f.SentinelMaster != "":
+		host, port, _ := net.SplitHostPort(f.SentinelMaster)
+		return array([]string{host, port})
+	case command == "ROLE" && f.Replica:
+		return array([]string{"slave"})
+	case command == "ROLE":
+		return array([]string{"master"})
+	case command == "PING":
+		return "+PONG\r\n"
+	case command == "AUTH", command == "SELECT":
+		return "+OK\r\n"
+	case command == "GET":
+		if value, ok := f.keys[args[1]]; ok {
+			return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+		}
+		return "$-1\r\n"
+	case command == "SET":
+		nx := false
+		for _, arg := range args[3:] {
+			nx = nx || strings.EqualFold(arg, "NX")
+		}
+		if _, exists := f.keys[args[1]]; exists && nx {
+			return "$-1\r\n"
+		}
+		f.keys[args[1]] = args[2]
+		return "+OK\r\n"
+	case command == "DEL":
+		delete(f.keys, args[1])
+		return ":1\r\n"
+	case command == "HINCRBY":
+		if f.hashes[args[1]] == nil {
+			f.hashes[args[1]] = make(map[string]int64)
+		}
+		delta, _ := strconv.ParseInt(args[3], 10, 64)
+		f.hashes[args[1]][args[2]] += delta
+		return ":" + strconv.FormatInt(f.hashes[args[1]][args[2]], 10) + "\r\n"
+	case command == "HGETALL":
+		var fields []string
+		for field, value := range f.hashes[args[1]] {
+			fields = append(fields, field, strconv.FormatInt(value, 10))
+		}
+		return array(fields)
+	case command == "SADD":
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = make(map[string]struct{})
+		}
+		f.sets[args[1]][args[2]] = struct{}{}
+		return ":1\r\n"
+	case command == "SMEMBERS":
+		var members []string
+		for member := range f.sets[args[1]] {
+			members = append(members, member)
+		}
+		return array(members)
+	case command == "EXPIRE":
+		return ":1\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func array(values []string) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(values)) + "\r\n")
+	for _, value := range values {
+		b.WriteString("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")
+	}
+	return b.String()
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
@@ -18,27 +19,41 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 
 	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/deployment"
 	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/gateway"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/lifecycle"
 	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/observability/tracing"
 )
 
 func main() {
+	os.Exit(runCommand(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// runServe implements "gateway-api serve", the default command: it starts the
+// public and admin servers and runs until SIGINT or SIGTERM.
+func runServe() int {
 	ctx := context.Background()
+	// Levels are applied by the gateway handler, so the JSON handler itself
+	// accepts everything.
+	slog.SetDefault(slog.New(gateway.NewLogHandler(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	if err := gateway.ValidateLogLevelConfig(); err != nil {
+		log.Fatalf("invalid log level configuration: %v", err)
+	}
+	notifyVerboseLoggingToggle()
+	if err := initDeployment(ctx); err != nil {
+		log.Fatalf("invalid deployment metadata configuration: %v", err)
+	}
 	shutdownTracing, err := tracing.Init(ctx)
 	if err != nil {
 		log.Fatalf("failed to initialize tracing: %v", err)
 	}
-	if shutdownTracing != nil {
-		defer func() {
-			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			if err := shutdownTracing(shutdownCtx); err != nil {
-				log.Printf("failed to shutdown tracing provider: %v", err)
-			}
-		}()
-	}
+	manager := lifecycle.New()
+	mustRegister(manager, lifecycle.Component{
+		Name:        "tracing",
+		Stop:        shutdownTracing,
+		StopTimeout: 5 * time.Second,
+	})
 
-	mux := http.NewServeMux()
 	startTime := time.Now()
 	if _, err := validateServiceURL("ORCHESTRATOR_URL", "http://127.0.0.1:4000"); err != nil {
 		log.Fatalf("invalid ORCHESTRATOR_URL: %v", err)
@@ -53,19 +68,118 @@ func main() {
 	if err != nil {
 		log.Fatalf("invalid trusted proxy configuration: %v", err)
 	}
+	if err := gateway.ValidatePublicBaseURL(); err != nil {
+		log.Fatalf("invalid public base URL: %v", err)
+	}
+	if err := audit.ValidateConfig(); err != nil {
+		log.Fatalf("invalid audit configuration: %v", err)
+	}
+	if err := gateway.ValidateServiceTokenConfig(); err != nil {
+		log.Fatalf("invalid service token configuration: %v", err)
+	}
+	if err := gateway.ValidateForwardedHeaderConfig(); err != nil {
+		log.Fatalf("invalid forwarded header configuration: %v", err)
+	}
+	if err := gateway.ValidateUpstreamCallAuditConfig(); err != nil {
+		log.Fatalf("invalid upstream call audit configuration: %v", err)
+	}
+	if err := gateway.ValidateRouteSuggestionConfig(); err != nil {
+		log.Fatalf("invalid route suggestion configuration: %v", err)
+	}
+	if err := gateway.ValidateAuthorizePassthroughConfig(); err != nil {
+		log.Fatalf("invalid authorize passthrough configuration: %v", err)
+	}
+	if err := gateway.ValidateRegionHeaderConfig(); err != nil {
+		log.Fatalf("invalid region header configuration: %v", err)
+	}
+	if err := gateway.ValidateRequestSigningConfig(); err != nil {
+		log.Fatalf("invalid request signing configuration: %v", err)
+	}
+	if err := gateway.ValidateEgressConfig(); err != nil {
+		log.Fatalf("invalid egress proxy configuration: %v", err)
+	}
+	if err := gateway.ValidateCollaborationSessionCacheConfig(); err != nil {
+		log.Fatalf("invalid collaboration session cache configuration: %v", err)
+	}
+	if err := gateway.ValidateCollaborationAuthorizerConfig(); err != nil {
+		log.Fatalf("invalid collaboration authorization configuration: %v", err)
+	}
+	if err := gateway.ValidateSSERedactionConfig(); err != nil {
+		log.Fatalf("invalid SSE redaction configuration: %v", err)
+	}
+	if err := gateway.ValidatePlanShareConfig(); err != nil {
+		log.Fatalf("invalid plan share configuration: %v", err)
+	}
+	if err := gateway.ValidateEventsTenantIsolationConfig(); err != nil {
+		log.Fatalf("invalid SSE tenant isolation configuration: %v", err)
+	}
+	if err := gateway.ValidateCollaborationProtocolConfig(); err != nil {
+		log.Fatalf("invalid collaboration protocol configuration: %v", err)
+	}
+	if err := gateway.ValidateCollaborationAffinityConfig(); err != nil {
+		log.Fatalf("invalid collaboration affinity configuration: %v", err)
+	}
+	if err := gateway.ValidateCollaborationReadOnlyConfig(); err != nil {
+		log.Fatalf("invalid collaboration read-only configuration: %v", err)
+	}
+	if err := gateway.ValidateTenantConfig(); err != nil {
+		log.Fatalf("invalid tenant configuration: %v", err)
+	}
+	if err := gateway.ValidateColdStartPolicy(); err != nil {
+		log.Fatalf("invalid cold-start policy: %v", err)
+	}
+	if err := gateway.ValidateDNSCacheConfig(); err != nil {
+		log.Fatalf("invalid DNS cache configuration: %v", err)
+	}
+	if err := gateway.ValidateSlowRequestConfig(); err != nil {
+		log.Fatalf("invalid slow request configuration: %v", err)
+	}
+	if err := gateway.ValidateHealthCheckConfig(); err != nil {
+		log.Fatalf("invalid health check configuration: %v", err)
+	}
+	if err := gateway.ValidateSLOConfig(); err != nil {
+		log.Fatalf("invalid SLO configuration: %v", err)
+	}
+	if err := gateway.ValidateHeaderLimitConfig(); err != nil {
+		log.Fatalf("invalid header limit configuration: %v", err)
+	}
+	if err := gateway.ValidateRequestHygieneConfig(); err != nil {
+		log.Fatalf("invalid request hygiene configuration: %v", err)
+	}
+	if err := gateway.ValidateHoneypotConfig(); err != nil {
+		log.Fatalf("invalid honeypot configuration: %v", err)
+	}
+	if err := gateway.ValidateClockConfig(); err != nil {
+		log.Fatalf("invalid clock configuration: %v", err)
+	}
+	if err := gateway.ValidateAuthTraceConfig(); err != nil {
+		log.Fatalf("invalid auth debug capture configuration: %v", err)
+	}
+	if err := gateway.ValidateProviderCallConfig(); err != nil {
+		log.Fatalf("invalid auth provider timeout configuration: %v", err)
+	}
+	if err := gateway.ValidateStepUpConfig(); err != nil {
+		log.Fatalf("invalid step-up configuration: %v", err)
+	}
+	if err := gateway.ValidateUpstreamAPIConfig(); err != nil {
+		log.Fatalf("invalid upstream API configuration: %v", err)
+	}
+	if err := gateway.ValidateUpstreamResponseConfig(); err != nil {
+		log.Fatalf("invalid upstream response configuration: %v", err)
+	}
 	if err := validateStateCookieConfig(allowInsecureStateCookie); err != nil {
 		log.Fatalf("oauth state cookie configuration invalid: %v", err)
 	}
 	if allowInsecureStateCookie {
 		log.Printf("warning: OAUTH_ALLOW_INSECURE_STATE_COOKIE enabled; this should only be used for local development")
 	}
-	gateway.RegisterAuthRoutes(mux, gateway.AuthRouteConfig{
-		TrustedProxyCIDRs:        trustedProxyCIDRs,
-		AllowInsecureStateCookie: allowInsecureStateCookie,
-	})
-	gateway.RegisterHealthRoutes(mux, startTime)
-	gateway.RegisterEventRoutes(mux, gateway.EventRouteConfig{TrustedProxyCIDRs: trustedProxyCIDRs})
-	gateway.RegisterCollaborationRoutes(mux, gateway.CollaborationRouteConfig{TrustedProxyCIDRs: trustedProxyCIDRs})
+	if err := gateway.CheckClockDrift(context.Background()); err != nil {
+		log.Fatalf("clock drift check failed: %v", err)
+	}
+	router, err := buildPublicRouter(trustedProxyCIDRs, allowInsecureStateCookie, startTime)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -73,49 +187,246 @@ func main() {
 	}
 
 	globalLimiter := gateway.NewGlobalRateLimiter(trustedNetworks)
-	maxBodyBytes := maxRequestBodyBytesFromEnv()
-	handler := buildHTTPHandler(mux, globalLimiter, maxBodyBytes)
+	handler := buildHTTPHandler(gateway.RegionHeaderMiddleware(gateway.PublicBaseURLMiddleware(gateway.HoneypotMiddleware(router, trustedNetworks), trustedNetworks), trustedNetworks), globalLimiter, trustedNetworks)
 
 	server := &http.Server{
-		Addr:         ":" + port,
-		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:           ":" + port,
+		Handler:        handler,
+		ConnContext:    gateway.RequestHygieneConnContext,
+		ReadTimeout:    15 * time.Second,
+		WriteTimeout:   60 * time.Second,
+		IdleTimeout:    60 * time.Second,
+		MaxHeaderBytes: gateway.ServerMaxHeaderBytes(),
+	}
+
+	adminServer, err := buildAdminServer(startTime)
+	if err != nil {
+		log.Fatalf("admin api configuration invalid: %v", err)
+	}
+
+	// Servers depend on maintenance so they stop accepting requests before
+	// the final usage flush runs, and maintenance depends on storage.
+	mustRegister(manager, lifecycle.Component{
+		Name:        "storage",
+		DependsOn:   []string{"tracing"},
+		Start:       func(context.Context) error { return gateway.InitStateStorage() },
+		Stop:        func(context.Context) error { return gateway.CloseStateStorage() },
+		StopTimeout: 5 * time.Second,
+	})
+	mustRegister(manager, maintenanceComponent([]string{"storage"}))
+	serverDeps := []string{"maintenance"}
+	if adminServer != nil {
+		mustRegister(manager, serverComponent(manager, "admin-api", adminServer, serverDeps, nil))
+	}
+	publicDeps := serverDeps
+	// The public listener is bound only once the dependencies answer, while
+	// the admin listener already serves /healthz.
+	if gateway.DependencyWaitEnabled() {
+		waitDeps := serverDeps
+		if adminServer != nil {
+			waitDeps = []string{"admin-api"}
+		}
+		mustRegister(manager, lifecycle.Component{
+			Name:      "dependency-wait",
+			DependsOn: waitDeps,
+			Start:     gateway.WaitForDependencies,
+		})
+		publicDeps = []string{"dependency-wait"}
+	}
+	// The orchestrator's API version is probed before the public listener is
+	// bound so the first requests already use the paths it serves.
+	mustRegister(manager, lifecycle.Component{
+		Name:      "upstream-api-version",
+		DependsOn: publicDeps,
+		Start:     gateway.DetectUpstreamAPIVersions,
+	})
+	publicDeps = []string{"upstream-api-version"}
+	mustRegister(manager, serverComponent(manager, "http", server, publicDeps, gateway.RequestHygieneListener))
+
+	if err := manager.Start(ctx); err != nil {
+		log.Fatalf("gateway-api failed to start: %v", err)
 	}
 
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		sig := <-shutdown
+	exitCode := 0
+	select {
+	case sig := <-shutdown:
 		log.Printf("received %s, initiating shutdown", sig)
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("graceful shutdown failed: %v", err)
-		}
-	}()
+	case err := <-manager.Failed():
+		log.Printf("shutting down after failure: %v", err)
+		exitCode = 1
+	}
+	if err := manager.Stop(context.Background()); err != nil {
+		log.Printf("shutdown incomplete: %v", err)
+		exitCode = 1
+	}
+	return exitCode
+}
+
+// buildPublicRouter registers every public route and applies the request body
+// limits.
+func buildPublicRouter(trustedProxyCIDRs []string, allowInsecureStateCookie bool, startTime time.Time) (*gateway.Router, error) {
+	routeBodyLimits, err := gateway.RouteBodyLimits(gateway.GetEnv("GATEWAY_ROUTE_BODY_LIMITS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GATEWAY_ROUTE_BODY_LIMITS: %w", err)
+	}
+	mux := http.NewServeMux()
+	gateway.RegisterAuthRoutes(mux, gateway.AuthRouteConfig{
+		TrustedProxyCIDRs:        trustedProxyCIDRs,
+		AllowInsecureStateCookie: allowInsecureStateCookie,
+	})
+	gateway.RegisterHealthRoutes(mux, startTime)
+	gateway.RegisterEventRoutes(mux, gateway.EventRouteConfig{TrustedProxyCIDRs: trustedProxyCIDRs})
+	gateway.RegisterAttachmentRoutes(mux, gateway.AttachmentRouteConfig{TrustedProxyCIDRs: trustedProxyCIDRs})
+	gateway.RegisterArtifactRoutes(mux, gateway.ArtifactRouteConfig{TrustedProxyCIDRs: trustedProxyCIDRs})
+	gateway.RegisterCollaborationRoutes(mux, gateway.CollaborationRouteConfig{TrustedProxyCIDRs: trustedProxyCIDRs})
+	gateway.RegisterSCIMRoutes(mux, gateway.SCIMRouteConfig{TrustedProxyCIDRs: trustedProxyCIDRs})
+	gateway.RegisterRevocationRoutes(mux, gateway.RevocationRouteConfig{TrustedProxyCIDRs: trustedProxyCIDRs})
+	router := gateway.NewRouter(mux)
+	router.SetBodyLimits(maxRequestBodyBytesFromEnv(), routeBodyLimits)
+	return router, nil
+}
+
+func mustRegister(manager *lifecycle.Manager, component lifecycle.Component) {
+	if err := manager.Register(component); err != nil {
+		log.Fatalf("lifecycle configuration invalid: %v", err)
+	}
+}
 
-	log.Printf("gateway-api listening on http://127.0.0.1:%s", port)
+// maintenanceComponent runs the background job scheduler. Stopping it waits
+// for in-flight jobs and the final usage flush.
+func maintenanceComponent(deps []string) lifecycle.Component {
+	var (
+		cancel context.CancelFunc
+		done   = make(chan struct{})
+	)
+	return lifecycle.Component{
+		Name:      "maintenance",
+		DependsOn: deps,
+		Start: func(ctx context.Context) error {
+			scheduler, err := gateway.NewMaintenanceScheduler()
+			if err != nil {
+				return err
+			}
+			var runCtx context.Context
+			runCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+			go func() {
+				defer close(done)
+				scheduler.Run(runCtx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
 
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("server error: %v", err)
+// serverComponent binds server's listener during Start so address errors
+// fail startup, then serves in the background. A serve error after startup
+// is reported through manager.Fail. A non-nil wrap wraps the listener.
+func serverComponent(manager *lifecycle.Manager, name string, server *http.Server, deps []string, wrap func(net.Listener) net.Listener) lifecycle.Component {
+	return lifecycle.Component{
+		Name:      name,
+		DependsOn: deps,
+		Start: func(context.Context) error {
+			listener, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				return err
+			}
+			if wrap != nil {
+				listener = wrap(listener)
+			}
+			log.Printf("gateway-api %s listening on %s", name, listener.Addr())
+			go func() {
+				if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					manager.Fail(fmt.Errorf("%s server: %w", name, err))
+				}
+			}()
+			return nil
+		},
+		Stop: server.Shutdown,
 	}
 }
 
-func buildHTTPHandler(base http.Handler, limiter *gateway.GlobalRateLimiter, maxBodyBytes int64) http.Handler {
-	handler := http.Handler(base)
-	if maxBodyBytes > 0 {
-		handler = gateway.RequestBodyLimitMiddleware(handler, maxBodyBytes)
+// buildAdminServer returns the admin API server when GATEWAY_ADMIN_ADDR is set.
+// The admin API is never exposed on the public listener.
+func buildAdminServer(startTime time.Time) (*http.Server, error) {
+	addr := strings.TrimSpace(gateway.GetEnv("GATEWAY_ADMIN_ADDR", ""))
+	if addr == "" {
+		return nil, nil
+	}
+	token, err := gateway.LoadAdminToken()
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, fmt.Errorf("GATEWAY_ADMIN_TOKEN is required when GATEWAY_ADMIN_ADDR is set")
+	}
+	return &http.Server{
+		Addr:           addr,
+		Handler:        gateway.SecurityHeadersMiddleware(audit.Middleware(gateway.HeaderLimitMiddleware(gateway.RequestCanonicalizationMiddleware(buildAdminRouter(token, startTime), maxURLBytesFromEnv()), nil))),
+		ReadTimeout:    15 * time.Second,
+		WriteTimeout:   60 * time.Second,
+		IdleTimeout:    60 * time.Second,
+		MaxHeaderBytes: gateway.ServerMaxHeaderBytes(),
+	}, nil
+}
+
+func buildAdminRouter(token string, startTime time.Time) *gateway.Router {
+	mux := http.NewServeMux()
+	gateway.RegisterAdminRoutes(mux, gateway.AdminRouteConfig{Token: token, StartedAt: startTime})
+	return gateway.NewRouter(mux)
+}
+
+// initDeployment resolves where this replica runs for audit records, metrics
+// and the X-Gateway-Region header, and adds it to OTEL_RESOURCE_ATTRIBUTES
+// before tracing reads it. An unreachable metadata service is logged rather
+// than fatal: the gateway runs with what GATEWAY_REGION and friends provide.
+func initDeployment(ctx context.Context) error {
+	if err := deployment.ValidateConfig(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, deployment.ResolveTimeout)
+	defer cancel()
+	replica, err := deployment.Resolve(ctx)
+	if err != nil {
+		slog.Warn("gateway.deployment.metadata_failed", slog.String("error", err.Error()))
 	}
+	deployment.Set(replica)
+	if attrs := replica.MergeResourceAttributes(os.Getenv("OTEL_RESOURCE_ATTRIBUTES")); attrs != "" {
+		_ = os.Setenv("OTEL_RESOURCE_ATTRIBUTES", attrs)
+	}
+	slog.Info("gateway.deployment", slog.Any("deployment", replica))
+	return nil
+}
+
+// buildHTTPHandler wraps the public router in the shared middleware. Body
+// limits are applied per route by the router itself.
+func buildHTTPHandler(base http.Handler, limiter *gateway.GlobalRateLimiter, trusted []*net.IPNet) http.Handler {
+	// Canonicalize the request target before anything routes on it.
+	handler := gateway.RequestCanonicalizationMiddleware(base, maxURLBytesFromEnv())
 	if limiter != nil {
 		handler = limiter.Middleware(handler)
 	}
+	// Ambiguously framed requests and oversized headers are refused before
+	// they are rate limited or forwarded.
+	handler = gateway.HeaderLimitMiddleware(handler, trusted)
+	handler = gateway.RequestHygieneMiddleware(handler, trusted)
 	// Order middlewares so that audit instrumentation always seeds the request
 	// identifier before rate limiting decisions are made while security headers
 	// remain on all responses, including 429s.
 	handler = gateway.SecurityHeadersMiddleware(handler)
+	handler = gateway.SLOMiddleware(handler)
+	handler = gateway.DrainMiddleware(handler)
+	handler = gateway.SlowRequestMiddleware(handler)
 	handler = audit.Middleware(handler)
 	return otelhttp.NewHandler(handler, "gateway.http.request", otelhttp.WithPublicEndpoint())
 }
@@ -165,6 +476,18 @@ func maxRequestBodyBytesFromEnv() int64 {
 	return parsed
 }
 
+func maxURLBytesFromEnv() int {
+	value := strings.TrimSpace(gateway.GetEnv("GATEWAY_MAX_URL_BYTES", ""))
+	if value == "" {
+		return gateway.DefaultMaxURLBytes()
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		return gateway.DefaultMaxURLBytes()
+	}
+	return parsed
+}
+
 func validateStateCookieConfig(allowInsecure bool) error {
 	if !allowInsecure {
 		return nil
@@ -143,8 +143,8 @@ func TestMainHTTPServer(t *testing.T) {
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte("OK"))
 		}),
-		nil,       // No rate limiter for test
-		1024*1024, // 1MB max body
+		nil, // No rate limiter for test
+		nil,
 	)
 
 	server := httptest.NewServer(handler)
@@ -275,7 +275,7 @@ func TestBuildHTTPHandler(t *testing.T) {
 	})
 
 	// Build with middleware
-	handler := buildHTTPHandler(baseHandler, nil, 1024)
+	handler := buildHTTPHandler(baseHandler, nil, nil)
 
 	// Create test request
 	req := httptest.NewRequest("GET", "/test", nil)
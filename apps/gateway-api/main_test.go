@@ -1,13 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
 	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/gateway"
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/lifecycle"
 )
 
 func TestNormalizeServiceURL(t *testing.T) {
@@ -184,6 +193,31 @@ func TestMaxRequestBodyBytesFromEnv(t *testing.T) {
 	}
 }
 
+func TestMaxURLBytesFromEnv(t *testing.T) {
+	defaults := gateway.DefaultMaxURLBytes()
+
+	cases := []struct {
+		name string
+		env  string
+		want int
+	}{
+		{name: "unset", env: "", want: defaults},
+		{name: "invalid number", env: "abc", want: defaults},
+		{name: "zero", env: "0", want: defaults},
+		{name: "valid", env: "2048", want: 2048},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("GATEWAY_MAX_URL_BYTES", tc.env)
+
+			if got := maxURLBytesFromEnv(); got != tc.want {
+				t.Fatalf("maxURLBytesFromEnv() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
 func TestRateLimitedResponsesIncludeRequestID(t *testing.T) {
 	t.Setenv("GATEWAY_HTTP_RATE_LIMIT_MAX", "1")
 	t.Setenv("GATEWAY_HTTP_RATE_LIMIT_WINDOW", "1m")
@@ -191,7 +225,7 @@ func TestRateLimitedResponsesIncludeRequestID(t *testing.T) {
 	limiter := gateway.NewGlobalRateLimiter(nil)
 	handler := buildHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-	}), limiter, gateway.DefaultMaxRequestBodyBytes())
+	}), limiter, nil)
 
 	first := httptest.NewRecorder()
 	firstReq := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -275,3 +309,360 @@ func TestValidateStateCookieConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildAdminServer(t *testing.T) {
+	t.Setenv("GATEWAY_ADMIN_ADDR", "")
+	t.Setenv("GATEWAY_ADMIN_TOKEN", "")
+	if server, err := buildAdminServer(time.Now()); err != nil || server != nil {
+		t.Fatalf("expected admin api to be disabled by default, got %v, %v", server, err)
+	}
+
+	t.Setenv("GATEWAY_ADMIN_ADDR", "127.0.0.1:9091")
+	if _, err := buildAdminServer(time.Now()); err == nil {
+		t.Fatal("expected error when admin token is missing")
+	}
+
+	t.Setenv("GATEWAY_ADMIN_TOKEN", "s3cret")
+	server, err := buildAdminServer(time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/admin/upstreams", nil)
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the liveness probe without token, got %d", rec.Code)
+	}
+}
+
+func TestRunReconcileUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	t.Setenv("GATEWAY_USAGE_EXPORT_URL", "")
+	if code := runReconcileUsage(context.Background(), nil, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2 without an export url, got %d", code)
+	}
+
+	t.Setenv("GATEWAY_USAGE_EXPORT_URL", "file://"+t.TempDir())
+	if code := runReconcileUsage(context.Background(), []string{"-from", "yesterday"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2 for an invalid range, got %d", code)
+	}
+
+	stdout.Reset()
+	args := []string{"-from", "2026-03-04T10:00:00Z", "-to", "2026-03-04T12:00:00Z"}
+	if code := runReconcileUsage(context.Background(), args, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1 for an empty export, got %d: %s", code, stderr.String())
+	}
+	var report gateway.UsageExportReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	if len(report.Missing) != 2 {
+		t.Fatalf("expected two missing hours, got %+v", report)
+	}
+}
+
+func TestRunMigrate(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runMigrate(context.Background(), []string{"extra"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2 for unexpected arguments, got %d", code)
+	}
+
+	t.Setenv("GATEWAY_STORAGE_URL", "dynamo://table")
+	if code := runMigrate(context.Background(), nil, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1 for an unusable store, got %d", code)
+	}
+
+	t.Setenv("GATEWAY_STORAGE_URL", "file://"+filepath.Join(t.TempDir(), "state.db"))
+	stdout.Reset()
+	if code := runMigrate(context.Background(), []string{"-dry-run"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	var report gateway.StorageMigrationReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	if !report.DryRun || report.SchemaVersion != report.TargetVersion {
+		t.Fatalf("expected an up-to-date dry run, got %+v", report)
+	}
+}
+
+func TestRunDrain(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	t.Setenv("GATEWAY_ADMIN_ADDR", "")
+	if code := runDrain(context.Background(), nil, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2 without an admin address, got %d", code)
+	}
+
+	admin := httptest.NewServer(buildAdminRouter("s3cret", time.Now()))
+	defer admin.Close()
+	t.Setenv("GATEWAY_ADMIN_ADDR", strings.TrimPrefix(admin.URL, "http://"))
+	t.Setenv("GATEWAY_ADMIN_TOKEN", "wrong")
+	t.Setenv("GATEWAY_DRAIN_DELAY", "10ms")
+	if code := runDrain(context.Background(), nil, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1 for a rejected token, got %d", code)
+	}
+
+	t.Setenv("GATEWAY_ADMIN_TOKEN", "s3cret")
+	stdout.Reset()
+	if code := runDrain(context.Background(), nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	var state struct {
+		Draining bool `json:"draining"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &state); err != nil || !state.Draining {
+		t.Fatalf("expected the gateway to drain, got %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := runDrain(context.Background(), []string{"-cancel"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &state); err != nil || state.Draining {
+		t.Fatalf("expected the drain to be cancelled, got %s", stdout.String())
+	}
+}
+
+func TestRunRehashAudit(t *testing.T) {
+	t.Setenv("GATEWAY_AUDIT_SALT", "old-salt")
+	stored := audit.Default().HashIdentity("tenant", "acme")
+
+	t.Setenv("GATEWAY_AUDIT_HASH_ALGORITHM", "hmac-sha256")
+	t.Setenv("GATEWAY_AUDIT_HMAC_KEY", strings.Repeat("k", 32))
+	t.Setenv("GATEWAY_AUDIT_PREVIOUS_HASH_ALGORITHM", "sha256")
+	t.Setenv("GATEWAY_AUDIT_PREVIOUS_HASH_SECRET", "old-salt")
+	t.Setenv("GATEWAY_AUDIT_PREVIOUS_HASH_UNTIL", time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	current := audit.Default().HashIdentity("tenant", "acme")
+
+	var stdout, stderr bytes.Buffer
+	input := `{"hash":"` + stored + `","parts":["tenant","acme"]}` + "\n" + `{"hash":"` + stored + `","parts":["tenant","globex"]}` + "\n"
+	if code := runRehashAudit(strings.NewReader(input), &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1 for an unmatched entry, got %d: %s", code, stderr.String())
+	}
+	if want := `{"hash":"` + stored + `","rehashed":"` + current + `"}` + "\n"; stdout.String() != want {
+		t.Fatalf("expected %s, got %s", want, stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "line 2:") {
+		t.Fatalf("expected the unmatched line to be reported, got %s", stderr.String())
+	}
+
+	if code := runRehashAudit(strings.NewReader(`{"parts":["tenant","acme"]}`), &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2 for an entry without a hash, got %d", code)
+	}
+	t.Setenv("GATEWAY_AUDIT_PREVIOUS_HASH_UNTIL", "")
+	if code := runRehashAudit(strings.NewReader(""), &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2 for an invalid configuration, got %d", code)
+	}
+}
+
+func TestRunAuditCorrelationID(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runAuditCorrelationID([]string{"-vectors"}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	var vectors correlationVectors
+	if err := json.Unmarshal(stdout.Bytes(), &vectors); err != nil || len(vectors.Vectors) != len(audit.CorrelationKinds) {
+		t.Fatalf("unexpected vectors %s: %v", stdout.String(), err)
+	}
+
+	t.Setenv("GATEWAY_AUDIT_CORRELATION_KEY", "")
+	if code := runAuditCorrelationID(nil, strings.NewReader(""), &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2 without a key, got %d", code)
+	}
+
+	key := strings.Repeat("c", 32)
+	t.Setenv("GATEWAY_AUDIT_CORRELATION_KEY", key)
+	acme := audit.CorrelationID([]byte(key), audit.CorrelationTenant, "acme")
+	input := `{"kind":"tenant","value":"acme","correlation_id":"` + acme + `"}` + "\n" +
+		`{"kind":"session","value":"s-1"}` + "\n" +
+		`{"kind":"tenant","value":"globex","correlation_id":"` + acme + `"}` + "\n"
+	stdout.Reset()
+	if code := runAuditCorrelationID(nil, strings.NewReader(input), &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1 for a mismatch, got %d: %s", code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"match":true`) || strings.Contains(lines[1], "match") || !strings.Contains(lines[2], `"match":false`) {
+		t.Fatalf("unexpected results %s", stdout.String())
+	}
+	if strings.Contains(stdout.String(), "globex") {
+		t.Fatalf("expected raw identifiers to stay out of the output, got %s", stdout.String())
+	}
+
+	if code := runAuditCorrelationID(nil, strings.NewReader(`{"kind":"device","value":"d-1"}`), &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2 for an unknown kind, got %d", code)
+	}
+}
+
+func TestRunCheckReportsProviderFailures(t *testing.T) {
+	t.Setenv("ORCHESTRATOR_URL", "")
+	t.Setenv("INDEXER_URL", "")
+	t.Setenv("GOOGLE_OAUTH_CLIENT_ID", "")
+	t.Setenv("OPENROUTER_CLIENT_ID", "openrouter-client")
+	t.Setenv("OIDC_ISSUER_URL", "")
+	t.Setenv("OIDC_CLIENT_ID", "")
+
+	var stdout bytes.Buffer
+	if code := runCheck(&stdout); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+
+	// A client ID without an issuer is caught before anyone signs in.
+	t.Setenv("OIDC_CLIENT_ID", "oidc-client")
+	stdout.Reset()
+	if code := runCheck(&stdout); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, stdout.String())
+	}
+	var report checkReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	if report.OK || report.Providers.Providers["oidc"].Status != "fail" || report.Providers.Providers["openrouter"].Status == "fail" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestRunCommandDispatch(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runCommand(context.Background(), []string{"help"}, nil, &stdout, &stderr); code != 0 || !strings.Contains(stdout.String(), "secrets verify") {
+		t.Fatalf("expected help to list the commands, got %d: %s", code, stdout.String())
+	}
+	if code := runCommand(context.Background(), []string{"secrets"}, nil, &stdout, &stderr); code != 2 || !strings.Contains(stderr.String(), `unknown command "secrets"`) {
+		t.Fatalf("expected an incomplete command to be rejected, got %d: %s", code, stderr.String())
+	}
+	stdout.Reset()
+	if code := runCommand(context.Background(), []string{"audit", "verify"}, nil, &stdout, &stderr); code != 2 || !strings.Contains(stderr.String(), "usage: gateway-api audit verify <file>") {
+		t.Fatalf("expected audit verify to require a file, got %d: %s", code, stderr.String())
+	}
+}
+
+func TestRunRoutes(t *testing.T) {
+	t.Setenv("GATEWAY_ADMIN_ADDR", "")
+	t.Setenv("GATEWAY_ROUTE_BODY_LIMITS", "POST /plan/{id}/attachments=1048576")
+	t.Setenv("GATEWAY_FORWARDED_HEADERS_EVENTS", "X-Feature-Flags:64")
+	var stdout, stderr bytes.Buffer
+	if code := runRoutes(nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	var report routesReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	routes := make(map[string]gateway.RouteInfo, len(report.Public))
+	for _, route := range report.Public {
+		routes[route.Pattern] = route
+	}
+	if got := routes["GET /collaboration/ws"].Policies; !reflect.DeepEqual(got, []string{"streaming", "auth:session", "connection_limit:ip", "lockout:auth_failure", "collaboration:read_only", "forward_headers:collaboration"}) {
+		t.Fatalf("unexpected collaboration policies %v", got)
+	}
+	if headers := routes["GET /events"].ForwardedHeaders; headers["X-Feature-Flags"] != 64 || headers["Traceparent"] == 0 {
+		t.Fatalf("unexpected forwarded headers %v", headers)
+	}
+	if routes["POST /plan/{id}/attachments"].MaxBodyBytes != 1048576 || routes["GET /healthz"].Pattern == "" {
+		t.Fatalf("unexpected routes %s", stdout.String())
+	}
+	if len(report.Admin) != 0 {
+		t.Fatalf("expected no admin routes without GATEWAY_ADMIN_ADDR, got %v", report.Admin)
+	}
+
+	t.Setenv("GATEWAY_ADMIN_ADDR", "127.0.0.1:9091")
+	stdout.Reset()
+	if code := runRoutes([]string{"-table"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "GET /admin/jobs") || !strings.Contains(stdout.String(), "auth:admin_token") {
+		t.Fatalf("expected the admin routes in the table, got %s", stdout.String())
+	}
+}
+
+func TestRunSecretsVerify(t *testing.T) {
+	t.Setenv("GATEWAY_COOKIE_HASH_KEY", strings.Repeat("h", 64))
+	t.Setenv("GATEWAY_COOKIE_BLOCK_KEY", strings.Repeat("b", 32))
+	t.Setenv("GATEWAY_ADMIN_ADDR", "")
+	t.Setenv("GATEWAY_IDENTITY_ASSERTION_KEY", "")
+	var stdout bytes.Buffer
+	if code := runSecretsVerify(&stdout); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+
+	t.Setenv("GATEWAY_COOKIE_BLOCK_KEY", "too-short")
+	t.Setenv("GATEWAY_ADMIN_ADDR", "127.0.0.1:9091")
+	t.Setenv("GATEWAY_ADMIN_TOKEN", "")
+	stdout.Reset()
+	if code := runSecretsVerify(&stdout); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, stdout.String())
+	}
+	if strings.Contains(stdout.String(), "too-short") {
+		t.Fatal("expected secret values to stay out of the report")
+	}
+	var report gateway.SecretsReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	statuses := make(map[string]string, len(report.Secrets))
+	for _, secret := range report.Secrets {
+		statuses[secret.Name] = secret.Status
+	}
+	if statuses["GATEWAY_COOKIE_BLOCK_KEY"] != gateway.SecretInvalid || statuses["GATEWAY_ADMIN_TOKEN"] != gateway.SecretInvalid || statuses["GATEWAY_IDENTITY_ASSERTION_KEY"] != gateway.SecretUnset {
+		t.Fatalf("unexpected statuses %v", statuses)
+	}
+}
+
+func TestRunAuditVerify(t *testing.T) {
+	var logs bytes.Buffer
+	original := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	audit.Default().Info(context.Background(), audit.Event{Name: "plan.events.subscribe", Outcome: "success", Target: "plan"})
+	slog.Info("gateway-api listening")
+	slog.SetDefault(original)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, logs.Bytes(), 0o600); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runAuditVerify([]string{path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	var report auditVerifyReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil || report.Records != 1 || report.Skipped != 1 {
+		t.Fatalf("unexpected report %s", stdout.String())
+	}
+
+	tampered := append(logs.Bytes(), []byte(`{"msg":"gateway.audit.info","schema_version":"2","event":"plan.deleted","outcome":"success","target":"plan"}`+"\n{not json\n")...)
+	if err := os.WriteFile(path, tampered, 0o600); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+	stdout.Reset()
+	if code := runAuditVerify([]string{path}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, stdout.String())
+	}
+	report = auditVerifyReport{}
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil || report.Invalid != 2 || report.Problems[0].Line != 3 || report.Problems[1].Line != 4 {
+		t.Fatalf("unexpected report %s", stdout.String())
+	}
+	if code := runAuditVerify([]string{filepath.Join(t.TempDir(), "missing.log")}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2 for a missing file, got %d", code)
+	}
+}
+
+func TestServerComponentBindsOnStart(t *testing.T) {
+	manager := lifecycle.New()
+	server := &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}
+	component := serverComponent(manager, "http", server, nil, nil)
+	if err := component.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := component.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bad := serverComponent(manager, "http", &http.Server{Addr: "256.0.0.1:1"}, nil, nil)
+	if err := bad.Start(context.Background()); err == nil {
+		t.Fatal("expected an invalid address to fail startup")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/gateway"
+)
+
+// runMigrate implements "gateway-api migrate". It prints a JSON report of the
+// schema version and the migrations applied, or pending with -dry-run, and
+// exits 1 when a migration fails, 2 on usage or configuration errors.
+func runMigrate(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dryRun := flags.Bool("dry-run", false, "list pending migrations without applying them")
+	timeout := flags.Duration("timeout", 5*time.Minute, "give up after this long, including waiting for another replica's migration lock")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected arguments: %v\n", flags.Args())
+		return 2
+	}
+	if *timeout <= 0 {
+		fmt.Fprintln(stderr, "-timeout must be positive")
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	report, err := gateway.MigrateStateStorage(ctx, *dryRun)
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+	if err != nil {
+		fmt.Fprintf(stderr, "migrate failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/gateway"
+)
+
+// runReconcileUsage implements "gateway-api reconcile-usage". It prints a JSON
+// report and exits 1 when the export has gaps, 2 on usage or configuration
+// errors.
+func runReconcileUsage(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("reconcile-usage", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	now := time.Now().UTC()
+	fromFlag := flags.String("from", now.Add(-24*time.Hour).Truncate(time.Hour).Format(time.RFC3339), "first hour to check (RFC 3339)")
+	toFlag := flags.String("to", now.Truncate(time.Hour).Format(time.RFC3339), "end of the range, exclusive (RFC 3339)")
+	checkStore := flags.Bool("check-store", false, "compare exported partitions with the usage store")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	from, err := time.Parse(time.RFC3339, *fromFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid -from: %v\n", err)
+		return 2
+	}
+	to, err := time.Parse(time.RFC3339, *toFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid -to: %v\n", err)
+		return 2
+	}
+	if !to.After(from) {
+		fmt.Fprintln(stderr, "-to must be after -from")
+		return 2
+	}
+
+	report, err := gateway.ReconcileUsageExport(ctx, from, to, *checkStore)
+	if err != nil {
+		fmt.Fprintf(stderr, "reconcile failed: %v\n", err)
+		return 2
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/audit"
+)
+
+// rehashEntry is one row of a correlation table: a stored identity hash and
+// the components it was computed from.
+type rehashEntry struct {
+	Hash   string   `json:"hash"`
+	Tenant string   `json:"tenant,omitempty"`
+	Parts  []string `json:"parts"`
+}
+
+type rehashResult struct {
+	Hash     string `json:"hash"`
+	Rehashed string `json:"rehashed"`
+}
+
+// runRehashAudit implements "gateway-api rehash-audit". It reads rehashEntry
+// JSON lines from stdin and writes the current hash of every entry whose
+// stored hash matches the current or previous hashing configuration, so
+// tables keyed by identity hashes can be rewritten during a rotation window.
+// It exits 1 when some entries do not match and 2 on configuration or input
+// errors.
+func runRehashAudit(stdin io.Reader, stdout, stderr io.Writer) int {
+	if err := audit.ValidateConfig(); err != nil {
+		fmt.Fprintf(stderr, "invalid audit configuration: %v\n", err)
+		return 2
+	}
+	logger := audit.Default()
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	encoder := json.NewEncoder(stdout)
+	unmatched := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry rehashEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Hash == "" || len(entry.Parts) == 0 {
+			fmt.Fprintf(stderr, "line %d: expected {\"hash\",\"tenant\",\"parts\"}\n", line)
+			return 2
+		}
+		rehashed, ok := logger.Rehash(entry.Hash, entry.Tenant, entry.Parts...)
+		if !ok {
+			fmt.Fprintf(stderr, "line %d: hash does not match the current or previous configuration\n", line)
+			unmatched++
+			continue
+		}
+		_ = encoder.Encode(rehashResult{Hash: entry.Hash, Rehashed: rehashed})
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(stderr, "read failed: %v\n", err)
+		return 2
+	}
+	if unmatched > 0 {
+		return 1
+	}
+	return 0
+}
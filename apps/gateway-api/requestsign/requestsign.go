@@ -0,0 +1,376 @@
+// Package requestsign signs requests the gateway sends on its own initiative,
+// such as webhook deliveries and mirrored traffic, so receivers can verify
+// that they come from the gateway and were not altered or replayed. Receivers
+// import it for Verifier.
+//
+// A signed request carries four headers: the key ID, a Unix timestamp, a
+// random nonce and the signature. The signature covers the method, host,
+// path and query, timestamp, nonce and a SHA-256 digest of the body, with
+// either HMAC-SHA256 or Ed25519. Verifiers pick the algorithm from their own
+// key configuration, never from the request.
+package requestsign
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of a signed request.
+const (
+	HeaderKeyID     = "X-Gateway-Key-Id"
+	HeaderTimestamp = "X-Gateway-Timestamp"
+	HeaderNonce     = "X-Gateway-Nonce"
+	HeaderSignature = "X-Gateway-Signature"
+)
+
+// Signature algorithms.
+const (
+	AlgorithmHMACSHA256 = "hmac-sha256"
+	AlgorithmEd25519    = "ed25519"
+)
+
+const (
+	// DefaultWindow is how far a request timestamp may be from the
+	// verifier's clock.
+	DefaultWindow = 5 * time.Minute
+	// DefaultMaxBodyBytes bounds the body a Verifier reads.
+	DefaultMaxBodyBytes = 10 << 20
+	// MinHMACKeyBytes is the shortest HMAC key accepted.
+	MinHMACKeyBytes = 32
+
+	canonicalPrefix = "gateway-request-v1"
+	nonceBytes      = 16
+)
+
+// Verification errors.
+var (
+	ErrMissingSignature = errors.New("requestsign: request is not signed")
+	ErrUnknownKey       = errors.New("requestsign: unknown key id")
+	ErrExpired          = errors.New("requestsign: timestamp outside the allowed window")
+	ErrReplayed         = errors.New("requestsign: nonce already used")
+	ErrInvalidSignature = errors.New("requestsign: signature does not match")
+	ErrBodyTooLarge     = errors.New("requestsign: body too large to verify")
+)
+
+// Signer signs outgoing requests with one key.
+type Signer struct {
+	keyID     string
+	algorithm string
+	secret    []byte
+	private   ed25519.PrivateKey
+	now       func() time.Time
+}
+
+// NewHMACSigner signs with HMAC-SHA256 under secret, which must be at least
+// MinHMACKeyBytes long.
+func NewHMACSigner(keyID string, secret []byte) (*Signer, error) {
+	if err := checkKeyID(keyID); err != nil {
+		return nil, err
+	}
+	if len(secret) < MinHMACKeyBytes {
+		return nil, fmt.Errorf("requestsign: hmac key must be at least %d bytes", MinHMACKeyBytes)
+	}
+	return &Signer{keyID: keyID, algorithm: AlgorithmHMACSHA256, secret: bytes.Clone(secret), now: time.Now}, nil
+}
+
+// NewEd25519Signer signs with Ed25519 under key.
+func NewEd25519Signer(keyID string, key ed25519.PrivateKey) (*Signer, error) {
+	if err := checkKeyID(keyID); err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("requestsign: invalid ed25519 private key")
+	}
+	return &Signer{keyID: keyID, algorithm: AlgorithmEd25519, private: key, now: time.Now}, nil
+}
+
+func checkKeyID(keyID string) error {
+	if keyID == "" || len(keyID) > 128 || strings.ContainsFunc(keyID, func(r rune) bool { return r <= ' ' || r > '~' }) {
+		return errors.New("requestsign: key id must be 1 to 128 printable ASCII characters without spaces")
+	}
+	return nil
+}
+
+// KeyID returns the key ID sent with each signature.
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Algorithm returns AlgorithmHMACSHA256 or AlgorithmEd25519.
+func (s *Signer) Algorithm() string {
+	return s.algorithm
+}
+
+// PublicKey returns the Ed25519 public key receivers verify with, or nil for
+// HMAC signers.
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	if s.private == nil {
+		return nil
+	}
+	return s.private.Public().(ed25519.PublicKey)
+}
+
+// Sign sets the signature headers on req. The body is read to be digested
+// and replaced, so req can still be sent.
+func (s *Signer) Sign(req *http.Request) error {
+	body, err := readBody(req, -1)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, nonceBytes)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	nonceText := base64.RawURLEncoding.EncodeToString(nonce)
+	message := canonical(req.Method, requestHost(req), req.URL.RequestURI(), timestamp, nonceText, body)
+
+	var signature []byte
+	switch s.algorithm {
+	case AlgorithmHMACSHA256:
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(message)
+		signature = mac.Sum(nil)
+	case AlgorithmEd25519:
+		signature = ed25519.Sign(s.private, message)
+	}
+	req.Header.Set(HeaderKeyID, s.keyID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonceText)
+	req.Header.Set(HeaderSignature, base64.RawURLEncoding.EncodeToString(signature))
+	return nil
+}
+
+// Transport returns a RoundTripper that signs every request before passing
+// it to base, or http.DefaultTransport when base is nil.
+func (s *Signer) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return signingTransport{signer: s, base: base}
+}
+
+type signingTransport struct {
+	signer *Signer
+	base   http.RoundTripper
+}
+
+func (t signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request.
+	signed := req.Clone(req.Context())
+	if err := t.signer.Sign(signed); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(signed)
+}
+
+// VerificationKey is a key a Verifier accepts.
+type VerificationKey struct {
+	Algorithm string
+	Secret    []byte
+	PublicKey ed25519.PublicKey
+}
+
+// HMACKey returns a VerificationKey for an HMAC-SHA256 signer's secret.
+func HMACKey(secret []byte) VerificationKey {
+	return VerificationKey{Algorithm: AlgorithmHMACSHA256, Secret: secret}
+}
+
+// Ed25519Key returns a VerificationKey for an Ed25519 signer's public key.
+func Ed25519Key(public ed25519.PublicKey) VerificationKey {
+	return VerificationKey{Algorithm: AlgorithmEd25519, PublicKey: public}
+}
+
+// NonceCache remembers nonces until their timestamp leaves the window.
+type NonceCache interface {
+	// Seen records nonce until expires and reports whether it was already
+	// recorded.
+	Seen(ctx context.Context, nonce string, expires time.Time) bool
+}
+
+// Verifier checks signed requests. Keys maps key IDs to keys, so a receiver
+// can accept the old and new key while the gateway's key is rotated.
+type Verifier struct {
+	Keys map[string]VerificationKey
+	// Window is the accepted clock difference; zero means DefaultWindow.
+	Window time.Duration
+	// Nonces rejects a request seen before. Without it, a captured request
+	// can be replayed within the window.
+	Nonces NonceCache
+	// MaxBodyBytes bounds the body read; zero means DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+	// Now returns the current time; nil means time.Now.
+	Now func() time.Time
+}
+
+// Verify checks the signature headers of req. The body is read and replaced,
+// so handlers can still read it.
+func (v *Verifier) Verify(req *http.Request) error {
+	keyID := req.Header.Get(HeaderKeyID)
+	timestamp := req.Header.Get(HeaderTimestamp)
+	nonce := req.Header.Get(HeaderNonce)
+	encoded := req.Header.Get(HeaderSignature)
+	if keyID == "" || timestamp == "" || nonce == "" || encoded == "" {
+		return ErrMissingSignature
+	}
+	key, ok := v.Keys[keyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrExpired
+	}
+	window := v.Window
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	signedAt := time.Unix(seconds, 0)
+	if skew := now().Sub(signedAt); skew > window || skew < -window {
+		return ErrExpired
+	}
+
+	limit := v.MaxBodyBytes
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+	body, err := readBody(req, limit)
+	if err != nil {
+		return err
+	}
+	message := canonical(req.Method, requestHost(req), req.URL.RequestURI(), timestamp, nonce, body)
+	switch key.Algorithm {
+	case AlgorithmHMACSHA256:
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write(message)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+	case AlgorithmEd25519:
+		if len(key.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(key.PublicKey, message, signature) {
+			return ErrInvalidSignature
+		}
+	default:
+		return fmt.Errorf("requestsign: unsupported algorithm %q for key %q", key.Algorithm, keyID)
+	}
+	// Only signed nonces are recorded, so unsigned requests cannot fill the
+	// cache or burn a legitimate nonce.
+	if v.Nonces != nil && v.Nonces.Seen(req.Context(), keyID+":"+nonce, signedAt.Add(window)) {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// Middleware rejects requests that fail Verify with 401 before they reach
+// next.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r); err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, ErrBodyTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MemoryNonceCache is a NonceCache for a single receiver process.
+type MemoryNonceCache struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	now    func() time.Time
+}
+
+// NewMemoryNonceCache returns an empty MemoryNonceCache.
+func NewMemoryNonceCache() *MemoryNonceCache {
+	return &MemoryNonceCache{nonces: make(map[string]time.Time), now: time.Now}
+}
+
+// Seen implements NonceCache. Expired nonces are dropped as new ones arrive.
+func (c *MemoryNonceCache) Seen(_ context.Context, nonce string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if until, ok := c.nonces[nonce]; ok && now.Before(until) {
+		return true
+	}
+	for key, until := range c.nonces {
+		if !now.Before(until) {
+			delete(c.nonces, key)
+		}
+	}
+	c.nonces[nonce] = expires
+	return false
+}
+
+func canonical(method, host, requestURI, timestamp, nonce string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	return []byte(strings.Join([]string{
+		canonicalPrefix,
+		strings.ToUpper(method),
+		strings.ToLower(host),
+		requestURI,
+		timestamp,
+		nonce,
+		hex.EncodeToString(digest[:]),
+	}, "\n"))
+}
+
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+// readBody reads and replaces req.Body. A negative limit reads everything.
+func readBody(req *http.Request, limit int64) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	reader := io.Reader(req.Body)
+	if limit >= 0 {
+		reader = io.LimitReader(req.Body, limit+1)
+	}
+	body, err := io.ReadAll(reader)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if limit >= 0 && int64(len(body)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, nil
+}
@@ -0,0 +1,142 @@
+package requestsign
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newHMACPair(t *testing.T) (*Signer, *Verifier) {
+	t.Helper()
+	secret := []byte(strings.Repeat("k", MinHMACKeyBytes))
+	signer, err := NewHMACSigner("key-1", secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return signer, &Verifier{Keys: map[string]VerificationKey{"key-1": HMACKey(secret)}, Nonces: NewMemoryNonceCache()}
+}
+
+func TestSignAndVerifyThroughTransport(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	hmacSigner, hmacVerifier := newHMACPair(t)
+	edSigner, err := NewEd25519Signer("key-2", private)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !edSigner.PublicKey().Equal(public) {
+		t.Fatal("expected the signer to expose its public key")
+	}
+
+	cases := map[string]struct {
+		signer   *Signer
+		verifier *Verifier
+	}{
+		AlgorithmHMACSHA256: {hmacSigner, hmacVerifier},
+		AlgorithmEd25519:    {edSigner, &Verifier{Keys: map[string]VerificationKey{"key-2": Ed25519Key(public)}}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var received string
+			server := httptest.NewServer(tc.verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				received = string(body)
+			})))
+			defer server.Close()
+
+			client := &http.Client{Transport: tc.signer.Transport(nil)}
+			resp, err := client.Post(server.URL+"/hooks/plan?attempt=1", "application/json", strings.NewReader(`{"event":"plan.completed"}`))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected signed request to verify, got %d", resp.StatusCode)
+			}
+			if received != `{"event":"plan.completed"}` {
+				t.Fatalf("expected the handler to read the body, got %q", received)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	signer, verifier := newHMACPair(t)
+	sign := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "https://receiver.example/hooks?attempt=1", strings.NewReader("payload"))
+		if err := signer.Sign(req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return req
+	}
+
+	cases := map[string]struct {
+		mutate func(*http.Request)
+		want   error
+	}{
+		"body":      {func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader("other")) }, ErrInvalidSignature},
+		"query":     {func(r *http.Request) { r.URL.RawQuery = "attempt=2" }, ErrInvalidSignature},
+		"method":    {func(r *http.Request) { r.Method = http.MethodPut }, ErrInvalidSignature},
+		"host":      {func(r *http.Request) { r.Host = "other.example" }, ErrInvalidSignature},
+		"timestamp": {func(r *http.Request) { r.Header.Set(HeaderTimestamp, "1") }, ErrExpired},
+		"key id":    {func(r *http.Request) { r.Header.Set(HeaderKeyID, "key-9") }, ErrUnknownKey},
+		"unsigned":  {func(r *http.Request) { r.Header.Del(HeaderSignature) }, ErrMissingSignature},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := sign()
+			tc.mutate(req)
+			if err := verifier.Verify(req); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+
+	req := sign()
+	replay := req.Clone(req.Context())
+	if err := verifier.Verify(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	replay.Body, _ = req.GetBody()
+	if err := verifier.Verify(replay); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("expected replay to be rejected, got %v", err)
+	}
+}
+
+func TestVerifyEnforcesWindowAndBodyLimit(t *testing.T) {
+	signer, verifier := newHMACPair(t)
+	signer.now = func() time.Time { return time.Now().Add(-2 * time.Minute) }
+	verifier.Window = time.Minute
+	req := httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader("payload"))
+	_ = signer.Sign(req)
+	if err := verifier.Verify(req); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected stale request to be rejected, got %v", err)
+	}
+
+	signer.now = time.Now
+	verifier.MaxBodyBytes = 4
+	req = httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader("payload"))
+	_ = signer.Sign(req)
+	if err := verifier.Verify(req); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("expected oversized body to be rejected, got %v", err)
+	}
+}
+
+func TestNewSignerRejectsWeakKeys(t *testing.T) {
+	if _, err := NewHMACSigner("key-1", []byte("short")); err == nil {
+		t.Fatal("expected short hmac key to be rejected")
+	}
+	if _, err := NewHMACSigner("key 1", []byte(strings.Repeat("k", MinHMACKeyBytes))); err == nil {
+		t.Fatal("expected key id with spaces to be rejected")
+	}
+	if _, err := NewEd25519Signer("key-1", ed25519.PrivateKey("short")); err == nil {
+		t.Fatal("expected invalid ed25519 key to be rejected")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/gateway"
+)
+
+// routesReport is printed by "gateway-api routes".
+type routesReport struct {
+	// Middleware lists what every public route passes through before its own
+	// policies, outermost first.
+	Middleware []string            `json:"middleware"`
+	Public     []gateway.RouteInfo `json:"public"`
+	Admin      []gateway.RouteInfo `json:"admin,omitempty"`
+}
+
+// runRoutes implements "gateway-api routes". It registers the routes the way
+// "serve" would with the current environment, without listening, and prints
+// them as JSON, or as a table with -table. Admin routes are listed when
+// GATEWAY_ADMIN_ADDR is set.
+func runRoutes(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("routes", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	table := flags.Bool("table", false, "print a table instead of JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	trustedProxyCIDRs := trustedProxyCIDRsFromEnv()
+	trustedNetworks, err := gateway.ParseTrustedProxyCIDRs(trustedProxyCIDRs)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid trusted proxy configuration: %v\n", err)
+		return 2
+	}
+	router, err := buildPublicRouter(trustedProxyCIDRs, allowInsecureStateCookieFromEnv(), time.Now())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	report := routesReport{Middleware: []string{"tracing", "audit"}, Public: router.Routes()}
+	if gateway.SlowRequestsEnabled() {
+		report.Middleware = append(report.Middleware, "slow_requests")
+	}
+	report.Middleware = append(report.Middleware, "security_headers")
+	if gateway.NewGlobalRateLimiter(trustedNetworks).Enabled() {
+		report.Middleware = append(report.Middleware, "rate_limit:http_global")
+	}
+	report.Middleware = append(report.Middleware, "request_canonicalization")
+	if strings.TrimSpace(gateway.GetEnv("GATEWAY_ADMIN_ADDR", "")) != "" {
+		// The token only guards requests, so any value lists the same routes.
+		report.Admin = buildAdminRouter("routes", time.Now()).Routes()
+	}
+
+	if !*table {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(report)
+		return 0
+	}
+	fmt.Fprintf(stdout, "middleware: %s\n\n", strings.Join(report.Middleware, ", "))
+	printRouteTable(stdout, "public", report.Public)
+	if len(report.Admin) > 0 {
+		fmt.Fprintln(stdout)
+		printRouteTable(stdout, "admin", report.Admin)
+	}
+	return 0
+}
+
+func printRouteTable(w io.Writer, listener string, routes []gateway.RouteInfo) {
+	tw := newTableWriter(w)
+	fmt.Fprintf(tw, "%s\tmax body bytes\tpolicies\n", listener)
+	for _, route := range routes {
+		limit := "-"
+		if route.MaxBodyBytes > 0 {
+			limit = fmt.Sprint(route.MaxBodyBytes)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", route.Pattern, limit, strings.Join(route.Policies, ", "))
+	}
+	_ = tw.Flush()
+}
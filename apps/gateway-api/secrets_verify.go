@@ -0,0 +1,22 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/gateway"
+)
+
+// runSecretsVerify implements "gateway-api secrets verify". It loads every
+// secret, including _FILE variants, prints a JSON report without the values
+// and exits 1 when any secret is unusable.
+func runSecretsVerify(stdout io.Writer) int {
+	report := gateway.CheckSecrets()
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+// notifyVerboseLoggingToggle is a no-op where SIGUSR1 does not exist; use
+// the admin API's /admin/loglevel instead.
+func notifyVerboseLoggingToggle() {}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/JudgeZ/AI-Agent-Tool/apps/gateway-api/internal/gateway"
+)
+
+// notifyVerboseLoggingToggle switches every log component to debug, and back
+// to the configured levels, on each SIGUSR1.
+func notifyVerboseLoggingToggle() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			verbose := gateway.ToggleVerboseLogging()
+			// Logged at warn so the change shows at any configured level.
+			slog.Warn("log verbosity toggled", slog.Bool("verbose", verbose))
+		}
+	}()
+}